@@ -0,0 +1,501 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package agent bridges the local TCP connections to the SOCKS5 server that
+// runs in the browser, so local tools can be routed through the SSH
+// connections of Sshwifty (dynamic forwarding). It also listens on the local
+// ports requested by the browser, whose connections are forwarded to the
+// fixed targets (local forwarding).
+//
+// The browser connects to the agent through a websocket. Messages of the
+// websocket are binary frames, each starts with the frame type and the 32
+// bits connection ID, followed by the payload:
+//
+//   - Accept: Agent -> browser, a new local connection is accepted. Payload
+//     is empty for the SOCKS5 connections, or the 32 bits ID of the listener
+//     which accepted the connection
+//   - Data: Both direction, payload is the data of the connection
+//   - Close: Both direction, the connection is closed
+//   - Listen: Browser -> agent, listen on the loopback address given in the
+//     payload, the ID is the ID of the listener chosen by the browser. The
+//     agent replies Listen with the listened address once it's listening
+//   - Unlisten: Both direction, the listener is closed, agent may carry the
+//     reason in the payload
+//
+// Listener IDs and connection IDs are separated.
+package agent
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Frame types
+const (
+	FrameAccept = 0x00
+	FrameData   = 0x01
+	FrameClose  = 0x02
+
+	FrameListen   = 0x03
+	FrameUnlisten = 0x04
+)
+
+// Errors
+var (
+	ErrInvalidFrame = errors.New("invalid frame")
+
+	ErrListenNotLoopback = errors.New(
+		"only loopback addresses can be listened")
+
+	ErrListenDuplicated = errors.New("listener already exists")
+
+	ErrListenTooMany = errors.New("too many listeners")
+)
+
+const (
+	frameHeaderSize = 5
+	readBufSize     = 4096
+	writeTimeout    = 30 * time.Second
+	maxListeners    = 64
+)
+
+// Agent accepts the local connections and relays them to the browser
+type Agent struct {
+	origin   string
+	l        log.Logger
+	upgrader websocket.Upgrader
+	lock     sync.Mutex
+	peer     *peer
+}
+
+// New creates an Agent which only accepts the browser that opened the
+// page from the `origin`, i.e. "https://sshwifty.example.com"
+func New(origin string, l log.Logger) *Agent {
+	return &Agent{
+		origin: origin,
+		l:      l,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  readBufSize,
+			WriteBufferSize: readBufSize,
+			CheckOrigin: func(r *http.Request) bool {
+				return r.Header.Get("Origin") == origin
+			},
+		},
+		lock: sync.Mutex{},
+		peer: nil,
+	}
+}
+
+// ServeHTTP accepts the websocket connection of the browser. Only one browser
+// can be connected at a time, the newer one replaces the older
+func (a *Agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := a.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		a.l.Warning("Unable to accept browser %s: %s", r.RemoteAddr, err)
+
+		return
+	}
+
+	p := newPeer(conn)
+
+	a.lock.Lock()
+	old := a.peer
+	a.peer = p
+	a.lock.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+
+	a.l.Info("Browser %s is connected", r.RemoteAddr)
+
+	rErr := p.serve()
+
+	a.lock.Lock()
+	if a.peer == p {
+		a.peer = nil
+	}
+	a.lock.Unlock()
+
+	p.close()
+
+	a.l.Info("Browser %s is disconnected: %s", r.RemoteAddr, rErr)
+}
+
+// Serve accepts the local connections from the `listener`. Connections are
+// refused when no browser is connected
+func (a *Agent) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		a.lock.Lock()
+		p := a.peer
+		a.lock.Unlock()
+
+		if p == nil {
+			a.l.Debug("Refused %s, no browser is connected",
+				conn.RemoteAddr())
+
+			conn.Close()
+
+			continue
+		}
+
+		go p.relay(conn, nil)
+	}
+}
+
+// Run starts an Agent which accepts the local connections on the
+// `socksAddress` and the browser on the `bridgeAddress`. It only returns when
+// either of them has failed
+func Run(
+	socksAddress string,
+	bridgeAddress string,
+	origin string,
+	l log.Logger,
+) error {
+	a := New(origin, l)
+
+	socks, err := net.Listen("tcp", socksAddress)
+	if err != nil {
+		return err
+	}
+	defer socks.Close()
+
+	bridge, err := net.Listen("tcp", bridgeAddress)
+	if err != nil {
+		return err
+	}
+	defer bridge.Close()
+
+	l.Info("Accepting SOCKS5 clients on %s, and browser from %q on %s",
+		socks.Addr(), origin, bridge.Addr())
+
+	errs := make(chan error, 2)
+
+	go func() {
+		errs <- a.Serve(socks)
+	}()
+
+	go func() {
+		errs <- (&http.Server{
+			Handler:           a,
+			ReadHeaderTimeout: writeTimeout,
+		}).Serve(bridge)
+	}()
+
+	return <-errs
+}
+
+// verifyListenAddress makes sure the `address` is a loopback address, so
+// the forwarded ports can't be reached from other machines
+func verifyListenAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if host == "localhost" {
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return ErrListenNotLoopback
+	}
+
+	return nil
+}
+
+// peer is a connected browser
+type peer struct {
+	conn      *websocket.Conn
+	writeLock sync.Mutex
+	lock      sync.Mutex
+	conns     map[uint32]net.Conn
+	listeners map[uint32]net.Listener
+	nextID    uint32
+	closed    bool
+}
+
+func newPeer(conn *websocket.Conn) *peer {
+	return &peer{
+		conn:      conn,
+		writeLock: sync.Mutex{},
+		lock:      sync.Mutex{},
+		conns:     map[uint32]net.Conn{},
+		listeners: map[uint32]net.Listener{},
+		nextID:    0,
+		closed:    false,
+	}
+}
+
+// send sends a frame to the browser. The payload must be placed into `buf`
+// after the frame header
+func (p *peer) send(frame byte, id uint32, buf []byte) error {
+	buf[0] = frame
+	binary.BigEndian.PutUint32(buf[1:frameHeaderSize], id)
+
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+
+	p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	return p.conn.WriteMessage(websocket.BinaryMessage, buf)
+}
+
+// add registers the `conn` and returns the ID of it. It returns false when
+// the peer is already closed
+func (p *peer) add(conn net.Conn) (uint32, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return 0, false
+	}
+
+	p.nextID++
+	p.conns[p.nextID] = conn
+
+	return p.nextID, true
+}
+
+// remove closes and unregisters the connection `id`. It returns false when
+// it's already removed
+func (p *peer) remove(id uint32) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	conn, ok := p.conns[id]
+	if !ok {
+		return false
+	}
+
+	delete(p.conns, id)
+	conn.Close()
+
+	return true
+}
+
+func (p *peer) get(id uint32) (net.Conn, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	conn, ok := p.conns[id]
+
+	return conn, ok
+}
+
+// relay relays the local `conn` to the browser. The `listener` is the ID of
+// the listener which accepted the `conn`, nil for the SOCKS5 connections
+func (p *peer) relay(conn net.Conn, listener []byte) {
+	id, ok := p.add(conn)
+	if !ok {
+		conn.Close()
+
+		return
+	}
+
+	buf := [frameHeaderSize + readBufSize]byte{}
+	aLen := copy(buf[frameHeaderSize:], listener)
+
+	if p.send(FrameAccept, id, buf[:frameHeaderSize+aLen]) != nil {
+		p.remove(id)
+
+		return
+	}
+
+	for {
+		rLen, rErr := conn.Read(buf[frameHeaderSize:])
+		if rErr != nil {
+			if p.remove(id) {
+				p.send(FrameClose, id, buf[:frameHeaderSize])
+			}
+
+			return
+		}
+
+		if p.send(FrameData, id, buf[:frameHeaderSize+rLen]) != nil {
+			p.remove(id)
+
+			return
+		}
+	}
+}
+
+// serve handles the frames sent by the browser until the websocket is closed
+func (p *peer) serve() error {
+	for {
+		mType, data, err := p.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		if mType != websocket.BinaryMessage || len(data) < frameHeaderSize {
+			return ErrInvalidFrame
+		}
+
+		id := binary.BigEndian.Uint32(data[1:frameHeaderSize])
+
+		switch data[0] {
+		case FrameData:
+			conn, ok := p.get(id)
+			if !ok {
+				continue
+			}
+
+			if _, wErr := conn.Write(data[frameHeaderSize:]); wErr != nil {
+				if p.remove(id) {
+					p.send(FrameClose, id, make([]byte, frameHeaderSize))
+				}
+			}
+
+		case FrameClose:
+			p.remove(id)
+
+		case FrameListen:
+			p.listen(id, string(data[frameHeaderSize:]))
+
+		case FrameUnlisten:
+			p.unlisten(id)
+
+		default:
+			return ErrInvalidFrame
+		}
+	}
+}
+
+// sendUnlisten tells the browser the listener `id` is closed because of `err`
+func (p *peer) sendUnlisten(id uint32, err error) {
+	buf := make([]byte, frameHeaderSize, frameHeaderSize+len(err.Error()))
+
+	p.send(FrameUnlisten, id, append(buf, err.Error()...))
+}
+
+// listen listens on the `address` for the listener `id`, and relays the
+// accepted connections to the browser
+func (p *peer) listen(id uint32, address string) {
+	if err := verifyListenAddress(address); err != nil {
+		p.sendUnlisten(id, err)
+
+		return
+	}
+
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+
+		return
+	}
+
+	if _, ok := p.listeners[id]; ok {
+		p.lock.Unlock()
+		p.sendUnlisten(id, ErrListenDuplicated)
+
+		return
+	}
+
+	if len(p.listeners) >= maxListeners {
+		p.lock.Unlock()
+		p.sendUnlisten(id, ErrListenTooMany)
+
+		return
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		p.lock.Unlock()
+		p.sendUnlisten(id, err)
+
+		return
+	}
+
+	p.listeners[id] = listener
+	p.lock.Unlock()
+
+	listened := append(
+		make([]byte, frameHeaderSize), listener.Addr().String()...)
+
+	if p.send(FrameListen, id, listened) != nil {
+		p.unlisten(id)
+
+		return
+	}
+
+	go func() {
+		accept := make([]byte, 4)
+		binary.BigEndian.PutUint32(accept, id)
+
+		for {
+			conn, aErr := listener.Accept()
+			if aErr != nil {
+				if p.unlisten(id) {
+					p.sendUnlisten(id, aErr)
+				}
+
+				return
+			}
+
+			go p.relay(conn, accept)
+		}
+	}()
+}
+
+// unlisten closes and unregisters the listener `id`. It returns false when
+// it's already removed
+func (p *peer) unlisten(id uint32) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	listener, ok := p.listeners[id]
+	if !ok {
+		return false
+	}
+
+	delete(p.listeners, id)
+	listener.Close()
+
+	return true
+}
+
+// close disconnects the browser and closes all local connections and
+// listeners
+func (p *peer) close() {
+	p.lock.Lock()
+	p.closed = true
+	for id, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, id)
+	}
+	for id, listener := range p.listeners {
+		listener.Close()
+		delete(p.listeners, id)
+	}
+	p.lock.Unlock()
+
+	p.conn.Close()
+}
@@ -0,0 +1,179 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package agent
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/nirui/sshwifty/application/log"
+)
+
+func TestAgent(t *testing.T) {
+	const origin = "https://sshwifty.example.com"
+
+	a := New(origin, log.NewDitch())
+
+	bridge := httptest.NewServer(a)
+	defer bridge.Close()
+
+	socks, lErr := net.Listen("tcp", "127.0.0.1:0")
+	if lErr != nil {
+		t.Error("Failed to listen:", lErr)
+		return
+	}
+	defer socks.Close()
+
+	go a.Serve(socks)
+
+	bridgeURL := "ws" + strings.TrimPrefix(bridge.URL, "http")
+
+	_, _, dErr := websocket.DefaultDialer.Dial(bridgeURL, http.Header{
+		"Origin": []string{"https://evil.example.com"},
+	})
+	if dErr == nil {
+		t.Error("Expecting browser of other origin to be rejected")
+		return
+	}
+
+	browser, _, dErr := websocket.DefaultDialer.Dial(bridgeURL, http.Header{
+		"Origin": []string{origin},
+	})
+	if dErr != nil {
+		t.Error("Failed to connect browser:", dErr)
+		return
+	}
+	defer browser.Close()
+
+	expect := func(frame byte) (uint32, []byte) {
+		_, data, rErr := browser.ReadMessage()
+		if rErr != nil {
+			t.Fatal("Failed to read frame:", rErr)
+		}
+
+		if data[0] != frame {
+			t.Fatalf("Expecting frame %d, got %v", frame, data)
+		}
+
+		return binary.BigEndian.Uint32(data[1:5]), data[5:]
+	}
+
+	// The browser may not be registered right after the dial returns
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		a.lock.Lock()
+		registered := a.peer != nil
+		a.lock.Unlock()
+
+		if registered {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Error("Expecting the browser to be registered")
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	local, cErr := net.Dial("tcp", socks.Addr().String())
+	if cErr != nil {
+		t.Error("Failed to dial agent:", cErr)
+		return
+	}
+	defer local.Close()
+
+	local.Write([]byte("Hello"))
+
+	id, _ := expect(FrameAccept)
+
+	_, payload := expect(FrameData)
+	if string(payload) != "Hello" {
+		t.Errorf("Expecting %q, got %q", "Hello", payload)
+		return
+	}
+
+	frame := append([]byte{FrameData, 0, 0, 0, 0}, "World"...)
+	binary.BigEndian.PutUint32(frame[1:5], id)
+
+	browser.WriteMessage(websocket.BinaryMessage, frame)
+
+	buf := [5]byte{}
+
+	if _, rErr := io.ReadFull(local, buf[:]); rErr != nil {
+		t.Error("Failed to read:", rErr)
+		return
+	}
+
+	if string(buf[:]) != "World" {
+		t.Errorf("Expecting %q, got %q", "World", buf[:])
+		return
+	}
+
+	local.Close()
+
+	if closedID, _ := expect(FrameClose); closedID != id {
+		t.Errorf("Expecting connection %d to be closed, got %d", id, closedID)
+		return
+	}
+
+	listen := func(id uint32, address string) {
+		frame := append([]byte{FrameListen, 0, 0, 0, 0}, address...)
+		binary.BigEndian.PutUint32(frame[1:5], id)
+
+		browser.WriteMessage(websocket.BinaryMessage, frame)
+	}
+
+	listen(7, "0.0.0.0:0")
+
+	if _, reason := expect(FrameUnlisten); string(reason) !=
+		ErrListenNotLoopback.Error() {
+		t.Errorf("Expecting non-loopback address to be refused, got %q",
+			reason)
+		return
+	}
+
+	listen(8, "127.0.0.1:0")
+
+	listenerID, listened := expect(FrameListen)
+	if listenerID != 8 {
+		t.Errorf("Expecting listener 8, got %d", listenerID)
+		return
+	}
+
+	forwarded, cErr := net.Dial("tcp", string(listened))
+	if cErr != nil {
+		t.Error("Failed to dial listener:", cErr)
+		return
+	}
+	defer forwarded.Close()
+
+	if _, accept := expect(FrameAccept); len(accept) != 4 ||
+		binary.BigEndian.Uint32(accept) != 8 {
+		t.Errorf("Expecting connection of listener 8, got %v", accept)
+		return
+	}
+}
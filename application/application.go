@@ -21,15 +21,19 @@ import (
 	"fmt"
 	"io"
 	goLog "log"
+	"net"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/nirui/sshwifty/application/broadcast"
 	"github.com/nirui/sshwifty/application/command"
 	"github.com/nirui/sshwifty/application/configuration"
 	"github.com/nirui/sshwifty/application/log"
 	"github.com/nirui/sshwifty/application/server"
+	"github.com/nirui/sshwifty/application/upgrade"
 )
 
 // ProccessSignaller send signal to the running application
@@ -43,10 +47,24 @@ func DefaultProccessSignallerBuilder() chan os.Signal {
 	return make(chan os.Signal, 1)
 }
 
+// restartSignal asks the application to restart the servers with the
+// configuration loaded again
+type restartSignal struct{}
+
+func (r restartSignal) String() string {
+	return "restart"
+}
+
+func (r restartSignal) Signal() {}
+
 var (
 	screenLineWipper = []byte("\r")
 )
 
+const (
+	upgradeReadyTimeout = 30 * time.Second
+)
+
 // Application contains data required for the application, and yes I don't like
 // to write comments
 type Application struct {
@@ -62,6 +80,230 @@ func New(screen io.Writer, logger log.Logger) Application {
 	}
 }
 
+// prepare applies the provisioned configuration, let the commands alter the
+// presets, and verifies the result
+func (a Application) prepare(
+	c configuration.Configuration,
+	commands command.Commands,
+) (configuration.Configuration, error) {
+	c, err := c.WithProvisioned()
+
+	if err != nil {
+		a.logger.Error("Unable to apply provisioned configuration: %s", err)
+
+		return c, err
+	}
+
+	// Allowing command to alter presets
+	c.Presets, err = commands.Reconfigure(c.Presets)
+
+	if err != nil {
+		a.logger.Error("Unable to reconfigure presets: %s", err)
+
+		return c, err
+	}
+
+	c.Tenants = append([]configuration.Tenant(nil), c.Tenants...)
+
+	for i := range c.Tenants {
+		c.Tenants[i].Presets, err = commands.Reconfigure(c.Tenants[i].Presets)
+
+		if err != nil {
+			a.logger.Error("Unable to reconfigure presets of Tenant %q: %s",
+				c.Tenants[i].Name, err)
+
+			return c, err
+		}
+	}
+
+	// Verify all configuration
+	err = c.Verify()
+
+	if err != nil {
+		a.logger.Error("Configuration was invalid: %s", err)
+
+		return c, err
+	}
+
+	return c, nil
+}
+
+// upgrade hands the listeners of the `servers` over to a new process of the
+// executable. It returns false when the handover has failed, and current
+// process should keep serving
+func (a Application) upgrade(servers []*server.Serving) bool {
+	listeners := make(map[string]*net.TCPListener, len(servers))
+
+	for _, s := range servers {
+		address, l := s.Listener()
+
+		if l == nil {
+			a.logger.Error("Unable to upgrade: Not all servers are listening")
+
+			return false
+		}
+
+		listeners[address] = l
+	}
+
+	a.logger.Info("Upgrading")
+
+	err := upgrade.Start(listeners, upgradeReadyTimeout)
+
+	if err != nil {
+		a.logger.Error("Unable to upgrade: %s", err)
+
+		return false
+	}
+
+	return true
+}
+
+// reload loads the configuration again, and applies it to the running
+// `servers` when it only differs from the `current` in the settings that are
+// reloadable. Otherwise, it returns true so the servers are restarted to
+// apply it. The `current` is kept when the configuration fails to load
+func (a Application) reload(
+	cLoader configuration.Loader,
+	commands command.Commands,
+	current configuration.Configuration,
+	currentCommon configuration.Common,
+	servers []*server.Serving,
+	builders []server.HandlerBuilder,
+) (configuration.Configuration, configuration.Common, bool) {
+	a.logger.Info("Reloading configuration")
+
+	loaderName, c, err := cLoader(a.logger.Context("Configuration"))
+
+	if err != nil {
+		a.logger.Error("\"%s\" loader cannot load configuration, the "+
+			"current one is kept: %s", loaderName, err)
+
+		return current, currentCommon, false
+	}
+
+	c, err = c.WithMounted()
+
+	if err != nil {
+		a.logger.Error("Unable to apply mounted configuration, the current "+
+			"one is kept: %s", err)
+
+		return current, currentCommon, false
+	}
+
+	c, err = c.WithInventory(a.logger.Context("Inventory"))
+
+	if err != nil {
+		a.logger.Error("Unable to apply inventory, the current one is "+
+			"kept: %s", err)
+
+		return current, currentCommon, false
+	}
+
+	c, err = a.prepare(c, commands)
+
+	if err != nil {
+		a.logger.Error("The current configuration is kept")
+
+		return current, currentCommon, false
+	}
+
+	if !c.Reloadable(current) {
+		a.logger.Info("Restarting to apply the configuration")
+
+		return c, currentCommon, true
+	}
+
+	commonCfg, err := c.Reloaded(currentCommon)
+
+	if err != nil {
+		a.logger.Error("Unable to apply the configuration, the current "+
+			"one is kept: %s", err)
+
+		return current, currentCommon, false
+	}
+
+	applies := make([]func(), len(servers))
+
+	for i := range servers {
+		applies[i], err = servers[i].Reload(
+			commonCfg, c.Servers[i], builders[i])
+
+		if err != nil {
+			a.logger.Error("Unable to reload server, the current "+
+				"configuration is kept: %s", err)
+
+			return current, currentCommon, false
+		}
+	}
+
+	commonCfg.Watcher.Start()
+	commonCfg.Warmup.Start()
+
+	for _, apply := range applies {
+		apply()
+	}
+
+	currentCommon.Watcher.Close()
+	currentCommon.Warmup.Close()
+
+	a.logger.Info("Configuration reloaded")
+
+	return c, commonCfg, false
+}
+
+// shutdown stops the `servers` from accepting, refuses the new streams of
+// the connected `sessions` and tells their users about it, then waits for
+// them to close until the `timeout` has passed
+func (a Application) shutdown(
+	servers []*server.Serving,
+	sessions *upgrade.Sessions,
+	notices *broadcast.Broadcaster,
+	timeout time.Duration,
+) {
+	sessions.Drain()
+
+	for i := len(servers); i > 0; i-- {
+		servers[i-1].Close()
+	}
+
+	notified := notices.Send(fmt.Sprintf("The server is shutting down in "+
+		"%s, please save your work and disconnect", timeout))
+
+	a.logger.Info("Shutting down, %d client(s) are notified", notified)
+
+	a.drain(sessions, timeout)
+}
+
+// drain waits for the `sessions` to close, until the `timeout` (0 to wait
+// indefinitely) or the process is asked to stop
+func (a Application) drain(sessions *upgrade.Sessions, timeout time.Duration) {
+	stopNotify := make(chan os.Signal, 1)
+	signal.Notify(stopNotify, os.Kill, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stopNotify)
+
+	var timeoutNotify <-chan time.Time
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		timeoutNotify = timer.C
+	}
+
+	a.logger.Info("Waiting for %d session(s) to close", sessions.Count())
+
+	select {
+	case <-sessions.Idle():
+		a.logger.Info("All sessions are closed")
+	case <-timeoutNotify:
+		a.logger.Warning("Closing %d remaining session(s) as the drain "+
+			"has timed out", sessions.Count())
+	case <-stopNotify:
+		a.screen.Write(screenLineWipper)
+	}
+}
+
 // Run execute the application. It will return when the application is finished
 // running
 func (a Application) run(
@@ -69,6 +311,8 @@ func (a Application) run(
 	closeSigBuilder ProccessSignallerBuilder,
 	commands command.Commands,
 	handlerBuilder server.HandlerBuilderBuilder,
+	inherited *upgrade.Inherited,
+	sessions *upgrade.Sessions,
 ) (bool, error) {
 	var err error
 
@@ -81,27 +325,33 @@ func (a Application) run(
 		return false, cErr
 	}
 
-	// Allowing command to alter presets
-	c.Presets, err = commands.Reconfigure(c.Presets)
+	c, err = c.WithMounted()
 
 	if err != nil {
-		a.logger.Error("Unable to reconfigure presets: %s", err)
+		a.logger.Error("Unable to apply mounted configuration: %s", err)
 
 		return false, err
 	}
 
-	// Verify all configuration
-	err = c.Verify()
+	c, err = c.WithInventory(a.logger.Context("Inventory"))
 
 	if err != nil {
-		a.logger.Error("Configuration was invalid: %s", err)
+		a.logger.Error("Unable to apply inventory: %s", err)
 
 		return false, err
 	}
 
+	c, err = a.prepare(c, commands)
+
+	if err != nil {
+		return false, err
+	}
+
 	closeNotify := closeSigBuilder()
 	closeNotifyDisableLock := sync.Mutex{}
-	signal.Notify(closeNotify, os.Kill, os.Interrupt, syscall.SIGHUP)
+	signal.Notify(closeNotify, append([]os.Signal{
+		os.Kill, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP},
+		upgrade.Signals...)...)
 	defer func() {
 		closeNotifyDisableLock.Lock()
 		defer closeNotifyDisableLock.Unlock()
@@ -113,8 +363,130 @@ func (a Application) run(
 		closeNotify = nil
 	}()
 
+	// Common settings are shared by all servers, so the states inside (i.e.
+	// traffic usage) are shared as well
+	commonCfg, err := c.Common()
+
+	if err != nil {
+		a.logger.Error("Unable to apply the configuration: %s", err)
+
+		return false, err
+	}
+
+	// Reload restarts the servers with the configuration loaded again. It's
+	// called by the watchers of the configuration (i.e. the Mounted), which
+	// stop watching once they have called it, so the servers must be
+	// restarted to start them again, unlike SIGHUP
+	commonCfg.Reload = func() {
+		closeNotifyDisableLock.Lock()
+		defer closeNotifyDisableLock.Unlock()
+		if closeNotify == nil {
+			return
+		}
+		select {
+		case closeNotify <- restartSignal{}:
+		default:
+		}
+	}
+
+	// Sessions are counted across restarts, as the ones started before a
+	// restart are still being served
+	commonCfg.Sessions = sessions
+
+	commonCfg.Mounted.Start(
+		a.logger.Context("Mounted"),
+		func(c configuration.Configuration) error {
+			// The watcher logs the error by itself
+			_, err := New(a.screen, log.NewDitch()).prepare(c, commands)
+
+			return err
+		},
+		commonCfg.Reload,
+	)
+	defer commonCfg.Mounted.Close()
+
+	commonCfg.Replica.Start(
+		a.logger.Context("Replica"),
+		func(c configuration.Configuration) error {
+			// The provisioned state is already applied to `c`
+			presets, rErr := commands.Reconfigure(c.Presets)
+			if rErr != nil {
+				return rErr
+			}
+
+			c.Presets = presets
+
+			c.Tenants = append([]configuration.Tenant(nil), c.Tenants...)
+
+			for i := range c.Tenants {
+				presets, rErr = commands.Reconfigure(c.Tenants[i].Presets)
+				if rErr != nil {
+					return rErr
+				}
+
+				c.Tenants[i].Presets = presets
+			}
+
+			return c.Verify()
+		},
+		commonCfg.Reload,
+	)
+	defer commonCfg.Replica.Close()
+
+	// Changes of the Inventory and the remote configuration are applied by
+	// reloading, the same way as SIGHUP does. Unlike the Mounted, they keep
+	// watching, so the servers only restart when the change requires it
+	hangup := func() {
+		closeNotifyDisableLock.Lock()
+		defer closeNotifyDisableLock.Unlock()
+		if closeNotify == nil {
+			return
+		}
+		select {
+		case closeNotify <- syscall.SIGHUP:
+		default:
+		}
+	}
+
+	commonCfg.Inventory.Start(a.logger.Context("Inventory"), hangup)
+	defer commonCfg.Inventory.Close()
+
+	commonCfg.Remote.Start(a.logger.Context("Remote"), hangup)
+	defer commonCfg.Remote.Close()
+
+	// The Watcher and the Warmup are replaced when the configuration is
+	// reloaded, close the ones in use by then
+	commonCfg.Watcher.Start()
+	defer func() { commonCfg.Watcher.Close() }()
+
+	commonCfg.Warmup.Start()
+	defer func() { commonCfg.Warmup.Close() }()
+
+	commonCfg.Audit.Start(a.logger.Context("Audit"))
+	defer commonCfg.Audit.Close()
+
+	commonCfg.Tracer.Start(a.logger.Context("Tracing"))
+	defer commonCfg.Tracer.Close()
+
+	commonCfg.Recorder.Start(a.logger.Context("Recording"))
+	defer commonCfg.Recorder.Close()
+
+	for _, t := range commonCfg.Tenants {
+		t.Watcher.Start()
+		defer t.Watcher.Close()
+
+		t.Warmup.Start()
+		defer t.Warmup.Close()
+
+		t.Recorder.Start(a.logger.Context("Recording (%s)", t.Name))
+		defer t.Recorder.Close()
+	}
+
+	defer commonCfg.LoginLimiter.Close()
+
 	servers := make([]*server.Serving, 0, len(c.Servers))
-	s := server.New(a.logger)
+	builders := make([]server.HandlerBuilder, 0, len(c.Servers))
+	s := server.New(a.logger, inherited)
 
 	defer func() {
 		for i := len(servers); i > 0; i-- {
@@ -124,7 +496,8 @@ func (a Application) run(
 	}()
 
 	for _, ss := range c.Servers {
-		newServer := s.Serve(c.Common(), ss, func(e error) {
+		builder := handlerBuilder(commands)
+		newServer := s.Serve(commonCfg, ss, func(e error) {
 			closeNotifyDisableLock.Lock()
 			defer closeNotifyDisableLock.Unlock()
 			if closeNotify == nil {
@@ -134,12 +507,60 @@ func (a Application) run(
 			signal.Stop(closeNotify)
 			close(closeNotify)
 			closeNotify = nil
-		}, handlerBuilder(commands))
+		}, builder)
 		servers = append(servers, newServer)
+		builders = append(builders, builder)
+	}
+
+	// Tell the old process to stop accepting once all servers are listening
+	// when current process is started by an upgrade
+	listeningWait := make(chan struct{})
+	defer close(listeningWait)
+
+	go func() {
+		for _, ss := range servers {
+			if !ss.Listening(listeningWait) {
+				return
+			}
+		}
+
+		inherited.Ready()
+	}()
+
+	sig := <-closeNotify
+
+	for upgrade.Requested(sig) || sig == syscall.SIGHUP {
+		if sig == syscall.SIGHUP {
+			reloaded, reloadedCommon, restart := a.reload(
+				cLoader, commands, c, commonCfg, servers, builders)
+			if restart {
+				return true, nil
+			}
+
+			c, commonCfg = reloaded, reloadedCommon
+		} else if a.upgrade(servers) {
+			closeNotifyDisableLock.Lock()
+			signal.Stop(closeNotify)
+			close(closeNotify)
+			closeNotify = nil
+			closeNotifyDisableLock.Unlock()
+
+			// Stop accepting, the new process accepts from now on
+			for i := len(servers); i > 0; i-- {
+				servers[i-1].Close()
+			}
+
+			a.logger.Info("Upgraded")
+			a.drain(sessions, c.UpgradeDrainTimeout)
+
+			return false, nil
+		}
+
+		sig = <-closeNotify
 	}
 
-	switch <-closeNotify {
-	case syscall.SIGHUP:
+	switch sig {
+	case restartSignal{}:
 		return true, nil
 	case syscall.SIGTERM:
 		fallthrough
@@ -147,6 +568,19 @@ func (a Application) run(
 		fallthrough
 	case os.Interrupt:
 		a.screen.Write(screenLineWipper)
+
+		if c.ShutdownDrainTimeout > 0 {
+			closeNotifyDisableLock.Lock()
+			signal.Stop(closeNotify)
+			close(closeNotify)
+			closeNotify = nil
+			closeNotifyDisableLock.Unlock()
+
+			a.shutdown(
+				servers, sessions, commonCfg.Broadcaster,
+				c.ShutdownDrainTimeout)
+		}
+
 		return false, nil
 	default:
 		closeNotifyDisableLock.Lock()
@@ -171,9 +605,18 @@ func (a Application) Run(
 	a.logger.Info("Initializing")
 	defer a.logger.Info("Closed")
 
+	inherited := upgrade.Inherit()
+	sessions := upgrade.NewSessions()
+
 	for {
 		restart, runErr := a.run(
-			cLoader, closeSigBuilder, commands, handlerBuilder)
+			cLoader,
+			closeSigBuilder,
+			commands,
+			handlerBuilder,
+			inherited,
+			sessions,
+		)
 
 		if runErr != nil {
 			a.logger.Error("Unable to start due to error: %s", runErr)
@@ -0,0 +1,354 @@
+//go:build !(windows || plan9)
+
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package application
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/commands"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/server"
+	"github.com/nirui/sshwifty/application/upgrade"
+)
+
+type testLogs struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (t *testLogs) Write(b []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.buf.Write(b)
+}
+
+func (t *testLogs) wait(tt *testing.T, s string) {
+	for i := 0; i < 100; i++ {
+		t.lock.Lock()
+		found := strings.Contains(t.buf.String(), s)
+		t.lock.Unlock()
+
+		if found {
+			return
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	tt.Fatalf("Expecting %q to be logged, got:\n%s", s, t.buf.String())
+}
+
+func testHandlerBuilder(command.Commands) server.HandlerBuilder {
+	return func(
+		configuration.Common, configuration.Server, log.Logger,
+	) http.Handler {
+		return http.NotFoundHandler()
+	}
+}
+
+func TestRunDrainsSessionsOnSIGTERM(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to pick a port:", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	logs := &testLogs{}
+	sessions := upgrade.NewSessions()
+	end := sessions.Begin()
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := New(io.Discard, log.NewWriter("Test", logs)).run(
+			configuration.Direct(configuration.Configuration{
+				Servers: []configuration.Server{configuration.Server{
+					ListenInterface: "127.0.0.1",
+					ListenPort:      uint16(port),
+				}.WithDefault()},
+				ShutdownDrainTimeout: 10 * time.Second,
+			}),
+			DefaultProccessSignallerBuilder,
+			commands.New(),
+			testHandlerBuilder,
+			upgrade.Inherit(),
+			sessions,
+		)
+
+		done <- err
+	}()
+
+	logs.wait(t, "Serving")
+
+	// Go terminates the process at once on SIGTERM unless it's notified
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal("Failed to send SIGTERM:", err)
+	}
+
+	logs.wait(t, "Shutting down")
+	logs.wait(t, "Waiting for 1 session(s) to close")
+
+	// Stopped accepting, but the connected session is still being served
+	if _, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port)); err == nil {
+		t.Error("Expecting the server to stop accepting")
+	}
+
+	select {
+	case <-done:
+		t.Fatal("Expecting run to wait for the session to close")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	end()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error("Unexpected error:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expecting run to return once the session is closed")
+	}
+
+	logs.wait(t, "All sessions are closed")
+}
+
+const testHandoffPortEnv = "SSHWIFTY_TEST_HANDOFF_PORT"
+
+// TestHandoffProcess is the Sshwifty process started by TestReusePortHandoff
+func TestHandoffProcess(t *testing.T) {
+	port, _ := strconv.ParseUint(os.Getenv(testHandoffPortEnv), 10, 16)
+	if port <= 0 {
+		t.Skip("Only started by TestReusePortHandoff")
+	}
+
+	err := New(io.Discard, log.NewDitch()).Run(
+		configuration.Direct(configuration.Configuration{
+			Servers: []configuration.Server{configuration.Server{
+				ListenInterface: "127.0.0.1",
+				ListenPort:      uint16(port),
+				ReusePort:       true,
+			}.WithDefault()},
+			ShutdownDrainTimeout: 30 * time.Second,
+		}),
+		DefaultProccessSignallerBuilder,
+		commands.New(),
+		func(command.Commands) server.HandlerBuilder {
+			return func(
+				commonCfg configuration.Common,
+				_ configuration.Server,
+				_ log.Logger,
+			) http.Handler {
+				return testHandoffHandler{sessions: commonCfg.Sessions}
+			}
+		},
+	)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
+
+// testHandoffHandler tells which process served the request. Requests to
+// "/session" are held as a session until the client goes away
+type testHandoffHandler struct {
+	sessions *upgrade.Sessions
+}
+
+func (h testHandoffHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/session" {
+		defer h.sessions.Begin()()
+	}
+
+	fmt.Fprintln(w, os.Getpid())
+	w.(http.Flusher).Flush()
+
+	if r.URL.Path == "/session" {
+		<-r.Context().Done()
+	}
+}
+
+func testHandoffStart(t *testing.T, port int) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHandoffProcess$")
+	cmd.Env = append(os.Environ(),
+		testHandoffPortEnv+"="+strconv.Itoa(port))
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal("Failed to start Sshwifty:", err)
+	}
+
+	return cmd
+}
+
+// testHandoffServedBy returns the PID of the process which served the request
+func testHandoffServedBy(port int) (int, error) {
+	client := http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+		Timeout:   time.Second,
+	}
+
+	rsp, err := client.Get("http://127.0.0.1:" + strconv.Itoa(port) + "/")
+	if err != nil {
+		return 0, err
+	}
+	defer rsp.Body.Close()
+
+	b, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// testHandoffWaitServing waits until the request is served by `pid`
+func testHandoffWaitServing(t *testing.T, port int, pid int) {
+	for i := 0; i < 200; i++ {
+		if servedBy, err := testHandoffServedBy(port); err == nil &&
+			servedBy == pid {
+			return
+		}
+
+		time.Sleep(25 * time.Millisecond)
+	}
+
+	t.Fatalf("Expecting process %d to be serving", pid)
+}
+
+// testHandoffWaitHandedOff waits until the listener of the old process is
+// closed, after which every request is served by the process of `pid`.
+// Requests queued by the closing listener are reset, so they're retried
+func testHandoffWaitHandedOff(t *testing.T, port int, pid int) {
+	served := 0
+
+	for i := 0; i < 400 && served < 20; i++ {
+		servedBy, err := testHandoffServedBy(port)
+		if err == nil && servedBy == pid {
+			served++
+
+			continue
+		}
+
+		served = 0
+
+		time.Sleep(25 * time.Millisecond)
+	}
+
+	if served < 20 {
+		t.Fatalf("Expecting process %d to be the only one serving", pid)
+	}
+}
+
+// TestReusePortHandoff follows the upgrade documented for ReusePort: start
+// the new process, then stop the old one with SIGTERM
+func TestReusePortHandoff(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to pick a port:", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	old := testHandoffStart(t, port)
+	defer old.Process.Kill()
+
+	testHandoffWaitServing(t, port, old.Process.Pid)
+
+	session, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal("Failed to connect:", err)
+	}
+	defer session.Close()
+
+	fmt.Fprintf(session, "GET /session HTTP/1.1\r\nHost: test\r\n\r\n")
+
+	// Wait for the session to begin before starting the new process, so
+	// it's served by the old one
+	sessionReader := bufio.NewReader(session)
+	for {
+		line, err := sessionReader.ReadString('\n')
+		if err != nil {
+			t.Fatal("Failed to begin the session:", err)
+		}
+
+		if strings.TrimSpace(line) == strconv.Itoa(old.Process.Pid) {
+			break
+		}
+	}
+
+	upgraded := testHandoffStart(t, port)
+	defer upgraded.Process.Kill()
+
+	testHandoffWaitServing(t, port, upgraded.Process.Pid)
+
+	oldExited := make(chan error, 1)
+	go func() { oldExited <- old.Wait() }()
+
+	if err := old.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal("Failed to send SIGTERM:", err)
+	}
+
+	// Only the new process accepts from now on
+	testHandoffWaitHandedOff(t, port, upgraded.Process.Pid)
+
+	select {
+	case err := <-oldExited:
+		t.Fatal("Expecting the old process to drain the session, but it "+
+			"has exited:", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	session.Close()
+
+	select {
+	case err := <-oldExited:
+		if err != nil {
+			t.Error("Expecting the old process to exit normally, got", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expecting the old process to exit once the session is " +
+			"closed")
+	}
+
+	if err := upgraded.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal("Failed to send SIGTERM:", err)
+	}
+
+	if err := upgraded.Wait(); err != nil {
+		t.Error("Expecting the new process to exit normally, got", err)
+	}
+}
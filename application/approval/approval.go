@@ -0,0 +1,146 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package approval requests push approvals from the user through an
+// external provider before a login is completed
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors
+var (
+	ErrDenied = errors.New(
+		"push approval was denied")
+
+	ErrTimeout = errors.New(
+		"push approval has timed out")
+
+	ErrUnsupportedProvider = errors.New(
+		"unsupported push approval provider")
+)
+
+// Request contains the details of a login that is waiting to be approved
+type Request struct {
+	// ID of the request, unique to each login attempt
+	ID string `json:"id"`
+
+	// Identity of the user whom the push is sent to
+	User string `json:"user"`
+
+	// Type of the remote, i.e. "SSH"
+	Type string `json:"type"`
+
+	// Address of the remote
+	Remote string `json:"remote"`
+
+	// IP address of the client
+	Client string `json:"client"`
+}
+
+// Provider sends a push to the user and waits for the answer. It returns
+// nil when the request is approved, ErrDenied when it's denied, or other
+// errors when the answer can't be retrieved. Provider must give up once the
+// `ctx` is done
+type Provider interface {
+	Name() string
+	Request(ctx context.Context, r Request) error
+}
+
+// Approver requests approvals from a Provider within a time limit
+type Approver struct {
+	provider Provider
+	timeout  time.Duration
+}
+
+// New creates a new Approver
+func New(provider Provider, timeout time.Duration) *Approver {
+	return &Approver{
+		provider: provider,
+		timeout:  timeout,
+	}
+}
+
+// Enabled returns whether or not the approvals are required
+func (a *Approver) Enabled() bool {
+	return a != nil && a.provider != nil
+}
+
+// Name returns the name of the Provider
+func (a *Approver) Name() string {
+	if !a.Enabled() {
+		return ""
+	}
+
+	return a.provider.Name()
+}
+
+// Timeout returns how long the Approver waits for an answer
+func (a *Approver) Timeout() time.Duration {
+	if !a.Enabled() {
+		return 0
+	}
+
+	return a.timeout
+}
+
+// Approve requests an approval for `r`, and returns nil when it's granted.
+// ErrDenied is returned when the user denied the request, and ErrTimeout is
+// returned when the user didn't answer in time. Requests are always approved
+// when the Approver is not enabled
+func (a *Approver) Approve(ctx context.Context, r Request) error {
+	if !a.Enabled() {
+		return nil
+	}
+
+	approveCtx, approveCtxCancel := context.WithTimeout(ctx, a.timeout)
+	defer approveCtxCancel()
+
+	err := a.provider.Request(approveCtx, r)
+	if err == nil || errors.Is(err, ErrDenied) {
+		return err
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if errors.Is(approveCtx.Err(), context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+
+	return fmt.Errorf("unable to request push approval from %s: %s",
+		a.provider.Name(), err)
+}
+
+// wait waits for `d` or until the `ctx` is done
+func wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,194 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testWebhookServer(
+	t *testing.T,
+	secret string,
+	answer func(n int, r Request) string,
+) *httptest.Server {
+	calls := int32(0)
+
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Error("Failed to read the request body:", err)
+				return
+			}
+
+			expected := ""
+			if len(secret) > 0 {
+				expected = Sign(secret, body)
+			}
+			if sig := req.Header.Get(WebhookSignatureHeader); sig != expected {
+				t.Errorf("Expecting signature %q, got %q", expected, sig)
+			}
+
+			r := Request{}
+			if err := json.Unmarshal(body, &r); err != nil {
+				t.Error("Failed to decode the request:", err)
+				return
+			}
+
+			result := answer(int(atomic.AddInt32(&calls, 1)), r)
+			if len(result) <= 0 {
+				<-req.Context().Done()
+				return
+			}
+
+			json.NewEncoder(w).Encode(webhookResponse{Result: result})
+		}))
+}
+
+func TestApproverWebhook(t *testing.T) {
+	s := testWebhookServer(t, "secret", func(n int, r Request) string {
+		if n < 2 {
+			return WebhookPending
+		}
+		if r.User == "alice" {
+			return WebhookAllow
+		}
+		return WebhookDeny
+	})
+	defer s.Close()
+
+	a := New(NewWebhook(s.URL, "secret"), 10*time.Second)
+
+	err := a.Approve(context.Background(), Request{User: "alice"})
+	if err != nil {
+		t.Error("Expecting the request to be approved, got:", err)
+	}
+
+	err = a.Approve(context.Background(), Request{User: "bob"})
+	if !errors.Is(err, ErrDenied) {
+		t.Errorf("Expecting %q, got %v", ErrDenied, err)
+	}
+}
+
+func TestApproverTimeout(t *testing.T) {
+	s := testWebhookServer(t, "", func(n int, r Request) string {
+		return ""
+	})
+	defer s.Close()
+
+	a := New(NewWebhook(s.URL, ""), 100*time.Millisecond)
+
+	err := a.Approve(context.Background(), Request{User: "alice"})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expecting %q, got %v", ErrTimeout, err)
+	}
+}
+
+func TestApproverDisabled(t *testing.T) {
+	var a *Approver
+
+	if a.Enabled() {
+		t.Error("Expecting a nil Approver to be disabled")
+	}
+
+	if err := a.Approve(context.Background(), Request{}); err != nil {
+		t.Error("Expecting a disabled Approver to approve, got:", err)
+	}
+}
+
+func TestDuo(t *testing.T) {
+	var d *Duo
+	statusCalls := int32(0)
+
+	s := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			params := ""
+			if req.Method == http.MethodGet {
+				params = req.URL.RawQuery
+			} else {
+				b, _ := io.ReadAll(req.Body)
+				params = string(b)
+			}
+
+			auth := d.sign(
+				req.Header.Get("Date"), req.Method, req.URL.Path, params)
+			if req.Header.Get("Authorization") != auth {
+				t.Errorf("Invalid signature for %s", req.URL.Path)
+			}
+
+			switch req.URL.Path {
+			case duoAuthPath:
+				if !strings.Contains(params, "username=alice") ||
+					!strings.Contains(params, "factor=push") {
+					t.Errorf("Unexpected auth parameters: %s", params)
+				}
+
+				w.Write([]byte(
+					`{"stat":"OK","response":{"txid":"tx1"}}`))
+
+			case duoAuthStatusPath:
+				if atomic.AddInt32(&statusCalls, 1) < 2 {
+					w.Write([]byte(
+						`{"stat":"OK","response":{"result":"waiting"}}`))
+					return
+				}
+
+				w.Write([]byte(
+					`{"stat":"OK","response":{"result":"allow"}}`))
+
+			default:
+				w.Write([]byte(
+					`{"stat":"FAIL","code":40401,"message":"Not found"}`))
+			}
+		}))
+	defer s.Close()
+
+	d = NewDuo(strings.TrimPrefix(s.URL, "http://"), "DIKEY", "skey")
+	d.scheme = "http"
+
+	a := New(d, 10*time.Second)
+
+	err := a.Approve(context.Background(), Request{
+		User:   "alice",
+		Client: "127.0.0.1",
+		Remote: "localhost:22",
+		Type:   "SSH",
+	})
+	if err != nil {
+		t.Error("Expecting the request to be approved, got:", err)
+	}
+
+	if statusCalls != 2 {
+		t.Errorf("Expecting 2 status checks, got %d", statusCalls)
+	}
+}
+
+func TestDuoEscape(t *testing.T) {
+	if e := duoEscape("a b~c+d"); e != "a%20b~c%2Bd" {
+		t.Errorf("Unexpected escaped result %q", e)
+	}
+}
@@ -0,0 +1,243 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package approval
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	duoAuthPath          = "/auth/v2/auth"
+	duoAuthStatusPath    = "/auth/v2/auth_status"
+	duoPollRetryInterval = time.Second
+	duoMaxResponseSize   = 64 * 1024
+)
+
+// Errors
+var (
+	ErrDuoInvalidResponse = errors.New(
+		"invalid response from Duo")
+)
+
+// Duo sends pushes through the Duo Auth API
+type Duo struct {
+	host           string
+	integrationKey string
+	secretKey      string
+	client         *http.Client
+	scheme         string
+	now            func() time.Time
+}
+
+// duoResponse is the common envelope of the Duo Auth API responses
+type duoResponse struct {
+	Stat     string          `json:"stat"`
+	Code     int             `json:"code"`
+	Message  string          `json:"message"`
+	Response json.RawMessage `json:"response"`
+}
+
+// duoAuthResult is the result of an authentication transaction
+type duoAuthResult struct {
+	TxID      string `json:"txid"`
+	Result    string `json:"result"`
+	Status    string `json:"status"`
+	StatusMsg string `json:"status_msg"`
+}
+
+// NewDuo creates a Duo Provider which talks to the API `host` (i.e.
+// "api-xxxxxxxx.duosecurity.com") with the given keys
+func NewDuo(host, integrationKey, secretKey string) *Duo {
+	return &Duo{
+		host:           strings.ToLower(host),
+		integrationKey: integrationKey,
+		secretKey:      secretKey,
+		client:         &http.Client{},
+		scheme:         "https",
+		now:            time.Now,
+	}
+}
+
+// Name implements Provider
+func (d *Duo) Name() string {
+	return "Duo"
+}
+
+// duoEscape escapes `s` in the way required by the request signature
+func duoEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// canonParams returns the canonical form of the `params`
+func (d *Duo) canonParams(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range params[k] {
+			pairs = append(pairs, duoEscape(k)+"="+duoEscape(v))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// sign returns the value of the Authorization header of a request
+func (d *Duo) sign(date, method, path, params string) string {
+	canon := strings.Join([]string{
+		date, strings.ToUpper(method), d.host, path, params,
+	}, "\n")
+
+	mac := hmac.New(sha1.New, []byte(d.secretKey))
+	mac.Write([]byte(canon))
+
+	return "Basic " + base64.StdEncoding.EncodeToString(
+		[]byte(d.integrationKey+":"+hex.EncodeToString(mac.Sum(nil))))
+}
+
+// call calls the API at `path` and decodes the response into `result`
+func (d *Duo) call(
+	ctx context.Context,
+	method string,
+	path string,
+	params url.Values,
+	result interface{},
+) error {
+	date := d.now().UTC().Format(time.RFC1123Z)
+	canon := d.canonParams(params)
+
+	u := d.scheme + "://" + d.host + path
+
+	var body io.Reader
+	if method == http.MethodGet {
+		u += "?" + canon
+	} else {
+		body = strings.NewReader(canon)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Date", date)
+	req.Header.Set("Authorization", d.sign(date, method, path, canon))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	r := duoResponse{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, duoMaxResponseSize)).
+		Decode(&r)
+	if err != nil {
+		return fmt.Errorf("%s: %s", ErrDuoInvalidResponse, err)
+	}
+
+	if r.Stat != "OK" {
+		return fmt.Errorf("Duo returned error %d: %s", r.Code, r.Message)
+	}
+
+	err = json.Unmarshal(r.Response, result)
+	if err != nil {
+		return fmt.Errorf("%s: %s", ErrDuoInvalidResponse, err)
+	}
+
+	return nil
+}
+
+// Request implements Provider
+func (d *Duo) Request(ctx context.Context, r Request) error {
+	result := duoAuthResult{}
+
+	params := url.Values{
+		"username": {r.User},
+		"factor":   {"push"},
+		"device":   {"auto"},
+		"async":    {"1"},
+		"type":     {"Sshwifty login"},
+		"pushinfo": {url.Values{
+			"Remote": {r.Remote},
+			"Type":   {r.Type},
+		}.Encode()},
+	}
+	if net.ParseIP(r.Client) != nil {
+		params.Set("ipaddr", r.Client)
+	}
+
+	err := d.call(ctx, http.MethodPost, duoAuthPath, params, &result)
+	if err != nil {
+		return err
+	}
+
+	if len(result.TxID) <= 0 {
+		return ErrDuoInvalidResponse
+	}
+
+	for {
+		err = d.call(ctx, http.MethodGet, duoAuthStatusPath, url.Values{
+			"txid": {result.TxID},
+		}, &result)
+		if err != nil {
+			return err
+		}
+
+		switch result.Result {
+		case "allow":
+			return nil
+
+		case "deny":
+			return ErrDenied
+
+		case "waiting":
+			// auth_status returns once the status is changed, so the retry
+			// interval here only keeps us from hammering the API when it
+			// doesn't
+			if err := wait(ctx, duoPollRetryInterval); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("%s: unknown result %q",
+				ErrDuoInvalidResponse, result.Result)
+		}
+	}
+}
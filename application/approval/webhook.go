@@ -0,0 +1,159 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package approval
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader carries the HMAC-SHA256 signature of the request
+	// body, signed with the secret of the webhook
+	WebhookSignatureHeader = "X-Sshwifty-Signature"
+
+	webhookPollInterval   = time.Second
+	webhookMaxResponseLen = 4 * 1024
+)
+
+// Results of a webhook request
+const (
+	WebhookAllow   = "allow"
+	WebhookDeny    = "deny"
+	WebhookPending = "pending"
+)
+
+// Errors
+var (
+	ErrWebhookInvalidResponse = errors.New(
+		"invalid response from the webhook")
+)
+
+// webhookResponse is the answer of the webhook
+type webhookResponse struct {
+	Result string `json:"result"`
+}
+
+// Webhook sends the approval requests to a generic webhook.
+//
+// The Request is POSTed as JSON, and the webhook must answer with
+// {"result": "allow"} or {"result": "deny"}. It can also answer with
+// {"result": "pending"} if the decision is not made yet, in which case the
+// same Request will be sent again after a short while
+type Webhook struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewWebhook creates a Webhook Provider which sends requests to `url`.
+// Requests are signed with the `secret` when it's not empty
+func NewWebhook(url string, secret string) *Webhook {
+	return &Webhook{
+		url:    url,
+		secret: secret,
+		client: &http.Client{},
+	}
+}
+
+// Name implements Provider
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// Sign returns the signature of the request `body` signed with `secret`
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send sends the request `body` once and returns the result
+func (w *Webhook) send(ctx context.Context, body []byte) (string, error) {
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, Sign(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	r := webhookResponse{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, webhookMaxResponseLen)).
+		Decode(&r)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", ErrWebhookInvalidResponse, err)
+	}
+
+	return r.Result, nil
+}
+
+// Request implements Provider
+func (w *Webhook) Request(ctx context.Context, r Request) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	for {
+		result, err := w.send(ctx, body)
+		if err != nil {
+			return err
+		}
+
+		switch result {
+		case WebhookAllow:
+			return nil
+
+		case WebhookDeny:
+			return ErrDenied
+
+		case WebhookPending:
+			if err := wait(ctx, webhookPollInterval); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("%s: unknown result %q",
+				ErrWebhookInvalidResponse, result)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package audit delivers the journaled connection events to external sinks
+// without ever blocking the connections that produced them
+package audit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nirui/sshwifty/application/journal"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Errors
+var (
+	ErrUnsupportedSink = errors.New(
+		"unsupported audit sink")
+)
+
+// Sink delivers batches of events to a destination
+type Sink interface {
+	// Name returns the name of the Sink used in logs and stats
+	Name() string
+
+	// Write delivers the `events`. It must return once the `ctx` is done,
+	// and must not retain the `events` after returning
+	Write(ctx context.Context, events []journal.Event) error
+}
+
+type outputSink struct {
+	Sink
+}
+
+// WithOutput wraps the Sink so it also receives the journal.REMOTE_OUTPUT
+// events, which are not delivered to Sinks by default
+func WithOutput(s Sink) Sink {
+	return outputSink{Sink: s}
+}
+
+// Settings of the Dispatcher
+type Settings struct {
+	// Max amount of events waiting for delivery to each Sink. Events beyond
+	// it are dropped
+	Buffer int
+
+	// Max amount of events delivered in a single write
+	BatchSize int
+
+	// Max time to wait for more events before delivering a partial batch
+	FlushInterval time.Duration
+
+	// Max time a single write can take
+	WriteTimeout time.Duration
+
+	// How many times a failed write is retried before the batch is dropped
+	Retries int
+}
+
+// Stats of a Sink
+type Stats struct {
+	Sink      string `json:"sink"`
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+	Failed    uint64 `json:"failed"`
+}
+
+type queue struct {
+	sink      Sink
+	output    bool
+	events    chan journal.Event
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	failed    atomic.Uint64
+}
+
+// Dispatcher queues events for each Sink separately, so a slow or broken
+// Sink only loses it's own events
+type Dispatcher struct {
+	settings Settings
+	queues   []*queue
+	output   bool
+	closing  chan struct{}
+	wait     sync.WaitGroup
+}
+
+// New creates a new Dispatcher, or nil when there's no Sink
+func New(settings Settings, sinks []Sink) *Dispatcher {
+	if len(sinks) <= 0 {
+		return nil
+	}
+
+	output := false
+	queues := make([]*queue, len(sinks))
+	for i := range queues {
+		queues[i] = &queue{
+			sink:   sinks[i],
+			output: false,
+			events: make(chan journal.Event, settings.Buffer),
+		}
+
+		if o, ok := sinks[i].(outputSink); ok {
+			queues[i].sink = o.Sink
+			queues[i].output = true
+			output = true
+		}
+	}
+
+	return &Dispatcher{
+		settings: settings,
+		queues:   queues,
+		output:   output,
+		closing:  make(chan struct{}),
+		wait:     sync.WaitGroup{},
+	}
+}
+
+// Start starts delivering events. It's safe to call Start on a nil
+// Dispatcher
+func (d *Dispatcher) Start(l log.Logger) {
+	if d == nil {
+		return
+	}
+
+	for _, q := range d.queues {
+		d.wait.Add(1)
+
+		go d.deliver(q, l.Context("Sink (%s)", q.sink.Name()))
+	}
+}
+
+// Close delivers the events that are still queued, and then stops the
+// Dispatcher. It's safe to call Close on a nil Dispatcher
+func (d *Dispatcher) Close() {
+	if d == nil {
+		return
+	}
+
+	close(d.closing)
+	d.wait.Wait()
+
+	for _, q := range d.queues {
+		if c, ok := q.sink.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// Publish queues the event `e` for delivery. It never blocks, events are
+// dropped when the queue of a Sink is full. It's safe to call Publish on a
+// nil Dispatcher
+func (d *Dispatcher) Publish(e journal.Event) {
+	if d == nil {
+		return
+	}
+
+	for _, q := range d.queues {
+		if e.Type == journal.REMOTE_OUTPUT && !q.output {
+			continue
+		}
+
+		select {
+		case q.events <- e:
+		default:
+			q.dropped.Add(1)
+		}
+	}
+}
+
+// StreamsOutput returns whether or not any Sink wants the
+// journal.REMOTE_OUTPUT events. It's safe to call StreamsOutput on a nil
+// Dispatcher
+func (d *Dispatcher) StreamsOutput() bool {
+	return d != nil && d.output
+}
+
+// Stats returns the Stats of all Sinks. It's safe to call Stats on a nil
+// Dispatcher
+func (d *Dispatcher) Stats() []Stats {
+	if d == nil {
+		return []Stats{}
+	}
+
+	stats := make([]Stats, len(d.queues))
+	for i, q := range d.queues {
+		stats[i] = Stats{
+			Sink:      q.sink.Name(),
+			Delivered: q.delivered.Load(),
+			Dropped:   q.dropped.Load(),
+			Failed:    q.failed.Load(),
+		}
+	}
+
+	return stats
+}
+
+func (d *Dispatcher) deliver(q *queue, l log.Logger) {
+	defer d.wait.Done()
+
+	ticker := time.NewTicker(d.settings.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]journal.Event, 0, d.settings.BatchSize)
+
+	for {
+		select {
+		case e := <-q.events:
+			batch = append(batch, e)
+
+			if len(batch) < d.settings.BatchSize {
+				continue
+			}
+
+			batch = d.flush(q, batch, l)
+
+		case <-ticker.C:
+			batch = d.flush(q, batch, l)
+
+		case <-d.closing:
+			for {
+				select {
+				case e := <-q.events:
+					batch = append(batch, e)
+
+					if len(batch) >= d.settings.BatchSize {
+						batch = d.flush(q, batch, l)
+					}
+
+					continue
+				default:
+				}
+
+				break
+			}
+
+			d.flush(q, batch, l)
+
+			return
+		}
+	}
+}
+
+// flush writes the `batch` into the Sink, retrying when it fails. Returns
+// the emptied batch
+func (d *Dispatcher) flush(
+	q *queue,
+	batch []journal.Event,
+	l log.Logger,
+) []journal.Event {
+	if len(batch) <= 0 {
+		return batch
+	}
+
+	var err error
+
+	for attempt := 0; attempt <= d.settings.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * time.Second):
+			case <-d.closing:
+				// Don't hold the shutdown for retries
+				attempt = d.settings.Retries
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(
+			context.Background(), d.settings.WriteTimeout)
+		err = q.sink.Write(ctx, batch)
+		cancel()
+
+		if err == nil {
+			q.delivered.Add(uint64(len(batch)))
+
+			return batch[:0]
+		}
+	}
+
+	q.failed.Add(uint64(len(batch)))
+
+	l.Warning("Unable to deliver %d events, they're dropped: %s",
+		len(batch), err)
+
+	return batch[:0]
+}
@@ -0,0 +1,363 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/journal"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+type testSink struct {
+	lock    sync.Mutex
+	block   chan struct{}
+	fails   int
+	batches [][]journal.Event
+}
+
+func (t *testSink) Name() string {
+	return "test"
+}
+
+func (t *testSink) Write(ctx context.Context, events []journal.Event) error {
+	if t.block != nil {
+		<-t.block
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.fails > 0 {
+		t.fails--
+		return errors.New("failed")
+	}
+
+	t.batches = append(t.batches, append([]journal.Event{}, events...))
+
+	return nil
+}
+
+func testSettings() Settings {
+	return Settings{
+		Buffer:        4,
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+		WriteTimeout:  time.Second,
+		Retries:       1,
+	}
+}
+
+func TestDispatcherBatches(t *testing.T) {
+	s := &testSink{}
+	d := New(testSettings(), []Sink{s})
+	d.Start(log.NewDitch())
+
+	for i := 0; i < 3; i++ {
+		d.Publish(journal.Event{Type: journal.CLIENT_CONNECTED})
+	}
+
+	d.Close()
+
+	if len(s.batches) != 2 || len(s.batches[0]) != 2 ||
+		len(s.batches[1]) != 1 {
+		t.Errorf("Unexpected batches %v", s.batches)
+	}
+
+	if st := d.Stats(); st[0].Delivered != 3 || st[0].Dropped != 0 {
+		t.Errorf("Unexpected stats %+v", st)
+	}
+}
+
+func TestDispatcherOutput(t *testing.T) {
+	lifecycle := &testSink{}
+	output := &testSink{}
+	d := New(testSettings(), []Sink{lifecycle, WithOutput(output)})
+	d.Start(log.NewDitch())
+
+	if !d.StreamsOutput() {
+		t.Error("Expecting the Dispatcher to stream output")
+	}
+
+	d.Publish(journal.Event{Type: journal.REMOTE_CONNECTED})
+	d.Publish(journal.Event{Type: journal.REMOTE_OUTPUT, Output: []byte("A")})
+	d.Close()
+
+	if len(lifecycle.batches) != 1 || len(lifecycle.batches[0]) != 1 {
+		t.Errorf("Unexpected batches %v", lifecycle.batches)
+	}
+
+	if len(output.batches) != 1 || len(output.batches[0]) != 2 {
+		t.Errorf("Unexpected batches %v", output.batches)
+	}
+
+	if New(testSettings(), []Sink{lifecycle}).StreamsOutput() {
+		t.Error("Expecting the Dispatcher to not stream output")
+	}
+}
+
+func TestDispatcherNeverBlocks(t *testing.T) {
+	s := &testSink{block: make(chan struct{})}
+	d := New(testSettings(), []Sink{s})
+	d.Start(log.NewDitch())
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 100; i++ {
+			d.Publish(journal.Event{Type: journal.CLIENT_CONNECTED})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish has blocked")
+	}
+
+	close(s.block)
+	d.Close()
+
+	st := d.Stats()[0]
+	if st.Dropped <= 0 || st.Delivered+st.Dropped != 100 {
+		t.Errorf("Unexpected stats %+v", st)
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	s := &testSink{fails: 1}
+	d := New(testSettings(), []Sink{s})
+	d.Start(log.NewDitch())
+
+	d.Publish(journal.Event{Type: journal.CLIENT_CONNECTED})
+	d.Publish(journal.Event{Type: journal.CLIENT_CONNECTED})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for d.Stats()[0].Delivered < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	d.Close()
+
+	if st := d.Stats()[0]; st.Delivered != 2 || st.Failed != 0 {
+		t.Errorf("Unexpected stats %+v", st)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f := NewFile(path)
+	defer f.Close()
+
+	err := f.Write(context.Background(), []journal.Event{
+		{Type: journal.CLIENT_CONNECTED},
+		{Type: journal.CLIENT_DISCONNECTED},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("Expecting 2 lines, got %d", lines)
+	}
+}
+
+func TestHTTPSinks(t *testing.T) {
+	var received *http.Request
+	var body []byte
+
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			received = r
+			body, _ = io.ReadAll(r.Body)
+		}))
+	defer srv.Close()
+
+	events := []journal.Event{{Type: journal.CLIENT_CONNECTED}}
+
+	err := NewWebhook(srv.URL, "secret").Write(context.Background(), events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(
+		received.Header.Get(webhookSignatureHeader), "sha256=") {
+		t.Error("Expecting the request to be signed")
+	}
+
+	err = NewKafka(srv.URL+"/", "audit", FormatJSON).Write(context.Background(), events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if received.URL.Path != "/topics/audit" ||
+		received.Header.Get("Content-Type") !=
+			"application/vnd.kafka.json.v2+json" ||
+		!strings.HasPrefix(string(body), `{"records":[{"value":{`) {
+		t.Errorf("Unexpected Kafka request %s %q", received.URL.Path, body)
+	}
+}
+
+func TestProtobufFormat(t *testing.T) {
+	data, err := FormatProtobuf.Encode(journal.Event{
+		Type:      journal.REMOTE_OUTPUT,
+		Details:   map[string]string{"a": "b"},
+		Output:    []byte("hi"),
+		User:      "u",
+		BytesSent: 300,
+		Reason:    "x",
+		Origin:    &journal.Origin{Forwarded: []string{"f"}, Port: "1"},
+		Tenant:    "t",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := append([]byte{0x12, 0x0d}, "remote.output"...)
+	expected = append(expected, 0x42, 0x06, 0x0a, 0x01, 'a', 0x12, 0x01, 'b')
+	expected = append(expected, 0x4a, 0x02, 'h', 'i')
+	expected = append(expected, 0x52, 0x01, 'u', 0x58, 0xac, 0x02)
+	expected = append(expected, 0x6a, 0x01, 'x')
+	expected = append(expected, 0x72, 0x06, 0x0a, 0x01, 'f', 0x12, 0x01, '1')
+	expected = append(expected, 0x7a, 0x01, 't')
+
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Expecting %v, got %v", expected, data)
+	}
+
+	if _, err := ParseFormat("xml"); err != ErrUnsupportedFormat {
+		t.Errorf("Expecting ErrUnsupportedFormat, got %v", err)
+	}
+}
+
+func TestNATSSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+
+		r := bufio.NewReader(conn)
+		lines := []string{}
+
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			if line == "PING\r\n" {
+				conn.Write([]byte("PONG\r\n"))
+				received <- strings.Join(lines, "")
+
+				return
+			}
+
+			lines = append(lines, line)
+		}
+	}()
+
+	sink, err := NewNATS(
+		"nats://user:pass@"+listener.Addr().String(), "sshwifty.{type}",
+		FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	err = sink.Write(context.Background(), []journal.Event{
+		{Type: journal.REMOTE_CONNECTED},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := <-received
+
+	if !strings.Contains(lines, `"user":"user","pass":"pass"`) ||
+		!strings.Contains(lines, "PUB sshwifty.remote.connected ") {
+		t.Errorf("Unexpected NATS messages %q", lines)
+	}
+
+	if _, err := NewNATS("http://localhost", "a", FormatJSON); err == nil {
+		t.Error("Expecting non-NATS URL to be rejected")
+	}
+}
+
+func TestFeed(t *testing.T) {
+	feed := NewFeed()
+	sub := feed.Subscribe(1)
+
+	feed.Publish(journal.Event{Type: journal.REMOTE_CONNECTED})
+	feed.Publish(journal.Event{Type: journal.REMOTE_OUTPUT})
+	feed.Publish(journal.Event{Type: journal.REMOTE_DISCONNECTED})
+
+	e := <-sub.Events()
+	if e.Type != journal.REMOTE_CONNECTED {
+		t.Errorf("Expecting event %q, got %q", journal.REMOTE_CONNECTED, e.Type)
+	}
+
+	if sub.Dropped() != 1 {
+		t.Errorf("Expecting 1 dropped event, got %d", sub.Dropped())
+	}
+
+	sub.Close()
+	feed.Publish(journal.Event{Type: journal.REMOTE_CONNECTED})
+
+	select {
+	case e := <-sub.Events():
+		t.Errorf("Closed subscription received event %q", e.Type)
+	default:
+	}
+
+	// Nil Feed is disabled
+	var disabled *Feed
+	disabled.Publish(journal.Event{Type: journal.REMOTE_CONNECTED})
+	disabled.Subscribe(1).Close()
+}
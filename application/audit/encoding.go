@@ -0,0 +1,202 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/nirui/sshwifty/application/journal"
+)
+
+// Format is the serialization of the events sent to the streaming Sinks
+type Format string
+
+// Supported Formats
+const (
+	// FormatJSON encodes the event as the same JSON object of the Journal
+	FormatJSON Format = "json"
+
+	// FormatProtobuf encodes the event as the Event message defined in
+	// event.proto
+	FormatProtobuf Format = "protobuf"
+)
+
+// Errors
+var (
+	ErrUnsupportedFormat = errors.New(
+		"unsupported audit event format")
+)
+
+// ParseFormat parses the name of the Format. Empty name results FormatJSON
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(name)) {
+	case "", FormatJSON:
+		return FormatJSON, nil
+
+	case FormatProtobuf:
+		return FormatProtobuf, nil
+
+	default:
+		return "", ErrUnsupportedFormat
+	}
+}
+
+// Encode serializes the event `e` in the Format
+func (f Format) Encode(e journal.Event) ([]byte, error) {
+	switch f {
+	case FormatJSON:
+		return json.Marshal(e)
+
+	case FormatProtobuf:
+		return marshalProtobuf(e), nil
+
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// Topic resolves the topic (or subject) of the event `e`. The "{type}" and
+// "{protocol}" in the `pattern` are replaced by the type and the protocol
+// of the event, so events can be routed to different topics
+func Topic(pattern string, e journal.Event) string {
+	protocol := e.Protocol
+	if len(protocol) <= 0 {
+		protocol = "none"
+	}
+
+	return strings.NewReplacer(
+		"{type}", string(e.Type),
+		"{protocol}", strings.ToLower(protocol),
+	).Replace(pattern)
+}
+
+// Field numbers of the Event message in event.proto
+const (
+	protobufFieldTime     = 1
+	protobufFieldType     = 2
+	protobufFieldClient   = 3
+	protobufFieldProtocol = 4
+	protobufFieldRemote   = 5
+	protobufFieldDuration = 6
+	protobufFieldError    = 7
+	protobufFieldDetails  = 8
+	protobufFieldOutput   = 9
+	protobufFieldUser     = 10
+	protobufFieldSent     = 11
+	protobufFieldReceived = 12
+	protobufFieldReason   = 13
+	protobufFieldOrigin   = 14
+	protobufFieldTenant   = 15
+)
+
+// Wire types of protobuf
+const (
+	protobufWireVarint = 0
+	protobufWireBytes  = 2
+)
+
+type protobufMessage []byte
+
+func (p *protobufMessage) tag(field int, wire int) {
+	*p = binary.AppendUvarint(*p, uint64(field<<3|wire))
+}
+
+func (p *protobufMessage) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+
+	p.tag(field, protobufWireVarint)
+	*p = binary.AppendUvarint(*p, v)
+}
+
+func (p *protobufMessage) bytes(field int, b []byte) {
+	if len(b) <= 0 {
+		return
+	}
+
+	p.tag(field, protobufWireBytes)
+	*p = binary.AppendUvarint(*p, uint64(len(b)))
+	*p = append(*p, b...)
+}
+
+func (p *protobufMessage) string(field int, s string) {
+	p.bytes(field, []byte(s))
+}
+
+// marshalProtobuf encodes the event `e` as the Event message
+func marshalProtobuf(e journal.Event) []byte {
+	m := protobufMessage{}
+
+	if !e.Time.IsZero() {
+		m.varint(protobufFieldTime, uint64(e.Time.UnixNano()))
+	}
+
+	m.string(protobufFieldType, string(e.Type))
+	m.string(protobufFieldClient, e.Client)
+	m.string(protobufFieldProtocol, e.Protocol)
+	m.string(protobufFieldRemote, e.Remote)
+	m.varint(protobufFieldDuration, uint64(e.Duration))
+	m.string(protobufFieldError, e.Error)
+
+	// Sorted, so the same event always results the same bytes
+	keys := make([]string, 0, len(e.Details))
+	for k := range e.Details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		entry := protobufMessage{}
+		entry.string(1, k)
+		entry.string(2, e.Details[k])
+
+		// Empty entries must still be present for the map
+		m.tag(protobufFieldDetails, protobufWireBytes)
+		m = binary.AppendUvarint(m, uint64(len(entry)))
+		m = append(m, entry...)
+	}
+
+	m.bytes(protobufFieldOutput, e.Output)
+	m.string(protobufFieldUser, e.User)
+	m.varint(protobufFieldSent, e.BytesSent)
+	m.varint(protobufFieldReceived, e.BytesReceived)
+	m.string(protobufFieldReason, e.Reason)
+
+	if e.Origin != nil {
+		origin := protobufMessage{}
+
+		for _, f := range e.Origin.Forwarded {
+			origin.string(1, f)
+		}
+
+		origin.string(2, e.Origin.Port)
+		origin.string(3, e.Origin.JA3)
+		origin.string(4, e.Origin.JA4)
+
+		m.bytes(protobufFieldOrigin, origin)
+	}
+
+	m.string(protobufFieldTenant, e.Tenant)
+
+	return m
+}
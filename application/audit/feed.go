@@ -0,0 +1,120 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"sync"
+
+	"github.com/nirui/sshwifty/application/journal"
+)
+
+// Feed delivers events to it's live subscribers as soon as they're
+// published, without the batching of the Dispatcher. Like the Dispatcher,
+// it never blocks the publisher, events are dropped when a subscriber
+// cannot keep up
+type Feed struct {
+	lock        sync.Mutex
+	subscribers map[*FeedSubscription]struct{}
+}
+
+// FeedSubscription receives the events published to a Feed
+type FeedSubscription struct {
+	feed    *Feed
+	events  chan journal.Event
+	dropped uint64
+}
+
+// NewFeed creates a new Feed
+func NewFeed() *Feed {
+	return &Feed{
+		lock:        sync.Mutex{},
+		subscribers: map[*FeedSubscription]struct{}{},
+	}
+}
+
+// Subscribe creates a new subscription that can hold at most `buffer`
+// events which are not yet received. It's safe to call Subscribe on a nil
+// Feed, the resulting subscription will never receive any event
+func (f *Feed) Subscribe(buffer int) *FeedSubscription {
+	s := &FeedSubscription{
+		feed:    f,
+		events:  make(chan journal.Event, buffer),
+		dropped: 0,
+	}
+
+	if f == nil {
+		return s
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.subscribers[s] = struct{}{}
+
+	return s
+}
+
+// Publish delivers the event `e` to all subscribers. The
+// journal.REMOTE_OUTPUT events are never delivered. It's safe to call
+// Publish on a nil Feed
+func (f *Feed) Publish(e journal.Event) {
+	if f == nil || e.Type == journal.REMOTE_OUTPUT {
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for s := range f.subscribers {
+		select {
+		case s.events <- e:
+		default:
+			s.dropped++
+		}
+	}
+}
+
+// Events returns the channel where the events will be received from
+func (s *FeedSubscription) Events() <-chan journal.Event {
+	return s.events
+}
+
+// Dropped returns how many events have been dropped because the
+// subscription did not receive them in time
+func (s *FeedSubscription) Dropped() uint64 {
+	if s.feed == nil {
+		return 0
+	}
+
+	s.feed.lock.Lock()
+	defer s.feed.lock.Unlock()
+
+	return s.dropped
+}
+
+// Close stops the subscription
+func (s *FeedSubscription) Close() {
+	if s.feed == nil {
+		return
+	}
+
+	s.feed.lock.Lock()
+	defer s.feed.lock.Unlock()
+
+	delete(s.feed.subscribers, s)
+}
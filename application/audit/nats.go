@@ -0,0 +1,309 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nirui/sshwifty/application/journal"
+)
+
+// Errors
+var (
+	ErrNATSInvalidURL = errors.New(
+		"invalid NATS URL, must be a \"nats://\" or \"tls://\" URL")
+
+	ErrNATSInvalidSubject = errors.New(
+		"invalid NATS subject")
+
+	ErrNATSUnexpectedReply = errors.New(
+		"unexpected reply from the NATS server")
+)
+
+const (
+	natsDefaultPort   = "4222"
+	natsMaxLineSize   = 64 * 1024
+	natsDialTimeout   = 10 * time.Second
+	natsClientName    = "sshwifty"
+	natsClientLang    = "go"
+	natsClientVersion = "1.0.0"
+)
+
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+}
+
+// NATSSink publishes the events to NATS subjects through the core NATS
+// protocol, one message per event
+type NATSSink struct {
+	u       *url.URL
+	subject string
+	format  Format
+	lock    sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+}
+
+// NewNATS creates a new NATSSink which connects to the NATS server at `u`.
+// The `subject` is resolved by Topic for each event
+func NewNATS(u string, subject string, format Format) (*NATSSink, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNATSInvalidURL, err)
+	}
+
+	if (parsed.Scheme != "nats" && parsed.Scheme != "tls") ||
+		len(parsed.Hostname()) <= 0 {
+		return nil, ErrNATSInvalidURL
+	}
+
+	if len(subject) <= 0 || strings.ContainsAny(subject, " \t\r\n") {
+		return nil, ErrNATSInvalidSubject
+	}
+
+	return &NATSSink{
+		u:       parsed,
+		subject: subject,
+		format:  format,
+		lock:    sync.Mutex{},
+		conn:    nil,
+		reader:  nil,
+	}, nil
+}
+
+// Name implements Sink
+func (n *NATSSink) Name() string {
+	return "nats:" + n.subject
+}
+
+// connect connects and authenticates to the NATS server
+func (n *NATSSink) connect(ctx context.Context) error {
+	port := n.u.Port()
+	if len(port) <= 0 {
+		port = natsDefaultPort
+	}
+
+	dialer := net.Dialer{Timeout: natsDialTimeout}
+
+	conn, err := dialer.DialContext(
+		ctx, "tcp", net.JoinHostPort(n.u.Hostname(), port))
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// The server always greets with it's INFO in plain text, the TLS
+	// handshake (if any) happens after it
+	reader := bufio.NewReaderSize(conn, natsMaxLineSize)
+
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		conn.Close()
+
+		return err
+	}
+
+	info := natsInfo{}
+
+	if !bytes.HasPrefix(line, []byte("INFO ")) ||
+		json.Unmarshal(line[5:], &info) != nil {
+		conn.Close()
+
+		return ErrNATSUnexpectedReply
+	}
+
+	if n.u.Scheme == "tls" || info.TLSRequired {
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName: n.u.Hostname(),
+		})
+
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+
+			return err
+		}
+
+		conn = tlsConn
+		reader = bufio.NewReaderSize(conn, natsMaxLineSize)
+	}
+
+	connect := natsConnect{
+		Verbose:  false,
+		Pedantic: false,
+		Name:     natsClientName,
+		Lang:     natsClientLang,
+		Version:  natsClientVersion,
+	}
+
+	if n.u.User != nil {
+		pass, hasPass := n.u.User.Password()
+
+		if hasPass {
+			connect.User = n.u.User.Username()
+			connect.Pass = pass
+		} else {
+			connect.Token = n.u.User.Username()
+		}
+	}
+
+	connectData, err := json.Marshal(connect)
+	if err != nil {
+		conn.Close()
+
+		return err
+	}
+
+	_, err = conn.Write([]byte("CONNECT " + string(connectData) + "\r\n"))
+	if err != nil {
+		conn.Close()
+
+		return err
+	}
+
+	n.conn = conn
+	n.reader = reader
+
+	return nil
+}
+
+// disconnect drops the connection, so the next Write reconnects
+func (n *NATSSink) disconnect() {
+	if n.conn == nil {
+		return
+	}
+
+	n.conn.Close()
+	n.conn = nil
+	n.reader = nil
+}
+
+// Write implements Sink. The events are followed by a PING, and the write
+// is only considered successful once the server replied the PONG, which
+// means the server has processed all the events before it
+func (n *NATSSink) Write(ctx context.Context, events []journal.Event) error {
+	buf := bytes.Buffer{}
+
+	for _, e := range events {
+		data, err := n.format.Encode(e)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&buf, "PUB %s %d\r\n", Topic(n.subject, e), len(data))
+		buf.Write(data)
+		buf.WriteString("\r\n")
+	}
+
+	buf.WriteString("PING\r\n")
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.conn == nil {
+		if err := n.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Time{}
+	}
+
+	n.conn.SetDeadline(deadline)
+
+	if _, err := n.conn.Write(buf.Bytes()); err != nil {
+		n.disconnect()
+
+		return err
+	}
+
+	for {
+		line, err := n.reader.ReadSlice('\n')
+		if err != nil {
+			n.disconnect()
+
+			return err
+		}
+
+		line = bytes.TrimRight(line, "\r\n")
+
+		switch {
+		case bytes.Equal(line, []byte("PONG")):
+			return nil
+
+		case bytes.Equal(line, []byte("PING")):
+			if _, err := n.conn.Write([]byte("PONG\r\n")); err != nil {
+				n.disconnect()
+
+				return err
+			}
+
+		case bytes.HasPrefix(line, []byte("-ERR")):
+			n.disconnect()
+
+			return fmt.Errorf("NATS server error: %s",
+				strings.TrimSpace(string(line[4:])))
+
+		case bytes.Equal(line, []byte("+OK")),
+			bytes.HasPrefix(line, []byte("INFO ")):
+			// Acknowledgement of verbose mode, and the updated cluster
+			// information. Neither of them matters here
+
+		default:
+			n.disconnect()
+
+			return ErrNATSUnexpectedReply
+		}
+	}
+}
+
+// Close closes the connection to the NATS server
+func (n *NATSSink) Close() error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.disconnect()
+
+	return nil
+}
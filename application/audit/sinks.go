@@ -0,0 +1,275 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/nirui/sshwifty/application/journal"
+)
+
+const (
+	webhookSignatureHeader = "X-Sshwifty-Signature"
+	httpRespondMaxSize     = 4096
+)
+
+// FileSink appends the events to a file, one JSON object per line
+type FileSink struct {
+	path string
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewFile creates a new FileSink
+func NewFile(path string) *FileSink {
+	return &FileSink{
+		path: path,
+		lock: sync.Mutex{},
+		file: nil,
+	}
+}
+
+// Name implements Sink
+func (f *FileSink) Name() string {
+	return "file:" + f.path
+}
+
+// Write implements Sink
+func (f *FileSink) Write(ctx context.Context, events []journal.Event) error {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.file == nil {
+		file, err := os.OpenFile(
+			f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+
+		f.file = file
+	}
+
+	_, err := f.file.Write(buf.Bytes())
+	if err != nil {
+		// Reopen the file on the next write
+		f.file.Close()
+		f.file = nil
+	}
+
+	return err
+}
+
+// Close closes the file
+func (f *FileSink) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	return err
+}
+
+// post sends the `body` to the `url` and expects a 2xx respond
+func post(
+	ctx context.Context,
+	client *http.Client,
+	url string,
+	contentType string,
+	header http.Header,
+	body []byte,
+) error {
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, httpRespondMaxSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected respond status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// WebhookSink POSTs the events to an URL as a JSON array
+type WebhookSink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewWebhook creates a new WebhookSink. When `secret` is given, the requests
+// are signed in the X-Sshwifty-Signature header, in the same way as the push
+// approval webhook
+func NewWebhook(url string, secret string) *WebhookSink {
+	return &WebhookSink{
+		url:    url,
+		secret: secret,
+		client: &http.Client{},
+	}
+}
+
+// Name implements Sink
+func (w *WebhookSink) Name() string {
+	return "webhook:" + w.url
+}
+
+// Write implements Sink
+func (w *WebhookSink) Write(ctx context.Context, events []journal.Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+
+	if len(w.secret) > 0 {
+		h := hmac.New(sha256.New, []byte(w.secret))
+		h.Write(body)
+
+		header.Set(webhookSignatureHeader,
+			"sha256="+hex.EncodeToString(h.Sum(nil)))
+	}
+
+	return post(ctx, w.client, w.url, "application/json", header, body)
+}
+
+// KafkaSink produces the events to Kafka topics through a Kafka REST Proxy
+// (the v2 API), one record per event
+type KafkaSink struct {
+	url    string
+	topic  string
+	format Format
+	client *http.Client
+}
+
+type kafkaRecord struct {
+	Value interface{} `json:"value"`
+}
+
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+// NewKafka creates a new KafkaSink which sends records to the REST Proxy at
+// `url`. The `topic` is resolved by Topic for each event. Events are sent
+// as JSON records in FormatJSON, or as binary records in other Formats
+func NewKafka(url string, topic string, format Format) *KafkaSink {
+	return &KafkaSink{
+		url:    strings.TrimRight(url, "/"),
+		topic:  topic,
+		format: format,
+		client: &http.Client{},
+	}
+}
+
+// Name implements Sink
+func (k *KafkaSink) Name() string {
+	return "kafka:" + k.topic
+}
+
+// Write implements Sink
+func (k *KafkaSink) Write(ctx context.Context, events []journal.Event) error {
+	topics := []string{}
+	records := map[string]*kafkaRecords{}
+
+	for i := range events {
+		var value interface{} = events[i]
+
+		if k.format != FormatJSON {
+			// Marshalled as base64 by encoding/json, as the REST Proxy
+			// expects for binary records
+			v, err := k.format.Encode(events[i])
+			if err != nil {
+				return err
+			}
+
+			value = v
+		}
+
+		topic := Topic(k.topic, events[i])
+
+		if _, ok := records[topic]; !ok {
+			topics = append(topics, topic)
+			records[topic] = &kafkaRecords{}
+		}
+
+		records[topic].Records = append(
+			records[topic].Records, kafkaRecord{Value: value})
+	}
+
+	contentType := "application/vnd.kafka.json.v2+json"
+	if k.format != FormatJSON {
+		contentType = "application/vnd.kafka.binary.v2+json"
+	}
+
+	for _, topic := range topics {
+		body, err := json.Marshal(records[topic])
+		if err != nil {
+			return err
+		}
+
+		err = post(ctx, k.client, k.url+"/topics/"+url.PathEscape(topic),
+			contentType, nil, body)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+//go:build !(windows || plan9)
+
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log/syslog"
+	"sync"
+
+	"github.com/nirui/sshwifty/application/journal"
+)
+
+// SyslogSink sends the events to a syslog server as JSON messages
+type SyslogSink struct {
+	network string
+	address string
+	tag     string
+	lock    sync.Mutex
+	writer  *syslog.Writer
+}
+
+// NewSyslog creates a new SyslogSink. Empty `network` and `address` sends
+// the events to the local syslog server
+func NewSyslog(network, address, tag string) (*SyslogSink, error) {
+	return &SyslogSink{
+		network: network,
+		address: address,
+		tag:     tag,
+		lock:    sync.Mutex{},
+		writer:  nil,
+	}, nil
+}
+
+// Name implements Sink
+func (s *SyslogSink) Name() string {
+	if len(s.address) <= 0 {
+		return "syslog"
+	}
+
+	return "syslog:" + s.address
+}
+
+// Write implements Sink
+func (s *SyslogSink) Write(ctx context.Context, events []journal.Event) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.writer == nil {
+		w, err := syslog.Dial(
+			s.network, s.address, syslog.LOG_INFO|syslog.LOG_AUTH, s.tag)
+		if err != nil {
+			return err
+		}
+
+		s.writer = w
+	}
+
+	for _, e := range events {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		err = s.writer.Info(string(b))
+		if err != nil {
+			// Reconnect on the next write
+			s.writer.Close()
+			s.writer = nil
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the connection to the syslog server
+func (s *SyslogSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.writer == nil {
+		return nil
+	}
+
+	err := s.writer.Close()
+	s.writer = nil
+
+	return err
+}
@@ -0,0 +1,30 @@
+//go:build windows || plan9
+
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"fmt"
+)
+
+// NewSyslog returns an error as syslog is not supported on current platform
+func NewSyslog(network, address, tag string) (Sink, error) {
+	return nil, fmt.Errorf("%s: syslog is not supported on this platform",
+		ErrUnsupportedSink)
+}
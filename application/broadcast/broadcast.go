@@ -0,0 +1,89 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package broadcast pushes the notices of the administrator to all the
+// connected clients, i.e. to warn them about a maintenance
+package broadcast
+
+import (
+	"sync"
+)
+
+// subscriberBuffer is the amount of notices queued for a subscriber, the
+// following ones are dropped until the subscriber has caught up
+const subscriberBuffer = 4
+
+// Broadcaster delivers the notices to it's subscribers. All methods of a nil
+// Broadcaster do nothing
+type Broadcaster struct {
+	lock        sync.Mutex
+	subscribers map[chan string]struct{}
+}
+
+// New creates a new Broadcaster
+func New() *Broadcaster {
+	return &Broadcaster{
+		lock:        sync.Mutex{},
+		subscribers: map[chan string]struct{}{},
+	}
+}
+
+// Subscribe returns a channel which receives the notices, and a function to
+// cancel the subscription
+func (b *Broadcaster) Subscribe() (<-chan string, func()) {
+	if b == nil {
+		return nil, func() {}
+	}
+
+	c := make(chan string, subscriberBuffer)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.subscribers[c] = struct{}{}
+
+	return c, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+
+		delete(b.subscribers, c)
+	}
+}
+
+// Send delivers the `notice` to all subscribers without waiting for them.
+// It returns the amount of subscribers which have received it
+func (b *Broadcaster) Send(notice string) int {
+	if b == nil {
+		return 0
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delivered := 0
+
+	for c := range b.subscribers {
+		select {
+		case c <- notice:
+			delivered++
+
+		default:
+		}
+	}
+
+	return delivered
+}
@@ -0,0 +1,69 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package broadcast
+
+import (
+	"testing"
+)
+
+func TestBroadcaster(t *testing.T) {
+	b := New()
+
+	c1, cancel1 := b.Subscribe()
+	c2, cancel2 := b.Subscribe()
+	defer cancel2()
+
+	if n := b.Send("hello"); n != 2 {
+		t.Errorf("Expecting 2 deliveries, got %d", n)
+	}
+
+	if n := <-c1; n != "hello" {
+		t.Errorf("Expecting %q, got %q", "hello", n)
+	}
+
+	if n := <-c2; n != "hello" {
+		t.Errorf("Expecting %q, got %q", "hello", n)
+	}
+
+	cancel1()
+
+	for i := 0; i < subscriberBuffer; i++ {
+		if n := b.Send("again"); n != 1 {
+			t.Errorf("Expecting 1 delivery, got %d", n)
+		}
+	}
+
+	if n := b.Send("dropped"); n != 0 {
+		t.Errorf("Expecting the notice to be dropped, got %d deliveries", n)
+	}
+}
+
+func TestNilBroadcaster(t *testing.T) {
+	b := (*Broadcaster)(nil)
+
+	c, cancel := b.Subscribe()
+	defer cancel()
+
+	if c != nil {
+		t.Error("Expecting no channel from a nil Broadcaster")
+	}
+
+	if n := b.Send("hello"); n != 0 {
+		t.Errorf("Expecting no delivery, got %d", n)
+	}
+}
@@ -0,0 +1,303 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package classroom keeps the batches of short-lived access links that the
+// instructors hand out to their students, so a workshop can be run through
+// one gateway, and tracks the session of every student
+package classroom
+
+import (
+	"crypto/rand"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrInvalidSeats = errors.New(
+		"invalid amount of seats")
+
+	ErrInvalidLifetime = errors.New(
+		"invalid lifetime")
+
+	ErrUnavailable = errors.New(
+		"classroom is unavailable")
+)
+
+// Limits of the classes
+const (
+	MaxSeats    = 200
+	MaxLifetime = 7 * 24 * time.Hour
+)
+
+// Statuses of the seats
+const (
+	StatusUnused       = "unused"
+	StatusConnected    = "connected"
+	StatusDisconnected = "disconnected"
+)
+
+// Seat is the access link of a student, and the status of it's session
+type Seat struct {
+	Number      int       `json:"seat"`
+	Token       string    `json:"token"`
+	Status      string    `json:"status"`
+	Client      string    `json:"client,omitempty"` // Last client
+	ConnectedAt time.Time `json:"connected_at,omitzero"`
+	LeftAt      time.Time `json:"left_at,omitzero"`
+	key         string
+	clients     int
+}
+
+// Class is a batch of seats bound to a Preset
+type Class struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Preset  string    `json:"preset"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	Seats   []Seat    `json:"seats"`
+}
+
+// Session is the login of a student
+type Session struct {
+	Class   string // ID of the class
+	Name    string // Name of the class
+	Preset  string
+	Seat    int
+	Key     string // Replaces the SharedKey for the student
+	Expires time.Time
+	token   string
+}
+
+// Token returns the token of the seat, which resumes the Session
+func (s Session) Token() string {
+	return s.token
+}
+
+// Classroom keeps the classes until they're closed or have expired. All
+// methods of a nil Classroom do nothing
+type Classroom struct {
+	lock    sync.Mutex
+	classes map[string]*Class
+}
+
+// New creates a new Classroom
+func New() *Classroom {
+	return &Classroom{
+		lock:    sync.Mutex{},
+		classes: map[string]*Class{},
+	}
+}
+
+// prune removes the expired classes. Must be called with the lock held
+func (c *Classroom) prune(now time.Time) {
+	for id, class := range c.classes {
+		if now.After(class.Expires) {
+			delete(c.classes, id)
+		}
+	}
+}
+
+// Open opens a class of `seats` seats for the `preset`, which are usable
+// for the `lifetime`
+func (c *Classroom) Open(
+	name string,
+	preset string,
+	seats int,
+	lifetime time.Duration,
+) (Class, error) {
+	if c == nil {
+		return Class{}, ErrUnavailable
+	}
+
+	if seats <= 0 || seats > MaxSeats {
+		return Class{}, ErrInvalidSeats
+	}
+
+	if lifetime <= 0 || lifetime > MaxLifetime {
+		return Class{}, ErrInvalidLifetime
+	}
+
+	now := time.Now()
+
+	class := &Class{
+		ID:      rand.Text(),
+		Name:    name,
+		Preset:  preset,
+		Created: now,
+		Expires: now.Add(lifetime),
+		Seats:   make([]Seat, seats),
+	}
+
+	for i := range class.Seats {
+		class.Seats[i] = Seat{
+			Number: i + 1,
+			Token:  rand.Text(),
+			Status: StatusUnused,
+			key:    rand.Text(),
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.prune(now)
+	c.classes[class.ID] = class
+
+	return class.snapshot(), nil
+}
+
+// snapshot returns a copy of the class which is safe to use without the
+// lock
+func (c *Class) snapshot() Class {
+	s := *c
+	s.Seats = append([]Seat{}, c.Seats...)
+
+	return s
+}
+
+// Classes returns the classes which have not expired, the latest opened
+// first
+func (c *Classroom) Classes() []Class {
+	if c == nil {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.prune(time.Now())
+
+	classes := make([]Class, 0, len(c.classes))
+	for _, class := range c.classes {
+		classes = append(classes, class.snapshot())
+	}
+
+	sort.Slice(classes, func(i, j int) bool {
+		return classes[i].Created.After(classes[j].Created)
+	})
+
+	return classes
+}
+
+// Close closes the class of the `id`, so it's seats can no longer be used.
+// The connected sessions are not ended. It returns false when the class is
+// not found
+func (c *Classroom) Close(id string) bool {
+	if c == nil {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, ok := c.classes[id]
+	delete(c.classes, id)
+
+	return ok
+}
+
+// find returns the seat of the `token`. Must be called with the lock held
+func (c *Classroom) find(token string) (*Class, *Seat) {
+	now := time.Now()
+
+	for _, class := range c.classes {
+		if now.After(class.Expires) {
+			continue
+		}
+
+		for i := range class.Seats {
+			if class.Seats[i].Token == token {
+				return class, &class.Seats[i]
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// Login returns the Session of the seat of the `token`
+func (c *Classroom) Login(token string) (Session, bool) {
+	if c == nil || len(token) <= 0 {
+		return Session{}, false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	class, seat := c.find(token)
+	if seat == nil {
+		return Session{}, false
+	}
+
+	return Session{
+		Class:   class.ID,
+		Name:    class.Name,
+		Preset:  class.Preset,
+		Seat:    seat.Number,
+		Key:     seat.key,
+		Expires: class.Expires,
+		token:   token,
+	}, true
+}
+
+// Attend marks the seat of the `session` as connected by the `client`, until
+// the returned function is called
+func (c *Classroom) Attend(session Session, client string) func() {
+	if c == nil {
+		return func() {}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, seat := c.find(session.token)
+	if seat == nil {
+		return func() {}
+	}
+
+	seat.clients++
+	seat.Status = StatusConnected
+	seat.Client = client
+	seat.ConnectedAt = time.Now()
+
+	once := sync.Once{}
+
+	return func() {
+		once.Do(func() {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+
+			// The class may have been closed meanwhile
+			_, seat := c.find(session.token)
+			if seat == nil {
+				return
+			}
+
+			seat.clients--
+			if seat.clients > 0 {
+				return
+			}
+
+			seat.Status = StatusDisconnected
+			seat.LeftAt = time.Now()
+		})
+	}
+}
@@ -0,0 +1,119 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package classroom
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClassroom(t *testing.T) {
+	c := New()
+
+	if _, err := c.Open("Lab", "Box", 0, time.Hour); !errors.Is(
+		err, ErrInvalidSeats) {
+		t.Error("Expecting a class without seats to be refused, got", err)
+	}
+
+	if _, err := c.Open("Lab", "Box", 2, MaxLifetime+1); !errors.Is(
+		err, ErrInvalidLifetime) {
+		t.Error("Expecting a class that lasts too long to be refused, got",
+			err)
+	}
+
+	class, err := c.Open("Lab", "Box", 2, time.Hour)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if len(class.Seats) != 2 || class.Seats[0].Token == class.Seats[1].Token {
+		t.Errorf("Unexpected seats: %+v", class.Seats)
+	}
+
+	if _, ok := c.Login("invalid"); ok {
+		t.Error("Expecting an unknown token to be refused")
+	}
+
+	s, ok := c.Login(class.Seats[1].Token)
+	if !ok {
+		t.Fatal("Expecting the seat to be logged in")
+	}
+
+	if s.Preset != "Box" || s.Seat != 2 || len(s.Key) <= 0 {
+		t.Errorf("Unexpected session: %+v", s)
+	}
+
+	leave := c.Attend(s, "client")
+
+	seats := c.Classes()[0].Seats
+	if seats[0].Status != StatusUnused || seats[1].Status != StatusConnected ||
+		seats[1].Client != "client" {
+		t.Errorf("Unexpected seats: %+v", seats)
+	}
+
+	leave()
+	leave()
+
+	if seat := c.Classes()[0].Seats[1]; seat.Status != StatusDisconnected {
+		t.Errorf("Expecting the seat to be disconnected, got %+v", seat)
+	}
+
+	if !c.Close(class.ID) || c.Close(class.ID) {
+		t.Error("Expecting the class to be closed once")
+	}
+
+	if _, ok := c.Login(class.Seats[1].Token); ok {
+		t.Error("Expecting the seats of a closed class to be refused")
+	}
+}
+
+func TestClassroomExpires(t *testing.T) {
+	c := New()
+
+	class, err := c.Open("Lab", "Box", 1, time.Nanosecond)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	time.Sleep(time.Millisecond)
+
+	if _, ok := c.Login(class.Seats[0].Token); ok {
+		t.Error("Expecting the seats of an expired class to be refused")
+	}
+
+	if len(c.Classes()) != 0 {
+		t.Error("Expecting the expired class to be removed")
+	}
+}
+
+func TestClassroomNil(t *testing.T) {
+	var c *Classroom
+
+	if _, err := c.Open("Lab", "Box", 1, time.Hour); !errors.Is(
+		err, ErrUnavailable) {
+		t.Error("Expecting a nil Classroom to be unavailable, got", err)
+	}
+
+	if _, ok := c.Login("token"); ok || c.Close("id") ||
+		len(c.Classes()) != 0 {
+		t.Error("Expecting a nil Classroom to do nothing")
+	}
+
+	c.Attend(Session{}, "client")()
+}
@@ -19,18 +19,128 @@ package command
 
 import (
 	"io"
+	"net"
 	"sync"
 	"time"
 
+	"github.com/nirui/sshwifty/application/approval"
+	"github.com/nirui/sshwifty/application/audit"
+	"github.com/nirui/sshwifty/application/broadcast"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/forward"
+	"github.com/nirui/sshwifty/application/handover"
+	"github.com/nirui/sshwifty/application/hostkeys"
+	"github.com/nirui/sshwifty/application/journal"
 	"github.com/nirui/sshwifty/application/log"
 	"github.com/nirui/sshwifty/application/network"
+	"github.com/nirui/sshwifty/application/recording"
+	"github.com/nirui/sshwifty/application/relay"
+	"github.com/nirui/sshwifty/application/risk"
 	"github.com/nirui/sshwifty/application/rw"
+	"github.com/nirui/sshwifty/application/sharing"
+	"github.com/nirui/sshwifty/application/streamstats"
+	"github.com/nirui/sshwifty/application/tracing"
+	"github.com/nirui/sshwifty/application/upgrade"
+	"github.com/nirui/sshwifty/application/warmup"
+	"github.com/nirui/sshwifty/application/watcher"
 )
 
 // Configuration contains configuration data needed to run command
 type Configuration struct {
-	Dial        network.Dial
-	DialTimeout time.Duration
+	Dial                 network.Dial
+	DialTimeout          time.Duration
+	ReadDeadlineStrategy configuration.ReadDeadlineStrategy
+	PromptTimeout        time.Duration
+	Journal              *journal.Journal
+	Audit                *audit.Dispatcher
+	Tracer               *tracing.Tracer
+	Events               *audit.Feed
+	KnownHosts           *hostkeys.Store
+	ClientAddress        string
+	ClientOrigin         *journal.Origin
+	Tenant               string
+	User                 string
+	UserGroups           []string
+	Presets              []configuration.Preset
+	ConnectNotice        string
+	Watcher              *watcher.Watcher
+	Broadcaster          *broadcast.Broadcaster
+	SSHRekeyThreshold    uint64
+	SSHMaxPacketSize     int
+	SSHAlgorithms        configuration.SSHAlgorithms
+	SSHServerPolicy      configuration.SSHServerPolicy
+	SSHKeepaliveInterval time.Duration
+	SSHKeepaliveCountMax int
+	SSHReconnectAttempts int
+	SSHReconnectMaxDelay time.Duration
+	Handover             *handover.Registry
+	InterruptMuteTime    time.Duration
+	Relays               *relay.Pool
+	Recorder             *recording.Recorder
+	RecordAllSessions    bool
+	TerminalType         string
+	TerminalModes        map[uint8]uint32
+	Warmup               *warmup.Pool
+	ForwardBindHost      string
+	ReverseForwardPolicy forward.Policy
+	ReverseForwards      *forward.Registry
+	Previews             *forward.Previews
+	AllowDynamicForwards bool
+	AllowLocalForwards   bool
+	AllowFileTransfer    bool
+	SSHAgentSocket       string
+	PresetRemotesOnly    bool
+	Approver             *approval.Approver
+	StepUp               *StepUp
+	Risk                 *risk.Scorer
+	Streams              *streamstats.Registry
+	Sessions             *upgrade.Sessions
+	Sharing              *sharing.Registry
+	ReadOnly             bool // Input from the client is not sent to remotes
+}
+
+// ClientIP returns the IP address of the client
+func (c Configuration) ClientIP() string {
+	host, _, err := net.SplitHostPort(c.ClientAddress)
+	if err != nil {
+		return c.ClientAddress
+	}
+
+	return host
+}
+
+// Preset returns the Preset of given `remoteType` that targets the
+// `remoteAddress`
+func (c Configuration) Preset(
+	remoteType string,
+	remoteAddress string,
+) (configuration.Preset, bool) {
+	remoteHost, _, err := net.SplitHostPort(remoteAddress)
+	if err != nil {
+		remoteHost = remoteAddress
+	}
+
+	for _, p := range c.Presets {
+		if p.Type != remoteType {
+			continue
+		}
+
+		// Hosts of some Presets (i.e. unix socket aliases) comes without a
+		// port
+		if _, _, err := net.SplitHostPort(p.Host); err != nil {
+			if p.Host == remoteHost {
+				return p, true
+			}
+
+			continue
+		}
+
+		if p.Host == remoteAddress {
+			return p, true
+		}
+	}
+
+	return configuration.Preset{}, false
 }
 
 // Commander command control
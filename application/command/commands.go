@@ -49,6 +49,7 @@ type Builder struct {
 	name         string
 	command      Command
 	configurator configuration.PresetReloader
+	signals      *Signals
 }
 
 // Register builds a Builder for registration
@@ -57,9 +58,20 @@ func Register(name string, c Command, p configuration.PresetReloader) Builder {
 		name:         name,
 		command:      c,
 		configurator: p,
+		signals:      nil,
 	}
 }
 
+// Accepts returns a copy of the Builder which only accepts the signals
+// defined in `s`. Signals that are not defined, or carrying data of a length
+// not allowed by the schema will be rejected as a ProtocolError before they
+// reach the command
+func (b Builder) Accepts(s Signals) Builder {
+	b.signals = &s
+
+	return b
+}
+
 // Commands contains data of all commands
 type Commands [MaxCommandID + 1]Builder
 
@@ -81,6 +93,15 @@ func (c *Commands) Register(
 	(*c)[id] = Register(name, cb, ps)
 }
 
+// name returns the name of the command `id`
+func (c Commands) name(id byte) string {
+	if id > MaxCommandID {
+		return ""
+	}
+
+	return c[id].name
+}
+
 // Run creates command executer
 func (c Commands) Run(
 	id byte,
@@ -99,7 +120,7 @@ func (c Commands) Run(
 		return FSM{}, ErrCommandRunUndefinedCommand
 	}
 
-	return newFSM(cc.command(l, hooks, w, cfg)), nil
+	return newFSM(cc.command(l, hooks, w, cfg), cc.signals), nil
 }
 
 // Reconfigure lets commands reset configuration
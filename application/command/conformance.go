@@ -0,0 +1,151 @@
+//go:build conformance
+
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/rw"
+)
+
+// Errors
+var (
+	ErrConformanceDialDisabled = errors.New(
+		"dialing is disabled in conformance mode")
+)
+
+const (
+	conformanceReadBufSize = 4096
+	conformanceDialTimeout = 1 * time.Second
+)
+
+// conformanceDial refuses all outgoing connections, so the result of a run
+// only depends on the input
+func conformanceDial(
+	ctx context.Context, network string, address string) (net.Conn, error) {
+	return nil, ErrConformanceDialDisabled
+}
+
+// RunConformance runs the commands `cs` over the raw protocol stream read
+// from `in`, and writes the responds to `out`. There is no websocket or
+// encryption in between, and outgoing connections are refused, so the
+// commands are driven by nothing but the input.
+//
+// It returns nil when `in` is drained
+func RunConformance(
+	cs Commands,
+	in io.Reader,
+	out io.Writer,
+	l log.Logger,
+) error {
+	buf := [conformanceReadBufSize]byte{}
+	lock := sync.Mutex{}
+	handler := newHandler(
+		Configuration{
+			Dial:        conformanceDial,
+			DialTimeout: conformanceDialTimeout,
+		},
+		&cs,
+		rw.NewFetchReader(func() ([]byte, error) {
+			for {
+				rLen, rErr := in.Read(buf[:])
+				if rLen > 0 {
+					return buf[:rLen], nil
+				}
+
+				if rErr != nil {
+					return nil, rErr
+				}
+			}
+		}),
+		out,
+		&lock,
+		0,
+		0,
+		l,
+		NewHooks(configuration.HookSettings{}, l),
+	)
+
+	hErr := handler.Handle()
+	if errors.Is(hErr, io.EOF) {
+		return nil
+	}
+
+	return hErr
+}
+
+// ConformanceControl builds a control message frame carrying `data`
+func ConformanceControl(data []byte) []byte {
+	if len(data) > HeaderMaxData {
+		panic("data must not be longer than HeaderMaxData")
+	}
+
+	hd := HeaderControl
+	hd.Set(byte(len(data)))
+
+	return append([]byte{byte(hd)}, data...)
+}
+
+// ConformanceStream builds a frame that starts the command `commandID` on
+// stream `id` with the bootup `data`
+func ConformanceStream(id byte, commandID byte, data []byte) []byte {
+	hd := HeaderStream
+	hd.Set(id)
+
+	initial := streamInitialHeader{}
+	initial.set(commandID, uint16(len(data)), true)
+
+	return append([]byte{byte(hd), initial[0], initial[1]}, data...)
+}
+
+// ConformanceSignal builds a frame that sends the signal `marker` with
+// `data` to stream `id`
+func ConformanceSignal(id byte, marker byte, data []byte) []byte {
+	hd := HeaderStream
+	hd.Set(id)
+
+	sHeader := StreamHeader{}
+	sHeader.Set(marker, uint16(len(data)))
+
+	return append([]byte{byte(hd), sHeader[0], sHeader[1]}, data...)
+}
+
+// ConformanceClose builds a frame that closes stream `id`
+func ConformanceClose(id byte) []byte {
+	hd := HeaderClose
+	hd.Set(id)
+
+	return []byte{byte(hd)}
+}
+
+// ConformanceCompleted builds a frame that tells stream `id` has been closed
+func ConformanceCompleted(id byte) []byte {
+	hd := HeaderCompleted
+	hd.Set(id)
+
+	return []byte{byte(hd)}
+}
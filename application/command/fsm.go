@@ -89,19 +89,31 @@ type FSMMachine interface {
 	Release() error
 }
 
+// FSMTerminator is implemented by the FSMMachine which can be terminated
+// from outside of it's stream, i.e. by the administrator
+type FSMTerminator interface {
+	// Terminate asks the machine to disconnect from it's remote with the
+	// `reason`, and then close the stream by itself. It must be safe to call
+	// from any goroutine, and may be called more than once
+	Terminate(reason string)
+}
+
 // FSM state machine control
 type FSM struct {
-	m      FSMMachine
-	s      FSMState
-	closed bool
+	m       FSMMachine
+	s       FSMState
+	signals *Signals
+	closed  bool
 }
 
-// newFSM creates a new FSM
-func newFSM(m FSMMachine) FSM {
+// newFSM creates a new FSM. When `signals` is not nil, only the signals
+// defined in it will be accepted
+func newFSM(m FSMMachine, signals *Signals) FSM {
 	return FSM{
-		m:      m,
-		s:      nil,
-		closed: false,
+		m:       m,
+		s:       nil,
+		signals: signals,
+		closed:  false,
 	}
 }
 
@@ -117,24 +129,38 @@ func emptyFSM() FSM {
 func (f *FSM) bootup(r *rw.LimitedReader, b []byte) FSMError {
 	s, err := f.m.Bootup(r, b)
 
-	if s == nil {
-		panic("FSMState must not be nil")
-	}
-
 	if !err.Succeed() {
 		return err
 	}
 
+	if s == nil {
+		panic("FSMState must not be nil")
+	}
+
 	f.s = s
 
 	return err
 }
 
+// terminator returns the FSMTerminator of the machine, or nil when it can't
+// be terminated
+func (f *FSM) terminator() FSMTerminator {
+	t, _ := f.m.(FSMTerminator)
+
+	return t
+}
+
 // running returns whether or not current FSM is running
 func (f *FSM) running() bool {
 	return f.s != nil
 }
 
+// validate checks whether or not the stream header `h` is acceptable by
+// current machine
+func (f *FSM) validate(h StreamHeader) error {
+	return f.signals.validate(h)
+}
+
 // tick ticks current machine
 func (f *FSM) tick(r *rw.LimitedReader, h StreamHeader, b []byte) error {
 	if f.closed {
@@ -26,6 +26,7 @@ import (
 
 	"github.com/nirui/sshwifty/application/log"
 	"github.com/nirui/sshwifty/application/rw"
+	"github.com/nirui/sshwifty/application/streamstats"
 )
 
 // Errors
@@ -35,9 +36,6 @@ var (
 
 	ErrHandlerControlMessageTooLong = errors.New(
 		"control message was too long")
-
-	ErrHandlerInvalidControlMessage = errors.New(
-		"invalid control message")
 )
 
 // HandlerCancelSignal signals the cancel of the entire handling proccess
@@ -45,16 +43,61 @@ type HandlerCancelSignal chan struct{}
 
 const (
 	handlerReadBufLen = HeaderMaxData + 3 // (3 = 1 Header, 2 Etc)
+
+	// Writes no larger than this are considered interactive (i.e. echoes of
+	// user input, or responds to control requests), they will be sent before
+	// the larger ones that are waiting
+	handlerSenderUrgentSize = 256
+
+	// Share slot used by the writes that don't belong to any stream
+	handlerSenderSlot = HeaderMaxData + 1
+
+	// Scale of the virtual time, so the cost of the writes is not truncated
+	// too much when they're divided by the weights
+	handlerSenderVirtualScale = 1000
+
+	// Max weight of a stream
+	handlerSenderMaxWeight = 100
 )
 
 type handlerBuf [handlerReadBufLen]byte
 
-// handlerSender writes handler signal
+// handlerSenderShare is the share of the sender that a stream owns
+type handlerSenderShare struct {
+	weight uint64
+	finish uint64
+}
+
+// handlerSenderWaiting is a bulk write that waits to be scheduled
+type handlerSenderWaiting struct {
+	ticket uint64
+	start  uint64
+}
+
+// handlerSenderShares contains the share of all streams, plus the one for
+// writes which don't belong to a stream
+type handlerSenderShares [handlerSenderSlot + 1]handlerSenderShare
+
+// handlerSender writes handler signal.
+//
+// Multiple streams share the same handlerSender. To keep the interactive
+// streams responsive while others are sending bulk of data, writers of small
+// data are given priority over the ones waiting to write larger data.
+//
+// Larger writes are scheduled with start-time fair queuing, so streams
+// receive the bandwidth in proportion to their weights, and a busy stream
+// cannot monopolize the sender
 type handlerSender struct {
-	writer   io.Writer
-	lock     *sync.Mutex
-	needWait bool
-	sign     *sync.Cond
+	writer        io.Writer
+	lock          *sync.Mutex
+	needWait      bool
+	writing       bool
+	urgentWaiting int
+	bulkWaiting   []handlerSenderWaiting
+	tickets       uint64
+	virtualTime   uint64
+	shares        handlerSenderShares
+	sign          *sync.Cond
 }
 
 // pause pauses sending
@@ -93,15 +136,120 @@ func (h *handlerSender) signal(hd Header, d []byte, buf []byte) error {
 	return wErr
 }
 
+// setWeight resets the share of the stream `slot` with the given `weight`
+func (h *handlerSender) setWeight(slot byte, weight int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if weight < 1 {
+		weight = 1
+	} else if weight > handlerSenderMaxWeight {
+		weight = handlerSenderMaxWeight
+	}
+
+	h.shares[slot] = handlerSenderShare{
+		weight: uint64(weight),
+		finish: 0,
+	}
+}
+
 // Write sends data
 func (h *handlerSender) Write(b []byte) (int, error) {
+	return h.write(b, handlerSenderSlot, false, nil)
+}
+
+// writeIgnorePause sends data even when the sender is paused
+func (h *handlerSender) writeIgnorePause(b []byte) (int, error) {
+	return h.write(b, handlerSenderSlot, true, nil)
+}
+
+// queueBulk registers a bulk write of `size` bytes for the stream `slot`, and
+// returns the ticket of it
+func (h *handlerSender) queueBulk(slot byte, size int) uint64 {
+	share := &h.shares[slot]
+	weight := max(share.weight, 1)
+	start := max(share.finish, h.virtualTime)
+
+	share.finish = start + uint64(size)*handlerSenderVirtualScale/weight
+
+	h.tickets++
+	h.bulkWaiting = append(h.bulkWaiting, handlerSenderWaiting{
+		ticket: h.tickets,
+		start:  start,
+	})
+
+	return h.tickets
+}
+
+// nextBulk returns the index of the bulk write in the waiting list which
+// should be scheduled next, that is the one with the smallest start time
+func (h *handlerSender) nextBulk() int {
+	next := 0
+
+	for i, w := range h.bulkWaiting {
+		if w.start < h.bulkWaiting[next].start {
+			next = i
+		}
+	}
+
+	return next
+}
+
+func (h *handlerSender) write(
+	b []byte,
+	slot byte,
+	ignorePause bool,
+	stats *streamstats.Stream,
+) (int, error) {
+	// Data waiting to be sent is held in the memory of the stream
+	stats.Hold(len(b))
+	defer stats.Release(len(b))
+
 	h.lock.Lock()
 	defer h.lock.Unlock()
 
-	for h.needWait {
+	urgent := len(b) <= handlerSenderUrgentSize
+	ticket := uint64(0)
+
+	if urgent {
+		h.urgentWaiting++
+	} else {
+		ticket = h.queueBulk(slot, len(b))
+	}
+
+	for (h.needWait && !ignorePause) ||
+		h.writing ||
+		(!urgent && (h.urgentWaiting > 0 ||
+			h.bulkWaiting[h.nextBulk()].ticket != ticket)) {
 		h.sign.Wait()
 	}
 
+	if urgent {
+		h.urgentWaiting--
+	} else {
+		next := h.nextBulk()
+
+		h.virtualTime = h.bulkWaiting[next].start
+		h.bulkWaiting = append(
+			h.bulkWaiting[:next], h.bulkWaiting[next+1:]...)
+	}
+
+	// Release the lock during writing so other writers can queue up and be
+	// scheduled when the current write is done
+	h.writing = true
+	h.lock.Unlock()
+
+	defer func() {
+		h.lock.Lock()
+		h.writing = false
+		h.sign.Broadcast()
+	}()
+
+	done := stats.Measure()
+	defer done()
+
+	stats.Sent(len(b))
+
 	return h.writer.Write(b)
 }
 
@@ -110,14 +258,23 @@ func (h *handlerSender) Write(b []byte) (int, error) {
 type streamHandlerSender struct {
 	*handlerSender
 
+	id        byte
 	sendDelay time.Duration
+	stats     *streamstats.Stream
 }
 
 // Write sends data
 func (h streamHandlerSender) Write(b []byte) (int, error) {
 	defer time.Sleep(h.sendDelay)
 
-	return h.handlerSender.Write(b)
+	return h.handlerSender.write(b, h.id, false, h.stats)
+}
+
+// SetWeight sets the weight of the stream. Streams that have a higher weight
+// will receive a larger share of the bandwidth when multiple streams are
+// sending at the same time
+func (h streamHandlerSender) SetWeight(weight int) {
+	h.handlerSender.setWeight(h.id, weight)
 }
 
 // Handler client stream control
@@ -151,10 +308,16 @@ func newHandler(
 		commands: commands,
 		receiver: receiver,
 		sender: handlerSender{
-			writer:   sender,
-			lock:     senderLock,
-			needWait: false,
-			sign:     sync.NewCond(senderLock),
+			writer:        sender,
+			lock:          senderLock,
+			needWait:      false,
+			writing:       false,
+			urgentWaiting: 0,
+			bulkWaiting:   make([]handlerSenderWaiting, 0, 4),
+			tickets:       0,
+			virtualTime:   0,
+			shares:        handlerSenderShares{},
+			sign:          sync.NewCond(senderLock),
 		},
 		senderPaused: false,
 		receiveDelay: receiveDelay,
@@ -186,8 +349,13 @@ func (e *Handler) handleControl(d byte, l log.Logger) error {
 		return rErr
 	}
 
-	if rLen <= 0 {
-		return ErrHandlerInvalidControlMessage
+	vErr := validateControl(buf[:rLen])
+
+	if vErr != nil {
+		hd := HeaderControl
+		hd.Set(d)
+
+		return newProtocolError(hd, vErr)
 	}
 
 	switch buf[0] {
@@ -200,16 +368,7 @@ func (e *Handler) handleControl(d byte, l log.Logger) error {
 		e.rBuf[0] = byte(hd)
 		e.rBuf[1] = HeaderControlEcho
 
-		var wErr error
-
-		if !e.senderPaused {
-			_, wErr = e.sender.Write(e.rBuf[:rLen+1])
-		} else {
-			e.sender.lock.Lock()
-			defer e.sender.lock.Unlock()
-
-			_, wErr = e.sender.writer.Write(e.rBuf[:rLen+1])
-		}
+		_, wErr := e.sender.writeIgnorePause(e.rBuf[:rLen+1])
 
 		return wErr
 
@@ -270,9 +429,13 @@ func (e *Handler) handleStream(h Header, d byte, l log.Logger) error {
 		defer e.sender.pause()
 	}
 
+	e.sender.setWeight(h.Data(), 1)
+
 	return st.reinit(h, &e.receiver, streamHandlerSender{
 		handlerSender: &e.sender,
+		id:            h.Data(),
 		sendDelay:     e.sendDelay,
+		stats:         nil,
 	}, l, e.hooks, e.commands, e.cfg, e.rBuf[:])
 }
 
@@ -317,12 +480,31 @@ func (e *Handler) handleCompleted(d byte, l log.Logger) error {
 
 // Handle starts handling
 func (e *Handler) Handle() error {
+	pushDone := make(chan struct{})
+	pushWait := sync.WaitGroup{}
+
+	pushWait.Add(2)
+	go func() {
+		defer pushWait.Done()
+
+		e.sendPresetStatuses(pushDone)
+	}()
+	go func() {
+		defer pushWait.Done()
+
+		e.sendNotices(pushDone)
+	}()
+
 	defer func() {
+		close(pushDone)
+
 		if e.senderPaused {
 			e.sender.resume()
 			e.senderPaused = false
 		}
 
+		pushWait.Wait()
+
 		e.streams.shutdown()
 	}()
 
@@ -362,7 +544,11 @@ func (e *Handler) Handle() error {
 		}
 
 		if dErr != nil {
-			l.Debug("Request failed: %s", dErr)
+			if errors.As(dErr, &ProtocolError{}) {
+				l.Warning("Request rejected: %s", dErr)
+			} else {
+				l.Debug("Request failed: %s", dErr)
+			}
 
 			return dErr
 		}
@@ -87,7 +87,7 @@ func TestHandlerHandleEcho(t *testing.T) {
 		0,
 		0,
 		log.NewDitch(),
-		NewHooks(configuration.HookSettings{}),
+		NewHooks(configuration.HookSettings{}, log.NewDitch()),
 	)
 
 	hErr := handler.Handle()
@@ -0,0 +1,91 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+// noticeChunkMaxSize is the max size of the text carried by each Control
+// message of a notice
+const noticeChunkMaxSize = HeaderMaxData - 2
+
+// Flags of the notice Control message
+const (
+	noticeFlagMore = 0x01
+)
+
+// sendNotices sends the notices of the administrator to the client until
+// `done` is closed
+//
+// Notices longer than a single Control message are split into multiple ones,
+// all but the last of them are flagged with noticeFlagMore. The client joins
+// the texts of them to build the notice
+//
+// Format of the Control message:
+//
+//	+------+-------+--------------+
+//	| 0x04 | Flags | Text (UTF-8) |
+//	+------+-------+--------------+
+//	  1 byte 1 byte   0-61 bytes
+func (e *Handler) sendNotices(done <-chan struct{}) {
+	notices, cancel := e.cfg.Broadcaster.Subscribe()
+	defer cancel()
+
+	if notices == nil {
+		return
+	}
+
+	buf := [HeaderMaxData + 1]byte{}
+
+	for {
+		var notice string
+
+		select {
+		case notice = <-notices:
+		case <-done:
+			return
+		}
+
+		text := []byte(notice)
+
+		for {
+			chunk := text[:min(len(text), noticeChunkMaxSize)]
+			text = text[len(chunk):]
+
+			hd := HeaderControl
+			hd.Set(byte(len(chunk) + 2))
+
+			buf[0] = byte(hd)
+			buf[1] = HeaderControlNotice
+			buf[2] = 0
+
+			if len(text) > 0 {
+				buf[2] |= noticeFlagMore
+			}
+
+			copy(buf[3:], chunk)
+
+			if _, wErr := e.sender.Write(buf[:len(chunk)+3]); wErr != nil {
+				e.log.Debug("Unable to send notice: %s", wErr)
+
+				return
+			}
+
+			if len(text) <= 0 {
+				break
+			}
+		}
+	}
+}
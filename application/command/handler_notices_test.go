@@ -0,0 +1,91 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/broadcast"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/rw"
+)
+
+func TestHandlerSendNotices(t *testing.T) {
+	w := dummyWriter{
+		written: make([]byte, 0, 128),
+	}
+	lock := sync.Mutex{}
+	b := broadcast.New()
+
+	handler := newHandler(
+		Configuration{Broadcaster: b},
+		nil,
+		rw.NewFetchReader(testDummyFetchGen(nil)),
+		&w,
+		&lock,
+		0,
+		0,
+		log.NewDitch(),
+		NewHooks(configuration.HookSettings{}, log.NewDitch()),
+	)
+
+	done := make(chan struct{})
+	sent := make(chan struct{})
+
+	go func() {
+		defer close(sent)
+
+		handler.sendNotices(done)
+	}()
+
+	notice := strings.Repeat("A", noticeChunkMaxSize) + "BC"
+
+	for b.Send(notice) <= 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	expected := []byte{byte(HeaderControl | HeaderMaxData),
+		HeaderControlNotice, noticeFlagMore}
+	expected = append(expected, strings.Repeat("A", noticeChunkMaxSize)...)
+	expected = append(expected,
+		byte(HeaderControl|4), HeaderControlNotice, 0, 'B', 'C')
+
+	for i := 0; ; i++ {
+		lock.Lock()
+		written := len(w.written)
+		lock.Unlock()
+
+		if written >= len(expected) || i > 1000 {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	close(done)
+	<-sent
+
+	if !bytes.Equal(w.written, expected) {
+		t.Errorf("Expecting %v, got %v", expected, w.written)
+	}
+}
@@ -0,0 +1,79 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"github.com/nirui/sshwifty/application/watcher"
+)
+
+// sendPresetStatuses sends the availability State of the watched Presets to
+// the client, first all of them, then only the changed ones, until `done` is
+// closed
+//
+// Format of the Control message:
+//
+//	+------+-------------------+-------+
+//	| 0x03 | Preset index (BE) | State |
+//	+------+-------------------+-------+
+//	  1 byte       2 bytes       1 byte
+func (e *Handler) sendPresetStatuses(done <-chan struct{}) {
+	changed, cancel := e.cfg.Watcher.Subscribe()
+	defer cancel()
+
+	if changed == nil {
+		return
+	}
+
+	sent := map[int]watcher.State{}
+	buf := [5]byte{}
+
+	for {
+		for _, s := range e.cfg.Watcher.Statuses() {
+			if state, ok := sent[s.Preset]; ok && state == s.State {
+				continue
+			}
+
+			if s.Preset > 0xffff {
+				continue
+			}
+
+			hd := HeaderControl
+			hd.Set(4)
+
+			buf[0] = byte(hd)
+			buf[1] = HeaderControlPresetStatus
+			buf[2] = byte(s.Preset >> 8)
+			buf[3] = byte(s.Preset)
+			buf[4] = byte(s.State)
+
+			if _, wErr := e.sender.Write(buf[:]); wErr != nil {
+				e.log.Debug("Unable to send Preset status: %s", wErr)
+
+				return
+			}
+
+			sent[s.Preset] = s.State
+		}
+
+		select {
+		case <-changed:
+		case <-done:
+			return
+		}
+	}
+}
@@ -190,7 +190,7 @@ func TestHandlerHandleStream(t *testing.T) {
 		0,
 		0,
 		log.NewDitch(),
-		NewHooks(configuration.HookSettings{}))
+		NewHooks(configuration.HookSettings{}, log.NewDitch()))
 
 	go func() {
 		stInitialHeader := streamInitialHeader{}
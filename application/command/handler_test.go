@@ -16,3 +16,123 @@
 // along with this program.  If not, see <https://www.gnu.org/licenses/>.
 
 package command
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type testBlockingWriter struct {
+	lock    sync.Mutex
+	release chan struct{}
+	written []int
+}
+
+func (w *testBlockingWriter) Write(b []byte) (int, error) {
+	<-w.release
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.written = append(w.written, len(b))
+
+	return len(b), nil
+}
+
+func TestHandlerSenderPrioritizesUrgentWrites(t *testing.T) {
+	w := &testBlockingWriter{release: make(chan struct{})}
+	lock := sync.Mutex{}
+	s := handlerSender{
+		writer: w,
+		lock:   &lock,
+		sign:   sync.NewCond(&lock),
+	}
+
+	bulk := make([]byte, handlerSenderUrgentSize+1)
+	urgent := make([]byte, 1)
+	wg := sync.WaitGroup{}
+
+	write := func(b []byte) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			s.Write(b)
+		}()
+	}
+
+	waitWriting := func() {
+		for {
+			lock.Lock()
+			writing := s.writing
+			lock.Unlock()
+
+			if writing {
+				return
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// The first bulk write blocks the sender, the following ones queue up
+	write(bulk)
+	waitWriting()
+
+	write(bulk)
+	time.Sleep(10 * time.Millisecond)
+
+	write(urgent)
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		w.release <- struct{}{}
+	}
+
+	wg.Wait()
+
+	expected := []int{len(bulk), len(urgent), len(bulk)}
+	for i := range expected {
+		if w.written[i] != expected[i] {
+			t.Errorf("Expecting writes in order of %v, got %v",
+				expected, w.written)
+			return
+		}
+	}
+}
+
+func TestHandlerSenderWeightedFairQueuing(t *testing.T) {
+	lock := sync.Mutex{}
+	s := handlerSender{
+		lock: &lock,
+		sign: sync.NewCond(&lock),
+	}
+
+	s.setWeight(1, 3)
+	s.setWeight(2, 1)
+
+	owners := map[uint64]byte{}
+
+	for i := 0; i < 6; i++ {
+		owners[s.queueBulk(1, 1000)] = 1
+		owners[s.queueBulk(2, 1000)] = 2
+	}
+
+	served := map[byte]int{}
+
+	for i := 0; i < 8; i++ {
+		next := s.nextBulk()
+
+		served[owners[s.bulkWaiting[next].ticket]]++
+		s.virtualTime = s.bulkWaiting[next].start
+		s.bulkWaiting = append(s.bulkWaiting[:next], s.bulkWaiting[next+1:]...)
+	}
+
+	if served[1] != 6 || served[2] != 2 {
+		t.Errorf("Expecting streams to be served in proportion of 3:1, "+
+			"got %d:%d", served[1], served[2])
+		return
+	}
+}
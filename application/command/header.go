@@ -74,6 +74,8 @@ const (
 	HeaderControlEcho         = 0x00
 	HeaderControlPauseStream  = 0x01
 	HeaderControlResumeStream = 0x02
+	HeaderControlPresetStatus = 0x03
+	HeaderControlNotice       = 0x04
 )
 
 // Consts
@@ -0,0 +1,160 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// AsyncHookStats contains the statistics of asynchronous Hook executions
+type AsyncHookStats struct {
+	Queued    int    `json:"queued"`
+	Workers   int    `json:"workers"`
+	Completed uint64 `json:"completed"`
+	Failed    uint64 `json:"failed"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// asyncHookJob is a pending asynchronous Hook execution
+type asyncHookJob struct {
+	t      configuration.HookType
+	index  int
+	hook   Hook
+	params HookParameters
+}
+
+// asyncHookPool executes asynchronous Hooks on a bounded amount of workers.
+// Workers are started on demand and exit when there is nothing left in the
+// queue, so the pool don't have to be closed
+type asyncHookPool struct {
+	lock      sync.Mutex
+	queue     chan asyncHookJob
+	workers   int
+	running   int
+	timeout   time.Duration
+	log       log.Logger
+	completed uint64
+	failed    uint64
+	dropped   uint64
+}
+
+func newAsyncHookPool(
+	workers int,
+	queue int,
+	timeout time.Duration,
+	l log.Logger,
+) *asyncHookPool {
+	return &asyncHookPool{
+		queue:   make(chan asyncHookJob, queue),
+		workers: workers,
+		timeout: timeout,
+		log:     l,
+	}
+}
+
+// submit queues the `job`. It returns false when the queue is full, in which
+// case the job is dropped
+func (p *asyncHookPool) submit(job asyncHookJob) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	select {
+	case p.queue <- job:
+	default:
+		p.dropped++
+		return false
+	}
+
+	if p.running < p.workers {
+		p.running++
+		go p.work()
+	}
+
+	return true
+}
+
+func (p *asyncHookPool) work() {
+	for {
+		select {
+		case job := <-p.queue:
+			p.run(job)
+
+		default:
+			p.lock.Lock()
+			if len(p.queue) > 0 {
+				p.lock.Unlock()
+				continue
+			}
+			p.running--
+			p.lock.Unlock()
+			return
+		}
+	}
+}
+
+func (p *asyncHookPool) run(job asyncHookJob) {
+	// Parameters are shared with the synchronous Hooks, and the Deadline of
+	// the asynchronous one is different, so a copy is needed
+	params := maps.Clone(job.params).Insert(
+		"Deadline",
+		time.Now().Add(p.timeout).Format(hooksExecDeadlineFormat),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	l := p.log.Context("Hook (%s, %d)", job.t, job.index)
+
+	err := job.hook.Run(ctx, params, NewDefaultHookOutput(l, func(
+		b []byte,
+	) (int, error) {
+		// There is no one to receive the output of an asynchronous Hook
+		return len(b), nil
+	}))
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err != nil {
+		p.failed++
+		l.Warning("Asynchronous hook has failed: %s", err)
+		return
+	}
+
+	p.completed++
+}
+
+// stats returns the current statistics
+func (p *asyncHookPool) stats() AsyncHookStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return AsyncHookStats{
+		Queued:    len(p.queue),
+		Workers:   p.running,
+		Completed: p.completed,
+		Failed:    p.failed,
+		Dropped:   p.dropped,
+	}
+}
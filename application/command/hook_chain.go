@@ -0,0 +1,150 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+// Names of the HookParameters that are used to match a hookCondition
+const (
+	hookParameterRemoteAddress = "Remote Address"
+	hookParameterUserGroups    = "User Groups"
+	hookParameterPresetTags    = "Preset Tags"
+)
+
+const (
+	hookParameterListSeparator = ","
+)
+
+// joinHookParameterList joins the `items` into a single HookParameters value
+func joinHookParameterList(items []string) string {
+	return strings.Join(items, hookParameterListSeparator)
+}
+
+// splitHookParameterList splits a HookParameters value joined by
+// joinHookParameterList
+func splitHookParameterList(value string) []string {
+	if len(value) <= 0 {
+		return nil
+	}
+	return strings.Split(value, hookParameterListSeparator)
+}
+
+// hookCondition is the parsed configuration.HookCondition
+type hookCondition struct {
+	presetTags []string
+	networks   []*net.IPNet
+	userGroups []string
+}
+
+func newHookCondition(c configuration.HookCondition) hookCondition {
+	networks := make([]*net.IPNet, 0, len(c.Networks))
+	for i := range c.Networks {
+		_, n, err := net.ParseCIDR(c.Networks[i])
+		if err != nil {
+			// Networks should already been verified by the configuration
+			panic("Invalid network: " + err.Error())
+		}
+		networks = append(networks, n)
+	}
+	return hookCondition{
+		presetTags: c.PresetTags,
+		networks:   networks,
+		userGroups: c.UserGroups,
+	}
+}
+
+// matchAny returns true when any of the `values` is one of the `expected`, or
+// there is nothing expected
+func (h hookCondition) matchAny(expected []string, values []string) bool {
+	if len(expected) <= 0 {
+		return true
+	}
+	for i := range values {
+		if slices.Contains(expected, values[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchNetworks returns true when the remote is inside any of the networks.
+// Host names of the remote will be resolved for the matching
+func (h hookCondition) matchNetworks(ctx context.Context, address string) bool {
+	if len(h.networks) <= 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ips := []net.IP{}
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return false
+		}
+		for i := range addrs {
+			ips = append(ips, addrs[i].IP)
+		}
+	}
+	for i := range h.networks {
+		for j := range ips {
+			if h.networks[i].Contains(ips[j]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// match returns true when all conditions are met by the `params`
+func (h hookCondition) match(ctx context.Context, params HookParameters) bool {
+	return h.matchAny(
+		h.presetTags, splitHookParameterList(params[hookParameterPresetTags]),
+	) && h.matchAny(
+		h.userGroups, splitHookParameterList(params[hookParameterUserGroups]),
+	) && h.matchNetworks(ctx, params[hookParameterRemoteAddress])
+}
+
+// chainedHook is a Hook in a Hook chain
+type chainedHook struct {
+	hook      Hook
+	when      hookCondition
+	onSuccess configuration.HookAction
+	onFailure configuration.HookAction
+	async     bool
+}
+
+func newChainedHook(h Hook, cmd configuration.HookCommand) chainedHook {
+	return chainedHook{
+		hook:      h,
+		when:      newHookCondition(cmd.When),
+		onSuccess: cmd.SuccessAction(),
+		onFailure: cmd.FailureAction(),
+		async:     cmd.Async,
+	}
+}
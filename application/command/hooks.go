@@ -2,8 +2,8 @@ package command
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/nirui/sshwifty/application/configuration"
@@ -17,6 +17,29 @@ func NewHookParameters(initialCapacity int) HookParameters {
 	return make(HookParameters, initialCapacity)
 }
 
+// NewRemoteHookParameters creates HookParameters for Hooks that are executed
+// on behalf of the stream `streamID` when it's connecting to a remote, so the
+// hook can tell who is connecting to where
+func NewRemoteHookParameters(
+	cfg Configuration,
+	streamID byte,
+	remoteType string,
+	remoteAddress string,
+) HookParameters {
+	preset, _ := cfg.Preset(remoteType, remoteAddress)
+
+	return NewHookParameters(9).
+		Insert("Remote Type", remoteType).
+		Insert(hookParameterRemoteAddress, remoteAddress).
+		Insert("User", cfg.User).
+		Insert(hookParameterUserGroups, joinHookParameterList(cfg.UserGroups)).
+		Insert("Client IP", cfg.ClientIP()).
+		Insert("Stream ID", strconv.FormatUint(uint64(streamID), 10)).
+		Insert("Preset", preset.Title).
+		Insert(hookParameterPresetTags, joinHookParameterList(preset.Tags)).
+		Insert("No Trace", strconv.FormatBool(preset.NoTrace))
+}
+
 // Insert inserts or replace the value to given `val` under parameter name
 // `name`, return a new HookParameters with the new value applied
 func (p HookParameters) Insert(name string, val string) HookParameters {
@@ -92,20 +115,22 @@ type HookConfiguration struct {
 	Timeout time.Duration
 }
 
-// hookTypes contains registered Hooks
-type hookTypes map[configuration.HookType][]Hook
+// hookTypes contains registered Hook chains
+type hookTypes map[configuration.HookType][]chainedHook
 
-// acquire fetches all Hook registered under `t`
-func (h *hookTypes) acquire(t configuration.HookType) (p []Hook, got bool) {
+// acquire fetches the Hook chain registered under `t`
+func (h *hookTypes) acquire(
+	t configuration.HookType,
+) (p []chainedHook, got bool) {
 	p, got = (*h)[t]
 	return
 }
 
-// Register register a Hook `p`
-func (h *hookTypes) register(t configuration.HookType, p Hook) {
+// Register appends a Hook `p` to the chain of `t`
+func (h *hookTypes) register(t configuration.HookType, p chainedHook) {
 	ps, found := (*h)[t]
 	if !found {
-		ps = make([]Hook, 0, 1)
+		ps = make([]chainedHook, 0, 1)
 	}
 	ps = append(ps, p)
 	(*h)[t] = ps
@@ -115,6 +140,7 @@ func (h *hookTypes) register(t configuration.HookType, p Hook) {
 type Hooks struct {
 	hooks hookTypes
 	cfg   HookConfiguration
+	async *asyncHookPool
 }
 
 // createHookForCommand creates a Hook based on given `command`
@@ -123,11 +149,12 @@ func createHookForCommand(command []string) Hook {
 }
 
 // NewHooks creates a Hooks
-func NewHooks(cfg configuration.HookSettings) Hooks {
+func NewHooks(cfg configuration.HookSettings, l log.Logger) Hooks {
 	hooks := make(hookTypes, len(cfg.Hooks))
 	for k, v := range cfg.Hooks {
 		for i := range v {
-			hooks.register(k, createHookForCommand(v[i]))
+			hooks.register(k, newChainedHook(
+				createHookForCommand(v[i].Command), v[i]))
 		}
 	}
 
@@ -136,15 +163,26 @@ func NewHooks(cfg configuration.HookSettings) Hooks {
 		cfg: HookConfiguration{
 			Timeout: cfg.Timeout,
 		},
+		async: newAsyncHookPool(
+			cfg.AsyncWorkers, cfg.AsyncQueue, cfg.Timeout, l),
 	}
 }
 
+// AsyncStats returns the statistics of asynchronous Hook executions
+func (h *Hooks) AsyncStats() AsyncHookStats {
+	return h.async.stats()
+}
+
 // Constants for Hooks.Run
 const (
 	hooksExecDeadlineFormat = time.RFC3339
 )
 
-// Run runs Hooks of given type `t`
+// Run runs the Hook chain of given type `t`. Hooks in the chain are executed
+// in order, skipping the ones which condition is not met, until one of them
+// decided to stop the chain by denying or skipping.
+//
+// It returns an error when the request is denied
 func (h *Hooks) Run(
 	ctx context.Context,
 	t configuration.HookType,
@@ -164,17 +202,41 @@ func (h *Hooks) Run(
 	timeoutCtx, timeoutCtxCancel := context.WithTimeout(ctx, h.cfg.Timeout)
 	defer timeoutCtxCancel()
 
-	errs := make([]error, 0, len(ps))
 	for i := range ps {
-		err := ps[i].Run(timeoutCtx, params, output)
-		if err == nil {
+		if !ps[i].when.match(timeoutCtx, params) {
 			continue
 		}
-		errs = append(errs, fmt.Errorf(
-			"server hook %d encountered an operational failure: %s",
-			i,
-			err,
-		))
+		if ps[i].async {
+			h.async.submit(asyncHookJob{
+				t:      t,
+				index:  i,
+				hook:   ps[i].hook,
+				params: params,
+			})
+			continue
+		}
+		err := ps[i].hook.Run(timeoutCtx, params, output)
+		action := ps[i].onSuccess
+		if err != nil {
+			action = ps[i].onFailure
+		}
+		switch action {
+		case configuration.HOOK_ACTION_DENY:
+			if err == nil {
+				return fmt.Errorf("server hook %d denied the request", i)
+			}
+			return fmt.Errorf(
+				"server hook %d encountered an operational failure: %s",
+				i,
+				err,
+			)
+		case configuration.HOOK_ACTION_SKIP:
+			return nil
+		}
+		if err != nil {
+			output.Err([]byte(fmt.Sprintf(
+				"Server hook %d failed, continuing: %s", i, err)))
+		}
 	}
-	return errors.Join(errs...)
+	return nil
 }
@@ -0,0 +1,293 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+func TestNewRemoteHookParameters(t *testing.T) {
+	cfg := Configuration{
+		ClientAddress: "192.0.2.1:51234",
+		User:          "alice",
+		UserGroups:    []string{"dev", "ops"},
+		Presets: []configuration.Preset{
+			{Title: "Telnet Box", Type: "Telnet", Host: "box.example:23"},
+			{
+				Title:   "SSH Box",
+				Type:    "SSH",
+				Host:    "box.example:22",
+				Tags:    []string{"prod", "db"},
+				NoTrace: true,
+			},
+			{Title: "Sandbox", Type: "SSH", Host: "unix_0123456789.sock"},
+		},
+	}
+
+	tests := []struct {
+		remoteType    string
+		remoteAddress string
+		preset        string
+		presetTags    string
+		noTrace       string
+	}{
+		{"SSH", "box.example:22", "SSH Box", "prod,db", "true"},
+		{"Telnet", "box.example:23", "Telnet Box", "", "false"},
+		{"SSH", "box.example:2222", "", "", "false"},
+		{"SSH", "unix_0123456789.sock:22", "Sandbox", "", "false"},
+	}
+
+	for _, test := range tests {
+		params := NewRemoteHookParameters(
+			cfg, 3, test.remoteType, test.remoteAddress)
+
+		expected := HookParameters{
+			"Remote Type":    test.remoteType,
+			"Remote Address": test.remoteAddress,
+			"User":           "alice",
+			"User Groups":    "dev,ops",
+			"Client IP":      "192.0.2.1",
+			"Stream ID":      "3",
+			"Preset":         test.preset,
+			"Preset Tags":    test.presetTags,
+			"No Trace":       test.noTrace,
+		}
+
+		if params.Items() != len(expected) {
+			t.Errorf("Expecting %d parameters, got %d",
+				len(expected), params.Items())
+		}
+
+		params.Iter(func(name, value string) {
+			if expected[name] != value {
+				t.Errorf("Expecting parameter %q to be %q, got %q",
+					name, expected[name], value)
+			}
+		})
+	}
+}
+
+type testHook struct {
+	name string
+	err  error
+	ran  *[]string
+}
+
+func (h testHook) Run(
+	ctx context.Context,
+	params HookParameters,
+	output HookOutput,
+) error {
+	*h.ran = append(*h.ran, h.name)
+	return h.err
+}
+
+type testHookOutput struct{}
+
+func (testHookOutput) Out(b []byte) (int, error) { return len(b), nil }
+func (testHookOutput) Err(b []byte) (int, error) { return len(b), nil }
+
+func TestHooksRunChain(t *testing.T) {
+	failed := errors.New("failed")
+	params := func(address, groups, tags string) HookParameters {
+		return NewHookParameters(3).
+			Insert(hookParameterRemoteAddress, address).
+			Insert(hookParameterUserGroups, groups).
+			Insert(hookParameterPresetTags, tags)
+	}
+
+	tests := []struct {
+		chain  []configuration.HookCommand
+		errs   []error
+		params HookParameters
+		ran    []string
+		denied bool
+	}{
+		{ // Failure denies by default
+			chain:  []configuration.HookCommand{{}, {}},
+			errs:   []error{failed, nil},
+			params: params("192.0.2.1:22", "", ""),
+			ran:    []string{"0"},
+			denied: true,
+		},
+		{ // Tolerated failure
+			chain: []configuration.HookCommand{
+				{OnFailure: configuration.HOOK_ACTION_CONTINUE},
+				{},
+			},
+			errs:   []error{failed, nil},
+			params: params("192.0.2.1:22", "", ""),
+			ran:    []string{"0", "1"},
+			denied: false,
+		},
+		{ // Short-circuit
+			chain: []configuration.HookCommand{
+				{OnSuccess: configuration.HOOK_ACTION_SKIP},
+				{},
+			},
+			errs:   []error{nil, failed},
+			params: params("192.0.2.1:22", "", ""),
+			ran:    []string{"0"},
+			denied: false,
+		},
+		{ // Deny on success
+			chain: []configuration.HookCommand{
+				{OnSuccess: configuration.HOOK_ACTION_DENY},
+			},
+			errs:   []error{nil},
+			params: params("192.0.2.1:22", "", ""),
+			ran:    []string{"0"},
+			denied: true,
+		},
+		{ // Conditions
+			chain: []configuration.HookCommand{
+				{When: configuration.HookCondition{
+					Networks: []string{"198.51.100.0/24"},
+				}},
+				{When: configuration.HookCondition{
+					PresetTags: []string{"prod"},
+					UserGroups: []string{"ops"},
+				}},
+				{When: configuration.HookCondition{
+					Networks:   []string{"192.0.2.0/24"},
+					UserGroups: []string{"dev"},
+				}},
+				{When: configuration.HookCondition{
+					PresetTags: []string{"staging"},
+				}},
+			},
+			errs:   []error{nil, nil, nil, nil},
+			params: params("192.0.2.1:22", "dev,ops", "db,prod"),
+			ran:    []string{"1", "2"},
+			denied: false,
+		},
+	}
+
+	for i, test := range tests {
+		ran := []string{}
+		hooks := Hooks{
+			hooks: hookTypes{},
+			cfg:   HookConfiguration{Timeout: time.Second},
+		}
+		for j := range test.chain {
+			hooks.hooks.register(
+				configuration.HOOK_BEFORE_CONNECTING,
+				newChainedHook(testHook{
+					name: string(rune('0' + j)),
+					err:  test.errs[j],
+					ran:  &ran,
+				}, test.chain[j]),
+			)
+		}
+
+		err := hooks.Run(
+			context.Background(),
+			configuration.HOOK_BEFORE_CONNECTING,
+			test.params,
+			testHookOutput{},
+		)
+
+		if (err != nil) != test.denied {
+			t.Errorf("Test %d: expecting denied to be %v, got error %v",
+				i, test.denied, err)
+		}
+
+		if len(ran) != len(test.ran) {
+			t.Errorf("Test %d: expecting hooks %v to run, got %v",
+				i, test.ran, ran)
+			continue
+		}
+
+		for j := range ran {
+			if ran[j] != test.ran[j] {
+				t.Errorf("Test %d: expecting hooks %v to run, got %v",
+					i, test.ran, ran)
+				break
+			}
+		}
+	}
+}
+
+type testBlockingHook struct {
+	release chan struct{}
+	err     error
+}
+
+func (h testBlockingHook) Run(
+	ctx context.Context,
+	params HookParameters,
+	output HookOutput,
+) error {
+	<-h.release
+	return h.err
+}
+
+func TestHooksRunAsync(t *testing.T) {
+	release := make(chan struct{})
+	hooks := Hooks{
+		hooks: hookTypes{},
+		cfg:   HookConfiguration{Timeout: time.Second},
+		async: newAsyncHookPool(1, 1, time.Second, log.NewDitch()),
+	}
+	hooks.hooks.register(
+		configuration.HOOK_BEFORE_CONNECTING,
+		newChainedHook(testBlockingHook{
+			release: release,
+			err:     errors.New("failed"),
+		}, configuration.HookCommand{Async: true}),
+	)
+
+	// First one occupies the worker, second one waits in the queue, and the
+	// third one is dropped. None of them blocks or fails the request
+	for i := 0; i < 3; i++ {
+		err := hooks.Run(
+			context.Background(),
+			configuration.HOOK_BEFORE_CONNECTING,
+			NewHookParameters(0),
+			testHookOutput{},
+		)
+		if err != nil {
+			t.Fatalf("Expecting asynchronous hooks not to fail, got %s", err)
+		}
+
+		// Wait for the worker to pick up the first one
+		for i == 0 && hooks.AsyncStats().Queued > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := hooks.AsyncStats()
+		if stats.Failed == 2 && stats.Dropped == 1 && stats.Workers == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected stats %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
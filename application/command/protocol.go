@@ -0,0 +1,148 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors
+var (
+	ErrProtocolUnknownControlMessage = errors.New(
+		"unknown control message")
+
+	ErrProtocolMalformedControlMessage = errors.New(
+		"malformed control message")
+
+	ErrProtocolUnknownSignal = errors.New(
+		"unknown stream signal")
+
+	ErrProtocolSignalDataTooShort = errors.New(
+		"stream signal data was too short")
+
+	ErrProtocolSignalDataTooLong = errors.New(
+		"stream signal data was too long")
+)
+
+// ProtocolError is returned when the client sent a message that does not
+// comply with the protocol. The connection should not be used once it's
+// returned, because there is no way of telling where the next valid message
+// starts
+type ProtocolError struct {
+	Header Header
+	Err    error
+}
+
+// newProtocolError creates a new ProtocolError
+func newProtocolError(h Header, err error) ProtocolError {
+	return ProtocolError{
+		Header: h,
+		Err:    err,
+	}
+}
+
+// Error returns the error message
+func (p ProtocolError) Error() string {
+	return fmt.Sprintf("protocol violation on %s: %s", p.Header, p.Err)
+}
+
+// Unwrap returns the underlaying error
+func (p ProtocolError) Unwrap() error {
+	return p.Err
+}
+
+// SignalSchema describes the data which can be carried by a stream signal
+type SignalSchema struct {
+	defined bool
+	min     uint16
+	max     uint16
+}
+
+// Signal creates a SignalSchema which accepts data that is at least `min`
+// and at most `max` bytes long
+func Signal(min, max uint16) SignalSchema {
+	if min > max {
+		panic("min must not be greater than max")
+	}
+
+	if max > StreamHeaderMaxLength {
+		panic("max must not be greater than StreamHeaderMaxLength")
+	}
+
+	return SignalSchema{
+		defined: true,
+		min:     min,
+		max:     max,
+	}
+}
+
+// validate checks whether or not the data length `n` is acceptable
+func (s SignalSchema) validate(n uint16) error {
+	if !s.defined {
+		return ErrProtocolUnknownSignal
+	}
+
+	if n < s.min {
+		return ErrProtocolSignalDataTooShort
+	}
+
+	if n > s.max {
+		return ErrProtocolSignalDataTooLong
+	}
+
+	return nil
+}
+
+// Signals contains the SignalSchema of every signal marker that a command
+// accepts. Markers which are not defined will be rejected
+type Signals [StreamHeaderMaxMarker + 1]SignalSchema
+
+// validate checks whether or not the stream header `h` is acceptable
+func (s *Signals) validate(h StreamHeader) error {
+	if s == nil {
+		return nil
+	}
+
+	return s[h.Marker()].validate(h.Length())
+}
+
+// validateControl checks whether or not the control message `m` is well
+// formed
+func validateControl(m []byte) error {
+	if len(m) <= 0 {
+		return ErrProtocolMalformedControlMessage
+	}
+
+	switch m[0] {
+	case HeaderControlEcho:
+		return nil
+
+	case HeaderControlPauseStream:
+		fallthrough
+	case HeaderControlResumeStream:
+		if len(m) != 1 {
+			return ErrProtocolMalformedControlMessage
+		}
+
+		return nil
+
+	default:
+		return ErrProtocolUnknownControlMessage
+	}
+}
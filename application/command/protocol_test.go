@@ -0,0 +1,154 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/rw"
+)
+
+func testProtocolHandle(cmds *Commands, s []byte) error {
+	w := dummyWriter{
+		written: make([]byte, 0, 64),
+	}
+	lock := sync.Mutex{}
+
+	handler := newHandler(
+		Configuration{},
+		cmds,
+		rw.NewFetchReader(testDummyFetchGen(s)),
+		&w,
+		&lock,
+		0,
+		0,
+		log.NewDitch(),
+		NewHooks(configuration.HookSettings{}, log.NewDitch()),
+	)
+
+	return handler.Handle()
+}
+
+type dummyProtocolCommand struct{}
+
+func newDummyProtocolCommand(
+	l log.Logger,
+	h Hooks,
+	w StreamResponder,
+	cfg Configuration,
+) FSMMachine {
+	return dummyProtocolCommand{}
+}
+
+func (d dummyProtocolCommand) Bootup(
+	r *rw.LimitedReader,
+	b []byte,
+) (FSMState, FSMError) {
+	return func(f *FSM, r *rw.LimitedReader, h StreamHeader, b []byte) error {
+		panic("Invalid signal must not reach the command")
+	}, NoFSMError()
+}
+
+func (d dummyProtocolCommand) Close() error {
+	return nil
+}
+
+func (d dummyProtocolCommand) Release() error {
+	return nil
+}
+
+func TestProtocolControlMessage(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		expected error
+	}{
+		{
+			[]byte{byte(HeaderControl | 2), HeaderControlPauseStream, 0},
+			ErrProtocolMalformedControlMessage,
+		},
+		{
+			[]byte{byte(HeaderControl | 1), HeaderControlPresetStatus},
+			ErrProtocolUnknownControlMessage,
+		},
+		{
+			[]byte{byte(HeaderControl | 0)},
+			ErrProtocolMalformedControlMessage,
+		},
+	}
+
+	for i, test := range tests {
+		hErr := testProtocolHandle(nil, test.data)
+
+		if !errors.As(hErr, &ProtocolError{}) {
+			t.Errorf("Test %d: Expecting a ProtocolError, got %v instead",
+				i, hErr)
+
+			return
+		}
+
+		if !errors.Is(hErr, test.expected) {
+			t.Errorf("Test %d: Expecting error %q, got %q instead",
+				i, test.expected, hErr)
+
+			return
+		}
+	}
+}
+
+func TestProtocolStreamSignal(t *testing.T) {
+	cmds := Commands{}
+	cmds[0] = Register("name", newDummyProtocolCommand, nil).
+		Accepts(Signals{0: Signal(1, 4)})
+
+	stInitialHeader := streamInitialHeader{}
+	stInitialHeader.set(0, 0, true)
+
+	tests := []struct {
+		marker   byte
+		length   uint16
+		expected error
+	}{
+		{0, 0, ErrProtocolSignalDataTooShort},
+		{0, 5, ErrProtocolSignalDataTooLong},
+		{1, 1, ErrProtocolUnknownSignal},
+	}
+
+	for i, test := range tests {
+		stHeader := StreamHeader{}
+		stHeader.Set(test.marker, test.length)
+
+		data := []byte{
+			byte(HeaderStream | 1), stInitialHeader[0], stInitialHeader[1],
+			byte(HeaderStream | 1), stHeader[0], stHeader[1],
+		}
+		data = append(data, make([]byte, test.length)...)
+
+		hErr := testProtocolHandle(&cmds, data)
+
+		if !errors.Is(hErr, test.expected) {
+			t.Errorf("Test %d: Expecting error %q, got %v instead",
+				i, test.expected, hErr)
+
+			return
+		}
+	}
+}
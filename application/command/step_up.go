@@ -0,0 +1,88 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package command
+
+import (
+	"context"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/stepup"
+)
+
+// stepUpRule is the parsed configuration.StepUpRule
+type stepUpRule struct {
+	when   hookCondition
+	method stepup.Method
+}
+
+// StepUp requires the users to authenticate again before connecting to the
+// remotes that match the step-up rules
+type StepUp struct {
+	rules    []stepUpRule
+	verifier *stepup.Verifier
+}
+
+// NewStepUp creates a new StepUp
+func NewStepUp(
+	rules []configuration.StepUpRule,
+	verifier *stepup.Verifier,
+) *StepUp {
+	r := make([]stepUpRule, 0, len(rules))
+	for i := range rules {
+		r = append(r, stepUpRule{
+			when:   newHookCondition(rules[i].When),
+			method: rules[i].Method,
+		})
+	}
+	return &StepUp{
+		rules:    r,
+		verifier: verifier,
+	}
+}
+
+// Required returns the method of the step-up authentication that the user
+// must pass before connecting to the remote described by the `params`. The
+// method of the first matched rule is returned, and `required` is false when
+// none of the rules is matched
+func (s *StepUp) Required(
+	ctx context.Context,
+	params HookParameters,
+) (method stepup.Method, required bool) {
+	if s == nil {
+		return "", false
+	}
+	for i := range s.rules {
+		if s.rules[i].when.match(ctx, params) {
+			return s.rules[i].method, true
+		}
+	}
+	return "", false
+}
+
+// Verify returns nil when the `answer` of the `user` passes the step-up
+// authentication of the `method`
+func (s *StepUp) Verify(
+	method stepup.Method,
+	user string,
+	answer []byte,
+) error {
+	if s == nil || s.verifier == nil {
+		return stepup.ErrRejected
+	}
+	return s.verifier.Verify(method, user, answer)
+}
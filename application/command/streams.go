@@ -23,6 +23,7 @@ import (
 
 	"github.com/nirui/sshwifty/application/log"
 	"github.com/nirui/sshwifty/application/rw"
+	"github.com/nirui/sshwifty/application/streamstats"
 )
 
 // Errors
@@ -47,6 +48,11 @@ type StreamError uint16
 const (
 	StreamErrorCommandUndefined      StreamError = 0x01
 	StreamErrorCommandFailedToBootup StreamError = 0x02
+
+	// StreamErrorShuttingDown is sent when the stream is refused because
+	// the server is shutting down. It's the max value the initial header can
+	// carry, so it doesn't collide with the errors of the commands
+	StreamErrorShuttingDown StreamError = 0x07ff
 )
 
 // StreamHeader contains data of the stream header
@@ -208,6 +214,24 @@ func (w StreamResponder) write(mk byte, b []byte, buf []byte) (int, error) {
 	return len(b), wErr
 }
 
+// StreamID returns the ID of the stream which the StreamResponder responds to
+func (w StreamResponder) StreamID() byte {
+	return w.h.Data()
+}
+
+// SetWeight sets the weight of the stream, which determines the share of the
+// bandwidth the stream receives when competing with other streams. Valid
+// weight is 1 to 100
+func (w StreamResponder) SetWeight(weight int) {
+	w.w.SetWeight(weight)
+}
+
+// Describe sets the remote which the stream is connected to, so it can be
+// identified in the resource usage of the streams
+func (w StreamResponder) Describe(remote string) {
+	w.w.stats.Describe(remote)
+}
+
 // HeaderSize returns the size of header
 func (w StreamResponder) HeaderSize() int {
 	return 3
@@ -288,6 +312,7 @@ func (w StreamResponder) Signal(signal Header) error {
 type stream struct {
 	f      FSM
 	closed bool
+	stats  *streamstats.Stream
 }
 
 type streams [HeaderMaxData + 1]stream
@@ -296,6 +321,7 @@ func newStream() stream {
 	return stream{
 		f:      emptyFSM(),
 		closed: false,
+		stats:  nil,
 	}
 }
 
@@ -357,10 +383,33 @@ func (c *stream) reinit(
 
 	l = l.Context("Command (%d)", hd.command())
 
+	if cfg.Sessions.Draining() {
+		rr := rw.NewLimitedReader(r, int(hd.data()))
+		defer rr.Ditch(b)
+
+		hd.set(hd.command(), uint16(StreamErrorShuttingDown), false)
+		hd.signal(w.handlerSender, h, b)
+
+		l.Warning("Refused to start the command as the server is " +
+			"shutting down")
+
+		return nil
+	}
+
+	w.stats = cfg.Streams.Open(streamstats.Info{
+		Client:  cfg.ClientAddress,
+		User:    cfg.User,
+		Tenant:  cfg.Tenant,
+		Command: cc.name(hd.command()),
+		Stream:  h.Data(),
+	})
+
 	ccc, cccErr := cc.Run(
 		hd.command(), l, hooks, newStreamResponder(w, h), cfg)
 
 	if cccErr != nil {
+		w.stats.Close()
+
 		hd.set(0, uint16(StreamErrorCommandUndefined), false)
 		hd.signal(w.handlerSender, h, b)
 
@@ -382,6 +431,8 @@ func (c *stream) reinit(
 	bootErr := ccc.bootup(&rr, b)
 
 	if !bootErr.Succeed() {
+		w.stats.Close()
+
 		l.Warning("Unable to start command %d due to error: %s",
 			hd.command(), bootErr.Error())
 
@@ -392,6 +443,11 @@ func (c *stream) reinit(
 
 	c.f = ccc
 	c.closed = false
+	c.stats = w.stats
+
+	if t := ccc.terminator(); t != nil {
+		c.stats.Terminable(t.Terminate)
+	}
 
 	sErr := signaller.Signal(bootErr.code, true)
 
@@ -421,9 +477,21 @@ func (c *stream) tick(
 		return rErr
 	}
 
+	// Reject the signal before any of it's data is handed to the command
+	vErr := c.f.validate(hd)
+
+	if vErr != nil {
+		return newProtocolError(h, vErr)
+	}
+
+	c.stats.Received(int(hd.Length()))
+
 	rr := rw.NewLimitedReader(r, int(hd.Length()))
 	defer rr.Ditch(b)
 
+	done := c.stats.Measure()
+	defer done()
+
 	return c.f.tick(&rr, hd, b)
 }
 
@@ -444,5 +512,8 @@ func (c *stream) release() error {
 		return ErrStreamsStreamReleasingInactiveStream
 	}
 
+	c.stats.Close()
+	c.stats = nil
+
 	return c.f.release()
 }
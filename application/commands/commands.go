@@ -24,7 +24,11 @@ import (
 // New creates a new commands group
 func New() command.Commands {
 	return command.Commands{
-		command.Register("Telnet", newTelnet, parseTelnetConfig),
-		command.Register("SSH", newSSH, parseSSHConfig),
+		command.Register("Telnet", newTelnet, parseTelnetConfig).
+			Accepts(telnetSignals),
+		command.Register("SSH", newSSH, parseSSHConfig).
+			Accepts(sshSignals),
+		command.Register("Conserver", newConserver, parseConserverConfig).
+			Accepts(conserverSignals),
 	}
 }
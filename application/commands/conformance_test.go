@@ -0,0 +1,51 @@
+//go:build conformance
+
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+func FuzzConformance(f *testing.F) {
+	corpora := []map[string][]byte{
+		ControlCorpus(),
+		TelnetCorpus(),
+		SSHCorpus(),
+		ConserverCorpus(),
+	}
+
+	for _, corpus := range corpora {
+		for _, input := range corpus {
+			f.Add(input)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		// Errors are expected for malformed inputs, only panics and hangs
+		// are failures
+		command.RunConformance(
+			New(), bytes.NewReader(input), io.Discard, log.NewDitch())
+	})
+}
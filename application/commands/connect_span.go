@@ -0,0 +1,60 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"strings"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/network"
+	"github.com/nirui/sshwifty/application/tracing"
+)
+
+// beginConnectSpan begins the span of a connection attempt of the
+// `protocol` to the `address`. Phases of the `trace` are recorded as the
+// child spans of it
+func beginConnectSpan(
+	cfg command.Configuration,
+	protocol string,
+	address string,
+	trace *network.DialTrace,
+) *tracing.Span {
+	span := cfg.Tracer.Begin(strings.ToLower(protocol) + ".connect")
+	if span == nil {
+		return nil
+	}
+
+	span.Set("sshwifty.protocol", protocol)
+	span.Set("server.address", address)
+
+	trace.Observe(span.Record)
+
+	return span
+}
+
+// endConnectSpan completes the unfinished phase of the `trace`, and then
+// ends the `span`, which has failed when the `err` is not nil
+func endConnectSpan(span *tracing.Span, trace *network.DialTrace, err error) {
+	trace.End()
+
+	if err != nil {
+		span.Set("sshwifty.dial_failure", classifyDialFailure(err).String())
+	}
+
+	span.End(err)
+}
@@ -0,0 +1,113 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nirui/sshwifty/application/approval"
+	"github.com/nirui/sshwifty/application/network"
+)
+
+// connectTiming is the timing data of a failed connection attempt
+type connectTiming struct {
+	// Unix time (in millisecond) when the attempt has started
+	Started int64 `json:"started"`
+
+	// The phase that has failed
+	Phase string `json:"phase"`
+
+	// Time spent on the failed phase, in millisecond
+	PhaseElapsed int64 `json:"phase_elapsed"`
+
+	// Whether or not the phase has failed due to time out
+	TimedOut bool `json:"timed_out"`
+
+	// Time spent on each completed phase, in millisecond
+	Completed map[string]int64 `json:"completed"`
+}
+
+func isTimeoutError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, approval.ErrTimeout) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+
+	return false
+}
+
+func newConnectTiming(r network.DialTraceReport, err error) connectTiming {
+	phase := r.Phase
+	if len(phase) <= 0 {
+		// No phase has been started by the Dial, so it must have failed
+		// before the connection was established
+		phase = network.DIAL_PHASE_CONNECT
+	}
+
+	completed := make(map[string]int64, len(r.Completed))
+	for k, v := range r.Completed {
+		completed[k] = v.Milliseconds()
+	}
+
+	started := int64(0)
+	if !r.Started.IsZero() {
+		started = r.Started.UnixMilli()
+	}
+
+	return connectTiming{
+		Started:      started,
+		Phase:        phase,
+		PhaseElapsed: r.PhaseElapsed.Milliseconds(),
+		TimedOut:     isTimeoutError(err),
+		Completed:    completed,
+	}
+}
+
+func (c connectTiming) String() string {
+	status := "failed"
+	if c.TimedOut {
+		status = "timed out"
+	}
+
+	phases := make([]string, 0, len(c.Completed))
+	for k, v := range c.Completed {
+		phases = append(phases, fmt.Sprintf("%s %s", k,
+			time.Duration(v)*time.Millisecond))
+	}
+	sort.Strings(phases)
+
+	if len(phases) <= 0 {
+		phases = append(phases, "none")
+	}
+
+	return fmt.Sprintf("%s %s after %s (completed phases: %s)",
+		c.Phase, status,
+		time.Duration(c.PhaseElapsed)*time.Millisecond,
+		strings.Join(phases, ", "))
+}
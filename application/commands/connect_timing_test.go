@@ -0,0 +1,87 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/approval"
+	"github.com/nirui/sshwifty/application/network"
+)
+
+func TestConnectTiming(t *testing.T) {
+	trace := network.NewDialTrace()
+	trace.Begin(network.DIAL_PHASE_RESOLVE)
+	trace.Begin(network.DIAL_PHASE_CONNECT)
+	trace.Begin(sshConnectPhaseHandshake)
+	time.Sleep(10 * time.Millisecond)
+
+	timing := newConnectTiming(trace.Report(), context.DeadlineExceeded)
+
+	if timing.Phase != sshConnectPhaseHandshake {
+		t.Errorf("Expecting phase %q, got %q",
+			sshConnectPhaseHandshake, timing.Phase)
+		return
+	}
+
+	if !timing.TimedOut {
+		t.Error("Expecting the timing to be reported as timed out")
+		return
+	}
+
+	if timing.PhaseElapsed < 10 {
+		t.Errorf("Expecting at least 10ms to be spent on the phase, got %d",
+			timing.PhaseElapsed)
+		return
+	}
+
+	for _, p := range []string{
+		network.DIAL_PHASE_RESOLVE, network.DIAL_PHASE_CONNECT,
+	} {
+		if _, ok := timing.Completed[p]; !ok {
+			t.Errorf("Expecting phase %q to be completed", p)
+			return
+		}
+	}
+
+	timing = newConnectTiming(
+		network.NewDialTrace().Report(), errors.New("refused"))
+
+	if timing.Phase != network.DIAL_PHASE_CONNECT || timing.TimedOut {
+		t.Errorf("Unexpected timing for a failed dial: %+v", timing)
+		return
+	}
+
+	trace = network.NewDialTrace()
+	trace.Begin(sshConnectPhaseApprove)
+
+	timing = newConnectTiming(trace.Report(), approval.ErrTimeout)
+	if timing.Phase != sshConnectPhaseApprove || !timing.TimedOut {
+		t.Errorf("Unexpected timing for an unanswered approval: %+v", timing)
+		return
+	}
+
+	timing = newConnectTiming(trace.Report(), approval.ErrDenied)
+	if timing.TimedOut {
+		t.Errorf("Unexpected timing for a denied approval: %+v", timing)
+		return
+	}
+}
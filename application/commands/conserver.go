@@ -0,0 +1,544 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/rw"
+)
+
+// Errors
+var (
+	ErrConserverUnableToReceiveRemoteConn = errors.New(
+		"unable to acquire remote connection handle")
+
+	ErrConserverPromptCancelled = errors.New(
+		"prompt has been cancelled")
+
+	ErrConserverPromptTimeout = errors.New(
+		"prompt has not been answered in time")
+
+	ErrConserverAnswerTooLarge = errors.New(
+		"answer of the prompt is too large")
+
+	ErrConserverUnexpectedAnswer = errors.New(
+		"answer of the prompt was not expected")
+
+	ErrConserverNoConsole = errors.New(
+		"no console has been selected")
+)
+
+// Error codes
+const (
+	ConserverRequestErrorBadRemoteAddress = command.StreamError(0x01)
+	ConserverRequestErrorBadUser          = command.StreamError(0x02)
+	ConserverRequestErrorBadConsole       = command.StreamError(0x03)
+	ConserverRequestErrorBadOptions       = command.StreamError(0x04)
+)
+
+// Options, sent as an optional byte after the console
+const (
+	ConserverOptionProtobuf byte = 0x01
+)
+
+const (
+	conserverDefaultPortString = "782"
+	conserverAnswerMaxSize     = 256
+)
+
+// Server signal codes
+const (
+	ConserverServerRemoteBand                 = 0x00
+	ConserverServerHookOutputBeforeConnecting = 0x01
+	ConserverServerConnectFailed              = 0x02
+	ConserverServerConnected                  = 0x03
+	ConserverServerStepUp                     = 0x04
+	ConserverServerRequestPassword            = 0x05
+	ConserverServerSelectConsole              = 0x06
+	ConserverServerFraming                    = 0x07
+)
+
+// Client signal codes
+const (
+	ConserverClientRemoteBand    = 0x00
+	ConserverClientRespondStepUp = 0x01
+	ConserverClientRespond       = 0x02
+)
+
+// conserverSignals is the schema of client signals
+var conserverSignals = command.Signals{
+	ConserverClientRemoteBand: command.Signal(
+		0, command.StreamHeaderMaxLength),
+	ConserverClientRespondStepUp: command.Signal(0, stepUpAnswerMaxSize),
+	ConserverClientRespond:       command.Signal(0, conserverAnswerMaxSize),
+}
+
+type conserverClient struct {
+	l             log.Logger
+	hooks         command.Hooks
+	w             command.StreamResponder
+	cfg           command.Configuration
+	baseCtx       context.Context
+	baseCtxCancel func()
+	stepUp        *sshPrompt[[]byte]
+	prompt        *sshPrompt[[]byte]
+	remoteChan    chan *conserverConn
+	remoteConn    *conserverConn
+	closeWait     sync.WaitGroup
+	noTrace       bool
+	framing       byte
+}
+
+func newConserver(
+	l log.Logger,
+	hooks command.Hooks,
+	w command.StreamResponder,
+	cfg command.Configuration,
+) command.FSMMachine {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	return &conserverClient{
+		l:             l,
+		hooks:         hooks,
+		w:             w,
+		cfg:           cfg,
+		baseCtx:       ctx,
+		baseCtxCancel: sync.OnceFunc(ctxCancel),
+		stepUp:        newSSHPrompt[[]byte](),
+		prompt:        newSSHPrompt[[]byte](),
+		remoteChan:    make(chan *conserverConn, 1),
+		remoteConn:    nil,
+		closeWait:     sync.WaitGroup{},
+		noTrace:       false,
+		framing:       SSHFramingBinary,
+	}
+}
+
+func parseConserverConfig(
+	p configuration.Preset,
+) (configuration.Preset, error) {
+	oldHost := p.Host
+
+	_, _, sErr := net.SplitHostPort(p.Host)
+	if sErr != nil {
+		p.Host = net.JoinHostPort(p.Host, conserverDefaultPortString)
+	}
+
+	if len(p.Host) <= 0 {
+		p.Host = oldHost
+	}
+
+	return p, nil
+}
+
+func (d *conserverClient) Bootup(
+	r *rw.LimitedReader,
+	b []byte) (command.FSMState, command.FSMError) {
+	addr, addrErr := ParseAddress(r.Read, b)
+	if addrErr != nil {
+		return nil, command.ToFSMError(
+			addrErr, ConserverRequestErrorBadRemoteAddress)
+	}
+	addrStr := addr.String()
+
+	user, userErr := ParseString(r.Read, b)
+	if userErr != nil {
+		return nil, command.ToFSMError(
+			userErr, ConserverRequestErrorBadUser)
+	}
+	userStr := string(user.Data())
+
+	console, consoleErr := ParseString(r.Read, b)
+	if consoleErr != nil {
+		return nil, command.ToFSMError(
+			consoleErr, ConserverRequestErrorBadConsole)
+	}
+	consoleStr := string(console.Data())
+
+	// Options, older clients don't send them
+	if !r.Completed() {
+		oData, oErr := rw.FetchOneByte(r.Fetch)
+		if oErr != nil {
+			return nil, command.ToFSMError(
+				oErr, ConserverRequestErrorBadOptions)
+		}
+
+		if oData[0]&ConserverOptionProtobuf != 0 {
+			d.framing = SSHFramingProtobuf
+		}
+	}
+
+	if p, ok := d.cfg.Preset("Conserver", addrStr); ok {
+		d.w.SetWeight(p.Weight)
+		d.noTrace = p.NoTrace
+	}
+
+	if !d.noTrace {
+		d.w.Describe(addrStr)
+	}
+
+	d.closeWait.Add(1)
+	go d.remote(addrStr, userStr, consoleStr)
+
+	return d.client, command.NoFSMError()
+}
+
+// sendFraming confirms the framing requested by the client. It must be sent
+// before any other signal, and it's not sent when the framing is
+// SSHFramingBinary
+func (d *conserverClient) sendFraming(buf []byte) {
+	if d.framing == SSHFramingBinary {
+		return
+	}
+
+	buf[d.w.HeaderSize()] = d.framing
+	d.w.SendManual(ConserverServerFraming, buf[:d.w.HeaderSize()+1])
+}
+
+// ask sends the prompt `marker` with the `payload` to the user, and waits
+// for the answer
+func (d *conserverClient) ask(
+	marker byte,
+	payload []byte,
+	buf []byte,
+) ([]byte, error) {
+	d.prompt.expect()
+	defer d.prompt.stop()
+
+	pLen := copy(buf[d.w.HeaderSize():], payload) + d.w.HeaderSize()
+	err := d.w.SendManual(marker, buf[:pLen])
+	if err != nil {
+		return nil, err
+	}
+
+	var expired <-chan time.Time
+	if d.cfg.PromptTimeout > 0 {
+		t := time.NewTimer(d.cfg.PromptTimeout)
+		defer t.Stop()
+
+		expired = t.C
+	}
+
+	select {
+	case answer := <-d.prompt.received():
+		return answer, nil
+
+	case <-d.baseCtx.Done():
+		return nil, ErrConserverPromptCancelled
+
+	case <-expired:
+		return nil, ErrConserverPromptTimeout
+	}
+}
+
+// selectConsole lists the consoles of the master at `addr`, and asks the
+// user to select one of them
+func (d *conserverClient) selectConsole(
+	addr string,
+	login func(c *conserverConn) error,
+	buf []byte,
+) (string, error) {
+	consoles, err := func() ([]string, error) {
+		master, err := dialConserver(
+			d.baseCtx, d.cfg.Dial, addr, d.cfg.DialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		defer master.Close()
+
+		if err := login(master); err != nil {
+			return nil, err
+		}
+
+		return conserverConsoles(
+			d.baseCtx, d.cfg.Dial, addr, d.cfg.DialTimeout, master, login)
+	}()
+	if err != nil {
+		return "", err
+	}
+
+	// Consoles that don't fit into the signal are left out, the user can
+	// still enter them by name
+	list, err := encodeSignalPayload(d.framing, conserverConsoleList(
+		consoles).fit(d.framing, len(buf)-d.w.HeaderSize()))
+	if err != nil {
+		return "", err
+	}
+
+	answer, err := d.ask(ConserverServerSelectConsole, list, buf)
+	if err != nil {
+		return "", err
+	}
+
+	console := strings.TrimSpace(string(answer))
+	if len(console) <= 0 {
+		return "", ErrConserverNoConsole
+	}
+
+	return console, nil
+}
+
+func (d *conserverClient) remote(addr string, user string, console string) {
+	defer func() {
+		d.w.Signal(command.HeaderClose)
+		close(d.remoteChan)
+		d.baseCtxCancel()
+		d.closeWait.Done()
+	}()
+
+	buf := [4096]byte{}
+
+	d.sendFraming(buf[:])
+
+	notice := formatConnectNotice(d.cfg.ConnectNotice, "Conserver", addr)
+	if len(notice) > 0 {
+		nLen := copy(buf[d.w.HeaderSize():], notice) + d.w.HeaderSize()
+		d.w.SendManual(
+			ConserverServerHookOutputBeforeConnecting, buf[:nLen])
+	}
+
+	params := command.NewRemoteHookParameters(
+		d.cfg, d.w.StreamID(), "Conserver", addr)
+
+	err := d.hooks.Run(
+		d.baseCtx,
+		configuration.HOOK_BEFORE_CONNECTING,
+		params,
+		command.NewDefaultHookOutput(d.l, func(
+			b []byte,
+		) (wLen int, wErr error) {
+			wLen = len(b)
+			dLen := copy(buf[d.w.HeaderSize():], b) + d.w.HeaderSize()
+			wErr = d.w.SendManual(
+				ConserverServerHookOutputBeforeConnecting,
+				buf[:dLen],
+			)
+			return
+		}),
+	)
+	rJournal := newRemoteJournal(d.cfg, d.l, "Conserver", addr)
+	if d.noTrace {
+		rJournal.withoutTrace()
+	}
+	defer func() { rJournal.done(err) }()
+	sendFailed := func(err error) {
+		errLen := copy(buf[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
+		d.w.SendManual(ConserverServerConnectFailed, buf[:errLen])
+	}
+	if err != nil {
+		sendFailed(err)
+		return
+	}
+
+	riskStepUp, err := scoreRisk(d.baseCtx, d.cfg, params, "", rJournal, d.l)
+	if err != nil {
+		sendFailed(err)
+		return
+	}
+
+	err = stepUp(d.baseCtx, d.cfg, params, riskStepUp, func(
+		code byte,
+	) ([]byte, bool, error) {
+		return waitStepUp(d.baseCtx, d.cfg.PromptTimeout, func() error {
+			buf[d.w.HeaderSize()] = code
+			return d.w.SendManual(
+				ConserverServerStepUp, buf[:d.w.HeaderSize()+1])
+		}, d.stepUp)
+	}, d.l)
+	if err != nil {
+		sendFailed(err)
+		return
+	}
+
+	// Every server of the conserver asks for the password, so it's only
+	// asked from the user once
+	var password []byte
+	login := func(c *conserverConn) error {
+		return c.login(user, func() ([]byte, error) {
+			if password != nil {
+				return password, nil
+			}
+
+			p, pErr := d.ask(ConserverServerRequestPassword, nil, buf[:])
+			if pErr != nil {
+				return nil, pErr
+			}
+			password = p
+
+			return password, nil
+		})
+	}
+
+	if len(console) <= 0 {
+		console, err = d.selectConsole(addr, login, buf[:])
+		if err != nil {
+			d.l.Debug("Unable to select a console: %s", err)
+			sendFailed(err)
+			return
+		}
+	}
+
+	rJournal.describe(map[string]string{
+		"user":    user,
+		"console": console,
+	})
+
+	conn, reply, err := attachConserver(
+		d.baseCtx, d.cfg.Dial, addr, d.cfg.DialTimeout, console, login)
+	if err != nil {
+		d.l.Debug("Unable to attach to console %q: %s", console, err)
+		sendFailed(err)
+		return
+	}
+	defer conn.Close()
+
+	err = d.w.SendManual(ConserverServerConnected, buf[:d.w.HeaderSize()])
+	if err != nil {
+		return
+	}
+
+	rJournal.connected()
+
+	rLen := copy(buf[d.w.HeaderSize():], reply+"\r\n") + d.w.HeaderSize()
+	err = d.w.SendManual(ConserverServerRemoteBand, buf[:rLen])
+	if err != nil {
+		return
+	}
+
+	d.remoteChan <- conn
+
+	for {
+		rLen, err := conn.Read(buf[d.w.HeaderSize():])
+		if err != nil {
+			return
+		}
+
+		rJournal.output("stdout", buf[d.w.HeaderSize():][:rLen])
+
+		wErr := d.w.SendManual(
+			ConserverServerRemoteBand, buf[:rLen+d.w.HeaderSize()])
+		if wErr != nil {
+			return
+		}
+	}
+}
+
+func (d *conserverClient) getRemote() (*conserverConn, error) {
+	if d.remoteConn != nil {
+		return d.remoteConn, nil
+	}
+
+	remoteConn, ok := <-d.remoteChan
+	if !ok {
+		return nil, ErrConserverUnableToReceiveRemoteConn
+	}
+	d.remoteConn = remoteConn
+
+	return d.remoteConn, nil
+}
+
+// readConserverAnswer reads the answer of a prompt from `r` and delivers it
+// to the `prompt`
+func readConserverAnswer(
+	r *rw.LimitedReader,
+	prompt *sshPrompt[[]byte],
+) error {
+	if r.Remains() > conserverAnswerMaxSize {
+		return ErrConserverAnswerTooLarge
+	}
+
+	answer := make([]byte, 0, r.Remains())
+
+	for !r.Completed() {
+		rData, rErr := r.Buffered()
+		if rErr != nil {
+			return rErr
+		}
+
+		answer = append(answer, rData...)
+	}
+
+	if !prompt.deliver(answer) {
+		return ErrConserverUnexpectedAnswer
+	}
+
+	return nil
+}
+
+func (d *conserverClient) client(
+	f *command.FSM,
+	r *rw.LimitedReader,
+	h command.StreamHeader,
+	b []byte,
+) error {
+	switch h.Marker() {
+	case ConserverClientRespondStepUp:
+		return readStepUpAnswer(r, d.stepUp)
+
+	case ConserverClientRespond:
+		return readConserverAnswer(r, d.prompt)
+	}
+
+	if d.cfg.ReadOnly {
+		return nil
+	}
+
+	remoteConn, remoteConnErr := d.getRemote()
+	if remoteConnErr != nil {
+		return remoteConnErr
+	}
+
+	for !r.Completed() {
+		rBuf, rErr := r.Buffered()
+		if rErr != nil {
+			return rErr
+		}
+
+		_, wErr := remoteConn.Write(rBuf)
+		if wErr != nil {
+			remoteConn.Close()
+			d.l.Debug("Failed to write data to remote: %s", wErr)
+		}
+	}
+
+	return nil
+}
+
+func (d *conserverClient) Close() error {
+	remoteConn, remoteConnErr := d.getRemote()
+	if remoteConnErr == nil {
+		remoteConn.Close()
+	}
+
+	d.baseCtxCancel()
+	d.closeWait.Wait()
+	return nil
+}
+
+func (d *conserverClient) Release() error {
+	d.baseCtxCancel()
+	return nil
+}
@@ -0,0 +1,508 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nirui/sshwifty/application/network"
+)
+
+// Replies and out-of-band commands of the conserver client protocol
+const (
+	conserverReplyOK       = "ok"
+	conserverReplyPassword = "passwd?"
+	conserverIAC           = 0xff
+	conserverOBDrop        = '.'
+	conserverMaxLineLen    = 4096
+	conserverMaxReplyLen   = 64 * 1024
+	conserverMaxRedirects  = 4
+)
+
+// Errors
+var (
+	ErrConserverUnexpectedReply = errors.New(
+		"unexpected reply from the conserver")
+
+	ErrConserverLoginFailed = errors.New(
+		"conserver has refused the login")
+
+	ErrConserverCallFailed = errors.New(
+		"conserver has refused to connect to the console")
+
+	ErrConserverTooManyRedirects = errors.New(
+		"too many redirects between the conserver masters")
+
+	ErrConserverReplyTooLarge = errors.New(
+		"reply of the conserver is too large")
+)
+
+// conserverConn is a connection to a conserver master or group server
+// which speaks the conserver client protocol. Commands and replies are
+// lines ended with "\r\n"
+type conserverConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+	dropped bool
+}
+
+// dialConserver connects to the conserver at `addr`, and reads the greeting.
+// Each of the dial and the commands must complete within the `timeout`
+func dialConserver(
+	ctx context.Context,
+	dial network.Dial,
+	addr string,
+	timeout time.Duration,
+) (*conserverConn, error) {
+	dialCtx, dialCtxCancel := context.WithTimeout(ctx, timeout)
+	defer dialCtxCancel()
+
+	conn, err := dial(
+		network.WithDialProtocol(dialCtx, "Conserver"), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conserverConn{
+		conn:    conn,
+		r:       bufio.NewReaderSize(conn, conserverMaxLineLen),
+		timeout: timeout,
+		dropped: false,
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	greeting, err := c.reply()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if greeting != conserverReplyOK {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %q", ErrConserverUnexpectedReply, greeting)
+	}
+
+	return c, nil
+}
+
+// Close closes the connection
+func (c *conserverConn) Close() error {
+	return c.conn.Close()
+}
+
+// line reads one line of reply, without the line ending
+func (c *conserverConn) line() (string, error) {
+	l, err := c.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", ErrConserverReplyTooLarge
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(l), "\r\n"), nil
+}
+
+// reply reads a reply of one line
+func (c *conserverConn) reply() (string, error) {
+	return c.line()
+}
+
+// replyLines reads a reply of multiple lines. Like the console client of
+// conserver, it assumes the reply is sent at once, so the reply ends at the
+// line where nothing else has been received
+func (c *conserverConn) replyLines() ([]string, error) {
+	lines := make([]string, 0, 16)
+	size := 0
+
+	for {
+		l, err := c.line()
+		if err != nil {
+			return nil, err
+		}
+
+		size += len(l)
+		if size > conserverMaxReplyLen {
+			return nil, ErrConserverReplyTooLarge
+		}
+
+		lines = append(lines, l)
+
+		if c.r.Buffered() <= 0 {
+			return lines, nil
+		}
+	}
+}
+
+// send sends the command `cmd`
+func (c *conserverConn) send(cmd string) error {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+
+	_, err := io.WriteString(c.conn, cmd+"\r\n")
+	return err
+}
+
+// command sends the command `cmd` and reads the reply of one line
+func (c *conserverConn) command(cmd string) (string, error) {
+	if err := c.send(cmd); err != nil {
+		return "", err
+	}
+
+	return c.reply()
+}
+
+// login logs in as the `user`. `password` is called to get the password
+// when the conserver asks for one
+func (c *conserverConn) login(
+	user string,
+	password func() ([]byte, error),
+) error {
+	r, err := c.command("login " + user)
+	if err != nil {
+		return err
+	}
+
+	if r == conserverReplyPassword {
+		p, pErr := password()
+		if pErr != nil {
+			return pErr
+		}
+
+		r, err = c.command(string(p))
+		if err != nil {
+			return err
+		}
+	}
+
+	if r != conserverReplyOK {
+		return fmt.Errorf("%w: %s", ErrConserverLoginFailed, r)
+	}
+
+	return nil
+}
+
+// locate asks the master where the `console` is served. It returns the
+// port of the group server on the same host, or the host of another master
+// (with an empty port) which must be asked instead
+func (c *conserverConn) locate(console string) (host string, port string,
+	err error) {
+	r, err := c.command("call " + console)
+	if err != nil {
+		return "", "", err
+	}
+
+	if strings.HasPrefix(r, "@") && len(r) > 1 {
+		return r[1:], "", nil
+	}
+
+	if _, pErr := strconv.ParseUint(r, 10, 16); pErr != nil {
+		return "", "", fmt.Errorf("%w: %s", ErrConserverCallFailed, r)
+	}
+
+	return "", r, nil
+}
+
+// groups asks the master for the ports of its group servers
+func (c *conserverConn) groups() ([]string, error) {
+	r, err := c.command("groups")
+	if err != nil {
+		return nil, err
+	}
+
+	ports := strings.Split(r, ":")
+	for _, p := range ports {
+		if _, pErr := strconv.ParseUint(p, 10, 16); pErr != nil {
+			return nil, fmt.Errorf(
+				"%w: %q", ErrConserverUnexpectedReply, r)
+		}
+	}
+
+	return ports, nil
+}
+
+// info asks the group server for the names of the consoles it serves
+func (c *conserverConn) info() ([]string, error) {
+	if err := c.send("info"); err != nil {
+		return nil, err
+	}
+
+	lines, err := c.replyLines()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(lines))
+	for _, l := range lines {
+		name, _, found := strings.Cut(l, ":")
+		if !found || len(name) <= 0 {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// call attaches to the `console` served by the group server, and returns
+// the reply (i.e. "[attached]") which must be shown to the user
+func (c *conserverConn) call(console string) (string, error) {
+	r, err := c.command("call " + console)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(r, "[") {
+		return "", fmt.Errorf("%w: %s", ErrConserverCallFailed, r)
+	}
+
+	return r, nil
+}
+
+// attached makes the connection ready for the console session
+func (c *conserverConn) attached() {
+	c.conn.SetDeadline(time.Time{})
+}
+
+// Read reads the output of the console. Bytes quoted by IAC are unquoted,
+// and the out-of-band commands are dropped. It returns io.EOF when the
+// conserver drops the session
+func (c *conserverConn) Read(b []byte) (int, error) {
+	if c.dropped {
+		return 0, io.EOF
+	}
+
+	n := 0
+
+	for n < len(b) {
+		// Only block when nothing has been read
+		if n > 0 && c.r.Buffered() <= 0 {
+			break
+		}
+
+		v, err := c.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				break
+			}
+			return 0, err
+		}
+
+		if v != conserverIAC {
+			b[n] = v
+			n++
+			continue
+		}
+
+		// Don't block on the command byte when there is something to return
+		if n > 0 && c.r.Buffered() <= 0 {
+			c.r.UnreadByte()
+			break
+		}
+
+		cmd, err := c.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				break
+			}
+			return 0, err
+		}
+
+		switch cmd {
+		case conserverIAC:
+			b[n] = conserverIAC
+			n++
+
+		case conserverOBDrop:
+			c.dropped = true
+
+			if n > 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		}
+	}
+
+	return n, nil
+}
+
+// Write writes the input of the console, IAC is quoted
+func (c *conserverConn) Write(b []byte) (int, error) {
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+
+	start := 0
+
+	for i := range b {
+		if b[i] != conserverIAC {
+			continue
+		}
+
+		if _, err := c.conn.Write(b[start : i+1]); err != nil {
+			return start, err
+		}
+
+		start = i
+	}
+
+	if _, err := c.conn.Write(b[start:]); err != nil {
+		return start, err
+	}
+
+	return len(b), nil
+}
+
+// conserverConsoles lists the consoles of all the group servers of the
+// master at `addr`
+func conserverConsoles(
+	ctx context.Context,
+	dial network.Dial,
+	addr string,
+	timeout time.Duration,
+	master *conserverConn,
+	login func(c *conserverConn) error,
+) ([]string, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ports, err := master.groups()
+	if err != nil {
+		return nil, err
+	}
+
+	consoles := make([]string, 0, 64)
+
+	for _, port := range ports {
+		group, err := dialConserver(
+			ctx, dial, net.JoinHostPort(host, port), timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		names, err := func() ([]string, error) {
+			defer group.Close()
+
+			if err := login(group); err != nil {
+				return nil, err
+			}
+
+			names, err := group.info()
+			if err != nil {
+				return nil, err
+			}
+
+			group.send("exit")
+
+			return names, nil
+		}()
+		if err != nil {
+			return nil, err
+		}
+
+		consoles = append(consoles, names...)
+	}
+
+	sort.Strings(consoles)
+
+	return consoles, nil
+}
+
+// attachConserver attaches to the `console` through the master at `addr`,
+// following the redirects to the other masters. It returns the connection
+// of the console session, and the reply of the call
+func attachConserver(
+	ctx context.Context,
+	dial network.Dial,
+	addr string,
+	timeout time.Duration,
+	console string,
+	login func(c *conserverConn) error,
+) (*conserverConn, string, error) {
+	host, masterPort, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i := 0; i < conserverMaxRedirects; i++ {
+		master, err := dialConserver(
+			ctx, dial, net.JoinHostPort(host, masterPort), timeout)
+		if err != nil {
+			return nil, "", err
+		}
+
+		redirect, port, err := func() (string, string, error) {
+			defer master.Close()
+
+			if err := login(master); err != nil {
+				return "", "", err
+			}
+
+			redirect, port, err := master.locate(console)
+			if err != nil {
+				return "", "", err
+			}
+
+			master.send("exit")
+
+			return redirect, port, nil
+		}()
+		if err != nil {
+			return nil, "", err
+		}
+
+		if len(port) <= 0 {
+			host = redirect
+			continue
+		}
+
+		group, err := dialConserver(
+			ctx, dial, net.JoinHostPort(host, port), timeout)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if err := login(group); err != nil {
+			group.Close()
+			return nil, "", err
+		}
+
+		reply, err := group.call(console)
+		if err != nil {
+			group.Close()
+			return nil, "", err
+		}
+
+		group.attached()
+
+		return group, reply, nil
+	}
+
+	return nil, "", ErrConserverTooManyRedirects
+}
@@ -0,0 +1,208 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConserver serves the `handle` of the conserver commands on a local
+// port, and returns the port
+func testConserver(
+	t *testing.T,
+	handle func(cmd string, conn net.Conn) (reply string, attach bool),
+) string {
+	listener, lErr := net.Listen("tcp", "127.0.0.1:0")
+	if lErr != nil {
+		t.Fatal("Failed to listen:", lErr)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, aErr := listener.Accept()
+			if aErr != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				io.WriteString(conn, "ok\r\n")
+
+				r := bufio.NewReader(conn)
+				for {
+					l, rErr := r.ReadString('\n')
+					if rErr != nil {
+						return
+					}
+
+					reply, attach := handle(strings.TrimRight(l, "\r\n"), conn)
+					if len(reply) > 0 {
+						io.WriteString(conn, reply)
+					}
+					if !attach {
+						continue
+					}
+
+					// Echo the console input back, then drop the session
+					b := make([]byte, 3)
+					if _, rErr := io.ReadFull(r, b); rErr != nil {
+						return
+					}
+					conn.Write(append(b, conserverIAC, conserverOBDrop))
+					return
+				}
+			}()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	return port
+}
+
+func testConserverDial(
+	ctx context.Context,
+	network string,
+	address string,
+) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext(ctx, network, address)
+}
+
+func TestConserverAttach(t *testing.T) {
+	login := func(cmd string) (string, bool) {
+		switch cmd {
+		case "login admin":
+			return "passwd?\r\n", true
+
+		case "secret":
+			return "ok\r\n", true
+		}
+
+		return "", false
+	}
+
+	group := testConserver(t, func(cmd string, conn net.Conn) (string, bool) {
+		if r, ok := login(cmd); ok {
+			return r, false
+		}
+
+		switch cmd {
+		case "info":
+			return "web1:1@localhost:/dev/ttyS0\r\n" +
+				"db1:2@localhost:/dev/ttyS1\r\n", false
+
+		case "call web1":
+			return "[attached]\r\n", true
+		}
+
+		return "console not found\r\n", false
+	})
+
+	master := testConserver(t, func(cmd string, conn net.Conn) (string, bool) {
+		if r, ok := login(cmd); ok {
+			return r, false
+		}
+
+		switch cmd {
+		case "groups":
+			return group + "\r\n", false
+
+		case "call web1":
+			return group + "\r\n", false
+
+		case "exit":
+			return "goodbye\r\n", false
+		}
+
+		return "console `" + strings.TrimPrefix(cmd, "call ") +
+			"' not found\r\n", false
+	})
+
+	addr := net.JoinHostPort("127.0.0.1", master)
+	passwordAsked := 0
+	doLogin := func(c *conserverConn) error {
+		return c.login("admin", func() ([]byte, error) {
+			passwordAsked++
+			return []byte("secret"), nil
+		})
+	}
+
+	m, err := dialConserver(
+		context.Background(), testConserverDial, addr, 5*time.Second)
+	if err != nil {
+		t.Fatal("Failed to connect to the master:", err)
+	}
+	defer m.Close()
+
+	if err := doLogin(m); err != nil {
+		t.Fatal("Failed to login:", err)
+	}
+
+	consoles, err := conserverConsoles(context.Background(),
+		testConserverDial, addr, 5*time.Second, m, doLogin)
+	if err != nil {
+		t.Fatal("Failed to list the consoles:", err)
+	}
+	if strings.Join(consoles, ",") != "db1,web1" {
+		t.Errorf("Unexpected consoles: %v", consoles)
+	}
+
+	_, _, err = attachConserver(context.Background(),
+		testConserverDial, addr, 5*time.Second, "nope", doLogin)
+	if err == nil {
+		t.Error("Expecting unknown consoles to be refused")
+	}
+
+	conn, reply, err := attachConserver(context.Background(),
+		testConserverDial, addr, 5*time.Second, "web1", doLogin)
+	if err != nil {
+		t.Fatal("Failed to attach:", err)
+	}
+	defer conn.Close()
+
+	if reply != "[attached]" {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+
+	if passwordAsked != 5 {
+		t.Errorf("Expecting the password to be asked 5 times, got %d",
+			passwordAsked)
+	}
+
+	if _, err := conn.Write([]byte{'a', conserverIAC}); err != nil {
+		t.Fatal("Failed to write:", err)
+	}
+
+	output, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal("Failed to read:", err)
+	}
+
+	// The IAC is quoted to the conserver, which echoes it back quoted
+	if string(output) != string([]byte{'a', conserverIAC}) {
+		t.Errorf("Unexpected output %v", output)
+	}
+}
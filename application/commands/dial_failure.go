@@ -0,0 +1,143 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// DialFailure classifies why the connection to the remote has failed, so
+// the client can tell the user what went wrong without parsing the message
+type DialFailure byte
+
+// DialFailures
+const (
+	DialFailureUnknown           DialFailure = 0x00
+	DialFailureNameNotResolved   DialFailure = 0x01
+	DialFailureConnectionRefused DialFailure = 0x02
+	DialFailureUnreachable       DialFailure = 0x03
+	DialFailureTimedOut          DialFailure = 0x04
+	DialFailureProxy             DialFailure = 0x05
+	DialFailureTLS               DialFailure = 0x06
+	DialFailureAuthRejected      DialFailure = 0x07
+	DialFailureHostKeyMismatch   DialFailure = 0x08
+)
+
+// Part of the error returned by ssh.NewClientConn when the server has
+// refused all the auth methods. It's not exported as an error value
+const sshUnableToAuthenticate = "ssh: unable to authenticate"
+
+// String returns the name of the DialFailure
+func (f DialFailure) String() string {
+	switch f {
+	case DialFailureNameNotResolved:
+		return "name not resolved"
+
+	case DialFailureConnectionRefused:
+		return "connection refused"
+
+	case DialFailureUnreachable:
+		return "unreachable"
+
+	case DialFailureTimedOut:
+		return "timed out"
+
+	case DialFailureProxy:
+		return "proxy error"
+
+	case DialFailureTLS:
+		return "TLS error"
+
+	case DialFailureAuthRejected:
+		return "auth rejected"
+
+	case DialFailureHostKeyMismatch:
+		return "host key mismatch"
+	}
+
+	return "unknown"
+}
+
+// classifyDialFailure returns the DialFailure of the `err` returned when
+// dialing, or handshaking with, the remote
+func classifyDialFailure(err error) DialFailure {
+	if err == nil {
+		return DialFailureUnknown
+	}
+
+	if errors.Is(err, ErrSSHRemoteHostKeyChanged) ||
+		errors.Is(err, ErrSSHRemoteHostKeyRevoked) ||
+		errors.Is(err, ErrSSHRemoteFingerprintUnexpected) {
+		return DialFailureHostKeyMismatch
+	}
+
+	if strings.Contains(err.Error(), sshUnableToAuthenticate) {
+		return DialFailureAuthRejected
+	}
+
+	// Everything that went wrong behind the SOCKS5 proxy is reported by it,
+	// including the failures of the proxy to reach the remote
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && strings.HasPrefix(opErr.Op, "socks") {
+		return DialFailureProxy
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsNotFound {
+			return DialFailureNameNotResolved
+		}
+
+		if dnsErr.IsTimeout {
+			return DialFailureTimedOut
+		}
+
+		return DialFailureUnknown
+	}
+
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return DialFailureConnectionRefused
+
+	case errors.Is(err, syscall.EHOSTUNREACH),
+		errors.Is(err, syscall.ENETUNREACH):
+		return DialFailureUnreachable
+
+	case isTimeoutError(err):
+		return DialFailureTimedOut
+	}
+
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &recordErr) || errors.As(err, &alertErr) ||
+		errors.As(err, &verifyErr) ||
+		errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) {
+		return DialFailureTLS
+	}
+
+	return DialFailureUnknown
+}
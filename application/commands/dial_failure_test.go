@@ -0,0 +1,63 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyDialFailure(t *testing.T) {
+	refused := &net.OpError{
+		Op:  "dial",
+		Net: "tcp",
+		Err: os.NewSyscallError("connect", syscall.ECONNREFUSED),
+	}
+
+	for _, c := range []struct {
+		err      error
+		expected DialFailure
+	}{
+		{nil, DialFailureUnknown},
+		{errors.New("something"), DialFailureUnknown},
+		{&net.DNSError{Err: "no such host", IsNotFound: true},
+			DialFailureNameNotResolved},
+		{refused, DialFailureConnectionRefused},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.EHOSTUNREACH},
+			DialFailureUnreachable},
+		{context.DeadlineExceeded, DialFailureTimedOut},
+		{&net.OpError{Op: "socks connect", Net: "tcp", Err: refused},
+			DialFailureProxy},
+		{tls.AlertError(40), DialFailureTLS},
+		{fmt.Errorf("ssh: handshake failed: %w", errors.New(
+			"ssh: unable to authenticate, attempted methods [none]")),
+			DialFailureAuthRejected},
+		{fmt.Errorf("ssh: handshake failed: %w", ErrSSHRemoteHostKeyChanged),
+			DialFailureHostKeyMismatch},
+	} {
+		if f := classifyDialFailure(c.err); f != c.expected {
+			t.Errorf("Expecting %q to be %s, got %s", c.err, c.expected, f)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+)
+
+const (
+	inputSequenceSize = 4
+)
+
+// inputSequence drops the input frames which have already been received.
+// When it's enabled, every input frame starts with a 32 bits big-endian
+// sequence number, counted up by the client from 1. Frames numbered no later
+// than the last accepted one are the retransmissions of a reconnected client,
+// writing them again would run the typed commands twice. Gaps are allowed,
+// as the lost frames can't be recovered anyway
+type inputSequence struct {
+	enabled bool
+	last    atomic.Uint32
+}
+
+// read reads the sequence number of the input frame from the `r`, and returns
+// whether or not the rest of the frame should be written. It always returns
+// true when the inputSequence is not enabled
+func (s *inputSequence) read(r io.Reader, b []byte) (bool, error) {
+	if !s.enabled {
+		return true, nil
+	}
+
+	_, err := io.ReadFull(r, b[:inputSequenceSize])
+	if err != nil {
+		return false, err
+	}
+
+	return s.accept(binary.BigEndian.Uint32(b)), nil
+}
+
+// accept returns true when the `seq` is later than the last accepted one,
+// which it then becomes. Numbers are compared in the serial number
+// arithmetic, so the sequence can wrap around
+func (s *inputSequence) accept(seq uint32) bool {
+	if int32(seq-s.last.Load()) <= 0 {
+		return false
+	}
+
+	s.last.Store(seq)
+
+	return true
+}
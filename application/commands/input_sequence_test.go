@@ -0,0 +1,70 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestInputSequence(t *testing.T) {
+	s := inputSequence{}
+
+	accepted, err := s.read(bytes.NewReader([]byte("ls")), make([]byte, 4))
+	if err != nil || !accepted {
+		t.Error("Expecting the input to be accepted when not enabled")
+	}
+
+	s.enabled = true
+
+	for i, c := range []struct {
+		seq      uint32
+		accepted bool
+	}{
+		{1, true},
+		{2, true},
+		{2, false},
+		{1, false},
+		{5, true},
+		{4, false},
+		{0xffffffff, false},
+	} {
+		if s.accept(c.seq) != c.accepted {
+			t.Errorf("%d: Expecting %d to be accepted: %t",
+				i, c.seq, c.accepted)
+		}
+	}
+
+	// Wraps around
+	s.last.Store(0xfffffffe)
+	if !s.accept(0xffffffff) || !s.accept(1) || s.accept(0xffffffff) {
+		t.Error("Expecting the sequence to wrap around")
+	}
+
+	accepted, err = s.read(
+		bytes.NewReader([]byte{0, 0, 0, 2, 'l', 's'}), make([]byte, 4))
+	if err != nil || !accepted {
+		t.Errorf("Expecting the input to be accepted, got %t (%v)",
+			accepted, err)
+	}
+
+	_, err = s.read(bytes.NewReader([]byte{0}), make([]byte, 4))
+	if err == nil {
+		t.Error("Expecting an error for the truncated sequence number")
+	}
+}
@@ -0,0 +1,67 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Errors
+var (
+	ErrInterruptUnknownKey = errors.New(
+		"unknown interrupt, expecting \"INT\" or \"QUIT\"")
+)
+
+// interruptKeys are the control keys typed into the terminal of the remote to
+// interrupt the running program, by the names of the signals they raise
+var interruptKeys = map[string]byte{
+	"":     0x03, // ^C by default
+	"INT":  0x03, // ^C
+	"QUIT": 0x1c, // ^\
+}
+
+// interruptKey returns the control key of the interrupt `name`
+func interruptKey(name []byte) (byte, error) {
+	key, found := interruptKeys[string(name)]
+	if !found {
+		return 0, ErrInterruptUnknownKey
+	}
+
+	return key, nil
+}
+
+// outputMute drops the output of the remote for a while after the client
+// has interrupted it, so the client can catch up with the output that is
+// flooding the terminal
+type outputMute struct {
+	until atomic.Int64 // Unix time in nanosecond
+}
+
+// mute drops the output from now until the `d` has passed
+func (m *outputMute) mute(d time.Duration) {
+	m.until.Store(time.Now().Add(d).UnixNano())
+}
+
+// muted returns true when the output should be dropped
+func (m *outputMute) muted() bool {
+	until := m.until.Load()
+
+	return until != 0 && time.Now().UnixNano() < until
+}
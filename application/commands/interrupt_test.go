@@ -0,0 +1,61 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInterruptKey(t *testing.T) {
+	for name, expected := range map[string]byte{
+		"":     0x03,
+		"INT":  0x03,
+		"QUIT": 0x1c,
+	} {
+		key, err := interruptKey([]byte(name))
+		if err != nil || key != expected {
+			t.Errorf("Expecting %#x for %q, got %#x (%v)",
+				expected, name, key, err)
+		}
+	}
+
+	if _, err := interruptKey([]byte("KILL")); err == nil {
+		t.Error("Expecting an error for unknown interrupt")
+	}
+}
+
+func TestOutputMute(t *testing.T) {
+	m := outputMute{}
+
+	if m.muted() {
+		t.Error("Expecting the output not to be muted by default")
+	}
+
+	m.mute(20 * time.Millisecond)
+
+	if !m.muted() {
+		t.Error("Expecting the output to be muted")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if m.muted() {
+		t.Error("Expecting the output to be unmuted once the time passed")
+	}
+}
@@ -0,0 +1,359 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nirui/sshwifty/application/audit"
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/journal"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/recording"
+	"github.com/nirui/sshwifty/application/risk"
+	"github.com/nirui/sshwifty/application/sharing"
+)
+
+// remoteJournal records the lifecycle of a remote connection into the
+// journal.Journal, and publishes it to the audit.Dispatcher and audit.Feed.
+// The output of the remote connection is also written into the
+// recording.Recording, if it's recorded
+type remoteJournal struct {
+	j           *journal.Journal
+	audit       *audit.Dispatcher
+	events      *audit.Feed
+	l           log.Logger
+	client      string
+	origin      *journal.Origin
+	tenant      string
+	user        string
+	protocol    string
+	remote      string
+	details     map[string]string
+	noTrace     bool
+	connectedAt time.Time
+	recording   *recording.Recording
+	recordOnly  func([]byte) []byte
+	sent        atomic.Uint64
+	received    atomic.Uint64
+	reason      string
+	sandbox     *sandboxLimits
+}
+
+func newRemoteJournal(
+	cfg command.Configuration,
+	l log.Logger,
+	protocol string,
+	remote string,
+) *remoteJournal {
+	return &remoteJournal{
+		j:           cfg.Journal,
+		audit:       cfg.Audit,
+		events:      cfg.Events,
+		l:           l,
+		client:      cfg.ClientAddress,
+		origin:      cfg.ClientOrigin,
+		tenant:      cfg.Tenant,
+		user:        cfg.User,
+		protocol:    protocol,
+		remote:      remote,
+		details:     nil,
+		connectedAt: time.Time{},
+		recording:   nil,
+		recordOnly:  nil,
+		reason:      "",
+		sandbox:     nil,
+	}
+}
+
+func (r *remoteJournal) record(t journal.EventType, d time.Duration, e error) {
+	event := journal.Event{
+		Time:     time.Now(),
+		Type:     t,
+		Client:   r.client,
+		Protocol: r.protocol,
+		Remote:   r.remote,
+		Duration: d,
+		User:     r.user,
+		Origin:   r.origin,
+		Tenant:   r.tenant,
+		Details:  r.details,
+	}
+
+	if e != nil {
+		event.Error = e.Error()
+	}
+
+	if r.noTrace {
+		event.Details = map[string]string{"no_trace": "true"}
+	}
+
+	r.publish(event)
+}
+
+// publish records the `event` into the journal, and publishes it to the
+// audit sinks and the management API
+func (r *remoteJournal) publish(event journal.Event) {
+	r.audit.Publish(event)
+	r.events.Publish(event)
+
+	if err := r.j.Record(event); err != nil {
+		r.l.Warning("Unable to write journal: %s", err)
+	}
+}
+
+// describe adds the details which will be recorded with the following
+// events
+func (r *remoteJournal) describe(details map[string]string) {
+	if r.details == nil {
+		r.details = make(map[string]string, len(details))
+	}
+
+	for k, v := range details {
+		r.details[k] = v
+	}
+}
+
+// withoutTrace marks the remote connection as a "no trace" one. Events of
+// it only carry the mark, but not the protocol specific details
+func (r *remoteJournal) withoutTrace() {
+	r.noTrace = true
+}
+
+// limitBy ends the remote connection once it has reached the limits of the
+// sandbox `s`, counted from when it's connected
+func (r *remoteJournal) limitBy(s *sandboxLimits) {
+	r.sandbox = s
+}
+
+// connected records that the remote connection has been established
+func (r *remoteJournal) connected() {
+	r.connectedAt = time.Now()
+	r.sandbox.start()
+
+	r.record(journal.REMOTE_CONNECTED, 0, nil)
+}
+
+// recordTo writes the output of the remote connection into the `rec` from
+// now on. When the `only` is not nil, only what it returns of the output is
+// written. The `rec` is closed when the remote connection is done
+func (r *remoteJournal) recordTo(
+	rec *recording.Recording, only func([]byte) []byte) {
+	r.recording = rec
+	r.recordOnly = only
+}
+
+// resized records that the terminal of the remote connection has been
+// resized to `cols` x `rows`
+func (r *remoteJournal) resized(cols, rows int) {
+	if err := r.recording.Resize(cols, rows); err != nil {
+		r.l.Warning("Unable to write recording: %s", err)
+	}
+}
+
+// input counts the `n` bytes sent to the remote connection
+func (r *remoteJournal) input(n int) {
+	r.sandbox.transferred(r.sent.Add(uint64(n)) + r.received.Load())
+}
+
+// output publishes a chunk of the output of the remote connection to the
+// audit sinks that want it, and writes it into the recording. `stream` names
+// where the output came from (i.e. "stdout"). Output of "no trace"
+// connections is never published
+func (r *remoteJournal) output(stream string, data []byte) {
+	r.sandbox.transferred(r.received.Add(uint64(len(data))) + r.sent.Load())
+
+	if r.recording != nil {
+		recorded := data
+		if r.recordOnly != nil {
+			recorded = r.recordOnly(data)
+		}
+
+		if err := r.recording.Output(recorded); err != nil {
+			r.l.Warning("Unable to write recording: %s", err)
+		}
+	}
+
+	if r.noTrace || !r.audit.StreamsOutput() {
+		return
+	}
+
+	details := make(map[string]string, len(r.details)+1)
+	for k, v := range r.details {
+		details[k] = v
+	}
+	details["stream"] = stream
+
+	r.audit.Publish(journal.Event{
+		Time:     time.Now(),
+		Type:     journal.REMOTE_OUTPUT,
+		Client:   r.client,
+		Protocol: r.protocol,
+		Remote:   r.remote,
+		User:     r.user,
+		Origin:   r.origin,
+		Tenant:   r.tenant,
+		Details:  details,
+		Output:   append([]byte{}, data...),
+	})
+}
+
+// risky records that the risk score `a` of the remote connection has
+// triggered the `action`
+func (r *remoteJournal) risky(a risk.Assessment, action risk.Action) {
+	details := map[string]string{
+		"score":  strconv.FormatFloat(a.Score, 'f', -1, 64),
+		"reason": a.Reason,
+		"action": action.String(),
+	}
+	if r.noTrace {
+		details["no_trace"] = "true"
+	}
+
+	r.publish(journal.Event{
+		Time:     time.Now(),
+		Type:     journal.REMOTE_RISK,
+		Client:   r.client,
+		Protocol: r.protocol,
+		Remote:   r.remote,
+		User:     r.user,
+		Origin:   r.origin,
+		Tenant:   r.tenant,
+		Details:  details,
+	})
+}
+
+// weakTransport records the `warnings` about the transport of the remote
+// connection
+func (r *remoteJournal) weakTransport(warnings []string) {
+	details := make(map[string]string, len(r.details)+1)
+	if r.noTrace {
+		details["no_trace"] = "true"
+	} else {
+		for k, v := range r.details {
+			details[k] = v
+		}
+	}
+	details["warnings"] = strings.Join(warnings, "; ")
+
+	r.publish(journal.Event{
+		Time:     time.Now(),
+		Type:     journal.REMOTE_WEAK_TRANSPORT,
+		Client:   r.client,
+		Protocol: r.protocol,
+		Remote:   r.remote,
+		User:     r.user,
+		Origin:   r.origin,
+		Tenant:   r.tenant,
+		Details:  details,
+	})
+}
+
+// shared records that the remote connection is sharing the account of the
+// remote with the `others` sessions
+func (r *remoteJournal) shared(others []sharing.Session) {
+	details := make(map[string]string, len(r.details)+2)
+	for k, v := range r.details {
+		details[k] = v
+	}
+	details["sessions"] = strconv.Itoa(len(others))
+	details["others"] = describeSharing(others)
+
+	r.publish(journal.Event{
+		Time:     time.Now(),
+		Type:     journal.REMOTE_SHARED,
+		Client:   r.client,
+		Protocol: r.protocol,
+		Remote:   r.remote,
+		User:     r.user,
+		Origin:   r.origin,
+		Tenant:   r.tenant,
+		Details:  details,
+	})
+}
+
+// disconnected sets the `reason` why the remote connection was ended, which
+// is recorded by done. Only the first reason is kept, as the following ones
+// are usually the consequences of it
+func (r *remoteJournal) disconnected(reason string) {
+	if len(r.reason) > 0 {
+		return
+	}
+
+	r.reason = reason
+}
+
+// done records the end of the remote connection. `err` is the error that
+// caused the connection to fail, if it has never been established, or the
+// reason of the disconnection when no other reason was given
+func (r *remoteJournal) done(err error) {
+	r.sandbox.stop()
+
+	if cErr := r.recording.Close(); cErr != nil {
+		r.l.Warning("Unable to close recording: %s", cErr)
+	}
+
+	if r.connectedAt.IsZero() {
+		r.record(journal.REMOTE_FAILED, 0, err)
+
+		return
+	}
+
+	if err != nil {
+		r.disconnected(err.Error())
+	}
+
+	details := r.details
+	if r.noTrace {
+		details = map[string]string{"no_trace": "true"}
+	}
+
+	r.publish(journal.Event{
+		Time:          time.Now(),
+		Type:          journal.REMOTE_DISCONNECTED,
+		Client:        r.client,
+		Protocol:      r.protocol,
+		Remote:        r.remote,
+		Duration:      time.Since(r.connectedAt),
+		User:          r.user,
+		Origin:        r.origin,
+		Tenant:        r.tenant,
+		Details:       details,
+		BytesSent:     r.sent.Load(),
+		BytesReceived: r.received.Load(),
+		Reason:        r.reason,
+	})
+}
+
+// journalConn counts the bytes written into the remote connection `Conn` as
+// the input of the `journal`
+type journalConn struct {
+	net.Conn
+	journal *remoteJournal
+}
+
+func (c journalConn) Write(b []byte) (int, error) {
+	wLen, wErr := c.Conn.Write(b)
+	c.journal.input(wLen)
+
+	return wLen, wErr
+}
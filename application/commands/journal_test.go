@@ -0,0 +1,67 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/nirui/sshwifty/application/audit"
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/journal"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+func TestRemoteJournalDisconnected(t *testing.T) {
+	feed := audit.NewFeed()
+	sub := feed.Subscribe(4)
+	defer sub.Close()
+
+	r := newRemoteJournal(command.Configuration{
+		Events:        feed,
+		ClientAddress: "192.0.2.1:1234",
+		User:          "alice",
+	}, log.NewDitch(), "SSH", "host:22")
+	r.describe(map[string]string{"login_user": "root"})
+	r.describe(map[string]string{"auth_method": "password"})
+	r.connected()
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	go func() {
+		b := make([]byte, 8)
+		remote.Read(b)
+	}()
+
+	journalConn{Conn: local, journal: r}.Write([]byte("ls\r"))
+	r.output("stdout", []byte("hello"))
+	r.disconnected("Session ended with status 0")
+	r.done(errors.New("ignored"))
+
+	<-sub.Events()
+	e := <-sub.Events()
+
+	if e.Type != journal.REMOTE_DISCONNECTED || e.User != "alice" ||
+		e.BytesSent != 3 || e.BytesReceived != 5 ||
+		e.Reason != "Session ended with status 0" ||
+		e.Details["login_user"] != "root" ||
+		e.Details["auth_method"] != "password" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+}
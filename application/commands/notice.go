@@ -0,0 +1,52 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"os"
+	"time"
+)
+
+// formatConnectNotice formats the connect notice. Following placeholders in
+// the `notice` will be replaced:
+//   - ${REMOTE_TYPE}: Type of the remote, i.e. SSH
+//   - ${REMOTE_ADDRESS}: Address of the remote
+//   - ${TIME}: Current time of the server, in RFC3339 format
+//
+// Unknown placeholders are kept as is
+func formatConnectNotice(notice, remoteType, remoteAddress string) string {
+	if len(notice) <= 0 {
+		return ""
+	}
+
+	return os.Expand(notice, func(name string) string {
+		switch name {
+		case "REMOTE_TYPE":
+			return remoteType
+
+		case "REMOTE_ADDRESS":
+			return remoteAddress
+
+		case "TIME":
+			return time.Now().Format(time.RFC3339)
+
+		default:
+			return "${" + name + "}"
+		}
+	})
+}
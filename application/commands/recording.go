@@ -0,0 +1,67 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"errors"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/recording"
+)
+
+// Errors
+var (
+	ErrRecordingUnavailable = errors.New(
+		"the session must be recorded, but the recording can't be started")
+)
+
+// startRecording starts the recording of the remote connection described by
+// the `m` when it's wanted by the configuration, or by the Preset (`record`).
+// Connections marked as "no trace" are never recorded. Returns nil when the
+// connection is not recorded, and an error when it must be, but can't be
+func startRecording(
+	cfg command.Configuration,
+	l log.Logger,
+	noTrace bool,
+	record bool,
+	m recording.Meta,
+) (*recording.Recording, error) {
+	if !cfg.Recorder.Enabled() || noTrace {
+		return nil, nil
+	}
+
+	if !record && !cfg.RecordAllSessions {
+		return nil, nil
+	}
+
+	m.User = cfg.User
+	m.Client = cfg.ClientAddress
+
+	rec, err := cfg.Recorder.Record(m)
+	if err != nil {
+		l.Warning("Unable to record the session: %s", err)
+
+		// Details of the failure are about the server, not for the client
+		return nil, ErrRecordingUnavailable
+	}
+
+	l.Debug("Recording the session into %q", rec.Path())
+
+	return rec, nil
+}
@@ -0,0 +1,81 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/risk"
+	"github.com/nirui/sshwifty/application/stepup"
+)
+
+// scoreRisk requests the risk score of the session which is connecting to
+// the remote described by the `params` to run the `cmd` (empty for a
+// shell). It returns the method of the step-up authentication that's
+// required by the score (empty if none is required), or an error when the
+// session must be refused
+func scoreRisk(
+	ctx context.Context,
+	cfg command.Configuration,
+	params command.HookParameters,
+	cmd string,
+	j *remoteJournal,
+	l log.Logger,
+) (stepup.Method, error) {
+	if !cfg.Risk.Enabled() {
+		return "", nil
+	}
+
+	a, action, err := cfg.Risk.Assess(ctx, params, cmd)
+	if err != nil {
+		l.Warning("Unable to score the risk of the session: %s", err)
+
+		if action == risk.ActionTerminate {
+			return "", fmt.Errorf("%w: %s", risk.ErrTerminated, err)
+		}
+
+		return "", nil
+	}
+
+	if action == risk.ActionNone {
+		return "", nil
+	}
+
+	l.Warning("Risk score %v (%q) of the session has triggered %q",
+		a.Score, a.Reason, action)
+
+	j.risky(a, action)
+
+	switch action {
+	case risk.ActionTerminate:
+		if len(a.Reason) > 0 {
+			return "", fmt.Errorf("%w: %s", risk.ErrTerminated, a.Reason)
+		}
+
+		return "", risk.ErrTerminated
+
+	case risk.ActionStepUp:
+		return cfg.Risk.StepUpMethod(), nil
+
+	default:
+		return "", nil
+	}
+}
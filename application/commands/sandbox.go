@@ -0,0 +1,92 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+// Reasons of the sandbox connections being ended
+const (
+	sandboxReasonMaxDuration = "Time limit of the sandbox has been reached"
+	sandboxReasonMaxBytes    = "Transfer limit of the sandbox has been reached"
+)
+
+// sandboxLimits ends the remote connection to a sandbox Preset once it has
+// lasted, or transferred, more than the Sandbox allows. All methods of a nil
+// sandboxLimits do nothing
+type sandboxLimits struct {
+	limits    configuration.Sandbox
+	terminate func(reason string)
+	timer     *time.Timer
+	once      sync.Once
+}
+
+// newSandboxLimits creates a sandboxLimits which calls `terminate` once the
+// limits of `s` are reached, or returns nil when `s` is nil
+func newSandboxLimits(
+	s *configuration.Sandbox,
+	terminate func(reason string),
+) *sandboxLimits {
+	if s == nil {
+		return nil
+	}
+
+	return &sandboxLimits{
+		limits:    *s,
+		terminate: terminate,
+		timer:     nil,
+		once:      sync.Once{},
+	}
+}
+
+func (s *sandboxLimits) end(reason string) {
+	s.once.Do(func() { s.terminate(reason) })
+}
+
+// start starts counting the duration of the connection
+func (s *sandboxLimits) start() {
+	if s == nil {
+		return
+	}
+
+	s.timer = time.AfterFunc(s.limits.MaxDuration, func() {
+		s.end(sandboxReasonMaxDuration)
+	})
+}
+
+// transferred checks the `total` bytes transferred by the connection
+func (s *sandboxLimits) transferred(total uint64) {
+	if s == nil || total < s.limits.MaxBytes {
+		return
+	}
+
+	s.end(sandboxReasonMaxBytes)
+}
+
+// stop stops counting the duration of the connection
+func (s *sandboxLimits) stop() {
+	if s == nil || s.timer == nil {
+		return
+	}
+
+	s.timer.Stop()
+}
@@ -0,0 +1,74 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+func TestSandboxLimits(t *testing.T) {
+	if newSandboxLimits(nil, nil) != nil {
+		t.Error("Expecting no limits without a Sandbox")
+	}
+
+	var nilLimits *sandboxLimits
+	nilLimits.start()
+	nilLimits.transferred(1)
+	nilLimits.stop()
+
+	reasons := make(chan string, 2)
+	s := newSandboxLimits(&configuration.Sandbox{
+		MaxDuration: time.Hour,
+		MaxBytes:    10,
+	}, func(reason string) { reasons <- reason })
+
+	s.start()
+	s.transferred(9)
+	s.transferred(10)
+	s.transferred(11)
+	s.stop()
+
+	if r := <-reasons; r != sandboxReasonMaxBytes {
+		t.Errorf("Expecting %q, got %q", sandboxReasonMaxBytes, r)
+	}
+
+	if len(reasons) != 0 {
+		t.Error("Expecting the connection to be terminated only once")
+	}
+
+	s = newSandboxLimits(&configuration.Sandbox{
+		MaxDuration: time.Millisecond,
+		MaxBytes:    10,
+	}, func(reason string) { reasons <- reason })
+
+	s.start()
+	defer s.stop()
+
+	select {
+	case r := <-reasons:
+		if r != sandboxReasonMaxDuration {
+			t.Errorf("Expecting %q, got %q", sandboxReasonMaxDuration, r)
+		}
+
+	case <-time.After(time.Second):
+		t.Error("Expecting the connection to be terminated once timed out")
+	}
+}
@@ -0,0 +1,109 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/sharing"
+)
+
+// Errors
+var (
+	ErrSharingTooLarge = errors.New(
+		"the list of the sharing sessions is too large to be sent")
+)
+
+const (
+	// Max amount of other sessions listed to the client, the following
+	// ones are only counted by the audit events
+	sharingMaxListed = 16
+)
+
+// shareRemote joins the session to the `account` of the `remote`, so the
+// client is told about the other sessions connected to it through the
+// `send`, once there are any, and every time they've changed. It also
+// records the sharing into the `j` when the account was already in use. The
+// returned function must be called once the session has left the remote.
+// The sessions of the "no trace" remotes don't take part in the sharing
+func shareRemote(
+	cfg command.Configuration,
+	protocol string,
+	remote string,
+	account string,
+	j *remoteJournal,
+	l log.Logger,
+	send func(sessions sharingSessions) error,
+) func() {
+	if j.noTrace {
+		return func() {}
+	}
+
+	notify := func(others []sharing.Session) {
+		if len(others) > sharingMaxListed {
+			others = others[:sharingMaxListed]
+		}
+
+		if err := send(sharingSessions(others)); err != nil {
+			l.Debug("Unable to send the sharing sessions: %s", err)
+		}
+	}
+
+	already, leave := cfg.Sharing.Join(sharing.Target{
+		Tenant:   cfg.Tenant,
+		Protocol: protocol,
+		Remote:   remote,
+		Account:  account,
+	}, sharing.Session{
+		User:   cfg.User,
+		Client: cfg.ClientIP(),
+		Since:  time.Now(),
+	}, notify)
+
+	if len(already) <= 0 {
+		return leave
+	}
+
+	l.Info("Account is shared with %d other session(s)", len(already))
+
+	j.shared(already)
+
+	return leave
+}
+
+// describeSharing returns a description of the `sessions` that's recorded
+// into the audit events
+func describeSharing(sessions []sharing.Session) string {
+	described := make([]string, len(sessions))
+
+	for i, s := range sessions {
+		if len(s.User) <= 0 {
+			described[i] = s.Client
+
+			continue
+		}
+
+		described[i] = s.User + " (" + s.Client + ")"
+	}
+
+	return strings.Join(described, ", ")
+}
@@ -0,0 +1,89 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"testing"
+
+	"github.com/nirui/sshwifty/application/audit"
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/journal"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/sharing"
+)
+
+func TestShareRemote(t *testing.T) {
+	feed := audit.NewFeed()
+	sub := feed.Subscribe(4)
+	defer sub.Close()
+
+	registry := sharing.New()
+	sent := map[string][]sharing.Session{}
+
+	share := func(user, client string) func() {
+		cfg := command.Configuration{
+			Events:        feed,
+			ClientAddress: client + ":1234",
+			User:          user,
+			Sharing:       registry,
+		}
+
+		r := newRemoteJournal(cfg, log.NewDitch(), "SSH", "host:22")
+		r.describe(map[string]string{"login_user": "root"})
+
+		return shareRemote(cfg, "SSH", "host:22", "root", r, log.NewDitch(),
+			func(sessions sharingSessions) error {
+				sent[user] = sessions
+
+				return nil
+			})
+	}
+
+	leaveAlice := share("alice", "192.0.2.1")
+	defer leaveAlice()
+
+	if _, ok := sent["alice"]; ok {
+		t.Error("Expecting alice not to be told while being alone")
+	}
+
+	leaveBob := share("bob", "192.0.2.2")
+
+	e := <-sub.Events()
+	if e.Type != journal.REMOTE_SHARED || e.User != "bob" ||
+		e.Details["sessions"] != "1" ||
+		e.Details["others"] != "alice (192.0.2.1)" ||
+		e.Details["login_user"] != "root" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+
+	if len(sent["bob"]) != 1 || sent["bob"][0].User != "alice" ||
+		sent["bob"][0].Client != "192.0.2.1" {
+		t.Errorf("Expecting bob to be told about alice, got %+v", sent["bob"])
+	}
+
+	if len(sent["alice"]) != 1 || sent["alice"][0].User != "bob" {
+		t.Errorf("Expecting alice to be told about bob, got %+v",
+			sent["alice"])
+	}
+
+	leaveBob()
+
+	if len(sent["alice"]) != 0 {
+		t.Errorf("Expecting alice to be alone again, got %+v", sent["alice"])
+	}
+}
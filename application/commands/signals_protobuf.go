@@ -0,0 +1,541 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nirui/sshwifty/application/sharing"
+)
+
+// Framings of the payloads of the signals. The client requests
+// SSHFramingProtobuf by setting SSHOptionProtobuf, and the server confirms it
+// with SSHServerExtendedFraming before sending any payload in it. Servers
+// which don't support it ignore the option and keep SSHFramingBinary. The
+// Telnet and the Conserver commands negotiate it in the same way, through
+// TelnetOptionProtobuf and ConserverOptionProtobuf
+const (
+	SSHFramingBinary   byte = 0x00
+	SSHFramingProtobuf byte = 0x01
+)
+
+// Errors
+var (
+	ErrSignalMalformedProtobuf = errors.New(
+		"malformed protobuf payload")
+
+	ErrSignalPayloadTooLarge = errors.New(
+		"signal payload is too large")
+)
+
+// Wire types of protobuf
+const (
+	protobufWireVarint  = 0
+	protobufWireFixed64 = 1
+	protobufWireBytes   = 2
+	protobufWireFixed32 = 5
+)
+
+// protobufMessage encodes a protobuf message. Fields of zero value are
+// omitted, as proto3 does
+type protobufMessage []byte
+
+func (p *protobufMessage) tag(field int, wire int) {
+	*p = binary.AppendUvarint(*p, uint64(field<<3|wire))
+}
+
+func (p *protobufMessage) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+
+	p.tag(field, protobufWireVarint)
+	*p = binary.AppendUvarint(*p, v)
+}
+
+func (p *protobufMessage) bool(field int, v bool) {
+	if !v {
+		return
+	}
+
+	p.varint(field, 1)
+}
+
+func (p *protobufMessage) bytes(field int, b []byte) {
+	if len(b) <= 0 {
+		return
+	}
+
+	p.embed(field, b)
+}
+
+func (p *protobufMessage) string(field int, s string) {
+	p.bytes(field, []byte(s))
+}
+
+// embed encodes `sub` as a field of embedded message. Unlike bytes, empty
+// messages are still encoded, so they keep their place in repeated fields
+func (p *protobufMessage) embed(field int, sub []byte) {
+	p.tag(field, protobufWireBytes)
+	*p = binary.AppendUvarint(*p, uint64(len(sub)))
+	*p = append(*p, sub...)
+}
+
+// protobufField is a decoded field of a protobuf message
+type protobufField struct {
+	number int
+	wire   int
+	varint uint64
+	bytes  []byte
+}
+
+// protobufFields decodes the fields of the protobuf message `b`. Fields of
+// the fixed size wire types are skipped, as none of the messages uses them
+func protobufFields(b []byte) ([]protobufField, error) {
+	result := []protobufField{}
+
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 || tag>>3 == 0 {
+			return nil, ErrSignalMalformedProtobuf
+		}
+		b = b[n:]
+
+		f := protobufField{
+			number: int(tag >> 3),
+			wire:   int(tag & 7),
+		}
+
+		switch f.wire {
+		case protobufWireVarint:
+			f.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, ErrSignalMalformedProtobuf
+			}
+			b = b[n:]
+
+		case protobufWireFixed64:
+			if len(b) < 8 {
+				return nil, ErrSignalMalformedProtobuf
+			}
+			b = b[8:]
+
+			continue
+
+		case protobufWireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return nil, ErrSignalMalformedProtobuf
+			}
+			f.bytes = b[n : n+int(l)]
+			b = b[n+int(l):]
+
+		case protobufWireFixed32:
+			if len(b) < 4 {
+				return nil, ErrSignalMalformedProtobuf
+			}
+			b = b[4:]
+
+			continue
+
+		default:
+			return nil, ErrSignalMalformedProtobuf
+		}
+
+		result = append(result, f)
+	}
+
+	return result, nil
+}
+
+// signalPayload is a payload of the signals which is described by a message
+// of signals.proto
+type signalPayload interface {
+	// binary encodes the payload in SSHFramingBinary
+	binary() ([]byte, error)
+
+	// protobuf encodes the payload as it's message
+	protobuf() []byte
+}
+
+// encodeSignalPayload encodes the `p` in the `framing`
+func encodeSignalPayload(framing byte, p signalPayload) ([]byte, error) {
+	if framing == SSHFramingProtobuf {
+		return p.protobuf(), nil
+	}
+
+	return p.binary()
+}
+
+// sshPromptCountdown is the payload of SSHServerExtendedPromptCountdown, in
+// seconds
+type sshPromptCountdown uint16
+
+func (c sshPromptCountdown) binary() ([]byte, error) {
+	return binary.BigEndian.AppendUint16(nil, uint16(c)), nil
+}
+
+func (c sshPromptCountdown) protobuf() []byte {
+	m := protobufMessage{}
+	m.varint(1, uint64(c))
+
+	return m
+}
+
+// sshAttempt is the payload of SSHServerExtendedAuthAttempt and
+// SSHServerExtendedKeyPassphrase
+type sshAttempt struct {
+	attempt byte
+	max     byte
+}
+
+func (a sshAttempt) binary() ([]byte, error) {
+	return []byte{a.attempt, a.max}, nil
+}
+
+func (a sshAttempt) protobuf() []byte {
+	m := protobufMessage{}
+	m.varint(1, uint64(a.attempt))
+	m.varint(2, uint64(a.max))
+
+	return m
+}
+
+// sshFingerprint is the payload of SSHServerConnectVerifyFingerprint and
+// SSHServerExtendedHostKeyChanged
+type sshFingerprint string
+
+func (f sshFingerprint) binary() ([]byte, error) {
+	return []byte(f), nil
+}
+
+func (f sshFingerprint) protobuf() []byte {
+	m := protobufMessage{}
+	m.string(1, string(f))
+
+	return m
+}
+
+// sshExitStatus is the payload of SSHServerExtendedSessionEnded
+type sshExitStatus struct {
+	reported bool // Whether or not the remote has reported the exit status
+	status   uint32
+	signal   string
+}
+
+func (s sshExitStatus) binary() ([]byte, error) {
+	if !s.reported {
+		return []byte{}, nil
+	}
+
+	return append(
+		binary.BigEndian.AppendUint32(nil, s.status), s.signal...), nil
+}
+
+func (s sshExitStatus) protobuf() []byte {
+	m := protobufMessage{}
+	m.varint(1, uint64(s.status))
+	m.string(2, s.signal)
+	m.bool(3, s.reported)
+
+	return m
+}
+
+func (t connectTiming) binary() ([]byte, error) {
+	return json.Marshal(t)
+}
+
+func (t connectTiming) protobuf() []byte {
+	m := protobufMessage{}
+	m.varint(1, uint64(t.Started))
+	m.string(2, t.Phase)
+	m.varint(3, uint64(t.PhaseElapsed))
+	m.bool(4, t.TimedOut)
+
+	// Sorted, so the same timing always results the same bytes
+	phases := make([]string, 0, len(t.Completed))
+	for phase := range t.Completed {
+		phases = append(phases, phase)
+	}
+	sort.Strings(phases)
+
+	for _, phase := range phases {
+		entry := protobufMessage{}
+		entry.string(1, phase)
+		entry.varint(2, uint64(t.Completed[phase]))
+
+		m.embed(5, entry)
+	}
+
+	return m
+}
+
+func (i sshTransportInfo) binary() ([]byte, error) {
+	return json.Marshal(i)
+}
+
+func (i sshTransportInfo) protobuf() []byte {
+	m := protobufMessage{}
+	m.string(1, i.ClientVersion)
+	m.string(2, i.ServerVersion)
+	m.string(3, i.KeyExchange)
+	m.string(4, i.HostKey)
+	m.string(5, i.CipherClientServer)
+	m.string(6, i.CipherServerClient)
+	m.string(7, i.MACClientServer)
+	m.string(8, i.MACServerClient)
+
+	return m
+}
+
+// sshWeakTransport is the payload of SSHServerExtendedWeakTransport
+type sshWeakTransport []string
+
+func (w sshWeakTransport) binary() ([]byte, error) {
+	return json.Marshal([]string(w))
+}
+
+func (w sshWeakTransport) protobuf() []byte {
+	m := protobufMessage{}
+
+	for _, warning := range w {
+		m.embed(1, []byte(warning))
+	}
+
+	return m
+}
+
+// sshForwards is the payload of SSHServerExtendedForwards
+type sshForwards []sshForward
+
+func (f sshForwards) binary() ([]byte, error) {
+	return json.Marshal([]sshForward(f))
+}
+
+func (f sshForwards) protobuf() []byte {
+	m := protobufMessage{}
+
+	for _, forward := range f {
+		entry := protobufMessage{}
+		entry.string(1, forward.Name)
+		entry.string(2, forward.Target)
+		entry.string(3, forward.Local)
+		entry.string(4, forward.Preview)
+		entry.string(5, forward.Error)
+
+		m.embed(1, entry)
+	}
+
+	return m
+}
+
+func (a sshPushApproval) binary() ([]byte, error) {
+	return json.Marshal(a)
+}
+
+func (a sshPushApproval) protobuf() []byte {
+	m := protobufMessage{}
+	m.string(1, a.Provider)
+	m.varint(2, uint64(a.Timeout))
+
+	return m
+}
+
+func (r sshReverseForwardResult) binary() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r sshReverseForwardResult) protobuf() []byte {
+	m := protobufMessage{}
+	m.string(1, r.Bind)
+	m.string(2, r.Target)
+	m.string(3, r.Listen)
+	m.string(4, r.Error)
+
+	return m
+}
+
+func (s sshReconnectStatus) binary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (s sshReconnectStatus) protobuf() []byte {
+	m := protobufMessage{}
+	m.string(1, s.State)
+	m.varint(2, uint64(s.Attempt))
+	m.varint(3, uint64(s.Attempts))
+	m.varint(4, uint64(s.Delay))
+	m.string(5, s.Error)
+
+	return m
+}
+
+func (s sshHandoverStatus) binary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (s sshHandoverStatus) protobuf() []byte {
+	m := protobufMessage{}
+	m.string(1, s.State)
+	m.string(2, s.ID)
+	m.varint(3, uint64(s.Expires))
+	m.string(4, s.Error)
+
+	return m
+}
+
+// sharingSessions is the payload of SSHServerExtendedSharing and
+// TelnetServerSharing
+type sharingSessions []sharing.Session
+
+func (s sharingSessions) binary() ([]byte, error) {
+	return json.Marshal([]sharing.Session(s))
+}
+
+func (s sharingSessions) protobuf() []byte {
+	m := protobufMessage{}
+
+	for _, session := range s {
+		entry := protobufMessage{}
+		entry.string(1, session.User)
+		entry.string(2, session.Client)
+		entry.string(3, session.Since.Format(time.RFC3339Nano))
+
+		m.embed(1, entry)
+	}
+
+	return m
+}
+
+// conserverConsoleList is the payload of ConserverServerSelectConsole
+type conserverConsoleList []string
+
+func (c conserverConsoleList) binary() ([]byte, error) {
+	if len(c) <= 0 {
+		return nil, nil
+	}
+
+	return []byte(strings.Join(c, "\n") + "\n"), nil
+}
+
+func (c conserverConsoleList) protobuf() []byte {
+	m := protobufMessage{}
+
+	for _, name := range c {
+		m.embed(1, []byte(name))
+	}
+
+	return m
+}
+
+// fit returns the leading consoles which can be encoded in the `framing`
+// within `max` bytes
+func (c conserverConsoleList) fit(framing byte, max int) conserverConsoleList {
+	size := 0
+
+	for i, name := range c {
+		size += len(name) + 1
+
+		if framing == SSHFramingProtobuf {
+			size += len(binary.AppendUvarint(nil, uint64(len(name))))
+		}
+
+		if size > max {
+			return c[:i]
+		}
+	}
+
+	return c
+}
+
+// sshResize is the payload of SSHClientResize
+type sshResize struct {
+	rows   int
+	cols   int
+	width  int // In pixel, 0 when unknown
+	height int
+}
+
+// parseSSHResizeProtobuf decodes the SSHResize message `b`
+func parseSSHResizeProtobuf(b []byte) (sshResize, error) {
+	fields, err := protobufFields(b)
+	if err != nil {
+		return sshResize{}, err
+	}
+
+	r := sshResize{}
+
+	for _, f := range fields {
+		if f.wire != protobufWireVarint {
+			continue
+		}
+
+		v := int(min(f.varint, math.MaxUint16))
+
+		switch f.number {
+		case 1:
+			r.rows = v
+		case 2:
+			r.cols = v
+		case 3:
+			r.width = v
+		case 4:
+			r.height = v
+		}
+	}
+
+	return r, nil
+}
+
+// parseSSHReverseForwardRequest decodes the `b` in the `framing`
+func parseSSHReverseForwardRequest(
+	framing byte, b []byte) (sshReverseForwardRequest, error) {
+	req := sshReverseForwardRequest{}
+
+	if framing != SSHFramingProtobuf {
+		err := json.Unmarshal(b, &req)
+
+		return req, err
+	}
+
+	fields, err := protobufFields(b)
+	if err != nil {
+		return req, err
+	}
+
+	for _, f := range fields {
+		if f.wire != protobufWireBytes {
+			continue
+		}
+
+		switch f.number {
+		case 1:
+			req.Bind = string(f.bytes)
+		case 2:
+			req.Target = string(f.bytes)
+		}
+	}
+
+	return req, nil
+}
@@ -0,0 +1,329 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/rw"
+)
+
+// testProtobufDecode decodes the message `b` into a map of the field numbers
+// to the values of the fields, in the order they're encoded
+func testProtobufDecode(t *testing.T, b []byte) map[int][]any {
+	fields, err := protobufFields(b)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	result := map[int][]any{}
+
+	for _, f := range fields {
+		if f.wire == protobufWireVarint {
+			result[f.number] = append(result[f.number], f.varint)
+		} else {
+			result[f.number] = append(result[f.number], string(f.bytes))
+		}
+	}
+
+	return result
+}
+
+func TestSignalPayloadProtobuf(t *testing.T) {
+	for _, test := range []struct {
+		payload  signalPayload
+		binary   string
+		expected map[int][]any
+	}{
+		{
+			sshPromptCountdown(300),
+			"\x01\x2c",
+			map[int][]any{1: {uint64(300)}},
+		},
+		{
+			sshAttempt{attempt: 2, max: 3},
+			"\x02\x03",
+			map[int][]any{1: {uint64(2)}, 2: {uint64(3)}},
+		},
+		{
+			sshFingerprint("SHA256:abc"),
+			"SHA256:abc",
+			map[int][]any{1: {"SHA256:abc"}},
+		},
+		{
+			sshExitStatus{reported: true, status: 130, signal: "INT"},
+			"\x00\x00\x00\x82INT",
+			map[int][]any{1: {uint64(130)}, 2: {"INT"}, 3: {uint64(1)}},
+		},
+		{
+			sshExitStatus{reported: true},
+			"\x00\x00\x00\x00",
+			map[int][]any{3: {uint64(1)}},
+		},
+		{
+			sshExitStatus{},
+			"",
+			map[int][]any{},
+		},
+		{
+			sshWeakTransport{"weak kex", ""},
+			`["weak kex",""]`,
+			map[int][]any{1: {"weak kex", ""}},
+		},
+		{
+			sshReconnectStatus{State: "waiting", Attempt: 1, Attempts: 3},
+			`{"state":"waiting","attempt":1,"attempts":3}`,
+			map[int][]any{
+				1: {"waiting"}, 2: {uint64(1)}, 3: {uint64(3)},
+			},
+		},
+		{
+			sshHandoverStatus{State: "parked", ID: "a1", Expires: 1700000000},
+			`{"state":"parked","id":"a1","expires":1700000000}`,
+			map[int][]any{
+				1: {"parked"}, 2: {"a1"}, 3: {uint64(1700000000)},
+			},
+		},
+		{
+			sshPushApproval{Provider: "duo", Timeout: 60},
+			`{"provider":"duo","timeout":60}`,
+			map[int][]any{1: {"duo"}, 2: {uint64(60)}},
+		},
+		{
+			conserverConsoleList{"web1", "db1"},
+			"web1\ndb1\n",
+			map[int][]any{1: {"web1", "db1"}},
+		},
+		{
+			conserverConsoleList{},
+			"",
+			map[int][]any{},
+		},
+	} {
+		b, err := encodeSignalPayload(SSHFramingBinary, test.payload)
+		if err != nil || string(b) != test.binary {
+			t.Errorf("Expecting %#v to be encoded as %q, got %q (%v)",
+				test.payload, test.binary, b, err)
+		}
+
+		b, _ = encodeSignalPayload(SSHFramingProtobuf, test.payload)
+
+		decoded := testProtobufDecode(t, b)
+		if !reflect.DeepEqual(decoded, test.expected) {
+			t.Errorf("Expecting %#v to be encoded as %v, got %v",
+				test.payload, test.expected, decoded)
+		}
+	}
+}
+
+func TestSignalPayloadProtobufEmbedded(t *testing.T) {
+	timing := connectTiming{
+		Started:   1700000000000,
+		Phase:     "auth",
+		TimedOut:  true,
+		Completed: map[string]int64{"tcp": 5, "dns": 0},
+	}
+
+	decoded := testProtobufDecode(t, timing.protobuf())
+	if decoded[1][0] != uint64(1700000000000) || decoded[2][0] != "auth" ||
+		decoded[4][0] != uint64(1) || len(decoded[5]) != 2 {
+		t.Fatal("Unexpected timing:", decoded)
+	}
+
+	// Sorted by the phases, and the zero values are kept as entries
+	dns := testProtobufDecode(t, []byte(decoded[5][0].(string)))
+	tcp := testProtobufDecode(t, []byte(decoded[5][1].(string)))
+
+	if dns[1][0] != "dns" || len(dns[2]) != 0 ||
+		tcp[1][0] != "tcp" || tcp[2][0] != uint64(5) {
+		t.Error("Unexpected completed phases:", dns, tcp)
+	}
+
+	forwards := sshForwards{
+		{Name: "web", Target: "127.0.0.1:80", Local: "127.0.0.1:8080"},
+		{},
+	}
+
+	decoded = testProtobufDecode(t, forwards.protobuf())
+	if len(decoded[1]) != 2 || decoded[1][1] != "" {
+		t.Fatal("Expecting every forward to be encoded, got", decoded)
+	}
+
+	web := testProtobufDecode(t, []byte(decoded[1][0].(string)))
+	if web[1][0] != "web" || web[2][0] != "127.0.0.1:80" ||
+		web[3][0] != "127.0.0.1:8080" {
+		t.Error("Unexpected forward:", web)
+	}
+}
+
+func TestSignalPayloadSharingSessions(t *testing.T) {
+	since := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sessions := sharingSessions{
+		{User: "alice", Client: "192.0.2.1", Since: since},
+		{Client: "192.0.2.2", Since: since},
+	}
+
+	b, err := encodeSignalPayload(SSHFramingBinary, sessions)
+	if err != nil || string(b) != `[`+
+		`{"user":"alice","client":"192.0.2.1","since":"2024-01-02T03:04:05Z"},`+
+		`{"client":"192.0.2.2","since":"2024-01-02T03:04:05Z"}]` {
+		t.Errorf("Unexpected JSON %s (%v)", b, err)
+	}
+
+	decoded := testProtobufDecode(t, sessions.protobuf())
+	if len(decoded[1]) != 2 {
+		t.Fatal("Expecting every session to be encoded, got", decoded)
+	}
+
+	alice := testProtobufDecode(t, []byte(decoded[1][0].(string)))
+	if alice[1][0] != "alice" || alice[2][0] != "192.0.2.1" ||
+		alice[3][0] != "2024-01-02T03:04:05Z" {
+		t.Error("Unexpected session:", alice)
+	}
+
+	anonymous := testProtobufDecode(t, []byte(decoded[1][1].(string)))
+	if len(anonymous[1]) != 0 || anonymous[2][0] != "192.0.2.2" {
+		t.Error("Unexpected session:", anonymous)
+	}
+}
+
+func TestConserverConsoleListFit(t *testing.T) {
+	consoles := conserverConsoleList{"web1", "db1", "mail1"}
+
+	for _, test := range []struct {
+		framing  byte
+		max      int
+		expected int
+	}{
+		{SSHFramingBinary, 100, 3},
+		{SSHFramingBinary, 9, 2},
+		{SSHFramingBinary, 8, 1},
+		{SSHFramingProtobuf, 100, 3},
+		{SSHFramingProtobuf, 11, 2},
+		{SSHFramingProtobuf, 10, 1},
+		{SSHFramingProtobuf, 5, 0},
+	} {
+		fit := consoles.fit(test.framing, test.max)
+		if len(fit) != test.expected {
+			t.Errorf("Expecting %d consoles to fit into %d bytes of %d, "+
+				"got %v", test.expected, test.max, test.framing, fit)
+		}
+
+		b, _ := encodeSignalPayload(test.framing, fit)
+		if len(b) > test.max {
+			t.Errorf("Expecting %v to fit into %d bytes, got %d",
+				fit, test.max, len(b))
+		}
+	}
+}
+
+func TestSignalPayloadProtobufParse(t *testing.T) {
+	m := protobufMessage{}
+	m.varint(1, 24)
+	m.varint(2, 80)
+	m.varint(4, 1<<20) // Out of range, clamped
+	m.string(9, "unknown fields are skipped")
+
+	size, err := parseSSHResizeProtobuf(m)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if size != (sshResize{rows: 24, cols: 80, width: 0, height: 0xffff}) {
+		t.Error("Unexpected size:", size)
+	}
+
+	m = protobufMessage{}
+	m.string(1, "127.0.0.1:8022")
+	m.string(2, "localhost:22")
+
+	req, err := parseSSHReverseForwardRequest(SSHFramingProtobuf, m)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if req.Bind != "127.0.0.1:8022" || req.Target != "localhost:22" {
+		t.Error("Unexpected request:", req)
+	}
+
+	req, err = parseSSHReverseForwardRequest(
+		SSHFramingBinary, []byte(`{"bind":"a","target":"b"}`))
+	if err != nil || req.Bind != "a" || req.Target != "b" {
+		t.Error("Unexpected request:", req, err)
+	}
+
+	for _, malformed := range [][]byte{
+		{0x08},             // Missing varint
+		{0x12, 0x05, 'a'},  // Truncated bytes
+		{0x00},             // Field number 0
+		{0x0b, 0x00, 0x00}, // Unsupported wire type
+	} {
+		if _, err := protobufFields(malformed); err == nil {
+			t.Errorf("Expecting % x to be refused", malformed)
+		}
+	}
+}
+
+func TestSSHParseResize(t *testing.T) {
+	read := func(framing byte, payload []byte) (sshResize, error) {
+		d := &sshClient{framing: framing}
+		fr := rw.NewFetchReader(func() ([]byte, error) {
+			if len(payload) <= 0 {
+				return nil, io.EOF
+			}
+
+			p := payload
+			payload = nil
+
+			return p, nil
+		})
+		r := rw.NewLimitedReader(&fr, len(payload))
+
+		return d.parseResize(&r, make([]byte, 32))
+	}
+
+	size, err := read(SSHFramingBinary, []byte{0, 24, 0, 80})
+	if err != nil || size != (sshResize{rows: 24, cols: 80}) {
+		t.Error("Unexpected size:", size, err)
+	}
+
+	size, err = read(SSHFramingBinary, []byte{0, 24, 0, 80, 3, 0, 1, 0})
+	if err != nil ||
+		size != (sshResize{rows: 24, cols: 80, width: 768, height: 256}) {
+		t.Error("Unexpected size:", size, err)
+	}
+
+	m := protobufMessage{}
+	m.varint(1, 24)
+	m.varint(2, 80)
+	m.varint(3, 768)
+
+	size, err = read(SSHFramingProtobuf, m)
+	if err != nil || size != (sshResize{rows: 24, cols: 80, width: 768}) {
+		t.Error("Unexpected size:", size, err)
+	}
+
+	_, err = read(SSHFramingProtobuf, bytes.Repeat([]byte{0x08, 0x01}, 17))
+	if err != ErrSignalPayloadTooLarge {
+		t.Error("Expecting ErrSignalPayloadTooLarge, got", err)
+	}
+}
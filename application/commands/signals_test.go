@@ -0,0 +1,174 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestSignalsSchema(t *testing.T) {
+	expected := map[string]int{
+		"SSH_SERVER_REMOTE_STDOUT":                    SSHServerRemoteStdOut,
+		"SSH_SERVER_REMOTE_STDERR":                    SSHServerRemoteStdErr,
+		"SSH_SERVER_HOOK_OUTPUT_BEFORE_CONNECTING":    SSHServerHookOutputBeforeConnecting,
+		"SSH_SERVER_CONNECT_FAILED":                   SSHServerConnectFailed,
+		"SSH_SERVER_CONNECT_SUCCEED":                  SSHServerConnectSucceed,
+		"SSH_SERVER_CONNECT_VERIFY_FINGERPRINT":       SSHServerConnectVerifyFingerprint,
+		"SSH_SERVER_CONNECT_REQUEST_CREDENTIAL":       SSHServerConnectRequestCredential,
+		"SSH_SERVER_EXTENDED":                         SSHServerExtended,
+		"SSH_SERVER_EXTENDED_PROMPT_COUNTDOWN":        SSHServerExtendedPromptCountdown,
+		"SSH_SERVER_EXTENDED_AUTH_ATTEMPT":            SSHServerExtendedAuthAttempt,
+		"SSH_SERVER_EXTENDED_CONNECT_TIMING":          SSHServerExtendedConnectTiming,
+		"SSH_SERVER_EXTENDED_TRANSPORT_INFO":          SSHServerExtendedTransportInfo,
+		"SSH_SERVER_EXTENDED_FORWARDS":                SSHServerExtendedForwards,
+		"SSH_SERVER_EXTENDED_REVERSE_FORWARD":         SSHServerExtendedReverseForward,
+		"SSH_SERVER_EXTENDED_DYNAMIC":                 SSHServerExtendedDynamic,
+		"SSH_SERVER_EXTENDED_PUSH_APPROVAL":           SSHServerExtendedPushApproval,
+		"SSH_SERVER_EXTENDED_STEP_UP":                 SSHServerExtendedStepUp,
+		"SSH_SERVER_EXTENDED_FILE_TRANSFER":           SSHServerExtendedFileTransfer,
+		"SSH_SERVER_EXTENDED_KEY_PASSPHRASE":          SSHServerExtendedKeyPassphrase,
+		"SSH_SERVER_EXTENDED_HOST_KEY_CHANGED":        SSHServerExtendedHostKeyChanged,
+		"SSH_SERVER_EXTENDED_LOCAL_FORWARD":           SSHServerExtendedLocalForward,
+		"SSH_SERVER_EXTENDED_SESSION_ENDED":           SSHServerExtendedSessionEnded,
+		"SSH_SERVER_EXTENDED_BANNER":                  SSHServerExtendedBanner,
+		"SSH_SERVER_EXTENDED_WEAK_TRANSPORT":          SSHServerExtendedWeakTransport,
+		"SSH_SERVER_EXTENDED_RECONNECT":               SSHServerExtendedReconnect,
+		"SSH_SERVER_EXTENDED_HANDOVER":                SSHServerExtendedHandover,
+		"SSH_SERVER_EXTENDED_INPUT_SEQUENCE":          SSHServerExtendedInputSequence,
+		"SSH_SERVER_EXTENDED_CONNECT_FAILURE":         SSHServerExtendedConnectFailure,
+		"SSH_SERVER_EXTENDED_SHARING":                 SSHServerExtendedSharing,
+		"SSH_SERVER_EXTENDED_FRAMING":                 SSHServerExtendedFraming,
+		"SSH_CLIENT_STD_IN":                           SSHClientStdIn,
+		"SSH_CLIENT_RESIZE":                           SSHClientResize,
+		"SSH_CLIENT_RESPOND_FINGERPRINT":              SSHClientRespondFingerprint,
+		"SSH_CLIENT_RESPOND_CREDENTIAL":               SSHClientRespondCredential,
+		"SSH_CLIENT_REVERSE_FORWARD":                  SSHClientReverseForward,
+		"SSH_CLIENT_DYNAMIC":                          SSHClientDynamic,
+		"SSH_CLIENT_RESPOND_STEP_UP":                  SSHClientRespondStepUp,
+		"SSH_CLIENT_FILE_TRANSFER":                    SSHClientFileTransfer,
+		"SSH_AUTH_METHOD_NONE":                        int(SSHAuthMethodNone),
+		"SSH_AUTH_METHOD_PASSPHRASE":                  int(SSHAuthMethodPassphrase),
+		"SSH_AUTH_METHOD_PRIVATE_KEY":                 int(SSHAuthMethodPrivateKey),
+		"SSH_OPTION_NONE":                             0,
+		"SSH_OPTION_DEBUG_TRANSPORT":                  int(SSHOptionDebugTransport),
+		"SSH_OPTION_EXEC":                             int(SSHOptionExec),
+		"SSH_OPTION_TERMINAL":                         int(SSHOptionTerminal),
+		"SSH_OPTION_ENVIRONMENT":                      int(SSHOptionEnvironment),
+		"SSH_OPTION_PRIVATE_KEY":                      int(SSHOptionPrivateKey),
+		"SSH_OPTION_RESUME":                           int(SSHOptionResume),
+		"SSH_OPTION_SEQUENCED_INPUT":                  int(SSHOptionSequencedInput),
+		"SSH_OPTION_PROTOBUF":                         int(SSHOptionProtobuf),
+		"SSH_FRAMING_BINARY":                          int(SSHFramingBinary),
+		"SSH_FRAMING_PROTOBUF":                        int(SSHFramingProtobuf),
+		"SSH_DYNAMIC_OPEN":                            SSHDynamicOpen,
+		"SSH_DYNAMIC_DATA":                            SSHDynamicData,
+		"SSH_DYNAMIC_CLOSE":                           SSHDynamicClose,
+		"SSH_DYNAMIC_INTERRUPT":                       SSHDynamicInterrupt,
+		"SSH_DYNAMIC_HANDOVER":                        SSHDynamicHandover,
+		"SSH_DYNAMIC_SIGNAL":                          SSHDynamicSignal,
+		"SSH_DYNAMIC_LOCAL_FORWARD":                   SSHDynamicLocalForward,
+		"SSH_FILE_TRANSFER_LIST":                      SSHFileTransferList,
+		"SSH_FILE_TRANSFER_DOWNLOAD":                  SSHFileTransferDownload,
+		"SSH_FILE_TRANSFER_UPLOAD":                    SSHFileTransferUpload,
+		"SSH_FILE_TRANSFER_DATA":                      SSHFileTransferData,
+		"SSH_FILE_TRANSFER_RENAME":                    SSHFileTransferRename,
+		"SSH_FILE_TRANSFER_REMOVE":                    SSHFileTransferRemove,
+		"SSH_FILE_TRANSFER_MKDIR":                     SSHFileTransferMkdir,
+		"SSH_FILE_TRANSFER_DONE":                      SSHFileTransferDone,
+		"SSH_FILE_TRANSFER_CLOSE":                     SSHFileTransferClose,
+		"SSH_FILE_TRANSFER_ENTRY_FILE":                SSHFileTransferEntryFile,
+		"SSH_FILE_TRANSFER_ENTRY_DIRECTORY":           SSHFileTransferEntryDirectory,
+		"SSH_FILE_TRANSFER_ENTRY_SYMLINK":             SSHFileTransferEntrySymlink,
+		"SSH_FILE_TRANSFER_ENTRY_OTHER":               SSHFileTransferEntryOther,
+		"STEP_UP_METHOD_PASSWORD":                     StepUpMethodPassword,
+		"STEP_UP_METHOD_TOTP":                         StepUpMethodTOTP,
+		"DIAL_FAILURE_UNKNOWN":                        int(DialFailureUnknown),
+		"DIAL_FAILURE_NAME_NOT_RESOLVED":              int(DialFailureNameNotResolved),
+		"DIAL_FAILURE_CONNECTION_REFUSED":             int(DialFailureConnectionRefused),
+		"DIAL_FAILURE_UNREACHABLE":                    int(DialFailureUnreachable),
+		"DIAL_FAILURE_TIMED_OUT":                      int(DialFailureTimedOut),
+		"DIAL_FAILURE_PROXY":                          int(DialFailureProxy),
+		"DIAL_FAILURE_TLS":                            int(DialFailureTLS),
+		"DIAL_FAILURE_AUTH_REJECTED":                  int(DialFailureAuthRejected),
+		"DIAL_FAILURE_HOST_KEY_MISMATCH":              int(DialFailureHostKeyMismatch),
+		"TELNET_SERVER_REMOTE_BAND":                   TelnetServerRemoteBand,
+		"TELNET_SERVER_HOOK_OUTPUT_BEFORE_CONNECTING": TelnetServerHookOutputBeforeConnecting,
+		"TELNET_SERVER_DIAL_FAILED":                   TelnetServerDialFailed,
+		"TELNET_SERVER_DIAL_CONNECTED":                TelnetServerDialConnected,
+		"TELNET_SERVER_STEP_UP":                       TelnetServerStepUp,
+		"TELNET_SERVER_DIAL_FAILURE":                  TelnetServerDialFailure,
+		"TELNET_SERVER_SHARING":                       TelnetServerSharing,
+		"TELNET_SERVER_FRAMING":                       TelnetServerFraming,
+		"TELNET_CLIENT_REMOTE_BAND":                   TelnetClientRemoteBand,
+		"TELNET_CLIENT_RESPOND_STEP_UP":               TelnetClientRespondStepUp,
+		"TELNET_CLIENT_INTERRUPT":                     TelnetClientInterrupt,
+		"TELNET_OPTION_NONE":                          0,
+		"TELNET_OPTION_SEQUENCED_INPUT":               int(TelnetOptionSequencedInput),
+		"TELNET_OPTION_PROTOBUF":                      int(TelnetOptionProtobuf),
+
+		"CONSERVER_SERVER_REMOTE_BAND":                   ConserverServerRemoteBand,
+		"CONSERVER_SERVER_HOOK_OUTPUT_BEFORE_CONNECTING": ConserverServerHookOutputBeforeConnecting,
+		"CONSERVER_SERVER_CONNECT_FAILED":                ConserverServerConnectFailed,
+		"CONSERVER_SERVER_CONNECTED":                     ConserverServerConnected,
+		"CONSERVER_SERVER_STEP_UP":                       ConserverServerStepUp,
+		"CONSERVER_SERVER_REQUEST_PASSWORD":              ConserverServerRequestPassword,
+		"CONSERVER_SERVER_SELECT_CONSOLE":                ConserverServerSelectConsole,
+		"CONSERVER_SERVER_FRAMING":                       ConserverServerFraming,
+		"CONSERVER_CLIENT_REMOTE_BAND":                   ConserverClientRemoteBand,
+		"CONSERVER_CLIENT_RESPOND_STEP_UP":               ConserverClientRespondStepUp,
+		"CONSERVER_CLIENT_RESPOND":                       ConserverClientRespond,
+		"CONSERVER_OPTION_NONE":                          0,
+		"CONSERVER_OPTION_PROTOBUF":                      int(ConserverOptionProtobuf),
+	}
+
+	schema, err := os.ReadFile("signals.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := regexp.MustCompile(`(?m)^\s+([A-Z][A-Z0-9_]+) = (\d+);`).
+		FindAllSubmatch(schema, -1)
+
+	defined := map[string]bool{}
+
+	for _, v := range values {
+		name := string(v[1])
+		value, _ := strconv.Atoi(string(v[2]))
+
+		defined[name] = true
+
+		e, ok := expected[name]
+		if !ok {
+			t.Errorf("%s is not a known signal", name)
+			continue
+		}
+
+		if e != value {
+			t.Errorf("Expecting %s to be %d, got %d", name, e, value)
+		}
+	}
+
+	for name := range expected {
+		if !defined[name] {
+			t.Errorf("%s is missing from signals.proto", name)
+		}
+	}
+}
@@ -19,18 +19,29 @@ package commands
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"io"
 	"net"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"golang.org/x/crypto/ssh"
 
+	"github.com/nirui/sshwifty/application/approval"
 	"github.com/nirui/sshwifty/application/command"
 	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/hostkeys"
 	"github.com/nirui/sshwifty/application/log"
 	"github.com/nirui/sshwifty/application/network"
+	"github.com/nirui/sshwifty/application/recording"
+	"github.com/nirui/sshwifty/application/relay"
 	"github.com/nirui/sshwifty/application/rw"
 )
 
@@ -43,6 +54,35 @@ const (
 	SSHServerConnectSucceed             = 0x04
 	SSHServerConnectVerifyFingerprint   = 0x05
 	SSHServerConnectRequestCredential   = 0x06
+	SSHServerExtended                   = 0x07
+)
+
+// Extended server -> client signal consts. They're sent as SSHServerExtended
+// signal, with the first byte of the data indicating the type of the extended
+// signal
+const (
+	SSHServerExtendedPromptCountdown = 0x00
+	SSHServerExtendedAuthAttempt     = 0x01
+	SSHServerExtendedConnectTiming   = 0x02
+	SSHServerExtendedTransportInfo   = 0x03
+	SSHServerExtendedForwards        = 0x04
+	SSHServerExtendedReverseForward  = 0x05
+	SSHServerExtendedDynamic         = 0x06
+	SSHServerExtendedPushApproval    = 0x07
+	SSHServerExtendedStepUp          = 0x08
+	SSHServerExtendedFileTransfer    = 0x09
+	SSHServerExtendedKeyPassphrase   = 0x0a
+	SSHServerExtendedHostKeyChanged  = 0x0b
+	SSHServerExtendedLocalForward    = 0x0c
+	SSHServerExtendedSessionEnded    = 0x0d
+	SSHServerExtendedBanner          = 0x0e
+	SSHServerExtendedWeakTransport   = 0x0f
+	SSHServerExtendedReconnect       = 0x10
+	SSHServerExtendedHandover        = 0x11
+	SSHServerExtendedInputSequence   = 0x12
+	SSHServerExtendedConnectFailure  = 0x13
+	SSHServerExtendedSharing         = 0x14
+	SSHServerExtendedFraming         = 0x15
 )
 
 // Client -> server signal consts
@@ -51,10 +91,49 @@ const (
 	SSHClientResize             = 0x01
 	SSHClientRespondFingerprint = 0x02
 	SSHClientRespondCredential  = 0x03
+	SSHClientReverseForward     = 0x04
+	SSHClientDynamic            = 0x05
+	SSHClientRespondStepUp      = 0x06
+	SSHClientFileTransfer       = 0x07
+)
+
+const (
+	sshCredentialMaxSize       = 4096
+	sshPromptCountdownInterval = 5 * time.Second
+	sshMaxPassphraseAttempts   = 3
+	sshDefaultTerminalType     = "xterm"
+	sshDefaultTerminalRows     = 80
+	sshDefaultTerminalCols     = 40
+	sshMaxEnvironment          = 32
+	sshReasonClientLeft        = "Client has disconnected"
+)
+
+var (
+	sshTerminalTypeVerifier = regexp.MustCompile("^[0-9A-Za-z_.+-]{1,32}$")
+	sshEnvironmentVerifier  = regexp.MustCompile("^[A-Za-z_][0-9A-Za-z_]{0,63}$")
 )
 
+// sshSignals is the schema of client signals
+var sshSignals = command.Signals{
+	SSHClientStdIn:              command.Signal(0, command.StreamHeaderMaxLength),
+	SSHClientResize:             command.Signal(4, 8),
+	SSHClientRespondFingerprint: command.Signal(1, 1),
+	SSHClientRespondCredential:  command.Signal(0, sshCredentialMaxSize),
+	SSHClientReverseForward: command.Signal(
+		1, sshReverseForwardRequestMaxSize),
+	SSHClientDynamic: command.Signal(
+		sshDynamicFrameHeaderSize, command.StreamHeaderMaxLength),
+	SSHClientRespondStepUp: command.Signal(0, stepUpAnswerMaxSize),
+	SSHClientFileTransfer: command.Signal(
+		sshFileTransferFrameHeaderSize, command.StreamHeaderMaxLength),
+}
+
+// Connect phases of SSH, in addition to the ones of network.DialTrace
 const (
-	sshCredentialMaxSize = 4096
+	sshConnectPhaseHandshake    = "handshake"
+	sshConnectPhaseAuthenticate = "authenticate"
+	sshConnectPhaseApprove      = "approve"
+	sshConnectPhaseSession      = "session"
 )
 
 // Error codes
@@ -62,6 +141,11 @@ const (
 	SSHRequestErrorBadUserName      = command.StreamError(0x01)
 	SSHRequestErrorBadRemoteAddress = command.StreamError(0x02)
 	SSHRequestErrorBadAuthMethod    = command.StreamError(0x03)
+	SSHRequestErrorBadCommand       = command.StreamError(0x04)
+	SSHRequestErrorBadTerminal      = command.StreamError(0x05)
+	SSHRequestErrorBadEnvironment   = command.StreamError(0x06)
+	SSHRequestErrorBadPrivateKey    = command.StreamError(0x07)
+	SSHRequestErrorBadHandover      = command.StreamError(0x08)
 )
 
 // Auth methods
@@ -71,6 +155,18 @@ const (
 	SSHAuthMethodPrivateKey byte = 0x02
 )
 
+// Options, sent as an optional byte after the auth method
+const (
+	SSHOptionDebugTransport byte = 0x01
+	SSHOptionExec           byte = 0x02
+	SSHOptionTerminal       byte = 0x04
+	SSHOptionEnvironment    byte = 0x08
+	SSHOptionPrivateKey     byte = 0x10
+	SSHOptionResume         byte = 0x20
+	SSHOptionSequencedInput byte = 0x40
+	SSHOptionProtobuf       byte = 0x80
+)
+
 type sshAuthMethodBuilder func(b []byte) []ssh.AuthMethod
 
 // Errors
@@ -84,12 +180,32 @@ var (
 	ErrSSHInvalidAddress = errors.New(
 		"invalid address")
 
+	ErrSSHInvalidTerminalType = errors.New(
+		"terminal type must be a valid terminal name")
+
+	ErrSSHInvalidEnvironment = errors.New(
+		"environment variables must have valid names")
+
+	ErrSSHTooManyEnvironment = errors.New(
+		"too many environment variables")
+
 	ErrSSHRemoteFingerprintVerificationCancelled = errors.New(
 		"server Fingerprint verification process has been cancelled")
 
 	ErrSSHRemoteFingerprintRefused = errors.New(
 		"server Fingerprint has been refused")
 
+	ErrSSHRemoteHostKeyChanged = errors.New(
+		"host key of the remote has changed since it was recorded, the " +
+			"connection has been refused")
+
+	ErrSSHRemoteHostKeyRevoked = errors.New(
+		"host key of the remote has been revoked")
+
+	ErrSSHRemoteFingerprintUnexpected = errors.New(
+		"server Fingerprint is not the one expected by the Preset, the " +
+			"connection has been refused")
+
 	ErrSSHRemoteConnUnavailable = errors.New(
 		"remote SSH connection is unavailable")
 
@@ -104,6 +220,9 @@ var (
 
 	ErrSSHUnknownClientSignal = errors.New(
 		"unknown client signal")
+
+	ErrSSHPromptTimeout = errors.New(
+		"timed out waiting for the user to respond")
 )
 
 var (
@@ -117,19 +236,20 @@ const (
 type sshRemoteConnWrapper struct {
 	net.Conn
 
-	writerConn          network.WriteTimeoutConn
-	requestTimeoutRetry func(s *sshRemoteConnWrapper) bool
+	writerConn   network.WriteTimeoutConn
+	readDeadline *sshReadDeadline
 }
 
 func (s *sshRemoteConnWrapper) Read(b []byte) (int, error) {
 	for {
 		rLen, rErr := s.Conn.Read(b)
 		if rErr == nil {
+			s.readDeadline.received()
 			return rLen, nil
 		}
 
 		netErr, isNetErr := rErr.(net.Error)
-		if !isNetErr || !netErr.Timeout() || !s.requestTimeoutRetry(s) {
+		if !isNetErr || !netErr.Timeout() || !s.readDeadline.timedout() {
 			return rLen, rErr
 		}
 	}
@@ -143,6 +263,11 @@ type sshRemoteConn struct {
 	writer  io.Writer
 	closer  func() error
 	session *ssh.Session
+	reverse *sshReverseForwards
+	dynamic *sshDynamicForwards
+	local   *sshDynamicForwards
+	files   *sshFileTransfers
+	journal *remoteJournal
 }
 
 func (s sshRemoteConn) isValid() bool {
@@ -150,24 +275,57 @@ func (s sshRemoteConn) isValid() bool {
 }
 
 type sshClient struct {
-	w                                    command.StreamResponder
-	l                                    log.Logger
-	hooks                                command.Hooks
-	cfg                                  command.Configuration
-	baseCtx                              context.Context
-	baseCtxCancel                        func()
-	remoteCloseWait                      sync.WaitGroup
-	remoteReadTimeoutRetry               bool
-	remoteReadForceRetryNextTimeout      bool
-	remoteReadTimeoutRetryLock           sync.Mutex
-	credentialReceive                    chan []byte
-	credentialProcessed                  bool
-	credentialReceiveClosed              bool
-	fingerprintVerifyResultReceive       chan bool
-	fingerprintProcessed                 bool
-	fingerprintVerifyResultReceiveClosed bool
-	remoteConnReceive                    chan sshRemoteConn
-	remoteConn                           sshRemoteConn
+	w                  command.StreamResponder
+	l                  log.Logger
+	transportLog       log.Logger
+	hooks              command.Hooks
+	cfg                command.Configuration
+	baseCtx            context.Context
+	baseCtxCancel      func()
+	remoteCloseWait    sync.WaitGroup
+	remoteReadDeadline *sshReadDeadline
+	credential         *sshPrompt[[]byte]
+	fingerprint        *sshPrompt[bool]
+	stepUp             *sshPrompt[[]byte]
+	remoteConnReceive  chan sshRemoteConn
+	remoteConn         sshRemoteConn
+	remoteHandedOver   bool
+	reconnectAuth      sshReconnectAuth
+	handingOver        chan struct{}
+	parked             atomic.Bool
+	resumed            bool
+	framing            byte
+	mute               outputMute
+	input              inputSequence
+	terminated         termination
+	sandbox            *configuration.Sandbox
+	forwards           map[string]string
+	noTrace            bool
+	record             bool
+	fingerprintPinned  string
+	agentSocket        string
+	command            string
+	terminal           sshTerminal
+	environment        []sshEnvironment
+	privateKey         []byte
+	algorithms         configuration.SSHAlgorithms
+	tlsWrap            *configuration.TLSWrap
+	bootstrap          string
+	hideBootstrap      bool
+	attach             string
+}
+
+// sshTerminal is the PTY requested by the client
+type sshTerminal struct {
+	termType string
+	rows     int
+	cols     int
+}
+
+// sshEnvironment is an environment variable requested by the client
+type sshEnvironment struct {
+	name  string
+	value string
 }
 
 func newSSH(
@@ -177,26 +335,64 @@ func newSSH(
 	cfg command.Configuration,
 ) command.FSMMachine {
 	ctx, ctxCancel := context.WithCancel(context.Background())
+	readDeadline := newSSHReadDeadline(
+		cfg.ReadDeadlineStrategy, cfg.DialTimeout, l)
 	return &sshClient{
-		w:                                    w,
-		l:                                    l,
-		hooks:                                hooks,
-		cfg:                                  cfg,
-		baseCtx:                              ctx,
-		baseCtxCancel:                        sync.OnceFunc(ctxCancel),
-		remoteCloseWait:                      sync.WaitGroup{},
-		remoteReadTimeoutRetry:               false,
-		remoteReadForceRetryNextTimeout:      false,
-		remoteReadTimeoutRetryLock:           sync.Mutex{},
-		credentialReceive:                    make(chan []byte, 1),
-		credentialProcessed:                  false,
-		credentialReceiveClosed:              false,
-		fingerprintVerifyResultReceive:       make(chan bool, 1),
-		fingerprintProcessed:                 false,
-		fingerprintVerifyResultReceiveClosed: false,
-		remoteConnReceive:                    make(chan sshRemoteConn, 1),
-		remoteConn:                           sshRemoteConn{},
+		w:                  w,
+		l:                  l,
+		transportLog:       nil,
+		hooks:              hooks,
+		cfg:                cfg,
+		baseCtx:            ctx,
+		baseCtxCancel:      sync.OnceFunc(ctxCancel),
+		remoteCloseWait:    sync.WaitGroup{},
+		remoteReadDeadline: readDeadline,
+		credential:         newSSHPrompt[[]byte](),
+		fingerprint:        newSSHPrompt[bool](),
+		stepUp:             newSSHPrompt[[]byte](),
+		remoteConnReceive:  make(chan sshRemoteConn, 1),
+		remoteConn:         sshRemoteConn{},
+		remoteHandedOver:   false,
+		handingOver:        make(chan struct{}, 1),
+		resumed:            false,
+		mute:               outputMute{},
+		input:              inputSequence{},
+		forwards:           nil,
+		noTrace:            false,
+		record:             false,
+		fingerprintPinned:  "",
+		agentSocket:        "",
+		command:            "",
+		terminal:           sshTerminal{},
+		environment:        nil,
+		privateKey:         nil,
+		reconnectAuth: sshReconnectAuth{
+			enabled: cfg.SSHReconnectAttempts > 0,
+		},
+	}
+}
+
+// usePreset applies the settings of the Preset which targets the `address`,
+// and returns it if there's one
+func (d *sshClient) usePreset(
+	address string) (configuration.Preset, bool) {
+	d.algorithms = d.cfg.SSHAlgorithms
+
+	p, presetFound := d.cfg.Preset("SSH", address)
+	if !presetFound {
+		return p, false
 	}
+
+	d.algorithms = d.algorithms.Override(p.SSHAlgorithms)
+	d.forwards = p.Forwards
+	d.noTrace = p.NoTrace
+	d.record = p.Record
+	d.sandbox = p.Sandbox
+	d.fingerprintPinned = p.ExpectedFingerprint
+	d.tlsWrap = p.TLS
+	d.agentSocket = sshAgentSocket(d.cfg, p)
+
+	return p, true
 }
 
 func parseSSHConfig(p configuration.Preset) (configuration.Preset, error) {
@@ -240,6 +436,15 @@ func (d *sshClient) Bootup(
 			ErrSSHInvalidAddress, SSHRequestErrorBadRemoteAddress)
 	}
 
+	p, presetFound := d.usePreset(addrStr)
+	if presetFound {
+		d.w.SetWeight(p.Weight)
+	}
+
+	if !d.noTrace {
+		d.w.Describe(addrStr)
+	}
+
 	// Auth method
 	rData, rErr := rw.FetchOneByte(r.Fetch)
 	if rErr != nil {
@@ -247,18 +452,309 @@ func (d *sshClient) Bootup(
 			rErr, SSHRequestErrorBadAuthMethod)
 	}
 
-	authMethodBuilder, authMethodBuilderErr := d.buildAuthMethod(rData[0])
+	authMethod := rData[0]
+
+	authMethodBuilder, authMethodBuilderErr := d.buildAuthMethod(authMethod)
 	if authMethodBuilderErr != nil {
 		return nil, command.ToFSMError(
 			authMethodBuilderErr, SSHRequestErrorBadAuthMethod)
 	}
 
+	// Options, older clients don't send them
+	if !r.Completed() {
+		// Some of the options carry data longer than `b`
+		long := make([]byte, sshCredentialMaxSize)
+
+		oData, oErr := rw.FetchOneByte(r.Fetch)
+		if oErr != nil {
+			return nil, command.ToFSMError(
+				oErr, SSHRequestErrorBadAuthMethod)
+		}
+
+		// Transport debugging writes the details of the session into the
+		// server log, which the "no trace" Presets must not leave behind
+		if oData[0]&SSHOptionDebugTransport != 0 && !d.noTrace {
+			d.transportLog = d.l.Context("Transport")
+			d.logTransport("Debugging enabled. Connecting to %s as %q "+
+				"with %s authentication",
+				addrStr, userNameStr, sshAuthMethodName(authMethod))
+		}
+
+		if oData[0]&SSHOptionExec != 0 {
+			cmd, cmdErr := ParseString(r.Read, long)
+			if cmdErr != nil {
+				return nil, command.ToFSMError(
+					cmdErr, SSHRequestErrorBadCommand)
+			}
+
+			d.command = string(cmd.Data())
+		}
+
+		if oData[0]&SSHOptionTerminal != 0 {
+			term, termErr := parseSSHTerminal(r, b)
+			if termErr != nil {
+				return nil, command.ToFSMError(
+					termErr, SSHRequestErrorBadTerminal)
+			}
+
+			d.terminal = term
+		}
+
+		if oData[0]&SSHOptionEnvironment != 0 {
+			env, envErr := parseSSHEnvironment(r, long)
+			if envErr != nil {
+				return nil, command.ToFSMError(
+					envErr, SSHRequestErrorBadEnvironment)
+			}
+
+			d.environment = env
+		}
+
+		// The private key is checked before dialing, so a malformed one
+		// can be told to the user immediately
+		if oData[0]&SSHOptionPrivateKey != 0 {
+			if authMethod != SSHAuthMethodPrivateKey {
+				return nil, command.ToFSMError(
+					ErrSSHInvalidAuthMethod, SSHRequestErrorBadAuthMethod)
+			}
+
+			key, keyErr := ParseString(r.Read, long)
+			if keyErr != nil {
+				return nil, command.ToFSMError(
+					keyErr, SSHRequestErrorBadPrivateKey)
+			}
+
+			keyErr = checkSSHPrivateKey(key.Data())
+			if keyErr != nil {
+				d.logTransport("Refused the private key: %s", keyErr)
+
+				return nil, command.ToFSMError(
+					keyErr, SSHRequestErrorBadPrivateKey)
+			}
+
+			d.privateKey = append([]byte{}, key.Data()...)
+		}
+
+		if oData[0]&SSHOptionSequencedInput != 0 {
+			d.input.enabled = true
+		}
+
+		if oData[0]&SSHOptionProtobuf != 0 {
+			d.framing = SSHFramingProtobuf
+		}
+
+		// The parked session is resumed in place of a new connection, the
+		// rest of the request is not used for it
+		if oData[0]&SSHOptionResume != 0 {
+			id, idErr := ParseString(r.Read, b)
+			if idErr != nil {
+				return nil, command.ToFSMError(
+					idErr, SSHRequestErrorBadHandover)
+			}
+
+			parked, parkedErr := d.takeParked(string(id.Data()), addrStr)
+			if parkedErr != nil {
+				return nil, command.ToFSMError(
+					parkedErr, SSHRequestErrorBadHandover)
+			}
+
+			d.reconnectAuth = parked.reconnectAuth
+			d.resumed = true
+
+			// The client may retransmit what it has sent before it parked
+			// the session
+			d.input.last.Store(parked.input)
+
+			d.remoteCloseWait.Add(1)
+			go d.resume(parked)
+
+			return d.local, command.NoFSMError()
+		}
+	}
+
+	// The command of the Preset can't be replaced by the user
+	if presetFound && len(p.Command) > 0 {
+		d.command = p.Command
+	}
+
+	// Neither can the environment variables of the Preset
+	if presetFound && len(p.Environment) > 0 {
+		d.environment = presetSSHEnvironment(d.environment, p.Environment)
+	}
+
+	// The bootstrap snippet is typed into the shell, and the shell is
+	// replaced by the multiplexer. There is neither when a command is run
+	// instead
+	if presetFound && len(d.command) <= 0 {
+		d.bootstrap = p.Bootstrap
+		d.hideBootstrap = p.HideBootstrap
+		d.attach = p.Attach
+	}
+
 	d.remoteCloseWait.Add(1)
-	go d.remote(userNameStr, addrStr, authMethodBuilder)
+	go d.remote(
+		userNameStr, addrStr, sshAuthMethodName(authMethod), authMethodBuilder)
 
 	return d.local, command.NoFSMError()
 }
 
+// parseSSHTerminal reads the terminal type and the initial size of the PTY
+// requested by the client
+func parseSSHTerminal(r *rw.LimitedReader, b []byte) (sshTerminal, error) {
+	termType, err := ParseString(r.Read, b)
+	if err != nil {
+		return sshTerminal{}, err
+	}
+
+	t := sshTerminal{termType: string(termType.Data())}
+	if !sshTerminalTypeVerifier.MatchString(t.termType) {
+		return sshTerminal{}, ErrSSHInvalidTerminalType
+	}
+
+	_, err = io.ReadFull(r, b[:4])
+	if err != nil {
+		return sshTerminal{}, err
+	}
+
+	t.rows = int(binary.BigEndian.Uint16(b[0:2]))
+	t.cols = int(binary.BigEndian.Uint16(b[2:4]))
+
+	return t, nil
+}
+
+// parseSSHEnvironment reads the environment variables requested by the client,
+// one byte of count followed by the names and the values of the variables
+func parseSSHEnvironment(
+	r *rw.LimitedReader, b []byte) ([]sshEnvironment, error) {
+	count, err := rw.FetchOneByte(r.Fetch)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(count[0]) > sshMaxEnvironment {
+		return nil, ErrSSHTooManyEnvironment
+	}
+
+	env := make([]sshEnvironment, 0, count[0])
+
+	for i := byte(0); i < count[0]; i++ {
+		name, err := ParseString(r.Read, b)
+		if err != nil {
+			return nil, err
+		}
+
+		e := sshEnvironment{name: string(name.Data())}
+		if !sshEnvironmentVerifier.MatchString(e.name) {
+			return nil, ErrSSHInvalidEnvironment
+		}
+
+		value, err := ParseString(r.Read, b)
+		if err != nil {
+			return nil, err
+		}
+
+		e.value = string(value.Data())
+
+		env = append(env, e)
+	}
+
+	return env, nil
+}
+
+// presetSSHEnvironment returns the environment variables requested by the
+// client with the ones of the Preset replacing those of the same names
+func presetSSHEnvironment(
+	env []sshEnvironment, preset map[string]string) []sshEnvironment {
+	merged := make([]sshEnvironment, 0, len(env)+len(preset))
+
+	for _, e := range env {
+		if _, found := preset[e.name]; found {
+			continue
+		}
+
+		merged = append(merged, e)
+	}
+
+	names := make([]string, 0, len(preset))
+	for name := range preset {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		merged = append(merged, sshEnvironment{
+			name:  name,
+			value: preset[name],
+		})
+	}
+
+	return merged
+}
+
+// sshWindowChange sends the "window-change" request of RFC 4254, section
+// 6.7. Unlike ssh.Session.WindowChange, it carries the size of the terminal in
+// pixels as well
+func sshWindowChange(
+	session *ssh.Session, rows, cols, width, height int) error {
+	_, err := session.SendRequest("window-change", false, ssh.Marshal(struct {
+		Columns uint32
+		Rows    uint32
+		Width   uint32
+		Height  uint32
+	}{
+		Columns: uint32(cols),
+		Rows:    uint32(rows),
+		Width:   uint32(width),
+		Height:  uint32(height),
+	}))
+
+	return err
+}
+
+// pty returns the terminal type, the size and the modes of the PTY of the
+// session. The terminal type requested by the client takes precedence over
+// the one of the user settings
+func (d *sshClient) pty() (string, int, int, ssh.TerminalModes) {
+	termType := sshDefaultTerminalType
+	if len(d.terminal.termType) > 0 {
+		termType = d.terminal.termType
+	} else if len(d.cfg.TerminalType) > 0 {
+		termType = d.cfg.TerminalType
+	}
+
+	rows, cols := sshDefaultTerminalRows, sshDefaultTerminalCols
+	if d.terminal.rows > 0 && d.terminal.cols > 0 {
+		rows, cols = d.terminal.rows, d.terminal.cols
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	for op, val := range d.cfg.TerminalModes {
+		modes[op] = val
+	}
+
+	return termType, rows, cols, modes
+}
+
+// sshAuthMethodName returns the name of the auth method
+func sshAuthMethodName(methodType byte) string {
+	switch methodType {
+	case SSHAuthMethodNone:
+		return "none"
+
+	case SSHAuthMethodPassphrase:
+		return "password"
+
+	case SSHAuthMethodPrivateKey:
+		return "publickey"
+	}
+
+	return "unknown"
+}
+
 func (d *sshClient) buildAuthMethod(
 	methodType byte) (sshAuthMethodBuilder, error) {
 	switch methodType {
@@ -270,24 +766,43 @@ func (d *sshClient) buildAuthMethod(
 	case SSHAuthMethodPassphrase:
 		return func(b []byte) []ssh.AuthMethod {
 			return []ssh.AuthMethod{
-				ssh.PasswordCallback(func() (string, error) {
-					d.enableRemoteReadTimeoutRetry()
-					defer d.disableRemoteReadTimeoutRetry()
-
-					wErr := d.w.SendManual(
-						SSHServerConnectRequestCredential,
-						b[d.w.HeaderSize():],
-					)
-					if wErr != nil {
-						return "", wErr
+				sshPassphraseAuth(func(attempt int) ([]byte, bool, error) {
+					d.logTransport("Attempting \"password\" authentication "+
+						"(attempt %d of %d)", attempt, sshMaxPassphraseAttempts)
+
+					if attempt > 1 {
+						d.l.Debug("Passphrase was rejected, requesting a new "+
+							"one (attempt %d of %d)",
+							attempt, sshMaxPassphraseAttempts)
 					}
 
-					passphraseBytes, passphraseReceived := <-d.credentialReceive
-					if !passphraseReceived {
-						return "", ErrSSHAuthCancelled
+					passphrase, received, wErr := sshPromptUser(
+						d,
+						func() error {
+							sErr := d.sendPayload(
+								SSHServerExtendedAuthAttempt,
+								sshAttempt{
+									attempt: byte(attempt),
+									max:     sshMaxPassphraseAttempts,
+								},
+								b,
+							)
+							if sErr != nil {
+								return sErr
+							}
+
+							return d.w.SendManual(
+								SSHServerConnectRequestCredential,
+								b[d.w.HeaderSize():],
+							)
+						},
+						d.credential,
+					)
+					if wErr == nil && received {
+						d.reconnectAuth.cachePassword(string(passphrase))
 					}
 
-					return string(passphraseBytes), nil
+					return passphrase, received, wErr
 				}),
 			}
 		}, nil
@@ -296,27 +811,52 @@ func (d *sshClient) buildAuthMethod(
 		return func(b []byte) []ssh.AuthMethod {
 			return []ssh.AuthMethod{
 				ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
-					d.enableRemoteReadTimeoutRetry()
-					defer d.disableRemoteReadTimeoutRetry()
-
-					wErr := d.w.SendManual(
-						SSHServerConnectRequestCredential,
-						b[d.w.HeaderSize():],
-					)
-					if wErr != nil {
-						return nil, wErr
+					d.logTransport("Attempting \"publickey\" authentication")
+
+					// Only ask for the private key when it was not sent
+					// along with the request
+					privateKeyBytes := d.privateKey
+					if len(privateKeyBytes) <= 0 {
+						received, receivedOK, wErr := sshPromptUser(
+							d,
+							func() error {
+								return d.w.SendManual(
+									SSHServerConnectRequestCredential,
+									b[d.w.HeaderSize():],
+								)
+							},
+							d.credential,
+						)
+						if wErr != nil {
+							return nil, wErr
+						}
+						if !receivedOK {
+							return nil, ErrSSHAuthCancelled
+						}
+
+						privateKeyBytes = received
 					}
 
-					privateKeyBytes, privateKeyReceived := <-d.credentialReceive
-					if !privateKeyReceived {
-						return nil, ErrSSHAuthCancelled
-					}
-
-					signer, signerErr := ssh.ParsePrivateKey(privateKeyBytes)
+					signer, signerErr := parseSSHPrivateKey(
+						privateKeyBytes,
+						sshMaxPassphraseAttempts,
+						func(attempt int) ([]byte, error) {
+							return d.requestKeyPassphrase(attempt, b)
+						},
+					)
 					if signerErr != nil {
+						d.logTransport("Unable to parse the private key: %s",
+							signerErr)
+
 						return nil, signerErr
 					}
 
+					d.logTransport("Offering %s key %s",
+						signer.PublicKey().Type(),
+						ssh.FingerprintSHA256(signer.PublicKey()))
+
+					d.reconnectAuth.cacheSigner(signer)
+
 					return []ssh.Signer{signer}, signerErr
 				}),
 			}
@@ -326,27 +866,152 @@ func (d *sshClient) buildAuthMethod(
 	return nil, ErrSSHInvalidAuthMethod
 }
 
+// sshPassphraseAuth asks the user for the passphrase through `ask`, and asks
+// again with the next `attempt` when the passphrase is rejected by the server,
+// up to sshMaxPassphraseAttempts times
+func sshPassphraseAuth(
+	ask func(attempt int) ([]byte, bool, error),
+) ssh.AuthMethod {
+	attempt := 0
+
+	return ssh.RetryableAuthMethod(ssh.PasswordCallback(func() (
+		string, error,
+	) {
+		attempt++
+
+		passphrase, received, err := ask(attempt)
+		if err != nil {
+			return "", err
+		}
+
+		if !received {
+			return "", ErrSSHAuthCancelled
+		}
+
+		return string(passphrase), nil
+	}), sshMaxPassphraseAttempts)
+}
+
+// requestKeyPassphrase asks the user for the passphrase of the encrypted
+// private key
+func (d *sshClient) requestKeyPassphrase(
+	attempt int, b []byte) ([]byte, error) {
+	d.logTransport("Private key is encrypted, requesting the passphrase "+
+		"(attempt %d of %d)", attempt, sshMaxPassphraseAttempts)
+
+	passphrase, received, err := sshPromptUser(
+		d,
+		func() error {
+			// Tells the client the following credential request is for the
+			// passphrase of the private key rather than the key itself
+			sErr := d.sendPayload(
+				SSHServerExtendedKeyPassphrase,
+				sshAttempt{
+					attempt: byte(attempt),
+					max:     sshMaxPassphraseAttempts,
+				},
+				b,
+			)
+			if sErr != nil {
+				return sErr
+			}
+
+			return d.w.SendManual(
+				SSHServerConnectRequestCredential,
+				b[d.w.HeaderSize():],
+			)
+		},
+		d.credential,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if !received {
+		return nil, ErrSSHAuthCancelled
+	}
+
+	return passphrase, nil
+}
+
 func (d *sshClient) confirmRemoteFingerprint(
 	hostname string,
 	remote net.Addr,
 	key ssh.PublicKey,
 	buf []byte,
 ) error {
-	d.enableRemoteReadTimeoutRetry()
-	defer d.disableRemoteReadTimeoutRetry()
-
 	fgp := ssh.FingerprintSHA256(key)
-	fgpLen := copy(buf[d.w.HeaderSize():], fgp)
 
-	wErr := d.w.SendManual(
-		SSHServerConnectVerifyFingerprint,
-		buf[:d.w.HeaderSize()+fgpLen],
+	d.logTransport("Received %s host key %s from %s", key.Type(), fgp, remote)
+
+	// The fingerprint pinned by the Preset is checked by the server only, the
+	// user can neither confirm nor override it
+	if len(d.fingerprintPinned) > 0 {
+		if fgp == d.fingerprintPinned {
+			d.logTransport("Host key matches the one expected by the Preset")
+
+			return nil
+		}
+
+		d.l.Warning("Host key %s of %s is not the one expected by the "+
+			"Preset, connection refused", fgp, hostname)
+
+		err := d.sendPayload(
+			SSHServerExtendedHostKeyChanged, sshFingerprint(fgp), buf)
+		if err != nil {
+			return err
+		}
+
+		return ErrSSHRemoteFingerprintUnexpected
+	}
+
+	known, err := d.cfg.KnownHosts.Check(hostname, key)
+	if err != nil {
+		d.l.Warning("Unable to check the known hosts: %s", err)
+	}
+
+	switch known {
+	case hostkeys.Known:
+		d.logTransport("Host key matches the one in the known hosts")
+
+		return nil
+
+	case hostkeys.Changed:
+		d.l.Warning("Host key %s of %s differs from the known one, "+
+			"connection refused", fgp, hostname)
+
+		err = d.sendPayload(
+			SSHServerExtendedHostKeyChanged, sshFingerprint(fgp), buf)
+		if err != nil {
+			return err
+		}
+
+		return ErrSSHRemoteHostKeyChanged
+
+	case hostkeys.Revoked:
+		return ErrSSHRemoteHostKeyRevoked
+	}
+
+	fgpData, err := encodeSignalPayload(d.framing, sshFingerprint(fgp))
+	if err != nil {
+		return err
+	}
+
+	fgpLen := copy(buf[d.w.HeaderSize():], fgpData)
+
+	confirmed, confirmOK, wErr := sshPromptUser(
+		d,
+		func() error {
+			return d.w.SendManual(
+				SSHServerConnectVerifyFingerprint,
+				buf[:d.w.HeaderSize()+fgpLen],
+			)
+		},
+		d.fingerprint,
 	)
 	if wErr != nil {
 		return wErr
 	}
-
-	confirmed, confirmOK := <-d.fingerprintVerifyResultReceive
 	if !confirmOK {
 		return ErrSSHRemoteFingerprintVerificationCancelled
 	}
@@ -354,95 +1019,453 @@ func (d *sshClient) confirmRemoteFingerprint(
 		return ErrSSHRemoteFingerprintRefused
 	}
 
+	err = d.cfg.KnownHosts.Add(hostname, key)
+	if err != nil {
+		d.l.Warning("Unable to record the host key: %s", err)
+	}
+
 	return nil
 }
 
-func (d *sshClient) enableRemoteReadTimeoutRetry() {
-	d.remoteReadTimeoutRetryLock.Lock()
-	defer d.remoteReadTimeoutRetryLock.Unlock()
+// sendExtended sends an extended signal `sig` with given `data` to the client
+func (d *sshClient) sendExtended(sig byte, data []byte, buf []byte) error {
+	hLen := d.w.HeaderSize()
+	buf[hLen] = sig
+	dLen := copy(buf[hLen+1:], data)
 
-	d.remoteReadTimeoutRetry = true
+	return d.w.SendManual(SSHServerExtended, buf[:hLen+1+dLen])
 }
 
-func (d *sshClient) disableRemoteReadTimeoutRetry() {
-	d.remoteReadTimeoutRetryLock.Lock()
-	defer d.remoteReadTimeoutRetryLock.Unlock()
+// sendPayload sends an extended signal `sig` carrying the `p` encoded in the
+// negotiated framing to the client
+func (d *sshClient) sendPayload(sig byte, p signalPayload, buf []byte) error {
+	data, err := encodeSignalPayload(d.framing, p)
+	if err != nil {
+		return err
+	}
+
+	if len(data)+d.w.HeaderSize()+1 > len(buf) {
+		return ErrSignalPayloadTooLarge
+	}
 
-	d.remoteReadTimeoutRetry = false
-	d.remoteReadForceRetryNextTimeout = true
+	return d.sendExtended(sig, data, buf)
 }
 
-func (d *sshClient) dialRemote(
-	networkName,
-	addr string,
-	config *ssh.ClientConfig) (*ssh.Client, func(), error) {
-	dialCtx, dialCtxCancel := context.WithTimeout(d.baseCtx, config.Timeout)
-	defer dialCtxCancel()
-	conn, err := d.cfg.Dial(dialCtx, networkName, addr)
-	if err != nil {
-		return nil, nil, err
+// sendFraming confirms the framing requested by the client. It must be sent
+// before any payload, clients which didn't receive it keep using
+// SSHFramingBinary
+func (d *sshClient) sendFraming(buf []byte) {
+	if d.framing == SSHFramingBinary {
+		return
 	}
 
-	sshConn := &sshRemoteConnWrapper{
-		Conn:       conn,
-		writerConn: network.NewWriteTimeoutConn(conn, d.cfg.DialTimeout),
-		requestTimeoutRetry: func(s *sshRemoteConnWrapper) bool {
-			d.remoteReadTimeoutRetryLock.Lock()
-			defer d.remoteReadTimeoutRetryLock.Unlock()
-
-			if !d.remoteReadTimeoutRetry {
-				if !d.remoteReadForceRetryNextTimeout {
-					return false
-				}
-				d.remoteReadForceRetryNextTimeout = false
-			}
+	d.sendExtended(SSHServerExtendedFraming, []byte{d.framing}, buf)
+}
 
-			s.SetReadDeadline(time.Now().Add(config.Timeout))
+// sendSharing sends the other `sessions` connected to the account of the
+// remote to the client
+func (d *sshClient) sendSharing(sessions sharingSessions) error {
+	payload, err := encodeSignalPayload(d.framing, sessions)
+	if err != nil {
+		return err
+	}
 
-			return true
-		},
+	buf := [4096]byte{}
+	if len(payload)+d.w.HeaderSize()+1 > len(buf) {
+		return ErrSharingTooLarge
 	}
 
-	// Set timeout for writer, otherwise the Timeout writer will never
-	// be triggered
-	sshConn.SetWriteDeadline(time.Now().Add(d.cfg.DialTimeout))
-	sshConn.SetReadDeadline(time.Now().Add(config.Timeout))
+	return d.sendExtended(SSHServerExtendedSharing, payload, buf[:])
+}
 
-	c, chans, reqs, err := ssh.NewClientConn(sshConn, addr, config)
+// sendBanner sends the pre-authentication banner of the server to the client.
+// Banners that don't fit into the `buf` are truncated
+func (d *sshClient) sendBanner(message string, buf []byte) {
+	maxLen := len(buf) - d.w.HeaderSize() - 1
+	if len(message) > maxLen {
+		message = message[:maxLen]
+
+		// Don't leave a partial character at the end
+		for len(message) > 0 && !utf8.ValidString(message) {
+			message = message[:len(message)-1]
+		}
+	}
+
+	err := d.sendExtended(SSHServerExtendedBanner, []byte(message), buf)
 	if err != nil {
-		sshConn.Close()
-		return nil, nil, err
+		d.l.Debug("Unable to send the banner: %s", err)
 	}
+}
+
+// sendConnectFailed sends the timing data and the DialFailure of the
+// connection attempt followed by the `err` to the client, and logs it
+func (d *sshClient) sendConnectFailed(
+	err error,
+	trace *network.DialTrace,
+	buf []byte,
+) {
+	timing := newConnectTiming(trace.Report(), err)
+	failure := classifyDialFailure(err)
 
-	return ssh.NewClient(c, chans, reqs), func() {
-		d.remoteReadTimeoutRetryLock.Lock()
-		defer d.remoteReadTimeoutRetryLock.Unlock()
+	d.l.Info("Connection attempt has failed (%s), %s: %s",
+		failure, timing, err)
+	d.logTransport("Connection attempt has failed: %s", err)
 
-		d.remoteReadTimeoutRetry = false
-		d.remoteReadForceRetryNextTimeout = true
+	d.sendPayload(SSHServerExtendedConnectTiming, timing, buf)
 
-		sshConn.SetReadDeadline(sshEmptyTime)
-	}, nil
+	d.sendExtended(SSHServerExtendedConnectFailure, []byte{byte(failure)}, buf)
+
+	errLen := copy(buf[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
+	d.w.SendManual(SSHServerConnectFailed, buf[:errLen])
 }
 
-func (d *sshClient) remote(
-	user string, address string, authMethodBuilder sshAuthMethodBuilder) {
-	defer func() {
-		d.w.Signal(command.HeaderClose)
-		close(d.remoteConnReceive)
-		d.baseCtxCancel()
-		d.remoteCloseWait.Done()
-	}()
+// sendTransportInfo sends the version strings and the negotiated algorithms
+// of the established SSH connection to the client. They're also recorded into
+// the journal, along with the warnings about the weak parts of them
+func (d *sshClient) sendTransportInfo(
+	capture *sshTransportCapture,
+	rJournal *remoteJournal,
+	buf []byte,
+) {
+	info, ok := capture.info()
+	if !ok {
+		return
+	}
 
-	buf := [4096]byte{}
+	rJournal.describe(info.details())
 
-	err := d.hooks.Run(
-		d.baseCtx,
-		configuration.HOOK_BEFORE_CONNECTING,
-		command.NewHookParameters(2).
-			Insert("Remote Type", "SSH").
-			Insert("Remote Address", address),
-		command.NewDefaultHookOutput(d.l, func(
+	d.sendPayload(SSHServerExtendedTransportInfo, info, buf)
+
+	warnings := info.weaknesses(d.cfg.SSHServerPolicy)
+	if len(warnings) <= 0 {
+		return
+	}
+
+	d.logTransport("Weak transport: %s", strings.Join(warnings, "; "))
+	rJournal.weakTransport(warnings)
+
+	d.sendPayload(
+		SSHServerExtendedWeakTransport, sshWeakTransport(warnings), buf)
+}
+
+// sendForwards sends the `forwards` established for the connection to the
+// client
+func (d *sshClient) sendForwards(forwards []sshForward, buf []byte) {
+	err := d.sendPayload(
+		SSHServerExtendedForwards, sshForwards(forwards), buf)
+	if err != nil {
+		d.l.Warning("Unable to send the list of forwards to the client: %s",
+			err)
+	}
+}
+
+// sshPushApproval tells the client that the login is waiting to be approved
+type sshPushApproval struct {
+	// Name of the push-approval provider
+	Provider string `json:"provider"`
+
+	// Max time to wait for the approval, in second
+	Timeout int64 `json:"timeout"`
+}
+
+// approve requests the approval of the login of `user` through the
+// push-approval provider, and waits until it's answered. The approval is
+// requested for the authenticated gateway user when there is one
+func (d *sshClient) approve(user string, address string, buf []byte) error {
+	defer d.remoteReadDeadline.interact()()
+
+	if len(d.cfg.User) > 0 {
+		user = d.cfg.User
+	}
+
+	d.sendPayload(SSHServerExtendedPushApproval, sshPushApproval{
+		Provider: d.cfg.Approver.Name(),
+		Timeout:  int64(d.cfg.Approver.Timeout() / time.Second),
+	}, buf)
+
+	d.logTransport("Requesting push approval for %q from %s",
+		user, d.cfg.Approver.Name())
+
+	return d.cfg.Approver.Approve(d.baseCtx, approval.Request{
+		ID:     rand.Text(),
+		User:   user,
+		Type:   "SSH",
+		Remote: address,
+		Client: d.cfg.ClientIP(),
+	})
+}
+
+// sendReverseForward sends the `result` of a reverse forward request to the
+// client
+func (d *sshClient) sendReverseForward(result sshReverseForwardResult) {
+	buf := [1024]byte{}
+
+	err := d.sendPayload(SSHServerExtendedReverseForward, result, buf[:])
+	if err != nil {
+		d.l.Warning("Unable to send the result of reverse forward to the "+
+			"client: %s", err)
+	}
+}
+
+// sendPromptCountdown tells the client how many seconds is left before the
+// prompt times out
+func (d *sshClient) sendPromptCountdown(deadline time.Time) error {
+	buf := [16]byte{}
+	remain := time.Until(deadline).Round(time.Second) / time.Second
+	if remain < 0 {
+		remain = 0
+	} else if remain > 0xffff {
+		remain = 0xffff
+	}
+
+	return d.sendPayload(
+		SSHServerExtendedPromptCountdown,
+		sshPromptCountdown(remain),
+		buf[:],
+	)
+}
+
+// sshPromptUser sends a prompt request to the client by calling `request`,
+// then wait for the user to respond through `prompt`. `received` will be
+// false if the client is closed before the user responds.
+//
+// When PromptTimeout is configured, the client will be informed about the
+// remaining time periodically, and ErrSSHPromptTimeout will be returned once
+// the time is up
+func sshPromptUser[T any](
+	d *sshClient,
+	request func() error,
+	prompt *sshPrompt[T],
+) (result T, received bool, err error) {
+	defer d.remoteReadDeadline.interact()()
+
+	result, received, err = waitSSHPrompt(
+		d.baseCtx,
+		d.cfg.PromptTimeout,
+		sshPromptCountdownInterval,
+		d.sendPromptCountdown,
+		request,
+		prompt,
+	)
+	if errors.Is(err, ErrSSHPromptTimeout) {
+		d.l.Debug("User did not respond to the prompt in time")
+	}
+
+	return
+}
+
+// waitSSHPrompt sends the prompt request through `request`, then waits for
+// the user to respond through `prompt` until the `ctx` is done. When the
+// `timeout` is set, the deadline is sent through `countdown` before the
+// request and then every `interval`, until ErrSSHPromptTimeout is returned
+// once the time is up
+func waitSSHPrompt[T any](
+	ctx context.Context,
+	timeout time.Duration,
+	interval time.Duration,
+	countdown func(deadline time.Time) error,
+	request func() error,
+	prompt *sshPrompt[T],
+) (result T, received bool, err error) {
+	// Start expecting before the request is sent, as the user may respond
+	// before request() returns
+	prompt.expect()
+	defer prompt.stop()
+
+	if timeout <= 0 {
+		err = request()
+		if err != nil {
+			return
+		}
+
+		select {
+		case result = <-prompt.received():
+			received = true
+
+		case <-ctx.Done():
+		}
+
+		return
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	err = countdown(deadline)
+	if err != nil {
+		return
+	}
+
+	err = request()
+	if err != nil {
+		return
+	}
+
+	expired := time.NewTimer(timeout)
+	defer expired.Stop()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case result = <-prompt.received():
+			received = true
+			return
+
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			err = countdown(deadline)
+			if err != nil {
+				return
+			}
+
+		case <-expired.C:
+			err = ErrSSHPromptTimeout
+			return
+		}
+	}
+}
+
+// sshConfig returns the ssh.Config of the connections to the remote
+func (d *sshClient) sshConfig() ssh.Config {
+	return ssh.Config{
+		RekeyThreshold: d.cfg.SSHRekeyThreshold,
+		Ciphers:        d.algorithms.Ciphers,
+		KeyExchanges:   d.algorithms.KeyExchanges,
+		MACs:           d.algorithms.MACs,
+	}
+}
+
+func (d *sshClient) dialRemote(
+	networkName,
+	addr string,
+	trace *network.DialTrace,
+	capture *sshTransportCapture,
+	config *ssh.ClientConfig) (*ssh.Client, func(), error) {
+	dialCtx, dialCtxCancel := context.WithTimeout(d.baseCtx, config.Timeout)
+	defer dialCtxCancel()
+	tlsConfig, err := d.tlsWrap.Config(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := d.cfg.Dial(network.WithDialProtocol(
+		network.WithDialTrace(dialCtx, trace), "SSH"), networkName, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if tlsConfig != nil {
+		conn, err = network.WrapTLS(
+			network.WithDialTrace(dialCtx, trace), conn, tlsConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		d.logTransport("TLS established with %q", tlsConfig.ServerName)
+	}
+
+	trace.Begin(sshConnectPhaseHandshake)
+
+	d.logTransport("Connected to %s, starting handshake", conn.RemoteAddr())
+
+	// Closing the connection is the only way to abort an ongoing handshake
+	stopAbort := context.AfterFunc(d.baseCtx, func() { conn.Close() })
+	defer stopAbort()
+
+	conn = capture.wrap(conn)
+
+	sshConn := &sshRemoteConnWrapper{
+		Conn:         conn,
+		writerConn:   network.NewWriteTimeoutConn(conn, d.cfg.DialTimeout),
+		readDeadline: d.remoteReadDeadline,
+	}
+
+	// Set timeout for writer, otherwise the Timeout writer will never
+	// be triggered
+	sshConn.SetWriteDeadline(time.Now().Add(d.cfg.DialTimeout))
+	d.remoteReadDeadline.bind(conn)
+
+	c, chans, reqs, err := ssh.NewClientConn(sshConn, addr, config)
+	d.logTransportCapture(capture)
+	if err != nil {
+		sshConn.Close()
+		return nil, nil, err
+	}
+
+	return ssh.NewClient(c, chans, reqs), d.remoteReadDeadline.clear, nil
+}
+
+// logTransport logs a transport event when transport debugging is enabled
+func (d *sshClient) logTransport(msg string, params ...interface{}) {
+	if d.transportLog == nil {
+		return
+	}
+
+	d.transportLog.Info(msg, params...)
+}
+
+// logTransportCapture logs the versions and algorithms captured during the
+// handshake
+func (d *sshClient) logTransportCapture(capture *sshTransportCapture) {
+	if d.transportLog == nil {
+		return
+	}
+
+	clientVersion, serverVersion := capture.versions()
+	d.logTransport("Client version: %q, server version: %q",
+		clientVersion, serverVersion)
+
+	n, ok := capture.negotiated()
+	if !ok {
+		d.logTransport("Algorithm negotiation was not completed")
+
+		return
+	}
+
+	d.logTransport("Negotiated algorithms: kex %q, host key %q, "+
+		"cipher %q/%q, MAC %q/%q",
+		n.KeyExchange, n.HostKey,
+		n.CipherClientServer, n.CipherServerClient,
+		n.MACClientServer, n.MACServerClient)
+}
+
+// remoteDone closes the stream once the remote goroutine has returned
+func (d *sshClient) remoteDone() {
+	d.w.Signal(command.HeaderClose)
+	close(d.remoteConnReceive)
+	d.baseCtxCancel()
+	d.remoteCloseWait.Done()
+}
+
+func (d *sshClient) remote(
+	user string,
+	address string,
+	authMethod string,
+	authMethodBuilder sshAuthMethodBuilder,
+) {
+	defer d.remoteDone()
+
+	buf := [4096]byte{}
+
+	d.sendFraming(buf[:])
+
+	notice := formatConnectNotice(d.cfg.ConnectNotice, "SSH", address)
+	if len(notice) > 0 {
+		nLen := copy(buf[d.w.HeaderSize():], notice) + d.w.HeaderSize()
+		d.w.SendManual(SSHServerHookOutputBeforeConnecting, buf[:nLen])
+	}
+
+	params := command.NewRemoteHookParameters(
+		d.cfg, d.w.StreamID(), "SSH", address)
+
+	err := d.hooks.Run(
+		d.baseCtx,
+		configuration.HOOK_BEFORE_CONNECTING,
+		params,
+		command.NewDefaultHookOutput(d.l, func(
 			b []byte,
 		) (wLen int, wErr error) {
 			wLen = len(b)
@@ -454,142 +1477,707 @@ func (d *sshClient) remote(
 			return
 		}),
 	)
+	rJournal := newRemoteJournal(d.cfg, d.l, "SSH", address)
+	rJournal.describe(map[string]string{
+		"login_user":  user,
+		"auth_method": authMethod,
+	})
+	rJournal.limitBy(newSandboxLimits(d.sandbox, d.Terminate))
+	if d.noTrace {
+		rJournal.withoutTrace()
+	}
+	parked := false
+	defer func() {
+		if !parked {
+			rJournal.done(err)
+		}
+	}()
 	if err != nil {
 		errLen := copy(buf[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
 		d.w.SendManual(SSHServerConnectFailed, buf[:errLen])
 		return
 	}
 
-	conn, clearConnInitialDeadline, err :=
-		d.dialRemote("tcp", address, &ssh.ClientConfig{
-			User: user,
-			Auth: authMethodBuilder(buf[:]),
-			HostKeyCallback: func(h string, r net.Addr, k ssh.PublicKey) error {
-				return d.confirmRemoteFingerprint(h, r, k, buf[:])
-			},
-			Timeout: d.cfg.DialTimeout,
-		})
+	riskStepUp, err := scoreRisk(
+		d.baseCtx, d.cfg, params, d.command, rJournal, d.l)
 	if err != nil {
 		errLen := copy(buf[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
 		d.w.SendManual(SSHServerConnectFailed, buf[:errLen])
-		d.l.Debug("Unable to connect to remote machine: %s", err)
 		return
 	}
-	defer conn.Close()
 
-	session, err := conn.NewSession()
+	err = stepUp(d.baseCtx, d.cfg, params, riskStepUp, func(
+		code byte,
+	) ([]byte, bool, error) {
+		return sshPromptUser(d, func() error {
+			return d.sendExtended(
+				SSHServerExtendedStepUp, []byte{code}, buf[:])
+		}, d.stepUp)
+	}, d.l)
 	if err != nil {
 		errLen := copy(buf[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
 		d.w.SendManual(SSHServerConnectFailed, buf[:errLen])
-		d.l.Debug("Unable open new session on remote machine: %s", err)
 		return
 	}
-	defer session.Close()
 
-	in, err := session.StdinPipe()
+	termType, rows, cols, _ := d.pty()
+	rec, err := startRecording(d.cfg, d.l, d.noTrace, d.record, recording.Meta{
+		Protocol: "SSH",
+		Remote:   address,
+		Term:     termType,
+		Width:    cols,
+		Height:   rows,
+	})
 	if err != nil {
 		errLen := copy(buf[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
 		d.w.SendManual(SSHServerConnectFailed, buf[:errLen])
-		d.l.Debug("Unable export Stdin pipe: %s", err)
 		return
 	}
+	rJournal.recordTo(rec, nil)
+
+	trace := network.NewDialTrace()
+	span := beginConnectSpan(d.cfg, "SSH", address, trace)
+	span.Set("sshwifty.auth_method", authMethod)
+
+	// Presets marked as FastStart may have an authenticated connection
+	// waiting in the warmup.Pool
+	conn, warm := d.cfg.Warmup.Take(address, user)
+	clearConnInitialDeadline := func() {}
+
+	if warm {
+		span.Set("sshwifty.warm", "true")
+		d.l.Debug("Attaching to a warm connection")
+		d.logTransport("Attaching to a warm connection, handshake skipped")
+	} else {
+		capture := newSSHTransportCapture()
+
+		conn, clearConnInitialDeadline, err = d.dialRemote(
+			"tcp", address, trace, capture, &ssh.ClientConfig{
+				Config:            d.sshConfig(),
+				HostKeyAlgorithms: d.algorithms.HostKeyAlgorithms,
+				User:              user,
+				Auth:              authMethodBuilder(buf[:]),
+				BannerCallback: func(message string) error {
+					d.logTransport("Received banner: %q", message)
+					d.sendBanner(message, buf[:])
+					return nil
+				},
+				HostKeyCallback: func(
+					h string, r net.Addr, k ssh.PublicKey) error {
+					trace.Begin(sshConnectPhaseAuthenticate)
+					err := d.confirmRemoteFingerprint(h, r, k, buf[:])
+					if err == nil {
+						d.reconnectAuth.cacheHostKey(k)
+					}
+					return err
+				},
+				Timeout: d.cfg.DialTimeout,
+			})
+		if err != nil {
+			d.sendConnectFailed(err, trace, buf[:])
+			endConnectSpan(span, trace, err)
+			return
+		}
+
+		d.sendTransportInfo(capture, rJournal, buf[:])
+	}
+	defer func() {
+		if !parked {
+			conn.Close()
+		}
+	}()
+
+	// Don't wait for the session setup when the client is gone
+	stopAbort := context.AfterFunc(d.baseCtx, func() { conn.Close() })
+	defer stopAbort()
+
+	// The login is completed only after it's approved, so the session is not
+	// opened before that
+	if d.cfg.Approver.Enabled() {
+		trace.Begin(sshConnectPhaseApprove)
+
+		err = d.approve(user, address, buf[:])
+		if err != nil {
+			d.sendConnectFailed(err, trace, buf[:])
+			endConnectSpan(span, trace, err)
+			return
+		}
+	}
+
+	trace.Begin(sshConnectPhaseSession)
 
-	out, err := session.StdoutPipe()
+	d.logTransport("Authenticated, opening session channel")
+
+	s, err := d.openSession(conn)
 	if err != nil {
-		errLen := copy(buf[d.w.HeaderSize():], err.Error()) +
-			d.w.HeaderSize()
-		d.w.SendManual(SSHServerConnectFailed, buf[:errLen])
-		d.l.Debug("Unable export Stdout pipe: %s", err)
+		d.sendConnectFailed(err, trace, buf[:])
+		endConnectSpan(span, trace, err)
 		return
 	}
 
-	errOut, err := session.StderrPipe()
+	endConnectSpan(span, trace, nil)
+	clearConnInitialDeadline()
+
+	conn, parked = d.serve(
+		conn, s, user, address, rJournal, stopAbort, buf[:])
+}
+
+// serve relays the session `s` opened through the `conn` until it's ended,
+// reconnecting the connection when it has dropped. It returns the last
+// connection, and whether or not the session has been parked for the
+// handover, in which case the connection must be left open. See park for the
+// `detach`
+func (d *sshClient) serve(
+	conn *ssh.Client,
+	s sshRemoteSession,
+	user string,
+	address string,
+	rJournal *remoteJournal,
+	detach func() bool,
+	buf []byte,
+) (*ssh.Client, bool) {
+	for {
+		switch d.serveSession(conn, s, user, address, rJournal, buf) {
+		case sshSessionClosed:
+			return conn, false
+
+		case sshSessionParked:
+			if d.park(conn, s, user, address, rJournal, detach) {
+				return conn, true
+			}
+
+			continue
+		}
+
+		conn.Close()
+
+		newConn, newS, rErr := d.reconnect(user, address, buf)
+		if rErr != nil {
+			d.l.Debug("Unable to reconnect: %s", rErr)
+			rJournal.disconnected("Unable to reconnect: " + rErr.Error())
+			return conn, false
+		}
+
+		conn, s = newConn, newS
+	}
+}
+
+// sshRemoteSession is the session of the remote shell, or the command
+type sshRemoteSession struct {
+	session   *ssh.Session
+	in        io.WriteCloser
+	out       io.Reader
+	errOut    io.Reader
+	stdout    <-chan []byte
+	stderr    <-chan []byte
+	closed    chan struct{}
+	close     func()
+	bootstrap *sshBootstrap
+}
+
+// relay starts reading the output of the session into the channels rather
+// than sending it to the client directly, so the reading can be handed from
+// one client to another without losing any of the output
+func (s *sshRemoteSession) relay(relays *relay.Pool) {
+	s.closed = make(chan struct{})
+	s.close = sync.OnceFunc(func() {
+		close(s.closed)
+		s.session.Close()
+	})
+
+	s.stdout = relays.Relay(s.out, s.closed)
+	s.stderr = relays.Relay(s.errOut, s.closed)
+}
+
+// sshSessionEnd tells how serveSession has returned
+type sshSessionEnd int
+
+const (
+	sshSessionClosed sshSessionEnd = iota
+	sshSessionDropped
+	sshSessionParked
+)
+
+// openSession opens the session of the shell, or the command, through the
+// `conn`
+func (d *sshClient) openSession(conn *ssh.Client) (sshRemoteSession, error) {
+	session, err := conn.NewSession()
 	if err != nil {
-		errLen := copy(buf[d.w.HeaderSize():], err.Error()) +
-			d.w.HeaderSize()
-		d.w.SendManual(SSHServerConnectFailed, buf[:errLen])
+		d.l.Debug("Unable open new session on remote machine: %s", err)
+		return sshRemoteSession{}, err
+	}
+
+	// The agent is a convenience, the session works without it
+	if len(d.agentSocket) > 0 {
+		err = forwardSSHAgent(conn, session, d.agentSocket)
+		if err != nil {
+			d.l.Warning("Unable to forward the SSH agent: %s", err)
+		} else {
+			d.logTransport("SSH agent forwarded")
+		}
+	}
+
+	// Servers only accept the variables they're told to (AcceptEnv of
+	// OpenSSH), the rest is refused without failing the session
+	for _, env := range d.environment {
+		err = session.Setenv(env.name, env.value)
+		if err != nil {
+			d.logTransport("Environment variable %q refused: %s",
+				env.name, err)
+		} else {
+			d.logTransport("Environment variable %q set", env.name)
+		}
+	}
+
+	s := sshRemoteSession{session: session}
+
+	s.in, err = session.StdinPipe()
+	if err != nil {
+		session.Close()
+		d.l.Debug("Unable export Stdin pipe: %s", err)
+		return sshRemoteSession{}, err
+	}
+
+	s.in = newSSHPacketWriter(s.in, d.cfg.SSHMaxPacketSize)
+
+	s.out, err = session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		d.l.Debug("Unable export Stdout pipe: %s", err)
+		return sshRemoteSession{}, err
+	}
+
+	s.errOut, err = session.StderrPipe()
+	if err != nil {
+		session.Close()
 		d.l.Debug("Unable export Stderr pipe: %s", err)
-		return
+		return sshRemoteSession{}, err
 	}
 
-	err = session.RequestPty("xterm", 80, 40, ssh.TerminalModes{
-		ssh.ECHO:          1,
-		ssh.TTY_OP_ISPEED: 14400,
-		ssh.TTY_OP_OSPEED: 14400,
-	})
+	if len(d.command) > 0 {
+		d.logTransport("Session channel opened, running command %q",
+			d.command)
+
+		err = session.Start(d.command)
+		if err != nil {
+			session.Close()
+			d.l.Debug("Unable to run command: %s", err)
+			return sshRemoteSession{}, err
+		}
+
+		s.relay(d.cfg.Relays)
+
+		return s, nil
+	}
+
+	terminalType, rows, cols, modes := d.pty()
+
+	d.logTransport("Session channel opened, requesting %q PTY of "+
+		"%dx%d", terminalType, cols, rows)
+
+	err = session.RequestPty(terminalType, rows, cols, modes)
 	if err != nil {
-		errLen := copy(buf[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
-		d.w.SendManual(SSHServerConnectFailed, buf[:errLen])
+		session.Close()
 		d.l.Debug("Unable request PTY: %s", err)
-		return
+		return sshRemoteSession{}, err
 	}
 
-	err = session.Shell()
+	if len(d.attach) > 0 {
+		d.logTransport("Attaching to multiplexer %q", d.attach)
+
+		err = session.Start(sshAttachCommand(d.attach))
+	} else {
+		d.logTransport("Starting shell")
+
+		err = session.Shell()
+	}
 	if err != nil {
-		errLen := copy(buf[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
-		d.w.SendManual(SSHServerConnectFailed, buf[:errLen])
+		session.Close()
 		d.l.Debug("Unable to start Shell: %s", err)
-		return
+		return sshRemoteSession{}, err
 	}
-	defer session.Wait()
 
-	clearConnInitialDeadline()
+	if len(d.bootstrap) > 0 {
+		s.bootstrap, err = newSSHBootstrap(d.bootstrap, d.hideBootstrap)
+		if err == nil {
+			err = s.bootstrap.start(s.in)
+		}
+		if err != nil {
+			d.logTransport("Unable to bootstrap the shell: %s", err)
+			s.bootstrap = nil
+		} else {
+			d.logTransport("Bootstrap snippet written to the shell")
+		}
+	}
+
+	s.relay(d.cfg.Relays)
+
+	return s, nil
+}
+
+// serveSession relays the session `s` opened through the `conn` until it's
+// ended. It returns sshSessionDropped when the connection has dropped before
+// the session could end, so it may be reconnected, or sshSessionParked when
+// the client has requested the handover, in which case the session is left
+// open
+func (d *sshClient) serveSession(
+	conn *ssh.Client,
+	s sshRemoteSession,
+	user string,
+	address string,
+	rJournal *remoteJournal,
+	buf []byte,
+) (end sshSessionEnd) {
+	waitSession := sync.OnceValue(s.session.Wait)
+
+	defer func() {
+		if end == sshSessionParked {
+			return
+		}
+
+		waitSession()
+		s.close()
+	}()
+
+	if len(d.forwards) > 0 {
+		forwarder, forwards := startSSHForwards(
+			conn,
+			d.cfg.ForwardBindHost,
+			d.forwards,
+			d.cfg.Previews,
+			d.l.Context("Forward"),
+		)
+		defer forwarder.close()
+
+		d.sendForwards(forwards, buf)
+	}
+
+	reverse := newSSHReverseForwards(
+		conn, d.cfg, address, d.l.Context("Reverse forward"))
+	defer reverse.close()
 
-	d.remoteConnReceive <- sshRemoteConn{
-		writer: in,
+	dynamic := newSSHDynamicForwards(
+		conn,
+		d.cfg.AllowDynamicForwards,
+		d.w.HeaderSize(),
+		d.w.SendManual,
+		d.l.Context("Dynamic forward"),
+	)
+	defer dynamic.close()
+
+	local := newSSHLocalForwards(
+		conn,
+		d.cfg.AllowLocalForwards,
+		d.w.HeaderSize(),
+		d.w.SendManual,
+		d.l.Context("Local forward"),
+	)
+	defer local.close()
+
+	files := newSSHFileTransfers(
+		conn,
+		d.cfg.AllowFileTransfer,
+		d.w.HeaderSize(),
+		d.w.SendManual,
+		d.l.Context("File transfer"),
+	)
+	defer files.close()
+
+	reconnected := d.setRemote(sshRemoteConn{
+		writer: s.in,
 		closer: func() error {
-			session.Close()
+			// The parked session is closed by the handover.Registry instead
+			if d.parked.Load() {
+				return nil
+			}
+
+			s.close()
 
 			return conn.Close()
 		},
-		session: session,
+		session: s.session,
+		reverse: reverse,
+		dynamic: dynamic,
+		local:   local,
+		files:   files,
+		journal: rJournal,
+	})
+
+	if !reconnected {
+		wErr := d.w.SendManual(
+			SSHServerConnectSucceed, buf[:d.w.HeaderSize()])
+		if wErr != nil {
+			return sshSessionClosed
+		}
+
+		// The resumed sessions were connected by the client which parked
+		// them. The client is told where to continue the input from instead
+		if !d.resumed {
+			rJournal.connected()
+		} else if d.input.enabled {
+			d.sendExtended(SSHServerExtendedInputSequence,
+				binary.BigEndian.AppendUint32(nil, d.input.last.Load()), buf)
+		}
 	}
 
-	wErr := d.w.SendManual(
-		SSHServerConnectSucceed, buf[:d.w.HeaderSize()])
-	if wErr != nil {
-		return
+	defer shareRemote(d.cfg, "SSH", address, user, rJournal,
+		d.l.Context("Sharing"), d.sendSharing)()
+
+	// Stateful firewalls drop the idle connections silently, keep them busy
+	if d.cfg.SSHKeepaliveInterval > 0 {
+		keepaliveCtx, keepaliveCancel := context.WithCancel(d.baseCtx)
+		defer keepaliveCancel()
+
+		go d.keepalive(keepaliveCtx, conn)
 	}
 
 	d.l.Debug("Serving")
 
-	d.remoteCloseWait.Add(1)
+	stdout, stderr := s.stdout, s.stderr
 
-	go func() {
-		defer d.remoteCloseWait.Done()
+	// Report the exit status once all the output of the session is sent
+	for stdout != nil || stderr != nil {
+		var sErr error
 
-		errOutBuf := [4096]byte{}
+		select {
+		case data, ok := <-stdout:
+			if !ok {
+				stdout = nil
+				continue
+			}
 
-		for {
-			rLen, err := errOut.Read(errOutBuf[d.w.HeaderSize():])
-			if err != nil {
-				return
+			rJournal.output("stdout", data)
+
+			if s.bootstrap != nil && s.bootstrap.hiding() {
+				data = s.bootstrap.filter(data)
 			}
 
-			err = d.w.SendManual(
-				SSHServerRemoteStdErr, errOutBuf[:d.w.HeaderSize()+rLen])
-			if err != nil {
-				return
+			// The output that has flooded the terminal is dropped after the
+			// client interrupted it
+			if d.mute.muted() {
+				continue
 			}
+
+			sErr = d.sendOutput(SSHServerRemoteStdOut, data, buf)
+
+		case data, ok := <-stderr:
+			if !ok {
+				stderr = nil
+				continue
+			}
+
+			rJournal.output("stderr", data)
+
+			if d.mute.muted() {
+				continue
+			}
+
+			sErr = d.sendOutput(SSHServerRemoteStdErr, data, buf)
+
+		case <-d.handingOver:
+			d.l.Debug("Handing over")
+
+			return sshSessionParked
 		}
-	}()
 
-	for {
-		rLen, rErr := out.Read(buf[d.w.HeaderSize():])
-		if rErr != nil {
-			return
+		if sErr != nil {
+			rJournal.disconnected(d.terminated.or(sshReasonClientLeft))
+
+			return sshSessionClosed
 		}
+	}
 
-		rErr = d.w.SendManual(
-			SSHServerRemoteStdOut, buf[:d.w.HeaderSize()+rLen])
-		if rErr != nil {
-			return
+	// Nobody is there to be told when the client has left
+	if d.baseCtx.Err() != nil {
+		rJournal.disconnected(d.terminated.or(sshReasonClientLeft))
+
+		return sshSessionClosed
+	}
+
+	endErr := waitSession()
+
+	sData, ok := sshSessionEnded(endErr)
+	if !ok {
+		d.logTransport("Connection has dropped: %s", endErr)
+
+		if d.cfg.SSHReconnectAttempts > 0 {
+			return sshSessionDropped
+		}
+
+		rJournal.disconnected("Connection has dropped: " + endErr.Error())
+
+		return sshSessionClosed
+	}
+
+	if endErr != nil {
+		d.logTransport("Session ended: %s", endErr)
+		rJournal.disconnected("Session ended: " + endErr.Error())
+	} else {
+		d.logTransport("Session ended with status 0")
+		rJournal.disconnected("Session ended with status 0")
+	}
+
+	d.sendPayload(SSHServerExtendedSessionEnded, sData, buf)
+
+	return sshSessionClosed
+}
+
+// keepalive sends keepalive requests through the `conn` until the `ctx` is
+// done, and closes it when the server has stopped responding
+func (d *sshClient) keepalive(ctx context.Context, conn *ssh.Client) {
+	err := sshKeepalive(
+		ctx, conn, d.cfg.SSHKeepaliveInterval, d.cfg.SSHKeepaliveCountMax)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+
+	d.logTransport("Keepalive has failed: %s", err)
+	d.l.Debug("Keepalive has failed: %s", err)
+
+	buf := [256]byte{}
+	msgLen := copy(buf[d.w.HeaderSize():], "\r\n"+err.Error()+"\r\n")
+	d.w.SendManual(SSHServerRemoteStdErr, buf[:d.w.HeaderSize()+msgLen])
+
+	conn.Close()
+}
+
+// sendOutput sends the output `data` of the remote as `marker` signals,
+// split to fit into the `buf`
+func (d *sshClient) sendOutput(marker byte, data []byte, buf []byte) error {
+	for len(data) > 0 {
+		n := copy(buf[d.w.HeaderSize():], data)
+		data = data[n:]
+
+		err := d.w.SendManual(marker, buf[:d.w.HeaderSize()+n])
+		if err != nil {
+			return err
 		}
 	}
+
+	return nil
+}
+
+// sshSessionEnded builds the payload of SSHServerExtendedSessionEnded from
+// the error returned by ssh.Session.Wait: the exit status and the exit
+// signal, which are not reported when the remote didn't report the exit
+// status. ok is false when the session was not ended by the remote
+func sshSessionEnded(err error) (status sshExitStatus, ok bool) {
+	var exitErr *ssh.ExitError
+	var exitMissingErr *ssh.ExitMissingError
+
+	switch {
+	case err == nil:
+		return sshExitStatus{reported: true}, true
+
+	case errors.As(err, &exitErr):
+		return sshExitStatus{
+			reported: true,
+			status:   uint32(exitErr.ExitStatus()),
+			signal:   exitErr.Signal(),
+		}, true
+
+	case errors.As(err, &exitMissingErr):
+		return sshExitStatus{}, true
+
+	default:
+		return sshExitStatus{}, false
+	}
+}
+
+// sshRemoteSignals are the POSIX signals the client can send to the remote session
+var sshRemoteSignals = map[string]ssh.Signal{
+	string(ssh.SIGHUP):  ssh.SIGHUP,
+	string(ssh.SIGINT):  ssh.SIGINT,
+	string(ssh.SIGQUIT): ssh.SIGQUIT,
+	string(ssh.SIGKILL): ssh.SIGKILL,
+	string(ssh.SIGTERM): ssh.SIGTERM,
+	string(ssh.SIGUSR1): ssh.SIGUSR1,
+	string(ssh.SIGUSR2): ssh.SIGUSR2,
+}
+
+// signalRemote delivers the signal requested by the SSHDynamicSignal `frame`
+// to the remote session. Remotes may not support the signal requests, which
+// are ignored by them silently
+func (d *sshClient) signalRemote(remote sshRemoteConn, frame []byte) error {
+	if len(frame) < sshDynamicFrameHeaderSize {
+		return ErrSSHDynamicForwardInvalidFrame
+	}
+
+	name := string(frame[sshDynamicFrameHeaderSize:])
+
+	sig, ok := sshRemoteSignals[name]
+	if !ok {
+		d.l.Debug("Ignored unknown signal %q", name)
+
+		return nil
+	}
+
+	d.logTransport("Sending signal %s", name)
+
+	err := remote.session.Signal(sig)
+	if err != nil {
+		d.l.Debug("Failed to send signal %s: %s", name, err)
+	}
+
+	return nil
+}
+
+// interrupt types the interrupt key named by the payload of the `frame` into
+// the remote session, and drops the output of it for the InterruptMuteTime
+func (d *sshClient) interrupt(remote sshRemoteConn, frame []byte) error {
+	if len(frame) < sshDynamicFrameHeaderSize {
+		return ErrSSHDynamicForwardInvalidFrame
+	}
+
+	key, err := interruptKey(frame[sshDynamicFrameHeaderSize:])
+	if err != nil {
+		d.l.Debug("Ignored interrupt: %s", err)
+
+		return nil
+	}
+
+	d.mute.mute(d.cfg.InterruptMuteTime)
+
+	d.logTransport("Interrupting, the output is dropped for %s",
+		d.cfg.InterruptMuteTime)
+
+	_, wErr := remote.writer.Write([]byte{key})
+	if wErr != nil {
+		remote.closer()
+		d.l.Debug("Failed to write interrupt to remote: %s", wErr)
+	}
+
+	return nil
+}
+
+// setRemote hands the `remote` over to the local handlers. It returns true
+// when it replaces a previous one, which is the case of the reconnects
+func (d *sshClient) setRemote(remote sshRemoteConn) (replaced bool) {
+	// Drop the previous remote if it was never picked up
+	select {
+	case <-d.remoteConnReceive:
+	default:
+	}
+
+	replaced = d.remoteHandedOver
+	d.remoteHandedOver = true
+
+	d.remoteConnReceive <- remote
+
+	return replaced
 }
 
 func (d *sshClient) getRemote() (sshRemoteConn, error) {
 	if d.remoteConn.isValid() {
+		// Replace the remote if it has been reconnected
+		select {
+		case remoteConn, ok := <-d.remoteConnReceive:
+			if ok {
+				d.remoteConn = remoteConn
+			}
+		default:
+		}
+
 		return d.remoteConn, nil
 	}
 
@@ -602,12 +2190,65 @@ func (d *sshClient) getRemote() (sshRemoteConn, error) {
 	return d.remoteConn, nil
 }
 
+// parseResize reads the payload of SSHClientResize in the negotiated framing
+func (d *sshClient) parseResize(
+	r *rw.LimitedReader, b []byte) (sshResize, error) {
+	if d.framing == SSHFramingProtobuf {
+		if r.Remains() > len(b) {
+			return sshResize{}, ErrSignalPayloadTooLarge
+		}
+
+		n, err := io.ReadFull(r, b[:r.Remains()])
+		if err != nil {
+			return sshResize{}, err
+		}
+
+		return parseSSHResizeProtobuf(b[:n])
+	}
+
+	_, err := io.ReadFull(r, b[:4])
+	if err != nil {
+		return sshResize{}, err
+	}
+
+	size := sshResize{
+		rows: int(binary.BigEndian.Uint16(b[0:2])),
+		cols: int(binary.BigEndian.Uint16(b[2:4])),
+	}
+
+	// Older clients only send rows and cols, the pixel size is zero
+	// (unknown) for them
+	if r.Remains() >= 4 {
+		_, err = io.ReadFull(r, b[:4])
+		if err != nil {
+			return sshResize{}, err
+		}
+
+		size.width = int(binary.BigEndian.Uint16(b[0:2]))
+		size.height = int(binary.BigEndian.Uint16(b[2:4]))
+	}
+
+	return size, nil
+}
+
 func (d *sshClient) local(
 	f *command.FSM,
 	r *rw.LimitedReader,
 	h command.StreamHeader,
 	b []byte,
 ) error {
+	// Only the answers of the prompts and the resizes are accepted in the
+	// read-only mode
+	if d.cfg.ReadOnly {
+		switch h.Marker() {
+		case SSHClientStdIn,
+			SSHClientReverseForward,
+			SSHClientDynamic,
+			SSHClientFileTransfer:
+			return nil
+		}
+	}
+
 	switch h.Marker() {
 	case SSHClientStdIn:
 		remote, remoteErr := d.getRemote()
@@ -615,13 +2256,25 @@ func (d *sshClient) local(
 			return remoteErr
 		}
 
+		accepted, sErr := d.input.read(r, b)
+		if sErr != nil {
+			return sErr
+		}
+
+		if !accepted {
+			d.l.Debug("Dropped a retransmitted input")
+
+			return r.Ditch(b)
+		}
+
 		for !r.Completed() {
 			rData, rErr := r.Buffered()
 			if rErr != nil {
 				return rErr
 			}
 
-			_, wErr := remote.writer.Write(rData)
+			wLen, wErr := remote.writer.Write(rData)
+			remote.journal.input(wLen)
 			if wErr != nil {
 				remote.closer()
 				d.l.Debug("Failed to write data to remote: %s", wErr)
@@ -636,34 +2289,26 @@ func (d *sshClient) local(
 			return remoteErr
 		}
 
-		_, rErr := io.ReadFull(r, b[:4])
+		size, rErr := d.parseResize(r, b)
 		if rErr != nil {
 			return rErr
 		}
 
-		rows := int(b[0])
-		rows <<= 8
-		rows |= int(b[1])
+		rows, cols := size.rows, size.cols
+		width, height := size.width, size.height
 
-		cols := int(b[2])
-		cols <<= 8
-		cols |= int(b[3])
+		remote.journal.resized(cols, rows)
 
 		// It's ok for it to fail
-		wcErr := remote.session.WindowChange(rows, cols)
+		wcErr := sshWindowChange(remote.session, rows, cols, width, height)
 		if wcErr != nil {
-			d.l.Debug("Failed to resize to %d, %d: %s", rows, cols, wcErr)
+			d.l.Debug("Failed to resize to %d, %d (%dx%d px): %s",
+				rows, cols, width, height, wcErr)
 		}
 
 		return nil
 
 	case SSHClientRespondFingerprint:
-		if d.fingerprintProcessed {
-			return ErrSSHUnexpectedFingerprintVerificationRespond
-		}
-
-		d.fingerprintProcessed = true
-
 		rData, rErr := rw.FetchOneByte(r.Fetch)
 		if rErr != nil {
 			return rErr
@@ -671,25 +2316,23 @@ func (d *sshClient) local(
 
 		comfirmed := rData[0] == 0
 
-		if !comfirmed {
-			d.fingerprintVerifyResultReceive <- false
+		if !d.fingerprint.deliver(comfirmed) {
+			return ErrSSHUnexpectedFingerprintVerificationRespond
+		}
 
+		if !comfirmed {
 			remote, remoteErr := d.getRemote()
 			if remoteErr == nil {
 				remote.closer()
 			}
-		} else {
-			d.fingerprintVerifyResultReceive <- true
 		}
 
 		return nil
 
-	case SSHClientRespondCredential:
-		if d.credentialProcessed {
-			return ErrSSHUnexpectedCredentialDataRespond
-		}
+	case SSHClientRespondStepUp:
+		return readStepUpAnswer(r, d.stepUp)
 
-		d.credentialProcessed = true
+	case SSHClientRespondCredential:
 
 		sshCredentialBufSize := 0
 
@@ -716,42 +2359,138 @@ func (d *sshClient) local(
 			credentialDataBuf = append(credentialDataBuf, rData...)
 		}
 
-		d.credentialReceive <- credentialDataBuf
+		if !d.credential.deliver(credentialDataBuf) {
+			return ErrSSHUnexpectedCredentialDataRespond
+		}
 
 		return nil
 
-	default:
-		return ErrSSHUnknownClientSignal
-	}
-}
+	case SSHClientReverseForward:
+		remote, remoteErr := d.getRemote()
+		if remoteErr != nil {
+			return remoteErr
+		}
 
-func (d *sshClient) Close() error {
-	d.credentialProcessed = true
-	d.fingerprintProcessed = true
+		reqData := make([]byte, 0, r.Remains())
+
+		for !r.Completed() {
+			rData, rErr := r.Buffered()
+			if rErr != nil {
+				return rErr
+			}
+
+			reqData = append(reqData, rData...)
+		}
 
-	if !d.credentialReceiveClosed {
-		close(d.credentialReceive)
+		req, reqErr := parseSSHReverseForwardRequest(d.framing, reqData)
 
-		d.credentialReceiveClosed = true
-	}
+		// Malformed requests are rejected without ending the session
+		if reqErr != nil {
+			d.sendReverseForward(sshReverseForwardResult{
+				Error: ErrSSHReverseForwardInvalid.Error(),
+			})
+
+			return nil
+		}
+
+		d.l.Debug("Requesting reverse forward from %q to %q",
+			req.Bind, req.Target)
+
+		remote.reverse.open(req, func(result sshReverseForwardResult) {
+			if len(result.Error) > 0 {
+				d.l.Debug("Reverse forward from %q to %q has failed: %s",
+					result.Bind, result.Target, result.Error)
+			}
+
+			d.sendReverseForward(result)
+		})
+
+		return nil
+
+	case SSHClientDynamic:
+		remote, remoteErr := d.getRemote()
+		if remoteErr != nil {
+			return remoteErr
+		}
+
+		frame := make([]byte, 0, r.Remains())
+
+		for !r.Completed() {
+			rData, rErr := r.Buffered()
+			if rErr != nil {
+				return rErr
+			}
+
+			frame = append(frame, rData...)
+		}
+
+		if len(frame) > 0 && frame[0]&SSHDynamicSignal != 0 {
+			return d.signalRemote(remote, frame)
+		}
+
+		if len(frame) > 0 && frame[0]&SSHDynamicHandover != 0 {
+			return d.requestHandover()
+		}
+
+		if len(frame) > 0 && frame[0]&SSHDynamicInterrupt != 0 {
+			return d.interrupt(remote, frame)
+		}
+
+		if len(frame) > 0 && frame[0]&SSHDynamicLocalForward != 0 {
+			frame[0] &^= SSHDynamicLocalForward
+
+			return remote.local.handle(frame)
+		}
+
+		return remote.dynamic.handle(frame)
+
+	case SSHClientFileTransfer:
+		remote, remoteErr := d.getRemote()
+		if remoteErr != nil {
+			return remoteErr
+		}
 
-	if !d.fingerprintVerifyResultReceiveClosed {
-		close(d.fingerprintVerifyResultReceive)
+		frame := make([]byte, 0, r.Remains())
 
-		d.fingerprintVerifyResultReceiveClosed = true
+		for !r.Completed() {
+			rData, rErr := r.Buffered()
+			if rErr != nil {
+				return rErr
+			}
+
+			frame = append(frame, rData...)
+		}
+
+		return remote.files.handle(frame)
+
+	default:
+		return ErrSSHUnknownClientSignal
 	}
+}
+
+func (d *sshClient) Close() error {
+	// Cancelling the context stops pending prompts and aborts the ongoing
+	// dial and handshake, so the remote goroutine will either hand over the
+	// connection or give up shortly
+	d.baseCtxCancel()
 
 	remote, remoteErr := d.getRemote()
 	if remoteErr == nil {
 		remote.closer()
 	}
 
-	d.baseCtxCancel()
 	d.remoteCloseWait.Wait()
 
 	return nil
 }
 
+// Terminate disconnects the remote with the `reason` on behalf of the
+// administrator, which also closes the stream
+func (d *sshClient) Terminate(reason string) {
+	d.terminated.terminate(reason)
+	d.baseCtxCancel()
+}
+
 func (d *sshClient) Release() error {
 	d.baseCtxCancel()
 	return nil
@@ -0,0 +1,55 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+// sshAgentSocket returns the socket of the SSH agent that's forwarded to the
+// sessions of the Preset `p`. The agent signs with the keys of the Sshwifty
+// host, so it's never forwarded to the remotes typed in by the users: the
+// global SSHAgentSocket only applies when the remotes are limited to the
+// Presets
+func sshAgentSocket(cfg command.Configuration, p configuration.Preset) string {
+	if len(p.SSHAgentSocket) > 0 {
+		return p.SSHAgentSocket
+	}
+
+	if !cfg.PresetRemotesOnly {
+		return ""
+	}
+
+	return cfg.SSHAgentSocket
+}
+
+// forwardSSHAgent forwards the SSH agent listening on the unix `socket` to
+// the `session`
+func forwardSSHAgent(
+	conn *ssh.Client, session *ssh.Session, socket string) error {
+	err := agent.ForwardToRemote(conn, socket)
+	if err != nil {
+		return err
+	}
+
+	return agent.RequestAgentForwarding(session)
+}
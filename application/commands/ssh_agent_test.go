@@ -0,0 +1,196 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// testSSHAgentServer starts a SSH server which reports the types of the
+// requests sent to it's sessions through the returned channel
+func testSSHAgentServer(t *testing.T) (*ssh.Client, <-chan string) {
+	_, priv, kErr := ed25519.GenerateKey(rand.Reader)
+	if kErr != nil {
+		t.Fatal("Failed to generate key:", kErr)
+	}
+
+	signer, sErr := ssh.NewSignerFromKey(priv)
+	if sErr != nil {
+		t.Fatal("Failed to create signer:", sErr)
+	}
+
+	listener, lErr := net.Listen("tcp", "127.0.0.1:0")
+	if lErr != nil {
+		t.Fatal("Failed to listen:", lErr)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	requests := make(chan string, 16)
+
+	go func() {
+		conn, aErr := listener.Accept()
+		if aErr != nil {
+			return
+		}
+		defer conn.Close()
+
+		serverCfg := &ssh.ServerConfig{NoClientAuth: true}
+		serverCfg.AddHostKey(signer)
+
+		sConn, chans, reqs, hErr := ssh.NewServerConn(conn, serverCfg)
+		if hErr != nil {
+			return
+		}
+		defer sConn.Close()
+
+		go ssh.DiscardRequests(reqs)
+
+		for newChan := range chans {
+			if newChan.ChannelType() != "session" {
+				newChan.Reject(ssh.UnknownChannelType, "")
+				continue
+			}
+
+			ch, chReqs, cErr := newChan.Accept()
+			if cErr != nil {
+				continue
+			}
+
+			go func() {
+				defer ch.Close()
+
+				for req := range chReqs {
+					requests <- req.Type
+					req.Reply(true, nil)
+				}
+			}()
+		}
+	}()
+
+	client, dErr := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if dErr != nil {
+		t.Fatal("Failed to connect:", dErr)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client, requests
+}
+
+// testSSHAgentForwarded returns whether or not the SSH agent is forwarded to
+// the session to the `address` opened with the `cfg`
+func testSSHAgentForwarded(
+	t *testing.T, cfg command.Configuration, address string) bool {
+	client, requests := testSSHAgentServer(t)
+
+	d := newSSH(
+		log.NewDitch(), command.Hooks{}, command.StreamResponder{}, cfg,
+	).(*sshClient)
+	d.usePreset(address)
+
+	s, err := d.openSession(client)
+	if err != nil {
+		t.Fatal("Unable to open session:", err)
+	}
+	defer s.close()
+
+	// Ends the requests of the session, which are sent in order
+	if _, err = s.session.SendRequest("env", true, ssh.Marshal(struct {
+		Name, Value string
+	}{"END", "1"})); err != nil {
+		t.Fatal("Unable to send request:", err)
+	}
+
+	for r := range requests {
+		switch r {
+		case "auth-agent-req@openssh.com":
+			return true
+
+		case "env":
+			return false
+		}
+	}
+
+	return false
+}
+
+func TestSSHAgentForwarding(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, aErr := listener.Accept()
+			if aErr != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				agent.ServeAgent(agent.NewKeyring(), conn)
+			}()
+		}
+	}()
+	presets := []configuration.Preset{
+		{Type: "SSH", Host: "preset:22"},
+		{Type: "SSH", Host: "agent:22", SSHAgentSocket: socket},
+	}
+
+	cfg := command.Configuration{
+		SSHAgentSocket: socket,
+		Presets:        presets,
+	}
+
+	if testSSHAgentForwarded(t, cfg, "typed:22") {
+		t.Error("Expecting the agent not to be forwarded to other remotes")
+	}
+
+	if testSSHAgentForwarded(t, cfg, "preset:22") {
+		t.Error("Expecting the global agent to be forwarded only when the " +
+			"remotes are limited to the Presets")
+	}
+
+	if !testSSHAgentForwarded(t, cfg, "agent:22") {
+		t.Error("Expecting the agent of the Preset to be forwarded")
+	}
+
+	cfg.PresetRemotesOnly = true
+
+	if !testSSHAgentForwarded(t, cfg, "preset:22") {
+		t.Error("Expecting the global agent to be forwarded to the Presets")
+	}
+}
@@ -0,0 +1,45 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"strings"
+)
+
+// sshAttachShorthands are the Attach commands of the well-known terminal
+// multiplexers
+var sshAttachShorthands = map[string]string{
+	"tmux":   "tmux new -A -s sshwifty",
+	"screen": "screen -D -RR sshwifty",
+}
+
+// sshAttachCommand returns the command which attaches to the multiplexer
+// given by the `attach` of a Preset, or starts the login shell instead when
+// the multiplexer is not installed. The name of the multiplexer (the first
+// field of the `attach`) must be verified
+func sshAttachCommand(attach string) string {
+	if c, ok := sshAttachShorthands[attach]; ok {
+		attach = c
+	}
+
+	program := strings.Fields(attach)[0]
+
+	return "if command -v " + program + " >/dev/null 2>&1; then exec " +
+		attach + "; fi; echo 'sshwifty: " + program + " is not available, " +
+		"starting the shell instead' >&2; exec \"${SHELL:-/bin/sh}\" -l"
+}
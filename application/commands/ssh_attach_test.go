@@ -0,0 +1,39 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSSHAttachCommand(t *testing.T) {
+	c := sshAttachCommand("tmux")
+	if !strings.Contains(c, "command -v tmux ") ||
+		!strings.Contains(c, "exec tmux new -A -s sshwifty;") {
+		t.Errorf("Unexpected command %q", c)
+		return
+	}
+
+	c = sshAttachCommand("zellij attach -c main")
+	if !strings.Contains(c, "command -v zellij ") ||
+		!strings.Contains(c, "exec zellij attach -c main;") {
+		t.Errorf("Unexpected command %q", c)
+		return
+	}
+}
@@ -0,0 +1,144 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHPassphraseAuth connects to a SSH server which only accepts the
+// "secret" passphrase, and returns the attempts that `answers` were asked
+// for
+func testSSHPassphraseAuth(
+	t *testing.T, answers []string, received bool) ([]int, error) {
+	_, priv, kErr := ed25519.GenerateKey(rand.Reader)
+	if kErr != nil {
+		t.Fatal("Failed to generate key:", kErr)
+	}
+
+	signer, sErr := ssh.NewSignerFromKey(priv)
+	if sErr != nil {
+		t.Fatal("Failed to create signer:", sErr)
+	}
+
+	listener, lErr := net.Listen("tcp", "127.0.0.1:0")
+	if lErr != nil {
+		t.Fatal("Failed to listen:", lErr)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, aErr := listener.Accept()
+		if aErr != nil {
+			return
+		}
+		defer conn.Close()
+
+		serverCfg := &ssh.ServerConfig{
+			PasswordCallback: func(
+				c ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+				if string(p) != "secret" {
+					return nil, errors.New("wrong passphrase")
+				}
+
+				return nil, nil
+			},
+		}
+		serverCfg.AddHostKey(signer)
+
+		sConn, chans, reqs, hErr := ssh.NewServerConn(conn, serverCfg)
+		if hErr != nil {
+			return
+		}
+		defer sConn.Close()
+
+		go ssh.DiscardRequests(reqs)
+
+		for newChan := range chans {
+			newChan.Reject(ssh.UnknownChannelType, "")
+		}
+	}()
+
+	attempts := []int{}
+
+	client, dErr := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User: "test",
+		Auth: []ssh.AuthMethod{
+			sshPassphraseAuth(func(attempt int) ([]byte, bool, error) {
+				attempts = append(attempts, attempt)
+
+				if len(answers) <= 0 {
+					return nil, false, errors.New("no more answers")
+				}
+
+				answer := answers[0]
+				answers = answers[1:]
+
+				return []byte(answer), received, nil
+			}),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if dErr != nil {
+		return attempts, dErr
+	}
+
+	client.Close()
+
+	return attempts, nil
+}
+
+func TestSSHPassphraseAuthRetry(t *testing.T) {
+	attempts, err := testSSHPassphraseAuth(
+		t, []string{"wrong", "secret"}, true)
+	if err != nil {
+		t.Error("Expecting the second passphrase to be accepted, got:", err)
+	}
+
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Errorf("Expecting attempts [1 2], got %v", attempts)
+	}
+
+	attempts, err = testSSHPassphraseAuth(
+		t, []string{"wrong", "wrong", "wrong", "secret"}, true)
+	if err == nil {
+		t.Error("Expecting the authentication to fail")
+	}
+
+	if len(attempts) != sshMaxPassphraseAttempts {
+		t.Errorf("Expecting %d attempts, got %v",
+			sshMaxPassphraseAttempts, attempts)
+	}
+}
+
+func TestSSHPassphraseAuthCancelled(t *testing.T) {
+	attempts, err := testSSHPassphraseAuth(t, []string{"secret"}, false)
+	if !errors.Is(err, ErrSSHAuthCancelled) {
+		t.Errorf("Expecting %q, got %v", ErrSSHAuthCancelled, err)
+	}
+
+	if len(attempts) != 1 {
+		t.Errorf("Expecting no retry once cancelled, got %v", attempts)
+	}
+}
@@ -0,0 +1,133 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"time"
+)
+
+// SSH bootstrap consts
+const (
+	sshBootstrapMarkerPrefix = "SSHWIFTY_BOOTSTRAP_"
+	sshBootstrapMaxHidden    = 64 * 1024
+	sshBootstrapHideTimeout  = 5 * time.Second
+)
+
+// sshBootstrap is the snippet of a Preset which is written to the shell once
+// it has started. When hidden, the output of the shell is held back until the
+// snippet has been run, which is told by a marker printed after it
+type sshBootstrap struct {
+	snippet  string
+	marker   []byte
+	held     []byte
+	deadline time.Time
+}
+
+// newSSHBootstrap creates a new sshBootstrap of the `snippet`
+func newSSHBootstrap(snippet string, hidden bool) (*sshBootstrap, error) {
+	b := &sshBootstrap{
+		snippet: snippet,
+		marker:  nil,
+		held:    nil,
+	}
+
+	if !hidden {
+		return b, nil
+	}
+
+	id := [16]byte{}
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return nil, err
+	}
+
+	b.marker = []byte(sshBootstrapMarkerPrefix + hex.EncodeToString(id[:]))
+
+	return b, nil
+}
+
+// input returns the data to be written to the shell. The marker is printed
+// by two separated arguments, so the echo of the typed command never matches
+// it
+func (b *sshBootstrap) input() []byte {
+	in := b.snippet
+	if len(in) > 0 && in[len(in)-1] != '\n' {
+		in += "\n"
+	}
+
+	if b.marker != nil {
+		id := b.marker[len(sshBootstrapMarkerPrefix):]
+		in += "printf '%s%s\\n' '" + sshBootstrapMarkerPrefix + "' '" +
+			string(id) + "'\n"
+	}
+
+	return []byte(in)
+}
+
+// start writes the bootstrap to the `shell`, and begins to hide its output
+// when needed
+func (b *sshBootstrap) start(shell io.Writer) error {
+	b.deadline = time.Now().Add(sshBootstrapHideTimeout)
+
+	_, err := shell.Write(b.input())
+
+	return err
+}
+
+// hiding returns whether or not the output of the shell is still held back
+func (b *sshBootstrap) hiding() bool {
+	return b.marker != nil
+}
+
+// filter returns the part of the output `d` that should be shown to the
+// user. Once the marker is found, the output before it is dropped. When the
+// marker doesn't show up in time, or too much output has been held, all
+// the held output is released, so nothing sent by an unexpected shell (i.e.
+// the CLI of a network device) is lost
+func (b *sshBootstrap) filter(d []byte) []byte {
+	if !b.hiding() {
+		return d
+	}
+
+	b.held = append(b.held, d...)
+
+	if i := bytes.Index(b.held, b.marker); i >= 0 {
+		rest := b.held[i+len(b.marker):]
+		rest = bytes.TrimPrefix(rest, []byte("\r"))
+		rest = bytes.TrimPrefix(rest, []byte("\n"))
+
+		b.marker = nil
+		b.held = nil
+
+		return rest
+	}
+
+	if len(b.held) < sshBootstrapMaxHidden && time.Now().Before(b.deadline) {
+		return nil
+	}
+
+	held := b.held
+
+	b.marker = nil
+	b.held = nil
+
+	return held
+}
@@ -0,0 +1,110 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSHBootstrapHidden(t *testing.T) {
+	b, err := newSSHBootstrap("export PS1='$ '", true)
+	if err != nil {
+		t.Error("Failed to create bootstrap:", err)
+		return
+	}
+
+	w := bytes.Buffer{}
+	if err := b.start(&w); err != nil {
+		t.Error("Failed to start bootstrap:", err)
+		return
+	}
+
+	// The shell echoes the typed input, which must not match the marker
+	echo := w.String()
+	if strings.Contains(echo, string(b.marker)) {
+		t.Errorf("Input %q contains the marker", echo)
+		return
+	}
+
+	if d := b.filter([]byte("Welcome\r\n" + echo)); len(d) > 0 {
+		t.Errorf("Expecting output to be hidden, got %q", d)
+		return
+	}
+
+	marker := string(b.marker)
+
+	d := b.filter([]byte(marker[:8]))
+	d = append(d, b.filter([]byte(marker[8:]+"\r\n$ "))...)
+	if string(d) != "$ " {
+		t.Errorf("Expecting %q after the marker, got %q", "$ ", d)
+		return
+	}
+
+	if b.hiding() {
+		t.Error("Expecting the output to be no longer hidden")
+		return
+	}
+
+	if d := b.filter([]byte("ls\r\n")); string(d) != "ls\r\n" {
+		t.Errorf("Expecting output to be passed, got %q", d)
+		return
+	}
+}
+
+func TestSSHBootstrapHiddenTimeout(t *testing.T) {
+	b, err := newSSHBootstrap("alias ll='ls -l'\n", true)
+	if err != nil {
+		t.Error("Failed to create bootstrap:", err)
+		return
+	}
+
+	if err := b.start(&bytes.Buffer{}); err != nil {
+		t.Error("Failed to start bootstrap:", err)
+		return
+	}
+
+	b.filter([]byte("router> "))
+	b.deadline = time.Now().Add(-time.Second)
+
+	if d := b.filter([]byte("% Invalid input")); string(d) !=
+		"router> % Invalid input" {
+		t.Errorf("Expecting held output to be released, got %q", d)
+		return
+	}
+}
+
+func TestSSHBootstrapVisible(t *testing.T) {
+	b, err := newSSHBootstrap("export TERM=xterm", false)
+	if err != nil {
+		t.Error("Failed to create bootstrap:", err)
+		return
+	}
+
+	if in := string(b.input()); in != "export TERM=xterm\n" {
+		t.Errorf("Unexpected input %q", in)
+		return
+	}
+
+	if b.hiding() {
+		t.Error("Expecting the output to be visible")
+		return
+	}
+}
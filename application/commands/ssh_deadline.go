@@ -0,0 +1,193 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// sshReadDeadline manages the read deadline of the remote connection before
+// the SSH session is established.
+//
+// During the handshake, the remote is expected to be silent when we're
+// waiting for the user to respond (i.e. to enter a password or to confirm the
+// fingerprint), so timeouts happened during such interaction must not be
+// treated as failure. How the deadline is managed outside of the interaction
+// is determined by the configuration.ReadDeadlineStrategy.
+type sshReadDeadline struct {
+	strategy      configuration.ReadDeadlineStrategy
+	timeout       time.Duration
+	l             log.Logger
+	lock          sync.Mutex
+	conn          net.Conn
+	deadline      time.Time
+	interactions  int
+	interactStart time.Time
+	cleared       bool
+}
+
+// newSSHReadDeadline creates a new sshReadDeadline
+func newSSHReadDeadline(
+	strategy configuration.ReadDeadlineStrategy,
+	timeout time.Duration,
+	l log.Logger,
+) *sshReadDeadline {
+	return &sshReadDeadline{
+		strategy:      strategy.WithDefault(),
+		timeout:       timeout,
+		l:             l,
+		lock:          sync.Mutex{},
+		conn:          nil,
+		deadline:      sshEmptyTime,
+		interactions:  0,
+		interactStart: sshEmptyTime,
+		cleared:       false,
+	}
+}
+
+// bind starts to manage the read deadline of given `conn`
+func (s *sshReadDeadline) bind(conn net.Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.conn = conn
+
+	switch s.strategy {
+	case configuration.READ_DEADLINE_DISABLED:
+		s.deadline = sshEmptyTime
+	default:
+		s.deadline = time.Now().Add(s.timeout)
+	}
+
+	s.l.Debug("Remote read deadline is managed under %q strategy",
+		s.strategy)
+
+	s.conn.SetReadDeadline(s.deadline)
+}
+
+// interact marks the start of an user interaction. The returned function
+// must be called once the interaction is over
+func (s *sshReadDeadline) interact() func() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.interactions++
+	if s.interactions == 1 {
+		s.interactStart = time.Now()
+	}
+
+	return sync.OnceFunc(func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		s.interactions--
+		if s.interactions > 0 || s.cleared || s.conn == nil {
+			return
+		}
+
+		switch s.strategy {
+		case configuration.READ_DEADLINE_SLIDING:
+			s.deadline = time.Now().Add(s.timeout)
+
+		case configuration.READ_DEADLINE_FIXED:
+			s.deadline = s.deadline.Add(time.Since(s.interactStart))
+
+		default:
+			return
+		}
+
+		s.l.Debug("User interaction completed, remote read deadline is "+
+			"now set to %s", s.deadline.Format(time.RFC3339))
+
+		s.conn.SetReadDeadline(s.deadline)
+	})
+}
+
+// received renews the deadline after data has been received from the remote
+func (s *sshReadDeadline) received() {
+	if s.strategy != configuration.READ_DEADLINE_SLIDING {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.cleared || s.interactions > 0 || s.conn == nil {
+		return
+	}
+
+	s.deadline = time.Now().Add(s.timeout)
+	s.conn.SetReadDeadline(s.deadline)
+}
+
+// timedout is called when a read has timed out. It returns whether or not
+// the read should be retried
+func (s *sshReadDeadline) timedout() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.cleared || s.conn == nil {
+		return false
+	}
+
+	now := time.Now()
+
+	if s.interactions > 0 {
+		// Waiting for the user, keep the connection open without changing
+		// the actual deadline
+		s.conn.SetReadDeadline(now.Add(s.timeout))
+
+		return true
+	}
+
+	// The deadline could be renewed by another routine after the read has
+	// timed out, retry as long as the current deadline is not reached yet
+	if !s.deadline.IsZero() && s.deadline.After(now) {
+		s.conn.SetReadDeadline(s.deadline)
+
+		return true
+	}
+
+	s.l.Debug("Remote read deadline has been exceeded under %q strategy",
+		s.strategy)
+
+	return false
+}
+
+// clear removes the read deadline, and stop managing it
+func (s *sshReadDeadline) clear() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.cleared {
+		return
+	}
+
+	s.cleared = true
+
+	if s.conn == nil {
+		return
+	}
+
+	s.conn.SetReadDeadline(sshEmptyTime)
+}
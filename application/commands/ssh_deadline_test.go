@@ -0,0 +1,87 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+func TestSSHReadDeadlineFixed(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	d := newSSHReadDeadline(
+		configuration.READ_DEADLINE_FIXED, 50*time.Millisecond, log.NewDitch())
+	d.bind(c1)
+	initial := d.deadline
+
+	done := d.interact()
+	if !d.timedout() {
+		t.Error("Expecting timeout to be retried during user interaction")
+		return
+	}
+	time.Sleep(100 * time.Millisecond)
+	done()
+
+	if !d.deadline.After(initial.Add(100 * time.Millisecond)) {
+		t.Errorf("Expecting the deadline to be postponed by the interaction, "+
+			"got %s (initially %s) instead", d.deadline, initial)
+		return
+	}
+
+	if !d.timedout() {
+		t.Error("Expecting timeout to be retried before the deadline")
+		return
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if d.timedout() {
+		t.Error("Expecting timeout not to be retried after the deadline")
+		return
+	}
+}
+
+func TestSSHReadDeadlineDisabled(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	d := newSSHReadDeadline(
+		configuration.READ_DEADLINE_DISABLED, time.Second, log.NewDitch())
+	d.bind(c1)
+
+	if !d.deadline.IsZero() {
+		t.Errorf("Expecting no deadline, got %s instead", d.deadline)
+		return
+	}
+
+	d.interact()()
+
+	if !d.deadline.IsZero() {
+		t.Errorf("Expecting no deadline after interaction, got %s instead",
+			d.deadline)
+		return
+	}
+}
@@ -0,0 +1,392 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Dynamic forward frame types. The frames are sent by the client as
+// SSHClientDynamic signal, and by the server as SSHServerExtendedDynamic
+// extended signal. Each frame starts with the frame type and the 16 bits
+// channel ID, followed by the payload:
+//
+//   - Open: Client -> server, payload is the "host:port" to connect to.
+//     The server replies Open with an empty payload once it's connected
+//   - Data: Both direction, payload is the data of the channel
+//   - Close: Both direction, server may carry the reason in the payload
+//
+// Local forwards (like `ssh -L`) use the same frames. Their channels are
+// opened for the connections accepted by the listeners of the local agent,
+// each to the target fixed by the forward. All client signal markers are
+// taken, so the client sends them as SSHClientDynamic with the frame type
+// flagged by SSHDynamicLocalForward, and the server sends them as the
+// SSHServerExtendedLocalForward extended signal
+//
+// Frames flagged by SSHDynamicSignal are not forwarding frames. They ask the
+// server to deliver a POSIX signal to the remote session, with the channel ID
+// ignored and the payload being the name of the signal without the "SIG"
+// prefix, i.e. "INT". The server doesn't reply them
+//
+// Frames flagged by SSHDynamicHandover are not forwarding frames either. They
+// ask the server to park the session for another client to resume, with the
+// channel ID ignored and no payload. The server replies them with the
+// SSHServerExtendedHandover extended signal
+//
+// Frames flagged by SSHDynamicInterrupt are not forwarding frames either.
+// They ask the server to type the interrupt key into the remote session to
+// stop the program that is flooding the terminal, with the channel ID ignored
+// and the payload being "INT" (^C, the default when empty) or "QUIT" (^\).
+// The server then drops the output of the session for a while (the
+// `InterruptMuteTime`), so the client can catch up. The server doesn't reply
+// them
+const (
+	SSHDynamicOpen  = 0x00
+	SSHDynamicData  = 0x01
+	SSHDynamicClose = 0x02
+
+	SSHDynamicInterrupt    = 0x10
+	SSHDynamicHandover     = 0x20
+	SSHDynamicSignal       = 0x40
+	SSHDynamicLocalForward = 0x80
+)
+
+// Errors
+var (
+	ErrSSHDynamicForwardDisabled = errors.New(
+		"dynamic forwarding is disabled")
+
+	ErrSSHLocalForwardDisabled = errors.New(
+		"local forwarding is disabled")
+
+	ErrSSHDynamicForwardTooMany = errors.New(
+		"too many dynamic forward channels")
+
+	ErrSSHDynamicForwardDuplicated = errors.New(
+		"dynamic forward channel already exists")
+
+	ErrSSHDynamicForwardInvalidFrame = errors.New(
+		"invalid dynamic forward frame")
+
+	ErrSSHDynamicForwardClosed = errors.New(
+		"connection is closing")
+)
+
+const (
+	sshDynamicFrameHeaderSize = 3
+	sshMaxDynamicChannels     = 64
+)
+
+// sshDynamicForwards relays the channels opened by the SOCKS5 server or the
+// local forwards that run on the client side through the SSH connection
+type sshDynamicForwards struct {
+	client   *ssh.Client
+	extended byte
+	enabled  bool
+	disabled error
+	hLen     int
+	sender   func(marker byte, data []byte) error
+	l        log.Logger
+	ctx      context.Context
+	cancel   func()
+	lock     sync.Mutex
+	channels map[uint16]net.Conn
+	closed   bool
+	wait     sync.WaitGroup
+}
+
+// newSSHDynamicForwards creates a sshDynamicForwards. The frames are sent
+// through the `sender`, which requires `hLen` bytes of headers in front of
+// the data just like command.StreamResponder.SendManual
+func newSSHDynamicForwards(
+	client *ssh.Client,
+	enabled bool,
+	hLen int,
+	sender func(marker byte, data []byte) error,
+	l log.Logger,
+) *sshDynamicForwards {
+	return newSSHChannelForwards(
+		client,
+		SSHServerExtendedDynamic,
+		enabled,
+		ErrSSHDynamicForwardDisabled,
+		hLen,
+		sender,
+		l,
+	)
+}
+
+// newSSHLocalForwards creates a sshDynamicForwards for the local forwards
+func newSSHLocalForwards(
+	client *ssh.Client,
+	enabled bool,
+	hLen int,
+	sender func(marker byte, data []byte) error,
+	l log.Logger,
+) *sshDynamicForwards {
+	return newSSHChannelForwards(
+		client,
+		SSHServerExtendedLocalForward,
+		enabled,
+		ErrSSHLocalForwardDisabled,
+		hLen,
+		sender,
+		l,
+	)
+}
+
+// newSSHChannelForwards creates a sshDynamicForwards which sends the frames
+// as the `extended` signal, and refuses to open channels with the `disabled`
+// error unless it's `enabled`
+func newSSHChannelForwards(
+	client *ssh.Client,
+	extended byte,
+	enabled bool,
+	disabled error,
+	hLen int,
+	sender func(marker byte, data []byte) error,
+	l log.Logger,
+) *sshDynamicForwards {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &sshDynamicForwards{
+		client:   client,
+		extended: extended,
+		enabled:  enabled,
+		disabled: disabled,
+		hLen:     hLen,
+		sender:   sender,
+		l:        l,
+		ctx:      ctx,
+		cancel:   cancel,
+		lock:     sync.Mutex{},
+		channels: map[uint16]net.Conn{},
+		closed:   false,
+		wait:     sync.WaitGroup{},
+	}
+}
+
+// send sends a frame to the client. The payload must be placed into `buf`
+// after the headers, and `pLen` is the length of it
+func (s *sshDynamicForwards) send(
+	frame byte, id uint16, buf []byte, pLen int) error {
+	buf[s.hLen] = s.extended
+	buf[s.hLen+1] = frame
+	buf[s.hLen+2] = byte(id >> 8)
+	buf[s.hLen+3] = byte(id)
+
+	return s.sender(
+		SSHServerExtended, buf[:s.hLen+1+sshDynamicFrameHeaderSize+pLen])
+}
+
+// sendClose tells the client the channel `id` is closed because of `err`
+func (s *sshDynamicForwards) sendClose(id uint16, err error) {
+	buf := [512]byte{}
+	pLen := 0
+
+	if err != nil {
+		pLen = copy(
+			buf[s.hLen+1+sshDynamicFrameHeaderSize:], err.Error())
+	}
+
+	s.send(SSHDynamicClose, id, buf[:], pLen)
+}
+
+// handle handles a frame sent by the client
+func (s *sshDynamicForwards) handle(frame []byte) error {
+	if len(frame) < sshDynamicFrameHeaderSize {
+		return ErrSSHDynamicForwardInvalidFrame
+	}
+
+	id := uint16(frame[1])<<8 | uint16(frame[2])
+	payload := frame[sshDynamicFrameHeaderSize:]
+
+	switch frame[0] {
+	case SSHDynamicOpen:
+		s.open(id, string(payload))
+
+	case SSHDynamicData:
+		conn, ok := s.channel(id)
+		if !ok {
+			// The channel could be closed by the server before the client
+			// knows it
+			return nil
+		}
+
+		if _, err := conn.Write(payload); err != nil {
+			s.remove(id, conn)
+			s.sendClose(id, err)
+		}
+
+	case SSHDynamicClose:
+		s.lock.Lock()
+		conn, ok := s.channels[id]
+		s.lock.Unlock()
+
+		if ok {
+			s.remove(id, conn)
+		}
+
+	default:
+		return ErrSSHDynamicForwardInvalidFrame
+	}
+
+	return nil
+}
+
+// channel returns the connection of the opened channel `id`
+func (s *sshDynamicForwards) channel(id uint16) (net.Conn, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	conn, ok := s.channels[id]
+
+	return conn, ok && conn != nil
+}
+
+// remove closes the channel `id` if it's still the `conn`, which is nil when
+// the channel is still being opened. It returns false when the channel is
+// already removed
+func (s *sshDynamicForwards) remove(id uint16, conn net.Conn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if current, ok := s.channels[id]; !ok || current != conn {
+		return false
+	}
+
+	delete(s.channels, id)
+
+	if conn != nil {
+		conn.Close()
+	}
+
+	return true
+}
+
+// reserve reserves the channel `id` for a connection that's being opened
+func (s *sshDynamicForwards) reserve(id uint16) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrSSHDynamicForwardClosed
+	}
+
+	if !s.enabled {
+		return s.disabled
+	}
+
+	if _, ok := s.channels[id]; ok {
+		return ErrSSHDynamicForwardDuplicated
+	}
+
+	if len(s.channels) >= sshMaxDynamicChannels {
+		return ErrSSHDynamicForwardTooMany
+	}
+
+	s.channels[id] = nil
+	s.wait.Add(1)
+
+	return nil
+}
+
+// open connects to the `address` through the SSH connection for channel `id`
+func (s *sshDynamicForwards) open(id uint16, address string) {
+	if err := s.reserve(id); err != nil {
+		s.sendClose(id, err)
+
+		return
+	}
+
+	go func() {
+		defer s.wait.Done()
+
+		conn, err := s.client.DialContext(s.ctx, "tcp", address)
+		if err != nil {
+			s.l.Debug("Unable to open channel %d to %q: %s", id, address, err)
+
+			if s.remove(id, nil) {
+				s.sendClose(id, err)
+			}
+
+			return
+		}
+
+		// The channel could be closed by the client while it's being opened
+		s.lock.Lock()
+		if current, ok := s.channels[id]; !ok || current != nil {
+			s.lock.Unlock()
+			conn.Close()
+
+			return
+		}
+		s.channels[id] = conn
+		s.lock.Unlock()
+
+		buf := [4096]byte{}
+		pStart := s.hLen + 1 + sshDynamicFrameHeaderSize
+
+		if s.send(SSHDynamicOpen, id, buf[:], 0) != nil {
+			s.remove(id, conn)
+
+			return
+		}
+
+		for {
+			rLen, rErr := conn.Read(buf[pStart:])
+			if rErr != nil {
+				if s.remove(id, conn) {
+					s.sendClose(id, nil)
+				}
+
+				return
+			}
+
+			if s.send(SSHDynamicData, id, buf[:], rLen) != nil {
+				s.remove(id, conn)
+
+				return
+			}
+		}
+	}()
+}
+
+// close closes all channels and waits for them to be done
+func (s *sshDynamicForwards) close() {
+	s.lock.Lock()
+	s.closed = true
+	s.cancel()
+	for id, conn := range s.channels {
+		if conn != nil {
+			conn.Close()
+		}
+
+		delete(s.channels, id)
+	}
+	s.lock.Unlock()
+
+	s.wait.Wait()
+}
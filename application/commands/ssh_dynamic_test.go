@@ -0,0 +1,150 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/log"
+)
+
+func TestSSHDynamicForwards(t *testing.T) {
+	client := testSSHForwardServer(t)
+
+	const hLen = 3
+
+	frames := make(chan []byte, 16)
+	sender := func(marker byte, data []byte) error {
+		if marker != SSHServerExtended ||
+			data[hLen] != SSHServerExtendedDynamic {
+			t.Errorf("Unexpected signal %d", marker)
+		}
+
+		frames <- append([]byte{}, data[hLen+1:]...)
+
+		return nil
+	}
+
+	expect := func(frame byte, id uint16, payload string) {
+		select {
+		case f := <-frames:
+			if f[0] != frame || uint16(f[1])<<8|uint16(f[2]) != id ||
+				string(f[3:]) != payload {
+				t.Errorf("Expecting frame %d of %d with %q, got %v",
+					frame, id, payload, f)
+			}
+
+		case <-time.After(5 * time.Second):
+			t.Errorf("Expecting frame %d of %d", frame, id)
+		}
+	}
+
+	disabled := newSSHDynamicForwards(
+		client, false, hLen, sender, log.NewDitch())
+
+	disabled.handle([]byte{SSHDynamicOpen, 0x00, 0x01, 'a', ':', '1'})
+	expect(SSHDynamicClose, 1, ErrSSHDynamicForwardDisabled.Error())
+	disabled.close()
+
+	dynamic := newSSHDynamicForwards(
+		client, true, hLen, sender, log.NewDitch())
+	defer dynamic.close()
+
+	if err := dynamic.handle([]byte{0xff, 0x00, 0x01}); err == nil {
+		t.Error("Expecting unknown frame to be rejected")
+		return
+	}
+
+	open := append([]byte{SSHDynamicOpen, 0x01, 0x02}, "localhost:80"...)
+
+	dynamic.handle(open)
+	expect(SSHDynamicOpen, 0x0102, "")
+
+	dynamic.handle(open)
+	expect(SSHDynamicClose, 0x0102, ErrSSHDynamicForwardDuplicated.Error())
+
+	dynamic.handle(append([]byte{SSHDynamicData, 0x01, 0x02}, "Hello"...))
+	expect(SSHDynamicData, 0x0102, "Hello")
+
+	dynamic.handle([]byte{SSHDynamicClose, 0x01, 0x02})
+
+	if _, ok := dynamic.channel(0x0102); ok {
+		t.Error("Expecting the channel to be closed")
+		return
+	}
+
+	// Data of closed channels are ignored
+	if err := dynamic.handle(
+		append([]byte{SSHDynamicData, 0x01, 0x02}, "Hello"...)); err != nil {
+		t.Error("Unexpected error:", err)
+		return
+	}
+}
+
+func TestSSHLocalForwards(t *testing.T) {
+	client := testSSHForwardServer(t)
+
+	const hLen = 3
+
+	frames := make(chan []byte, 16)
+	sender := func(marker byte, data []byte) error {
+		if marker != SSHServerExtended ||
+			data[hLen] != SSHServerExtendedLocalForward {
+			t.Errorf("Unexpected signal %d", marker)
+		}
+
+		frames <- append([]byte{}, data[hLen+1:]...)
+
+		return nil
+	}
+
+	disabled := newSSHLocalForwards(
+		client, false, hLen, sender, log.NewDitch())
+
+	disabled.handle([]byte{SSHDynamicOpen, 0x00, 0x01, 'a', ':', '1'})
+
+	select {
+	case f := <-frames:
+		if f[0] != SSHDynamicClose ||
+			string(f[3:]) != ErrSSHLocalForwardDisabled.Error() {
+			t.Errorf("Unexpected frame %v", f)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Error("Expecting the channel to be refused")
+	}
+
+	disabled.close()
+
+	local := newSSHLocalForwards(
+		client, true, hLen, sender, log.NewDitch())
+	defer local.close()
+
+	local.handle(append([]byte{SSHDynamicOpen, 0x00, 0x01}, "localhost:80"...))
+
+	select {
+	case f := <-frames:
+		if f[0] != SSHDynamicOpen || len(f) != sshDynamicFrameHeaderSize {
+			t.Errorf("Unexpected frame %v", f)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Error("Expecting the channel to be opened")
+	}
+}
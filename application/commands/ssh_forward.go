@@ -0,0 +1,305 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/forward"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// sshForward describes an established forward
+type sshForward struct {
+	Name    string `json:"name"`
+	Target  string `json:"target"`
+	Local   string `json:"local,omitempty"`
+	Preview string `json:"preview,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// sshForwardDial dials the target of the forwarded connections
+type sshForwardDial func(
+	ctx context.Context, network string, address string) (net.Conn, error)
+
+// sshForwarder relays the connections accepted by the listeners to the
+// targets through the `dial`
+type sshForwarder struct {
+	dial      sshForwardDial
+	l         log.Logger
+	ctx       context.Context
+	ctxCancel func()
+	lock      sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	previews  []func()
+	closed    bool
+	wait      sync.WaitGroup
+}
+
+func newSSHForwarder(dial sshForwardDial, l log.Logger) *sshForwarder {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	return &sshForwarder{
+		dial:      dial,
+		l:         l,
+		ctx:       ctx,
+		ctxCancel: ctxCancel,
+		lock:      sync.Mutex{},
+		listeners: []net.Listener{},
+		conns:     map[net.Conn]struct{}{},
+		previews:  []func(){},
+		closed:    false,
+		wait:      sync.WaitGroup{},
+	}
+}
+
+// startSSHForwards starts listening for the `forwards` on `bindHost`. Forwards
+// that target HTTP services are added to the `previews` instead. Failed
+// forwards are reported with an error rather than stopping the others
+func startSSHForwards(
+	client *ssh.Client,
+	bindHost string,
+	forwards map[string]string,
+	previews *forward.Previews,
+	l log.Logger,
+) (*sshForwarder, []sshForward) {
+	f := newSSHForwarder(client.DialContext, l)
+
+	names := make([]string, 0, len(forwards))
+	for name := range forwards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]sshForward, 0, len(names))
+
+	for _, name := range names {
+		target, isHTTP := forward.HTTPTarget(forwards[name])
+
+		fwd := sshForward{
+			Name:   name,
+			Target: target,
+		}
+
+		if isHTTP {
+			token, remove := previews.Add(forward.Preview{
+				Name:   name,
+				Target: target,
+				Dial:   client.DialContext,
+			})
+
+			if len(token) > 0 {
+				l.Debug("Previewing %q (%s)", name, target)
+
+				f.previews = append(f.previews, remove)
+				fwd.Preview = token
+				result = append(result, fwd)
+
+				continue
+			}
+		}
+
+		listener, err := net.Listen("tcp", net.JoinHostPort(bindHost, "0"))
+		if err != nil {
+			l.Warning("Unable to listen for forward %q: %s", name, err)
+
+			fwd.Error = err.Error()
+			result = append(result, fwd)
+
+			continue
+		}
+
+		fwd.Local = listener.Addr().String()
+		result = append(result, fwd)
+
+		l.Debug("Forwarding %s to %q (%s)", fwd.Local, name, fwd.Target)
+
+		f.serve(listener, name, fwd.Target, func() {})
+	}
+
+	return f, result
+}
+
+// serve relays the connections accepted by the `listener` to the `target`.
+// The `stopped` is called once the `listener` is no longer served. It returns
+// false and closes the `listener` when the forwarder is already closed
+func (f *sshForwarder) serve(
+	listener net.Listener,
+	name string,
+	target string,
+	stopped func(),
+) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		listener.Close()
+		stopped()
+
+		return false
+	}
+
+	f.listeners = append(f.listeners, listener)
+	f.wait.Add(1)
+
+	go f.accept(listener, name, target, stopped)
+
+	return true
+}
+
+// run runs the `fn` in background, close will wait for it to return. It
+// returns false when the forwarder is already closed
+func (f *sshForwarder) run(fn func()) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return false
+	}
+
+	f.wait.Add(1)
+
+	go func() {
+		defer f.wait.Done()
+
+		fn()
+	}()
+
+	return true
+}
+
+// served returns how many listeners has been served
+func (f *sshForwarder) served() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return len(f.listeners)
+}
+
+func (f *sshForwarder) accept(
+	listener net.Listener,
+	name string,
+	target string,
+	stopped func(),
+) {
+	defer func() {
+		listener.Close()
+		stopped()
+		f.wait.Done()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		if !f.track(conn) {
+			conn.Close()
+
+			return
+		}
+
+		go f.relay(conn, name, target)
+	}
+}
+
+// track records the `conn` so it can be closed together with the forwarder.
+// It returns false when the forwarder is already closed
+func (f *sshForwarder) track(conn net.Conn) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return false
+	}
+
+	f.conns[conn] = struct{}{}
+	f.wait.Add(1)
+
+	return true
+}
+
+func (f *sshForwarder) untrack(conn net.Conn) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delete(f.conns, conn)
+	f.wait.Done()
+}
+
+func (f *sshForwarder) relay(conn net.Conn, name string, target string) {
+	defer func() {
+		conn.Close()
+		f.untrack(conn)
+	}()
+
+	remote, err := f.dial(f.ctx, "tcp", target)
+	if err != nil {
+		f.l.Debug("Unable to open forward %q to %s: %s", name, target, err)
+
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		io.Copy(remote, conn)
+
+		if cw, ok := remote.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	}()
+
+	io.Copy(conn, remote)
+
+	// Unblocks the copy above in case the remote is done first
+	conn.Close()
+
+	<-done
+}
+
+// close stops all listeners, closes all forwarded connections and waits for
+// them to be done
+func (f *sshForwarder) close() {
+	f.lock.Lock()
+	f.closed = true
+	f.ctxCancel()
+	for conn := range f.conns {
+		conn.Close()
+	}
+	for _, listener := range f.listeners {
+		listener.Close()
+	}
+	for _, remove := range f.previews {
+		remove()
+	}
+	f.lock.Unlock()
+
+	f.wait.Wait()
+}
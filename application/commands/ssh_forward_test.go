@@ -0,0 +1,230 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/log"
+)
+
+func testSSHForwardServer(t *testing.T) *ssh.Client {
+	_, priv, kErr := ed25519.GenerateKey(rand.Reader)
+	if kErr != nil {
+		t.Fatal("Failed to generate key:", kErr)
+	}
+
+	signer, sErr := ssh.NewSignerFromKey(priv)
+	if sErr != nil {
+		t.Fatal("Failed to create signer:", sErr)
+	}
+
+	listener, lErr := net.Listen("tcp", "127.0.0.1:0")
+	if lErr != nil {
+		t.Fatal("Failed to listen:", lErr)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, aErr := listener.Accept()
+		if aErr != nil {
+			return
+		}
+		defer conn.Close()
+
+		serverCfg := &ssh.ServerConfig{NoClientAuth: true}
+		serverCfg.AddHostKey(signer)
+
+		sConn, chans, reqs, hErr := ssh.NewServerConn(conn, serverCfg)
+		if hErr != nil {
+			return
+		}
+		defer sConn.Close()
+
+		go testSSHForwardServerRequests(t, sConn, reqs)
+
+		// Echos everything sent to the direct-tcpip channels
+		for newChan := range chans {
+			if newChan.ChannelType() != "direct-tcpip" {
+				newChan.Reject(ssh.UnknownChannelType, "")
+				continue
+			}
+
+			ch, chReqs, cErr := newChan.Accept()
+			if cErr != nil {
+				continue
+			}
+
+			go ssh.DiscardRequests(chReqs)
+
+			go func() {
+				defer ch.Close()
+
+				io.Copy(ch, ch)
+			}()
+		}
+	}()
+
+	client, dErr := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if dErr != nil {
+		t.Fatal("Failed to connect:", dErr)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// testSSHForwardServerRequests handles the tcpip-forward requests by
+// listening locally and opening forwarded-tcpip channels for the accepted
+// connections
+func testSSHForwardServerRequests(
+	t *testing.T, sConn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		if req.Type != "tcpip-forward" {
+			req.Reply(false, nil)
+			continue
+		}
+
+		fwd := struct {
+			Host string
+			Port uint32
+		}{}
+
+		if err := ssh.Unmarshal(req.Payload, &fwd); err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+
+		listener, lErr := net.Listen("tcp", net.JoinHostPort(
+			fwd.Host, strconv.FormatUint(uint64(fwd.Port), 10)))
+		if lErr != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		t.Cleanup(func() { listener.Close() })
+
+		port := uint32(listener.Addr().(*net.TCPAddr).Port)
+
+		req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+
+		go func() {
+			for {
+				conn, aErr := listener.Accept()
+				if aErr != nil {
+					return
+				}
+
+				ch, chReqs, oErr := sConn.OpenChannel(
+					"forwarded-tcpip", ssh.Marshal(struct {
+						Host       string
+						Port       uint32
+						OriginHost string
+						OriginPort uint32
+					}{fwd.Host, port, "127.0.0.1", 1}))
+				if oErr != nil {
+					conn.Close()
+					continue
+				}
+
+				go ssh.DiscardRequests(chReqs)
+
+				go func() {
+					defer ch.Close()
+					defer conn.Close()
+
+					go io.Copy(ch, conn)
+
+					io.Copy(conn, ch)
+				}()
+			}
+		}()
+	}
+}
+
+func TestSSHForwarder(t *testing.T) {
+	client := testSSHForwardServer(t)
+
+	forwarder, forwards := startSSHForwards(client, "127.0.0.1",
+		map[string]string{
+			"web": "localhost:80",
+			"db":  "localhost:5432",
+		}, nil, log.NewDitch())
+
+	if len(forwards) != 2 {
+		t.Errorf("Expecting 2 forwards, got %d", len(forwards))
+		return
+	}
+
+	if forwards[0].Name != "db" || forwards[1].Name != "web" {
+		t.Errorf("Expecting forwards to be sorted by name, got %v", forwards)
+		return
+	}
+
+	for _, f := range forwards {
+		if len(f.Error) > 0 || len(f.Local) <= 0 {
+			t.Errorf("Unexpected forward %v", f)
+			return
+		}
+	}
+
+	conn, dErr := net.Dial("tcp", forwards[1].Local)
+	if dErr != nil {
+		t.Error("Failed to dial forward:", dErr)
+		return
+	}
+	defer conn.Close()
+
+	if _, wErr := conn.Write([]byte("Hello")); wErr != nil {
+		t.Error("Failed to write:", wErr)
+		return
+	}
+
+	buf := [5]byte{}
+
+	if _, rErr := io.ReadFull(conn, buf[:]); rErr != nil {
+		t.Error("Failed to read:", rErr)
+		return
+	}
+
+	if string(buf[:]) != "Hello" {
+		t.Errorf("Expecting %q, got %q", "Hello", buf[:])
+		return
+	}
+
+	forwarder.close()
+
+	if _, rErr := conn.Read(buf[:]); rErr == nil {
+		t.Error("Expecting the forwarded connection to be closed")
+		return
+	}
+
+	if _, dErr := net.Dial("tcp", forwards[0].Local); dErr == nil {
+		t.Error("Expecting the listener to be closed")
+		return
+	}
+}
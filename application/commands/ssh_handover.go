@@ -0,0 +1,235 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/handover"
+)
+
+// Errors
+var (
+	ErrSSHHandoverUnavailable = errors.New(
+		"session handover is only available to the logged-in users")
+
+	ErrSSHHandoverNotFound = errors.New(
+		"the session is not waiting to be resumed, it may have expired " +
+			"or been resumed already")
+
+	ErrSSHHandoverExpired = errors.New(
+		"the parked session was not resumed in time")
+)
+
+// SSH handover consts
+const (
+	sshHandoverStateParked  = "parked"
+	sshHandoverStateRefused = "refused"
+)
+
+// sshHandoverStatus tells the client about the result of the handover
+// request
+type sshHandoverStatus struct {
+	State   string `json:"state"`
+	ID      string `json:"id,omitempty"`
+	Expires int64  `json:"expires,omitempty"` // Unix time
+	Error   string `json:"error,omitempty"`
+}
+
+// sshParkedSession is the session left by a client for another one to
+// resume. The output of the session is left unread while it's parked, which
+// eventually pauses the remote
+type sshParkedSession struct {
+	conn          *ssh.Client
+	session       sshRemoteSession
+	user          string
+	address       string
+	journal       *remoteJournal
+	reconnectAuth sshReconnectAuth
+	input         uint32 // Sequence number of the last accepted input
+	stopKeepalive func()
+}
+
+// Close closes the parked session which has not been resumed in time
+func (p *sshParkedSession) Close() error {
+	p.stopKeepalive()
+	p.session.close()
+	p.journal.done(ErrSSHHandoverExpired)
+
+	return p.conn.Close()
+}
+
+// sendHandover sends the `status` of the handover to the client
+func (d *sshClient) sendHandover(status sshHandoverStatus) {
+	buf := [1024]byte{}
+
+	d.sendPayload(SSHServerExtendedHandover, status, buf[:])
+}
+
+// requestHandover asks the remote goroutine to park the session once it's
+// being served. The session stays with the current client when the handover
+// is unavailable to the user
+func (d *sshClient) requestHandover() error {
+	_, err := d.getRemote()
+	if err != nil {
+		return err
+	}
+
+	if !d.cfg.Handover.Enabled() || len(d.cfg.User) <= 0 {
+		d.sendHandover(sshHandoverStatus{
+			State: sshHandoverStateRefused,
+			Error: ErrSSHHandoverUnavailable.Error(),
+		})
+
+		return nil
+	}
+
+	select {
+	case d.handingOver <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// park puts the session `s` opened through the `conn` into the
+// handover.Registry. `detach` stops the `conn` from being closed along with
+// the client, it returns false when it's too late for that. The session is
+// not parked when park returns false, and should be served again if it's
+// still alive
+func (d *sshClient) park(
+	conn *ssh.Client,
+	s sshRemoteSession,
+	user string,
+	address string,
+	rJournal *remoteJournal,
+	detach func() bool,
+) bool {
+	// Sandboxes are disposable, and their limits are counted by the client
+	// which connected them
+	if d.sandbox != nil || !detach() {
+		return false
+	}
+
+	keepaliveCtx, keepaliveCancel := context.WithCancel(context.Background())
+
+	parked := &sshParkedSession{
+		conn:          conn,
+		session:       s,
+		user:          user,
+		address:       address,
+		journal:       rJournal,
+		reconnectAuth: d.reconnectAuth,
+		input:         d.input.last.Load(),
+		stopKeepalive: keepaliveCancel,
+	}
+
+	session, err := d.cfg.Handover.Park(d.cfg.User, handover.Session{
+		Type:   "SSH",
+		Remote: address,
+		Login:  user,
+		Client: d.cfg.ClientAddress,
+	}, parked)
+	if err != nil {
+		keepaliveCancel()
+
+		d.l.Debug("Unable to park the session: %s", err)
+
+		d.sendHandover(sshHandoverStatus{
+			State: sshHandoverStateRefused,
+			Error: err.Error(),
+		})
+
+		return false
+	}
+
+	if d.cfg.SSHKeepaliveInterval > 0 {
+		go func() {
+			err := sshKeepalive(keepaliveCtx, conn,
+				d.cfg.SSHKeepaliveInterval, d.cfg.SSHKeepaliveCountMax)
+			if err != nil && keepaliveCtx.Err() == nil {
+				conn.Close()
+			}
+		}()
+	}
+
+	// The session is no longer closed along with the client
+	d.parked.Store(true)
+
+	d.l.Debug("Session parked for handover until %s", session.Expires)
+
+	d.sendHandover(sshHandoverStatus{
+		State:   sshHandoverStateParked,
+		ID:      session.ID,
+		Expires: session.Expires.Unix(),
+	})
+
+	return true
+}
+
+// takeParked takes the session `id` parked by the same user for the
+// `address` out of the handover.Registry
+func (d *sshClient) takeParked(
+	id string, address string) (*sshParkedSession, error) {
+	_, parked, ok := d.cfg.Handover.Resume(d.cfg.User, id, address)
+	if !ok {
+		return nil, ErrSSHHandoverNotFound
+	}
+
+	p, ok := parked.(*sshParkedSession)
+	if !ok {
+		parked.Close()
+
+		return nil, ErrSSHHandoverNotFound
+	}
+
+	p.stopKeepalive()
+
+	return p, nil
+}
+
+// resume serves the parked session `p` to the current client
+func (d *sshClient) resume(p *sshParkedSession) {
+	defer d.remoteDone()
+
+	buf := [4096]byte{}
+	conn := p.conn
+	parked := false
+
+	defer func() {
+		if parked {
+			return
+		}
+
+		conn.Close()
+		p.journal.done(nil)
+	}()
+
+	stopAbort := context.AfterFunc(d.baseCtx, func() { conn.Close() })
+	defer stopAbort()
+
+	d.l.Debug("Resuming the session parked by another client")
+
+	d.sendFraming(buf[:])
+
+	conn, parked = d.serve(
+		conn, p.session, p.user, p.address, p.journal, stopAbort, buf[:])
+}
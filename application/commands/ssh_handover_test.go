@@ -0,0 +1,80 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/relay"
+)
+
+func TestSSHRelayOutput(t *testing.T) {
+	r, w := io.Pipe()
+	closed := make(chan struct{})
+
+	output := (*relay.Pool)(nil).Relay(r, closed)
+
+	go func() {
+		w.Write([]byte("Hello"))
+		w.Write([]byte("World"))
+		w.Close()
+	}()
+
+	// Nothing is lost while nobody is reading the output, as it is when the
+	// session is parked
+	time.Sleep(10 * time.Millisecond)
+
+	received := []byte{}
+	for data := range output {
+		received = append(received, data...)
+	}
+
+	if string(received) != "HelloWorld" {
+		t.Errorf("Expecting %q, got %q", "HelloWorld", received)
+	}
+}
+
+func TestSSHRelayOutputClosed(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	closed := make(chan struct{})
+	output := (*relay.Pool)(nil).Relay(r, closed)
+	done := make(chan struct{})
+
+	go w.Write([]byte("Hello"))
+
+	// The output that is never read must not keep the relay forever
+	time.Sleep(10 * time.Millisecond)
+	close(closed)
+
+	go func() {
+		defer close(done)
+
+		for range output {
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expecting the relay to stop once the session is closed")
+	}
+}
@@ -0,0 +1,89 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Errors
+var (
+	ErrSSHKeepaliveTimeout = errors.New(
+		"the SSH server has stopped responding to the keepalive requests")
+)
+
+const sshKeepaliveRequest = "keepalive@openssh.com"
+
+// sshRequestSender sends global requests through a SSH connection
+type sshRequestSender interface {
+	SendRequest(
+		name string, wantReply bool, payload []byte) (bool, []byte, error)
+}
+
+// sshKeepalive sends a keepalive request through the `conn` every `interval`
+// until the `ctx` is done, the same as the ServerAliveInterval of OpenSSH.
+// Any reply (even a refusal) proves the server alive. It returns
+// ErrSSHKeepaliveTimeout once `countMax` requests in a row are left
+// unanswered, or the error of the connection
+func sshKeepalive(
+	ctx context.Context,
+	conn sshRequestSender,
+	interval time.Duration,
+	countMax int,
+) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var pending chan error
+	missed := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case err := <-pending:
+			if err != nil {
+				return err
+			}
+
+			pending = nil
+			missed = 0
+
+		case <-ticker.C:
+			if pending != nil {
+				missed++
+
+				if missed >= countMax {
+					return ErrSSHKeepaliveTimeout
+				}
+
+				continue
+			}
+
+			pending = make(chan error, 1)
+
+			go func(result chan<- error) {
+				_, _, err := conn.SendRequest(sshKeepaliveRequest, true, nil)
+				result <- err
+			}(pending)
+		}
+	}
+}
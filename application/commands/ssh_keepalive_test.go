@@ -0,0 +1,64 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type dummySSHRequestSender struct {
+	replies chan struct{}
+}
+
+func (d dummySSHRequestSender) SendRequest(
+	name string,
+	wantReply bool,
+	payload []byte,
+) (bool, []byte, error) {
+	<-d.replies
+
+	return false, nil, nil
+}
+
+func TestSSHKeepaliveTimeout(t *testing.T) {
+	conn := dummySSHRequestSender{replies: make(chan struct{})}
+
+	err := sshKeepalive(
+		context.Background(), conn, 10*time.Millisecond, 3)
+	if err != ErrSSHKeepaliveTimeout {
+		t.Errorf("Expecting error %q, got %q", ErrSSHKeepaliveTimeout, err)
+		return
+	}
+}
+
+func TestSSHKeepaliveAnswered(t *testing.T) {
+	conn := dummySSHRequestSender{replies: make(chan struct{})}
+	close(conn.replies)
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := sshKeepalive(ctx, conn, 10*time.Millisecond, 2)
+	if err != nil {
+		t.Errorf("Expecting no error, got %q", err)
+		return
+	}
+}
@@ -0,0 +1,81 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"crypto/x509"
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Errors
+var (
+	ErrSSHKeyPassphraseIncorrect = errors.New(
+		"incorrect passphrase of the private key")
+)
+
+// checkSSHPrivateKey returns an error when the private `key` is malformed or
+// of an unsupported type. Encrypted keys are not decrypted, the passphrase is
+// only asked once they're used
+func checkSSHPrivateKey(key []byte) error {
+	_, err := ssh.ParseRawPrivateKey(key)
+
+	missing := &ssh.PassphraseMissingError{}
+	if err == nil || errors.As(err, &missing) {
+		return nil
+	}
+
+	return err
+}
+
+// parseSSHPrivateKey parses the private `key`. When the key is encrypted,
+// the passphrase is requested through `passphrase` (which receives the
+// number of the attempt) until it's correct, for up to `maxAttempts` times
+func parseSSHPrivateKey(
+	key []byte,
+	maxAttempts int,
+	passphrase func(attempt int) ([]byte, error),
+) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(key)
+
+	missing := &ssh.PassphraseMissingError{}
+	if !errors.As(err, &missing) {
+		return signer, err
+	}
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		p, pErr := passphrase(attempt)
+		if pErr != nil {
+			return nil, pErr
+		}
+
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, p)
+
+		// Don't keep the passphrase around longer than needed
+		for i := range p {
+			p[i] = 0
+		}
+
+		if err != x509.IncorrectPasswordError {
+			return signer, err
+		}
+	}
+
+	return nil, ErrSSHKeyPassphraseIncorrect
+}
@@ -0,0 +1,72 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"io"
+)
+
+const (
+	// Smaller packets would be mostly the headers
+	sshMinPacketSize = 512
+)
+
+// sshPacketWriter splits the writes to an SSH channel, so every data packet
+// sent to the remote carries no more than `max` bytes. The SSH library
+// only splits them by the limit the remote has asked for
+type sshPacketWriter struct {
+	io.WriteCloser
+
+	max int
+}
+
+// newSSHPacketWriter returns the `w` which writes no more than `max` bytes
+// at once, or the `w` itself when `max` is 0
+func newSSHPacketWriter(w io.WriteCloser, max int) io.WriteCloser {
+	if max <= 0 {
+		return w
+	}
+
+	if max < sshMinPacketSize {
+		max = sshMinPacketSize
+	}
+
+	return sshPacketWriter{
+		WriteCloser: w,
+		max:         max,
+	}
+}
+
+func (s sshPacketWriter) Write(b []byte) (int, error) {
+	written := 0
+
+	for len(b) > 0 {
+		n := min(len(b), s.max)
+
+		wLen, wErr := s.WriteCloser.Write(b[:n])
+		written += wLen
+
+		if wErr != nil {
+			return written, wErr
+		}
+
+		b = b[n:]
+	}
+
+	return written, nil
+}
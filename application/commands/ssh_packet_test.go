@@ -0,0 +1,84 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+type testSSHPacketRecorder struct {
+	bytes.Buffer
+
+	writes []int
+}
+
+func (t *testSSHPacketRecorder) Write(b []byte) (int, error) {
+	t.writes = append(t.writes, len(b))
+
+	return t.Buffer.Write(b)
+}
+
+func (t *testSSHPacketRecorder) Close() error {
+	return nil
+}
+
+func TestSSHPacketWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 1300)
+
+	r := &testSSHPacketRecorder{}
+	w := newSSHPacketWriter(r, 600)
+
+	if n, err := w.Write(data); n != len(data) || err != nil {
+		t.Fatalf("Unexpected write result %d, %v", n, err)
+	}
+
+	if len(r.writes) != 3 || r.writes[0] != 600 || r.writes[2] != 100 {
+		t.Errorf("Expecting writes of 600, 600 and 100, got %v", r.writes)
+	}
+
+	if !bytes.Equal(r.Bytes(), data) {
+		t.Error("Expecting the data to be written as it is")
+	}
+
+	// Raised to the minimum
+	r = &testSSHPacketRecorder{}
+	newSSHPacketWriter(r, 1).Write(data)
+
+	if r.writes[0] != sshMinPacketSize {
+		t.Errorf("Expecting writes of %d, got %v", sshMinPacketSize, r.writes)
+	}
+
+	// Not limited
+	r = &testSSHPacketRecorder{}
+	if newSSHPacketWriter(r, 0) != r {
+		t.Error("Expecting the writer to be left unwrapped")
+	}
+}
+
+func TestSSHConfigRekeyThreshold(t *testing.T) {
+	d := newSSH(log.NewDitch(), command.Hooks{}, command.StreamResponder{},
+		command.Configuration{SSHRekeyThreshold: 4096}).(*sshClient)
+
+	if c := d.sshConfig(); c.RekeyThreshold != 4096 {
+		t.Errorf("Expecting RekeyThreshold 4096, got %d", c.RekeyThreshold)
+	}
+}
@@ -0,0 +1,75 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"sync/atomic"
+)
+
+// sshPrompt passes the respond of the user from the FSM goroutine to the
+// remote goroutine which is waiting for it.
+//
+// A respond is only accepted when the remote goroutine is expecting one, and
+// the channel is never closed, so the FSM goroutine can deliver responds at
+// any time without worrying about the state of the remote goroutine
+type sshPrompt[T any] struct {
+	expecting atomic.Bool
+	respond   chan T
+}
+
+// newSSHPrompt creates a new sshPrompt
+func newSSHPrompt[T any]() *sshPrompt[T] {
+	return &sshPrompt[T]{
+		expecting: atomic.Bool{},
+		respond:   make(chan T, 1),
+	}
+}
+
+// expect starts to accept a respond. Respond left by the previous prompt
+// is discarded
+func (p *sshPrompt[T]) expect() {
+	select {
+	case <-p.respond:
+	default:
+	}
+
+	p.expecting.Store(true)
+}
+
+// stop stops accepting responds
+func (p *sshPrompt[T]) stop() {
+	p.expecting.Store(false)
+}
+
+// deliver delivers the respond `v`. It returns false when no respond is
+// expected
+func (p *sshPrompt[T]) deliver(v T) bool {
+	if !p.expecting.CompareAndSwap(true, false) {
+		return false
+	}
+
+	// Only one respond can be accepted for each prompt, so it never blocks
+	p.respond <- v
+
+	return true
+}
+
+// received returns the channel where the respond can be received from
+func (p *sshPrompt[T]) received() <-chan T {
+	return p.respond
+}
@@ -0,0 +1,198 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSSHPromptDeliver(t *testing.T) {
+	p := newSSHPrompt[bool]()
+
+	if p.deliver(true) {
+		t.Error("Expecting respond to be rejected when no prompt is pending")
+		return
+	}
+
+	p.expect()
+
+	if !p.deliver(true) {
+		t.Error("Expecting respond to be accepted")
+		return
+	}
+
+	if p.deliver(false) {
+		t.Error("Expecting the second respond to be rejected")
+		return
+	}
+
+	if v := <-p.received(); !v {
+		t.Errorf("Expecting the first respond to be received, got %v", v)
+		return
+	}
+}
+
+func TestSSHPromptExpectDiscardsStaleRespond(t *testing.T) {
+	p := newSSHPrompt[[]byte]()
+
+	p.expect()
+	p.deliver([]byte("stale"))
+	p.stop()
+
+	p.expect()
+
+	select {
+	case v := <-p.received():
+		t.Errorf("Expecting stale respond to be discarded, got %q", v)
+		return
+	default:
+	}
+
+	if !p.deliver([]byte("fresh")) {
+		t.Error("Expecting respond to be accepted")
+		return
+	}
+
+	if v := <-p.received(); string(v) != "fresh" {
+		t.Errorf("Expecting \"fresh\", got %q", v)
+		return
+	}
+}
+
+func TestSSHPromptStop(t *testing.T) {
+	p := newSSHPrompt[bool]()
+
+	p.expect()
+	p.stop()
+
+	if p.deliver(true) {
+		t.Error("Expecting respond to be rejected after the prompt stopped")
+		return
+	}
+}
+
+func TestWaitSSHPromptCountdown(t *testing.T) {
+	p := newSSHPrompt[bool]()
+	deadlines := []time.Time{}
+	requested := false
+
+	_, received, err := waitSSHPrompt(
+		context.Background(),
+		50*time.Millisecond,
+		10*time.Millisecond,
+		func(deadline time.Time) error {
+			if len(deadlines) <= 0 && requested {
+				t.Error("Expecting the countdown to be sent before the request")
+			}
+
+			deadlines = append(deadlines, deadline)
+			return nil
+		},
+		func() error {
+			requested = true
+			return nil
+		},
+		p,
+	)
+	if !errors.Is(err, ErrSSHPromptTimeout) || received {
+		t.Errorf("Expecting %q, got %v, %v", ErrSSHPromptTimeout, received, err)
+	}
+
+	// Sent once before the request, then on every interval
+	if len(deadlines) < 3 {
+		t.Errorf("Expecting the countdown to be repeated, got %d",
+			len(deadlines))
+	}
+
+	for _, d := range deadlines[1:] {
+		if !d.Equal(deadlines[0]) {
+			t.Errorf("Expecting the deadline %s, got %s", deadlines[0], d)
+		}
+	}
+
+	if p.deliver(true) {
+		t.Error("Expecting the late respond to be rejected")
+	}
+}
+
+func TestWaitSSHPromptRespond(t *testing.T) {
+	p := newSSHPrompt[[]byte]()
+	countdownErr := errors.New("countdown failed")
+
+	for _, timeout := range []time.Duration{0, time.Minute} {
+		result, received, err := waitSSHPrompt(
+			context.Background(),
+			timeout,
+			time.Minute,
+			func(deadline time.Time) error {
+				if timeout <= 0 {
+					t.Error("Expecting no countdown without the timeout")
+				}
+
+				return nil
+			},
+			func() error {
+				p.deliver([]byte("secret"))
+				return nil
+			},
+			p,
+		)
+		if err != nil || !received || string(result) != "secret" {
+			t.Errorf("Unexpected respond %q, %v, %v", result, received, err)
+		}
+	}
+
+	_, _, err := waitSSHPrompt(
+		context.Background(),
+		time.Minute,
+		time.Minute,
+		func(deadline time.Time) error {
+			return countdownErr
+		},
+		func() error {
+			t.Error("Expecting no request when the countdown failed")
+			return nil
+		},
+		p,
+	)
+	if !errors.Is(err, countdownErr) {
+		t.Errorf("Expecting %q, got %v", countdownErr, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, received, err := waitSSHPrompt(
+		ctx,
+		time.Minute,
+		time.Minute,
+		func(deadline time.Time) error {
+			return nil
+		},
+		func() error {
+			return nil
+		},
+		p,
+	)
+	if err != nil || received {
+		t.Errorf("Expecting cancellation, got %v, %v", received, err)
+	}
+}
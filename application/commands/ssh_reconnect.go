@@ -0,0 +1,263 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/network"
+)
+
+// Errors
+var (
+	ErrSSHConnectionDropped = errors.New(
+		"the connection to the remote has dropped")
+
+	ErrSSHReconnectUnavailable = errors.New(
+		"the credentials of the connection were not kept, unable to " +
+			"reconnect")
+
+	ErrSSHReconnectHostKeyMismatch = errors.New(
+		"the host key differs from the one of the previous connection")
+)
+
+// SSH reconnect consts
+const (
+	sshReconnectInitialDelay = time.Second
+	sshReconnectStateWaiting = "waiting"
+	sshReconnectStateDone    = "reconnected"
+	sshReconnectStateFailed  = "failed"
+)
+
+// sshReconnectAuth keeps the credentials accepted by the remote, so the
+// connection can be authenticated again without asking the user once it
+// has dropped. Nothing is kept when the reconnect is disabled
+type sshReconnectAuth struct {
+	enabled     bool
+	password    string
+	hasPassword bool
+	signer      ssh.Signer
+	hostKey     ssh.PublicKey
+}
+
+// cachePassword keeps the `password`. The last one is the accepted one once
+// the authentication has succeeded
+func (a *sshReconnectAuth) cachePassword(password string) {
+	if !a.enabled {
+		return
+	}
+
+	a.password = password
+	a.hasPassword = true
+}
+
+// cacheSigner keeps the `signer` of the decrypted private key
+func (a *sshReconnectAuth) cacheSigner(signer ssh.Signer) {
+	if !a.enabled {
+		return
+	}
+
+	a.signer = signer
+}
+
+// cacheHostKey keeps the host `key` accepted by the user
+func (a *sshReconnectAuth) cacheHostKey(key ssh.PublicKey) {
+	if !a.enabled {
+		return
+	}
+
+	a.hostKey = key
+}
+
+// usable returns whether or not the connection can be authenticated again
+func (a *sshReconnectAuth) usable() bool {
+	return a.hostKey != nil
+}
+
+// methods returns the ssh.AuthMethod built from the kept credentials. None
+// for the connections which were authenticated by the "none" method
+func (a *sshReconnectAuth) methods() []ssh.AuthMethod {
+	methods := []ssh.AuthMethod{}
+
+	if a.signer != nil {
+		methods = append(methods, ssh.PublicKeys(a.signer))
+	}
+
+	if a.hasPassword {
+		methods = append(methods, ssh.Password(a.password))
+	}
+
+	return methods
+}
+
+// verifyHostKey only accepts the host key of the previous connection, as
+// the user can't be asked to confirm a new one
+func (a *sshReconnectAuth) verifyHostKey(
+	hostname string,
+	remote net.Addr,
+	key ssh.PublicKey,
+) error {
+	if a.hostKey == nil ||
+		!bytes.Equal(a.hostKey.Marshal(), key.Marshal()) {
+		return ErrSSHReconnectHostKeyMismatch
+	}
+
+	return nil
+}
+
+// sshReconnectDelay returns the time to wait before the reconnect `attempt`
+// (starts from 1), which doubles after every attempt up to the `max`
+func sshReconnectDelay(attempt int, max time.Duration) time.Duration {
+	if attempt > 16 {
+		return max
+	}
+
+	delay := sshReconnectInitialDelay << (attempt - 1)
+	if delay > max {
+		return max
+	}
+
+	return delay
+}
+
+// sshReconnectStatus tells the client about the progress of the reconnect
+type sshReconnectStatus struct {
+	State    string `json:"state"`
+	Attempt  int    `json:"attempt"`
+	Attempts int    `json:"attempts"`
+	Delay    int64  `json:"delay,omitempty"` // In millisecond
+	Error    string `json:"error,omitempty"`
+}
+
+// sendReconnect sends the `status` of the reconnect to the client
+func (d *sshClient) sendReconnect(status sshReconnectStatus, buf []byte) {
+	d.sendPayload(SSHServerExtendedReconnect, status, buf)
+}
+
+// redial connects and authenticates to the remote again. The hooks, the
+// risk scoring, the step-up and the approval are not repeated, as they were
+// done for the dropped connection
+func (d *sshClient) redial(user string, address string) (*ssh.Client, error) {
+	if conn, warm := d.cfg.Warmup.Take(address, user); warm {
+		d.logTransport("Reconnected through a warm connection")
+
+		return conn, nil
+	}
+
+	if !d.reconnectAuth.usable() {
+		return nil, ErrSSHReconnectUnavailable
+	}
+
+	conn, clearConnInitialDeadline, err := d.dialRemote(
+		"tcp",
+		address,
+		network.NewDialTrace(),
+		newSSHTransportCapture(),
+		&ssh.ClientConfig{
+			Config:            d.sshConfig(),
+			HostKeyAlgorithms: d.algorithms.HostKeyAlgorithms,
+			User:              user,
+			Auth:              d.reconnectAuth.methods(),
+			HostKeyCallback:   d.reconnectAuth.verifyHostKey,
+			Timeout:           d.cfg.DialTimeout,
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	clearConnInitialDeadline()
+
+	return conn, nil
+}
+
+// reconnect connects to the remote again after the connection has dropped,
+// and opens a new session through it. The client is told about every
+// attempt
+func (d *sshClient) reconnect(
+	user string,
+	address string,
+	buf []byte,
+) (*ssh.Client, sshRemoteSession, error) {
+	err := ErrSSHConnectionDropped
+	attempts := d.cfg.SSHReconnectAttempts
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		delay := sshReconnectDelay(attempt, d.cfg.SSHReconnectMaxDelay)
+
+		d.logTransport("Reconnecting in %s (attempt %d of %d)",
+			delay, attempt, attempts)
+
+		d.sendReconnect(sshReconnectStatus{
+			State:    sshReconnectStateWaiting,
+			Attempt:  attempt,
+			Attempts: attempts,
+			Delay:    delay.Milliseconds(),
+			Error:    err.Error(),
+		}, buf)
+
+		wait := time.NewTimer(delay)
+
+		select {
+		case <-d.baseCtx.Done():
+			wait.Stop()
+
+			return nil, sshRemoteSession{}, d.baseCtx.Err()
+
+		case <-wait.C:
+		}
+
+		conn, dErr := d.redial(user, address)
+		if dErr != nil {
+			d.logTransport("Reconnect attempt %d has failed: %s",
+				attempt, dErr)
+
+			err = dErr
+			continue
+		}
+
+		s, sErr := d.openSession(conn)
+		if sErr != nil {
+			conn.Close()
+
+			err = sErr
+			continue
+		}
+
+		d.sendReconnect(sshReconnectStatus{
+			State:    sshReconnectStateDone,
+			Attempt:  attempt,
+			Attempts: attempts,
+		}, buf)
+
+		return conn, s, nil
+	}
+
+	d.sendReconnect(sshReconnectStatus{
+		State:    sshReconnectStateFailed,
+		Attempt:  attempts,
+		Attempts: attempts,
+		Error:    err.Error(),
+	}, buf)
+
+	return nil, sshRemoteSession{}, err
+}
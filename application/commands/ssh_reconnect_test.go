@@ -0,0 +1,93 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHReconnectDelay(t *testing.T) {
+	for _, c := range []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{6, 30 * time.Second},
+		{100, 30 * time.Second},
+	} {
+		if d := sshReconnectDelay(c.attempt, 30*time.Second); d != c.expected {
+			t.Errorf("Expecting delay %s for attempt %d, got %s",
+				c.expected, c.attempt, d)
+			return
+		}
+	}
+}
+
+func TestSSHReconnectAuth(t *testing.T) {
+	newKey := func() ssh.PublicKey {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal("Failed to generate key:", err)
+		}
+
+		key, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			t.Fatal("Failed to convert key:", err)
+		}
+
+		return key
+	}
+
+	known, other := newKey(), newKey()
+
+	disabled := sshReconnectAuth{}
+	disabled.cachePassword("secret")
+	disabled.cacheHostKey(known)
+
+	if disabled.usable() || disabled.hasPassword {
+		t.Error("Expecting nothing to be kept when disabled")
+		return
+	}
+
+	a := sshReconnectAuth{enabled: true}
+	a.cachePassword("wrong")
+	a.cachePassword("secret")
+	a.cacheHostKey(known)
+
+	if !a.usable() || a.password != "secret" || len(a.methods()) != 1 {
+		t.Error("Expecting the last password to be kept")
+		return
+	}
+
+	if err := a.verifyHostKey("localhost", nil, known); err != nil {
+		t.Error("Unexpected error:", err)
+		return
+	}
+
+	if err := a.verifyHostKey("localhost", nil, other); err == nil {
+		t.Error("Expecting a changed host key to be refused")
+		return
+	}
+}
@@ -0,0 +1,190 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/forward"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Errors
+var (
+	ErrSSHReverseForwardDisabled = errors.New(
+		"reverse forwarding is disabled")
+
+	ErrSSHReverseForwardNotAllowed = errors.New(
+		"binding to the address is not allowed")
+
+	ErrSSHReverseForwardTooMany = errors.New(
+		"too many reverse forwards")
+
+	ErrSSHReverseForwardClosed = errors.New(
+		"connection is closing")
+
+	ErrSSHReverseForwardInvalid = errors.New(
+		"invalid reverse forward request")
+)
+
+const (
+	sshReverseForwardRequestMaxSize = 512
+	sshMaxReverseForwards           = 16
+)
+
+// sshReverseForwardRequest is the reverse forward requested by the client
+type sshReverseForwardRequest struct {
+	Bind   string `json:"bind"`
+	Target string `json:"target"`
+}
+
+// sshReverseForwardResult is the result of a sshReverseForwardRequest
+type sshReverseForwardResult struct {
+	Bind   string `json:"bind"`
+	Target string `json:"target"`
+	Listen string `json:"listen,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// sshReverseForwards listens on the remote for the reverse forwards allowed
+// by the policy, and relays the accepted connections to the targets dialed
+// by Sshwifty
+type sshReverseForwards struct {
+	client    *ssh.Client
+	policy    forward.Policy
+	registry  *forward.Registry
+	entry     forward.Entry
+	forwarder *sshForwarder
+}
+
+func newSSHReverseForwards(
+	client *ssh.Client,
+	cfg command.Configuration,
+	remote string,
+	l log.Logger,
+) *sshReverseForwards {
+	return &sshReverseForwards{
+		client:   client,
+		policy:   cfg.ReverseForwardPolicy,
+		registry: cfg.ReverseForwards,
+		entry: forward.Entry{
+			User:   cfg.User,
+			Client: cfg.ClientIP(),
+			Remote: remote,
+		},
+		forwarder: newSSHForwarder(func(
+			ctx context.Context, n string, addr string,
+		) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(ctx, cfg.DialTimeout)
+			defer cancel()
+
+			return cfg.Dial(ctx, n, addr)
+		}, l),
+	}
+}
+
+// verify returns an error when the `req` is not allowed
+func (r *sshReverseForwards) verify(req sshReverseForwardRequest) error {
+	if !r.policy.Enabled() {
+		return ErrSSHReverseForwardDisabled
+	}
+
+	host, port, err := net.SplitHostPort(req.Bind)
+	if err != nil {
+		return fmt.Errorf("invalid bind address: %s", err)
+	}
+
+	if len(host) <= 0 {
+		return errors.New("invalid bind address: host is required")
+	}
+
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return errors.New("invalid bind address: invalid port")
+	}
+
+	if !r.policy.Allows(host, uint16(portNum)) {
+		return ErrSSHReverseForwardNotAllowed
+	}
+
+	if _, _, err := net.SplitHostPort(req.Target); err != nil {
+		return fmt.Errorf("invalid target address: %s", err)
+	}
+
+	if r.forwarder.served() >= sshMaxReverseForwards {
+		return ErrSSHReverseForwardTooMany
+	}
+
+	return nil
+}
+
+// open asks the remote to listen for the `req`, and calls `done` with the
+// result. `done` is not called when the forwards are already closed
+func (r *sshReverseForwards) open(
+	req sshReverseForwardRequest,
+	done func(sshReverseForwardResult),
+) {
+	result := sshReverseForwardResult{
+		Bind:   req.Bind,
+		Target: req.Target,
+	}
+
+	if err := r.verify(req); err != nil {
+		result.Error = err.Error()
+		done(result)
+
+		return
+	}
+
+	// Listen requires a round trip to the remote, so don't block the caller
+	r.forwarder.run(func() {
+		listener, err := r.client.Listen("tcp", req.Bind)
+		if err != nil {
+			result.Error = err.Error()
+			done(result)
+
+			return
+		}
+
+		result.Listen = listener.Addr().String()
+
+		e := r.entry
+		e.Bind = result.Listen
+		e.Target = req.Target
+
+		stopped := r.registry.Add(e)
+
+		if !r.forwarder.serve(listener, req.Bind, req.Target, stopped) {
+			result.Error = ErrSSHReverseForwardClosed.Error()
+		}
+
+		done(result)
+	})
+}
+
+// close stops all reverse forwards
+func (r *sshReverseForwards) close() {
+	r.forwarder.close()
+}
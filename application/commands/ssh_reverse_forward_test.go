@@ -0,0 +1,142 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/forward"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+func TestSSHReverseForwards(t *testing.T) {
+	client := testSSHForwardServer(t)
+
+	// The target echos whatever it receives
+	target, lErr := net.Listen("tcp", "127.0.0.1:0")
+	if lErr != nil {
+		t.Error("Failed to listen:", lErr)
+		return
+	}
+	defer target.Close()
+
+	go func() {
+		for {
+			conn, aErr := target.Accept()
+			if aErr != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	policy, pErr := forward.ParsePolicy([]string{"127.0.0.1:0"})
+	if pErr != nil {
+		t.Error("Failed to parse policy:", pErr)
+		return
+	}
+
+	registry := forward.NewRegistry()
+	dialer := net.Dialer{}
+
+	reverse := newSSHReverseForwards(client, command.Configuration{
+		Dial: func(
+			ctx context.Context, n string, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, n, addr)
+		},
+		DialTimeout:          time.Second,
+		User:                 "tester",
+		ReverseForwardPolicy: policy,
+		ReverseForwards:      registry,
+	}, "remote:22", log.NewDitch())
+
+	results := make(chan sshReverseForwardResult, 1)
+	done := func(r sshReverseForwardResult) { results <- r }
+
+	for _, bind := range []string{"127.0.0.1:8080", "0.0.0.0:0", ":0"} {
+		reverse.open(sshReverseForwardRequest{
+			Bind:   bind,
+			Target: target.Addr().String(),
+		}, done)
+
+		if r := <-results; len(r.Error) <= 0 {
+			t.Errorf("Expecting binding to %q to be rejected", bind)
+			return
+		}
+	}
+
+	reverse.open(sshReverseForwardRequest{
+		Bind:   "127.0.0.1:0",
+		Target: target.Addr().String(),
+	}, done)
+
+	result := <-results
+	if len(result.Error) > 0 || len(result.Listen) <= 0 {
+		t.Errorf("Unexpected result %v", result)
+		return
+	}
+
+	entries := registry.Entries()
+	if len(entries) != 1 ||
+		entries[0].User != "tester" ||
+		entries[0].Bind != result.Listen {
+		t.Errorf("Unexpected entries %v", entries)
+		return
+	}
+
+	conn, dErr := net.Dial("tcp", result.Listen)
+	if dErr != nil {
+		t.Error("Failed to dial reverse forward:", dErr)
+		return
+	}
+	defer conn.Close()
+
+	if _, wErr := conn.Write([]byte("Hello")); wErr != nil {
+		t.Error("Failed to write:", wErr)
+		return
+	}
+
+	buf := [5]byte{}
+
+	if _, rErr := io.ReadFull(conn, buf[:]); rErr != nil {
+		t.Error("Failed to read:", rErr)
+		return
+	}
+
+	if string(buf[:]) != "Hello" {
+		t.Errorf("Expecting %q, got %q", "Hello", buf[:])
+		return
+	}
+
+	reverse.close()
+
+	if len(registry.Entries()) != 0 {
+		t.Error("Expecting the forward to be removed from the registry")
+		return
+	}
+}
@@ -0,0 +1,707 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/sftp"
+)
+
+// File transfer frame types. The frames are sent by the client as
+// SSHClientFileTransfer signal, and by the server as
+// SSHServerExtendedFileTransfer extended signal. Each frame starts with the
+// frame type and the 16 bits request ID, followed by the payload:
+//
+//   - List: Client -> server, payload is the path of the directory, empty
+//     for the home directory. The server replies with List frames which
+//     carry the entries, and then a Done frame which carries the absolute
+//     path of the directory
+//   - Download: Client -> server, payload is the path of the file. The
+//     server replies Download with the 64 bits size of the file, followed
+//     by Data frames and then a Done frame
+//   - Upload: Client -> server, payload is the path of the file. The server
+//     replies Upload with an empty payload once the file is created, then
+//     the client sends Data frames and a Done frame. The server replies Done
+//     once the file is completely written
+//   - Data: Both direction, payload is the data of the file
+//   - Rename: Client -> server, payload is the old path and the new path
+//     separated by a NUL byte. Replied by Done
+//   - Remove: Client -> server, payload is the path of the file or the
+//     empty directory. Replied by Done
+//   - Mkdir: Client -> server, payload is the path of the directory.
+//     Replied by Done
+//   - Done: Both direction, see above
+//   - Close: Both direction. The client sends it to cancel the request,
+//     and the server sends it with the reason when the request has failed
+//
+// Every entry in List frames is encoded as:
+//
+//	+------+------------+-----------+------------+---------------+
+//	| Type | Permission | Size      | Modified   | Name          |
+//	+------+------------+-----------+------------+---------------+
+//	| 1 B  | 2 Bytes    | 8 Bytes   | 4 Bytes    | String        |
+//	+------+------------+-----------+------------+---------------+
+//
+// where the Type is one of the SSHFileTransferEntry* consts, and Modified is
+// the Unix time in seconds
+const (
+	SSHFileTransferList     = 0x00
+	SSHFileTransferDownload = 0x01
+	SSHFileTransferUpload   = 0x02
+	SSHFileTransferData     = 0x03
+	SSHFileTransferRename   = 0x04
+	SSHFileTransferRemove   = 0x05
+	SSHFileTransferMkdir    = 0x06
+	SSHFileTransferDone     = 0x07
+	SSHFileTransferClose    = 0x08
+)
+
+// Types of the entries in the List frames
+const (
+	SSHFileTransferEntryFile      = 0x00
+	SSHFileTransferEntryDirectory = 0x01
+	SSHFileTransferEntrySymlink   = 0x02
+	SSHFileTransferEntryOther     = 0x03
+)
+
+// Errors
+var (
+	ErrSSHFileTransferDisabled = errors.New(
+		"file transfer is disabled")
+
+	ErrSSHFileTransferInvalidFrame = errors.New(
+		"invalid file transfer frame")
+
+	ErrSSHFileTransferDuplicated = errors.New(
+		"file transfer request already exists")
+
+	ErrSSHFileTransferClosed = errors.New(
+		"connection is closing")
+)
+
+const (
+	sshFileTransferFrameHeaderSize = 3
+	sshFileTransferEntryHeaderSize = 15
+	sshFileTransferMaxPendingJobs  = 16
+)
+
+// sshFileTransferJob is a task which is executed by the worker of
+// sshFileTransfers
+type sshFileTransferJob struct {
+	id uint16
+
+	// silent jobs are not replied when the SFTP session cannot be started,
+	// as they're not requests on their own
+	silent bool
+
+	run func(c *sftp.Client, buf []byte)
+}
+
+// sshFileTransfers serves the file transfer requests of the client through
+// the SFTP subsystem of the SSH connection.
+//
+// The SFTP session is started when the first request is received. The
+// requests are executed by a single worker one after another, so the
+// Data frames of an upload are naturally slowed down to the speed of the
+// remote
+type sshFileTransfers struct {
+	enabled   bool
+	hLen      int
+	sender    func(marker byte, data []byte) error
+	opener    func() (*sftp.Client, io.Closer, error)
+	l         log.Logger
+	ctx       context.Context
+	cancel    func()
+	jobs      chan sshFileTransferJob
+	lock      sync.Mutex
+	session   io.Closer
+	cancelled map[uint16]bool
+	uploads   map[uint16]*sftp.File
+	wait      sync.WaitGroup
+}
+
+// newSSHFileTransfers creates a sshFileTransfers. The frames are sent
+// through the `sender`, which requires `hLen` bytes of headers in front of
+// the data just like command.StreamResponder.SendManual
+func newSSHFileTransfers(
+	client *ssh.Client,
+	enabled bool,
+	hLen int,
+	sender func(marker byte, data []byte) error,
+	l log.Logger,
+) *sshFileTransfers {
+	return newSSHFileTransfersWithOpener(enabled, hLen, sender, func() (
+		*sftp.Client, io.Closer, error,
+	) {
+		return openSSHFileTransferSession(client)
+	}, l)
+}
+
+func newSSHFileTransfersWithOpener(
+	enabled bool,
+	hLen int,
+	sender func(marker byte, data []byte) error,
+	opener func() (*sftp.Client, io.Closer, error),
+	l log.Logger,
+) *sshFileTransfers {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &sshFileTransfers{
+		enabled:   enabled,
+		hLen:      hLen,
+		sender:    sender,
+		opener:    opener,
+		l:         l,
+		ctx:       ctx,
+		cancel:    cancel,
+		jobs:      make(chan sshFileTransferJob, sshFileTransferMaxPendingJobs),
+		lock:      sync.Mutex{},
+		session:   nil,
+		cancelled: map[uint16]bool{},
+		uploads:   map[uint16]*sftp.File{},
+		wait:      sync.WaitGroup{},
+	}
+
+	if enabled {
+		s.wait.Add(1)
+
+		go s.work()
+	}
+
+	return s
+}
+
+// openSSHFileTransferSession starts the SFTP subsystem on the `client`
+func openSSHFileTransferSession(
+	client *ssh.Client) (*sftp.Client, io.Closer, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+
+		return nil, nil, err
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+
+		return nil, nil, err
+	}
+
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		session.Close()
+
+		return nil, nil, err
+	}
+
+	c, err := sftp.New(stdin, stdout)
+	if err != nil {
+		session.Close()
+
+		return nil, nil, err
+	}
+
+	return c, session, nil
+}
+
+// send sends a frame to the client. The payload must be placed into `buf`
+// after the headers, and `pLen` is the length of it
+func (s *sshFileTransfers) send(
+	frame byte, id uint16, buf []byte, pLen int) error {
+	buf[s.hLen] = SSHServerExtendedFileTransfer
+	buf[s.hLen+1] = frame
+	buf[s.hLen+2] = byte(id >> 8)
+	buf[s.hLen+3] = byte(id)
+
+	return s.sender(
+		SSHServerExtended,
+		buf[:s.hLen+1+sshFileTransferFrameHeaderSize+pLen])
+}
+
+// sendPayload sends a frame which carries `payload` to the client
+func (s *sshFileTransfers) sendPayload(
+	frame byte, id uint16, payload []byte) error {
+	buf := [4096]byte{}
+	pLen := copy(
+		buf[s.hLen+1+sshFileTransferFrameHeaderSize:], payload)
+
+	return s.send(frame, id, buf[:], pLen)
+}
+
+// sendClose tells the client the request `id` has failed because of `err`
+func (s *sshFileTransfers) sendClose(id uint16, err error) {
+	s.sendPayload(SSHFileTransferClose, id, []byte(err.Error()))
+}
+
+// handle handles a frame sent by the client
+func (s *sshFileTransfers) handle(frame []byte) error {
+	if len(frame) < sshFileTransferFrameHeaderSize {
+		return ErrSSHFileTransferInvalidFrame
+	}
+
+	id := uint16(frame[1])<<8 | uint16(frame[2])
+	payload := frame[sshFileTransferFrameHeaderSize:]
+
+	switch frame[0] {
+	case SSHFileTransferList:
+		path := string(payload)
+
+		return s.schedule(id, false, func(c *sftp.Client, buf []byte) {
+			s.list(c, id, path, buf)
+		})
+
+	case SSHFileTransferDownload:
+		path := string(payload)
+
+		return s.schedule(id, false, func(c *sftp.Client, buf []byte) {
+			s.download(c, id, path, buf)
+		})
+
+	case SSHFileTransferUpload:
+		path := string(payload)
+
+		return s.schedule(id, false, func(c *sftp.Client, buf []byte) {
+			s.upload(c, id, path)
+		})
+
+	case SSHFileTransferData:
+		// The payload is only valid until handle returns
+		data := append([]byte{}, payload...)
+
+		return s.schedule(id, true, func(c *sftp.Client, buf []byte) {
+			s.write(id, data)
+		})
+
+	case SSHFileTransferDone:
+		return s.schedule(id, true, func(c *sftp.Client, buf []byte) {
+			s.finish(id)
+		})
+
+	case SSHFileTransferRename:
+		paths := bytes.SplitN(payload, []byte{0}, 2)
+		if len(paths) != 2 {
+			return ErrSSHFileTransferInvalidFrame
+		}
+
+		from, to := string(paths[0]), string(paths[1])
+
+		return s.schedule(id, false, func(c *sftp.Client, buf []byte) {
+			s.done(id, c.Rename(from, to))
+		})
+
+	case SSHFileTransferRemove:
+		path := string(payload)
+
+		return s.schedule(id, false, func(c *sftp.Client, buf []byte) {
+			s.done(id, s.remove(c, path))
+		})
+
+	case SSHFileTransferMkdir:
+		path := string(payload)
+
+		return s.schedule(id, false, func(c *sftp.Client, buf []byte) {
+			s.done(id, c.Mkdir(path))
+		})
+
+	case SSHFileTransferClose:
+		if !s.enabled {
+			return nil
+		}
+
+		// Stops the ongoing download right away, the mark is then cleared
+		// by the scheduled job once the worker reaches it
+		s.lock.Lock()
+		s.cancelled[id] = true
+		s.lock.Unlock()
+
+		return s.schedule(id, true, func(c *sftp.Client, buf []byte) {
+			s.abort(c, id)
+		})
+
+	default:
+		return ErrSSHFileTransferInvalidFrame
+	}
+}
+
+// schedule queues the job `run` of the request `id` for the worker. It
+// blocks when there are too many pending jobs
+func (s *sshFileTransfers) schedule(
+	id uint16, silent bool, run func(c *sftp.Client, buf []byte)) error {
+	if !s.enabled {
+		if !silent {
+			s.sendClose(id, ErrSSHFileTransferDisabled)
+		}
+
+		return nil
+	}
+
+	job := sshFileTransferJob{
+		id:     id,
+		silent: silent,
+		run:    run,
+	}
+
+	select {
+	case s.jobs <- job:
+		return nil
+
+	case <-s.ctx.Done():
+		return ErrSSHFileTransferClosed
+	}
+}
+
+// work executes the scheduled jobs until the sshFileTransfers is closed
+func (s *sshFileTransfers) work() {
+	defer s.wait.Done()
+
+	var c *sftp.Client
+	var openErr error
+
+	buf := [4096]byte{}
+
+	for {
+		select {
+		case job := <-s.jobs:
+			if c == nil && openErr == nil {
+				c, openErr = s.open()
+			}
+
+			if openErr != nil {
+				// Every request will fail the same way, so the jobs are
+				// dropped and the requests are failed right away
+				if !job.silent {
+					s.sendClose(job.id, openErr)
+				}
+
+				continue
+			}
+
+			job.run(c, buf[:])
+
+		case <-s.ctx.Done():
+			for id, f := range s.uploads {
+				f.Close()
+
+				delete(s.uploads, id)
+			}
+
+			return
+		}
+	}
+}
+
+// open starts the SFTP session
+func (s *sshFileTransfers) open() (*sftp.Client, error) {
+	c, session, err := s.opener()
+	if err != nil {
+		s.l.Debug("Unable to start SFTP session: %s", err)
+
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.ctx.Err() != nil {
+		session.Close()
+
+		return nil, ErrSSHFileTransferClosed
+	}
+
+	s.session = session
+
+	return c, nil
+}
+
+// isCancelled returns whether or not the request `id` has been cancelled
+func (s *sshFileTransfers) isCancelled(id uint16) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.cancelled[id]
+}
+
+// done replies Done to the request `id` if `err` is nil, or Close otherwise
+func (s *sshFileTransfers) done(id uint16, err error) {
+	if err != nil {
+		s.sendClose(id, err)
+
+		return
+	}
+
+	s.sendPayload(SSHFileTransferDone, id, nil)
+}
+
+// list sends the entries of the directory `path` to the client
+func (s *sshFileTransfers) list(
+	c *sftp.Client, id uint16, path string, buf []byte) {
+	if len(path) == 0 {
+		path = "."
+	}
+
+	path, err := c.RealPath(path)
+	if err != nil {
+		s.sendClose(id, err)
+
+		return
+	}
+
+	entries, err := c.ReadDir(path)
+	if err != nil {
+		s.sendClose(id, err)
+
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir() != entries[j].IsDir() {
+			return entries[i].IsDir()
+		}
+
+		return entries[i].Name < entries[j].Name
+	})
+
+	pStart := s.hLen + 1 + sshFileTransferFrameHeaderSize
+	pLen := 0
+
+	for _, entry := range entries {
+		eLen, ok := encodeSSHFileTransferEntry(buf[pStart+pLen:], entry)
+		if ok {
+			pLen += eLen
+
+			continue
+		}
+
+		if pLen == 0 {
+			// The entry cannot fit into an empty frame, skip it
+			continue
+		}
+
+		if s.send(SSHFileTransferList, id, buf, pLen) != nil {
+			return
+		}
+
+		pLen, ok = encodeSSHFileTransferEntry(buf[pStart:], entry)
+		if !ok {
+			pLen = 0
+		}
+	}
+
+	if pLen > 0 {
+		if s.send(SSHFileTransferList, id, buf, pLen) != nil {
+			return
+		}
+	}
+
+	s.sendPayload(SSHFileTransferDone, id, []byte(path))
+}
+
+// download sends the file at `path` to the client
+func (s *sshFileTransfers) download(
+	c *sftp.Client, id uint16, path string, buf []byte) {
+	info, err := c.Stat(path)
+	if err != nil {
+		s.sendClose(id, err)
+
+		return
+	}
+
+	f, err := c.Open(path)
+	if err != nil {
+		s.sendClose(id, err)
+
+		return
+	}
+	defer f.Close()
+
+	pStart := s.hLen + 1 + sshFileTransferFrameHeaderSize
+
+	binary.BigEndian.PutUint64(buf[pStart:], info.Size)
+
+	if s.send(SSHFileTransferDownload, id, buf, 8) != nil {
+		return
+	}
+
+	for !s.isCancelled(id) {
+		rLen, rErr := f.Read(buf[pStart:])
+		if rErr == io.EOF {
+			s.sendPayload(SSHFileTransferDone, id, nil)
+
+			return
+		} else if rErr != nil {
+			s.sendClose(id, rErr)
+
+			return
+		}
+
+		if s.send(SSHFileTransferData, id, buf, rLen) != nil {
+			return
+		}
+	}
+}
+
+// upload creates the file at `path` for the following Data frames
+func (s *sshFileTransfers) upload(c *sftp.Client, id uint16, path string) {
+	if _, ok := s.uploads[id]; ok {
+		s.sendClose(id, ErrSSHFileTransferDuplicated)
+
+		return
+	}
+
+	f, err := c.Create(path)
+	if err != nil {
+		s.sendClose(id, err)
+
+		return
+	}
+
+	s.uploads[id] = f
+
+	s.sendPayload(SSHFileTransferUpload, id, nil)
+}
+
+// write writes the `data` to the file being uploaded by the request `id`
+func (s *sshFileTransfers) write(id uint16, data []byte) {
+	f, ok := s.uploads[id]
+	if !ok {
+		// The upload could be failed before the client knows it
+		return
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		delete(s.uploads, id)
+
+		s.sendClose(id, err)
+	}
+}
+
+// finish completes the upload of the request `id`
+func (s *sshFileTransfers) finish(id uint16) {
+	f, ok := s.uploads[id]
+	if !ok {
+		return
+	}
+
+	delete(s.uploads, id)
+
+	s.done(id, f.Close())
+}
+
+// remove removes the file or the empty directory at `path`
+func (s *sshFileTransfers) remove(c *sftp.Client, path string) error {
+	err := c.Remove(path)
+	if err == nil {
+		return nil
+	}
+
+	if c.RemoveDirectory(path) == nil {
+		return nil
+	}
+
+	return err
+}
+
+// abort cancels the request `id`. The incomplete upload is closed but not
+// removed, as the client may want to inspect or resume it
+func (s *sshFileTransfers) abort(c *sftp.Client, id uint16) {
+	s.lock.Lock()
+	delete(s.cancelled, id)
+	s.lock.Unlock()
+
+	f, ok := s.uploads[id]
+	if !ok {
+		return
+	}
+
+	delete(s.uploads, id)
+	f.Close()
+}
+
+// close stops the worker and ends the SFTP session
+func (s *sshFileTransfers) close() {
+	s.lock.Lock()
+	s.cancel()
+	if s.session != nil {
+		s.session.Close()
+	}
+	s.lock.Unlock()
+
+	s.wait.Wait()
+}
+
+// encodeSSHFileTransferEntry writes the `entry` into `b`. It returns false
+// when there is not enough space in `b`
+func encodeSSHFileTransferEntry(b []byte, entry sftp.FileInfo) (int, bool) {
+	name := []byte(entry.Name)
+
+	if len(name) > MaxInteger {
+		return 0, false
+	}
+
+	if len(b) < sshFileTransferEntryHeaderSize {
+		return 0, false
+	}
+
+	switch {
+	case entry.IsDir():
+		b[0] = SSHFileTransferEntryDirectory
+
+	case entry.Mode&os.ModeSymlink != 0:
+		b[0] = SSHFileTransferEntrySymlink
+
+	case entry.Mode.IsRegular():
+		b[0] = SSHFileTransferEntryFile
+
+	default:
+		b[0] = SSHFileTransferEntryOther
+	}
+
+	modTime := int64(0)
+
+	if !entry.ModTime.IsZero() {
+		modTime = entry.ModTime.Unix()
+	}
+
+	binary.BigEndian.PutUint16(b[1:3], uint16(entry.Mode.Perm()))
+	binary.BigEndian.PutUint64(b[3:11], entry.Size)
+	binary.BigEndian.PutUint32(b[11:15], uint32(modTime))
+
+	nLen, err := NewString(name).Marshal(b[sshFileTransferEntryHeaderSize:])
+	if err != nil {
+		return 0, false
+	}
+
+	return sshFileTransferEntryHeaderSize + nLen, true
+}
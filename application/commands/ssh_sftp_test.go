@@ -0,0 +1,132 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/sftp"
+)
+
+func TestSSHFileTransfers(t *testing.T) {
+	const hLen = 3
+
+	frames := make(chan []byte, 16)
+	sender := func(marker byte, data []byte) error {
+		if marker != SSHServerExtended ||
+			data[hLen] != SSHServerExtendedFileTransfer {
+			t.Errorf("Unexpected signal %d", marker)
+		}
+
+		frames <- append([]byte{}, data[hLen+1:]...)
+
+		return nil
+	}
+
+	expect := func(frame byte, id uint16, payload string) {
+		select {
+		case f := <-frames:
+			if f[0] != frame || uint16(f[1])<<8|uint16(f[2]) != id ||
+				string(f[3:]) != payload {
+				t.Errorf("Expecting frame %d of %d with %q, got %v",
+					frame, id, payload, f)
+			}
+
+		case <-time.After(5 * time.Second):
+			t.Errorf("Expecting frame %d of %d", frame, id)
+		}
+	}
+
+	disabled := newSSHFileTransfersWithOpener(
+		false, hLen, sender, nil, log.NewDitch())
+
+	disabled.handle(append([]byte{SSHFileTransferList, 0x00, 0x01}, "/"...))
+	expect(SSHFileTransferClose, 1, ErrSSHFileTransferDisabled.Error())
+	disabled.close()
+
+	opens := 0
+	openErr := errors.New("subsystem request failed")
+
+	files := newSSHFileTransfersWithOpener(true, hLen, sender, func() (
+		*sftp.Client, io.Closer, error,
+	) {
+		opens++
+
+		return nil, nil, openErr
+	}, log.NewDitch())
+	defer files.close()
+
+	if err := files.handle([]byte{0xff, 0x00, 0x01}); err == nil {
+		t.Error("Expecting unknown frame to be rejected")
+		return
+	}
+
+	if err := files.handle(
+		append([]byte{SSHFileTransferRename, 0x00, 0x01}, "a"...)); err == nil {
+		t.Error("Expecting rename without the new path to be rejected")
+		return
+	}
+
+	files.handle(append([]byte{SSHFileTransferList, 0x01, 0x02}, "/"...))
+	expect(SSHFileTransferClose, 0x0102, openErr.Error())
+
+	// Data frames are not replied on their own
+	files.handle(append([]byte{SSHFileTransferData, 0x01, 0x02}, "Hi"...))
+
+	files.handle(append([]byte{SSHFileTransferMkdir, 0x01, 0x03}, "/a"...))
+	expect(SSHFileTransferClose, 0x0103, openErr.Error())
+
+	if opens != 1 {
+		t.Errorf("Expecting the session to be opened once, got %d", opens)
+	}
+}
+
+func TestEncodeSSHFileTransferEntry(t *testing.T) {
+	b := make([]byte, 32)
+
+	eLen, ok := encodeSSHFileTransferEntry(b, sftp.FileInfo{
+		Name:    "docs",
+		Size:    4096,
+		Mode:    os.ModeDir | 0755,
+		ModTime: time.Unix(1700000000, 0),
+	})
+	if !ok || eLen != sshFileTransferEntryHeaderSize+5 {
+		t.Errorf("Unexpected encoded length %d", eLen)
+		return
+	}
+
+	if b[0] != SSHFileTransferEntryDirectory ||
+		binary.BigEndian.Uint16(b[1:3]) != 0755 ||
+		binary.BigEndian.Uint64(b[3:11]) != 4096 ||
+		binary.BigEndian.Uint32(b[11:15]) != 1700000000 ||
+		b[15] != 4 || string(b[16:20]) != "docs" {
+		t.Errorf("Unexpected encoded entry %v", b[:eLen])
+	}
+
+	if _, ok := encodeSSHFileTransferEntry(b[:18], sftp.FileInfo{
+		Name: "docs",
+	}); ok {
+		t.Error("Expecting the entry to not fit into the buffer")
+	}
+}
@@ -0,0 +1,403 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+// SSH transport consts used to inspect the plaintext part of the handshake
+const (
+	sshTransportMaxVersionLines = 16
+	sshTransportMaxLineLen      = 255
+	sshTransportMaxPacketLen    = 35000
+	sshTransportMsgKexInit      = 20
+	sshTransportKexInitCookie   = 16
+)
+
+// Errors
+var (
+	errSSHTransportMalformed = errors.New(
+		"malformed SSH transport data")
+)
+
+// sshKexInit contains the algorithm lists sent in a SSH_MSG_KEXINIT message
+type sshKexInit struct {
+	KeyExchanges        []string
+	HostKeys            []string
+	CiphersClientServer []string
+	CiphersServerClient []string
+	MACsClientServer    []string
+	MACsServerClient    []string
+}
+
+// parseSSHKexInit parses the payload of a SSH_MSG_KEXINIT message
+func parseSSHKexInit(payload []byte) (sshKexInit, error) {
+	if len(payload) < 1+sshTransportKexInitCookie ||
+		payload[0] != sshTransportMsgKexInit {
+		return sshKexInit{}, errSSHTransportMalformed
+	}
+
+	payload = payload[1+sshTransportKexInitCookie:]
+	lists := [6][]string{}
+
+	for i := range lists {
+		if len(payload) < 4 {
+			return sshKexInit{}, errSSHTransportMalformed
+		}
+
+		lLen := binary.BigEndian.Uint32(payload)
+		payload = payload[4:]
+
+		if uint32(len(payload)) < lLen {
+			return sshKexInit{}, errSSHTransportMalformed
+		}
+
+		if lLen > 0 {
+			lists[i] = strings.Split(string(payload[:lLen]), ",")
+		}
+
+		payload = payload[lLen:]
+	}
+
+	return sshKexInit{
+		KeyExchanges:        lists[0],
+		HostKeys:            lists[1],
+		CiphersClientServer: lists[2],
+		CiphersServerClient: lists[3],
+		MACsClientServer:    lists[4],
+		MACsServerClient:    lists[5],
+	}, nil
+}
+
+// sshNegotiatedAlgorithms are the algorithms agreed by both sides of a SSH
+// connection
+type sshNegotiatedAlgorithms struct {
+	KeyExchange        string `json:"kex"`
+	HostKey            string `json:"host_key"`
+	CipherClientServer string `json:"cipher_client_server"`
+	CipherServerClient string `json:"cipher_server_client"`
+	MACClientServer    string `json:"mac_client_server"`
+	MACServerClient    string `json:"mac_server_client"`
+}
+
+// sshTransportInfo describes the transport of an established SSH connection
+type sshTransportInfo struct {
+	ClientVersion string `json:"client_version"`
+	ServerVersion string `json:"server_version"`
+
+	sshNegotiatedAlgorithms
+}
+
+// details returns the sshTransportInfo as journal details
+func (i sshTransportInfo) details() map[string]string {
+	return map[string]string{
+		"client_version":       i.ClientVersion,
+		"server_version":       i.ServerVersion,
+		"kex":                  i.KeyExchange,
+		"host_key":             i.HostKey,
+		"cipher_client_server": i.CipherClientServer,
+		"cipher_server_client": i.CipherServerClient,
+		"mac_client_server":    i.MACClientServer,
+		"mac_server_client":    i.MACServerClient,
+	}
+}
+
+// weaknesses returns the warnings about the parts of the transport which
+// don't fit the known-good profile described by the `policy`
+func (i sshTransportInfo) weaknesses(
+	policy configuration.SSHServerPolicy,
+) []string {
+	warnings := []string{}
+
+	if policy.WeakServerVersion(i.ServerVersion) {
+		warnings = append(warnings, fmt.Sprintf(
+			"Outdated server version %q", i.ServerVersion))
+	}
+
+	for _, a := range []struct {
+		name  string
+		value string
+	}{
+		{"key exchange", i.KeyExchange},
+		{"host key", i.HostKey},
+		{"cipher", i.CipherClientServer},
+		{"cipher", i.CipherServerClient},
+		{"MAC", i.MACClientServer},
+		{"MAC", i.MACServerClient},
+	} {
+		if !policy.WeakAlgorithm(a.value) {
+			continue
+		}
+
+		w := fmt.Sprintf("Weak %s algorithm %q", a.name, a.value)
+		if len(warnings) > 0 && warnings[len(warnings)-1] == w {
+			continue
+		}
+
+		warnings = append(warnings, w)
+	}
+
+	return warnings
+}
+
+// sshNegotiateAlgorithm returns the first algorithm of the client which is
+// also supported by the server, as it's the way SSH negotiates algorithms
+func sshNegotiateAlgorithm(client []string, server []string) string {
+	for _, c := range client {
+		for _, s := range server {
+			if c == s {
+				return c
+			}
+		}
+	}
+
+	return ""
+}
+
+// sshCipherHasMAC returns whether or not the cipher relies on a separate MAC
+// algorithm. AEAD ciphers authenticate the data themselves
+func sshCipherHasMAC(cipher string) bool {
+	switch cipher {
+	case "aes128-gcm@openssh.com",
+		"aes256-gcm@openssh.com",
+		"chacha20-poly1305@openssh.com":
+		return false
+	}
+
+	return true
+}
+
+// negotiateSSHAlgorithms returns the algorithms negotiated between `client`
+// and `server`
+func negotiateSSHAlgorithms(
+	client sshKexInit,
+	server sshKexInit,
+) sshNegotiatedAlgorithms {
+	n := sshNegotiatedAlgorithms{
+		KeyExchange: sshNegotiateAlgorithm(
+			client.KeyExchanges, server.KeyExchanges),
+		HostKey: sshNegotiateAlgorithm(
+			client.HostKeys, server.HostKeys),
+		CipherClientServer: sshNegotiateAlgorithm(
+			client.CiphersClientServer, server.CiphersClientServer),
+		CipherServerClient: sshNegotiateAlgorithm(
+			client.CiphersServerClient, server.CiphersServerClient),
+	}
+
+	if sshCipherHasMAC(n.CipherClientServer) {
+		n.MACClientServer = sshNegotiateAlgorithm(
+			client.MACsClientServer, server.MACsClientServer)
+	}
+
+	if sshCipherHasMAC(n.CipherServerClient) {
+		n.MACServerClient = sshNegotiateAlgorithm(
+			client.MACsServerClient, server.MACsServerClient)
+	}
+
+	return n
+}
+
+// sshTransportSniffer inspects the data sent by one side of a SSH connection
+// until it has seen the version string and the first SSH_MSG_KEXINIT, which
+// are the only parts of the handshake that are not encrypted
+type sshTransportSniffer struct {
+	buf     []byte
+	lines   int
+	version string
+	kexInit *sshKexInit
+	failed  bool
+}
+
+// done returns whether or not the sniffer needs no more data
+func (s *sshTransportSniffer) done() bool {
+	return s.failed || s.kexInit != nil
+}
+
+// feed inspects the given data
+func (s *sshTransportSniffer) feed(b []byte) {
+	if s.done() {
+		return
+	}
+
+	s.buf = append(s.buf, b...)
+
+	for len(s.version) <= 0 {
+		lineEnd := bytes.IndexByte(s.buf, '\n')
+		if lineEnd < 0 {
+			if len(s.buf) > sshTransportMaxLineLen {
+				s.fail()
+			}
+
+			return
+		}
+
+		line := strings.TrimRight(string(s.buf[:lineEnd]), "\r")
+		s.buf = s.buf[lineEnd+1:]
+		s.lines++
+
+		if strings.HasPrefix(line, "SSH-") {
+			s.version = line
+		} else if s.lines >= sshTransportMaxVersionLines {
+			s.fail()
+
+			return
+		}
+	}
+
+	if len(s.buf) < 5 {
+		return
+	}
+
+	pLen := binary.BigEndian.Uint32(s.buf)
+	if pLen < 1 || pLen > sshTransportMaxPacketLen {
+		s.fail()
+
+		return
+	}
+
+	if uint32(len(s.buf)-4) < pLen {
+		return
+	}
+
+	padLen := uint32(s.buf[4])
+	if padLen+1 > pLen {
+		s.fail()
+
+		return
+	}
+
+	kexInit, kexInitErr := parseSSHKexInit(s.buf[5 : 4+pLen-padLen])
+	if kexInitErr != nil {
+		s.fail()
+
+		return
+	}
+
+	s.kexInit = &kexInit
+	s.buf = nil
+}
+
+func (s *sshTransportSniffer) fail() {
+	s.failed = true
+	s.buf = nil
+}
+
+// sshTransportCapture captures the version strings and the algorithm
+// negotiation of a SSH connection
+type sshTransportCapture struct {
+	lock   sync.Mutex
+	client sshTransportSniffer
+	server sshTransportSniffer
+}
+
+// newSSHTransportCapture creates a new sshTransportCapture
+func newSSHTransportCapture() *sshTransportCapture {
+	return &sshTransportCapture{
+		lock:   sync.Mutex{},
+		client: sshTransportSniffer{},
+		server: sshTransportSniffer{},
+	}
+}
+
+// wrap returns a net.Conn which feeds the data that went through `conn` to
+// the capture
+func (c *sshTransportCapture) wrap(conn net.Conn) net.Conn {
+	return &sshTransportCaptureConn{
+		Conn:    conn,
+		capture: c,
+	}
+}
+
+func (c *sshTransportCapture) feed(s *sshTransportSniffer, b []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s.feed(b)
+}
+
+// versions returns the version strings of the client and the server
+func (c *sshTransportCapture) versions() (client string, server string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.client.version, c.server.version
+}
+
+// negotiated returns the negotiated algorithms. `ok` is false if the
+// algorithm lists of both sides have not been captured
+func (c *sshTransportCapture) negotiated() (
+	n sshNegotiatedAlgorithms, ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.client.kexInit == nil || c.server.kexInit == nil {
+		return sshNegotiatedAlgorithms{}, false
+	}
+
+	return negotiateSSHAlgorithms(*c.client.kexInit, *c.server.kexInit), true
+}
+
+// info returns the sshTransportInfo of the captured handshake. `ok` is false
+// if the algorithm lists of both sides have not been captured
+func (c *sshTransportCapture) info() (i sshTransportInfo, ok bool) {
+	n, ok := c.negotiated()
+	if !ok {
+		return sshTransportInfo{}, false
+	}
+
+	clientVersion, serverVersion := c.versions()
+
+	return sshTransportInfo{
+		ClientVersion:           clientVersion,
+		ServerVersion:           serverVersion,
+		sshNegotiatedAlgorithms: n,
+	}, true
+}
+
+type sshTransportCaptureConn struct {
+	net.Conn
+
+	capture *sshTransportCapture
+}
+
+func (s *sshTransportCaptureConn) Read(b []byte) (int, error) {
+	rLen, rErr := s.Conn.Read(b)
+	if rLen > 0 {
+		s.capture.feed(&s.capture.server, b[:rLen])
+	}
+
+	return rLen, rErr
+}
+
+func (s *sshTransportCaptureConn) Write(b []byte) (int, error) {
+	wLen, wErr := s.Conn.Write(b)
+	if wLen > 0 {
+		s.capture.feed(&s.capture.client, b[:wLen])
+	}
+
+	return wLen, wErr
+}
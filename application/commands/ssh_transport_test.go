@@ -0,0 +1,232 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+func TestSSHTransportCapture(t *testing.T) {
+	_, priv, kErr := ed25519.GenerateKey(rand.Reader)
+	if kErr != nil {
+		t.Error("Failed to generate key:", kErr)
+		return
+	}
+
+	signer, sErr := ssh.NewSignerFromKey(priv)
+	if sErr != nil {
+		t.Error("Failed to create signer:", sErr)
+		return
+	}
+
+	listener, lErr := net.Listen("tcp", "127.0.0.1:0")
+	if lErr != nil {
+		t.Error("Failed to listen:", lErr)
+		return
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, aErr := listener.Accept()
+		if aErr != nil {
+			return
+		}
+		defer conn.Close()
+
+		serverCfg := &ssh.ServerConfig{
+			NoClientAuth:  true,
+			ServerVersion: "SSH-2.0-Test_1.0",
+		}
+		serverCfg.Ciphers = []string{"aes128-ctr"}
+		serverCfg.AddHostKey(signer)
+
+		sConn, chans, reqs, hErr := ssh.NewServerConn(conn, serverCfg)
+		if hErr != nil {
+			return
+		}
+		defer sConn.Close()
+
+		go ssh.DiscardRequests(reqs)
+
+		for range chans {
+		}
+	}()
+
+	conn, dErr := net.Dial("tcp", listener.Addr().String())
+	if dErr != nil {
+		t.Error("Failed to dial:", dErr)
+		return
+	}
+
+	capture := newSSHTransportCapture()
+
+	c, chans, reqs, cErr := ssh.NewClientConn(
+		capture.wrap(conn), "test", &ssh.ClientConfig{
+			User:            "test",
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+	if cErr != nil {
+		t.Error("Failed to connect:", cErr)
+		return
+	}
+	defer ssh.NewClient(c, chans, reqs).Close()
+
+	clientVersion, serverVersion := capture.versions()
+	if !strings.HasPrefix(clientVersion, "SSH-2.0-") {
+		t.Errorf("Unexpected client version %q", clientVersion)
+		return
+	}
+
+	if serverVersion != "SSH-2.0-Test_1.0" {
+		t.Errorf("Unexpected server version %q", serverVersion)
+		return
+	}
+
+	n, ok := capture.negotiated()
+	if !ok {
+		t.Error("Expecting the negotiation to be captured")
+		return
+	}
+
+	if len(n.KeyExchange) <= 0 {
+		t.Errorf("Unexpected key exchange %q", n.KeyExchange)
+		return
+	}
+
+	if n.HostKey != ssh.KeyAlgoED25519 {
+		t.Errorf("Expecting host key %q, got %q",
+			ssh.KeyAlgoED25519, n.HostKey)
+		return
+	}
+
+	if n.CipherClientServer != "aes128-ctr" ||
+		n.CipherServerClient != "aes128-ctr" {
+		t.Errorf("Expecting cipher %q, got %q/%q", "aes128-ctr",
+			n.CipherClientServer, n.CipherServerClient)
+		return
+	}
+
+	if len(n.MACClientServer) <= 0 || len(n.MACServerClient) <= 0 {
+		t.Errorf("Expecting MACs to be negotiated, got %q/%q",
+			n.MACClientServer, n.MACServerClient)
+		return
+	}
+	info, ok := capture.info()
+	if !ok {
+		t.Error("Expecting the transport info to be available")
+		return
+	}
+
+	infoData, mErr := json.Marshal(info)
+	if mErr != nil {
+		t.Error("Failed to marshal transport info:", mErr)
+		return
+	}
+
+	infoStr := string(infoData)
+	if !strings.Contains(infoStr, `"server_version":"SSH-2.0-Test_1.0"`) ||
+		!strings.Contains(infoStr, `"cipher_client_server":"aes128-ctr"`) {
+		t.Errorf("Unexpected transport info %s", infoData)
+		return
+	}
+}
+
+func TestSSHTransportSnifferMalformed(t *testing.T) {
+	s := sshTransportSniffer{}
+
+	s.feed([]byte("SSH-2.0-Test\r\n"))
+	s.feed([]byte{0xff, 0xff, 0xff, 0xff, 0x04})
+
+	if !s.done() || s.kexInit != nil {
+		t.Error("Expecting the sniffer to give up on malformed packet")
+		return
+	}
+
+	if s.version != "SSH-2.0-Test" {
+		t.Errorf("Unexpected version %q", s.version)
+		return
+	}
+}
+
+func TestSSHNegotiatedAlgorithmsAEAD(t *testing.T) {
+	k := sshKexInit{
+		KeyExchanges:        []string{"curve25519-sha256"},
+		HostKeys:            []string{"ssh-ed25519"},
+		CiphersClientServer: []string{"chacha20-poly1305@openssh.com"},
+		CiphersServerClient: []string{"chacha20-poly1305@openssh.com"},
+		MACsClientServer:    []string{"hmac-sha2-256"},
+		MACsServerClient:    []string{"hmac-sha2-256"},
+	}
+
+	n := negotiateSSHAlgorithms(k, k)
+	if n.MACClientServer != "" || n.MACServerClient != "" {
+		t.Errorf("Expecting no MAC for AEAD cipher, got %q/%q",
+			n.MACClientServer, n.MACServerClient)
+		return
+	}
+}
+
+func TestSSHTransportInfoWeaknesses(t *testing.T) {
+	info := sshTransportInfo{
+		ServerVersion: "SSH-2.0-OpenSSH_5.3",
+		sshNegotiatedAlgorithms: sshNegotiatedAlgorithms{
+			KeyExchange:        "diffie-hellman-group1-sha1",
+			HostKey:            "ssh-rsa",
+			CipherClientServer: "aes128-cbc",
+			CipherServerClient: "aes128-cbc",
+			MACClientServer:    "hmac-sha2-256",
+			MACServerClient:    "hmac-sha2-256",
+		},
+	}
+
+	policy := configuration.SSHServerPolicy{}.WithDefault()
+
+	w := info.weaknesses(policy)
+	if len(w) != 4 {
+		t.Errorf("Expecting 4 warnings, got %d: %q", len(w), w)
+		return
+	}
+
+	info.ServerVersion = "SSH-2.0-OpenSSH_9.6"
+	info.KeyExchange = "curve25519-sha256"
+	info.HostKey = "ssh-ed25519"
+	info.CipherClientServer = "chacha20-poly1305@openssh.com"
+	info.CipherServerClient = "chacha20-poly1305@openssh.com"
+
+	if w := info.weaknesses(policy); len(w) != 0 {
+		t.Errorf("Expecting no warning, got %q", w)
+		return
+	}
+
+	policy.Disabled = true
+	info.HostKey = "ssh-rsa"
+
+	if w := info.weaknesses(policy); len(w) != 0 {
+		t.Errorf("Expecting no warning from a disabled policy, got %q", w)
+		return
+	}
+}
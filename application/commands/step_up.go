@@ -0,0 +1,175 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/rw"
+	"github.com/nirui/sshwifty/application/stepup"
+)
+
+// Step-up authentication methods, as they're sent to the client
+const (
+	StepUpMethodPassword = 0x00
+	StepUpMethodTOTP     = 0x01
+)
+
+const (
+	stepUpAnswerMaxSize = 256
+)
+
+// Errors
+var (
+	ErrStepUpCancelled = errors.New(
+		"step-up authentication has been cancelled")
+
+	ErrStepUpTimeout = errors.New(
+		"step-up authentication has timed out")
+
+	ErrStepUpAnswerTooLarge = errors.New(
+		"step-up authentication answer is too large")
+
+	ErrStepUpUnexpectedAnswer = errors.New(
+		"unexpected step-up authentication answer")
+)
+
+// stepUpMethodCode returns the code of the `method` that is sent to the
+// client
+func stepUpMethodCode(method stepup.Method) byte {
+	switch method {
+	case stepup.METHOD_TOTP:
+		return StepUpMethodTOTP
+
+	default:
+		return StepUpMethodPassword
+	}
+}
+
+// stepUpPrompt asks the user to answer the step-up authentication of the
+// method `code`. `received` is false when the client is gone before the
+// user answers
+type stepUpPrompt func(code byte) (answer []byte, received bool, err error)
+
+// stepUp asks the user to authenticate again through `prompt` when it's
+// required by the step-up rules for connecting to the remote described by
+// the `params`, or by the `forced` method (i.e. the one required by the
+// risk score, empty if none), and returns nil once the user passed it
+func stepUp(
+	ctx context.Context,
+	cfg command.Configuration,
+	params command.HookParameters,
+	forced stepup.Method,
+	prompt stepUpPrompt,
+	l log.Logger,
+) error {
+	method, required := cfg.StepUp.Required(ctx, params)
+	if !required {
+		if len(forced) <= 0 {
+			return nil
+		}
+
+		method = forced
+	}
+
+	l.Debug("Step-up authentication %q is required", method)
+
+	answer, received, err := prompt(stepUpMethodCode(method))
+	if err != nil {
+		return err
+	}
+	if !received {
+		return ErrStepUpCancelled
+	}
+
+	err = cfg.StepUp.Verify(method, cfg.User, answer)
+	if err != nil {
+		l.Info("Step-up authentication %q has failed: %s", method, err)
+
+		return err
+	}
+
+	return nil
+}
+
+// waitStepUp sends the step-up request through `request`, then waits for
+// the user to answer through `prompt` until the `timeout` (0 to wait
+// indefinitely) or the `ctx` is done
+func waitStepUp(
+	ctx context.Context,
+	timeout time.Duration,
+	request func() error,
+	prompt *sshPrompt[[]byte],
+) (answer []byte, received bool, err error) {
+	prompt.expect()
+	defer prompt.stop()
+
+	err = request()
+	if err != nil {
+		return
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+
+		expired = t.C
+	}
+
+	select {
+	case answer = <-prompt.received():
+		received = true
+
+	case <-ctx.Done():
+
+	case <-expired:
+		err = ErrStepUpTimeout
+	}
+
+	return
+}
+
+// readStepUpAnswer reads the step-up answer from `r` and delivers it to the
+// `prompt`
+func readStepUpAnswer(r *rw.LimitedReader, prompt *sshPrompt[[]byte]) error {
+	if r.Remains() > stepUpAnswerMaxSize {
+		return ErrStepUpAnswerTooLarge
+	}
+
+	answer := make([]byte, 0, r.Remains())
+
+	for !r.Completed() {
+		rData, rErr := r.Buffered()
+		if rErr != nil {
+			return rErr
+		}
+
+		answer = append(answer, rData...)
+	}
+
+	if !prompt.deliver(answer) {
+		return ErrStepUpUnexpectedAnswer
+	}
+
+	return nil
+}
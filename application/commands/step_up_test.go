@@ -0,0 +1,116 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/stepup"
+)
+
+func testStepUpConfiguration() command.Configuration {
+	return command.Configuration{
+		StepUp: command.NewStepUp([]configuration.StepUpRule{
+			{
+				When: configuration.HookCondition{
+					PresetTags: []string{"prod"},
+				},
+				Method: stepup.METHOD_PASSWORD,
+			},
+		}, stepup.NewVerifier("secret", nil)),
+		Presets: []configuration.Preset{
+			{Type: "SSH", Host: "prod:22", Tags: []string{"prod"}},
+			{Type: "SSH", Host: "dev:22", Tags: []string{"dev"}},
+		},
+	}
+}
+
+func TestStepUp(t *testing.T) {
+	cfg := testStepUpConfiguration()
+	l := log.NewDitch()
+
+	answer := func(a string) stepUpPrompt {
+		return func(code byte) ([]byte, bool, error) {
+			if code != StepUpMethodPassword {
+				t.Errorf("Expecting method %d, got %d",
+					StepUpMethodPassword, code)
+			}
+			return []byte(a), true, nil
+		}
+	}
+
+	prod := command.NewRemoteHookParameters(cfg, 0, "SSH", "prod:22")
+	dev := command.NewRemoteHookParameters(cfg, 0, "SSH", "dev:22")
+
+	err := stepUp(context.Background(), cfg, prod, "", answer("secret"), l)
+	if err != nil {
+		t.Error("Expecting the step-up to pass, got:", err)
+	}
+
+	err = stepUp(context.Background(), cfg, prod, "", answer("wrong"), l)
+	if !errors.Is(err, stepup.ErrRejected) {
+		t.Errorf("Expecting %q, got %v", stepup.ErrRejected, err)
+	}
+
+	err = stepUp(context.Background(), cfg, dev, "", func(
+		code byte,
+	) ([]byte, bool, error) {
+		t.Error("Step-up must not be required by remotes without the tag")
+		return nil, false, nil
+	}, l)
+	if err != nil {
+		t.Error("Expecting no step-up, got:", err)
+	}
+
+	err = stepUp(context.Background(), cfg, dev, stepup.METHOD_PASSWORD,
+		answer("secret"), l)
+	if err != nil {
+		t.Error("Expecting the forced step-up to pass, got:", err)
+	}
+}
+
+func TestWaitStepUp(t *testing.T) {
+	prompt := newSSHPrompt[[]byte]()
+
+	answer, received, err := waitStepUp(
+		context.Background(), time.Second, func() error {
+			prompt.deliver([]byte("secret"))
+			return nil
+		}, prompt)
+	if err != nil || !received || string(answer) != "secret" {
+		t.Errorf("Unexpected answer %q, %v, %v", answer, received, err)
+	}
+
+	_, _, err = waitStepUp(
+		context.Background(), 10*time.Millisecond, func() error {
+			return nil
+		}, prompt)
+	if !errors.Is(err, ErrStepUpTimeout) {
+		t.Errorf("Expecting %q, got %v", ErrStepUpTimeout, err)
+	}
+
+	if prompt.deliver([]byte("late")) {
+		t.Error("Expecting the late answer to be rejected")
+	}
+}
@@ -18,8 +18,10 @@
 package commands
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -28,6 +30,7 @@ import (
 	"github.com/nirui/sshwifty/application/configuration"
 	"github.com/nirui/sshwifty/application/log"
 	"github.com/nirui/sshwifty/application/network"
+	"github.com/nirui/sshwifty/application/recording"
 	"github.com/nirui/sshwifty/application/rw"
 )
 
@@ -40,10 +43,19 @@ var (
 // Error codes
 const (
 	TelnetRequestErrorBadRemoteAddress = command.StreamError(0x01)
+	TelnetRequestErrorBadOptions       = command.StreamError(0x02)
+)
+
+// Options, sent as an optional byte after the address
+const (
+	TelnetOptionSequencedInput byte = 0x01
+	TelnetOptionProtobuf       byte = 0x02
 )
 
 const (
 	telnetDefaultPortString = "23"
+	telnetInterruptMaxSize  = 4
+	telnetReasonClientLeft  = "Client has disconnected"
 )
 
 // Server signal codes
@@ -52,8 +64,26 @@ const (
 	TelnetServerHookOutputBeforeConnecting = 0x01
 	TelnetServerDialFailed                 = 0x02
 	TelnetServerDialConnected              = 0x03
+	TelnetServerStepUp                     = 0x04
+	TelnetServerDialFailure                = 0x05
+	TelnetServerSharing                    = 0x06
+	TelnetServerFraming                    = 0x07
+)
+
+// Client signal codes
+const (
+	TelnetClientRemoteBand    = 0x00
+	TelnetClientRespondStepUp = 0x01
+	TelnetClientInterrupt     = 0x02
 )
 
+// telnetSignals is the schema of client signals
+var telnetSignals = command.Signals{
+	TelnetClientRemoteBand:    command.Signal(0, command.StreamHeaderMaxLength),
+	TelnetClientRespondStepUp: command.Signal(0, stepUpAnswerMaxSize),
+	TelnetClientInterrupt:     command.Signal(0, telnetInterruptMaxSize),
+}
+
 type telnetClient struct {
 	l             log.Logger
 	hooks         command.Hooks
@@ -61,9 +91,17 @@ type telnetClient struct {
 	cfg           command.Configuration
 	baseCtx       context.Context
 	baseCtxCancel func()
+	stepUp        *sshPrompt[[]byte]
 	remoteChan    chan net.Conn
 	remoteConn    net.Conn
 	closeWait     sync.WaitGroup
+	noTrace       bool
+	record        bool
+	mute          outputMute
+	input         inputSequence
+	framing       byte
+	terminated    termination
+	sandbox       *configuration.Sandbox
 }
 
 func newTelnet(
@@ -80,9 +118,15 @@ func newTelnet(
 		cfg:           cfg,
 		baseCtx:       ctx,
 		baseCtxCancel: sync.OnceFunc(ctxCancel),
+		stepUp:        newSSHPrompt[[]byte](),
 		remoteChan:    make(chan net.Conn, 1),
 		remoteConn:    nil,
 		closeWait:     sync.WaitGroup{},
+		noTrace:       false,
+		record:        false,
+		mute:          outputMute{},
+		input:         inputSequence{},
+		framing:       SSHFramingBinary,
 	}
 }
 
@@ -110,6 +154,31 @@ func (d *telnetClient) Bootup(
 			addrErr, TelnetRequestErrorBadRemoteAddress)
 	}
 
+	// Options, older clients don't send them
+	if !r.Completed() {
+		oData, oErr := rw.FetchOneByte(r.Fetch)
+		if oErr != nil {
+			return nil, command.ToFSMError(oErr, TelnetRequestErrorBadOptions)
+		}
+
+		d.input.enabled = oData[0]&TelnetOptionSequencedInput != 0
+
+		if oData[0]&TelnetOptionProtobuf != 0 {
+			d.framing = SSHFramingProtobuf
+		}
+	}
+
+	if p, ok := d.cfg.Preset("Telnet", addr.String()); ok {
+		d.w.SetWeight(p.Weight)
+		d.noTrace = p.NoTrace
+		d.record = p.Record
+		d.sandbox = p.Sandbox
+	}
+
+	if !d.noTrace {
+		d.w.Describe(addr.String())
+	}
+
 	d.closeWait.Add(1)
 	go d.remote(addr.String())
 
@@ -126,12 +195,21 @@ func (d *telnetClient) remote(addr string) {
 
 	buf := [4096]byte{}
 
+	d.sendFraming(buf[:])
+
+	notice := formatConnectNotice(d.cfg.ConnectNotice, "Telnet", addr)
+	if len(notice) > 0 {
+		nLen := copy(buf[d.w.HeaderSize():], notice) + d.w.HeaderSize()
+		d.w.SendManual(TelnetServerHookOutputBeforeConnecting, buf[:nLen])
+	}
+
+	params := command.NewRemoteHookParameters(
+		d.cfg, d.w.StreamID(), "Telnet", addr)
+
 	err := d.hooks.Run(
 		d.baseCtx,
 		configuration.HOOK_BEFORE_CONNECTING,
-		command.NewHookParameters(2).
-			Insert("Remote Type", "Telnet").
-			Insert("Remote Address", addr),
+		params,
 		command.NewDefaultHookOutput(d.l, func(
 			b []byte,
 		) (wLen int, wErr error) {
@@ -144,49 +222,162 @@ func (d *telnetClient) remote(addr string) {
 			return
 		}),
 	)
+	rJournal := newRemoteJournal(d.cfg, d.l, "Telnet", addr)
+	rJournal.limitBy(newSandboxLimits(d.sandbox, d.Terminate))
+	if d.noTrace {
+		rJournal.withoutTrace()
+	}
+	defer func() { rJournal.done(err) }()
+	if err != nil {
+		errLen := copy(buf[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
+		d.w.SendManual(TelnetServerDialFailed, buf[:errLen])
+		return
+	}
+
+	riskStepUp, err := scoreRisk(d.baseCtx, d.cfg, params, "", rJournal, d.l)
 	if err != nil {
 		errLen := copy(buf[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
 		d.w.SendManual(TelnetServerDialFailed, buf[:errLen])
 		return
 	}
 
+	err = stepUp(d.baseCtx, d.cfg, params, riskStepUp, func(
+		code byte,
+	) ([]byte, bool, error) {
+		return waitStepUp(d.baseCtx, d.cfg.PromptTimeout, func() error {
+			buf[d.w.HeaderSize()] = code
+			return d.w.SendManual(
+				TelnetServerStepUp, buf[:d.w.HeaderSize()+1])
+		}, d.stepUp)
+	}, d.l)
+	if err != nil {
+		errLen := copy(buf[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
+		d.w.SendManual(TelnetServerDialFailed, buf[:errLen])
+		return
+	}
+
+	// The size of the terminal is negotiated in-band, the default one is
+	// recorded
+	rec, err := startRecording(d.cfg, d.l, d.noTrace, d.record, recording.Meta{
+		Protocol: "Telnet",
+		Remote:   addr,
+		Term:     d.cfg.TerminalType,
+	})
+	if err != nil {
+		errLen := copy(buf[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
+		d.w.SendManual(TelnetServerDialFailed, buf[:errLen])
+		return
+	}
+	rJournal.recordTo(rec, (&telnetCommandFilter{}).filter)
+
 	dialCtx, dialCtxCancel := context.WithTimeout(d.baseCtx, d.cfg.DialTimeout)
 	defer dialCtxCancel()
-	clientConn, err := d.cfg.Dial(dialCtx, "tcp", addr)
+	trace := network.NewDialTrace()
+	span := beginConnectSpan(d.cfg, "Telnet", addr, trace)
+	clientConn, err := d.cfg.Dial(network.WithDialProtocol(
+		network.WithDialTrace(dialCtx, trace), "Telnet"), "tcp", addr)
 	if err != nil {
+		failure := classifyDialFailure(err)
+		d.l.Info("Connection attempt has failed (%s), %s: %s",
+			failure, newConnectTiming(trace.Report(), err), err)
+		endConnectSpan(span, trace, err)
+		buf[d.w.HeaderSize()] = byte(failure)
+		d.w.SendManual(TelnetServerDialFailure, buf[:d.w.HeaderSize()+1])
 		errLen := copy(buf[d.w.HeaderSize():], err.Error()) + d.w.HeaderSize()
 		d.w.SendManual(TelnetServerDialFailed, buf[:errLen])
 		return
 	}
 	defer clientConn.Close()
 
+	// The connection is dropped when the client has been terminated
+	stopAbort := context.AfterFunc(d.baseCtx, func() { clientConn.Close() })
+	defer stopAbort()
+
+	endConnectSpan(span, trace, nil)
+
 	err = d.w.SendManual(TelnetServerDialConnected, buf[:d.w.HeaderSize()])
 	if err != nil {
 		return
 	}
 
+	rJournal.connected()
+
+	defer shareRemote(d.cfg, "Telnet", addr, "", rJournal,
+		d.l.Context("Sharing"), d.sendSharing)()
+
 	// Set timeout for writer, otherwise the Timeout writer will never
 	// be triggered
 	clientConn.SetWriteDeadline(time.Now().Add(d.cfg.DialTimeout))
 	timeoutClientConn := network.NewWriteTimeoutConn(
 		clientConn, d.cfg.DialTimeout)
 
-	d.remoteChan <- &timeoutClientConn
+	d.remoteChan <- journalConn{Conn: &timeoutClientConn, journal: rJournal}
 
 	for {
 		rLen, err := clientConn.Read(buf[d.w.HeaderSize():])
 		if err != nil {
+			switch {
+			case d.baseCtx.Err() != nil:
+				rJournal.disconnected(d.terminated.or(telnetReasonClientLeft))
+
+			case errors.Is(err, io.EOF):
+				rJournal.disconnected("Connection closed by the remote")
+
+			default:
+				rJournal.disconnected("Connection has failed: " + err.Error())
+			}
+
 			return
 		}
 
+		rJournal.output("stdout", buf[d.w.HeaderSize():][:rLen])
+
+		// The output that has flooded the terminal is dropped after the
+		// client interrupted it
+		if d.mute.muted() {
+			continue
+		}
+
 		wErr := d.w.SendManual(
 			TelnetServerRemoteBand, buf[:rLen+d.w.HeaderSize()])
 		if wErr != nil {
+			rJournal.disconnected(d.terminated.or(telnetReasonClientLeft))
+
 			return
 		}
 	}
 }
 
+// sendFraming confirms the framing requested by the client. It must be sent
+// before any other signal, and it's not sent when the framing is
+// SSHFramingBinary
+func (d *telnetClient) sendFraming(buf []byte) {
+	if d.framing == SSHFramingBinary {
+		return
+	}
+
+	buf[d.w.HeaderSize()] = d.framing
+	d.w.SendManual(TelnetServerFraming, buf[:d.w.HeaderSize()+1])
+}
+
+// sendSharing sends the other `sessions` connected to the remote to the
+// client
+func (d *telnetClient) sendSharing(sessions sharingSessions) error {
+	payload, err := encodeSignalPayload(d.framing, sessions)
+	if err != nil {
+		return err
+	}
+
+	buf := [4096]byte{}
+	if len(payload)+d.w.HeaderSize() > len(buf) {
+		return ErrSharingTooLarge
+	}
+
+	pLen := copy(buf[d.w.HeaderSize():], payload) + d.w.HeaderSize()
+
+	return d.w.SendManual(TelnetServerSharing, buf[:pLen])
+}
+
 func (d *telnetClient) getRemote() (net.Conn, error) {
 	if d.remoteConn != nil {
 		return d.remoteConn, nil
@@ -201,17 +392,217 @@ func (d *telnetClient) getRemote() (net.Conn, error) {
 	return d.remoteConn, nil
 }
 
+// telnetNegotiationOnly returns whether `b` contains nothing but the option
+// negotiations (including the subnegotiations) of the Telnet protocol
+func telnetNegotiationOnly(b []byte) bool {
+	const (
+		iac  = 255
+		dont = 254
+		will = 251
+		sb   = 250
+		se   = 240
+	)
+
+	for i := 0; i < len(b); {
+		if b[i] != iac || i+2 >= len(b) {
+			return false
+		}
+
+		switch cmd := b[i+1]; {
+		case cmd >= will && cmd <= dont:
+			i += 3
+
+		case cmd == sb:
+			end := bytes.Index(b[i+2:], []byte{iac, se})
+			if end < 0 {
+				return false
+			}
+
+			i += 2 + end + 2
+
+		default:
+			return false
+		}
+	}
+
+	return len(b) > 0
+}
+
+// telnetCommandFilter removes the commands of the Telnet protocol (i.e. the
+// option negotiations) from the output of the remote, leaving only what's
+// printed to the terminal. Commands split across the outputs are removed too
+type telnetCommandFilter struct {
+	state int
+}
+
+// States of the telnetCommandFilter
+const (
+	telnetFilterData = iota
+	telnetFilterCommand
+	telnetFilterOption
+	telnetFilterSub
+	telnetFilterSubCommand
+)
+
+// filter returns the `b` without the Telnet commands
+func (f *telnetCommandFilter) filter(b []byte) []byte {
+	const (
+		iac  = 255
+		dont = 254
+		will = 251
+		sb   = 250
+		se   = 240
+	)
+
+	out := make([]byte, 0, len(b))
+
+	for _, c := range b {
+		switch f.state {
+		case telnetFilterData:
+			if c == iac {
+				f.state = telnetFilterCommand
+			} else {
+				out = append(out, c)
+			}
+
+		case telnetFilterCommand:
+			switch {
+			case c == iac: // Escaped 0xff
+				out = append(out, c)
+				f.state = telnetFilterData
+
+			case c >= will && c <= dont:
+				f.state = telnetFilterOption
+
+			case c == sb:
+				f.state = telnetFilterSub
+
+			default:
+				f.state = telnetFilterData
+			}
+
+		case telnetFilterOption:
+			f.state = telnetFilterData
+
+		case telnetFilterSub:
+			if c == iac {
+				f.state = telnetFilterSubCommand
+			}
+
+		case telnetFilterSubCommand:
+			if c == se {
+				f.state = telnetFilterData
+			} else {
+				f.state = telnetFilterSub
+			}
+		}
+	}
+
+	return out
+}
+
+// negotiate sends the signal in `r` to the remote only when it's an option
+// negotiation, so the session can be set up without letting the client
+// type into it
+func (d *telnetClient) negotiate(
+	remoteConn net.Conn,
+	r *rw.LimitedReader,
+) error {
+	data := make([]byte, 0, r.Remains())
+
+	for !r.Completed() {
+		rBuf, rErr := r.Buffered()
+		if rErr != nil {
+			return rErr
+		}
+
+		data = append(data, rBuf...)
+	}
+
+	if !telnetNegotiationOnly(data) {
+		return nil
+	}
+
+	_, wErr := remoteConn.Write(data)
+	if wErr != nil {
+		remoteConn.Close()
+		d.l.Debug("Failed to write negotiation to remote: %s", wErr)
+	}
+
+	return nil
+}
+
+// interrupt sends the interrupt key named in `r` to the remote, and drops
+// the output of it for the InterruptMuteTime
+func (d *telnetClient) interrupt(
+	remoteConn net.Conn,
+	r *rw.LimitedReader,
+) error {
+	name := make([]byte, 0, telnetInterruptMaxSize)
+
+	for !r.Completed() {
+		rBuf, rErr := r.Buffered()
+		if rErr != nil {
+			return rErr
+		}
+
+		name = append(name, rBuf...)
+	}
+
+	key, err := interruptKey(name)
+	if err != nil {
+		d.l.Debug("Ignored interrupt: %s", err)
+
+		return nil
+	}
+
+	d.mute.mute(d.cfg.InterruptMuteTime)
+
+	_, wErr := remoteConn.Write([]byte{key})
+	if wErr != nil {
+		remoteConn.Close()
+		d.l.Debug("Failed to write interrupt to remote: %s", wErr)
+	}
+
+	return nil
+}
+
 func (d *telnetClient) client(
 	f *command.FSM,
 	r *rw.LimitedReader,
 	h command.StreamHeader,
 	b []byte,
 ) error {
+	if h.Marker() == TelnetClientRespondStepUp {
+		return readStepUpAnswer(r, d.stepUp)
+	}
+
 	remoteConn, remoteConnErr := d.getRemote()
 	if remoteConnErr != nil {
 		return remoteConnErr
 	}
 
+	if h.Marker() == TelnetClientRemoteBand {
+		accepted, sErr := d.input.read(r, b)
+		if sErr != nil {
+			return sErr
+		}
+
+		if !accepted {
+			d.l.Debug("Dropped a retransmitted input")
+
+			return r.Ditch(b)
+		}
+	}
+
+	if d.cfg.ReadOnly {
+		return d.negotiate(remoteConn, r)
+	}
+
+	if h.Marker() == TelnetClientInterrupt {
+		return d.interrupt(remoteConn, r)
+	}
+
 	// All Telnet requests are in-band, so we just directly send them all
 	// to the server
 	for !r.Completed() {
@@ -241,6 +632,13 @@ func (d *telnetClient) Close() error {
 	return nil
 }
 
+// Terminate disconnects the remote with the `reason` on behalf of the
+// administrator, which also closes the stream
+func (d *telnetClient) Terminate(reason string) {
+	d.terminated.terminate(reason)
+	d.baseCtxCancel()
+}
+
 func (d *telnetClient) Release() error {
 	d.baseCtxCancel()
 	return nil
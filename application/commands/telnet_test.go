@@ -0,0 +1,64 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"testing"
+)
+
+func TestTelnetNegotiationOnly(t *testing.T) {
+	for _, c := range []struct {
+		data     []byte
+		expected bool
+	}{
+		{[]byte{255, 253, 1}, true},
+		{[]byte{255, 251, 24, 255, 252, 31}, true},
+		{[]byte{255, 251, 31, 255, 250, 31, 0, 80, 0, 24, 255, 240}, true},
+		{[]byte{}, false},
+		{[]byte("ls\r"), false},
+		{[]byte{255, 253}, false},
+		{[]byte{255, 255, 'l', 's', '\r'}, false},
+		{[]byte{255, 244, 0}, false},
+		{[]byte{255, 253, 1, 'l', 's'}, false},
+		{[]byte{255, 250, 31, 0, 80}, false},
+	} {
+		if r := telnetNegotiationOnly(c.data); r != c.expected {
+			t.Errorf("Expecting %v for %v, got %v", c.expected, c.data, r)
+		}
+	}
+}
+
+func TestTelnetCommandFilter(t *testing.T) {
+	f := telnetCommandFilter{}
+
+	for _, c := range []struct {
+		data     []byte
+		expected []byte
+	}{
+		{[]byte("login: "), []byte("login: ")},
+		{[]byte{255, 253, 1, 'a', 255, 255, 'b'}, []byte{'a', 255, 'b'}},
+		{[]byte{'c', 255}, []byte{'c'}},
+		{[]byte{251, 24, 'd'}, []byte{'d'}},
+		{[]byte{255, 250, 31, 0, 80, 255}, []byte{}},
+		{[]byte{240, 'e', 255, 241, 'f'}, []byte{'e', 'f'}},
+	} {
+		if r := f.filter(c.data); string(r) != string(c.expected) {
+			t.Errorf("Expecting %v for %v, got %v", c.expected, c.data, r)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"sync/atomic"
+)
+
+// termination records why the remote connection was disconnected on purpose,
+// i.e. by the administrator, so the journal can tell it apart from the
+// client having left
+type termination struct {
+	reason atomic.Pointer[string]
+}
+
+// terminate records the `reason` of the termination. Only the first reason
+// is kept
+func (t *termination) terminate(reason string) {
+	t.reason.CompareAndSwap(nil, &reason)
+}
+
+// or returns the reason of the termination, or the `reason` when the
+// connection wasn't terminated
+func (t *termination) or(reason string) string {
+	r := t.reason.Load()
+	if r == nil {
+		return reason
+	}
+
+	return *r
+}
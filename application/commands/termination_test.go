@@ -0,0 +1,37 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package commands
+
+import (
+	"testing"
+)
+
+func TestTermination(t *testing.T) {
+	tt := termination{}
+
+	if r := tt.or("left"); r != "left" {
+		t.Errorf("Expecting %q when not terminated, got %q", "left", r)
+	}
+
+	tt.terminate("first")
+	tt.terminate("second")
+
+	if r := tt.or("left"); r != "first" {
+		t.Errorf("Expecting the first reason %q, got %q", "first", r)
+	}
+}
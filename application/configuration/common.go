@@ -17,6 +17,8 @@
 
 package configuration
 
+import "strings"
+
 func durationAtLeast(current, min int) int {
 	if current > min {
 		return current
@@ -24,3 +26,19 @@ func durationAtLeast(current, min int) int {
 
 	return min
 }
+
+// normalizeTerminalModes returns the terminal `modes` with their names
+// upper-cased
+func normalizeTerminalModes(modes map[string]uint32) map[string]uint32 {
+	if len(modes) <= 0 {
+		return nil
+	}
+
+	normalized := make(map[string]uint32, len(modes))
+
+	for name, val := range modes {
+		normalized[strings.ToUpper(strings.TrimSpace(name))] = val
+	}
+
+	return normalized
+}
@@ -18,18 +18,191 @@
 package configuration
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/nirui/sshwifty/application/approval"
+	"github.com/nirui/sshwifty/application/audit"
+	"github.com/nirui/sshwifty/application/broadcast"
+	"github.com/nirui/sshwifty/application/classroom"
+	"github.com/nirui/sshwifty/application/deeplink"
+	"github.com/nirui/sshwifty/application/forward"
+	"github.com/nirui/sshwifty/application/handover"
+	"github.com/nirui/sshwifty/application/journal"
+	"github.com/nirui/sshwifty/application/kiosk"
 	"github.com/nirui/sshwifty/application/network"
+	"github.com/nirui/sshwifty/application/ratelimit"
+	"github.com/nirui/sshwifty/application/recording"
+	"github.com/nirui/sshwifty/application/relay"
+	"github.com/nirui/sshwifty/application/risk"
+	"github.com/nirui/sshwifty/application/sharing"
+	"github.com/nirui/sshwifty/application/stepup"
+	"github.com/nirui/sshwifty/application/streamstats"
+	"github.com/nirui/sshwifty/application/tracing"
+	"github.com/nirui/sshwifty/application/upgrade"
+	"github.com/nirui/sshwifty/application/warmup"
+	"github.com/nirui/sshwifty/application/watcher"
+	"golang.org/x/crypto/ssh"
 )
 
+var (
+	forwardNameVerifier     = regexp.MustCompile("^[0-9A-Za-z_.-]{1,32}$")
+	environmentNameVerifier = regexp.MustCompile(
+		"^[A-Za-z_][0-9A-Za-z_]{0,63}$")
+	attachProgramVerifier = regexp.MustCompile("^[0-9A-Za-z_./-]{1,128}$")
+)
+
+// terminalModeOpcodes are the opcodes of the SSH terminal modes, see
+// RFC 4254 section 8 and RFC 8160
+var terminalModeOpcodes = map[string]uint8{
+	"VINTR":         1,
+	"VQUIT":         2,
+	"VERASE":        3,
+	"VKILL":         4,
+	"VEOF":          5,
+	"VEOL":          6,
+	"VEOL2":         7,
+	"VSTART":        8,
+	"VSTOP":         9,
+	"VSUSP":         10,
+	"VDSUSP":        11,
+	"VREPRINT":      12,
+	"VWERASE":       13,
+	"VLNEXT":        14,
+	"VFLUSH":        15,
+	"VSWTCH":        16,
+	"VSTATUS":       17,
+	"VDISCARD":      18,
+	"IGNPAR":        30,
+	"PARMRK":        31,
+	"INPCK":         32,
+	"ISTRIP":        33,
+	"INLCR":         34,
+	"IGNCR":         35,
+	"ICRNL":         36,
+	"IUCLC":         37,
+	"IXON":          38,
+	"IXANY":         39,
+	"IXOFF":         40,
+	"IMAXBEL":       41,
+	"IUTF8":         42,
+	"ISIG":          50,
+	"ICANON":        51,
+	"XCASE":         52,
+	"ECHO":          53,
+	"ECHOE":         54,
+	"ECHOK":         55,
+	"ECHONL":        56,
+	"NOFLSH":        57,
+	"TOSTOP":        58,
+	"IEXTEN":        59,
+	"ECHOCTL":       60,
+	"ECHOKE":        61,
+	"PENDIN":        62,
+	"OPOST":         70,
+	"OLCUC":         71,
+	"ONLCR":         72,
+	"OCRNL":         73,
+	"ONOCR":         74,
+	"ONLRET":        75,
+	"CS7":           90,
+	"CS8":           91,
+	"PARENB":        92,
+	"PARODD":        93,
+	"TTY_OP_ISPEED": 128,
+	"TTY_OP_OSPEED": 129,
+}
+
+// ListenFamily is the address family the Server listens with
+type ListenFamily string
+
+// Defined ListenFamily
+const (
+	// LISTEN_FAMILY_DEFAULT listens to the ListenInterface with whichever
+	// family it's in. A wildcard ListenInterface ("0.0.0.0" or "::") accepts
+	// both IPv4 and IPv6 where the system permits it
+	LISTEN_FAMILY_DEFAULT ListenFamily = ""
+
+	// LISTEN_FAMILY_IPV4 only accepts IPv4
+	LISTEN_FAMILY_IPV4 ListenFamily = "ipv4"
+
+	// LISTEN_FAMILY_IPV6 only accepts IPv6, with the IPV6_V6ONLY set
+	LISTEN_FAMILY_IPV6 ListenFamily = "ipv6"
+
+	// LISTEN_FAMILY_DUAL accepts both IPv6 and IPv4 on an IPv6
+	// ListenInterface, with the IPV6_V6ONLY cleared
+	LISTEN_FAMILY_DUAL ListenFamily = "dual"
+)
+
+// Network returns the network to listen to the `ip` with
+func (f ListenFamily) Network() string {
+	switch f {
+	case LISTEN_FAMILY_IPV4:
+		return "tcp4"
+
+	case LISTEN_FAMILY_IPV6:
+		return "tcp6"
+
+	default:
+		return "tcp"
+	}
+}
+
+// verify returns an error when current ListenFamily is unsupported, or
+// can't be used to listen to the `ip`
+func (f ListenFamily) verify(ip net.IP) error {
+	isIPv4 := ip.To4() != nil
+
+	switch f {
+	case LISTEN_FAMILY_DEFAULT:
+		return nil
+
+	case LISTEN_FAMILY_IPV4:
+		if !isIPv4 {
+			return fmt.Errorf("%q is not an IPv4 address", ip)
+		}
+
+		return nil
+
+	case LISTEN_FAMILY_IPV6, LISTEN_FAMILY_DUAL:
+		if isIPv4 {
+			return fmt.Errorf("%q is not an IPv6 address", ip)
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf(
+			"unsupported listen family: %q. Supported families are: %q",
+			f,
+			[]ListenFamily{
+				LISTEN_FAMILY_IPV4,
+				LISTEN_FAMILY_IPV6,
+				LISTEN_FAMILY_DUAL,
+			},
+		)
+	}
+}
+
 // Server contains configuration of a HTTP server
 type Server struct {
 	ListenInterface       string
+	ListenFamily          ListenFamily
 	ListenPort            uint16
+	ReusePort             bool
 	InitialTimeout        time.Duration
 	ReadTimeout           time.Duration
 	WriteTimeout          time.Duration
@@ -89,7 +262,9 @@ func (s Server) WithDefault() Server {
 
 	return Server{
 		ListenInterface:       s.defaultListenInterface(),
+		ListenFamily:          s.ListenFamily,
 		ListenPort:            s.defaultListenPort(),
+		ReusePort:             s.ReusePort,
 		InitialTimeout:        initialTimeout,
 		ReadTimeout:           readTimeout,
 		WriteTimeout:          s.maxDur(s.WriteTimeout, 3*time.Second),
@@ -109,10 +284,15 @@ func (s Server) IsTLS() bool {
 
 // Verify verifies current configuration
 func (s Server) Verify() error {
-	if net.ParseIP(s.ListenInterface) == nil {
+	ip := net.ParseIP(s.ListenInterface)
+	if ip == nil {
 		return fmt.Errorf("invalid IP address \"%s\"", s.ListenInterface)
 	}
 
+	if err := s.ListenFamily.verify(ip); err != nil {
+		return fmt.Errorf("invalid ListenFamily: %s", err)
+	}
+
 	if (len(s.TLSCertificateFile) > 0 && len(s.TLSCertificateKeyFile) <= 0) ||
 		(len(s.TLSCertificateFile) <= 0 && len(s.TLSCertificateKeyFile) > 0) {
 		return errors.New("TLSCertificateFile and TLSCertificateKeyFile must " +
@@ -143,6 +323,55 @@ func (m Meta) Concretize() (map[string]string, error) {
 	return mm, nil
 }
 
+// ReadDeadlineStrategy determines how the read deadline of a remote
+// connection is managed before the remote session is fully established
+type ReadDeadlineStrategy string
+
+// Defined ReadDeadlineStrategy
+const (
+	// READ_DEADLINE_SLIDING renews the deadline every time when data is
+	// received from the remote. The remote is only considered timed out when
+	// it stays silent for too long
+	READ_DEADLINE_SLIDING ReadDeadlineStrategy = "sliding"
+
+	// READ_DEADLINE_FIXED sets a fixed deadline for the entire handshake.
+	// Time spent on waiting for the user (i.e. to enter credentials) is not
+	// counted
+	READ_DEADLINE_FIXED ReadDeadlineStrategy = "fixed"
+
+	// READ_DEADLINE_DISABLED sets no deadline at all, dead remotes can only
+	// be detected through TCP keep-alive
+	READ_DEADLINE_DISABLED ReadDeadlineStrategy = "disabled"
+)
+
+// WithDefault returns the ReadDeadlineStrategy, or the default one when it's
+// unspecified
+func (r ReadDeadlineStrategy) WithDefault() ReadDeadlineStrategy {
+	if len(r) <= 0 {
+		return READ_DEADLINE_SLIDING
+	}
+	return r
+}
+
+// verify returns an error when current ReadDeadlineStrategy is unsupported
+func (r ReadDeadlineStrategy) verify() error {
+	switch r {
+	case READ_DEADLINE_SLIDING, READ_DEADLINE_FIXED, READ_DEADLINE_DISABLED:
+		return nil
+	default:
+		return fmt.Errorf(
+			"unsupported read deadline strategy: %q. Supported strategies "+
+				"are: %q",
+			r,
+			[]ReadDeadlineStrategy{
+				READ_DEADLINE_SLIDING,
+				READ_DEADLINE_FIXED,
+				READ_DEADLINE_DISABLED,
+			},
+		)
+	}
+}
+
 // HookType is a type of Hook
 type HookType string
 
@@ -167,10 +396,141 @@ func (h HookType) verify() error {
 	}
 }
 
-// HookCommand contains a single Hook command
-type HookCommand []string
+// HookAction determines what to do next after a Hook command is executed
+type HookAction string
+
+// Defined Hook Actions
+const (
+	// Execute the next Hook command in the chain
+	HOOK_ACTION_CONTINUE HookAction = "continue"
+
+	// Stop the chain, and reject the request
+	HOOK_ACTION_DENY HookAction = "deny"
+
+	// Stop the chain, and accept the request without executing the rest of
+	// the Hook commands
+	HOOK_ACTION_SKIP HookAction = "skip"
+)
+
+// verify verifies the HookAction. Empty HookAction is valid as it will be
+// replaced by the default one
+func (h HookAction) verify() error {
+	switch h {
+	case "", HOOK_ACTION_CONTINUE, HOOK_ACTION_DENY, HOOK_ACTION_SKIP:
+		return nil
+	default:
+		return fmt.Errorf(
+			"unsupported Hook action: %q. Supported actions are: %q",
+			h,
+			[]HookAction{
+				HOOK_ACTION_CONTINUE,
+				HOOK_ACTION_DENY,
+				HOOK_ACTION_SKIP,
+			},
+		)
+	}
+}
+
+// HookCondition determines whether or not a Hook command will be executed.
+// A Hook command is executed only when all given conditions are met, and a
+// condition is met when any item of it matches
+type HookCondition struct {
+	// Tags of the Preset of the remote
+	PresetTags []string
+
+	// Networks (in CIDR format) of the remote
+	Networks []string
+
+	// Groups of the user
+	UserGroups []string
+}
+
+// verify verifies the HookCondition
+func (h HookCondition) verify() error {
+	for _, n := range h.Networks {
+		if _, _, err := net.ParseCIDR(n); err != nil {
+			return fmt.Errorf("invalid network %q: %s", n, err)
+		}
+	}
+	return nil
+}
+
+// HookCommand contains a single Hook command of a Hook chain
+type HookCommand struct {
+	// The command to execute
+	Command []string
+
+	// Condition to execute the command, optional
+	When HookCondition
+
+	// Action to take when the command was successfully executed. Default to
+	// HOOK_ACTION_CONTINUE
+	OnSuccess HookAction
+
+	// Action to take when the command was failed. Default to
+	// HOOK_ACTION_DENY
+	OnFailure HookAction
+
+	// Execute the command asynchronously in the background. The request will
+	// not wait for it, so it's result has no effect, and OnSuccess and
+	// OnFailure are ignored
+	Async bool
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Besides the object form, a
+// HookCommand can also be defined as an array of the command alone
+func (h *HookCommand) UnmarshalJSON(b []byte) error {
+	command := []string{}
+	if err := json.Unmarshal(b, &command); err == nil {
+		*h = HookCommand{Command: command}
+		return nil
+	}
+
+	type hookCommand HookCommand
+	cmd := hookCommand{}
+	if err := json.Unmarshal(b, &cmd); err != nil {
+		return err
+	}
+	*h = HookCommand(cmd)
+	return nil
+}
+
+// SuccessAction returns the action to take when the command was successfully
+// executed
+func (h HookCommand) SuccessAction() HookAction {
+	if len(h.OnSuccess) <= 0 {
+		return HOOK_ACTION_CONTINUE
+	}
+	return h.OnSuccess
+}
+
+// FailureAction returns the action to take when the command was failed
+func (h HookCommand) FailureAction() HookAction {
+	if len(h.OnFailure) <= 0 {
+		return HOOK_ACTION_DENY
+	}
+	return h.OnFailure
+}
+
+// verify verifies the HookCommand
+func (h HookCommand) verify() error {
+	if len(h.Command) <= 0 || len(h.Command[0]) <= 0 {
+		return errors.New("command must not be empty")
+	}
+	if err := h.When.verify(); err != nil {
+		return fmt.Errorf("invalid condition: %s", err)
+	}
+	if err := h.OnSuccess.verify(); err != nil {
+		return fmt.Errorf("invalid OnSuccess: %s", err)
+	}
+	if err := h.OnFailure.verify(); err != nil {
+		return fmt.Errorf("invalid OnFailure: %s", err)
+	}
+	return nil
+}
 
-// Hooks contains registered Hooks
+// Hooks contains registered Hook chains. Hook commands in a chain are
+// executed in order
 type Hooks map[HookType][]HookCommand
 
 // verify verifies all settings in current Hooks
@@ -183,11 +543,12 @@ func (h Hooks) verify() error {
 			continue
 		}
 		for i := range v {
-			if len(v[i]) <= 0 {
+			if err := v[i].verify(); err != nil {
 				return fmt.Errorf(
-					"the command %d for Hook type %q must not be empty",
+					"the command %d for Hook type %q is invalid: %s",
 					i,
 					k,
+					err,
 				)
 			}
 		}
@@ -197,125 +558,2020 @@ func (h Hooks) verify() error {
 
 // HookSettings contains Hook settings
 type HookSettings struct {
-	Timeout time.Duration
-	Hooks   Hooks
+	Timeout      time.Duration
+	AsyncWorkers int
+	AsyncQueue   int
+	Hooks        Hooks
 }
 
-// Preset contains data of a static remote host
-type Preset struct {
-	Title    string
-	Type     string
-	Host     string
-	TabColor string
-	Meta     map[string]string
+// StepUpRule requires the users to authenticate again through the Method
+// right before connecting to the remotes that meet the condition, even when
+// they have already been authenticated by the gateway
+type StepUpRule struct {
+	// Condition of the remotes that require the step-up authentication
+	When HookCondition
+
+	// Method of the step-up authentication
+	Method stepup.Method
 }
 
-// Configuration contains configuration of the application
-type Configuration struct {
-	HostName               string
-	SharedKey              string
-	DialTimeout            time.Duration
-	Socks5                 string
-	Socks5User             string
-	Socks5Password         string
-	Hooks                  Hooks
-	HookTimeout            time.Duration
-	Servers                []Server
-	Presets                []Preset
-	OnlyAllowPresetRemotes bool
+// verify verifies the StepUpRule
+func (s StepUpRule) verify() error {
+	if err := s.When.verify(); err != nil {
+		return fmt.Errorf("invalid condition: %s", err)
+	}
+
+	return s.Method.Verify()
 }
 
-// Verify verifies current setting
-func (c Configuration) Verify() error {
-	if err := c.Hooks.verify(); err != nil {
-		return fmt.Errorf("invalid Hook settings: %s", err)
+// Defined push-approval providers
+const (
+	PUSH_APPROVAL_DUO     = "duo"
+	PUSH_APPROVAL_WEBHOOK = "webhook"
+)
+
+// PushApproval contains the settings of the provider which must approve the
+// SSH logins before they're completed
+type PushApproval struct {
+	Provider          string
+	Timeout           time.Duration
+	DuoHost           string
+	DuoIntegrationKey string
+	DuoSecretKey      string
+	WebhookURL        string
+	WebhookSecret     string
+}
+
+// verify verifies the PushApproval
+func (p PushApproval) verify() error {
+	switch p.Provider {
+	case "":
+		return nil
+
+	case PUSH_APPROVAL_DUO:
+		if len(p.DuoHost) <= 0 || len(p.DuoIntegrationKey) <= 0 ||
+			len(p.DuoSecretKey) <= 0 {
+			return errors.New("DuoHost, DuoIntegrationKey and " +
+				"DuoSecretKey are required by the \"duo\" provider")
+		}
+
+		return nil
+
+	case PUSH_APPROVAL_WEBHOOK:
+		u, err := url.Parse(p.WebhookURL)
+		if err != nil {
+			return fmt.Errorf("invalid WebhookURL: %s", err)
+		}
+
+		if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) <= 0 {
+			return fmt.Errorf("invalid WebhookURL %q: must be a HTTP or "+
+				"HTTPS URL", p.WebhookURL)
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("%s: %q. Supported providers are: %q",
+			approval.ErrUnsupportedProvider, p.Provider, []string{
+				PUSH_APPROVAL_DUO,
+				PUSH_APPROVAL_WEBHOOK,
+			})
 	}
+}
 
-	if len(c.Servers) <= 0 {
-		return errors.New("must specify at least one server")
+// approver builds the approval.Approver, or nil when push approval is
+// disabled
+func (p PushApproval) approver() *approval.Approver {
+	switch p.Provider {
+	case PUSH_APPROVAL_DUO:
+		return approval.New(approval.NewDuo(
+			p.DuoHost, p.DuoIntegrationKey, p.DuoSecretKey), p.Timeout)
+
+	case PUSH_APPROVAL_WEBHOOK:
+		return approval.New(approval.NewWebhook(
+			p.WebhookURL, p.WebhookSecret), p.Timeout)
+
+	default:
+		return nil
 	}
+}
 
-	for i, c := range c.Servers {
-		vErr := c.Verify()
+// Types of the AuditSink
+const (
+	AUDIT_SINK_FILE    = "file"
+	AUDIT_SINK_SYSLOG  = "syslog"
+	AUDIT_SINK_WEBHOOK = "webhook"
+	AUDIT_SINK_KAFKA   = "kafka"
+	AUDIT_SINK_NATS    = "nats"
+)
 
-		if vErr == nil {
-			continue
+// AuditSink contains the settings of a destination of the audit events
+type AuditSink struct {
+	Type    string // "file", "syslog", "webhook", "kafka" or "nats"
+	Path    string // Path of the file, used by "file"
+	Network string // "udp", "tcp" or empty for local, used by "syslog"
+	Address string // Address of the server, used by "syslog"
+	Tag     string // Tag of the messages, used by "syslog"
+	URL     string // URL of the webhook, Kafka REST Proxy or NATS server
+	Secret  string // Secret to sign the requests, used by "webhook"
+	Topic   string // Topic or subject, used by "kafka" and "nats"
+	Format  string // "json" or "protobuf", used by "kafka" and "nats"
+	Output  bool   // Also send the output of the remotes to the sink
+}
+
+// verify verifies the AuditSink
+func (a AuditSink) verify() error {
+	switch a.Type {
+	case AUDIT_SINK_FILE:
+		if len(a.Path) <= 0 {
+			return errors.New("Path is required by the \"file\" sink")
 		}
 
-		return fmt.Errorf("invalid setting for server %d: %s", i, vErr)
-	}
+		return nil
 
-	return nil
+	case AUDIT_SINK_SYSLOG:
+		return nil
+
+	case AUDIT_SINK_WEBHOOK, AUDIT_SINK_KAFKA:
+		u, err := url.Parse(a.URL)
+		if err != nil {
+			return fmt.Errorf("invalid URL: %s", err)
+		}
+
+		if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) <= 0 {
+			return fmt.Errorf("invalid URL %q: must be a HTTP or HTTPS URL",
+				a.URL)
+		}
+
+		if a.Type == AUDIT_SINK_KAFKA && len(a.Topic) <= 0 {
+			return errors.New("Topic is required by the \"kafka\" sink")
+		}
+
+		if _, err := audit.ParseFormat(a.Format); err != nil {
+			return fmt.Errorf("%s: %q", err, a.Format)
+		}
+
+		return nil
+
+	case AUDIT_SINK_NATS:
+		if len(a.Topic) <= 0 {
+			return errors.New("Topic is required by the \"nats\" sink")
+		}
+
+		if _, err := audit.ParseFormat(a.Format); err != nil {
+			return fmt.Errorf("%s: %q", err, a.Format)
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("%s: %q. Supported sinks are: %q",
+			audit.ErrUnsupportedSink, a.Type, []string{
+				AUDIT_SINK_FILE,
+				AUDIT_SINK_SYSLOG,
+				AUDIT_SINK_WEBHOOK,
+				AUDIT_SINK_KAFKA,
+				AUDIT_SINK_NATS,
+			})
+	}
 }
 
-// Dialer builds a Dialer
-func (c Configuration) Dialer() network.Dial {
-	dialTimeout := c.DialTimeout
+// sink builds the audit.Sink
+func (a AuditSink) sink() (audit.Sink, error) {
+	s, err := a.build()
+	if err != nil {
+		return nil, err
+	}
 
-	if dialTimeout < 3 {
-		dialTimeout = 3
+	if a.Output {
+		return audit.WithOutput(s), nil
 	}
 
-	dialer := network.TCPDial()
+	return s, nil
+}
 
-	if len(c.Socks5) > 0 {
-		sDial, sDialErr := network.BuildSocks5Dial(
-			c.Socks5, c.Socks5User, c.Socks5Password)
+func (a AuditSink) build() (audit.Sink, error) {
+	switch a.Type {
+	case AUDIT_SINK_FILE:
+		return audit.NewFile(a.Path), nil
 
-		if sDialErr != nil {
-			panic("Unable to build Socks5 Dialer: " + sDialErr.Error())
+	case AUDIT_SINK_SYSLOG:
+		return audit.NewSyslog(a.Network, a.Address, a.Tag)
+
+	case AUDIT_SINK_WEBHOOK:
+		return audit.NewWebhook(a.URL, a.Secret), nil
+
+	case AUDIT_SINK_KAFKA:
+		format, err := audit.ParseFormat(a.Format)
+		if err != nil {
+			return nil, err
 		}
 
-		dialer = sDial
+		return audit.NewKafka(a.URL, a.Topic, format), nil
+
+	case AUDIT_SINK_NATS:
+		format, err := audit.ParseFormat(a.Format)
+		if err != nil {
+			return nil, err
+		}
+
+		return audit.NewNATS(a.URL, a.Topic, format)
+
+	default:
+		return nil, audit.ErrUnsupportedSink
 	}
+}
 
-	if c.OnlyAllowPresetRemotes {
-		accessList := make(network.AllowedHosts, len(c.Presets))
+// Audit contains the settings of the audit event delivery
+type Audit struct {
+	Sinks         []AuditSink
+	Buffer        int
+	BatchSize     int
+	FlushInterval time.Duration
+}
 
-		for _, k := range c.Presets {
-			if len(k.Host) <= 0 {
-				continue
-			}
+// verify verifies the Audit
+func (a Audit) verify() error {
+	for i, s := range a.Sinks {
+		if err := s.verify(); err != nil {
+			return fmt.Errorf("invalid Sink %d: %s", i+1, err)
+		}
 
-			accessList[k.Host] = struct{}{}
+		if _, err := s.sink(); err != nil {
+			return fmt.Errorf("invalid Sink %d: %s", i+1, err)
 		}
+	}
 
-		dialer = network.AccessControlDial(accessList, dialer)
+	return nil
+}
+
+// dispatcher builds the audit.Dispatcher, or nil when there's no Sink
+func (a Audit) dispatcher() *audit.Dispatcher {
+	sinks := make([]audit.Sink, 0, len(a.Sinks))
+
+	// Sinks are checked by Verify
+	for _, s := range a.Sinks {
+		sink, err := s.sink()
+		if err != nil {
+			continue
+		}
+
+		sinks = append(sinks, sink)
 	}
 
-	return dialer
+	return audit.New(audit.Settings{
+		Buffer:        a.Buffer,
+		BatchSize:     a.BatchSize,
+		FlushInterval: a.FlushInterval,
+		WriteTimeout:  10 * time.Second,
+		Retries:       3,
+	}, sinks)
 }
 
-// Common settings shared by mulitple servers
-type Common struct {
-	HostName               string
-	SharedKey              string
-	Dialer                 network.Dial
-	DialTimeout            time.Duration
-	Presets                []Preset
-	Hooks                  HookSettings
-	OnlyAllowPresetRemotes bool
+// Tracing contains the settings of the OpenTelemetry tracing of the
+// connection setup
+type Tracing struct {
+	Endpoint    string  // URL of the OTLP/HTTP traces endpoint, or empty
+	Headers     Meta    // Headers sent to the Endpoint
+	ServiceName string  // Name of the service the spans are reported as
+	SampleRate  float64 // Ratio of the connections that are traced
 }
 
-// hookSettings returns Hooks settings
-func (c Configuration) hookSettings() HookSettings {
-	return HookSettings{
-		Timeout: c.HookTimeout,
-		Hooks:   c.Hooks,
+// verify verifies the Tracing
+func (t Tracing) verify() error {
+	if len(t.Endpoint) <= 0 {
+		return nil
+	}
+
+	u, err := url.Parse(t.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid Endpoint: %s", err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) <= 0 {
+		return fmt.Errorf("invalid Endpoint %q: must be a HTTP or HTTPS URL",
+			t.Endpoint)
 	}
+
+	if _, err := t.Headers.Concretize(); err != nil {
+		return fmt.Errorf("invalid Headers: %s", err)
+	}
+
+	return nil
 }
 
-// Common returns common settings
-func (c Configuration) Common() Common {
-	return Common{
-		HostName:               c.HostName,
-		SharedKey:              c.SharedKey,
-		Dialer:                 c.Dialer(),
-		DialTimeout:            c.DialTimeout,
-		Presets:                c.Presets,
-		Hooks:                  c.hookSettings(),
-		OnlyAllowPresetRemotes: c.OnlyAllowPresetRemotes,
+// tracer builds the tracing.Tracer, or nil when the tracing is disabled
+func (t Tracing) tracer() *tracing.Tracer {
+	// Headers are checked by Verify
+	headers, _ := t.Headers.Concretize()
+
+	header := make(http.Header, len(headers))
+	for k, v := range headers {
+		header.Set(k, v)
 	}
+
+	return tracing.New(tracing.Settings{
+		Endpoint:      t.Endpoint,
+		Headers:       header,
+		ServiceName:   t.ServiceName,
+		SampleRate:    t.SampleRate,
+		Buffer:        1024,
+		BatchSize:     100,
+		FlushInterval: 5 * time.Second,
+		Timeout:       10 * time.Second,
+	})
+}
+
+// LoginLimit contains the settings of the lockout of the clients which
+// failed to login too many times
+type LoginLimit struct {
+	MaxAttempts int // 0 to disable the lockout
+	Window      time.Duration
+	Backend     string // Empty or "memory", or an URL of the Redis server
+}
+
+// verify verifies the LoginLimit
+func (l LoginLimit) verify() error {
+	if l.MaxAttempts < 0 {
+		return errors.New("MaxAttempts must not be negative")
+	}
+
+	if _, err := ratelimit.NewBackend(l.Backend); err != nil {
+		return fmt.Errorf("invalid Backend: %s", err)
+	}
+
+	return nil
+}
+
+// limiter builds the ratelimit.Limiter, or nil when the lockout is disabled
+func (l LoginLimit) limiter() *ratelimit.Limiter {
+	if l.MaxAttempts <= 0 {
+		return nil
+	}
+
+	// Backend is checked by Verify
+	backend, err := ratelimit.NewBackend(l.Backend)
+	if err != nil {
+		return nil
+	}
+
+	return ratelimit.New(backend, "login", l.MaxAttempts, l.Window)
+}
+
+// RiskScoring contains the settings of the webhook which scores the risk of
+// the sessions before they're connected, and the score thresholds of the
+// actions taken against the risky ones
+type RiskScoring struct {
+	WebhookURL     string // Empty to disable the risk scoring
+	WebhookSecret  string
+	Timeout        time.Duration
+	IncludeCommand bool // Send the commands that sessions are going to run
+	FailClosed     bool // Refuse the sessions when the webhook fails
+	AlertScore     float64
+	StepUpScore    float64
+	StepUpMethod   stepup.Method
+	TerminateScore float64
+}
+
+// verify verifies the RiskScoring
+func (r RiskScoring) verify() error {
+	if len(r.WebhookURL) <= 0 {
+		return nil
+	}
+
+	u, err := url.Parse(r.WebhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid WebhookURL: %s", err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) <= 0 {
+		return fmt.Errorf("invalid WebhookURL %q: must be a HTTP or "+
+			"HTTPS URL", r.WebhookURL)
+	}
+
+	if r.AlertScore < 0 || r.StepUpScore < 0 || r.TerminateScore < 0 {
+		return errors.New("scores must not be negative")
+	}
+
+	if r.StepUpScore > 0 {
+		if err := r.StepUpMethod.Verify(); err != nil {
+			return fmt.Errorf("invalid StepUpMethod: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// scorer builds the risk.Scorer, or nil when the risk scoring is disabled
+func (r RiskScoring) scorer() *risk.Scorer {
+	if len(r.WebhookURL) <= 0 {
+		return nil
+	}
+
+	return risk.New(r.WebhookURL, risk.Settings{
+		Secret:         r.WebhookSecret,
+		Timeout:        r.Timeout,
+		IncludeCommand: r.IncludeCommand,
+		FailClosed:     r.FailClosed,
+		Thresholds: risk.Thresholds{
+			Alert:     r.AlertScore,
+			StepUp:    r.StepUpScore,
+			Terminate: r.TerminateScore,
+		},
+		StepUpMethod: r.StepUpMethod,
+	})
+}
+
+// Kiosk contains the settings of the kiosks, the unattended displays (i.e.
+// NOC wall displays) which watch the console of a Preset in the read-only
+// mode
+type Kiosk struct {
+	Key               string // Empty to disable the kiosks
+	KeepaliveInterval time.Duration
+}
+
+// verify verifies the Kiosk
+func (k Kiosk) verify() error {
+	if len(k.Key) <= 0 {
+		return nil
+	}
+
+	if k.KeepaliveInterval < time.Second {
+		return errors.New("KeepaliveInterval must be at least 1 second")
+	}
+
+	return nil
+}
+
+// Recording contains the settings of the session recording
+type Recording struct {
+	Directory   string        // Empty to disable the recording
+	Filename    string        // Template of the file names
+	Retention   time.Duration // 0 to keep the recordings forever
+	AllSessions bool          // Otherwise only the Presets marked to Record
+	Index       bool          // Index the output for full-text search
+	S3          RecordingS3
+}
+
+// RecordingS3 contains the settings of the S3 compatible object storage
+// where the finished recordings are shipped to
+type RecordingS3 struct {
+	Endpoint  string // Empty to use AWS S3
+	Region    string
+	Bucket    string // Empty to keep the recordings in the Directory
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+}
+
+// settings returns the recording.S3Settings
+func (r RecordingS3) settings() recording.S3Settings {
+	return recording.S3Settings{
+		Endpoint:  r.Endpoint,
+		Region:    r.Region,
+		Bucket:    r.Bucket,
+		Prefix:    r.Prefix,
+		AccessKey: r.AccessKey,
+		SecretKey: r.SecretKey,
+		PathStyle: r.PathStyle,
+	}
+}
+
+// verify verifies the Recording
+func (r Recording) verify() error {
+	if len(r.Directory) <= 0 {
+		if len(r.S3.Bucket) > 0 {
+			return errors.New("the S3 Bucket requires the Directory, " +
+				"where the recordings are kept until they're shipped")
+		}
+
+		return nil
+	}
+
+	if err := recording.VerifyFilename(r.Filename); err != nil {
+		return err
+	}
+
+	if len(r.S3.Bucket) <= 0 {
+		return nil
+	}
+
+	if err := r.S3.settings().Verify(); err != nil {
+		return fmt.Errorf("invalid S3: %s", err)
+	}
+
+	return nil
+}
+
+// recorder builds the recording.Recorder, or nil when the recording is
+// disabled
+func (r Recording) recorder() *recording.Recorder {
+	var storage recording.Storage
+
+	if len(r.S3.Bucket) > 0 {
+		storage = recording.NewS3(r.S3.settings())
+	}
+
+	return recording.New(recording.Settings{
+		Directory: r.Directory,
+		Filename:  r.Filename,
+		Retention: r.Retention,
+		Storage:   storage,
+		Index:     r.Index,
+	})
+}
+
+// SSHAlgorithms contains the algorithms offered to the SSH servers, in the
+// order of preference. Empty lists to use the defaults of the SSH library
+type SSHAlgorithms struct {
+	Ciphers           []string
+	KeyExchanges      []string
+	MACs              []string
+	HostKeyAlgorithms []string
+}
+
+// sshHostKeyAlgorithms are the host key algorithms supported by the SSH
+// library
+var sshHostKeyAlgorithms = map[string]struct{}{
+	ssh.KeyAlgoRSA:            {},
+	ssh.KeyAlgoRSASHA256:      {},
+	ssh.KeyAlgoRSASHA512:      {},
+	ssh.KeyAlgoDSA:            {},
+	ssh.KeyAlgoECDSA256:       {},
+	ssh.KeyAlgoECDSA384:       {},
+	ssh.KeyAlgoECDSA521:       {},
+	ssh.KeyAlgoSKECDSA256:     {},
+	ssh.KeyAlgoED25519:        {},
+	ssh.KeyAlgoSKED25519:      {},
+	ssh.CertAlgoRSAv01:        {},
+	ssh.CertAlgoRSASHA256v01:  {},
+	ssh.CertAlgoRSASHA512v01:  {},
+	ssh.CertAlgoDSAv01:        {},
+	ssh.CertAlgoECDSA256v01:   {},
+	ssh.CertAlgoECDSA384v01:   {},
+	ssh.CertAlgoECDSA521v01:   {},
+	ssh.CertAlgoSKECDSA256v01: {},
+	ssh.CertAlgoED25519v01:    {},
+	ssh.CertAlgoSKED25519v01:  {},
+}
+
+// verify verifies the SSHAlgorithms
+func (s SSHAlgorithms) verify() error {
+	// SetDefaults drops the algorithms which are not supported by the SSH
+	// library, so every missing item is an unknown one
+	cfg := ssh.Config{
+		Ciphers:      s.Ciphers,
+		KeyExchanges: s.KeyExchanges,
+		MACs:         s.MACs,
+	}
+	cfg.SetDefaults()
+
+	for _, l := range []struct {
+		name      string
+		specified []string
+		supported []string
+	}{
+		{"Ciphers", s.Ciphers, cfg.Ciphers},
+		{"KeyExchanges", s.KeyExchanges, cfg.KeyExchanges},
+		{"MACs", s.MACs, cfg.MACs},
+	} {
+		if len(l.specified) <= 0 {
+			continue
+		}
+
+		if err := verifySSHAlgorithmList(l.specified, l.supported); err != nil {
+			return fmt.Errorf("invalid %s: %s", l.name, err)
+		}
+	}
+
+	for _, a := range s.HostKeyAlgorithms {
+		if _, ok := sshHostKeyAlgorithms[a]; !ok {
+			return fmt.Errorf("invalid HostKeyAlgorithms: unsupported "+
+				"algorithm %q", a)
+		}
+	}
+
+	return nil
+}
+
+// verifySSHAlgorithmList returns an error when any of the `specified`
+// algorithms is not in the `supported` ones
+func verifySSHAlgorithmList(specified []string, supported []string) error {
+	for _, a := range specified {
+		found := false
+
+		for _, s := range supported {
+			if s == a {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("unsupported algorithm %q", a)
+		}
+	}
+
+	return nil
+}
+
+// IsEmpty returns whether or not none of the algorithms is specified
+func (s SSHAlgorithms) IsEmpty() bool {
+	return len(s.Ciphers) <= 0 && len(s.KeyExchanges) <= 0 &&
+		len(s.MACs) <= 0 && len(s.HostKeyAlgorithms) <= 0
+}
+
+// Override returns the SSHAlgorithms with its lists replaced by the
+// non-empty ones of `o`
+func (s SSHAlgorithms) Override(o SSHAlgorithms) SSHAlgorithms {
+	if len(o.Ciphers) > 0 {
+		s.Ciphers = o.Ciphers
+	}
+
+	if len(o.KeyExchanges) > 0 {
+		s.KeyExchanges = o.KeyExchanges
+	}
+
+	if len(o.MACs) > 0 {
+		s.MACs = o.MACs
+	}
+
+	if len(o.HostKeyAlgorithms) > 0 {
+		s.HostKeyAlgorithms = o.HostKeyAlgorithms
+	}
+
+	return s
+}
+
+// SSHServerPolicy describes the known-good profile of the SSH servers. The
+// servers which negotiated a weak algorithm or reported a weak version are
+// warned about once they're connected
+type SSHServerPolicy struct {
+	Disabled bool
+
+	// Patterns (in the syntax of path.Match, i.e. "*-cbc") of the weak
+	// algorithms. nil to use the defaultSSHWeakAlgorithms
+	WeakAlgorithms []string
+
+	// Regular expressions of the weak server version strings. nil to use the
+	// defaultSSHWeakServerVersions
+	WeakServerVersions []string
+}
+
+// defaultSSHWeakAlgorithms are the algorithms considered weak by default
+var defaultSSHWeakAlgorithms = []string{
+	ssh.KeyAlgoRSA,
+	ssh.KeyAlgoDSA,
+	ssh.CertAlgoRSAv01,
+	ssh.CertAlgoDSAv01,
+	"diffie-hellman-group1-sha1",
+	"diffie-hellman-group14-sha1",
+	"diffie-hellman-group-exchange-sha1",
+	"*-cbc",
+	"arcfour*",
+	"hmac-sha1-96",
+}
+
+// defaultSSHWeakServerVersions are the server versions considered weak by
+// default: the ones which still speak SSH 1, and OpenSSH older than 7.0
+var defaultSSHWeakServerVersions = []string{
+	`^SSH-1\.`,
+	`^SSH-2\.0-OpenSSH_[1-6]\.`,
+}
+
+// WithDefault returns the SSHServerPolicy with the unspecified lists set to
+// their defaults
+func (s SSHServerPolicy) WithDefault() SSHServerPolicy {
+	if s.WeakAlgorithms == nil {
+		s.WeakAlgorithms = defaultSSHWeakAlgorithms
+	}
+
+	if s.WeakServerVersions == nil {
+		s.WeakServerVersions = defaultSSHWeakServerVersions
+	}
+
+	return s
+}
+
+// verify verifies the SSHServerPolicy
+func (s SSHServerPolicy) verify() error {
+	for _, p := range s.WeakAlgorithms {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid WeakAlgorithms pattern %q: %s", p, err)
+		}
+	}
+
+	for _, v := range s.WeakServerVersions {
+		if _, err := regexp.Compile(v); err != nil {
+			return fmt.Errorf("invalid WeakServerVersions %q: %s", v, err)
+		}
+	}
+
+	return nil
+}
+
+// WeakAlgorithm returns whether or not the `algorithm` is a weak one
+func (s SSHServerPolicy) WeakAlgorithm(algorithm string) bool {
+	if s.Disabled || len(algorithm) <= 0 {
+		return false
+	}
+
+	for _, p := range s.WeakAlgorithms {
+		if ok, _ := path.Match(p, algorithm); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WeakServerVersion returns whether or not the server `version` is a weak
+// one
+func (s SSHServerPolicy) WeakServerVersion(version string) bool {
+	if s.Disabled {
+		return false
+	}
+
+	for _, v := range s.WeakServerVersions {
+		if ok, _ := regexp.MatchString(v, version); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Preset contains data of a static remote host
+type Preset struct {
+	Title               string
+	Type                string
+	Host                string
+	TabColor            string
+	Meta                map[string]string
+	ProxyCommand        []string
+	Watch               string
+	Tags                []string
+	FastStart           bool
+	Weight              int
+	Forwards            map[string]string
+	NoTrace             bool
+	ExpectedFingerprint string
+	SSHAgentSocket      string
+	Command             string
+	Environment         map[string]string
+	SSHAlgorithms       SSHAlgorithms
+	Bootstrap           string
+	HideBootstrap       bool
+	Attach              string
+	Record              bool
+	Sandbox             *Sandbox
+	TLS                 *TLSWrap
+	WireGuard           bool
+}
+
+// Configuration contains configuration of the application
+type Configuration struct {
+	HostName               string
+	SharedKey              string
+	DialTimeout            time.Duration
+	Socks5                 string
+	Socks5User             String
+	Socks5Password         String
+	WireGuard              *WireGuard
+	TCPKeepAliveIdle       time.Duration
+	TCPKeepAliveInterval   time.Duration
+	TCPKeepAliveCount      int
+	ReadDeadlineStrategy   ReadDeadlineStrategy
+	PromptTimeout          time.Duration
+	KeyVaultFile           string
+	KnownHostsFile         string
+	UserSettingsFile       string
+	PasskeyFile            string
+	PasskeyRPID            string
+	PasskeySessionLifetime time.Duration
+	SocketBinding          bool
+	TrustedProxies         []string
+	UsageNetworks          map[string][]string
+	JournalFile            string
+	JournalRetention       time.Duration
+	JournalMaxEvents       int
+	ConnectNotice          string
+	DNSCacheSize           int
+	DNSCacheMaxTTL         time.Duration
+	DNSCacheNegativeTTL    time.Duration
+	WatchInterval          time.Duration
+	UserHeader             string
+	UserGroupsHeader       string
+	SSHRekeyThreshold      uint64
+	SSHMaxPacketSize       int
+	SSHAlgorithms          SSHAlgorithms
+	SSHServerPolicy        SSHServerPolicy
+	SSHKeepaliveInterval   time.Duration
+	SSHKeepaliveCountMax   int
+	SSHReconnectAttempts   int
+	SSHReconnectMaxDelay   time.Duration
+	HandoverLifetime       time.Duration
+	HandoverMaxSessions    int
+	InterruptMuteTime      time.Duration
+	RelayBufferSize        int
+	RelayQueueSize         int
+	RelayMaxBuffers        int
+	TerminalModes          map[string]uint32
+	FastStartConnections   int
+	FastStartRevalidation  time.Duration
+	ForwardBindHost        string
+	ReverseForwardRules    []string
+	AllowDynamicForwards   bool
+	AllowLocalForwards     bool
+	AllowFileTransfer      bool
+	SSHAgentSocket         string
+	PushApproval           PushApproval
+	Audit                  Audit
+	Tracing                Tracing
+	LoginLimit             LoginLimit
+	RiskScoring            RiskScoring
+	ManagementToken        string
+	DeepLinkKey            string
+	Kiosk                  Kiosk
+	Recording              Recording
+	StepUpRules            []StepUpRule
+	StepUpTOTPSecrets      map[string]string
+	Hooks                  Hooks
+	HookTimeout            time.Duration
+	AsyncHookWorkers       int
+	AsyncHookQueue         int
+	Servers                []Server
+	Presets                []Preset
+	OnlyAllowPresetRemotes bool
+	ProvisionFile          string
+	MountedDirectories     []string
+	UpgradeDrainTimeout    time.Duration
+	ShutdownDrainTimeout   time.Duration
+	ReplicateFrom          string
+	ReplicationToken       string
+	Inventory              Inventory
+	Tenants                []Tenant
+
+	// Configuration before the ProvisionFile is applied
+	provisionBase *Configuration
+
+	// Configuration before the MountedDirectories are applied, and the
+	// digest of the applied content
+	mountedBase   *Configuration
+	mountedDigest string
+
+	// Digest of the Presets read from the Inventory
+	inventoryDigest string
+
+	// Where the configuration is loaded from by the Remote loader
+	remote *remoteConfig
+}
+
+// Verify verifies current setting
+func (c Configuration) Verify() error {
+	if _, _, err := c.socks5Credentials(); err != nil {
+		return err
+	}
+
+	if err := c.WireGuard.verify(); err != nil {
+		return fmt.Errorf("invalid WireGuard: %s", err)
+	}
+
+	if err := c.Hooks.verify(); err != nil {
+		return fmt.Errorf("invalid Hook settings: %s", err)
+	}
+
+	if err := c.ReadDeadlineStrategy.WithDefault().verify(); err != nil {
+		return fmt.Errorf("invalid ReadDeadlineStrategy: %s", err)
+	}
+
+	if _, err := forward.ParsePolicy(c.ReverseForwardRules); err != nil {
+		return fmt.Errorf("invalid ReverseForwardRules: %s", err)
+	}
+
+	if err := c.PushApproval.verify(); err != nil {
+		return fmt.Errorf("invalid PushApproval: %s", err)
+	}
+
+	if err := c.Audit.verify(); err != nil {
+		return fmt.Errorf("invalid Audit: %s", err)
+	}
+
+	if err := c.Tracing.verify(); err != nil {
+		return fmt.Errorf("invalid Tracing: %s", err)
+	}
+
+	if err := c.LoginLimit.verify(); err != nil {
+		return fmt.Errorf("invalid LoginLimit: %s", err)
+	}
+
+	if err := c.RiskScoring.verify(); err != nil {
+		return fmt.Errorf("invalid RiskScoring: %s", err)
+	}
+
+	if err := c.Kiosk.verify(); err != nil {
+		return fmt.Errorf("invalid Kiosk: %s", err)
+	}
+
+	// Otherwise the deep links could be used as the kiosk tokens
+	if len(c.Kiosk.Key) > 0 && c.Kiosk.Key == c.DeepLinkKey {
+		return errors.New("the Key of the Kiosk must not be the same as " +
+			"the DeepLinkKey")
+	}
+
+	if err := c.Recording.verify(); err != nil {
+		return fmt.Errorf("invalid Recording: %s", err)
+	}
+
+	if err := c.verifyStepUp(); err != nil {
+		return err
+	}
+
+	if err := c.SSHAlgorithms.verify(); err != nil {
+		return fmt.Errorf("invalid SSHAlgorithms: %s", err)
+	}
+
+	if err := c.SSHServerPolicy.verify(); err != nil {
+		return fmt.Errorf("invalid SSHServerPolicy: %s", err)
+	}
+
+	if err := c.verifyTerminalModes(); err != nil {
+		return err
+	}
+
+	if len(c.SSHAgentSocket) > 0 {
+		err := network.VerifyUnixSocketPath(c.SSHAgentSocket)
+		if err != nil {
+			return fmt.Errorf("invalid SSHAgentSocket: %s", err)
+		}
+	}
+
+	if len(c.PasskeyFile) > 0 && len(c.SharedKey) <= 0 {
+		return errors.New("PasskeyFile requires the SharedKey")
+	}
+
+	if len(c.PasskeyFile) > 0 && len(c.PasskeyRPID) <= 0 {
+		return errors.New("PasskeyFile requires the PasskeyRPID")
+	}
+
+	if len(c.ProvisionFile) > 0 && len(c.ManagementToken) <= 0 {
+		return errors.New("ProvisionFile requires the ManagementToken")
+	}
+
+	if len(c.ReplicateFrom) > 0 {
+		if err := verifyReplicateFrom(c.ReplicateFrom); err != nil {
+			return fmt.Errorf("invalid ReplicateFrom: %s", err)
+		}
+
+		if len(c.ReplicationToken) <= 0 {
+			return errors.New("ReplicateFrom requires the ReplicationToken")
+		}
+	}
+
+	if err := c.Inventory.verify(); err != nil {
+		return fmt.Errorf("invalid Inventory: %s", err)
+	}
+
+	for _, p := range c.TrustedProxies {
+		if _, err := parseTrustedProxy(p); err != nil {
+			return fmt.Errorf("invalid TrustedProxies %q: %s", p, err)
+		}
+	}
+
+	for name, cidrs := range c.UsageNetworks {
+		for _, cidr := range cidrs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid network %q of UsageNetworks "+
+					"%q: %s", cidr, name, err)
+			}
+		}
+	}
+
+	for _, p := range c.Presets {
+		if len(p.ProxyCommand) > 0 && len(p.ProxyCommand[0]) <= 0 {
+			return fmt.Errorf("invalid ProxyCommand of Preset %q: %s",
+				p.Title, network.ErrCommandDialEmptyCommand)
+		}
+
+		for _, tag := range p.Tags {
+			if len(tag) <= 0 || strings.Contains(tag, ",") {
+				return fmt.Errorf("invalid Tag %q of Preset %q: Tags must "+
+					"not be empty or contain \",\"", tag, p.Title)
+			}
+		}
+
+		if len(p.Watch) > 0 {
+			if err := watcher.VerifyCheck(p.Watch); err != nil {
+				return fmt.Errorf("invalid Watch of Preset %q: %s",
+					p.Title, err)
+			}
+		}
+
+		if err := p.verifyWireGuard(c.WireGuard); err != nil {
+			return fmt.Errorf("invalid WireGuard of Preset %q: %s",
+				p.Title, err)
+		}
+
+		if p.Weight < 0 || p.Weight > 100 {
+			return fmt.Errorf("invalid Weight of Preset %q: must be between "+
+				"0 and 100", p.Title)
+		}
+
+		if err := p.verifyForwards(); err != nil {
+			return fmt.Errorf("invalid Forwards of Preset %q: %s",
+				p.Title, err)
+		}
+
+		if err := p.verifySSHAgentSocket(); err != nil {
+			return fmt.Errorf("invalid SSHAgentSocket of Preset %q: %s",
+				p.Title, err)
+		}
+
+		if err := p.verifyExpectedFingerprint(); err != nil {
+			return fmt.Errorf("invalid ExpectedFingerprint of Preset %q: %s",
+				p.Title, err)
+		}
+
+		if err := p.verifyEnvironment(); err != nil {
+			return fmt.Errorf("invalid Environment of Preset %q: %s",
+				p.Title, err)
+		}
+
+		if !p.SSHAlgorithms.IsEmpty() {
+			if p.Type != "SSH" {
+				return fmt.Errorf("invalid SSHAlgorithms of Preset %q: "+
+					"only SSH Presets can set the algorithms", p.Title)
+			}
+
+			if err := p.SSHAlgorithms.verify(); err != nil {
+				return fmt.Errorf("invalid SSHAlgorithms of Preset %q: %s",
+					p.Title, err)
+			}
+		}
+
+		if err := p.verifyBootstrap(); err != nil {
+			return fmt.Errorf("invalid Bootstrap of Preset %q: %s",
+				p.Title, err)
+		}
+
+		if err := p.verifyAttach(); err != nil {
+			return fmt.Errorf("invalid Attach of Preset %q: %s",
+				p.Title, err)
+		}
+
+		if len(p.Command) > 0 && p.Type != "SSH" {
+			return fmt.Errorf("invalid Command of Preset %q: only SSH "+
+				"Presets can run a command", p.Title)
+		}
+
+		if err := p.verifyRecord(); err != nil {
+			return fmt.Errorf("invalid Record of Preset %q: %s",
+				p.Title, err)
+		}
+
+		if err := p.verifyTLS(); err != nil {
+			return fmt.Errorf("invalid TLS of Preset %q: %s", p.Title, err)
+		}
+
+		if err := p.verifySandbox(); err != nil {
+			return fmt.Errorf("invalid Sandbox of Preset %q: %s",
+				p.Title, err)
+		}
+
+		if p.FastStart {
+			if err := p.verifyFastStart(); err != nil {
+				return fmt.Errorf("invalid FastStart of Preset %q: %s",
+					p.Title, err)
+			}
+		}
+
+		path, ok := network.UnixSocketPath(p.Host)
+		if !ok {
+			continue
+		}
+
+		if err := network.VerifyUnixSocketPath(path); err != nil {
+			return fmt.Errorf("invalid unix socket Host of Preset %q: %s",
+				p.Title, err)
+		}
+	}
+
+	if err := c.verifyTenants(); err != nil {
+		return err
+	}
+
+	if len(c.Servers) <= 0 {
+		return errors.New("must specify at least one server")
+	}
+
+	for i, c := range c.Servers {
+		vErr := c.Verify()
+
+		if vErr == nil {
+			continue
+		}
+
+		return fmt.Errorf("invalid setting for server %d: %s", i, vErr)
+	}
+
+	return nil
+}
+
+// Dialer builds a Dialer
+func (c Configuration) Dialer() (network.Dial, error) {
+	dialTimeout := c.DialTimeout
+
+	if dialTimeout < 3 {
+		dialTimeout = 3
+	}
+
+	keepAlive := network.KeepAlive{
+		Idle:     c.TCPKeepAliveIdle,
+		Interval: c.TCPKeepAliveInterval,
+		Count:    c.TCPKeepAliveCount,
+	}
+
+	dnsCacheMaxTTL := c.DNSCacheMaxTTL
+	if dnsCacheMaxTTL <= 0 {
+		dnsCacheMaxTTL = 5 * time.Minute
+	}
+
+	resolver := network.NewResolver(
+		c.DNSCacheSize, dnsCacheMaxTTL, c.DNSCacheNegativeTTL)
+
+	dialer := network.TCPDial(keepAlive, resolver)
+
+	if len(c.Socks5) > 0 {
+		sDial, sDialErr := network.BuildSocks5Dial(
+			c.Socks5, c.socks5Credentials, keepAlive)
+
+		if sDialErr != nil {
+			return nil, fmt.Errorf("unable to build Socks5 Dialer: %s",
+				sDialErr)
+		}
+
+		dialer = sDial
+	}
+
+	if c.OnlyAllowPresetRemotes {
+		accessList := make(network.AllowedHosts, len(c.Presets))
+
+		for _, k := range c.Presets {
+			if len(k.Host) <= 0 {
+				continue
+			}
+
+			accessList[k.Host] = struct{}{}
+		}
+
+		dialer = network.AccessControlDial(accessList, dialer)
+	}
+
+	// Unix sockets, WireGuard targets and proxy commands can only be defined
+	// by the Presets, so they're not subject to the access control
+	if sockets := c.unixSockets(); len(sockets) > 0 {
+		dialer = network.UnixSocketDial(sockets, dialer)
+	}
+
+	if targets := c.wireGuardTargets(); len(targets) > 0 {
+		// The secrets are loaded again, and they may have been changed
+		// since Verify
+		settings, err := c.WireGuard.settings()
+		if err != nil {
+			return nil, fmt.Errorf("unable to build WireGuard Dialer: %s",
+				err)
+		}
+
+		dialer = network.WireGuardDial(settings, targets, dialer)
+	}
+
+	if commands := c.proxyCommands(); len(commands) > 0 {
+		dialer = network.CommandDial(commands, dialer)
+	}
+
+	return dialer, nil
+}
+
+// socks5Credentials loads the Socks5User and the Socks5Password, which can be
+// references to where the secrets are stored (i.e. "file://"). They're loaded
+// again for every connection, so the rotated secrets are picked up without
+// restarting
+func (c Configuration) socks5Credentials() (string, string, error) {
+	user, err := c.Socks5User.Parse()
+	if err != nil {
+		return "", "", fmt.Errorf("unable to load Socks5User: %s", err)
+	}
+
+	password, err := c.Socks5Password.Parse()
+	if err != nil {
+		return "", "", fmt.Errorf("unable to load Socks5Password: %s", err)
+	}
+
+	return user, password, nil
+}
+
+// unixSockets returns unix socket targets defined by the Presets
+func (c Configuration) unixSockets() network.UnixSockets {
+	sockets := network.UnixSockets{}
+
+	for _, p := range c.Presets {
+		if path, ok := network.UnixSocketPath(p.Host); ok {
+			sockets.Add(path)
+		}
+	}
+
+	return sockets
+}
+
+// proxyCommands returns the proxy commands defined by the Presets
+func (c Configuration) proxyCommands() network.ProxyCommands {
+	commands := network.ProxyCommands{}
+
+	for _, p := range c.Presets {
+		if len(p.ProxyCommand) <= 0 {
+			continue
+		}
+
+		commands[network.DialTarget{
+			Protocol: p.Type,
+			Address:  p.address(),
+		}] = p.ProxyCommand
+	}
+
+	return commands
+}
+
+// parseTrustedProxy parses the `proxy` of the TrustedProxies, which is either
+// a network in the CIDR format, or a single address
+func parseTrustedProxy(proxy string) (netip.Prefix, error) {
+	if !strings.Contains(proxy, "/") {
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+
+		addr = addr.Unmap()
+
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return prefix.Masked(), nil
+}
+
+// trustedProxies returns the parsed TrustedProxies
+func (c Configuration) trustedProxies() []netip.Prefix {
+	proxies := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, p := range c.TrustedProxies {
+		prefix, err := parseTrustedProxy(p)
+		if err != nil {
+			continue
+		}
+
+		proxies = append(proxies, prefix)
+	}
+
+	return proxies
+}
+
+// trafficClassifier returns a network.TrafficClassifier which groups the
+// remotes by the Presets and the UsageNetworks
+func (c Configuration) trafficClassifier() network.TrafficClassifier {
+	type usageNetwork struct {
+		name    string
+		network *net.IPNet
+	}
+
+	// The ports of the unix sockets are ignored by the dialer, so they're
+	// matched by the alias alone
+	presets := map[string]string{}
+	sockets := map[string]string{}
+	for _, p := range c.Presets {
+		if path, ok := network.UnixSocketPath(p.Host); ok {
+			sockets[network.UnixSocketAlias(path)] = "preset:" + p.Title
+		} else {
+			presets[p.address()] = "preset:" + p.Title
+		}
+	}
+
+	// The names are grouped by the IP address they're resolved to, which
+	// is only known when the remotes are dialed directly rather than
+	// through the Socks5 proxy
+	direct := len(c.Socks5) <= 0
+
+	networks := []usageNetwork{}
+	for name, cidrs := range c.UsageNetworks {
+		for _, cidr := range cidrs {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				continue
+			}
+
+			networks = append(networks, usageNetwork{
+				name:    "network:" + name,
+				network: n,
+			})
+		}
+	}
+
+	// Narrower network first
+	sort.SliceStable(networks, func(i, j int) bool {
+		iOnes, _ := networks[i].network.Mask.Size()
+		jOnes, _ := networks[j].network.Mask.Size()
+
+		return iOnes > jOnes
+	})
+
+	return func(address string, remote net.Addr) string {
+		if name, ok := presets[address]; ok {
+			return name
+		}
+
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+
+		if name, ok := sockets[host]; ok {
+			return name
+		}
+
+		ip := net.ParseIP(host)
+		if tcpAddr, ok := remote.(*net.TCPAddr); ip == nil && ok && direct {
+			ip = tcpAddr.IP
+		}
+
+		if ip == nil {
+			return "other"
+		}
+
+		for _, n := range networks {
+			if n.network.Contains(ip) {
+				return n.name
+			}
+		}
+
+		return "other"
+	}
+}
+
+// presetDefaultPorts contains the ports which the remotes listen on when
+// the Host of the Preset doesn't come with one, indexed by the Preset Type
+var presetDefaultPorts = map[string]string{
+	"SSH":       "22",
+	"Telnet":    "23",
+	"Conserver": "782",
+}
+
+// address returns the Host of the Preset with the default port of it's Type
+// added when it has none, which is the address the clients will dial
+func (p Preset) address() string {
+	port, ok := presetDefaultPorts[p.Type]
+	if !ok || len(p.Host) <= 0 {
+		return p.Host
+	}
+
+	if _, _, err := net.SplitHostPort(p.Host); err == nil {
+		return p.Host
+	}
+
+	return net.JoinHostPort(p.Host, port)
+}
+
+// presets returns the Presets with unix socket Hosts replaced by their
+// aliases, so the socket path will not be exposed to the client
+func (c Configuration) presets() []Preset {
+	presets := make([]Preset, len(c.Presets))
+
+	for i, p := range c.Presets {
+		if path, ok := network.UnixSocketPath(p.Host); ok {
+			p.Host = network.UnixSocketAlias(path)
+		}
+
+		presets[i] = p
+	}
+
+	return presets
+}
+
+func (c Configuration) watcher(
+	dial network.Dial,
+	presets []Preset,
+) *watcher.Watcher {
+	interval := c.WatchInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	targets := make([]watcher.Target, 0, len(presets))
+
+	for i, p := range presets {
+		if len(p.Watch) <= 0 {
+			continue
+		}
+
+		// Unix socket aliases don't come with a port, which will be ignored
+		// by the dialer anyway
+		address := p.address()
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, "0")
+		}
+
+		targets = append(targets, watcher.Target{
+			Preset:   i,
+			Title:    p.Title,
+			Address:  address,
+			Check:    p.Watch,
+			Protocol: p.Type,
+		})
+	}
+
+	return watcher.New(dial, interval, c.DialTimeout, targets)
+}
+
+// verifyForwards returns an error when the forwards defined by the Preset
+// are invalid
+func (p Preset) verifyForwards() error {
+	if len(p.Forwards) <= 0 {
+		return nil
+	}
+
+	if p.Type != "SSH" {
+		return errors.New("only SSH Presets can define forwards")
+	}
+
+	for name, target := range p.Forwards {
+		if !forwardNameVerifier.MatchString(name) {
+			return fmt.Errorf("invalid name %q: must only contain "+
+				"0-9, A-Z, a-z, \"_\", \".\" or \"-\"", name)
+		}
+
+		address, _ := forward.HTTPTarget(target)
+
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return fmt.Errorf("invalid target %q of %q: %s", target, name, err)
+		}
+
+		if len(host) <= 0 {
+			return fmt.Errorf("invalid target %q of %q: host is required",
+				target, name)
+		}
+
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return fmt.Errorf("invalid target %q of %q: invalid port",
+				target, name)
+		}
+	}
+
+	return nil
+}
+
+// verifyEnvironment returns an error when the environment variables of the
+// Preset can't be sent to the SSH server
+func (p Preset) verifyEnvironment() error {
+	if len(p.Environment) <= 0 {
+		return nil
+	}
+
+	if p.Type != "SSH" {
+		return errors.New("only SSH Presets can set environment variables")
+	}
+
+	if len(p.Environment) > 32 {
+		return errors.New("must not set more than 32 variables")
+	}
+
+	for name := range p.Environment {
+		if !environmentNameVerifier.MatchString(name) {
+			return fmt.Errorf("invalid name %q: must only contain 0-9, "+
+				"A-Z, a-z or \"_\", and not start with a digit", name)
+		}
+	}
+
+	return nil
+}
+
+// verifyRecord returns an error when the Preset can't be recorded
+func (p Preset) verifyRecord() error {
+	if !p.Record {
+		return nil
+	}
+
+	if p.Type != "SSH" && p.Type != "Telnet" {
+		return errors.New("only SSH and Telnet Presets can be recorded")
+	}
+
+	// The recordings would be the very trace the Preset asked not to leave
+	if p.NoTrace {
+		return errors.New("NoTrace Presets can't be recorded")
+	}
+
+	return nil
+}
+
+// verifyBootstrap returns an error when the Bootstrap snippet of the Preset
+// can't be written to the shell
+func (p Preset) verifyBootstrap() error {
+	if len(p.Bootstrap) <= 0 {
+		if p.HideBootstrap {
+			return errors.New("HideBootstrap requires a Bootstrap snippet")
+		}
+
+		return nil
+	}
+
+	if p.Type != "SSH" {
+		return errors.New("only SSH Presets can bootstrap the shell")
+	}
+
+	if len(p.Command) > 0 {
+		return errors.New("Presets which run a Command have no shell to " +
+			"bootstrap")
+	}
+
+	if len(p.Bootstrap) > 4096 {
+		return errors.New("must not be longer than 4096 bytes")
+	}
+
+	return nil
+}
+
+// verifyAttach returns an error when the Attach command of the Preset can't
+// be used to attach to a terminal multiplexer
+func (p Preset) verifyAttach() error {
+	if len(p.Attach) <= 0 {
+		return nil
+	}
+
+	if p.Type != "SSH" {
+		return errors.New("only SSH Presets can attach to a multiplexer")
+	}
+
+	if len(p.Command) > 0 {
+		return errors.New("Presets which run a Command can't attach to a " +
+			"multiplexer")
+	}
+
+	if len(p.Attach) > 512 {
+		return errors.New("must not be longer than 512 bytes")
+	}
+
+	// The program is checked for on the remote by the shell
+	fields := strings.Fields(p.Attach)
+	if len(fields) <= 0 || !attachProgramVerifier.MatchString(fields[0]) {
+		return errors.New("the name of the multiplexer must only contain " +
+			"0-9, A-Z, a-z, \"_\", \".\", \"/\" or \"-\"")
+	}
+
+	return nil
+}
+
+// verifySSHAgentSocket returns an error when the SSHAgentSocket is not an
+// usable socket path of a SSH Preset
+func (p Preset) verifySSHAgentSocket() error {
+	if len(p.SSHAgentSocket) <= 0 {
+		return nil
+	}
+
+	if p.Type != "SSH" {
+		return errors.New("only SSH Presets can forward the SSH agent")
+	}
+
+	return network.VerifyUnixSocketPath(p.SSHAgentSocket)
+}
+
+// verifyExpectedFingerprint returns an error when the ExpectedFingerprint is
+// not a SHA256 fingerprint of a SSH Preset
+func (p Preset) verifyExpectedFingerprint() error {
+	if len(p.ExpectedFingerprint) <= 0 {
+		return nil
+	}
+
+	if p.Type != "SSH" {
+		return errors.New("only SSH Presets can expect a fingerprint")
+	}
+
+	sum, ok := strings.CutPrefix(p.ExpectedFingerprint, "SHA256:")
+	if !ok {
+		return errors.New("must be a \"SHA256:\" fingerprint")
+	}
+
+	decoded, err := base64.RawStdEncoding.DecodeString(sum)
+	if err != nil || len(decoded) != sha256.Size {
+		return errors.New("invalid SHA256 fingerprint")
+	}
+
+	return nil
+}
+
+// verifyFastStart returns an error when the Preset doesn't carry everything
+// needed to establish a warm connection without user interaction
+func (p Preset) verifyFastStart() error {
+	if p.Type != "SSH" {
+		return errors.New("only SSH Presets can be started fast")
+	}
+
+	if len(p.Meta["User"]) <= 0 || len(p.Meta["Fingerprint"]) <= 0 {
+		return errors.New("Meta \"User\" and \"Fingerprint\" are required")
+	}
+
+	switch auth := p.Meta["Authentication"]; auth {
+	case "Password", "Private Key":
+		if len(p.Meta[auth]) <= 0 {
+			return fmt.Errorf("Meta %q is required", auth)
+		}
+	case "None":
+	default:
+		return errors.New("Meta \"Authentication\" must be one of " +
+			"\"Password\", \"Private Key\" or \"None\"")
+	}
+
+	return p.warmupTarget(0, SSHAlgorithms{}).Verify()
+}
+
+// warmupTarget returns the warmup.Target of the Preset which has the index
+// `i`. The `algos` are used unless the Preset specifies its own
+func (p Preset) warmupTarget(i int, algos SSHAlgorithms) warmup.Target {
+	algos = algos.Override(p.SSHAlgorithms)
+
+	t := warmup.Target{
+		Preset:            i,
+		Title:             p.Title,
+		Address:           p.Host,
+		User:              p.Meta["User"],
+		Fingerprint:       p.Meta["Fingerprint"],
+		Ciphers:           algos.Ciphers,
+		KeyExchanges:      algos.KeyExchanges,
+		MACs:              algos.MACs,
+		HostKeyAlgorithms: algos.HostKeyAlgorithms,
+	}
+
+	// The client always sends the address with a port
+	if _, _, err := net.SplitHostPort(t.Address); err != nil {
+		t.Address = net.JoinHostPort(t.Address, "22")
+	}
+
+	// Verified by Preset.verifyTLS
+	t.TLS, _ = p.TLS.Config(t.Address)
+
+	switch p.Meta["Authentication"] {
+	case "Password":
+		t.Password = p.Meta["Password"]
+	case "Private Key":
+		t.PrivateKey = p.Meta["Private Key"]
+	}
+
+	return t
+}
+
+func (c Configuration) warmup(
+	dial network.Dial,
+	presets []Preset,
+) *warmup.Pool {
+	targets := make([]warmup.Target, 0, len(presets))
+
+	for i, p := range presets {
+		if !p.FastStart {
+			continue
+		}
+
+		targets = append(targets, p.warmupTarget(i, c.SSHAlgorithms))
+	}
+
+	revalidate := c.FastStartRevalidation
+	if revalidate <= 0 {
+		revalidate = time.Minute
+	}
+
+	return warmup.New(dial, warmup.Settings{
+		Timeout:        c.DialTimeout,
+		Revalidate:     revalidate,
+		MaxConnections: c.FastStartConnections,
+		RekeyThreshold: c.SSHRekeyThreshold,
+	}, targets)
+}
+
+// verifyTerminalModes verifies the names of the TerminalModes
+func (c Configuration) verifyTerminalModes() error {
+	for name := range c.TerminalModes {
+		if _, ok := terminalModeOpcodes[name]; !ok {
+			return fmt.Errorf("invalid TerminalModes: unknown mode %q", name)
+		}
+	}
+
+	return nil
+}
+
+// terminalModes returns the TerminalModes by their opcodes
+func (c Configuration) terminalModes() map[uint8]uint32 {
+	modes := make(map[uint8]uint32, len(c.TerminalModes))
+
+	for name, val := range c.TerminalModes {
+		// Names are checked by Verify
+		op, ok := terminalModeOpcodes[name]
+		if !ok {
+			continue
+		}
+
+		modes[op] = val
+	}
+
+	return modes
+}
+
+// verifyStepUpMethod verifies that the step-up `method` can be used with
+// current settings
+func (c Configuration) verifyStepUpMethod(method stepup.Method) error {
+	switch method {
+	case stepup.METHOD_PASSWORD:
+		if len(c.SharedKey) <= 0 {
+			return fmt.Errorf("method %q requires the SharedKey", method)
+		}
+
+	case stepup.METHOD_TOTP:
+		if len(c.StepUpTOTPSecrets) <= 0 {
+			return fmt.Errorf(
+				"method %q requires the StepUpTOTPSecrets", method)
+		}
+	}
+
+	return nil
+}
+
+// verifyStepUp verifies the StepUpRules, the StepUpTOTPSecrets and the
+// step-up method of the RiskScoring
+func (c Configuration) verifyStepUp() error {
+	for i, r := range c.StepUpRules {
+		if err := r.verify(); err != nil {
+			return fmt.Errorf("invalid StepUpRules %d: %s", i, err)
+		}
+
+		if err := c.verifyStepUpMethod(r.Method); err != nil {
+			return fmt.Errorf("invalid StepUpRules %d: %s", i, err)
+		}
+	}
+
+	if len(c.RiskScoring.WebhookURL) > 0 && c.RiskScoring.StepUpScore > 0 {
+		err := c.verifyStepUpMethod(c.RiskScoring.StepUpMethod)
+		if err != nil {
+			return fmt.Errorf("invalid RiskScoring: %s", err)
+		}
+	}
+
+	for user, secret := range c.StepUpTOTPSecrets {
+		if _, err := stepup.ParseTOTPSecret(secret); err != nil {
+			return fmt.Errorf("invalid StepUpTOTPSecrets of %q: %s",
+				user, err)
+		}
+	}
+
+	return nil
+}
+
+// stepUpVerifier builds the stepup.Verifier
+func (c Configuration) stepUpVerifier() *stepup.Verifier {
+	secrets := make(map[string][]byte, len(c.StepUpTOTPSecrets))
+
+	for user, secret := range c.StepUpTOTPSecrets {
+		// Secrets are checked by Verify
+		s, err := stepup.ParseTOTPSecret(secret)
+		if err != nil {
+			continue
+		}
+
+		secrets[user] = s
+	}
+
+	return stepup.NewVerifier(c.SharedKey, secrets)
+}
+
+// Common settings shared by mulitple servers
+type Common struct {
+	HostName               string
+	SharedKey              string
+	Dialer                 network.Dial
+	DialTimeout            time.Duration
+	ReadDeadlineStrategy   ReadDeadlineStrategy
+	PromptTimeout          time.Duration
+	KeyVaultFile           string
+	KnownHostsFile         string
+	UserSettingsFile       string
+	PasskeyFile            string
+	PasskeyRPID            string
+	PasskeySessionLifetime time.Duration
+	SocketBinding          bool
+	TrustedProxies         []netip.Prefix
+	Usage                  *network.TrafficUsage
+	Streams                *streamstats.Registry
+	Broadcaster            *broadcast.Broadcaster
+	JournalFile            string
+	JournalPolicy          journal.Policy
+	ConnectNotice          string
+	Presets                []Preset
+	Watcher                *watcher.Watcher
+	UserHeader             string
+	UserGroupsHeader       string
+	SSHRekeyThreshold      uint64
+	SSHMaxPacketSize       int
+	SSHAlgorithms          SSHAlgorithms
+	SSHServerPolicy        SSHServerPolicy
+	SSHKeepaliveInterval   time.Duration
+	SSHKeepaliveCountMax   int
+	SSHReconnectAttempts   int
+	SSHReconnectMaxDelay   time.Duration
+	Handover               *handover.Registry
+	InterruptMuteTime      time.Duration
+	Relays                 *relay.Pool
+	TerminalModes          map[uint8]uint32
+	Warmup                 *warmup.Pool
+	ForwardBindHost        string
+	ReverseForwardPolicy   forward.Policy
+	ReverseForwards        *forward.Registry
+	Previews               *forward.Previews
+	AllowDynamicForwards   bool
+	AllowLocalForwards     bool
+	AllowFileTransfer      bool
+	SSHAgentSocket         string
+	Approver               *approval.Approver
+	Audit                  *audit.Dispatcher
+	Tracer                 *tracing.Tracer
+	LoginLimiter           *ratelimit.Limiter
+	RiskScorer             *risk.Scorer
+	Events                 *audit.Feed
+	ManagementToken        string
+	DeepLinks              deeplink.Signer
+	Kiosks                 *kiosk.Kiosk
+	Recorder               *recording.Recorder
+	RecordAllSessions      bool
+	Provision              *Provision
+	Mounted                *MountedWatcher
+	Replica                *Replica
+	Inventory              *InventoryWatcher
+	Remote                 *RemoteWatcher
+	Reload                 func()
+	Sessions               *upgrade.Sessions
+	Sharing                *sharing.Registry
+	Tenant                 string
+	Tenants                []TenantCommon
+	MaxClients             int
+	Clients                *upgrade.Sessions
+	StepUpRules            []StepUpRule
+	StepUpVerifier         *stepup.Verifier
+	Hooks                  HookSettings
+	OnlyAllowPresetRemotes bool
+	Sandboxes              network.AllowedTargets
+	Classroom              *classroom.Classroom
+}
+
+// hookSettings returns Hooks settings
+func (c Configuration) hookSettings() HookSettings {
+	return HookSettings{
+		Timeout:      c.HookTimeout,
+		AsyncWorkers: c.AsyncHookWorkers,
+		AsyncQueue:   c.AsyncHookQueue,
+		Hooks:        c.Hooks,
+	}
+}
+
+// journalPolicy returns the journal.Policy
+func (c Configuration) journalPolicy() journal.Policy {
+	return journal.Policy{
+		Retention: c.JournalRetention,
+		MaxEvents: c.JournalMaxEvents,
+	}
+}
+
+// Common returns common settings
+func (c Configuration) Common() (Common, error) {
+	rawDialer, err := c.Dialer()
+	if err != nil {
+		return Common{}, err
+	}
+
+	usage := network.NewTrafficUsage()
+	dialer := network.TrafficDial(usage, c.trafficClassifier(), rawDialer)
+	presets := c.presets()
+
+	// Rules are checked by Verify
+	reverseForwardPolicy, _ := forward.ParsePolicy(c.ReverseForwardRules)
+
+	return Common{
+		HostName:               c.HostName,
+		SharedKey:              c.SharedKey,
+		Dialer:                 dialer,
+		DialTimeout:            c.DialTimeout,
+		ReadDeadlineStrategy:   c.ReadDeadlineStrategy.WithDefault(),
+		PromptTimeout:          c.PromptTimeout,
+		KeyVaultFile:           c.KeyVaultFile,
+		KnownHostsFile:         c.KnownHostsFile,
+		UserSettingsFile:       c.UserSettingsFile,
+		PasskeyFile:            c.PasskeyFile,
+		PasskeyRPID:            c.PasskeyRPID,
+		PasskeySessionLifetime: c.PasskeySessionLifetime,
+		SocketBinding:          c.SocketBinding,
+		TrustedProxies:         c.trustedProxies(),
+		Usage:                  usage,
+		Streams:                streamstats.NewRegistry(),
+		Broadcaster:            broadcast.New(),
+		JournalFile:            c.JournalFile,
+		JournalPolicy:          c.journalPolicy(),
+		ConnectNotice:          c.ConnectNotice,
+		Presets:                presets,
+		Watcher:                c.watcher(rawDialer, presets),
+		UserHeader:             c.UserHeader,
+		UserGroupsHeader:       c.UserGroupsHeader,
+		SSHRekeyThreshold:      c.SSHRekeyThreshold,
+		SSHMaxPacketSize:       c.SSHMaxPacketSize,
+		SSHAlgorithms:          c.SSHAlgorithms,
+		SSHServerPolicy:        c.SSHServerPolicy.WithDefault(),
+		SSHKeepaliveInterval:   c.SSHKeepaliveInterval,
+		SSHKeepaliveCountMax:   c.SSHKeepaliveCountMax,
+		SSHReconnectAttempts:   c.SSHReconnectAttempts,
+		SSHReconnectMaxDelay:   c.SSHReconnectMaxDelay,
+		Handover:               c.handover(),
+		InterruptMuteTime:      c.InterruptMuteTime,
+		Relays:                 c.relays(),
+		TerminalModes:          c.terminalModes(),
+		Warmup:                 c.warmup(dialer, presets),
+		ForwardBindHost:        c.ForwardBindHost,
+		ReverseForwardPolicy:   reverseForwardPolicy,
+		ReverseForwards:        forward.NewRegistry(),
+		Previews:               forward.NewPreviews(),
+		AllowDynamicForwards:   c.AllowDynamicForwards,
+		AllowLocalForwards:     c.AllowLocalForwards,
+		AllowFileTransfer:      c.AllowFileTransfer,
+		SSHAgentSocket:         c.SSHAgentSocket,
+		Approver:               c.PushApproval.approver(),
+		Audit:                  c.Audit.dispatcher(),
+		Tracer:                 c.Tracing.tracer(),
+		LoginLimiter:           c.LoginLimit.limiter(),
+		RiskScorer:             c.RiskScoring.scorer(),
+		Events:                 c.events(),
+		ManagementToken:        c.ManagementToken,
+		DeepLinks:              deeplink.New(c.DeepLinkKey),
+		Kiosks: kiosk.New(
+			c.Kiosk.Key, c.Kiosk.KeepaliveInterval),
+		Recorder:               c.Recording.recorder(),
+		RecordAllSessions:      c.Recording.AllSessions,
+		Provision:              c.provision(),
+		Mounted:                c.mountedWatcher(),
+		Replica:                c.replica(),
+		Inventory:              c.inventoryWatcher(),
+		Remote:                 c.remoteWatcher(),
+		Reload:                 func() {},
+		Sharing:                sharing.New(),
+		Tenant:                 "",
+		Tenants:                c.tenants(rawDialer, dialer),
+		MaxClients:             0,
+		Clients:                nil,
+		StepUpRules:            c.StepUpRules,
+		StepUpVerifier:         c.stepUpVerifier(),
+		Hooks:                  c.hookSettings(),
+		OnlyAllowPresetRemotes: c.OnlyAllowPresetRemotes,
+		Sandboxes:              c.sandboxes(),
+		Classroom:              c.classroom(),
+	}, nil
+}
+
+// handover builds the handover.Registry of the parked sessions, or nil when
+// the handover is disabled
+func (c Configuration) handover() *handover.Registry {
+	return handover.New(c.HandoverLifetime, c.HandoverMaxSessions)
+}
+
+// classroom builds the classroom.Classroom of the classes, or nil when the
+// management API is disabled, as the classes are opened through it
+func (c Configuration) classroom() *classroom.Classroom {
+	if len(c.ManagementToken) <= 0 {
+		return nil
+	}
+
+	return classroom.New()
+}
+
+// relays builds the relay.Pool of the output of the remote sessions
+func (c Configuration) relays() *relay.Pool {
+	return relay.New(relay.Settings{
+		BufferSize: c.RelayBufferSize,
+		QueueSize:  c.RelayQueueSize,
+		MaxBuffers: c.RelayMaxBuffers,
+	})
+}
+
+// events builds the audit.Feed of the management API, or nil when the
+// management API is disabled
+func (c Configuration) events() *audit.Feed {
+	if len(c.ManagementToken) <= 0 {
+		return nil
+	}
+
+	return audit.NewFeed()
 }
 
 // DecideDialTimeout will return a reasonable timeout for dialing
@@ -0,0 +1,1288 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/keyvault"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/network"
+)
+
+func TestHookCommandUnmarshalJSON(t *testing.T) {
+	hooks := Hooks{}
+
+	err := json.Unmarshal([]byte(`{
+		"before_connecting": [
+			["/bin/true"],
+			{
+				"Command": ["/bin/false"],
+				"When": {"Networks": ["10.0.0.0/8"], "UserGroups": ["dev"]},
+				"OnFailure": "continue"
+			}
+		]
+	}`), &hooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hooks.verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	chain := hooks[HOOK_BEFORE_CONNECTING]
+	if len(chain) != 2 {
+		t.Fatalf("Expecting 2 Hook commands, got %d", len(chain))
+	}
+
+	if chain[0].Command[0] != "/bin/true" ||
+		chain[0].SuccessAction() != HOOK_ACTION_CONTINUE ||
+		chain[0].FailureAction() != HOOK_ACTION_DENY {
+		t.Errorf("Unexpected Hook command %v", chain[0])
+	}
+
+	if chain[1].Command[0] != "/bin/false" ||
+		chain[1].When.Networks[0] != "10.0.0.0/8" ||
+		chain[1].When.UserGroups[0] != "dev" ||
+		chain[1].FailureAction() != HOOK_ACTION_CONTINUE {
+		t.Errorf("Unexpected Hook command %v", chain[1])
+	}
+
+	hooks[HOOK_BEFORE_CONNECTING][1].OnSuccess = "retry"
+	if hooks.verify() == nil {
+		t.Error("Expecting invalid Hook action to be rejected")
+	}
+}
+
+func TestPresetVerifyFastStart(t *testing.T) {
+	p := Preset{
+		Title: "Test",
+		Type:  "SSH",
+		Host:  "localhost",
+		Meta: map[string]string{
+			"User":           "test",
+			"Fingerprint":    "SHA256:test",
+			"Authentication": "Password",
+		},
+		FastStart: true,
+	}
+
+	if err := p.verifyFastStart(); err == nil {
+		t.Error("Expecting an error when the Password is missing")
+		return
+	}
+
+	p.Meta["Password"] = "test"
+
+	if err := p.verifyFastStart(); err != nil {
+		t.Error("Unexpected error:", err)
+		return
+	}
+
+	if a := p.warmupTarget(1, SSHAlgorithms{}).Address; a != "localhost:22" {
+		t.Errorf("Expecting the default port to be added, got %q", a)
+		return
+	}
+
+	p.Type = "Telnet"
+
+	if err := p.verifyFastStart(); err == nil {
+		t.Error("Expecting an error for non-SSH Preset")
+		return
+	}
+}
+
+func TestPresetVerifyExpectedFingerprint(t *testing.T) {
+	p := Preset{
+		Title:               "Test",
+		Type:                "SSH",
+		Host:                "localhost",
+		ExpectedFingerprint: "SHA256:bgO",
+	}
+
+	if err := p.verifyExpectedFingerprint(); err == nil {
+		t.Error("Expecting an error for truncated fingerprint")
+		return
+	}
+
+	p.ExpectedFingerprint = "SHA256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU"
+
+	if err := p.verifyExpectedFingerprint(); err != nil {
+		t.Error("Unexpected error:", err)
+		return
+	}
+
+	p.Type = "Telnet"
+
+	if err := p.verifyExpectedFingerprint(); err == nil {
+		t.Error("Expecting an error for non-SSH Preset")
+		return
+	}
+}
+
+func TestPresetVerifyEnvironment(t *testing.T) {
+	p := Preset{
+		Title: "Test",
+		Type:  "SSH",
+		Host:  "localhost",
+		Environment: map[string]string{
+			"LANG":      "C.UTF-8",
+			"_APP_MODE": "kiosk",
+		},
+	}
+
+	if err := p.verifyEnvironment(); err != nil {
+		t.Error("Unexpected error:", err)
+		return
+	}
+
+	p.Environment["2FA"] = "yes"
+
+	if err := p.verifyEnvironment(); err == nil {
+		t.Error("Expecting an error for name started with a digit")
+		return
+	}
+
+	p.Environment = map[string]string{"LANG": "C.UTF-8"}
+	p.Type = "Telnet"
+
+	if err := p.verifyEnvironment(); err == nil {
+		t.Error("Expecting an error for non-SSH Preset")
+		return
+	}
+}
+
+func TestPresetVerifyAttach(t *testing.T) {
+	p := Preset{
+		Title:  "Test",
+		Type:   "SSH",
+		Host:   "localhost",
+		Attach: "/usr/local/bin/tmux new -A -s ops",
+	}
+
+	if err := p.verifyAttach(); err != nil {
+		t.Error("Unexpected error:", err)
+		return
+	}
+
+	for _, a := range []string{"tmux;reboot", "$(reboot) new", "  "} {
+		p.Attach = a
+
+		if err := p.verifyAttach(); err == nil {
+			t.Errorf("Expecting an error for %q", a)
+			return
+		}
+	}
+
+	p.Attach = "screen"
+	p.Command = "top"
+
+	if err := p.verifyAttach(); err == nil {
+		t.Error("Expecting an error for Preset which runs a Command")
+		return
+	}
+}
+
+func TestPresetVerifyRecord(t *testing.T) {
+	p := Preset{Title: "Test", Type: "Telnet", Host: "localhost", Record: true}
+
+	if err := p.verifyRecord(); err != nil {
+		t.Error("Unexpected error:", err)
+		return
+	}
+
+	p.NoTrace = true
+
+	if err := p.verifyRecord(); err == nil {
+		t.Error("Expecting an error for NoTrace Preset")
+		return
+	}
+
+	p.NoTrace = false
+	p.Type = "Conserver"
+
+	if err := p.verifyRecord(); err == nil {
+		t.Error("Expecting an error for non-SSH, non-Telnet Preset")
+		return
+	}
+}
+
+func TestPresetVerifySandbox(t *testing.T) {
+	p := Preset{
+		Title:        "Test",
+		Type:         "SSH",
+		Host:         "sandbox:22",
+		ProxyCommand: []string{"docker", "run", "--rm", "-i", "sandbox"},
+		Sandbox:      &Sandbox{MaxDuration: time.Hour, MaxBytes: 1 << 20},
+	}
+
+	if err := p.verifySandbox(); err != nil {
+		t.Error("Unexpected error:", err)
+		return
+	}
+
+	for _, invalid := range []func(p Preset) Preset{
+		func(p Preset) Preset { p.Type = "Conserver"; return p },
+		func(p Preset) Preset { p.ProxyCommand = nil; return p },
+		func(p Preset) Preset { p.FastStart = true; return p },
+		func(p Preset) Preset {
+			p.Sandbox = &Sandbox{MaxBytes: 1}
+			return p
+		},
+		func(p Preset) Preset {
+			p.Sandbox = &Sandbox{MaxDuration: time.Hour}
+			return p
+		},
+	} {
+		if err := invalid(p).verifySandbox(); err == nil {
+			t.Errorf("Expecting an error for %+v", invalid(p))
+		}
+	}
+
+	c := Configuration{Presets: []Preset{{Type: "SSH", Host: "other:22"}}}
+	if c.sandboxes() != nil {
+		t.Error("Expecting no sandboxes")
+	}
+
+	p.Host = "sandbox"
+	c.Presets = append(c.Presets, p)
+	s := c.sandboxes()
+	if len(s) != 1 || !s.Allowed(network.DialTarget{
+		Protocol: "SSH",
+		Address:  "sandbox:22",
+	}) {
+		t.Errorf("Expecting the sandbox to be allowed, got %v", s)
+	}
+
+	if s.Allowed(network.DialTarget{
+		Protocol: "Telnet",
+		Address:  "sandbox:22",
+	}) {
+		t.Error("Expecting the sandbox to be refused to Telnet")
+	}
+}
+
+func TestPresetVerifyTLS(t *testing.T) {
+	p := Preset{
+		Title: "Test",
+		Type:  "SSH",
+		Host:  "ssh.example.com:443",
+		TLS:   &TLSWrap{ALPN: []string{"ssh"}},
+	}
+
+	if err := p.verifyTLS(); err != nil {
+		t.Error("Unexpected error:", err)
+		return
+	}
+
+	cfg, _ := p.TLS.Config(p.Host)
+	if cfg.ServerName != "ssh.example.com" || cfg.NextProtos[0] != "ssh" {
+		t.Errorf("Unexpected TLS config: %+v", cfg)
+	}
+
+	p.TLS.ServerName = "front.example.com"
+
+	cfg, _ = p.TLS.Config(p.Host)
+	if cfg.ServerName != "front.example.com" {
+		t.Errorf("Expecting the ServerName to be used, got %q",
+			cfg.ServerName)
+	}
+
+	for _, invalid := range []func(p Preset) Preset{
+		func(p Preset) Preset { p.Type = "Telnet"; return p },
+		func(p Preset) Preset { p.Host = "unix:/run/ssh.sock"; return p },
+		func(p Preset) Preset {
+			p.TLS = &TLSWrap{ALPN: []string{""}}
+			return p
+		},
+		func(p Preset) Preset {
+			p.TLS = &TLSWrap{CAFile: "/nonexistent/ca.pem"}
+			return p
+		},
+	} {
+		if err := invalid(p).verifyTLS(); err == nil {
+			t.Errorf("Expecting an error for %+v", invalid(p))
+		}
+	}
+}
+
+func TestSSHAlgorithms(t *testing.T) {
+	legacy := SSHAlgorithms{
+		Ciphers:           []string{"aes128-cbc", "3des-cbc"},
+		KeyExchanges:      []string{"diffie-hellman-group1-sha1"},
+		MACs:              []string{"hmac-sha1"},
+		HostKeyAlgorithms: []string{"ssh-rsa", "ssh-dss"},
+	}
+
+	if err := legacy.verify(); err != nil {
+		t.Error("Unexpected error:", err)
+		return
+	}
+
+	for _, a := range []SSHAlgorithms{
+		{Ciphers: []string{"aes128-ctr", "rot13"}},
+		{KeyExchanges: []string{"diffie-hellman-group0-sha1"}},
+		{MACs: []string{"hmac-md5"}},
+		{HostKeyAlgorithms: []string{"ssh-rsa", "ssh-foo"}},
+	} {
+		if err := a.verify(); err == nil {
+			t.Errorf("Expecting an error for %v", a)
+			return
+		}
+	}
+
+	o := SSHAlgorithms{
+		MACs: []string{"hmac-sha2-256-etm@openssh.com"},
+	}.Override(legacy)
+
+	if len(o.Ciphers) != 2 || o.MACs[0] != "hmac-sha1" {
+		t.Errorf("Unexpected override result: %v", o)
+		return
+	}
+
+	o = legacy.Override(SSHAlgorithms{
+		MACs: []string{"hmac-sha2-256-etm@openssh.com"},
+	})
+
+	if o.Ciphers[0] != "aes128-cbc" ||
+		o.MACs[0] != "hmac-sha2-256-etm@openssh.com" {
+		t.Errorf("Unexpected override result: %v", o)
+		return
+	}
+}
+
+func TestServerVerifyListenFamily(t *testing.T) {
+	for _, c := range []struct {
+		iface  string
+		family ListenFamily
+		valid  bool
+	}{
+		{"0.0.0.0", LISTEN_FAMILY_DEFAULT, true},
+		{"0.0.0.0", LISTEN_FAMILY_IPV4, true},
+		{"0.0.0.0", LISTEN_FAMILY_IPV6, false},
+		{"::", LISTEN_FAMILY_IPV4, false},
+		{"::", LISTEN_FAMILY_IPV6, true},
+		{"::1", LISTEN_FAMILY_DUAL, true},
+		{"::", "ipv5", false},
+	} {
+		err := Server{
+			ListenInterface: c.iface,
+			ListenFamily:    c.family,
+		}.Verify()
+
+		if (err == nil) != c.valid {
+			t.Errorf("Expecting %q of %q to be valid: %v, got %v",
+				c.iface, c.family, c.valid, err)
+		}
+	}
+}
+
+func TestTracing(t *testing.T) {
+	for endpoint, expected := range map[string]string{
+		"http://collector:4318":           "http://collector:4318/v1/traces",
+		"https://collector/":              "https://collector/v1/traces",
+		"https://collector/otlp/v1/spans": "https://collector/otlp/v1/spans",
+	} {
+		tracing := fileCfgTracing{Endpoint: endpoint}.build()
+
+		if tracing.Endpoint != expected {
+			t.Errorf("Expecting %q for %q, got %q",
+				expected, endpoint, tracing.Endpoint)
+		}
+
+		if err := tracing.verify(); err != nil {
+			t.Errorf("Unexpected error for %q: %s", endpoint, err)
+		}
+	}
+
+	if err := (Tracing{Endpoint: "ftp://collector"}).verify(); err == nil {
+		t.Error("Expecting an error for a non-HTTP Endpoint")
+	}
+}
+
+func TestTerminalModes(t *testing.T) {
+	cfg := Configuration{
+		TerminalModes: normalizeTerminalModes(map[string]uint32{
+			"iutf8":  1,
+			" ECHO ": 0,
+		}),
+	}
+
+	if err := cfg.verifyTerminalModes(); err != nil {
+		t.Error("Expecting the TerminalModes to be valid, got:", err)
+	}
+
+	modes := cfg.terminalModes()
+	if len(modes) != 2 || modes[42] != 1 || modes[53] != 0 {
+		t.Errorf("Unexpected terminal modes: %v", modes)
+	}
+
+	cfg.TerminalModes["NOPE"] = 1
+	if err := cfg.verifyTerminalModes(); err == nil {
+		t.Error("Expecting unknown terminal modes to be refused")
+	}
+}
+
+func TestSocks5Credentials(t *testing.T) {
+	passwordFile := filepath.Join(t.TempDir(), "password")
+
+	if err := os.WriteFile(passwordFile, []byte("first"), 0600); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	c := Configuration{
+		Socks5User:     "literal://user",
+		Socks5Password: String("file://" + passwordFile),
+	}
+
+	user, password, err := c.socks5Credentials()
+	if err != nil || user != "user" || password != "first" {
+		t.Errorf("Expecting %q and %q, got %q and %q (%v)",
+			"user", "first", user, password, err)
+	}
+
+	// The rotated password is loaded without building a new Configuration
+	if err := os.WriteFile(passwordFile, []byte("second"), 0600); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	_, password, err = c.socks5Credentials()
+	if err != nil || password != "second" {
+		t.Errorf("Expecting %q, got %q (%v)", "second", password, err)
+	}
+
+	os.Remove(passwordFile)
+
+	if _, _, err := c.socks5Credentials(); err == nil {
+		t.Error("Expecting an error when the password can't be loaded")
+	}
+}
+
+func TestProxyCommands(t *testing.T) {
+	c := Configuration{Presets: []Preset{
+		{Type: "SSH", Host: "a", ProxyCommand: []string{"a"}},
+		{Type: "Telnet", Host: "b", ProxyCommand: []string{"b"}},
+		{Type: "SSH", Host: "c:2222", ProxyCommand: []string{"c"}},
+		{Type: "SSH", Host: "d"},
+	}}
+
+	commands := c.proxyCommands()
+	if len(commands) != 3 {
+		t.Errorf("Expecting 3 proxy commands, got %v", commands)
+	}
+
+	// Indexed by the addresses the clients dial, with the default ports
+	for target, expected := range map[network.DialTarget]string{
+		{Protocol: "SSH", Address: "a:22"}:      "a",
+		{Protocol: "Telnet", Address: "b:23"}:   "b",
+		{Protocol: "SSH", Address: "c:2222"}:    "c",
+		{Protocol: "Telnet", Address: "a:22"}:   "",
+		{Protocol: "SSH", Address: "b:23"}:      "",
+		{Protocol: "Telnet", Address: "c:2222"}: "",
+	} {
+		cmd := commands[target]
+		if len(expected) <= 0 && cmd != nil {
+			t.Errorf("Expecting no command for %+v, got %v", target, cmd)
+		} else if len(expected) > 0 && (len(cmd) != 1 || cmd[0] != expected) {
+			t.Errorf("Expecting command %q for %+v, got %v",
+				expected, target, cmd)
+		}
+	}
+}
+
+func TestTrafficClassifier(t *testing.T) {
+	c := Configuration{
+		Presets: []Preset{
+			{Title: "A", Type: "SSH", Host: "a.example.com"},
+			{Title: "B", Type: "Telnet", Host: "b.example.com:2323"},
+			{Title: "S", Type: "SSH", Host: "unix:/run/s.sock"},
+		},
+		UsageNetworks: map[string][]string{
+			"Wide":   {"10.0.0.0/8"},
+			"Narrow": {"10.1.0.0/16"},
+		},
+	}
+
+	resolved := &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 22}
+	alias := network.UnixSocketAlias("/run/s.sock")
+
+	for _, test := range []struct {
+		address  string
+		remote   net.Addr
+		expected string
+	}{
+		{"a.example.com:22", nil, "preset:A"},
+		{"b.example.com:2323", nil, "preset:B"},
+		{net.JoinHostPort(alias, "22"), nil, "preset:S"},
+		{"a.example.com:2222", nil, "other"},
+		{"10.2.0.1:22", nil, "network:Wide"},
+		{"10.1.0.1:22", nil, "network:Narrow"},
+		{"c.example.com:22", resolved, "network:Narrow"},
+		{"c.example.com:22", nil, "other"},
+		{"192.0.2.1:22", nil, "other"},
+	} {
+		name := c.trafficClassifier()(test.address, test.remote)
+		if name != test.expected {
+			t.Errorf("Expecting %q for %q, got %q",
+				test.expected, test.address, name)
+		}
+	}
+
+	// The Socks5 proxy is what the connections reach
+	c.Socks5 = "127.0.0.1:1080"
+	name := c.trafficClassifier()("c.example.com:22", resolved)
+	if name != "other" {
+		t.Errorf("Expecting other through the Socks5 proxy, got %q", name)
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	c := Configuration{
+		TrustedProxies: []string{"10.0.0.1", "192.0.2.7/24", "::1"},
+	}
+
+	proxies := c.trustedProxies()
+	expected := []string{"10.0.0.1/32", "192.0.2.0/24", "::1/128"}
+
+	if len(proxies) != len(expected) {
+		t.Fatalf("Expecting %d proxies, got %v", len(expected), proxies)
+	}
+
+	for i := range expected {
+		if proxies[i].String() != expected[i] {
+			t.Errorf("Expecting %q, got %q", expected[i], proxies[i])
+		}
+	}
+
+	for _, p := range []string{"", "proxy.example.com", "10.0.0.0/33"} {
+		if _, err := parseTrustedProxy(p); err == nil {
+			t.Errorf("Expecting %q to be rejected", p)
+		}
+	}
+}
+
+func TestProvisionPut(t *testing.T) {
+	cfg := Configuration{
+		ManagementToken:   "token",
+		ProvisionFile:     filepath.Join(t.TempDir(), "provision.json"),
+		StepUpTOTPSecrets: map[string]string{"admin": "JBSWY3DPEHPK3PXP"},
+	}
+	verify := func(c Configuration) error { return c.verifyStepUp() }
+
+	p := cfg.provision()
+
+	data, etag, err := p.Get(ProvisionPresets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "[]" {
+		t.Errorf("Expecting empty Presets, got %s", data)
+	}
+
+	presets := []byte(`[{"Title": "Server", "Type": "SSH", "Host": "s:22"}]`)
+
+	_, _, err = p.Put(ProvisionPresets, presets, "", verify)
+	if !errors.Is(err, ErrProvisionPreconditionRequired) {
+		t.Errorf("Expecting ErrProvisionPreconditionRequired, got %v", err)
+	}
+
+	_, _, err = p.Put(ProvisionPresets, presets, "\"stale\"", verify)
+	if !errors.Is(err, ErrProvisionPreconditionFailed) {
+		t.Errorf("Expecting ErrProvisionPreconditionFailed, got %v", err)
+	}
+
+	newETag, changed, err := p.Put(ProvisionPresets, presets, etag, verify)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !changed || newETag == etag {
+		t.Errorf("Expecting the Presets to be changed")
+	}
+
+	sameETag, changed, err := p.Put(ProvisionPresets, presets, "*", verify)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changed || sameETag != newETag {
+		t.Errorf("Expecting an identical PUT to change nothing")
+	}
+
+	_, _, err = p.Put(ProvisionPresets,
+		[]byte(`[{"Title": "X", "Type": "SSH", "Host": "x:22",
+			"ProxyCommand": ["/bin/sh"]}]`), "*", verify)
+	if !errors.Is(err, ErrProvisionInvalid) {
+		t.Errorf("Expecting ProxyCommand to be rejected, got %v", err)
+	}
+
+	_, _, err = p.Put(ProvisionUsers,
+		[]byte(`{"admin": {"TOTPSecret": "JBSWY3DPEHPK3PXP"}}`), "*", verify)
+	if !errors.Is(err, ErrProvisionInvalid) {
+		t.Errorf("Expecting configured user to be rejected, got %v", err)
+	}
+
+	_, _, err = p.Put("hosts", []byte(`{}`), "*", verify)
+	if !errors.Is(err, ErrProvisionUnknownCollection) {
+		t.Errorf("Expecting ErrProvisionUnknownCollection, got %v", err)
+	}
+
+	merged, err := cfg.WithProvisioned()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(merged.Presets) != 1 || merged.Presets[0].Host != "s:22" {
+		t.Errorf("Expecting the provisioned Preset, got %v", merged.Presets)
+	}
+
+	data, etag, err = merged.provision().Get(ProvisionPresets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if etag != newETag {
+		t.Errorf("Expecting ETag %s, got %s (%s)", newETag, etag, data)
+	}
+}
+
+func TestProvisionPresets(t *testing.T) {
+	cfg := Configuration{
+		ManagementToken: "token",
+		ProvisionFile:   filepath.Join(t.TempDir(), "provision.json"),
+	}
+	verify := func(c Configuration) error { return nil }
+	p := cfg.provision()
+
+	etag := "*"
+
+	for _, title := range []string{"A", "B", "C"} {
+		index, newETag, err := p.AddPreset([]byte(`{"Title": "`+title+
+			`", "Type": "SSH", "Host": "h:22"}`), etag, verify)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if title == "C" && index != 2 {
+			t.Errorf("Expecting C to be added at 2, got %d", index)
+		}
+
+		etag = newETag
+	}
+
+	_, _, err := p.AddPreset([]byte(`{"Title": "D", "Type": "SSH",
+		"Host": "d:22", "ProxyCommand": ["/bin/sh"]}`), etag, verify)
+	if !errors.Is(err, ErrProvisionInvalid) {
+		t.Errorf("Expecting ProxyCommand to be rejected, got %v", err)
+	}
+
+	_, _, err = p.MovePreset(2, 0, "\"stale\"", verify)
+	if !errors.Is(err, ErrProvisionPreconditionFailed) {
+		t.Errorf("Expecting ErrProvisionPreconditionFailed, got %v", err)
+	}
+
+	etag, _, err = p.MovePreset(2, 0, etag, verify)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	etag, _, err = p.ReplacePreset(1,
+		[]byte(`{"Title": "A2", "Type": "SSH", "Host": "a:22"}`), etag, verify)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	etag, _, err = p.DeletePreset(2, etag, verify)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = p.DeletePreset(2, etag, verify)
+	if !errors.Is(err, ErrProvisionPresetNotFound) {
+		t.Errorf("Expecting ErrProvisionPresetNotFound, got %v", err)
+	}
+
+	data, currentETag, err := p.GetPreset(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if currentETag != etag || !strings.Contains(string(data), `"A2"`) {
+		t.Errorf("Unexpected Preset %s (%s)", data, currentETag)
+	}
+
+	merged, err := cfg.WithProvisioned()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(merged.Presets) != 2 || merged.Presets[0].Title != "C" ||
+		merged.Presets[1].Title != "A2" {
+		t.Errorf("Unexpected Presets %+v", merged.Presets)
+	}
+}
+
+func TestMountedWatcher(t *testing.T) {
+	configMap, secret := t.TempDir(), t.TempDir()
+	write := func(dir, name, content string) {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(configMap, "presets.json",
+		`[{"Title": "Server", "Type": "SSH", "Host": "s:22"}]`)
+	write(secret, "users.json", `{"admin": {"TOTPSecret": "JBSWY3DPEHPK3PXP"}}`)
+
+	cfg, err := Configuration{
+		MountedDirectories: []string{configMap, secret},
+	}.WithMounted()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cfg.Presets) != 1 || cfg.StepUpTOTPSecrets["admin"] == "" {
+		t.Fatalf("Expecting the mounted state, got %v and %v",
+			cfg.Presets, cfg.StepUpTOTPSecrets)
+	}
+
+	w := cfg.mountedWatcher()
+	verify := func(c Configuration) error { return c.verifyStepUp() }
+
+	if w.check(log.NewDitch(), verify) {
+		t.Error("Expecting no change")
+	}
+
+	write(secret, "users.json", `{"admin": {"TOTPSecret": "!"}}`)
+	if w.check(log.NewDitch(), verify) {
+		t.Error("Expecting the invalid change to be ignored")
+	}
+
+	write(secret, "users.json", `{"root": {"TOTPSecret": "JBSWY3DPEHPK3PXP"}}`)
+	if !w.check(log.NewDitch(), verify) {
+		t.Error("Expecting the change to be detected")
+	}
+}
+
+func TestReplica(t *testing.T) {
+	primaryDir, standbyDir := t.TempDir(), t.TempDir()
+	primaryCfg := Configuration{
+		ManagementToken: "token",
+		ProvisionFile:   filepath.Join(primaryDir, "provision.json"),
+	}
+	verify := func(c Configuration) error { return c.verifyStepUp() }
+
+	vault, err := keyvault.Open(filepath.Join(primaryDir, "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = vault.Add([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVz"+
+		"rm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"), "admin", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := ReplicationSource{
+		KeyVault:  vault,
+		Provision: primaryCfg.provision(),
+	}
+
+	_, _, err = source.Provision.Put(ProvisionPresets,
+		[]byte(`[{"Title": "Server", "Type": "SSH", "Host": "s:22"}]`),
+		"*", verify)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	primary := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != ReplicationPath ||
+				r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			data, etag, _ := source.Snapshot()
+			w.Header().Set("ETag", etag)
+
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+
+				return
+			}
+
+			w.Write(data)
+		}))
+	defer primary.Close()
+
+	standbyCfg := Configuration{
+		ReplicateFrom:    primary.URL + "/",
+		ReplicationToken: "wrong",
+		KeyVaultFile:     filepath.Join(standbyDir, "keys.json"),
+		ProvisionFile:    filepath.Join(standbyDir, "provision.json"),
+	}
+
+	if standbyCfg.replica().check(log.NewDitch(), verify) {
+		t.Error("Expecting the unauthorized replica to change nothing")
+	}
+
+	standbyCfg.ReplicationToken = "token"
+	r := standbyCfg.replica()
+
+	if !r.check(log.NewDitch(), verify) {
+		t.Error("Expecting the provision state to be replicated")
+	}
+
+	standbyVault, err := keyvault.Open(standbyCfg.KeyVaultFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := standbyVault.List(); len(keys) != 1 || keys[0].Owner != "admin" {
+		t.Errorf("Expecting the key vault to be replicated, got %v", keys)
+	}
+
+	standby, err := standbyCfg.WithProvisioned()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(standby.Presets) != 1 {
+		t.Errorf("Expecting 1 replicated Preset, got %d", len(standby.Presets))
+	}
+
+	if r.check(log.NewDitch(), verify) {
+		t.Error("Expecting an unchanged primary to change nothing")
+	}
+
+	_, _, err = source.Provision.Put(ProvisionUsers,
+		[]byte(`{"admin": {"TOTPSecret": "JBSWY3DPEHPK3PXP"}}`), "*", verify)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !standbyCfg.replica().check(log.NewDitch(), verify) {
+		t.Error("Expecting the changed users to be replicated")
+	}
+}
+
+func TestTenants(t *testing.T) {
+	for _, c := range []struct {
+		tenants []Tenant
+		valid   bool
+	}{
+		{[]Tenant{{Name: "a", HostNames: []string{"a.example.com"}}}, true},
+		{[]Tenant{{Name: "a", PathPrefix: "/a"}}, true},
+		{[]Tenant{{Name: "a b", PathPrefix: "/a"}}, false},
+		{[]Tenant{{Name: "a"}}, false},
+		{[]Tenant{{Name: "a", PathPrefix: "a"}}, false},
+		{[]Tenant{{Name: "a", PathPrefix: "/a/"}}, false},
+		{[]Tenant{{Name: "a", PathPrefix: "/sshwifty"}}, false},
+		{[]Tenant{{Name: "a", PathPrefix: "/a", MaxClients: -1}}, false},
+		{[]Tenant{
+			{Name: "a", PathPrefix: "/a", PasskeyFile: "passkeys.json"},
+		}, false},
+		{[]Tenant{
+			{Name: "a", PathPrefix: "/a"},
+			{Name: "a", PathPrefix: "/b"},
+		}, false},
+		{[]Tenant{
+			{Name: "a", HostNames: []string{"example.com"}},
+			{Name: "b", HostNames: []string{"example.com"}},
+		}, false},
+	} {
+		err := Configuration{
+			Servers: []Server{{ListenInterface: "127.0.0.1"}},
+			Tenants: c.tenants,
+		}.verifyTenants()
+
+		if (err == nil) != c.valid {
+			t.Errorf("Expecting %v to be valid: %v, got %v",
+				c.tenants, c.valid, err)
+		}
+	}
+
+	cfg := Configuration{
+		HostName:        "example.com",
+		SharedKey:       "main",
+		ManagementToken: "token",
+		Presets:         []Preset{{Title: "Main"}},
+		Tenants: []Tenant{{
+			Name:       "a",
+			HostNames:  []string{"A.example.com"},
+			MaxClients: 2,
+			SharedKey:  "a",
+			Presets:    []Preset{{Title: "A"}},
+		}},
+	}
+
+	tenants := cfg.tenants(nil, nil)
+	if len(tenants) != 1 || tenants[0].HostNames[0] != "a.example.com" {
+		t.Fatalf("Unexpected tenants %v", tenants)
+	}
+
+	common := tenants[0].Common(Common{
+		HostName:        cfg.HostName,
+		SharedKey:       cfg.SharedKey,
+		ManagementToken: cfg.ManagementToken,
+		Presets:         cfg.Presets,
+		Tenants:         tenants,
+	})
+
+	if common.Tenant != "a" || common.SharedKey != "a" ||
+		common.MaxClients != 2 || len(common.HostName) > 0 ||
+		len(common.ManagementToken) > 0 || len(common.Tenants) > 0 {
+		t.Errorf("Unexpected Common of the tenant %v", common)
+	}
+
+	if len(common.Presets) != 1 || common.Presets[0].Title != "A" {
+		t.Errorf("Expecting the Presets of the tenant, got %v", common.Presets)
+	}
+}
+
+func TestReloadable(t *testing.T) {
+	current := Configuration{
+		SharedKey: "key",
+		Servers: []Server{{
+			ListenInterface:       "127.0.0.1",
+			ListenPort:            8182,
+			TLSCertificateFile:    "cert.pem",
+			TLSCertificateKeyFile: "key.pem",
+		}},
+		Presets: []Preset{{Title: "a", Type: "SSH", Host: "a:22"}},
+		Hooks:   Hooks{},
+	}
+
+	for _, c := range []struct {
+		change     func(c *Configuration)
+		reloadable bool
+	}{
+		{func(c *Configuration) {}, true},
+		{func(c *Configuration) {
+			c.Presets = append(c.Presets,
+				Preset{Title: "b", Type: "SSH", Host: "b:22"})
+			c.OnlyAllowPresetRemotes = true
+			c.Socks5 = "localhost:1080"
+		}, true},
+		{func(c *Configuration) {
+			c.Servers = []Server{c.Servers[0]}
+			c.Servers[0].TLSCertificateFile = "renewed.pem"
+			c.Servers[0].ServerMessage = "Hello"
+		}, true},
+		{func(c *Configuration) { c.SharedKey = "changed" }, false},
+		{func(c *Configuration) {
+			c.Servers = []Server{c.Servers[0]}
+			c.Servers[0].ListenPort = 8183
+		}, false},
+		{func(c *Configuration) {
+			c.Servers = []Server{c.Servers[0]}
+			c.Servers[0].TLSCertificateFile = ""
+			c.Servers[0].TLSCertificateKeyFile = ""
+		}, false},
+		{func(c *Configuration) {
+			c.Servers = append(c.Servers, c.Servers[0])
+		}, false},
+	} {
+		changed := current
+		c.change(&changed)
+
+		if r := changed.Reloadable(current); r != c.reloadable {
+			t.Errorf("Expecting %+v to be reloadable: %v, got %v",
+				changed, c.reloadable, r)
+		}
+	}
+}
+
+func TestInventory(t *testing.T) {
+	devices := `{"name": "core-1", "primary_ip": {"address": "10.0.0.1/24"},
+		"tags": [{"name": "core"}, {"name": "a,b"}]}`
+
+	inventory := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Token token" {
+				w.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			if r.URL.Query().Get("offset") != "" {
+				w.Write([]byte(`{"next": null, "results": [` + devices +
+					`, {"name": "no-address", "primary_ip": null}]}`))
+
+				return
+			}
+
+			w.Write([]byte(`{"next": "http://` + r.Host +
+				`/api/dcim/devices/?offset=2", "results": [{"name": "edge-1",
+				"primary_ip": {"address": "2001:db8::1/64"}, "tags": []}]}`))
+		}))
+	defer inventory.Close()
+
+	c := Configuration{
+		Presets: []Preset{{Title: "Local", Type: "SSH", Host: "l:22"}},
+		Inventory: fileCfgInventory{
+			URL:       inventory.URL + "/api/dcim/devices/",
+			Format:    "NetBox",
+			Token:     "literal://token",
+			CacheFile: filepath.Join(t.TempDir(), "inventory.json"),
+			Meta:      map[string]string{"User": "admin"},
+		}.build(),
+	}
+
+	synced, err := c.WithInventory(log.NewDitch())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(synced.Presets) != 3 ||
+		synced.Presets[1].Title != "edge-1" ||
+		synced.Presets[1].Host != "[2001:db8::1]:22" ||
+		synced.Presets[2].Title != "core-1" ||
+		synced.Presets[2].Host != "10.0.0.1:22" ||
+		len(synced.Presets[2].Tags) != 1 ||
+		synced.Presets[2].Meta["User"] != "admin" {
+		t.Errorf("Unexpected Presets: %+v", synced.Presets)
+	}
+
+	watcher := synced.inventoryWatcher()
+
+	if watcher.check(log.NewDitch()) {
+		t.Error("Expecting the unchanged inventory to be left alone")
+	}
+
+	devices = `{"name": "core-2", "primary_ip": {"address": "10.0.0.2/24"}}`
+
+	if !watcher.check(log.NewDitch()) {
+		t.Error("Expecting the change of the inventory to be found")
+	}
+
+	// Use the cached copy when the inventory is unreachable
+	inventory.Close()
+
+	cached, err := c.WithInventory(log.NewDitch())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cached.Presets) != 3 || cached.Presets[2].Title != "core-1" {
+		t.Errorf("Unexpected cached Presets: %+v", cached.Presets)
+	}
+
+	c.Inventory.CacheFile = ""
+
+	if _, err := c.WithInventory(log.NewDitch()); err == nil {
+		t.Error("Expecting an error when there is no cached copy")
+	}
+}
+
+func TestAnsibleInventory(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	write("group_vars/all.yml", "ansible_user: admin\n")
+	write("host_vars/web02.yml", "ansible_host: 10.0.0.12\n")
+
+	for _, c := range []struct {
+		file    string
+		content string
+	}{
+		{"hosts", `
+bastion.example.com:2222 ansible_user="ops"
+
+[web]
+web[01:02] ansible_port=22
+
+[web:vars]
+ansible_user=deploy # Overrides the one of all
+
+[prod:children]
+web
+`},
+		{"hosts.yml", `
+all:
+  hosts:
+    bastion.example.com:
+      ansible_port: 2222
+      ansible_user: ops
+  children:
+    prod:
+      children:
+        web:
+          hosts:
+            web[01:02]:
+              ansible_port: 22
+          vars:
+            ansible_user: deploy # Overrides the one of all
+`},
+	} {
+		cfg := Configuration{
+			Inventory: fileCfgInventory{
+				URL:    write(c.file, c.content),
+				Format: "ansible",
+			}.build(),
+		}
+
+		synced, err := cfg.WithInventory(log.NewDitch())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hosts := map[string]string{}
+		users := map[string]string{}
+		tags := map[string][]string{}
+
+		for _, p := range synced.Presets {
+			hosts[p.Title] = p.Host
+			users[p.Title] = p.Meta["User"]
+			tags[p.Title] = p.Tags
+		}
+
+		if len(synced.Presets) != 3 ||
+			hosts["bastion.example.com"] != "bastion.example.com:2222" ||
+			users["bastion.example.com"] != "ops" ||
+			hosts["web01"] != "web01:22" ||
+			hosts["web02"] != "10.0.0.12:22" ||
+			users["web02"] != "deploy" ||
+			len(tags["web01"]) != 2 || tags["web01"][0] != "prod" {
+			t.Errorf("Unexpected Presets of %q: %+v", c.file, synced.Presets)
+		}
+	}
+}
+
+func TestRemote(t *testing.T) {
+	content := atomic.Value{}
+	content.Store(`{"SharedKey": "first"}`)
+
+	source := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/sshwifty.json":
+				if r.Header.Get("Authorization") != "Bearer token" {
+					w.WriteHeader(http.StatusUnauthorized)
+
+					return
+				}
+
+				w.Write([]byte(content.Load().(string)))
+
+			case "/v1/kv/sshwifty/config":
+				if r.Header.Get("X-Consul-Token") != "token" {
+					w.WriteHeader(http.StatusForbidden)
+
+					return
+				}
+
+				w.Header().Set("X-Consul-Index", "10")
+				w.Write([]byte(content.Load().(string)))
+
+			case "/v3/kv/range":
+				req := map[string][]byte{}
+				json.NewDecoder(r.Body).Decode(&req)
+
+				if string(req["key"]) != "sshwifty/config" {
+					w.Write([]byte(`{}`))
+
+					return
+				}
+
+				json.NewEncoder(w).Encode(map[string]any{
+					"kvs": []map[string][]byte{{"value": []byte(content.Load().(string))}},
+				})
+
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer source.Close()
+
+	host := source.Listener.Addr().String()
+
+	for _, s := range []string{
+		source.URL + "/sshwifty.json",
+		"consul://" + host + "/sshwifty/config?dc=dc1",
+		"etcd://" + host + "/sshwifty/config",
+	} {
+		_, c, err := Remote(s, "literal://token", time.Second)(
+			log.NewDitch())
+		if err != nil {
+			t.Errorf("Unable to load %q: %s", s, err)
+
+			continue
+		}
+
+		if c.SharedKey != "first" || c.remote == nil {
+			t.Errorf("Unexpected configuration of %q: %+v", s, c)
+		}
+	}
+
+	for _, s := range []string{
+		"ftp://" + host + "/sshwifty.json",
+		"consul://" + host + "/",
+		"etcd://" + host + "/sshwifty/missing",
+	} {
+		if _, _, err := Remote(s, "", time.Second)(log.NewDitch()); err == nil {
+			t.Errorf("Expecting %q to fail", s)
+		}
+	}
+
+	_, c, err := Remote(source.URL+"/sshwifty.json", "literal://token",
+		10*time.Millisecond)(log.NewDitch())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := make(chan struct{}, 1)
+	watcher := c.remoteWatcher()
+	watcher.Start(log.NewDitch(), func() { changed <- struct{}{} })
+	defer watcher.Close()
+
+	content.Store(`{"SharedKey": "second"}`)
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Error("Expecting the change of the configuration to be found")
+	}
+}
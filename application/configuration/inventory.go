@@ -0,0 +1,513 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nirui/sshwifty/application/log"
+)
+
+const (
+	inventoryTimeout  = 30 * time.Second
+	inventoryMaxSize  = 16 * 1024 * 1024
+	inventoryMaxPages = 100
+)
+
+// Formats of the Inventory
+const (
+	InventoryFormatJSON    = "json"
+	InventoryFormatNetBox  = "netbox"
+	InventoryFormatAnsible = "ansible"
+)
+
+// Errors
+var (
+	ErrInventoryUnexpectedStatus = errors.New(
+		"unexpected response status")
+
+	ErrInventoryTooManyPages = errors.New(
+		"too many pages")
+)
+
+// Inventory is the external source of truth (i.e. NetBox) that the Presets
+// are synced from
+type Inventory struct {
+	URL       string // Or the path to a local file
+	Format    string
+	Token     String
+	Interval  time.Duration
+	CacheFile string
+	Type      string            // Type of the Presets built from the hosts
+	Port      uint16            // Port of the Presets built from the hosts
+	OutOfBand bool              // Connect to the out-of-band address
+	Meta      map[string]string // Meta of the Presets built from the hosts
+}
+
+// Enabled returns whether or not the Inventory is enabled
+func (i Inventory) Enabled() bool {
+	return len(i.URL) > 0
+}
+
+// remote returns whether or not the Inventory is fetched through HTTP
+// rather than read from a local file
+func (i Inventory) remote() bool {
+	return strings.HasPrefix(i.URL, "http://") ||
+		strings.HasPrefix(i.URL, "https://")
+}
+
+// verify verifies the Inventory
+func (i Inventory) verify() error {
+	if !i.Enabled() {
+		return nil
+	}
+
+	if i.remote() {
+		if err := verifyReplicateFrom(i.URL); err != nil {
+			return fmt.Errorf("invalid URL: %s", err)
+		}
+	} else if i.Format == InventoryFormatNetBox {
+		return errors.New("URL of NetBox must start with \"http://\" or " +
+			"\"https://\"")
+	}
+
+	switch i.Format {
+	case InventoryFormatJSON:
+	case InventoryFormatNetBox, InventoryFormatAnsible:
+		if i.Type != "SSH" && i.Type != "Telnet" {
+			return fmt.Errorf("Type %q is unsupported, must be \"SSH\" "+
+				"or \"Telnet\"", i.Type)
+		}
+
+	default:
+		return fmt.Errorf("Format %q is unsupported, must be %q, %q or %q",
+			i.Format, InventoryFormatJSON, InventoryFormatNetBox,
+			InventoryFormatAnsible)
+	}
+
+	if _, err := i.Token.Parse(); err != nil {
+		return fmt.Errorf("unable to load Token: %s", err)
+	}
+
+	return nil
+}
+
+// preset builds the Preset of the host `title` from the settings of the
+// Inventory. `user` is added to the Meta when it's not empty
+func (i Inventory) preset(
+	title, host string,
+	port uint16,
+	user string,
+	tags []string,
+) provisionedPreset {
+	meta := make(map[string]string, len(i.Meta)+1)
+	for k, v := range i.Meta {
+		meta[k] = v
+	}
+
+	if len(user) > 0 {
+		meta["User"] = user
+	}
+
+	if port <= 0 {
+		port = i.Port
+	}
+
+	return provisionedPreset{
+		Title: title,
+		Type:  i.Type,
+		Host:  net.JoinHostPort(host, strconv.FormatUint(uint64(port), 10)),
+		Meta:  meta,
+		Tags:  tags,
+	}
+}
+
+// inventoryTags returns the `names` that can be used as the tags of Presets
+func inventoryTags(names []string) []string {
+	tags := make([]string, 0, len(names))
+
+	for _, n := range names {
+		if len(n) <= 0 || strings.Contains(n, ",") {
+			continue
+		}
+
+		tags = append(tags, n)
+	}
+
+	return tags
+}
+
+// netboxPage is a page of the NetBox devices (or virtual machines)
+type netboxPage struct {
+	Next    *string        `json:"next"`
+	Results []netboxDevice `json:"results"`
+}
+
+type netboxAddress struct {
+	Address string `json:"address"`
+}
+
+type netboxTag struct {
+	Name string `json:"name"`
+}
+
+type netboxDevice struct {
+	Name      string         `json:"name"`
+	PrimaryIP *netboxAddress `json:"primary_ip"`
+	OOBIP     *netboxAddress `json:"oob_ip"`
+	Tags      []netboxTag    `json:"tags"`
+}
+
+// preset returns the Preset of the device, or false when the device has no
+// name or address to connect to
+func (d netboxDevice) preset(i Inventory) (provisionedPreset, bool) {
+	address := d.PrimaryIP
+	if i.OutOfBand {
+		address = d.OOBIP
+	}
+
+	if len(d.Name) <= 0 || address == nil {
+		return provisionedPreset{}, false
+	}
+
+	// Addresses are given with the prefix length, i.e. "10.0.0.1/24"
+	ip, _, _ := strings.Cut(address.Address, "/")
+	if net.ParseIP(ip) == nil {
+		return provisionedPreset{}, false
+	}
+
+	tags := make([]string, 0, len(d.Tags))
+	for _, t := range d.Tags {
+		tags = append(tags, t.Name)
+	}
+
+	return i.preset(d.Name, ip, 0, "", inventoryTags(tags)), true
+}
+
+// inventoryClient fetches the Presets from the Inventory
+type inventoryClient struct {
+	inventory Inventory
+	token     string
+	client    *http.Client
+}
+
+func newInventoryClient(i Inventory) inventoryClient {
+	// Verified by Inventory.verify
+	token, _ := i.Token.Parse()
+
+	return inventoryClient{
+		inventory: i,
+		token:     strings.TrimSpace(token),
+		client:    &http.Client{Timeout: inventoryTimeout},
+	}
+}
+
+// download returns the content of the `url`, or of the local file when the
+// Inventory is not remote
+func (c inventoryClient) download(url string) ([]byte, error) {
+	if !c.inventory.remote() {
+		f, err := os.Open(url)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return io.ReadAll(io.LimitReader(f, inventoryMaxSize))
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.inventory.Format != InventoryFormatAnsible {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	if len(c.token) > 0 {
+		if c.inventory.Format == InventoryFormatNetBox {
+			req.Header.Set("Authorization", "Token "+c.token)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(
+			"%w: %s", ErrInventoryUnexpectedStatus, resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, inventoryMaxSize))
+}
+
+// get downloads the `url` and decodes it into `v`
+func (c inventoryClient) get(url string, v any) error {
+	data, err := c.download(url)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// fetch downloads the Presets from the Inventory
+func (c inventoryClient) fetch() ([]provisionedPreset, error) {
+	switch c.inventory.Format {
+	case InventoryFormatNetBox:
+	case InventoryFormatAnsible:
+		return c.fetchAnsible()
+	default:
+		presets := []provisionedPreset{}
+
+		return presets, c.get(c.inventory.URL, &presets)
+	}
+
+	presets := make([]provisionedPreset, 0, 64)
+	next := c.inventory.URL
+
+	for i := 0; len(next) > 0; i++ {
+		if i >= inventoryMaxPages {
+			return nil, ErrInventoryTooManyPages
+		}
+
+		page := netboxPage{}
+
+		if err := c.get(next, &page); err != nil {
+			return nil, err
+		}
+
+		for _, d := range page.Results {
+			if p, ok := d.preset(c.inventory); ok {
+				presets = append(presets, p)
+			}
+		}
+
+		next = ""
+		if page.Next != nil {
+			next = *page.Next
+		}
+	}
+
+	return presets, nil
+}
+
+// inventoryDigest returns the encoded `presets` and their digest
+func inventoryDigest(presets []provisionedPreset) ([]byte, string, error) {
+	data, err := json.MarshalIndent(presets, "", "  ")
+	if err != nil {
+		return nil, "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return data, hex.EncodeToString(sum[:]), nil
+}
+
+// loadInventory fetches the Presets from the Inventory and writes them into
+// the CacheFile. When the Inventory is unreachable, the Presets are read from
+// the CacheFile instead, which is reported by `l`
+func loadInventory(
+	i Inventory,
+	l log.Logger,
+) ([]provisionedPreset, string, error) {
+	presets, err := newInventoryClient(i).fetch()
+	if err == nil {
+		data, digest, dErr := inventoryDigest(presets)
+		if dErr != nil {
+			return nil, "", dErr
+		}
+
+		if len(i.CacheFile) > 0 {
+			tmpPath := i.CacheFile + ".tmp"
+
+			wErr := os.WriteFile(tmpPath, data, 0600)
+			if wErr == nil {
+				wErr = os.Rename(tmpPath, i.CacheFile)
+			}
+			if wErr != nil {
+				l.Warning("Unable to write the inventory cache: %s", wErr)
+			}
+		}
+
+		return presets, digest, nil
+	}
+
+	if len(i.CacheFile) <= 0 {
+		return nil, "", fmt.Errorf("unable to fetch inventory: %s", err)
+	}
+
+	l.Warning("Unable to fetch inventory, using the cached copy: %s", err)
+
+	data, rErr := os.ReadFile(i.CacheFile)
+	if rErr != nil {
+		return nil, "", fmt.Errorf("unable to fetch inventory (%s), nor "+
+			"read the cached copy: %s", err, rErr)
+	}
+
+	cached := []provisionedPreset{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if dErr := decoder.Decode(&cached); dErr != nil {
+		return nil, "", fmt.Errorf("invalid inventory cache %q: %s",
+			i.CacheFile, dErr)
+	}
+
+	_, digest, dErr := inventoryDigest(cached)
+	if dErr != nil {
+		return nil, "", dErr
+	}
+
+	return cached, digest, nil
+}
+
+// WithInventory returns the configuration with the Presets in the Inventory
+// added
+func (c Configuration) WithInventory(l log.Logger) (Configuration, error) {
+	if !c.Inventory.Enabled() {
+		return c, nil
+	}
+
+	if err := c.Inventory.verify(); err != nil {
+		return Configuration{}, fmt.Errorf("invalid Inventory: %s", err)
+	}
+
+	presets, digest, err := loadInventory(c.Inventory, l)
+	if err != nil {
+		return Configuration{}, err
+	}
+
+	merged := make([]Preset, 0, len(c.Presets)+len(presets))
+	merged = append(merged, c.Presets...)
+
+	for _, p := range presets {
+		merged = append(merged, p.preset())
+	}
+
+	c.Presets = merged
+	c.inventoryDigest = digest
+
+	return c, nil
+}
+
+// InventoryWatcher checks the Inventory for changes periodically
+type InventoryWatcher struct {
+	client   inventoryClient
+	digest   string
+	interval time.Duration
+	closing  chan struct{}
+	wait     sync.WaitGroup
+}
+
+// inventoryWatcher builds the InventoryWatcher, or nil when the Inventory is
+// disabled
+func (c Configuration) inventoryWatcher() *InventoryWatcher {
+	if !c.Inventory.Enabled() {
+		return nil
+	}
+
+	return &InventoryWatcher{
+		client:   newInventoryClient(c.Inventory),
+		digest:   c.inventoryDigest,
+		interval: c.Inventory.Interval,
+		closing:  make(chan struct{}),
+	}
+}
+
+// Start starts watching. `changed` is called every time the Presets in the
+// Inventory have changed, so they can be loaded again
+func (w *InventoryWatcher) Start(l log.Logger, changed func()) {
+	if w == nil {
+		return
+	}
+
+	w.wait.Add(1)
+
+	go w.watch(l, changed)
+}
+
+// Close stops the watching
+func (w *InventoryWatcher) Close() {
+	if w == nil {
+		return
+	}
+
+	close(w.closing)
+	w.wait.Wait()
+}
+
+func (w *InventoryWatcher) watch(l log.Logger, changed func()) {
+	defer w.wait.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.closing:
+			return
+		}
+
+		if w.check(l) {
+			changed()
+		}
+	}
+}
+
+// check returns true when the Presets in the Inventory have changed
+func (w *InventoryWatcher) check(l log.Logger) bool {
+	presets, err := w.client.fetch()
+	if err != nil {
+		l.Warning("Unable to fetch inventory: %s", err)
+
+		return false
+	}
+
+	_, digest, err := inventoryDigest(presets)
+	if err != nil || digest == w.digest {
+		return false
+	}
+
+	// Only report the same content once
+	w.digest = digest
+
+	l.Info("Inventory has been changed, reloading")
+
+	return true
+}
@@ -0,0 +1,679 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ansibleAll       = "all"
+	ansibleUngrouped = "ungrouped"
+
+	// Max number of hosts a range pattern (i.e. "web[01:50]") expands to
+	ansibleMaxRange = 4096
+)
+
+// Errors
+var (
+	ErrAnsibleInvalidRange = errors.New(
+		"invalid host range")
+)
+
+// ansibleGroup is a group of the Ansible inventory
+type ansibleGroup struct {
+	vars     map[string]string
+	hosts    []string
+	children []string
+}
+
+// ansibleInventory is the hosts and groups read from an Ansible inventory
+type ansibleInventory struct {
+	groups map[string]*ansibleGroup
+	hosts  map[string]map[string]string
+	order  []string
+}
+
+func newAnsibleInventory() *ansibleInventory {
+	return &ansibleInventory{
+		groups: map[string]*ansibleGroup{},
+		hosts:  map[string]map[string]string{},
+		order:  []string{},
+	}
+}
+
+func (a *ansibleInventory) group(name string) *ansibleGroup {
+	g, ok := a.groups[name]
+	if !ok {
+		g = &ansibleGroup{vars: map[string]string{}}
+		a.groups[name] = g
+	}
+
+	return g
+}
+
+// addHost adds the host `name` to the `group`, with `vars` added to the ones
+// of the host
+func (a *ansibleInventory) addHost(
+	group, name string, vars map[string]string) {
+	hostVars, ok := a.hosts[name]
+	if !ok {
+		hostVars = map[string]string{}
+		a.hosts[name] = hostVars
+		a.order = append(a.order, name)
+	}
+
+	for k, v := range vars {
+		hostVars[k] = v
+	}
+
+	g := a.group(group)
+	g.hosts = append(g.hosts, name)
+}
+
+func (a *ansibleInventory) addChild(group, child string) {
+	a.group(child)
+
+	g := a.group(group)
+	g.children = append(g.children, child)
+}
+
+// ansibleValue returns the string form of the YAML value `v`
+func ansibleValue(v any) string {
+	switch vv := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return vv
+	default:
+		return fmt.Sprint(vv)
+	}
+}
+
+// ansibleUnquote removes the quotes around the INI value `v`
+func ansibleUnquote(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+
+	return v
+}
+
+// ansibleFields splits the INI host line `line` by the spaces outside of the
+// quotes, and stops at the comment
+func ansibleFields(line string) []string {
+	fields := []string{}
+	current := strings.Builder{}
+	quote := byte(0)
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case quote != 0:
+			current.WriteByte(c)
+			if c == quote {
+				quote = 0
+			}
+
+		case c == '"' || c == '\'':
+			quote = c
+			current.WriteByte(c)
+
+		case c == '#' && current.Len() <= 0:
+			i = len(line)
+
+		case c == ' ' || c == '\t':
+			if current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+
+		default:
+			current.WriteByte(c)
+		}
+	}
+
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+
+	return fields
+}
+
+// expandAnsibleHosts expands the range patterns in the host `name`, i.e.
+// "web[01:03]" to "web01", "web02" and "web03", and "db-[a:b]" to "db-a" and
+// "db-b"
+func expandAnsibleHosts(name string) ([]string, error) {
+	start := strings.IndexByte(name, '[')
+	if start < 0 {
+		return []string{name}, nil
+	}
+
+	end := strings.IndexByte(name[start:], ']')
+	if end < 0 {
+		return nil, fmt.Errorf("%w: %q", ErrAnsibleInvalidRange, name)
+	}
+
+	end += start
+	prefix, suffix := name[:start], name[end+1:]
+	bounds := strings.Split(name[start+1:end], ":")
+
+	if len(bounds) != 2 && len(bounds) != 3 {
+		return nil, fmt.Errorf("%w: %q", ErrAnsibleInvalidRange, name)
+	}
+
+	step := 1
+	if len(bounds) == 3 {
+		s, err := strconv.Atoi(bounds[2])
+		if err != nil || s <= 0 {
+			return nil, fmt.Errorf("%w: %q", ErrAnsibleInvalidRange, name)
+		}
+
+		step = s
+	}
+
+	items := []string{}
+
+	first, fErr := strconv.Atoi(bounds[0])
+	last, lErr := strconv.Atoi(bounds[1])
+
+	switch {
+	case fErr == nil && lErr == nil:
+		if last-first >= ansibleMaxRange*step {
+			return nil, fmt.Errorf("%w: %q", ErrAnsibleInvalidRange, name)
+		}
+
+		for n := first; n <= last; n += step {
+			items = append(items, fmt.Sprintf("%0*d", len(bounds[0]), n))
+		}
+
+	case len(bounds[0]) == 1 && len(bounds[1]) == 1:
+		for c := int(bounds[0][0]); c <= int(bounds[1][0]); c += step {
+			items = append(items, string(rune(c)))
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrAnsibleInvalidRange, name)
+	}
+
+	hosts := []string{}
+
+	// The suffix may contain more patterns
+	rests, err := expandAnsibleHosts(suffix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		for _, rest := range rests {
+			hosts = append(hosts, prefix+item+rest)
+		}
+	}
+
+	if len(hosts) > ansibleMaxRange {
+		return nil, fmt.Errorf("%w: %q", ErrAnsibleInvalidRange, name)
+	}
+
+	return hosts, nil
+}
+
+// parseAnsibleINI reads the Ansible inventory in the INI format
+func parseAnsibleINI(data []byte) (*ansibleInventory, error) {
+	a := newAnsibleInventory()
+	group, kind := ansibleUngrouped, ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 4096), inventoryMaxSize)
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if len(line) <= 0 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			group, kind, _ = strings.Cut(line[1:len(line)-1], ":")
+			group = strings.TrimSpace(group)
+			a.group(group)
+
+			if kind != "" && kind != "vars" && kind != "children" {
+				return nil, fmt.Errorf("line %d: section %q is unsupported",
+					lineNo, line)
+			}
+
+			continue
+		}
+
+		switch kind {
+		case "vars":
+			k, v, found := strings.Cut(line, "=")
+			if !found {
+				return nil, fmt.Errorf("line %d: variable must be given "+
+					"as \"key=value\"", lineNo)
+			}
+
+			v = strings.TrimSpace(v)
+
+			// Comments can follow the values which are not quoted
+			if len(v) > 0 && v[0] != '"' && v[0] != '\'' {
+				if i := strings.Index(v, " #"); i >= 0 {
+					v = strings.TrimSpace(v[:i])
+				}
+			}
+
+			a.group(group).vars[strings.TrimSpace(k)] = ansibleUnquote(v)
+
+		case "children":
+			a.addChild(group, ansibleFields(line)[0])
+
+		default:
+			fields := ansibleFields(line)
+			if len(fields) <= 0 {
+				continue
+			}
+
+			vars := map[string]string{}
+
+			for _, f := range fields[1:] {
+				k, v, found := strings.Cut(f, "=")
+				if !found {
+					return nil, fmt.Errorf("line %d: variable %q must be "+
+						"given as \"key=value\"", lineNo, f)
+				}
+
+				vars[k] = ansibleUnquote(v)
+			}
+
+			name := fields[0]
+
+			// "host:port" for the hosts that listen on another port
+			if h, p, found := strings.Cut(name, ":"); found &&
+				!strings.Contains(p, ":") {
+				if _, err := strconv.ParseUint(p, 10, 16); err == nil {
+					name = h
+					vars["ansible_port"] = p
+				}
+			}
+
+			names, err := expandAnsibleHosts(name)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNo, err)
+			}
+
+			for _, n := range names {
+				a.addHost(group, n, vars)
+			}
+		}
+	}
+
+	return a, scanner.Err()
+}
+
+// ansibleYAMLGroup is a group of the Ansible inventory in the YAML format
+type ansibleYAMLGroup struct {
+	Hosts    map[string]map[string]any    `yaml:"hosts"`
+	Vars     map[string]any               `yaml:"vars"`
+	Children map[string]*ansibleYAMLGroup `yaml:"children"`
+}
+
+func (g *ansibleYAMLGroup) add(a *ansibleInventory, name string) error {
+	group := a.group(name)
+
+	if g == nil {
+		return nil
+	}
+
+	for k, v := range g.Vars {
+		group.vars[k] = ansibleValue(v)
+	}
+
+	hosts := make([]string, 0, len(g.Hosts))
+	for h := range g.Hosts {
+		hosts = append(hosts, h)
+	}
+
+	sort.Strings(hosts)
+
+	for _, h := range hosts {
+		vars := make(map[string]string, len(g.Hosts[h]))
+		for k, v := range g.Hosts[h] {
+			vars[k] = ansibleValue(v)
+		}
+
+		names, err := expandAnsibleHosts(h)
+		if err != nil {
+			return err
+		}
+
+		for _, n := range names {
+			a.addHost(name, n, vars)
+		}
+	}
+
+	children := make([]string, 0, len(g.Children))
+	for c := range g.Children {
+		children = append(children, c)
+	}
+
+	sort.Strings(children)
+
+	for _, c := range children {
+		a.addChild(name, c)
+
+		if err := g.Children[c].add(a, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseAnsibleYAML reads the Ansible inventory in the YAML format
+func parseAnsibleYAML(data []byte) (*ansibleInventory, error) {
+	groups := map[string]*ansibleYAMLGroup{}
+
+	if err := yaml.Unmarshal(data, &groups); err != nil {
+		return nil, err
+	}
+
+	a := newAnsibleInventory()
+
+	names := make([]string, 0, len(groups))
+	for n := range groups {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	for _, n := range names {
+		if err := groups[n].add(a, n); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// readAnsibleVars reads the variables of the host or the group `name` from
+// the "host_vars" or the "group_vars" directory `dir`. The variables can be
+// put in a file named after the host or the group (with the ".yml", ".yaml"
+// or ".json" extension, or without one), or in the files of the directory
+// of that name
+func readAnsibleVars(dir, name string) (map[string]string, error) {
+	files := []string{}
+
+	for _, ext := range []string{"", ".yml", ".yaml", ".json"} {
+		p := filepath.Join(dir, name+ext)
+
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+
+		if !info.IsDir() {
+			files = append(files, p)
+
+			continue
+		}
+
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			if !e.IsDir() {
+				files = append(files, filepath.Join(p, e.Name()))
+			}
+		}
+	}
+
+	vars := map[string]string{}
+
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		values := map[string]any{}
+
+		// JSON is YAML as well
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("invalid variables %q: %s", f, err)
+		}
+
+		for k, v := range values {
+			vars[k] = ansibleValue(v)
+		}
+	}
+
+	return vars, nil
+}
+
+// withVarsDirectories adds the variables in the "group_vars" and the
+// "host_vars" directories next to the inventory file `path`. They override
+// the ones defined in the inventory
+func (a *ansibleInventory) withVarsDirectories(path string) error {
+	base := filepath.Dir(path)
+
+	for name, g := range a.groups {
+		vars, err := readAnsibleVars(
+			filepath.Join(base, "group_vars"), name)
+		if err != nil {
+			return err
+		}
+
+		for k, v := range vars {
+			g.vars[k] = v
+		}
+	}
+
+	for name, hostVars := range a.hosts {
+		vars, err := readAnsibleVars(filepath.Join(base, "host_vars"), name)
+		if err != nil {
+			return err
+		}
+
+		for k, v := range vars {
+			hostVars[k] = v
+		}
+	}
+
+	return nil
+}
+
+// hostGroups returns the groups of all hosts, including the parent groups,
+// ordered by their depth and then name, which is the order that Ansible
+// applies their variables in
+func (a *ansibleInventory) hostGroups() map[string][]string {
+	all := a.group(ansibleAll)
+	parents := map[string][]string{}
+	depths := map[string]int{ansibleAll: 0}
+	queue := []string{ansibleAll}
+
+	for name, g := range a.groups {
+		for _, c := range g.children {
+			parents[c] = append(parents[c], name)
+		}
+	}
+
+	// Groups that are not the child of any group belong to "all"
+	for name := range a.groups {
+		if name != ansibleAll && len(parents[name]) <= 0 {
+			all.children = append(all.children, name)
+			parents[name] = []string{ansibleAll}
+		}
+	}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		for _, c := range a.group(name).children {
+			if _, ok := depths[c]; ok {
+				continue
+			}
+
+			depths[c] = depths[name] + 1
+			queue = append(queue, c)
+		}
+	}
+
+	hostGroups := make(map[string][]string, len(a.hosts))
+
+	for name, g := range a.groups {
+		for _, h := range g.hosts {
+			hostGroups[h] = append(hostGroups[h], name)
+		}
+	}
+
+	for h, direct := range hostGroups {
+		found := map[string]bool{ansibleAll: true}
+		groups := []string{ansibleAll}
+		pending := direct
+
+		for len(pending) > 0 {
+			name := pending[0]
+			pending = pending[1:]
+
+			if found[name] {
+				continue
+			}
+
+			found[name] = true
+			groups = append(groups, name)
+			pending = append(pending, parents[name]...)
+		}
+
+		sort.Slice(groups, func(i, j int) bool {
+			if depths[groups[i]] != depths[groups[j]] {
+				return depths[groups[i]] < depths[groups[j]]
+			}
+
+			return groups[i] < groups[j]
+		})
+
+		hostGroups[h] = groups
+	}
+
+	return hostGroups
+}
+
+// ansibleVar returns the first one of the variables `names` that is set.
+// Templated values are skipped as they can't be rendered here
+func ansibleVar(vars map[string]string, names ...string) string {
+	for _, n := range names {
+		v := strings.TrimSpace(vars[n])
+
+		if len(v) > 0 && !strings.Contains(v, "{{") {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// presets builds the Presets of the hosts in the inventory
+func (a *ansibleInventory) presets(i Inventory) []provisionedPreset {
+	hostGroups := a.hostGroups()
+	presets := make([]provisionedPreset, 0, len(a.order))
+
+	for _, name := range a.order {
+		vars := map[string]string{}
+		tags := []string{}
+
+		for _, g := range hostGroups[name] {
+			for k, v := range a.groups[g].vars {
+				vars[k] = v
+			}
+
+			if g != ansibleAll && g != ansibleUngrouped {
+				tags = append(tags, g)
+			}
+		}
+
+		for k, v := range a.hosts[name] {
+			vars[k] = v
+		}
+
+		host := ansibleVar(vars, "ansible_host", "ansible_ssh_host")
+		if len(host) <= 0 {
+			host = name
+		}
+
+		port, _ := strconv.ParseUint(
+			ansibleVar(vars, "ansible_port", "ansible_ssh_port"), 10, 16)
+
+		presets = append(presets, i.preset(
+			name,
+			host,
+			uint16(port),
+			ansibleVar(vars, "ansible_user", "ansible_ssh_user"),
+			inventoryTags(tags),
+		))
+	}
+
+	return presets
+}
+
+// fetchAnsible reads the Presets from the Ansible inventory, which can be
+// either in the YAML or the INI format
+func (c inventoryClient) fetchAnsible() ([]provisionedPreset, error) {
+	data, err := c.download(c.inventory.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Inventories that are not named as YAML are tried as YAML first, as the
+	// INI ones are never valid YAML mappings
+	var a *ansibleInventory
+
+	switch strings.ToLower(filepath.Ext(c.inventory.URL)) {
+	case ".yml", ".yaml", ".json":
+		a, err = parseAnsibleYAML(data)
+
+	default:
+		if a, err = parseAnsibleYAML(data); err != nil {
+			a, err = parseAnsibleINI(data)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid Ansible inventory: %s", err)
+	}
+
+	if !c.inventory.remote() {
+		if err := a.withVarsDirectories(c.inventory.URL); err != nil {
+			return nil, err
+		}
+	}
+
+	return a.presets(c.inventory), nil
+}
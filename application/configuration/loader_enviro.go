@@ -50,10 +50,92 @@ func Enviro() Loader {
 	return func(log log.Logger) (string, Configuration, error) {
 		log.Info("Loading configuration from environment variables ...")
 
+		var wireGuard *WireGuard
+		if a := parseEnv("SSHWIFTY_WIREGUARD"); len(a) > 0 {
+			err := json.Unmarshal([]byte(a), &wireGuard)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_WIREGUARD: %s",
+					err,
+				)
+			}
+		}
+
 		dialTimeout, _ := strconv.ParseUint(
 			parseEnv("SSHWIFTY_DIALTIMEOUT"), 10, 32)
 		hookExecTimeout, _ := strconv.ParseUint(
 			parseEnv("SSHWIFTY_HOOKTIMEOUT"), 10, 32)
+		tcpKeepAliveIdle, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_TCPKEEPALIVEIDLE"), 10, 32)
+		tcpKeepAliveInterval, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_TCPKEEPALIVEINTERVAL"), 10, 32)
+		tcpKeepAliveCount, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_TCPKEEPALIVECOUNT"), 10, 32)
+		readDeadlineStrategy := ReadDeadlineStrategy(
+			parseEnv("SSHWIFTY_READDEADLINESTRATEGY"))
+		promptTimeout, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_PROMPTTIMEOUT"), 10, 32)
+		upgradeDrainTimeout, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_UPGRADEDRAINTIMEOUT"), 10, 32)
+		shutdownDrainTimeout, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_SHUTDOWNDRAINTIMEOUT"), 10, 32)
+		journalRetention, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_JOURNALRETENTION"), 10, 32)
+		passkeySessionLifetime, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_PASSKEYSESSIONLIFETIME"), 10, 32)
+		journalMaxEvents, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_JOURNALMAXEVENTS"), 10, 32)
+		dnsCacheSize, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_DNSCACHESIZE"), 10, 32)
+		dnsCacheMaxTTL, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_DNSCACHEMAXTTL"), 10, 32)
+		dnsCacheNegativeTTL, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_DNSCACHENEGATIVETTL"), 10, 32)
+		watchInterval, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_WATCHINTERVAL"), 10, 32)
+		asyncHookWorkers, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_ASYNCHOOKWORKERS"), 10, 32)
+		asyncHookQueue, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_ASYNCHOOKQUEUE"), 10, 32)
+		sshRekeyThreshold, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_SSHREKEYTHRESHOLD"), 10, 64)
+		sshMaxPacketSize, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_SSHMAXPACKETSIZE"), 10, 31)
+		sshKeepaliveInterval, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_SSHKEEPALIVEINTERVAL"), 10, 32)
+		sshKeepaliveCountMax, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_SSHKEEPALIVECOUNTMAX"), 10, 32)
+		sshReconnectAttempts, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_SSHRECONNECTATTEMPTS"), 10, 32)
+		sshReconnectMaxDelay, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_SSHRECONNECTMAXDELAY"), 10, 32)
+		handoverLifetime, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_HANDOVERLIFETIME"), 10, 32)
+		handoverMaxSessions, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_HANDOVERMAXSESSIONS"), 10, 32)
+		interruptMuteTime, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_INTERRUPTMUTETIME"), 10, 32)
+		relayBufferSize, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_RELAYBUFFERSIZE"), 10, 32)
+		relayQueueSize, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_RELAYQUEUESIZE"), 10, 32)
+		relayMaxBuffers, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_RELAYMAXBUFFERS"), 10, 32)
+		fastStartConnections, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_FASTSTARTCONNECTIONS"), 10, 32)
+		fastStartRevalidation, _ := strconv.ParseUint(
+			parseEnv("SSHWIFTY_FASTSTARTREVALIDATION"), 10, 32)
+
+		usageNetworks := map[string][]string{}
+		if u := parseEnv("SSHWIFTY_USAGENETWORKS"); len(u) > 0 {
+			err := json.Unmarshal([]byte(u), &usageNetworks)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_USAGENETWORKS: %s",
+					err,
+				)
+			}
+		}
 
 		hooks := make(map[HookType][]HookCommand)
 		if h := parseEnv("SSHWIFTY_HOOK_BEFORE_CONNECTING"); len(h) > 0 {
@@ -64,21 +146,262 @@ func Enviro() Loader {
 					err,
 				)
 			}
-			hooks[HOOK_BEFORE_CONNECTING] = []HookCommand{hookBeforeConnecting}
+			hooks[HOOK_BEFORE_CONNECTING] = []HookCommand{
+				{Command: hookBeforeConnecting},
+			}
+		}
+		var reverseForwardRules []string
+		if r := parseEnv("SSHWIFTY_REVERSEFORWARDRULES"); len(r) > 0 {
+			rules, err := parseJsonStringArray(r)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_REVERSEFORWARDRULES: %s",
+					err,
+				)
+			}
+			reverseForwardRules = rules
+		}
+		var trustedProxies []string
+		if p := parseEnv("SSHWIFTY_TRUSTEDPROXIES"); len(p) > 0 {
+			proxies, err := parseJsonStringArray(p)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_TRUSTEDPROXIES: %s",
+					err,
+				)
+			}
+			trustedProxies = proxies
+		}
+		var mountedDirectories []string
+		if d := parseEnv("SSHWIFTY_MOUNTEDDIRECTORIES"); len(d) > 0 {
+			dirs, err := parseJsonStringArray(d)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_MOUNTEDDIRECTORIES: %s",
+					err,
+				)
+			}
+			mountedDirectories = dirs
+		}
+		pushApproval := fileCfgPushApproval{}
+		if a := parseEnv("SSHWIFTY_PUSHAPPROVAL"); len(a) > 0 {
+			err := json.Unmarshal([]byte(a), &pushApproval)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_PUSHAPPROVAL: %s",
+					err,
+				)
+			}
+		}
+		auditCfg := fileCfgAudit{}
+		if a := parseEnv("SSHWIFTY_AUDIT"); len(a) > 0 {
+			err := json.Unmarshal([]byte(a), &auditCfg)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_AUDIT: %s",
+					err,
+				)
+			}
+		}
+		tracingCfg := fileCfgTracing{}
+		if a := parseEnv("SSHWIFTY_TRACING"); len(a) > 0 {
+			err := json.Unmarshal([]byte(a), &tracingCfg)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_TRACING: %s",
+					err,
+				)
+			}
+		}
+		loginLimit := fileCfgLoginLimit{}
+		if a := parseEnv("SSHWIFTY_LOGINLIMIT"); len(a) > 0 {
+			err := json.Unmarshal([]byte(a), &loginLimit)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_LOGINLIMIT: %s",
+					err,
+				)
+			}
+		}
+		var terminalModes map[string]uint32
+		if m := parseEnv("SSHWIFTY_TERMINALMODES"); len(m) > 0 {
+			err := json.Unmarshal([]byte(m), &terminalModes)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_TERMINALMODES: %s",
+					err,
+				)
+			}
+		}
+		sshAlgorithms := SSHAlgorithms{}
+		if a := parseEnv("SSHWIFTY_SSHALGORITHMS"); len(a) > 0 {
+			err := json.Unmarshal([]byte(a), &sshAlgorithms)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_SSHALGORITHMS: %s",
+					err,
+				)
+			}
+		}
+		sshServerPolicy := SSHServerPolicy{}
+		if p := parseEnv("SSHWIFTY_SSHSERVERPOLICY"); len(p) > 0 {
+			err := json.Unmarshal([]byte(p), &sshServerPolicy)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_SSHSERVERPOLICY: %s",
+					err,
+				)
+			}
+		}
+		kioskCfg := fileCfgKiosk{}
+		if k := parseEnv("SSHWIFTY_KIOSK"); len(k) > 0 {
+			err := json.Unmarshal([]byte(k), &kioskCfg)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_KIOSK: %s",
+					err,
+				)
+			}
+		}
+		inventoryCfg := fileCfgInventory{}
+		if i := parseEnv("SSHWIFTY_INVENTORY"); len(i) > 0 {
+			err := json.Unmarshal([]byte(i), &inventoryCfg)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_INVENTORY: %s",
+					err,
+				)
+			}
+		}
+		recordingCfg := fileCfgRecording{}
+		if r := parseEnv("SSHWIFTY_RECORDING"); len(r) > 0 {
+			err := json.Unmarshal([]byte(r), &recordingCfg)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_RECORDING: %s",
+					err,
+				)
+			}
+		}
+		riskScoring := fileCfgRiskScoring{}
+		if a := parseEnv("SSHWIFTY_RISKSCORING"); len(a) > 0 {
+			err := json.Unmarshal([]byte(a), &riskScoring)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_RISKSCORING: %s",
+					err,
+				)
+			}
+		}
+		var stepUpRules []StepUpRule
+		if r := parseEnv("SSHWIFTY_STEPUPRULES"); len(r) > 0 {
+			err := json.Unmarshal([]byte(r), &stepUpRules)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_STEPUPRULES: %s",
+					err,
+				)
+			}
+		}
+		stepUpTOTPSecrets := Meta{}
+		if t := parseEnv("SSHWIFTY_STEPUPTOTPSECRETS"); len(t) > 0 {
+			err := json.Unmarshal([]byte(t), &stepUpTOTPSecrets)
+			if err != nil {
+				return "", Configuration{}, fmt.Errorf(
+					"Unable to parse SSHWIFTY_STEPUPTOTPSECRETS: %s",
+					err,
+				)
+			}
 		}
 		cfg, cfgErr := fileCfgCommon{
-			HostName:       parseEnv("SSHWIFTY_HOSTNAME"),
-			SharedKey:      parseEnv("SSHWIFTY_SHAREDKEY"),
-			DialTimeout:    int(dialTimeout),
-			Socks5:         parseEnv("SSHWIFTY_SOCKS5"),
-			Socks5User:     parseEnv("SSHWIFTY_SOCKS5_USER"),
-			Socks5Password: parseEnv("SSHWIFTY_SOCKS5_PASSWORD"),
-			Hooks:          hooks,
-			HookTimeout:    int(hookExecTimeout),
-			Servers:        nil,
-			Presets:        nil,
+			HostName:             parseEnv("SSHWIFTY_HOSTNAME"),
+			SharedKey:            parseEnv("SSHWIFTY_SHAREDKEY"),
+			DialTimeout:          int(dialTimeout),
+			Socks5:               parseEnv("SSHWIFTY_SOCKS5"),
+			Socks5User:           String(parseEnv("SSHWIFTY_SOCKS5_USER")),
+			Socks5Password:       String(parseEnv("SSHWIFTY_SOCKS5_PASSWORD")),
+			WireGuard:            wireGuard,
+			TCPKeepAliveIdle:     int(tcpKeepAliveIdle),
+			TCPKeepAliveInterval: int(tcpKeepAliveInterval),
+			TCPKeepAliveCount:    int(tcpKeepAliveCount),
+			ReadDeadlineStrategy: readDeadlineStrategy,
+			PromptTimeout:        int(promptTimeout),
+			KeyVaultFile:         parseEnv("SSHWIFTY_KEYVAULTFILE"),
+			KnownHostsFile:       parseEnv("SSHWIFTY_KNOWNHOSTSFILE"),
+			UserSettingsFile:     parseEnv("SSHWIFTY_USERSETTINGSFILE"),
+			UsageNetworks:        usageNetworks,
+			JournalFile:          parseEnv("SSHWIFTY_JOURNALFILE"),
+			JournalRetention:     int(journalRetention),
+			JournalMaxEvents:     int(journalMaxEvents),
+			ConnectNotice:        String(parseEnv("SSHWIFTY_CONNECTNOTICE")),
+			DNSCacheSize:         int(dnsCacheSize),
+			DNSCacheMaxTTL:       int(dnsCacheMaxTTL),
+			DNSCacheNegativeTTL:  int(dnsCacheNegativeTTL),
+			WatchInterval:        int(watchInterval),
+			UserHeader:           parseEnv("SSHWIFTY_USERHEADER"),
+			UserGroupsHeader:     parseEnv("SSHWIFTY_USERGROUPSHEADER"),
+			SSHRekeyThreshold:    sshRekeyThreshold,
+			SSHMaxPacketSize:     int(sshMaxPacketSize),
+			SSHAlgorithms:        sshAlgorithms,
+			SSHServerPolicy:      sshServerPolicy,
+			SSHKeepaliveInterval: int(sshKeepaliveInterval),
+			SSHKeepaliveCountMax: int(sshKeepaliveCountMax),
+			SSHReconnectAttempts: int(sshReconnectAttempts),
+			SSHReconnectMaxDelay: int(sshReconnectMaxDelay),
+			HandoverLifetime:     int(handoverLifetime),
+			HandoverMaxSessions:  int(handoverMaxSessions),
+			InterruptMuteTime:    int(interruptMuteTime),
+			RelayBufferSize:      int(relayBufferSize),
+			RelayQueueSize:       int(relayQueueSize),
+			RelayMaxBuffers:      int(relayMaxBuffers),
+			TerminalModes:        terminalModes,
+			ForwardBindHost:      parseEnv("SSHWIFTY_FORWARDBINDHOST"),
+			ReverseForwardRules:  reverseForwardRules,
+			AllowDynamicForwards: len(
+				parseEnv("SSHWIFTY_ALLOWDYNAMICFORWARDS")) > 0,
+			AllowLocalForwards: len(
+				parseEnv("SSHWIFTY_ALLOWLOCALFORWARDS")) > 0,
+			AllowFileTransfer: len(
+				parseEnv("SSHWIFTY_ALLOWFILETRANSFER")) > 0,
+			SSHAgentSocket:       parseEnv("SSHWIFTY_SSHAGENTSOCKET"),
+			PushApproval:         pushApproval,
+			Audit:                auditCfg,
+			Tracing:              tracingCfg,
+			LoginLimit:           loginLimit,
+			RiskScoring:          riskScoring,
+			ManagementToken:      parseEnv("SSHWIFTY_MANAGEMENTTOKEN"),
+			DeepLinkKey:          parseEnv("SSHWIFTY_DEEPLINKKEY"),
+			Kiosk:                kioskCfg,
+			Recording:            recordingCfg,
+			StepUpRules:          stepUpRules,
+			StepUpTOTPSecrets:    stepUpTOTPSecrets,
+			Hooks:                hooks,
+			HookTimeout:          int(hookExecTimeout),
+			AsyncHookWorkers:     int(asyncHookWorkers),
+			AsyncHookQueue:       int(asyncHookQueue),
+			Servers:              nil,
+			Presets:              nil,
+			FastStartConnections: int(fastStartConnections),
+			FastStartRevalidation: int(
+				fastStartRevalidation),
+			PasskeyFile: parseEnv("SSHWIFTY_PASSKEYFILE"),
+			PasskeyRPID: parseEnv("SSHWIFTY_PASSKEYRPID"),
+			PasskeySessionLifetime: int(
+				passkeySessionLifetime),
+			SocketBinding: len(
+				parseEnv("SSHWIFTY_SOCKETBINDING")) > 0,
+			TrustedProxies: trustedProxies,
 			OnlyAllowPresetRemotes: len(
 				parseEnv("SSHWIFTY_ONLYALLOWPRESETREMOTES")) > 0,
+			ProvisionFile:      parseEnv("SSHWIFTY_PROVISIONFILE"),
+			MountedDirectories: mountedDirectories,
+			UpgradeDrainTimeout: int(
+				upgradeDrainTimeout),
+			ShutdownDrainTimeout: int(
+				shutdownDrainTimeout),
+			ReplicateFrom:    parseEnv("SSHWIFTY_REPLICATEFROM"),
+			ReplicationToken: parseEnv("SSHWIFTY_REPLICATIONTOKEN"),
+			Inventory:        inventoryCfg,
 		}.build()
 
 		if cfgErr != nil {
@@ -111,7 +434,9 @@ func Enviro() Loader {
 
 		cfgSer := fileCfgServer{
 			ListenInterface:       listenIface,
+			ListenFamily:          parseEnv("SSHWIFTY_LISTENFAMILY"),
 			ListenPort:            uint16(listenPort),
+			ReusePort:             len(parseEnv("SSHWIFTY_REUSEPORT")) > 0,
 			InitialTimeout:        int(initialTimeout),
 			ReadTimeout:           int(readTimeout),
 			WriteTimeout:          int(writeTimeout),
@@ -123,6 +448,21 @@ func Enviro() Loader {
 			ServerMessage:         parseEnv("SSHWIFTY_SERVERMESSAGE"),
 		}
 
+		servers := []Server{cfgSer.build()}
+
+		// The IPv6 interface is listened separately from the IPv4 one, so
+		// neither of them accepts the family of the other
+		listenIfaceV6 := parseEnv("SSHWIFTY_LISTENINTERFACEV6")
+		if len(listenIfaceV6) > 0 {
+			servers[0].ListenFamily = LISTEN_FAMILY_IPV4
+
+			cfgSerV6 := cfgSer
+			cfgSerV6.ListenInterface = listenIfaceV6
+			cfgSerV6.ListenFamily = string(LISTEN_FAMILY_IPV6)
+
+			servers = append(servers, cfgSerV6.build())
+		}
+
 		presets := make(fileCfgPresets, 0, 16)
 		presetStr := strings.TrimSpace(parseEnv("SSHWIFTY_PRESETS"))
 
@@ -142,18 +482,127 @@ func Enviro() Loader {
 				"unable to parse Preset data: %s", err)
 		}
 
+		stepUpSecrets, err := cfg.StepUpTOTPSecrets.Concretize()
+		if err != nil {
+			return enviroTypeName, Configuration{}, fmt.Errorf(
+				"unable to load StepUpTOTPSecrets: %s", err)
+		}
+
+		tenants := make(fileCfgTenants, 0, 4)
+		if a := strings.TrimSpace(parseEnv("SSHWIFTY_TENANTS")); len(a) > 0 {
+			jErr := json.Unmarshal([]byte(a), &tenants)
+			if jErr != nil {
+				return enviroTypeName, Configuration{}, fmt.Errorf(
+					"invalid \"SSHWIFTY_TENANTS\": %s", jErr)
+			}
+		}
+
+		concretizeTenants, err := tenants.concretize()
+		if err != nil {
+			return enviroTypeName, Configuration{}, err
+		}
+
+		promptWait := time.Duration(cfg.PromptTimeout) * time.Second
+		journalKeep := time.Duration(cfg.JournalRetention) * time.Hour
+		passkeyKeep := time.Duration(cfg.PasskeySessionLifetime) * time.Hour
+		dnsMaxTTL := time.Duration(cfg.DNSCacheMaxTTL) * time.Second
+		dnsNegativeTTL := time.Duration(cfg.DNSCacheNegativeTTL) * time.Second
+		watchEvery := time.Duration(cfg.WatchInterval) * time.Second
+		revalidateEvery := time.Duration(cfg.FastStartRevalidation) *
+			time.Second
+		keepaliveEvery := time.Duration(cfg.SSHKeepaliveInterval) *
+			time.Second
+		reconnectMaxDelay := time.Duration(cfg.SSHReconnectMaxDelay) *
+			time.Second
+		handoverKeep := time.Duration(cfg.HandoverLifetime) * time.Second
+		interruptMute := time.Duration(cfg.InterruptMuteTime) *
+			time.Millisecond
+
 		return enviroTypeName, Configuration{
-			HostName:               cfg.HostName,
-			SharedKey:              cfg.SharedKey,
-			DialTimeout:            time.Duration(cfg.DialTimeout) * time.Second,
-			Socks5:                 cfg.Socks5,
-			Socks5User:             cfg.Socks5User,
-			Socks5Password:         cfg.Socks5Password,
+			HostName:       cfg.HostName,
+			SharedKey:      cfg.SharedKey,
+			DialTimeout:    time.Duration(cfg.DialTimeout) * time.Second,
+			Socks5:         cfg.Socks5,
+			Socks5User:     cfg.Socks5User,
+			Socks5Password: cfg.Socks5Password,
+			WireGuard:      cfg.WireGuard,
+			TCPKeepAliveIdle: time.Duration(cfg.TCPKeepAliveIdle) *
+				time.Second,
+			TCPKeepAliveInterval: time.Duration(cfg.TCPKeepAliveInterval) *
+				time.Second,
+			TCPKeepAliveCount:      cfg.TCPKeepAliveCount,
+			ReadDeadlineStrategy:   cfg.ReadDeadlineStrategy,
+			PromptTimeout:          promptWait,
+			KeyVaultFile:           cfg.KeyVaultFile,
+			KnownHostsFile:         cfg.KnownHostsFile,
+			UserSettingsFile:       cfg.UserSettingsFile,
+			PasskeyFile:            cfg.PasskeyFile,
+			PasskeyRPID:            cfg.PasskeyRPID,
+			PasskeySessionLifetime: passkeyKeep,
+			SocketBinding:          cfg.SocketBinding,
+			TrustedProxies:         cfg.TrustedProxies,
+			UsageNetworks:          cfg.UsageNetworks,
+			JournalFile:            cfg.JournalFile,
+			JournalRetention:       journalKeep,
+			JournalMaxEvents:       cfg.JournalMaxEvents,
+			ConnectNotice:          string(cfg.ConnectNotice),
+			DNSCacheSize:           cfg.DNSCacheSize,
+			DNSCacheMaxTTL:         dnsMaxTTL,
+			DNSCacheNegativeTTL:    dnsNegativeTTL,
+			WatchInterval:          watchEvery,
+			UserHeader:             cfg.UserHeader,
+			UserGroupsHeader:       cfg.UserGroupsHeader,
+			SSHRekeyThreshold:      cfg.SSHRekeyThreshold,
+			SSHMaxPacketSize:       cfg.SSHMaxPacketSize,
+			SSHAlgorithms:          cfg.SSHAlgorithms,
+			SSHServerPolicy:        cfg.SSHServerPolicy,
+			SSHKeepaliveInterval:   keepaliveEvery,
+			SSHKeepaliveCountMax:   cfg.SSHKeepaliveCountMax,
+			SSHReconnectAttempts:   cfg.SSHReconnectAttempts,
+			SSHReconnectMaxDelay:   reconnectMaxDelay,
+			HandoverLifetime:       handoverKeep,
+			HandoverMaxSessions:    cfg.HandoverMaxSessions,
+			InterruptMuteTime:      interruptMute,
+			RelayBufferSize:        cfg.RelayBufferSize,
+			RelayQueueSize:         cfg.RelayQueueSize,
+			RelayMaxBuffers:        cfg.RelayMaxBuffers,
+			TerminalModes:          normalizeTerminalModes(cfg.TerminalModes),
+			FastStartConnections:   cfg.FastStartConnections,
+			FastStartRevalidation:  revalidateEvery,
+			ForwardBindHost:        cfg.ForwardBindHost,
+			ReverseForwardRules:    cfg.ReverseForwardRules,
+			AllowDynamicForwards:   cfg.AllowDynamicForwards,
+			AllowLocalForwards:     cfg.AllowLocalForwards,
+			AllowFileTransfer:      cfg.AllowFileTransfer,
+			SSHAgentSocket:         cfg.SSHAgentSocket,
+			PushApproval:           cfg.PushApproval.build(),
+			Audit:                  cfg.Audit.build(),
+			Tracing:                cfg.Tracing.build(),
+			LoginLimit:             cfg.LoginLimit.build(),
+			RiskScoring:            cfg.RiskScoring.build(),
+			ManagementToken:        cfg.ManagementToken,
+			DeepLinkKey:            cfg.DeepLinkKey,
+			Kiosk:                  cfg.Kiosk.build(),
+			Recording:              cfg.Recording.build(),
+			StepUpRules:            cfg.StepUpRules,
+			StepUpTOTPSecrets:      stepUpSecrets,
 			Hooks:                  cfg.Hooks,
 			HookTimeout:            time.Duration(cfg.HookTimeout) * time.Second,
-			Servers:                []Server{cfgSer.build()},
+			AsyncHookWorkers:       cfg.AsyncHookWorkers,
+			AsyncHookQueue:         cfg.AsyncHookQueue,
+			Servers:                servers,
 			Presets:                concretizePresets,
 			OnlyAllowPresetRemotes: cfg.OnlyAllowPresetRemotes,
+			ProvisionFile:          cfg.ProvisionFile,
+			MountedDirectories:     cfg.MountedDirectories,
+			UpgradeDrainTimeout: time.Duration(cfg.UpgradeDrainTimeout) *
+				time.Second,
+			ShutdownDrainTimeout: time.Duration(cfg.ShutdownDrainTimeout) *
+				time.Second,
+			ReplicateFrom:    cfg.ReplicateFrom,
+			ReplicationToken: cfg.ReplicationToken,
+			Inventory:        cfg.Inventory.build(),
+			Tenants:          concretizeTenants,
 		}, nil
 	}
 }
@@ -0,0 +1,59 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/log"
+)
+
+func TestEnviroTCPKeepAlive(t *testing.T) {
+	t.Setenv("SSHWIFTY_TCPKEEPALIVEIDLE", "15")
+	t.Setenv("SSHWIFTY_TCPKEEPALIVEINTERVAL", "10")
+	t.Setenv("SSHWIFTY_TCPKEEPALIVECOUNT", "9")
+
+	_, cfg, err := Enviro()(log.NewDitch())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.TCPKeepAliveIdle != 15*time.Second ||
+		cfg.TCPKeepAliveInterval != 10*time.Second ||
+		cfg.TCPKeepAliveCount != 9 {
+		t.Errorf("Unexpected TCP keep-alive settings %v/%v/%d",
+			cfg.TCPKeepAliveIdle, cfg.TCPKeepAliveInterval,
+			cfg.TCPKeepAliveCount)
+	}
+}
+
+func TestEnviroSSHLimits(t *testing.T) {
+	t.Setenv("SSHWIFTY_SSHREKEYTHRESHOLD", "1048576")
+	t.Setenv("SSHWIFTY_SSHMAXPACKETSIZE", "4096")
+
+	_, cfg, err := Enviro()(log.NewDitch())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.SSHRekeyThreshold != 1048576 || cfg.SSHMaxPacketSize != 4096 {
+		t.Errorf("Unexpected SSH limits %d/%d",
+			cfg.SSHRekeyThreshold, cfg.SSHMaxPacketSize)
+	}
+}
@@ -20,6 +20,8 @@ package configuration
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"os/user"
 	"path"
@@ -28,6 +30,8 @@ import (
 	"time"
 
 	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/relay"
+	"github.com/nirui/sshwifty/application/stepup"
 )
 
 const (
@@ -36,7 +40,9 @@ const (
 
 type fileCfgServer struct {
 	ListenInterface       string // Interface to listen to
+	ListenFamily          string // Address family to listen with
 	ListenPort            uint16 // Port to listen
+	ReusePort             bool   // Share the port with other processes
 	InitialTimeout        int    // Client initial request timeout, in second
 	ReadTimeout           int    // Read operation timeout, in second
 	WriteTimeout          int    // Write operation timeout, in second
@@ -55,7 +61,9 @@ func (f *fileCfgServer) build() Server {
 	}
 	return Server{
 		ListenInterface: iface,
+		ListenFamily:    ListenFamily(strings.ToLower(f.ListenFamily)),
 		ListenPort:      f.ListenPort,
+		ReusePort:       f.ReusePort,
 		InitialTimeout: time.Duration(
 			durationAtLeast(f.InitialTimeout, 5)) * time.Second,
 		ReadTimeout: time.Duration(
@@ -75,11 +83,46 @@ func (f *fileCfgServer) build() Server {
 }
 
 type fileCfgPreset struct {
-	Title    string
-	Type     string
-	Host     string
-	TabColor string
-	Meta     Meta
+	Title               string
+	Type                string
+	Host                string
+	TabColor            string
+	Meta                Meta
+	ProxyCommand        []string
+	Watch               string
+	Tags                []string
+	FastStart           bool
+	Weight              int
+	Forwards            map[string]string
+	NoTrace             bool
+	ExpectedFingerprint string
+	SSHAgentSocket      string
+	Command             string
+	Environment         map[string]string
+	SSHAlgorithms       SSHAlgorithms
+	Bootstrap           string
+	HideBootstrap       bool
+	Attach              string
+	Record              bool
+	Sandbox             *fileCfgSandbox
+	TLS                 *TLSWrap
+	WireGuard           bool
+}
+
+type fileCfgSandbox struct {
+	MaxDuration int    // Max lifetime of the connections, in seconds
+	MaxBytes    uint64 // Max bytes transferred by the connections
+}
+
+func (f *fileCfgSandbox) build() *Sandbox {
+	if f == nil {
+		return nil
+	}
+
+	return &Sandbox{
+		MaxDuration: time.Duration(f.MaxDuration) * time.Second,
+		MaxBytes:    f.MaxBytes,
+	}
 }
 
 func (f fileCfgPreset) concretize() (Preset, error) {
@@ -88,11 +131,31 @@ func (f fileCfgPreset) concretize() (Preset, error) {
 		return Preset{}, err
 	}
 	return Preset{
-		Title:    f.Title,
-		Type:     strings.TrimSpace(f.Type),
-		Host:     f.Host,
-		TabColor: strings.TrimSpace(f.TabColor),
-		Meta:     m,
+		Title:        f.Title,
+		Type:         strings.TrimSpace(f.Type),
+		Host:         f.Host,
+		TabColor:     strings.TrimSpace(f.TabColor),
+		Meta:         m,
+		ProxyCommand: f.ProxyCommand,
+		Watch:        strings.TrimSpace(f.Watch),
+		Tags:         f.Tags,
+		FastStart:    f.FastStart,
+		Weight:       f.Weight,
+		Forwards:     f.Forwards,
+		NoTrace:      f.NoTrace,
+		ExpectedFingerprint: strings.TrimSpace(
+			f.ExpectedFingerprint),
+		SSHAgentSocket: strings.TrimSpace(f.SSHAgentSocket),
+		Command:        strings.TrimSpace(f.Command),
+		Environment:    f.Environment,
+		SSHAlgorithms:  f.SSHAlgorithms,
+		Bootstrap:      f.Bootstrap,
+		HideBootstrap:  f.HideBootstrap,
+		Attach:         strings.TrimSpace(f.Attach),
+		Record:         f.Record,
+		Sandbox:        f.Sandbox.build(),
+		TLS:            f.TLS.build(),
+		WireGuard:      f.WireGuard,
 	}, nil
 }
 
@@ -112,6 +175,317 @@ func (f fileCfgPresets) concretize() ([]Preset, error) {
 	return ps, nil
 }
 
+type fileCfgPushApproval struct {
+	Provider          string // "duo" or "webhook", empty to disable
+	Timeout           int    // Max time to wait for the answer, in second
+	DuoHost           string // API host name of the Duo application
+	DuoIntegrationKey string // Integration key of the Duo application
+	DuoSecretKey      string // Secret key of the Duo application
+	WebhookURL        string // URL where the requests are POSTed to
+	WebhookSecret     string // Secret to sign the requests with, optional
+}
+
+func (f fileCfgPushApproval) build() PushApproval {
+	timeout := 60
+	if f.Timeout > 0 {
+		timeout = durationAtLeast(f.Timeout, 10)
+	}
+	return PushApproval{
+		Provider:          strings.ToLower(strings.TrimSpace(f.Provider)),
+		Timeout:           time.Duration(timeout) * time.Second,
+		DuoHost:           strings.TrimSpace(f.DuoHost),
+		DuoIntegrationKey: f.DuoIntegrationKey,
+		DuoSecretKey:      f.DuoSecretKey,
+		WebhookURL:        strings.TrimSpace(f.WebhookURL),
+		WebhookSecret:     f.WebhookSecret,
+	}
+}
+
+type fileCfgTracing struct {
+	Endpoint    string  // URL of the OTLP/HTTP traces endpoint
+	Headers     Meta    // Headers sent to the Endpoint
+	ServiceName string  // Name of the service, defaults to "sshwifty"
+	SampleRate  float64 // Ratio of the connections that are traced, 0 to 1
+}
+
+func (f fileCfgTracing) build() Tracing {
+	endpoint := strings.TrimSpace(f.Endpoint)
+	if u, err := url.Parse(endpoint); err == nil && len(u.Host) > 0 &&
+		strings.Trim(u.Path, "/") == "" {
+		// Only the address of the collector is given
+		u.Path = "/v1/traces"
+		endpoint = u.String()
+	}
+
+	serviceName := strings.TrimSpace(f.ServiceName)
+	if len(serviceName) <= 0 {
+		serviceName = "sshwifty"
+	}
+
+	sampleRate := f.SampleRate
+	if sampleRate <= 0 || sampleRate > 1 {
+		sampleRate = 1
+	}
+
+	return Tracing{
+		Endpoint:    endpoint,
+		Headers:     f.Headers,
+		ServiceName: serviceName,
+		SampleRate:  sampleRate,
+	}
+}
+
+type fileCfgAudit struct {
+	Sinks         []AuditSink // Destinations of the audit events
+	Buffer        int         // Max events waiting for each Sink
+	BatchSize     int         // Max events delivered in a single write
+	FlushInterval int         // Max time to wait for a full batch, in second
+}
+
+func (f fileCfgAudit) build() Audit {
+	sinks := make([]AuditSink, len(f.Sinks))
+	for i, s := range f.Sinks {
+		s.Type = strings.ToLower(strings.TrimSpace(s.Type))
+		s.URL = strings.TrimSpace(s.URL)
+		sinks[i] = s
+	}
+
+	buffer := f.Buffer
+	if buffer <= 0 {
+		buffer = 1024
+	}
+
+	batchSize := f.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	flushInterval := 5
+	if f.FlushInterval > 0 {
+		flushInterval = f.FlushInterval
+	}
+
+	return Audit{
+		Sinks:         sinks,
+		Buffer:        buffer,
+		BatchSize:     batchSize,
+		FlushInterval: time.Duration(flushInterval) * time.Second,
+	}
+}
+
+type fileCfgLoginLimit struct {
+	MaxAttempts int    // Max failed logins of a client in the Window
+	Window      int    // Time window of the failed logins, in second
+	Backend     string // Where the counters are kept
+}
+
+func (f fileCfgLoginLimit) build() LoginLimit {
+	window := 300
+	if f.Window > 0 {
+		window = f.Window
+	}
+
+	return LoginLimit{
+		MaxAttempts: f.MaxAttempts,
+		Window:      time.Duration(window) * time.Second,
+		Backend:     strings.TrimSpace(f.Backend),
+	}
+}
+
+type fileCfgRiskScoring struct {
+	WebhookURL     string        // URL where the sessions are POSTed to
+	WebhookSecret  string        // Secret to sign the requests with, optional
+	Timeout        int           // Max time to wait for the score, in second
+	IncludeCommand bool          // Send the commands that sessions will run
+	FailClosed     bool          // Refuse the sessions if the webhook fails
+	AlertScore     float64       // Min score to alert, 0 to disable
+	StepUpScore    float64       // Min score to require step-up, 0 to disable
+	StepUpMethod   stepup.Method // Method of the step-up, default "password"
+	TerminateScore float64       // Min score to refuse, 0 to disable
+}
+
+func (f fileCfgRiskScoring) build() RiskScoring {
+	timeout := 5
+	if f.Timeout > 0 {
+		timeout = f.Timeout
+	}
+	method := f.StepUpMethod
+	if len(method) <= 0 {
+		method = stepup.METHOD_PASSWORD
+	}
+	return RiskScoring{
+		WebhookURL:     strings.TrimSpace(f.WebhookURL),
+		WebhookSecret:  f.WebhookSecret,
+		Timeout:        time.Duration(timeout) * time.Second,
+		IncludeCommand: f.IncludeCommand,
+		FailClosed:     f.FailClosed,
+		AlertScore:     f.AlertScore,
+		StepUpScore:    f.StepUpScore,
+		StepUpMethod:   method,
+		TerminateScore: f.TerminateScore,
+	}
+}
+
+type fileCfgKiosk struct {
+	Key               string // Key to sign the kiosk tokens with
+	KeepaliveInterval int    // Interval of the kiosk keepalives, in second
+}
+
+func (f fileCfgKiosk) build() Kiosk {
+	keepalive := 30
+	if f.KeepaliveInterval > 0 {
+		keepalive = f.KeepaliveInterval
+	}
+	return Kiosk{
+		Key:               f.Key,
+		KeepaliveInterval: time.Duration(keepalive) * time.Second,
+	}
+}
+
+type fileCfgInventory struct {
+	URL       string            // Where the inventory is fetched or read from
+	Format    string            // "json" (default), "netbox" or "ansible"
+	Token     String            // Token to fetch the inventory with
+	Interval  int               // Interval of the synchronizations, in second
+	CacheFile string            // Where the last fetched inventory is kept
+	Type      string            // Type of the hosts, "SSH" (default)
+	Port      uint16            // Port of the hosts, 22 by default
+	OutOfBand bool              // Connect to the out-of-band address instead
+	Meta      map[string]string // Meta of the hosts
+}
+
+func (f fileCfgInventory) build() Inventory {
+	format := strings.ToLower(strings.TrimSpace(f.Format))
+	if len(format) <= 0 {
+		format = InventoryFormatJSON
+	}
+
+	interval := 300
+	if f.Interval > 0 {
+		interval = f.Interval
+	}
+
+	typ := strings.TrimSpace(f.Type)
+	if len(typ) <= 0 {
+		typ = "SSH"
+	}
+
+	port := f.Port
+	if port <= 0 {
+		port = 22
+		if typ == "Telnet" {
+			port = 23
+		}
+	}
+
+	return Inventory{
+		URL:       strings.TrimSpace(f.URL),
+		Format:    format,
+		Token:     f.Token,
+		Interval:  time.Duration(interval) * time.Second,
+		CacheFile: strings.TrimSpace(f.CacheFile),
+		Type:      typ,
+		Port:      port,
+		OutOfBand: f.OutOfBand,
+		Meta:      f.Meta,
+	}
+}
+
+type fileCfgRecording struct {
+	Directory   string      // Where the recordings are written to
+	Filename    string      // Template of the file names of the recordings
+	Retention   int         // How long the recordings are kept, in hour
+	AllSessions bool        // Record all sessions, not only those of Presets
+	Index       bool        // Index the output so it can be searched
+	S3          RecordingS3 // Where the finished recordings are shipped to
+}
+
+func (f fileCfgRecording) build() Recording {
+	s3 := f.S3
+	s3.Endpoint = strings.TrimSpace(s3.Endpoint)
+	s3.Region = strings.TrimSpace(s3.Region)
+	s3.Bucket = strings.TrimSpace(s3.Bucket)
+	s3.Prefix = strings.Trim(strings.TrimSpace(s3.Prefix), "/")
+
+	return Recording{
+		Directory:   strings.TrimSpace(f.Directory),
+		Filename:    strings.TrimSpace(f.Filename),
+		Retention:   time.Duration(durationAtLeast(f.Retention, 0)) * time.Hour,
+		AllSessions: f.AllSessions,
+		Index:       f.Index,
+		S3:          s3,
+	}
+}
+
+type fileCfgTenant struct {
+	Name                   string           // Name of the tenant
+	HostNames              []string         // Host names selecting the tenant
+	PathPrefix             string           // Path selecting the tenant
+	MaxClients             int              // Max connected clients, 0 = any
+	SharedKey              string           // Shared key of the tenant
+	KeyVaultFile           string           // Public key vault of the tenant
+	KnownHostsFile         string           // Known hosts of the tenant
+	UserSettingsFile       string           // Settings of the users
+	PasskeyFile            string           // Passkeys of the users
+	PasskeyRPID            string           // Domain passkeys bound to
+	JournalFile            string           // Journal of the tenant
+	ReverseForwardRules    []string         // Reverse forwards allowed
+	Recording              fileCfgRecording // Recording of the sessions
+	StepUpRules            []StepUpRule     // Remotes requiring step-up
+	StepUpTOTPSecrets      Meta             // TOTP secrets of the users
+	Presets                fileCfgPresets   // Presets of the tenant
+	OnlyAllowPresetRemotes bool             // Allow the Presets only
+}
+
+func (f fileCfgTenant) concretize() (Tenant, error) {
+	presets, err := f.Presets.concretize()
+	if err != nil {
+		return Tenant{}, err
+	}
+
+	stepUpTOTPSecrets, err := f.StepUpTOTPSecrets.Concretize()
+	if err != nil {
+		return Tenant{}, fmt.Errorf(
+			"unable to load StepUpTOTPSecrets: %s", err)
+	}
+
+	return Tenant{
+		Name:                   strings.TrimSpace(f.Name),
+		HostNames:              f.HostNames,
+		PathPrefix:             strings.TrimSpace(f.PathPrefix),
+		MaxClients:             f.MaxClients,
+		SharedKey:              f.SharedKey,
+		KeyVaultFile:           f.KeyVaultFile,
+		KnownHostsFile:         f.KnownHostsFile,
+		UserSettingsFile:       f.UserSettingsFile,
+		PasskeyFile:            f.PasskeyFile,
+		PasskeyRPID:            strings.TrimSpace(f.PasskeyRPID),
+		JournalFile:            f.JournalFile,
+		ReverseForwardRules:    f.ReverseForwardRules,
+		Recording:              f.Recording.build(),
+		StepUpRules:            f.StepUpRules,
+		StepUpTOTPSecrets:      stepUpTOTPSecrets,
+		Presets:                presets,
+		OnlyAllowPresetRemotes: f.OnlyAllowPresetRemotes,
+	}, nil
+}
+
+type fileCfgTenants []fileCfgTenant
+
+func (f fileCfgTenants) concretize() ([]Tenant, error) {
+	ts := make([]Tenant, 0, len(f))
+	for i, t := range f {
+		tt, err := t.concretize()
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to concretize Tenant %d (named \"%s\"): %s",
+				i+1, t.Name, err)
+		}
+		ts = append(ts, tt)
+	}
+	return ts, nil
+}
+
 type fileCfgCommon struct {
 	// Host name
 	HostName string
@@ -125,11 +499,259 @@ type fileCfgCommon struct {
 	// Socks5 server address, optional
 	Socks5 string
 
-	// Login user for socks5 server, optional
-	Socks5User string
+	// Login user for socks5 server, optional. Supports the same scheme
+	// prefixes as the Preset Meta (i.e. "file://"), which are loaded again
+	// for every connection
+	Socks5User String
+
+	// Login pass for socks5 server, optional. Supports the same scheme
+	// prefixes as the Preset Meta (i.e. "file://"), which are loaded again
+	// for every connection
+	Socks5Password String
+
+	// Userspace WireGuard tunnel which the Presets can be reached through,
+	// optional
+	WireGuard *WireGuard
+
+	// Idle time before TCP keep-alive probes are sent to the remote, in
+	// second. 0 to use system default
+	TCPKeepAliveIdle int
+
+	// Interval between each TCP keep-alive probe, in second. 0 to use system
+	// default
+	TCPKeepAliveInterval int
+
+	// Max unanswered TCP keep-alive probes before the connection is
+	// considered dead. 0 to use system default
+	TCPKeepAliveCount int
+
+	// How the read deadline is managed during the remote handshake. Can be
+	// "sliding", "fixed" or "disabled", default is "sliding"
+	ReadDeadlineStrategy ReadDeadlineStrategy
+
+	// Max time to wait for the user to respond to a prompt (i.e. to enter a
+	// password or to confirm the fingerprint), in second. 0 to wait
+	// indefinitely
+	PromptTimeout int
+
+	// Path to the file where users' public keys are stored. Leave empty to
+	// disable the public key vault
+	KeyVaultFile string
 
-	// Login pass for socks5 server, optional
-	Socks5Password string
+	// Path to the known_hosts file where the SSH host keys accepted by the
+	// users are recorded. Recorded keys are accepted automatically, and the
+	// connection is refused when the key of the remote has changed. Leave
+	// empty to let users verify the host key on every connection
+	KnownHostsFile string
+
+	// Path to the file where the default settings of users are stored. Leave
+	// empty to disable the server-stored user settings
+	UserSettingsFile string
+
+	// Path to the file where passkeys of users are stored. Leave empty to
+	// disable passkey login. Requires the SharedKey
+	PasskeyFile string
+
+	// The domain which the passkeys are bound to, it must be the host name
+	// the users use to reach Sshwifty, or a parent domain of it. Required
+	// by the PasskeyFile
+	PasskeyRPID string
+
+	// How long a passkey login lasts, in hour. 0 to use the default (12)
+	PasskeySessionLifetime int
+
+	// Require every Websocket connection to present a single-use token,
+	// which is issued by a successful verification to the same client. A
+	// stolen key alone cannot connect once it's enabled
+	SocketBinding bool
+
+	// Addresses (or networks in the CIDR format) of the reverse proxies in
+	// front of Sshwifty. Requests relayed by them are taken as coming from
+	// the client named by the X-Forwarded-For header, so the clients behind
+	// them are told apart by the SocketBinding and the LoginLimit
+	TrustedProxies []string
+
+	// Networks to aggregate traffic usage with, in the format of
+	// {"Name": ["CIDR", ...]}. Traffic of remotes which belongs to neither a
+	// Preset nor a network listed here will be aggregated as "other"
+	UsageNetworks map[string][]string
+
+	// Path to the file where connection events are journaled. Leave empty to
+	// disable the journal
+	JournalFile string
+
+	// How long the journaled events are kept, in hour. 0 to keep them forever
+	JournalRetention int
+
+	// Max amount of journaled events to keep, min 100
+	JournalMaxEvents int
+
+	// Notice sent to the user before connecting to any remote. Supports the
+	// same scheme prefixes as the Preset Meta (i.e. "file://")
+	ConnectNotice String
+
+	// Max amount of host names to cache the resolve results of. 0 to
+	// disable the DNS cache
+	DNSCacheSize int
+
+	// Max time to cache a resolve result, in second. The TTL of the DNS
+	// record is used when it's shorter. 0 to use the default (300)
+	DNSCacheMaxTTL int
+
+	// Time to cache a non-existing host, in second. 0 to disable negative
+	// caching
+	DNSCacheNegativeTTL int
+
+	// Interval of the availability checks on the watched Presets, in
+	// second. 0 to use the default (60), min 5
+	WatchInterval int
+
+	// Name of the HTTP request header which carries the identity of the user
+	// authenticated by a trusted reverse proxy, optional
+	UserHeader string
+
+	// Name of the HTTP request header which carries the comma separated
+	// groups of the user authenticated by a trusted reverse proxy, optional
+	UserGroupsHeader string
+
+	// Renegotiate the keys of the SSH connections after this amount of data
+	// is transferred, in bytes. 0 to use the default of the SSH library
+	SSHRekeyThreshold uint64
+
+	// Max size of the data packets sent to the SSH servers, in bytes. 0 to
+	// only be limited by the SSH servers
+	SSHMaxPacketSize int
+
+	// Algorithms offered to the SSH servers, in the order of preference
+	SSHAlgorithms SSHAlgorithms
+
+	// Known-good profile of the SSH servers, the ones which don't fit it are
+	// warned about
+	SSHServerPolicy SSHServerPolicy
+
+	// Interval of the keepalive requests sent to the SSH servers, in second.
+	// 0 to disable, min 5
+	SSHKeepaliveInterval int
+
+	// Max amount of keepalive requests left unanswered before the SSH
+	// connection is closed. 0 to use the default (3)
+	SSHKeepaliveCountMax int
+
+	// Max attempts to reconnect the SSH sessions whose connection has
+	// dropped, using the credentials of the dropped connection. 0 to disable
+	SSHReconnectAttempts int
+
+	// Max time to wait between the reconnect attempts, in second. The wait
+	// starts from 1 second and doubles after every attempt. 0 to use the
+	// default (30)
+	SSHReconnectMaxDelay int
+
+	// Time to keep the SSH sessions parked for the handover to another
+	// device, in second. 0 to disable
+	HandoverLifetime int
+
+	// Max amount of parked sessions of a user. 0 to use the default (4)
+	HandoverMaxSessions int
+
+	// Time to drop the output of a session after the client has interrupted
+	// it, in millisecond. 0 to use the default (1000)
+	InterruptMuteTime int
+
+	// Size of each read of the output of the SSH sessions, in byte. 0 to use
+	// the default (4096)
+	RelayBufferSize int
+
+	// Amount of the reads of the output each SSH session can take ahead of
+	// the client. 0 to not read ahead
+	RelayQueueSize int
+
+	// Max amount of the reads held by all the SSH sessions. 0 for no limit
+	RelayMaxBuffers int
+
+	// Terminal modes of the PTY of the SSH sessions, in the format of
+	// {"Name": Value} (i.e. {"IUTF8": 1}). They override the defaults, which
+	// are {"ECHO": 1, "TTY_OP_ISPEED": 14400, "TTY_OP_OSPEED": 14400}
+	TerminalModes map[string]uint32
+
+	// Max amount of warm connections kept for the Presets marked as
+	// FastStart. 0 to use the default (4)
+	FastStartConnections int
+
+	// Interval of the revalidation of the warm connections, in second. 0 to
+	// use the default (60), min 10
+	FastStartRevalidation int
+
+	// Host where the listeners of the forwards defined by the Presets are
+	// bound to. Empty to use the default ("127.0.0.1")
+	ForwardBindHost string
+
+	// Rules of the remote bind addresses that the clients are allowed to
+	// request reverse forwards on, in the format of "host:port" or
+	// "host:fromPort-toPort". Host "*" matches any host. Empty to disable
+	// reverse forwarding
+	ReverseForwardRules []string
+
+	// Allow the clients to open connections through the SSH connection for
+	// the SOCKS5 server that runs on their side (dynamic forwarding)
+	AllowDynamicForwards bool
+
+	// Allow the clients to open connections through the SSH connection for
+	// the local ports forwarded by their local agents (local forwarding)
+	AllowLocalForwards bool
+
+	// Allow the clients to browse and transfer files through the SFTP
+	// subsystem of the SSH connection
+	AllowFileTransfer bool
+
+	// Path to the socket of the SSH agent on the Sshwifty host, which is
+	// forwarded to the SSH sessions. Presets can override it
+	SSHAgentSocket string
+
+	// Provider which must approve the SSH logins through a push to the user
+	// before they're completed, optional
+	PushApproval fileCfgPushApproval
+
+	// Deliver the journaled connection events to external sinks
+	Audit fileCfgAudit
+
+	// Export the traces of the connection setup to an OpenTelemetry
+	// collector
+	Tracing fileCfgTracing
+
+	// Lock out the clients which failed to login too many times
+	LoginLimit fileCfgLoginLimit
+
+	// Webhook which scores the risk of the sessions before they're
+	// connected, so the risky ones can be alerted, stepped up or refused
+	RiskScoring fileCfgRiskScoring
+
+	// Token that the clients of the gRPC management API must present as
+	// the "authorization: Bearer <token>" metadata. Leave it empty to
+	// disable the management API
+	ManagementToken string
+
+	// Key of the signed deep links (`/connect?preset=...`) that open a Preset
+	// right after the page is loaded. Leave it empty to disable deep links
+	DeepLinkKey string
+
+	// Kiosks, the unattended displays that watch the console of a Preset
+	// in the read-only mode (`/kiosk?preset=...`). Leave the Key empty to
+	// disable kiosks
+	Kiosk fileCfgKiosk
+
+	// Session recording into asciicast v2 files. Leave the Directory empty
+	// to disable the recording
+	Recording fileCfgRecording
+
+	// Rules of the remotes that the users must authenticate again before
+	// connecting to, even when they're already authenticated, optional
+	StepUpRules []StepUpRule
+
+	// TOTP secrets (in base32) of the users for the "totp" step-up
+	// authentication, in the format of {"User": "Secret"}. User "*" matches
+	// the users who don't have a secret of their own. Supports the same
+	// scheme prefixes as the Preset Meta (i.e. "file://")
+	StepUpTOTPSecrets Meta
 
 	// Hooks
 	Hooks Hooks
@@ -137,6 +759,14 @@ type fileCfgCommon struct {
 	// HookTimeout execution timeout
 	HookTimeout int
 
+	// Max amount of asynchronous Hooks that can be executed at the same
+	// time. 0 to use the default (4)
+	AsyncHookWorkers int
+
+	// Max amount of asynchronous Hooks that can wait for execution. 0 to use
+	// the default (100)
+	AsyncHookQueue int
+
 	// Servers
 	Servers []*fileCfgServer
 
@@ -145,9 +775,124 @@ type fileCfgCommon struct {
 
 	// Allow predefined remotes only
 	OnlyAllowPresetRemotes bool
+
+	// Path to the file where the Presets, policy rules and users provisioned
+	// through the provisioning API are stored. They're added on top of the
+	// ones defined in the configuration. Requires the ManagementToken. Leave
+	// empty to disable the provisioning API
+	ProvisionFile string
+
+	// Directories (i.e. mounted Kubernetes ConfigMap or Secret volumes) that
+	// contain "presets.json", "policies.json" and "users.json". They're added
+	// on top of the ones defined in the configuration, and applied again once
+	// changed
+	MountedDirectories []string
+
+	// Max time to wait for the connected sessions to close after the
+	// listeners are handed over to the upgraded executable (SIGUSR2), in
+	// second. 0 to wait until all of them are closed
+	UpgradeDrainTimeout int
+
+	// Max time to wait for the connected sessions to close once Sshwifty is
+	// asked to stop (SIGTERM or SIGINT), in second. New connections and
+	// streams are refused during the time. 0 to stop without waiting
+	ShutdownDrainTimeout int
+
+	// Address of the primary instance to replicate the known hosts, the key
+	// vault, the journal and the provisioned state from, i.e.
+	// "https://primary.example.com". Leave empty unless current instance is
+	// a standby
+	ReplicateFrom string
+
+	// The ManagementToken of the primary instance
+	ReplicationToken string
+
+	// External inventory (i.e. NetBox) to sync the Presets from. They're
+	// added on top of the ones defined in the configuration
+	Inventory fileCfgInventory
+
+	// Tenants which have their own users, Presets, policies, trust stores
+	// and recordings, selected by the host name or the path
+	Tenants fileCfgTenants
 }
 
 func (f fileCfgCommon) build() (fileCfgCommon, error) {
+	connectNotice, err := f.ConnectNotice.Parse()
+	if err != nil {
+		return fileCfgCommon{}, fmt.Errorf(
+			"unable to load ConnectNotice: %s", err)
+	}
+
+	asyncHookWorkers := f.AsyncHookWorkers
+	if asyncHookWorkers <= 0 {
+		asyncHookWorkers = 4
+	}
+
+	asyncHookQueue := f.AsyncHookQueue
+	if asyncHookQueue <= 0 {
+		asyncHookQueue = 100
+	}
+
+	watchInterval := 0
+	if f.WatchInterval > 0 {
+		watchInterval = durationAtLeast(f.WatchInterval, 5)
+	}
+
+	sshKeepaliveInterval := 0
+	if f.SSHKeepaliveInterval > 0 {
+		sshKeepaliveInterval = durationAtLeast(f.SSHKeepaliveInterval, 5)
+	}
+
+	sshKeepaliveCountMax := f.SSHKeepaliveCountMax
+	if sshKeepaliveCountMax <= 0 {
+		sshKeepaliveCountMax = 3
+	}
+
+	sshReconnectMaxDelay := f.SSHReconnectMaxDelay
+	if sshReconnectMaxDelay <= 0 {
+		sshReconnectMaxDelay = 30
+	}
+
+	handoverMaxSessions := f.HandoverMaxSessions
+	if handoverMaxSessions <= 0 {
+		handoverMaxSessions = 4
+	}
+
+	interruptMuteTime := f.InterruptMuteTime
+	if interruptMuteTime <= 0 {
+		interruptMuteTime = 1000
+	}
+
+	relayBufferSize := f.RelayBufferSize
+	if relayBufferSize <= 0 {
+		relayBufferSize = relay.DefaultBufferSize
+	}
+
+	fastStartConnections := f.FastStartConnections
+	if fastStartConnections <= 0 {
+		fastStartConnections = 4
+	}
+
+	fastStartRevalidation := 60
+	if f.FastStartRevalidation > 0 {
+		fastStartRevalidation = durationAtLeast(f.FastStartRevalidation, 10)
+	}
+
+	passkeySessionLifetime := f.PasskeySessionLifetime
+	if passkeySessionLifetime <= 0 {
+		passkeySessionLifetime = 12
+	}
+
+	forwardBindHost := strings.TrimSpace(f.ForwardBindHost)
+	if len(forwardBindHost) <= 0 {
+		forwardBindHost = "127.0.0.1"
+	}
+
+	tcpKeepAliveCount := f.TCPKeepAliveCount
+	if tcpKeepAliveCount < 0 {
+		tcpKeepAliveCount = 0
+	}
+
 	return fileCfgCommon{
 		HostName:               f.HostName,
 		SharedKey:              f.SharedKey,
@@ -155,11 +900,80 @@ func (f fileCfgCommon) build() (fileCfgCommon, error) {
 		Socks5:                 f.Socks5,
 		Socks5User:             f.Socks5User,
 		Socks5Password:         f.Socks5Password,
+		WireGuard:              f.WireGuard,
+		TCPKeepAliveIdle:       durationAtLeast(f.TCPKeepAliveIdle, 0),
+		TCPKeepAliveInterval:   durationAtLeast(f.TCPKeepAliveInterval, 0),
+		TCPKeepAliveCount:      tcpKeepAliveCount,
+		ReadDeadlineStrategy:   f.ReadDeadlineStrategy,
+		PromptTimeout:          durationAtLeast(f.PromptTimeout, 0),
+		KeyVaultFile:           f.KeyVaultFile,
+		KnownHostsFile:         f.KnownHostsFile,
+		UserSettingsFile:       f.UserSettingsFile,
+		PasskeyFile:            f.PasskeyFile,
+		PasskeyRPID:            strings.TrimSpace(f.PasskeyRPID),
+		PasskeySessionLifetime: passkeySessionLifetime,
+		SocketBinding:          f.SocketBinding,
+		TrustedProxies:         f.TrustedProxies,
+		UsageNetworks:          f.UsageNetworks,
+		JournalFile:            f.JournalFile,
+		JournalRetention:       durationAtLeast(f.JournalRetention, 0),
+		JournalMaxEvents:       durationAtLeast(f.JournalMaxEvents, 100),
+		ConnectNotice:          String(connectNotice),
+		DNSCacheSize:           durationAtLeast(f.DNSCacheSize, 0),
+		DNSCacheMaxTTL:         durationAtLeast(f.DNSCacheMaxTTL, 0),
+		DNSCacheNegativeTTL:    durationAtLeast(f.DNSCacheNegativeTTL, 0),
+		WatchInterval:          watchInterval,
+		UserHeader:             strings.TrimSpace(f.UserHeader),
+		UserGroupsHeader:       strings.TrimSpace(f.UserGroupsHeader),
+		SSHRekeyThreshold:      f.SSHRekeyThreshold,
+		SSHMaxPacketSize:       durationAtLeast(f.SSHMaxPacketSize, 0),
+		SSHAlgorithms:          f.SSHAlgorithms,
+		SSHServerPolicy:        f.SSHServerPolicy,
+		SSHKeepaliveInterval:   sshKeepaliveInterval,
+		SSHKeepaliveCountMax:   sshKeepaliveCountMax,
+		SSHReconnectAttempts:   durationAtLeast(f.SSHReconnectAttempts, 0),
+		SSHReconnectMaxDelay:   sshReconnectMaxDelay,
+		HandoverLifetime:       durationAtLeast(f.HandoverLifetime, 0),
+		HandoverMaxSessions:    handoverMaxSessions,
+		InterruptMuteTime:      interruptMuteTime,
+		RelayBufferSize:        durationAtLeast(relayBufferSize, 512),
+		RelayQueueSize:         durationAtLeast(f.RelayQueueSize, 0),
+		RelayMaxBuffers:        durationAtLeast(f.RelayMaxBuffers, 0),
+		TerminalModes:          f.TerminalModes,
+		FastStartConnections:   fastStartConnections,
+		FastStartRevalidation:  fastStartRevalidation,
+		ForwardBindHost:        forwardBindHost,
+		ReverseForwardRules:    f.ReverseForwardRules,
+		AllowDynamicForwards:   f.AllowDynamicForwards,
+		AllowLocalForwards:     f.AllowLocalForwards,
+		AllowFileTransfer:      f.AllowFileTransfer,
+		SSHAgentSocket:         strings.TrimSpace(f.SSHAgentSocket),
+		PushApproval:           f.PushApproval,
+		Audit:                  f.Audit,
+		Tracing:                f.Tracing,
+		LoginLimit:             f.LoginLimit,
+		RiskScoring:            f.RiskScoring,
+		ManagementToken:        strings.TrimSpace(f.ManagementToken),
+		DeepLinkKey:            f.DeepLinkKey,
+		Kiosk:                  f.Kiosk,
+		Recording:              f.Recording,
+		StepUpRules:            f.StepUpRules,
+		StepUpTOTPSecrets:      f.StepUpTOTPSecrets,
 		Hooks:                  f.Hooks,
 		HookTimeout:            durationAtLeast(f.HookTimeout, 1),
+		AsyncHookWorkers:       asyncHookWorkers,
+		AsyncHookQueue:         asyncHookQueue,
 		Servers:                f.Servers,
 		Presets:                f.Presets,
 		OnlyAllowPresetRemotes: f.OnlyAllowPresetRemotes,
+		ProvisionFile:          f.ProvisionFile,
+		MountedDirectories:     f.MountedDirectories,
+		UpgradeDrainTimeout:    durationAtLeast(f.UpgradeDrainTimeout, 0),
+		ShutdownDrainTimeout:   durationAtLeast(f.ShutdownDrainTimeout, 0),
+		ReplicateFrom:          strings.TrimSpace(f.ReplicateFrom),
+		ReplicationToken:       strings.TrimSpace(f.ReplicationToken),
+		Inventory:              f.Inventory,
+		Tenants:                f.Tenants,
 	}, nil
 }
 
@@ -170,17 +984,25 @@ func loadFile(filePath string) (string, Configuration, error) {
 	}
 	defer f.Close()
 
+	cfg, cfgErr := decodeFile(f)
+
+	return fileTypeName, cfg, cfgErr
+}
+
+// decodeFile reads the configuration in the format of the configuration file
+// from `r`
+func decodeFile(r io.Reader) (Configuration, error) {
 	cfg := fileCfgCommon{}
 
-	jDecoder := json.NewDecoder(f)
+	jDecoder := json.NewDecoder(r)
 	jDecodeErr := jDecoder.Decode(&cfg)
 	if jDecodeErr != nil {
-		return fileTypeName, Configuration{}, jDecodeErr
+		return Configuration{}, jDecodeErr
 	}
 
 	finalCfg, cfgErr := cfg.build()
 	if cfgErr != nil {
-		return fileTypeName, Configuration{}, cfgErr
+		return Configuration{}, cfgErr
 	}
 
 	servers := make([]Server, len(finalCfg.Servers))
@@ -190,22 +1012,124 @@ func loadFile(filePath string) (string, Configuration, error) {
 
 	presets, err := finalCfg.Presets.concretize()
 	if err != nil {
-		return fileTypeName, Configuration{}, err
+		return Configuration{}, err
 	}
 
-	return fileTypeName, Configuration{
+	stepUpTOTPSecrets, err := finalCfg.StepUpTOTPSecrets.Concretize()
+	if err != nil {
+		return Configuration{}, fmt.Errorf(
+			"unable to load StepUpTOTPSecrets: %s", err)
+	}
+
+	tenants, err := finalCfg.Tenants.concretize()
+	if err != nil {
+		return Configuration{}, err
+	}
+
+	promptTimeout := time.Duration(finalCfg.PromptTimeout) * time.Second
+	journalRetention := time.Duration(finalCfg.JournalRetention) * time.Hour
+	passkeySessionLifetime := time.Duration(
+		finalCfg.PasskeySessionLifetime) * time.Hour
+	dnsMaxTTL := time.Duration(finalCfg.DNSCacheMaxTTL) * time.Second
+	dnsNegativeTTL := time.Duration(finalCfg.DNSCacheNegativeTTL) * time.Second
+	watchEvery := time.Duration(finalCfg.WatchInterval) * time.Second
+	revalidateEvery := time.Duration(finalCfg.FastStartRevalidation) *
+		time.Second
+	keepaliveEvery := time.Duration(finalCfg.SSHKeepaliveInterval) *
+		time.Second
+	reconnectMaxDelay := time.Duration(finalCfg.SSHReconnectMaxDelay) *
+		time.Second
+	handoverLifetime := time.Duration(finalCfg.HandoverLifetime) *
+		time.Second
+	interruptMuteTime := time.Duration(finalCfg.InterruptMuteTime) *
+		time.Millisecond
+
+	return Configuration{
 		HostName:  finalCfg.HostName,
 		SharedKey: finalCfg.SharedKey,
 		DialTimeout: time.Duration(finalCfg.DialTimeout) *
 			time.Second,
-		Socks5:                 cfg.Socks5,
-		Socks5User:             cfg.Socks5User,
-		Socks5Password:         cfg.Socks5Password,
+		Socks5:         cfg.Socks5,
+		Socks5User:     cfg.Socks5User,
+		Socks5Password: cfg.Socks5Password,
+		WireGuard:      cfg.WireGuard,
+		TCPKeepAliveIdle: time.Duration(finalCfg.TCPKeepAliveIdle) *
+			time.Second,
+		TCPKeepAliveInterval: time.Duration(finalCfg.TCPKeepAliveInterval) *
+			time.Second,
+		TCPKeepAliveCount:      finalCfg.TCPKeepAliveCount,
+		ReadDeadlineStrategy:   finalCfg.ReadDeadlineStrategy,
+		PromptTimeout:          promptTimeout,
+		KeyVaultFile:           finalCfg.KeyVaultFile,
+		KnownHostsFile:         finalCfg.KnownHostsFile,
+		UserSettingsFile:       finalCfg.UserSettingsFile,
+		PasskeyFile:            finalCfg.PasskeyFile,
+		PasskeyRPID:            finalCfg.PasskeyRPID,
+		PasskeySessionLifetime: passkeySessionLifetime,
+		SocketBinding:          finalCfg.SocketBinding,
+		TrustedProxies:         finalCfg.TrustedProxies,
+		UsageNetworks:          finalCfg.UsageNetworks,
+		JournalFile:            finalCfg.JournalFile,
+		JournalRetention:       journalRetention,
+		JournalMaxEvents:       finalCfg.JournalMaxEvents,
+		ConnectNotice:          string(finalCfg.ConnectNotice),
+		DNSCacheSize:           finalCfg.DNSCacheSize,
+		DNSCacheMaxTTL:         dnsMaxTTL,
+		DNSCacheNegativeTTL:    dnsNegativeTTL,
+		WatchInterval:          watchEvery,
+		UserHeader:             finalCfg.UserHeader,
+		UserGroupsHeader:       finalCfg.UserGroupsHeader,
+		SSHRekeyThreshold:      finalCfg.SSHRekeyThreshold,
+		SSHMaxPacketSize:       finalCfg.SSHMaxPacketSize,
+		SSHAlgorithms:          finalCfg.SSHAlgorithms,
+		SSHServerPolicy:        finalCfg.SSHServerPolicy,
+		SSHKeepaliveInterval:   keepaliveEvery,
+		SSHKeepaliveCountMax:   finalCfg.SSHKeepaliveCountMax,
+		SSHReconnectAttempts:   finalCfg.SSHReconnectAttempts,
+		SSHReconnectMaxDelay:   reconnectMaxDelay,
+		HandoverLifetime:       handoverLifetime,
+		HandoverMaxSessions:    finalCfg.HandoverMaxSessions,
+		InterruptMuteTime:      interruptMuteTime,
+		RelayBufferSize:        finalCfg.RelayBufferSize,
+		RelayQueueSize:         finalCfg.RelayQueueSize,
+		RelayMaxBuffers:        finalCfg.RelayMaxBuffers,
+		TerminalModes:          normalizeTerminalModes(finalCfg.TerminalModes),
+		FastStartConnections:   finalCfg.FastStartConnections,
+		FastStartRevalidation:  revalidateEvery,
+		ForwardBindHost:        finalCfg.ForwardBindHost,
+		ReverseForwardRules:    finalCfg.ReverseForwardRules,
+		AllowDynamicForwards:   finalCfg.AllowDynamicForwards,
+		AllowLocalForwards:     finalCfg.AllowLocalForwards,
+		AllowFileTransfer:      finalCfg.AllowFileTransfer,
+		SSHAgentSocket:         finalCfg.SSHAgentSocket,
+		PushApproval:           finalCfg.PushApproval.build(),
+		Audit:                  finalCfg.Audit.build(),
+		Tracing:                finalCfg.Tracing.build(),
+		LoginLimit:             finalCfg.LoginLimit.build(),
+		RiskScoring:            finalCfg.RiskScoring.build(),
+		ManagementToken:        finalCfg.ManagementToken,
+		DeepLinkKey:            finalCfg.DeepLinkKey,
+		Kiosk:                  finalCfg.Kiosk.build(),
+		Recording:              finalCfg.Recording.build(),
+		StepUpRules:            finalCfg.StepUpRules,
+		StepUpTOTPSecrets:      stepUpTOTPSecrets,
 		Hooks:                  cfg.Hooks,
 		HookTimeout:            time.Duration(cfg.HookTimeout) * time.Second,
+		AsyncHookWorkers:       finalCfg.AsyncHookWorkers,
+		AsyncHookQueue:         finalCfg.AsyncHookQueue,
 		Servers:                servers,
 		Presets:                presets,
 		OnlyAllowPresetRemotes: cfg.OnlyAllowPresetRemotes,
+		ProvisionFile:          cfg.ProvisionFile,
+		MountedDirectories:     cfg.MountedDirectories,
+		UpgradeDrainTimeout: time.Duration(finalCfg.UpgradeDrainTimeout) *
+			time.Second,
+		ShutdownDrainTimeout: time.Duration(finalCfg.ShutdownDrainTimeout) *
+			time.Second,
+		ReplicateFrom:    finalCfg.ReplicateFrom,
+		ReplicationToken: finalCfg.ReplicationToken,
+		Inventory:        finalCfg.Inventory.build(),
+		Tenants:          tenants,
 	}, nil
 }
 
@@ -0,0 +1,83 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeFileTCPKeepAlive(t *testing.T) {
+	for _, test := range []struct {
+		file     string
+		idle     time.Duration
+		interval time.Duration
+		count    int
+	}{
+		{`{}`, 0, 0, 0},
+		{
+			`{"TCPKeepAliveIdle": 15, "TCPKeepAliveInterval": 10, ` +
+				`"TCPKeepAliveCount": 9}`,
+			15 * time.Second, 10 * time.Second, 9,
+		},
+		{
+			`{"TCPKeepAliveIdle": -1, "TCPKeepAliveInterval": -1, ` +
+				`"TCPKeepAliveCount": -1}`,
+			0, 0, 0,
+		},
+	} {
+		cfg, err := decodeFile(strings.NewReader(test.file))
+		if err != nil {
+			t.Errorf("Unable to decode %s: %s", test.file, err)
+			continue
+		}
+
+		if cfg.TCPKeepAliveIdle != test.idle ||
+			cfg.TCPKeepAliveInterval != test.interval ||
+			cfg.TCPKeepAliveCount != test.count {
+			t.Errorf("Expecting %v/%v/%d for %s, got %v/%v/%d",
+				test.idle, test.interval, test.count, test.file,
+				cfg.TCPKeepAliveIdle, cfg.TCPKeepAliveInterval,
+				cfg.TCPKeepAliveCount)
+		}
+	}
+}
+
+func TestDecodeFileSSHLimits(t *testing.T) {
+	cfg, err := decodeFile(strings.NewReader(
+		`{"SSHRekeyThreshold": 1048576, "SSHMaxPacketSize": 4096}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.SSHRekeyThreshold != 1048576 || cfg.SSHMaxPacketSize != 4096 {
+		t.Errorf("Unexpected SSH limits %d/%d",
+			cfg.SSHRekeyThreshold, cfg.SSHMaxPacketSize)
+	}
+
+	c, err := cfg.Common()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.SSHMaxPacketSize != 4096 {
+		t.Errorf("Expecting SSHMaxPacketSize in Common, got %d",
+			c.SSHMaxPacketSize)
+	}
+}
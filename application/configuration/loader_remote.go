@@ -0,0 +1,509 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nirui/sshwifty/application/log"
+)
+
+const (
+	remoteTypeName = "Remote"
+
+	remoteTimeout = 30 * time.Second
+	remoteMaxSize = 16 * 1024 * 1024
+)
+
+// Kinds of the remote configuration sources
+const (
+	remoteHTTP   = "http"
+	remoteEtcd   = "etcd"
+	remoteConsul = "consul"
+)
+
+// Errors
+var (
+	ErrRemoteUnsupportedSource = errors.New(
+		"unsupported source, expecting \"http(s)://\", \"etcd(+https)://\" " +
+			"or \"consul(+https)://\"")
+
+	ErrRemoteKeyNotSpecified = errors.New(
+		"key must be specified")
+
+	ErrRemoteKeyNotFound = errors.New(
+		"key was not found")
+
+	ErrRemoteUnexpectedStatus = errors.New(
+		"unexpected response status")
+)
+
+// IsRemote returns whether or not the configuration `source` is a remote
+// source accepted by the Remote loader rather than a file path
+func IsRemote(source string) bool {
+	return strings.Contains(source, "://")
+}
+
+// remoteConfig is where the configuration is loaded from, and the state of
+// the last loaded configuration
+type remoteConfig struct {
+	kind     string
+	endpoint url.URL // Where the requests are sent to
+	key      string  // The key in etcd or Consul
+	user     string  // The etcd user
+	password string
+	token    string
+	interval time.Duration
+	client   *http.Client
+	digest   string
+	index    string // The X-Consul-Index of the loaded configuration
+}
+
+// parseRemote parses the remote configuration `source`
+func parseRemote(
+	source string,
+	token String,
+	interval time.Duration,
+) (remoteConfig, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return remoteConfig{}, err
+	}
+
+	r := remoteConfig{
+		endpoint: *u,
+		interval: interval,
+		client:   &http.Client{},
+	}
+
+	r.token, err = token.Parse()
+	if err != nil {
+		return remoteConfig{}, fmt.Errorf("unable to load token: %s", err)
+	}
+
+	r.token = strings.TrimSpace(r.token)
+
+	kind, scheme, _ := strings.Cut(u.Scheme, "+")
+	if len(scheme) <= 0 {
+		scheme = "http"
+	}
+
+	switch kind {
+	case "http", "https":
+		r.kind = remoteHTTP
+
+		return r, nil
+
+	case remoteEtcd, remoteConsul:
+		r.kind = kind
+
+	default:
+		return remoteConfig{}, ErrRemoteUnsupportedSource
+	}
+
+	if scheme != "http" && scheme != "https" {
+		return remoteConfig{}, ErrRemoteUnsupportedSource
+	}
+
+	r.key = strings.TrimPrefix(u.Path, "/")
+	if len(r.key) <= 0 {
+		return remoteConfig{}, ErrRemoteKeyNotSpecified
+	}
+
+	if u.User != nil {
+		r.user = u.User.Username()
+		r.password, _ = u.User.Password()
+	}
+
+	r.endpoint = url.URL{Scheme: scheme, Host: u.Host, RawQuery: u.RawQuery}
+
+	return r, nil
+}
+
+// String returns the source without the credentials
+func (r remoteConfig) String() string {
+	u := r.endpoint
+	u.User = nil
+
+	if r.kind == remoteHTTP {
+		return u.String()
+	}
+
+	u.Scheme = r.kind + "+" + u.Scheme
+	u.Path = "/" + r.key
+
+	return u.String()
+}
+
+// do sends the `req` and returns the body of the response. `wait` is how
+// long the server may hold the request
+func (r remoteConfig) do(
+	ctx context.Context,
+	req *http.Request,
+	wait time.Duration,
+) (*http.Response, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteTimeout+wait)
+	defer cancel()
+
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, remoteMaxSize))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil, ErrRemoteKeyNotFound
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf(
+			"%w: %s", ErrRemoteUnexpectedStatus, resp.Status)
+	}
+
+	return resp, data, nil
+}
+
+// fetchHTTP downloads the configuration from the URL
+func (r remoteConfig) fetchHTTP(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, r.endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	_, data, err := r.do(ctx, req, 0)
+
+	return data, err
+}
+
+// etcdPost sends the `request` to the `path` of the etcd JSON gateway, and
+// decodes the response into `response`
+func (r remoteConfig) etcdPost(
+	ctx context.Context,
+	path, token string,
+	request, response any,
+) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	u := r.endpoint
+	u.Path = path
+
+	req, err := http.NewRequest(http.MethodPost, u.String(),
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(token) > 0 {
+		req.Header.Set("Authorization", token)
+	}
+
+	_, data, err := r.do(ctx, req, 0)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, response)
+}
+
+// fetchEtcd reads the configuration from the key of etcd, through its JSON
+// gateway
+func (r remoteConfig) fetchEtcd(ctx context.Context) ([]byte, error) {
+	token := r.token
+
+	if len(r.user) > 0 {
+		auth := struct {
+			Token string `json:"token"`
+		}{}
+
+		err := r.etcdPost(ctx, "/v3/auth/authenticate", "", map[string]string{
+			"name":     r.user,
+			"password": r.password,
+		}, &auth)
+		if err != nil {
+			return nil, fmt.Errorf("unable to authenticate: %s", err)
+		}
+
+		token = auth.Token
+	}
+
+	result := struct {
+		KVs []struct {
+			Value []byte `json:"value"` // Encoded in base64
+		} `json:"kvs"`
+	}{}
+
+	err := r.etcdPost(ctx, "/v3/kv/range", token, map[string]string{
+		"key": base64.StdEncoding.EncodeToString([]byte(r.key)),
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.KVs) <= 0 {
+		return nil, ErrRemoteKeyNotFound
+	}
+
+	return result.KVs[0].Value, nil
+}
+
+// fetchConsul reads the configuration from the key of Consul. When `index`
+// is given, the request blocks until the key has changed from it or the
+// interval has passed
+func (r remoteConfig) fetchConsul(
+	ctx context.Context,
+	index string,
+) ([]byte, string, error) {
+	u := r.endpoint
+	u.Path = "/v1/kv/" + r.key
+
+	query := u.Query()
+	query.Set("raw", "")
+
+	wait := time.Duration(0)
+	if len(index) > 0 {
+		wait = r.interval
+		query.Set("index", index)
+		query.Set("wait", fmt.Sprintf("%ds", int(wait.Seconds())))
+	}
+
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(r.token) > 0 {
+		req.Header.Set("X-Consul-Token", r.token)
+	}
+
+	resp, data, err := r.do(ctx, req, wait)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, resp.Header.Get("X-Consul-Index"), nil
+}
+
+// fetch reads the configuration from the source, and returns it along with
+// its digest
+func (r remoteConfig) fetch(
+	ctx context.Context,
+	index string,
+) (data []byte, digest string, newIndex string, err error) {
+	switch r.kind {
+	case remoteEtcd:
+		data, err = r.fetchEtcd(ctx)
+
+	case remoteConsul:
+		data, newIndex, err = r.fetchConsul(ctx, index)
+
+	default:
+		data, err = r.fetchHTTP(ctx)
+	}
+
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return data, hex.EncodeToString(sum[:]), newIndex, nil
+}
+
+// Remote creates a loader that reads the configuration (in the format of the
+// configuration file) from the `source`, which is either a "http(s)://" URL,
+// a key of etcd ("etcd://host:2379/key" or "etcd+https://...") or a key of
+// Consul ("consul://host:8500/key" or "consul+https://..."). The source is
+// then checked for changes every `interval` by the RemoteWatcher
+func Remote(source string, token String, interval time.Duration) Loader {
+	return func(log log.Logger) (string, Configuration, error) {
+		r, err := parseRemote(source, token, interval)
+		if err != nil {
+			return remoteTypeName, Configuration{}, fmt.Errorf(
+				"invalid source: %s", err)
+		}
+
+		log.Info("Loading configuration from: %s", r)
+
+		data, digest, index, err := r.fetch(context.Background(), "")
+		if err != nil {
+			return remoteTypeName, Configuration{}, err
+		}
+
+		cfg, err := decodeFile(bytes.NewReader(data))
+		if err != nil {
+			return remoteTypeName, Configuration{}, err
+		}
+
+		r.digest, r.index = digest, index
+		cfg.remote = &r
+
+		return remoteTypeName, cfg, nil
+	}
+}
+
+// consulIndex returns the index to wait on after `current` has been changed
+// to `updated`. Consul may reset the index, which must be started over then
+func consulIndex(current, updated string) string {
+	c, _ := strconv.ParseUint(current, 10, 64)
+	u, err := strconv.ParseUint(updated, 10, 64)
+
+	if err != nil || u <= 0 || u < c {
+		return ""
+	}
+
+	return updated
+}
+
+// RemoteWatcher checks the source of the configuration loaded by the Remote
+// loader for changes
+type RemoteWatcher struct {
+	remote  remoteConfig
+	ctx     context.Context
+	cancel  context.CancelFunc
+	closing chan struct{}
+	wait    sync.WaitGroup
+}
+
+// remoteWatcher builds the RemoteWatcher, or nil when the configuration is
+// not loaded by the Remote loader
+func (c Configuration) remoteWatcher() *RemoteWatcher {
+	if c.remote == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &RemoteWatcher{
+		remote:  *c.remote,
+		ctx:     ctx,
+		cancel:  cancel,
+		closing: make(chan struct{}),
+	}
+}
+
+// Start starts watching. `changed` is called every time the configuration
+// has changed, so it can be loaded again
+func (w *RemoteWatcher) Start(l log.Logger, changed func()) {
+	if w == nil {
+		return
+	}
+
+	w.wait.Add(1)
+
+	go w.watch(l, changed)
+}
+
+// Close stops the watching
+func (w *RemoteWatcher) Close() {
+	if w == nil {
+		return
+	}
+
+	w.cancel()
+	close(w.closing)
+	w.wait.Wait()
+}
+
+// sleep waits for the interval, returns false when the watcher is closed
+// during the time
+func (w *RemoteWatcher) sleep() bool {
+	timer := time.NewTimer(w.remote.interval)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-w.closing:
+		return false
+	}
+}
+
+func (w *RemoteWatcher) watch(l log.Logger, changed func()) {
+	defer w.wait.Done()
+
+	for {
+		// Consul holds the requests until the key has changed, so there is
+		// no need to wait between them unless it has failed
+		blocking := w.remote.kind == remoteConsul && len(w.remote.index) > 0
+
+		if !blocking && !w.sleep() {
+			return
+		}
+
+		_, digest, index, err := w.remote.fetch(w.ctx, w.remote.index)
+
+		select {
+		case <-w.closing:
+			return
+		default:
+		}
+
+		if err != nil {
+			l.Warning("Unable to check %s: %s", w.remote, err)
+
+			if blocking && !w.sleep() {
+				return
+			}
+
+			continue
+		}
+
+		w.remote.index = consulIndex(w.remote.index, index)
+
+		if digest == w.remote.digest {
+			continue
+		}
+
+		// Only report the same content once
+		w.remote.digest = digest
+
+		l.Info("Configuration has been changed, reloading")
+
+		changed()
+	}
+}
@@ -0,0 +1,295 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nirui/sshwifty/application/log"
+)
+
+const (
+	mountedCheckInterval = 10 * time.Second
+	mountedReadAttempts  = 5
+)
+
+// mountedFile is a collection file read from one of the MountedDirectories
+type mountedFile struct {
+	dir  string
+	name string
+	data []byte
+}
+
+// readMountedFiles reads the collection files (i.e. "presets.json") in the
+// `dirs` and returns them with their digest. Missing files are skipped
+func readMountedFiles(dirs []string) ([]mountedFile, string, error) {
+	files := make([]mountedFile, 0, len(dirs)*3)
+	h := sha256.New()
+	size := [8]byte{}
+
+	for _, dir := range dirs {
+		for _, name := range []string{
+			ProvisionPresets, ProvisionPolicies, ProvisionUsers,
+		} {
+			data, err := os.ReadFile(filepath.Join(dir, name+".json"))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, "", err
+			}
+
+			files = append(files, mountedFile{
+				dir:  dir,
+				name: name,
+				data: data,
+			})
+
+			h.Write([]byte(dir))
+			h.Write([]byte{0})
+			h.Write([]byte(name))
+			h.Write([]byte{0})
+			binary.BigEndian.PutUint64(size[:], uint64(len(data)))
+			h.Write(size[:])
+			h.Write(data)
+		}
+	}
+
+	return files, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadMountedState reads the state in the `dirs`. The files of a ConfigMap
+// or Secret volume are swapped all at once by Kubernetes, but they can still
+// be read in the middle of a swap, so the files are read until two reads in
+// a row give the same result
+func loadMountedState(dirs []string) (provisionedState, string, error) {
+	s := provisionedState{}
+
+	files, digest, err := readMountedFiles(dirs)
+	for i := 0; err == nil && i < mountedReadAttempts; i++ {
+		var again []mountedFile
+		var againDigest string
+
+		again, againDigest, err = readMountedFiles(dirs)
+		if err == nil && againDigest == digest {
+			break
+		}
+
+		files, digest = again, againDigest
+	}
+	if err != nil {
+		return s, "", fmt.Errorf("unable to read mounted directories: %s", err)
+	}
+
+	s.Users = map[string]provisionedUser{}
+
+	for _, f := range files {
+		fs := provisionedState{}
+		fc, _ := fs.collection(f.name)
+
+		decoder := json.NewDecoder(bytes.NewReader(f.data))
+		decoder.DisallowUnknownFields()
+
+		err := decoder.Decode(fc)
+		if err != nil {
+			return s, digest, fmt.Errorf("invalid %q: %s",
+				filepath.Join(f.dir, f.name+".json"), err)
+		}
+
+		s.Presets = append(s.Presets, fs.Presets...)
+		s.Policies.StepUpRules = append(
+			s.Policies.StepUpRules, fs.Policies.StepUpRules...)
+		s.Policies.ReverseForwardRules = append(
+			s.Policies.ReverseForwardRules,
+			fs.Policies.ReverseForwardRules...)
+
+		for user, u := range fs.Users {
+			if _, ok := s.Users[user]; ok {
+				return s, digest, fmt.Errorf(
+					"user %q in %q has already been defined", user, f.dir)
+			}
+
+			s.Users[user] = u
+		}
+	}
+
+	s.normalize()
+
+	return s, digest, nil
+}
+
+// withMounted returns the configuration with state `s` read from the
+// MountedDirectories added
+func (c Configuration) withMounted(
+	s provisionedState, digest string) (Configuration, error) {
+	merged, err := s.merge(c)
+	if err != nil {
+		return Configuration{}, fmt.Errorf(
+			"unable to apply mounted directories: %s", err)
+	}
+
+	base := c
+	merged.mountedBase = &base
+	merged.mountedDigest = digest
+
+	return merged, nil
+}
+
+// WithMounted returns the configuration with the Presets, policies and users
+// in the MountedDirectories added
+func (c Configuration) WithMounted() (Configuration, error) {
+	if len(c.MountedDirectories) <= 0 {
+		return c, nil
+	}
+
+	s, digest, err := loadMountedState(c.MountedDirectories)
+	if err != nil {
+		return Configuration{}, err
+	}
+
+	return c.withMounted(s, digest)
+}
+
+// MountedWatcher watches the MountedDirectories for changes
+type MountedWatcher struct {
+	dirs     []string
+	base     Configuration
+	digest   string
+	interval time.Duration
+	closing  chan struct{}
+	wait     sync.WaitGroup
+}
+
+// mountedWatcher builds the MountedWatcher, or nil when there is no
+// MountedDirectories to watch
+func (c Configuration) mountedWatcher() *MountedWatcher {
+	if len(c.MountedDirectories) <= 0 {
+		return nil
+	}
+
+	base := c
+	if c.mountedBase != nil {
+		base = *c.mountedBase
+	}
+
+	return &MountedWatcher{
+		dirs:     c.MountedDirectories,
+		base:     base,
+		digest:   c.mountedDigest,
+		interval: mountedCheckInterval,
+		closing:  make(chan struct{}),
+	}
+}
+
+// Start starts watching. When the content of the directories has changed,
+// the resulting configuration is passed to `verify`, and `changed` is called
+// once it's verified. Invalid changes are logged and ignored, the current
+// configuration stays in effect until a valid one is mounted
+func (w *MountedWatcher) Start(
+	l log.Logger,
+	verify func(Configuration) error,
+	changed func(),
+) {
+	if w == nil {
+		return
+	}
+
+	w.wait.Add(1)
+
+	go w.watch(l, verify, changed)
+}
+
+// Close stops the watching
+func (w *MountedWatcher) Close() {
+	if w == nil {
+		return
+	}
+
+	close(w.closing)
+	w.wait.Wait()
+}
+
+func (w *MountedWatcher) watch(
+	l log.Logger,
+	verify func(Configuration) error,
+	changed func(),
+) {
+	defer w.wait.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.closing:
+			return
+		}
+
+		if w.check(l, verify) {
+			changed()
+
+			return
+		}
+	}
+}
+
+// check returns true when the directories contain a verified change
+func (w *MountedWatcher) check(
+	l log.Logger, verify func(Configuration) error) bool {
+	s, digest, err := loadMountedState(w.dirs)
+	if len(digest) <= 0 {
+		l.Debug("Unable to check mounted directories: %s", err)
+
+		return false
+	} else if digest == w.digest {
+		return false
+	}
+
+	// Only report the same content once
+	w.digest = digest
+
+	if err != nil {
+		l.Warning("Ignored the change of mounted directories: %s", err)
+
+		return false
+	}
+
+	c, err := w.base.withMounted(s, digest)
+	if err == nil {
+		err = verify(c)
+	}
+	if err != nil {
+		l.Warning("Ignored the change of mounted directories: %s", err)
+
+		return false
+	}
+
+	l.Info("Mounted directories have been changed, reloading")
+
+	return true
+}
@@ -0,0 +1,627 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Errors
+var (
+	ErrProvisionInvalid = errors.New(
+		"invalid provisioned configuration")
+
+	ErrProvisionUnknownCollection = errors.New(
+		"unknown provisioned collection")
+
+	ErrProvisionPreconditionRequired = errors.New(
+		"the If-Match precondition is required")
+
+	ErrProvisionPreconditionFailed = errors.New(
+		"the collection has been changed since it was read")
+
+	ErrProvisionPresetNotFound = errors.New(
+		"provisioned preset was not found")
+)
+
+// Collections of the provisioned state
+const (
+	ProvisionPresets  = "presets"
+	ProvisionPolicies = "policies"
+	ProvisionUsers    = "users"
+)
+
+// provisionedPreset is a Preset provisioned declaratively. Unlike the
+// configured ones, it can't run a ProxyCommand, and it's Meta is always
+// literal, so the provisioning client can't make Sshwifty run commands or
+// read files on the server
+type provisionedPreset struct {
+	Title               string
+	Type                string
+	Host                string
+	TabColor            string
+	Meta                map[string]string
+	Watch               string
+	Tags                []string
+	FastStart           bool
+	Weight              int
+	Forwards            map[string]string
+	NoTrace             bool
+	ExpectedFingerprint string
+	Command             string
+	Environment         map[string]string
+	SSHAlgorithms       SSHAlgorithms
+	Bootstrap           string
+	HideBootstrap       bool
+	Attach              string
+	Record              bool
+}
+
+func (p provisionedPreset) preset() Preset {
+	return Preset{
+		Title:        p.Title,
+		Type:         strings.TrimSpace(p.Type),
+		Host:         p.Host,
+		TabColor:     strings.TrimSpace(p.TabColor),
+		Meta:         p.Meta,
+		ProxyCommand: nil,
+		Watch:        strings.TrimSpace(p.Watch),
+		Tags:         p.Tags,
+		FastStart:    p.FastStart,
+		Weight:       p.Weight,
+		Forwards:     p.Forwards,
+		NoTrace:      p.NoTrace,
+		ExpectedFingerprint: strings.TrimSpace(
+			p.ExpectedFingerprint),
+		Command:       strings.TrimSpace(p.Command),
+		Environment:   p.Environment,
+		SSHAlgorithms: p.SSHAlgorithms,
+		Bootstrap:     p.Bootstrap,
+		HideBootstrap: p.HideBootstrap,
+		Attach:        strings.TrimSpace(p.Attach),
+		Record:        p.Record,
+	}
+}
+
+// provisionedPolicies are the access rules provisioned declaratively
+type provisionedPolicies struct {
+	StepUpRules         []StepUpRule
+	ReverseForwardRules []string
+}
+
+// provisionedUser is a user provisioned declaratively
+type provisionedUser struct {
+	// Base32 encoded TOTP secret for the "totp" step-up authentication
+	TOTPSecret string
+}
+
+// provisionedState is the content of the ProvisionFile
+type provisionedState struct {
+	Presets  []provisionedPreset
+	Policies provisionedPolicies
+	Users    map[string]provisionedUser
+}
+
+// normalize replaces the missing collections with empty ones, so they're
+// always encoded the same way
+func (s *provisionedState) normalize() {
+	if s.Presets == nil {
+		s.Presets = []provisionedPreset{}
+	}
+
+	if s.Policies.StepUpRules == nil {
+		s.Policies.StepUpRules = []StepUpRule{}
+	}
+
+	if s.Policies.ReverseForwardRules == nil {
+		s.Policies.ReverseForwardRules = []string{}
+	}
+
+	if s.Users == nil {
+		s.Users = map[string]provisionedUser{}
+	}
+}
+
+// collection returns the pointer to the given collection of the state
+func (s *provisionedState) collection(name string) (any, error) {
+	switch name {
+	case ProvisionPresets:
+		return &s.Presets, nil
+
+	case ProvisionPolicies:
+		return &s.Policies, nil
+
+	case ProvisionUsers:
+		return &s.Users, nil
+
+	default:
+		return nil, ErrProvisionUnknownCollection
+	}
+}
+
+// merge adds the provisioned state to the configuration `c`. Provisioned
+// Presets and rules follow the configured ones
+func (s provisionedState) merge(c Configuration) (Configuration, error) {
+	presets := make([]Preset, 0, len(c.Presets)+len(s.Presets))
+	presets = append(presets, c.Presets...)
+	for _, p := range s.Presets {
+		presets = append(presets, p.preset())
+	}
+	c.Presets = presets
+
+	c.StepUpRules = append(
+		append([]StepUpRule{}, c.StepUpRules...), s.Policies.StepUpRules...)
+	c.ReverseForwardRules = append(
+		append([]string{}, c.ReverseForwardRules...),
+		s.Policies.ReverseForwardRules...)
+
+	secrets := make(map[string]string, len(c.StepUpTOTPSecrets)+len(s.Users))
+	for user, secret := range c.StepUpTOTPSecrets {
+		secrets[user] = secret
+	}
+
+	for user, u := range s.Users {
+		if _, ok := secrets[user]; ok {
+			return Configuration{}, fmt.Errorf(
+				"user %q has already been configured", user)
+		}
+
+		if len(u.TOTPSecret) > 0 {
+			secrets[user] = u.TOTPSecret
+		}
+	}
+	c.StepUpTOTPSecrets = secrets
+
+	return c, nil
+}
+
+// provisionETag returns the entity tag of the JSON encoded collection
+func provisionETag(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return "\"" + hex.EncodeToString(sum[:16]) + "\""
+}
+
+// Provision stores the Presets, policies and users that are provisioned
+// declaratively through the API, on top of the ones in the configuration
+type Provision struct {
+	path string
+	base Configuration
+	lock sync.Mutex
+}
+
+// loadProvisionedState reads the provisioned state. Missing file results an empty state
+func loadProvisionedState(path string) (provisionedState, error) {
+	s := provisionedState{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.normalize()
+
+		return s, nil
+	} else if err != nil {
+		return s, fmt.Errorf(
+			"unable to read provision file %q: %s", path, err)
+	}
+
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return s, fmt.Errorf(
+			"unable to parse provision file %q: %s", path, err)
+	}
+
+	s.normalize()
+
+	return s, nil
+}
+
+// WithProvisioned returns the configuration with the state in the
+// ProvisionFile added
+func (c Configuration) WithProvisioned() (Configuration, error) {
+	if len(c.ProvisionFile) <= 0 {
+		return c, nil
+	}
+
+	s, err := loadProvisionedState(c.ProvisionFile)
+	if err != nil {
+		return Configuration{}, err
+	}
+
+	merged, err := s.merge(c)
+	if err != nil {
+		return Configuration{}, fmt.Errorf(
+			"unable to apply provision file %q: %s", c.ProvisionFile, err)
+	}
+
+	base := c
+	merged.provisionBase = &base
+
+	return merged, nil
+}
+
+// provision builds the Provision, or nil when it's disabled
+func (c Configuration) provision() *Provision {
+	if len(c.ProvisionFile) <= 0 {
+		return nil
+	}
+
+	base := c
+	if c.provisionBase != nil {
+		base = *c.provisionBase
+	}
+
+	return &Provision{
+		path: c.ProvisionFile,
+		base: base,
+		lock: sync.Mutex{},
+	}
+}
+
+// Get returns the JSON encoded collection of given `name` and it's entity
+// tag
+func (p *Provision) Get(name string) ([]byte, string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	s, err := loadProvisionedState(p.path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	c, err := s.collection(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, provisionETag(data), nil
+}
+
+// export returns the JSON encoded provisioned state
+func (p *Provision) export() ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	s, err := loadProvisionedState(p.path)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(s)
+}
+
+// Put replaces the collection of given `name` with the JSON encoded `data`.
+// `ifMatch` must be the entity tag of the current collection, or "*". The
+// resulting configuration is passed to `verify`, and nothing is changed
+// when it fails. Returns the new entity tag, and whether or not the state
+// has been changed
+func (p *Provision) Put(
+	name string,
+	data []byte,
+	ifMatch string,
+	verify func(Configuration) error,
+) (string, bool, error) {
+	if len(ifMatch) <= 0 {
+		return "", false, ErrProvisionPreconditionRequired
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	s, err := loadProvisionedState(p.path)
+	if err != nil {
+		return "", false, err
+	}
+
+	c, err := s.collection(name)
+	if err != nil {
+		return "", false, err
+	}
+
+	current, err := json.Marshal(c)
+	if err != nil {
+		return "", false, err
+	}
+
+	etag := provisionETag(current)
+	if ifMatch != "*" && ifMatch != etag {
+		return etag, false, ErrProvisionPreconditionFailed
+	}
+
+	// Decode into an empty collection, so fields missing from `data` are
+	// reset instead of being kept
+	updated := provisionedState{}
+	uc, _ := updated.collection(name)
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(uc)
+	if err != nil {
+		return etag, false, fmt.Errorf(
+			"%w: invalid %s: %s", ErrProvisionInvalid, name, err)
+	}
+
+	updated.normalize()
+
+	switch name {
+	case ProvisionPresets:
+		s.Presets = updated.Presets
+
+	case ProvisionPolicies:
+		s.Policies = updated.Policies
+
+	case ProvisionUsers:
+		s.Users = updated.Users
+	}
+
+	newData, err := json.Marshal(uc)
+	if err != nil {
+		return etag, false, err
+	}
+
+	newETag := provisionETag(newData)
+	if newETag == etag {
+		return etag, false, nil
+	}
+
+	if err := p.save(s, verify); err != nil {
+		return etag, false, err
+	}
+
+	return newETag, true, nil
+}
+
+// save verifies the configuration with the state `s` applied by `verify`,
+// and writes the state into the ProvisionFile. Caller must hold the lock
+func (p *Provision) save(
+	s provisionedState,
+	verify func(Configuration) error,
+) error {
+	merged, err := s.merge(p.base)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrProvisionInvalid, err)
+	}
+
+	err = verify(merged)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrProvisionInvalid, err)
+	}
+
+	stateData, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := p.path + ".tmp"
+
+	err = os.WriteFile(tmpPath, stateData, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, p.path)
+}
+
+// decodeProvisionedPreset decodes the JSON encoded Preset `data`
+func decodeProvisionedPreset(data []byte) (provisionedPreset, error) {
+	preset := provisionedPreset{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&preset); err != nil {
+		return provisionedPreset{}, fmt.Errorf(
+			"%w: invalid preset: %s", ErrProvisionInvalid, err)
+	}
+
+	return preset, nil
+}
+
+// GetPreset returns the JSON encoded provisioned Preset at the `index`, and
+// the entity tag of the Presets collection
+func (p *Provision) GetPreset(index int) ([]byte, string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	s, err := loadProvisionedState(p.path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	all, err := json.Marshal(s.Presets)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if index < 0 || index >= len(s.Presets) {
+		return nil, "", ErrProvisionPresetNotFound
+	}
+
+	data, err := json.Marshal(s.Presets[index])
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, provisionETag(all), nil
+}
+
+// editPresets changes the provisioned Presets with `edit`. `ifMatch` must be
+// the entity tag of the current Presets collection, or "*". The resulting
+// configuration is passed to `verify`, and nothing is changed when it fails.
+// Returns the new entity tag of the collection, and whether or not it has
+// been changed
+func (p *Provision) editPresets(
+	ifMatch string,
+	verify func(Configuration) error,
+	edit func([]provisionedPreset) ([]provisionedPreset, error),
+) (string, bool, error) {
+	if len(ifMatch) <= 0 {
+		return "", false, ErrProvisionPreconditionRequired
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	s, err := loadProvisionedState(p.path)
+	if err != nil {
+		return "", false, err
+	}
+
+	current, err := json.Marshal(s.Presets)
+	if err != nil {
+		return "", false, err
+	}
+
+	etag := provisionETag(current)
+	if ifMatch != "*" && ifMatch != etag {
+		return etag, false, ErrProvisionPreconditionFailed
+	}
+
+	presets, err := edit(append([]provisionedPreset{}, s.Presets...))
+	if err != nil {
+		return etag, false, err
+	}
+
+	s.Presets = presets
+	s.normalize()
+
+	newData, err := json.Marshal(s.Presets)
+	if err != nil {
+		return etag, false, err
+	}
+
+	newETag := provisionETag(newData)
+	if newETag == etag {
+		return etag, false, nil
+	}
+
+	if err := p.save(s, verify); err != nil {
+		return etag, false, err
+	}
+
+	return newETag, true, nil
+}
+
+// AddPreset appends the JSON encoded Preset `data` to the provisioned ones,
+// and returns it's index along with the new entity tag of the collection.
+// See Put for the `ifMatch` and the `verify`
+func (p *Provision) AddPreset(
+	data []byte,
+	ifMatch string,
+	verify func(Configuration) error,
+) (int, string, error) {
+	preset, err := decodeProvisionedPreset(data)
+	if err != nil {
+		return 0, "", err
+	}
+
+	index := 0
+
+	etag, _, err := p.editPresets(ifMatch, verify,
+		func(presets []provisionedPreset) ([]provisionedPreset, error) {
+			index = len(presets)
+
+			return append(presets, preset), nil
+		})
+
+	return index, etag, err
+}
+
+// ReplacePreset replaces the provisioned Preset at the `index` with the JSON
+// encoded Preset `data`. See Put for the `ifMatch`, the `verify` and the
+// results
+func (p *Provision) ReplacePreset(
+	index int,
+	data []byte,
+	ifMatch string,
+	verify func(Configuration) error,
+) (string, bool, error) {
+	preset, err := decodeProvisionedPreset(data)
+	if err != nil {
+		return "", false, err
+	}
+
+	return p.editPresets(ifMatch, verify,
+		func(presets []provisionedPreset) ([]provisionedPreset, error) {
+			if index < 0 || index >= len(presets) {
+				return nil, ErrProvisionPresetNotFound
+			}
+
+			presets[index] = preset
+
+			return presets, nil
+		})
+}
+
+// DeletePreset removes the provisioned Preset at the `index`. See Put for
+// the `ifMatch`, the `verify` and the results
+func (p *Provision) DeletePreset(
+	index int,
+	ifMatch string,
+	verify func(Configuration) error,
+) (string, bool, error) {
+	return p.editPresets(ifMatch, verify,
+		func(presets []provisionedPreset) ([]provisionedPreset, error) {
+			if index < 0 || index >= len(presets) {
+				return nil, ErrProvisionPresetNotFound
+			}
+
+			return append(presets[:index], presets[index+1:]...), nil
+		})
+}
+
+// MovePreset moves the provisioned Preset at the `index` to the index `to`,
+// shifting the ones in between. See Put for the `ifMatch`, the `verify` and
+// the results
+func (p *Provision) MovePreset(
+	index, to int,
+	ifMatch string,
+	verify func(Configuration) error,
+) (string, bool, error) {
+	return p.editPresets(ifMatch, verify,
+		func(presets []provisionedPreset) ([]provisionedPreset, error) {
+			if index < 0 || index >= len(presets) {
+				return nil, ErrProvisionPresetNotFound
+			}
+
+			if to < 0 || to >= len(presets) {
+				return nil, fmt.Errorf("%w: index %d is out of range",
+					ErrProvisionInvalid, to)
+			}
+
+			moving := presets[index]
+			presets = append(presets[:index], presets[index+1:]...)
+			presets = append(presets[:to],
+				append([]provisionedPreset{moving}, presets[to:]...)...)
+
+			return presets, nil
+		})
+}
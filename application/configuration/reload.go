@@ -0,0 +1,120 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"reflect"
+
+	"github.com/nirui/sshwifty/application/network"
+)
+
+// reloadable returns whether or not the `s` only differs from the `current`
+// in the settings that can be applied to a running server, which are the TLS
+// certificate and the ServerMessage
+func (s Server) reloadable(current Server) bool {
+	if s.IsTLS() != current.IsTLS() {
+		return false
+	}
+
+	s.TLSCertificateFile, current.TLSCertificateFile = "", ""
+	s.TLSCertificateKeyFile, current.TLSCertificateKeyFile = "", ""
+	s.ServerMessage, current.ServerMessage = "", ""
+
+	return s == current
+}
+
+// withoutReloadable returns a copy of current Configuration without the
+// settings that can be reloaded
+func (c Configuration) withoutReloadable() Configuration {
+	c.DialTimeout = 0
+	c.Socks5 = ""
+	c.Socks5User = ""
+	c.Socks5Password = ""
+	c.WireGuard = nil
+	c.TCPKeepAliveIdle = 0
+	c.TCPKeepAliveInterval = 0
+	c.TCPKeepAliveCount = 0
+	c.DNSCacheSize = 0
+	c.DNSCacheMaxTTL = 0
+	c.DNSCacheNegativeTTL = 0
+	c.UsageNetworks = nil
+	c.WatchInterval = 0
+	c.FastStartConnections = 0
+	c.FastStartRevalidation = 0
+	c.Hooks = nil
+	c.HookTimeout = 0
+	c.AsyncHookWorkers = 0
+	c.AsyncHookQueue = 0
+	c.Servers = nil
+	c.Presets = nil
+	c.OnlyAllowPresetRemotes = false
+	c.provisionBase = nil
+	c.mountedBase = nil
+	c.inventoryDigest = ""
+	c.remote = nil
+
+	return c
+}
+
+// Reloadable returns whether or not the `c` only differs from the `current`
+// in the settings that can be applied without restarting the servers. They
+// are the Presets, the Hooks, the settings of the dialer (including the
+// OnlyAllowPresetRemotes) and the TLS certificates
+func (c Configuration) Reloadable(current Configuration) bool {
+	if len(c.Servers) != len(current.Servers) {
+		return false
+	}
+
+	for i := range c.Servers {
+		if !c.Servers[i].reloadable(current.Servers[i]) {
+			return false
+		}
+	}
+
+	return reflect.DeepEqual(
+		c.withoutReloadable(), current.withoutReloadable())
+}
+
+// Reloaded returns the `current` with the settings that can be reloaded
+// replaced by the ones of the `c`. The states kept by the `current` (i.e. the
+// traffic usage and the connected sessions) are carried over. The Watcher and
+// the Warmup are rebuilt, so the new ones must be started, and the old ones
+// of the `current` must be closed once they're replaced. The Provision is
+// rebuilt as well, so it validates against the reloaded configuration
+func (c Configuration) Reloaded(current Common) (Common, error) {
+	rawDialer, err := c.Dialer()
+	if err != nil {
+		return Common{}, err
+	}
+
+	dialer := network.TrafficDial(
+		current.Usage, c.trafficClassifier(), rawDialer)
+	presets := c.presets()
+
+	current.Dialer = dialer
+	current.DialTimeout = c.DialTimeout
+	current.Presets = presets
+	current.Watcher = c.watcher(rawDialer, presets)
+	current.Warmup = c.warmup(dialer, presets)
+	current.Hooks = c.hookSettings()
+	current.OnlyAllowPresetRemotes = c.OnlyAllowPresetRemotes
+	current.Sandboxes = c.sandboxes()
+	current.Provision = c.provision()
+
+	return current, nil
+}
@@ -0,0 +1,420 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nirui/sshwifty/application/hostkeys"
+	"github.com/nirui/sshwifty/application/journal"
+	"github.com/nirui/sshwifty/application/keyvault"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// ReplicationPath is the path of the replication endpoint of the primary
+const ReplicationPath = "/sshwifty/replication"
+
+const (
+	replicationCheckInterval = 10 * time.Second
+	replicationTimeout       = 30 * time.Second
+	replicationMaxSize       = 16 * 1024 * 1024
+)
+
+// Errors
+var (
+	ErrReplicationUnexpectedStatus = errors.New(
+		"unexpected response status")
+)
+
+// ReplicationSnapshot is the state replicated from the primary instance to
+// the standby ones. Stores that are not enabled on the primary are null, and
+// the standby keeps its own
+type ReplicationSnapshot struct {
+	KnownHosts *string         `json:"known_hosts"`
+	KeyVault   []keyvault.Key  `json:"key_vault"`
+	Journal    []journal.Event `json:"journal"`
+	Provision  json.RawMessage `json:"provision"`
+}
+
+// ReplicationSource contains the stores of the primary instance that are
+// replicated. Nil stores are not replicated
+type ReplicationSource struct {
+	KnownHosts *hostkeys.Store
+	KeyVault   *keyvault.Vault
+	Journal    *journal.Journal
+	Provision  *Provision
+}
+
+// Snapshot returns the JSON encoded ReplicationSnapshot of the stores, and
+// it's entity tag
+func (r ReplicationSource) Snapshot() ([]byte, string, error) {
+	s := ReplicationSnapshot{}
+
+	if r.KnownHosts != nil {
+		data, err := r.KnownHosts.Export()
+		if err != nil {
+			return nil, "", err
+		}
+
+		knownHosts := string(data)
+		s.KnownHosts = &knownHosts
+	}
+
+	if r.KeyVault != nil {
+		s.KeyVault = r.KeyVault.List()
+	}
+
+	if r.Journal != nil {
+		s.Journal = r.Journal.Events(0)
+	}
+
+	if r.Provision != nil {
+		var err error
+
+		s.Provision, err = r.Provision.export()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, provisionETag(data), nil
+}
+
+// verifyReplicateFrom verifies the address of the primary instance
+func verifyReplicateFrom(primary string) error {
+	u, err := url.Parse(primary)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("must start with \"http://\" or \"https://\"")
+	}
+
+	if len(u.Host) <= 0 {
+		return errors.New("host must be specified")
+	}
+
+	return nil
+}
+
+// Replica follows the state of the primary instance given in ReplicateFrom
+type Replica struct {
+	endpoint       string
+	token          string
+	knownHostsFile string
+	keyVaultFile   string
+	journalFile    string
+	journalPolicy  journal.Policy
+	provisionFile  string
+	base           Configuration
+	client         *http.Client
+	etag           string
+	closing        chan struct{}
+	wait           sync.WaitGroup
+}
+
+// replica builds the Replica, or nil when current instance is not a standby
+func (c Configuration) replica() *Replica {
+	if len(c.ReplicateFrom) <= 0 {
+		return nil
+	}
+
+	base := c
+	if c.provisionBase != nil {
+		base = *c.provisionBase
+	}
+
+	endpoint := strings.TrimRight(c.ReplicateFrom, "/") + ReplicationPath
+
+	return &Replica{
+		endpoint:       endpoint,
+		token:          c.ReplicationToken,
+		knownHostsFile: c.KnownHostsFile,
+		keyVaultFile:   c.KeyVaultFile,
+		journalFile:    c.JournalFile,
+		journalPolicy:  c.journalPolicy(),
+		provisionFile:  c.ProvisionFile,
+		base:           base,
+		client:         &http.Client{Timeout: replicationTimeout},
+		etag:           "",
+		closing:        make(chan struct{}),
+	}
+}
+
+// Start starts following the primary. When the replicated provision state
+// has changed, the resulting configuration is passed to `verify`, and
+// `changed` is called once it's verified and applied. The other stores are
+// applied directly
+func (r *Replica) Start(
+	l log.Logger,
+	verify func(Configuration) error,
+	changed func(),
+) {
+	if r == nil {
+		return
+	}
+
+	r.wait.Add(1)
+
+	go r.follow(l, verify, changed)
+}
+
+// Close stops the following
+func (r *Replica) Close() {
+	if r == nil {
+		return
+	}
+
+	close(r.closing)
+	r.wait.Wait()
+}
+
+func (r *Replica) follow(
+	l log.Logger,
+	verify func(Configuration) error,
+	changed func(),
+) {
+	defer r.wait.Done()
+
+	ticker := time.NewTicker(replicationCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if r.check(l, verify) {
+			changed()
+
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-r.closing:
+			return
+		}
+	}
+}
+
+// fetch downloads the snapshot from the primary. It returns false when the
+// snapshot is not modified since the last fetch
+func (r *Replica) fetch() (ReplicationSnapshot, string, bool, error) {
+	s := ReplicationSnapshot{}
+
+	req, err := http.NewRequest(http.MethodGet, r.endpoint, nil)
+	if err != nil {
+		return s, "", false, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+r.token)
+
+	if len(r.etag) > 0 {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return s, "", false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return s, r.etag, false, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return s, "", false, fmt.Errorf(
+			"%w: %s", ErrReplicationUnexpectedStatus, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, replicationMaxSize))
+	if err != nil {
+		return s, "", false, err
+	}
+
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return s, "", false, err
+	}
+
+	return s, resp.Header.Get("ETag"), true, nil
+}
+
+// check fetches and applies the snapshot of the primary, returns true when
+// the provision state has been changed
+func (r *Replica) check(l log.Logger, verify func(Configuration) error) bool {
+	s, etag, modified, err := r.fetch()
+	if err != nil {
+		l.Warning("Unable to fetch the state of the primary: %s", err)
+
+		return false
+	} else if !modified {
+		return false
+	}
+
+	// Only apply the same snapshot once
+	r.etag = etag
+
+	if err := r.applyStores(s); err != nil {
+		l.Warning("Unable to apply the state of the primary: %s", err)
+	}
+
+	changed, err := r.applyProvision(s.Provision, verify)
+	if err != nil {
+		l.Warning("Ignored the provision state of the primary: %s", err)
+
+		return false
+	}
+
+	if changed {
+		l.Info("Provision state of the primary has been changed, reloading")
+	}
+
+	return changed
+}
+
+// applyStores replaces the content of the known hosts, the key vault and
+// the journal with the ones in `s`
+func (r *Replica) applyStores(s ReplicationSnapshot) error {
+	if s.KnownHosts != nil && len(r.knownHostsFile) > 0 {
+		known, err := hostkeys.Open(r.knownHostsFile)
+		if err != nil {
+			return err
+		}
+
+		current, err := known.Export()
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(current, []byte(*s.KnownHosts)) {
+			err = known.Replace([]byte(*s.KnownHosts))
+			if err != nil {
+				return fmt.Errorf("unable to replace known hosts: %s", err)
+			}
+		}
+	}
+
+	if s.KeyVault != nil && len(r.keyVaultFile) > 0 {
+		vault, err := keyvault.Open(r.keyVaultFile)
+		if err != nil {
+			return err
+		}
+
+		err = vault.Replace(s.KeyVault)
+		if err != nil {
+			return fmt.Errorf("unable to replace key vault: %s", err)
+		}
+	}
+
+	if s.Journal != nil && len(r.journalFile) > 0 {
+		j, err := journal.Open(r.journalFile, r.journalPolicy)
+		if err != nil {
+			return err
+		}
+
+		err = j.Replace(s.Journal)
+		if err != nil {
+			return fmt.Errorf("unable to replace journal: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// applyProvision writes the provision state `data` into the ProvisionFile
+// once it's verified. Returns true when the state has been changed
+func (r *Replica) applyProvision(
+	data json.RawMessage,
+	verify func(Configuration) error,
+) (bool, error) {
+	if len(r.provisionFile) <= 0 || len(data) <= 0 ||
+		bytes.Equal(data, []byte("null")) {
+		return false, nil
+	}
+
+	s := provisionedState{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(&s)
+	if err != nil {
+		return false, err
+	}
+
+	s.normalize()
+
+	current, err := loadProvisionedState(r.provisionFile)
+	if err != nil {
+		return false, err
+	}
+
+	currentData, err := json.Marshal(current)
+	if err != nil {
+		return false, err
+	}
+
+	newData, err := json.Marshal(s)
+	if err != nil {
+		return false, err
+	}
+
+	if bytes.Equal(currentData, newData) {
+		return false, nil
+	}
+
+	merged, err := s.merge(r.base)
+	if err == nil {
+		err = verify(merged)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	stateData, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return false, err
+	}
+
+	tmpPath := r.provisionFile + ".tmp"
+
+	err = os.WriteFile(tmpPath, stateData, 0600)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.Rename(tmpPath, r.provisionFile)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,89 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nirui/sshwifty/application/network"
+)
+
+// Sandbox contains the hard limits of the connections to a sandbox Preset,
+// which connects the users to a disposable container or VM started by it's
+// ProxyCommand (i.e. "docker run --rm -i"). Once there's any sandbox
+// Preset, the anonymous users can only connect to the sandbox Presets
+type Sandbox struct {
+	MaxDuration time.Duration // Connections are ended once lasted this long
+	MaxBytes    uint64        // Or transferred this many bytes in total
+}
+
+// verifySandbox returns an error when the Preset can't be a sandbox
+func (p Preset) verifySandbox() error {
+	if p.Sandbox == nil {
+		return nil
+	}
+
+	if p.Type != "SSH" && p.Type != "Telnet" {
+		return errors.New("only SSH and Telnet Presets can be sandboxes")
+	}
+
+	// Every connection must reach a container of it's own
+	if len(p.ProxyCommand) <= 0 {
+		return errors.New("the ProxyCommand which starts the disposable " +
+			"container or VM is required")
+	}
+
+	// Both of them would start the containers without the users
+	if len(p.Watch) > 0 || p.FastStart {
+		return errors.New("sandboxes can't be Watched or FastStarted")
+	}
+
+	if p.Sandbox.MaxDuration <= 0 {
+		return errors.New("MaxDuration is required")
+	}
+
+	if p.Sandbox.MaxBytes <= 0 {
+		return errors.New("MaxBytes is required")
+	}
+
+	return nil
+}
+
+// sandboxes returns the remotes of the sandbox Presets and the protocols
+// they're reached with, or nil when there's none of them
+func (c Configuration) sandboxes() network.AllowedTargets {
+	var targets network.AllowedTargets
+
+	for _, p := range c.Presets {
+		if p.Sandbox == nil {
+			continue
+		}
+
+		if targets == nil {
+			targets = network.AllowedTargets{}
+		}
+
+		targets[network.DialTarget{
+			Protocol: p.Type,
+			Address:  p.address(),
+		}] = struct{}{}
+	}
+
+	return targets
+}
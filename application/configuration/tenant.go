@@ -0,0 +1,282 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nirui/sshwifty/application/deeplink"
+	"github.com/nirui/sshwifty/application/forward"
+	"github.com/nirui/sshwifty/application/handover"
+	"github.com/nirui/sshwifty/application/kiosk"
+	"github.com/nirui/sshwifty/application/network"
+	"github.com/nirui/sshwifty/application/recording"
+	"github.com/nirui/sshwifty/application/stepup"
+	"github.com/nirui/sshwifty/application/upgrade"
+	"github.com/nirui/sshwifty/application/warmup"
+	"github.com/nirui/sshwifty/application/watcher"
+)
+
+// tenantNameMatcher matches the valid names of the tenants, which are also
+// used as the value of the cookie that selects them
+var tenantNameMatcher = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Tenant is a namespace of the users, Presets, policies, trust stores and
+// recordings, selected by the host name or the path that the users reach
+// Sshwifty through. The settings of a tenant are never inherited from the
+// Configuration, so the tenants can't see the states of each other, i.e.
+// a tenant without it's KnownHostsFile doesn't record the host keys. Other
+// settings (i.e. the timeouts, the SSH algorithms and the audit sinks) are
+// shared
+type Tenant struct {
+	Name                   string
+	HostNames              []string
+	PathPrefix             string
+	MaxClients             int
+	SharedKey              string
+	KeyVaultFile           string
+	KnownHostsFile         string
+	UserSettingsFile       string
+	PasskeyFile            string
+	PasskeyRPID            string
+	JournalFile            string
+	ReverseForwardRules    []string
+	Recording              Recording
+	StepUpRules            []StepUpRule
+	StepUpTOTPSecrets      map[string]string
+	Presets                []Preset
+	OnlyAllowPresetRemotes bool
+}
+
+// tenant returns the Configuration of the tenant `t`
+func (c Configuration) tenant(t Tenant) Configuration {
+	tc := c
+	tc.HostName = ""
+	tc.SharedKey = t.SharedKey
+	tc.KeyVaultFile = t.KeyVaultFile
+	tc.KnownHostsFile = t.KnownHostsFile
+	tc.UserSettingsFile = t.UserSettingsFile
+	tc.PasskeyFile = t.PasskeyFile
+	tc.PasskeyRPID = t.PasskeyRPID
+	tc.JournalFile = t.JournalFile
+	tc.ReverseForwardRules = t.ReverseForwardRules
+	tc.Recording = t.Recording
+	tc.StepUpRules = t.StepUpRules
+	tc.StepUpTOTPSecrets = t.StepUpTOTPSecrets
+	tc.Presets = t.Presets
+	tc.OnlyAllowPresetRemotes = t.OnlyAllowPresetRemotes
+
+	// The deep links and the kiosks point to the Presets by their index,
+	// which could be the one of another tenant
+	tc.DeepLinkKey = ""
+	tc.Kiosk = Kiosk{}
+
+	// Managing Sshwifty is up to the administrator of the deployment
+	tc.ManagementToken = ""
+	tc.ProvisionFile = ""
+	tc.MountedDirectories = nil
+	tc.ReplicateFrom = ""
+	tc.ReplicationToken = ""
+	tc.Inventory = Inventory{}
+	tc.Tenants = nil
+
+	return tc
+}
+
+// verifyTenants verifies the Tenants, and the Configuration of each of them
+func (c Configuration) verifyTenants() error {
+	names := map[string]struct{}{}
+	hostNames := map[string]string{}
+	pathPrefixes := map[string]string{}
+
+	for _, t := range c.Tenants {
+		if !tenantNameMatcher.MatchString(t.Name) {
+			return fmt.Errorf("invalid Name %q of Tenant: must only contain "+
+				"letters, digits, \"_\" and \"-\"", t.Name)
+		}
+
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("Tenant %q is defined more than once", t.Name)
+		}
+		names[t.Name] = struct{}{}
+
+		if len(t.HostNames) <= 0 && len(t.PathPrefix) <= 0 {
+			return fmt.Errorf("Tenant %q must be selected by either the "+
+				"HostNames or the PathPrefix", t.Name)
+		}
+
+		for _, h := range t.HostNames {
+			if len(h) <= 0 {
+				return fmt.Errorf("invalid HostNames of Tenant %q: must "+
+					"not be empty", t.Name)
+			}
+
+			if other, ok := hostNames[h]; ok {
+				return fmt.Errorf("host name %q of Tenant %q is already "+
+					"used by Tenant %q", h, t.Name, other)
+			}
+			hostNames[h] = t.Name
+		}
+
+		if len(t.PathPrefix) > 0 {
+			if !strings.HasPrefix(t.PathPrefix, "/") ||
+				strings.HasSuffix(t.PathPrefix, "/") ||
+				strings.HasPrefix(t.PathPrefix+"/", "/sshwifty/") {
+				return fmt.Errorf("invalid PathPrefix %q of Tenant %q: "+
+					"must start but not end with \"/\", and must not be "+
+					"under \"/sshwifty\"", t.PathPrefix, t.Name)
+			}
+
+			if other, ok := pathPrefixes[t.PathPrefix]; ok {
+				return fmt.Errorf("PathPrefix %q of Tenant %q is already "+
+					"used by Tenant %q", t.PathPrefix, t.Name, other)
+			}
+			pathPrefixes[t.PathPrefix] = t.Name
+		}
+
+		if t.MaxClients < 0 {
+			return fmt.Errorf("invalid MaxClients of Tenant %q: must not be "+
+				"negative", t.Name)
+		}
+
+		if err := c.tenant(t).Verify(); err != nil {
+			return fmt.Errorf("invalid Tenant %q: %s", t.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// TenantCommon is the settings of a tenant which differ from the Common
+type TenantCommon struct {
+	Name                   string
+	HostNames              []string
+	PathPrefix             string
+	MaxClients             int
+	Clients                *upgrade.Sessions
+	SharedKey              string
+	KeyVaultFile           string
+	KnownHostsFile         string
+	UserSettingsFile       string
+	PasskeyFile            string
+	PasskeyRPID            string
+	JournalFile            string
+	Presets                []Preset
+	Watcher                *watcher.Watcher
+	Warmup                 *warmup.Pool
+	Handover               *handover.Registry
+	ReverseForwardPolicy   forward.Policy
+	Recorder               *recording.Recorder
+	RecordAllSessions      bool
+	StepUpRules            []StepUpRule
+	StepUpVerifier         *stepup.Verifier
+	OnlyAllowPresetRemotes bool
+	Sandboxes              network.AllowedTargets
+}
+
+// tenants builds the TenantCommon of the Tenants. The `dial` is the one that
+// isn't counted into the traffic usage, and the `countedDial` is the one that
+// is
+func (c Configuration) tenants(
+	dial network.Dial,
+	countedDial network.Dial,
+) []TenantCommon {
+	tenants := make([]TenantCommon, 0, len(c.Tenants))
+
+	for _, t := range c.Tenants {
+		tc := c.tenant(t)
+		presets := tc.presets()
+
+		// Rules are checked by Verify
+		reverseForwardPolicy, _ := forward.ParsePolicy(tc.ReverseForwardRules)
+
+		hostNames := make([]string, len(t.HostNames))
+		for i, h := range t.HostNames {
+			hostNames[i] = strings.ToLower(h)
+		}
+
+		tenants = append(tenants, TenantCommon{
+			Name:                   t.Name,
+			HostNames:              hostNames,
+			PathPrefix:             t.PathPrefix,
+			MaxClients:             t.MaxClients,
+			Clients:                upgrade.NewSessions(),
+			SharedKey:              tc.SharedKey,
+			KeyVaultFile:           tc.KeyVaultFile,
+			KnownHostsFile:         tc.KnownHostsFile,
+			UserSettingsFile:       tc.UserSettingsFile,
+			PasskeyFile:            tc.PasskeyFile,
+			PasskeyRPID:            tc.PasskeyRPID,
+			JournalFile:            tc.JournalFile,
+			Presets:                presets,
+			Watcher:                tc.watcher(dial, presets),
+			Warmup:                 tc.warmup(countedDial, presets),
+			Handover:               tc.handover(),
+			ReverseForwardPolicy:   reverseForwardPolicy,
+			Recorder:               tc.Recording.recorder(),
+			RecordAllSessions:      tc.Recording.AllSessions,
+			StepUpRules:            tc.StepUpRules,
+			StepUpVerifier:         tc.stepUpVerifier(),
+			OnlyAllowPresetRemotes: tc.OnlyAllowPresetRemotes,
+			Sandboxes:              tc.sandboxes(),
+		})
+	}
+
+	return tenants
+}
+
+// Common returns the Common of the tenant, which shares everything else with
+// the `c`
+func (t TenantCommon) Common(c Common) Common {
+	c.HostName = ""
+	c.Tenant = t.Name
+	c.Tenants = nil
+	c.MaxClients = t.MaxClients
+	c.Clients = t.Clients
+	c.SharedKey = t.SharedKey
+	c.KeyVaultFile = t.KeyVaultFile
+	c.KnownHostsFile = t.KnownHostsFile
+	c.UserSettingsFile = t.UserSettingsFile
+	c.PasskeyFile = t.PasskeyFile
+	c.PasskeyRPID = t.PasskeyRPID
+	c.JournalFile = t.JournalFile
+	c.Presets = t.Presets
+	c.Watcher = t.Watcher
+	c.Warmup = t.Warmup
+	c.Handover = t.Handover
+	c.ReverseForwardPolicy = t.ReverseForwardPolicy
+	c.Recorder = t.Recorder
+	c.RecordAllSessions = t.RecordAllSessions
+	c.StepUpRules = t.StepUpRules
+	c.StepUpVerifier = t.StepUpVerifier
+	c.OnlyAllowPresetRemotes = t.OnlyAllowPresetRemotes
+	c.Sandboxes = t.Sandboxes
+	c.DeepLinks = deeplink.New("")
+	c.Kiosks = kiosk.New("", 0)
+	c.ManagementToken = ""
+	c.Provision = nil
+	c.Classroom = nil
+	c.Mounted = nil
+	c.Replica = nil
+	c.Inventory = nil
+	c.Remote = nil
+
+	return c
+}
@@ -0,0 +1,120 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/nirui/sshwifty/application/network"
+)
+
+// TLSWrap wraps the connections of a Preset in TLS before the SSH handshake,
+// for the SSH servers that are hidden behind TLS (i.e. on port 443) to
+// traverse the restrictive networks
+type TLSWrap struct {
+	ServerName string   // SNI, the host of the Preset by default
+	ALPN       []string // Protocols offered through ALPN, none by default
+	CAFile     string   // CAs to verify with, the system ones by default
+	SkipVerify bool     // Leave the server to the host key verification
+}
+
+// build returns the TLSWrap with the settings cleaned up
+func (t *TLSWrap) build() *TLSWrap {
+	if t == nil {
+		return nil
+	}
+
+	return &TLSWrap{
+		ServerName: strings.TrimSpace(t.ServerName),
+		ALPN:       t.ALPN,
+		CAFile:     strings.TrimSpace(t.CAFile),
+		SkipVerify: t.SkipVerify,
+	}
+}
+
+// Config returns the tls.Config used to connect to the `address`, or nil
+// when the connections are not wrapped
+func (t *TLSWrap) Config(address string) (*tls.Config, error) {
+	if t == nil {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		NextProtos:         t.ALPN,
+		InsecureSkipVerify: t.SkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if len(cfg.ServerName) <= 0 {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+
+		cfg.ServerName = host
+	}
+
+	if len(t.CAFile) > 0 {
+		data, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+
+		if !cfg.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate was found in %q",
+				t.CAFile)
+		}
+	}
+
+	return cfg, nil
+}
+
+// verifyTLS returns an error when the Preset can't be wrapped in TLS
+func (p Preset) verifyTLS() error {
+	if p.TLS == nil {
+		return nil
+	}
+
+	if p.Type != "SSH" {
+		return errors.New("only SSH Presets can be wrapped in TLS")
+	}
+
+	if _, ok := network.UnixSocketPath(p.Host); ok {
+		return errors.New("Unix socket targets can't be wrapped in TLS")
+	}
+
+	for _, proto := range p.TLS.ALPN {
+		if len(proto) <= 0 || len(proto) > 255 {
+			return fmt.Errorf("ALPN protocol %q must be 1 to 255 bytes "+
+				"long", proto)
+		}
+	}
+
+	_, err := p.TLS.Config(p.Host)
+
+	return err
+}
@@ -0,0 +1,138 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nirui/sshwifty/application/network"
+)
+
+// WireGuard is an userspace WireGuard tunnel which the Presets can be
+// reached through, so the host of Sshwifty needs no VPN to be set up
+type WireGuard struct {
+	PrivateKey String   // Private key of the local end, in base64
+	Addresses  []string // IP addresses of the local end inside the tunnel
+	DNS        []string // Resolvers reached through the tunnel, optional
+	MTU        int      // 0 to use the default
+	Peer       WireGuardPeer
+}
+
+// WireGuardPeer is the remote end of the WireGuard tunnel
+type WireGuardPeer struct {
+	PublicKey           string   // Public key of the peer, in base64
+	PresharedKey        String   // Preshared key, in base64, optional
+	Endpoint            string   // Host and port of the peer
+	AllowedIPs          []string // Networks reached through the peer
+	PersistentKeepalive int      // In seconds, 0 to disable
+}
+
+// settings returns the network.WireGuardSettings with the secrets loaded
+func (w *WireGuard) settings() (network.WireGuardSettings, error) {
+	privateKey, err := w.PrivateKey.Parse()
+	if err != nil {
+		return network.WireGuardSettings{}, fmt.Errorf(
+			"unable to load PrivateKey: %s", err)
+	}
+
+	presharedKey, err := w.Peer.PresharedKey.Parse()
+	if err != nil {
+		return network.WireGuardSettings{}, fmt.Errorf(
+			"unable to load PresharedKey of the Peer: %s", err)
+	}
+
+	return network.WireGuardSettings{
+		PrivateKey: strings.TrimSpace(privateKey),
+		Addresses:  w.Addresses,
+		DNS:        w.DNS,
+		MTU:        w.MTU,
+		Peer: network.WireGuardPeer{
+			PublicKey:    strings.TrimSpace(w.Peer.PublicKey),
+			PresharedKey: strings.TrimSpace(presharedKey),
+			Endpoint:     strings.TrimSpace(w.Peer.Endpoint),
+			AllowedIPs:   w.Peer.AllowedIPs,
+			PersistentKeepalive: time.Duration(
+				w.Peer.PersistentKeepalive) * time.Second,
+		},
+	}, nil
+}
+
+// verify verifies the WireGuard
+func (w *WireGuard) verify() error {
+	if w == nil {
+		return nil
+	}
+
+	if w.Peer.PersistentKeepalive < 0 || w.Peer.PersistentKeepalive > 65535 {
+		return errors.New("PersistentKeepalive of the Peer must be " +
+			"between 0 and 65535")
+	}
+
+	settings, err := w.settings()
+	if err != nil {
+		return err
+	}
+
+	return settings.Verify()
+}
+
+// verifyWireGuard returns an error when the Preset can't be reached through
+// the WireGuard `tunnel`
+func (p Preset) verifyWireGuard(tunnel *WireGuard) error {
+	if !p.WireGuard {
+		return nil
+	}
+
+	if tunnel == nil {
+		return errors.New("the WireGuard tunnel is not configured")
+	}
+
+	if _, ok := network.UnixSocketPath(p.Host); ok {
+		return errors.New("Unix socket targets can't be reached through " +
+			"the WireGuard tunnel")
+	}
+
+	if len(p.ProxyCommand) > 0 {
+		return errors.New("Presets with a ProxyCommand can't be reached " +
+			"through the WireGuard tunnel")
+	}
+
+	return nil
+}
+
+// wireGuardTargets returns the remotes of the Presets which are reached
+// through the WireGuard tunnel
+func (c Configuration) wireGuardTargets() network.AllowedTargets {
+	targets := network.AllowedTargets{}
+
+	for _, p := range c.Presets {
+		if !p.WireGuard {
+			continue
+		}
+
+		targets[network.DialTarget{
+			Protocol: p.Type,
+			Address:  p.address(),
+		}] = struct{}{}
+	}
+
+	return targets
+}
@@ -0,0 +1,97 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package configuration
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/nirui/sshwifty/application/network"
+)
+
+func TestWireGuard(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	w := &WireGuard{
+		PrivateKey: String("literal://" + key),
+		Addresses:  []string{"10.7.0.2"},
+		Peer: WireGuardPeer{
+			PublicKey:  key,
+			Endpoint:   "vpn.example.com:51820",
+			AllowedIPs: []string{"10.7.0.0/24"},
+		},
+	}
+
+	if err := w.verify(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	w.Peer.PersistentKeepalive = -1
+
+	if err := w.verify(); err == nil {
+		t.Error("Expecting an error for the negative PersistentKeepalive")
+	}
+
+	w.Peer.PersistentKeepalive = 25
+	w.PrivateKey = "file:///nonexistent/wireguard.key"
+
+	if err := w.verify(); err == nil {
+		t.Error("Expecting an error for the unloadable PrivateKey")
+	}
+
+	c := Configuration{Presets: []Preset{
+		{Type: "SSH", Host: "10.7.0.1:22", WireGuard: true},
+		{Type: "Telnet", Host: "10.7.0.1:23"},
+	}}
+
+	targets := c.wireGuardTargets()
+	if len(targets) != 1 ||
+		!targets.Allowed(network.DialTarget{
+			Protocol: "SSH", Address: "10.7.0.1:22"}) {
+		t.Errorf("Unexpected WireGuard targets: %v", targets)
+	}
+
+	p := c.Presets[0]
+
+	if err := p.verifyWireGuard(w); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	for _, invalid := range []func(p Preset) Preset{
+		func(p Preset) Preset { p.Host = "unix:/run/ssh.sock"; return p },
+		func(p Preset) Preset { p.ProxyCommand = []string{"nc"}; return p },
+	} {
+		if err := invalid(p).verifyWireGuard(w); err == nil {
+			t.Errorf("Expecting an error for %+v", invalid(p))
+		}
+	}
+
+	if err := p.verifyWireGuard(nil); err == nil {
+		t.Error("Expecting an error when the tunnel is not configured")
+	}
+
+	// The PrivateKey became unloadable after Verify
+	c.WireGuard = w
+
+	if _, err := c.Dialer(); err == nil {
+		t.Error("Expecting an error for the unloadable PrivateKey")
+	}
+
+	if _, err := c.Common(); err == nil {
+		t.Error("Expecting an error for the unloadable PrivateKey")
+	}
+}
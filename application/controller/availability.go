@@ -0,0 +1,76 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/watcher"
+)
+
+// Errors
+var (
+	ErrAvailabilityDisabled = NewError(
+		http.StatusNotFound, "No Preset is being watched")
+)
+
+// availability controller exposes the availability of the watched Presets
+type availability struct {
+	baseController
+
+	verifier socketVerification
+	watcher  *watcher.Watcher
+}
+
+func newAvailability(
+	verifier socketVerification,
+	w *watcher.Watcher,
+) availability {
+	return availability{
+		verifier: verifier,
+		watcher:  w,
+	}
+}
+
+func (a availability) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if a.watcher == nil {
+		return ErrAvailabilityDisabled
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	err := a.verifier.authorize(r)
+	if err != nil {
+		return err
+	}
+
+	mData, mErr := json.Marshal(a.watcher.Statuses())
+	if mErr != nil {
+		return mErr
+	}
+
+	hd.Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(mData)
+
+	return nil
+}
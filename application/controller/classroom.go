@@ -0,0 +1,385 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nirui/sshwifty/application/classroom"
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/network"
+)
+
+// Errors
+var (
+	ErrClassroomDisabled = NewError(
+		http.StatusNotFound, "Classroom is not enabled")
+
+	ErrClassroomInvalidRequest = NewError(
+		http.StatusBadRequest, "Invalid classroom request")
+
+	ErrClassroomPresetNotFound = NewError(
+		http.StatusBadRequest, "Preset of the class is not found")
+
+	ErrClassroomNotFound = NewError(
+		http.StatusNotFound, "Class is not found")
+
+	ErrClassroomSeatRefused = NewError(
+		http.StatusForbidden, "Seat is invalid or the class has ended")
+
+	ErrClassroomUnauthorized = NewError(
+		http.StatusUnauthorized, "Invalid management token")
+)
+
+const (
+	classroomSeatCookie     = "sshwifty-seat"
+	classroomSeatPath       = "/classroom"
+	classroomMaxNameLength  = 64
+	classroomMaxRequestSize = 4096
+)
+
+// classroomRequest opens a class
+type classroomRequest struct {
+	Name     string `json:"name"`
+	Preset   string `json:"preset"`
+	Seats    int    `json:"seats"`
+	Lifetime int    `json:"lifetime"` // In seconds
+}
+
+// classroomLink is the access link of a seat
+type classroomLink struct {
+	Seat int    `json:"seat"`
+	Link string `json:"link"`
+}
+
+// classroomOpened is the class that has been opened, and the access links of
+// it's seats
+type classroomOpened struct {
+	ID      string          `json:"id"`
+	Expires time.Time       `json:"expires"`
+	Links   []classroomLink `json:"links"`
+}
+
+type seatRespond struct {
+	ID      int    `json:"id"`
+	Key     string `json:"key"`
+	Class   string `json:"class"`
+	Seat    int    `json:"seat"`
+	Expires int64  `json:"expires"`
+}
+
+// seatSession returns the classroom session carried by the request `r`
+func (s socket) seatSession(r *http.Request) (classroom.Session, bool) {
+	if s.commonCfg.Classroom == nil {
+		return classroom.Session{}, false
+	}
+
+	c, err := r.Cookie(classroomSeatCookie)
+	if err != nil {
+		return classroom.Session{}, false
+	}
+
+	return s.commonCfg.Classroom.Login(c.Value)
+}
+
+// dropSeatSession ends the classroom session carried by `r`
+func (s socket) dropSeatSession(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie(classroomSeatCookie); err != nil {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     classroomSeatCookie,
+		Value:    "",
+		Path:     "/sshwifty/",
+		MaxAge:   -1,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// seatPreset returns the ID and the Preset of the class of the `session`
+func (s socket) seatPreset(
+	session classroom.Session,
+) (int, configuration.Preset, bool) {
+	return presetByTitle(s.commonCfg.Presets, session.Preset)
+}
+
+// restrictSeat restricts the `cfg` to the Preset of the class, when the
+// request `r` is sent by a student
+func (s socket) restrictSeat(
+	r *http.Request,
+	cfg command.Configuration,
+) command.Configuration {
+	session, ok := s.seatSession(r)
+	if !ok {
+		return cfg
+	}
+
+	_, preset, found := s.seatPreset(session)
+
+	cfg.Dial = network.AccessControlDial(kioskRemote{
+		cfg:    cfg,
+		preset: preset,
+		found:  found,
+	}, cfg.Dial)
+	cfg.AllowDynamicForwards = false
+	cfg.AllowLocalForwards = false
+	cfg.ReverseForwardPolicy = nil
+
+	return cfg
+}
+
+// presetByTitle returns the ID and the Preset of the `title`
+func presetByTitle(
+	presets []configuration.Preset,
+	title string,
+) (int, configuration.Preset, bool) {
+	for i := range presets {
+		if presets[i].Title != title {
+			continue
+		}
+
+		return i, presets[i], true
+	}
+
+	return -1, configuration.Preset{}, false
+}
+
+// classes controller lets the instructor open a batch of short-lived access
+// links bound to a Preset, and watch the status of every seat
+type classes struct {
+	baseController
+
+	token     string
+	classroom *classroom.Classroom
+	presets   []configuration.Preset
+}
+
+func newClasses(commonCfg configuration.Common) classes {
+	return classes{
+		token:     commonCfg.ManagementToken,
+		classroom: commonCfg.Classroom,
+		presets:   commonCfg.Presets,
+	}
+}
+
+// prepare authorizes the request
+func (c classes) prepare(w http.ResponseWriter, r *http.Request) error {
+	if c.classroom == nil || len(c.token) <= 0 {
+		return ErrClassroomDisabled
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	if !bearerAuthorized(r, c.token) {
+		return ErrClassroomUnauthorized
+	}
+
+	return nil
+}
+
+func (c classes) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if err := c.prepare(w, r); err != nil {
+		return err
+	}
+
+	mData, mErr := json.Marshal(c.classroom.Classes())
+	if mErr != nil {
+		return mErr
+	}
+
+	w.Header().Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(mData)
+
+	return nil
+}
+
+func (c classes) Post(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if err := c.prepare(w, r); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(
+		http.MaxBytesReader(w, r.Body, classroomMaxRequestSize))
+	if err != nil {
+		return ErrClassroomInvalidRequest
+	}
+
+	req := classroomRequest{}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return ErrClassroomInvalidRequest
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if len(req.Name) <= 0 || len(req.Name) > classroomMaxNameLength ||
+		!utf8.ValidString(req.Name) {
+		return ErrClassroomInvalidRequest
+	}
+
+	if _, _, found := presetByTitle(c.presets, req.Preset); !found {
+		return ErrClassroomPresetNotFound
+	}
+
+	class, err := c.classroom.Open(req.Name, req.Preset, req.Seats,
+		time.Duration(req.Lifetime)*time.Second)
+	if err != nil {
+		return NewError(http.StatusBadRequest, err.Error())
+	}
+
+	l.Info("Class %q of %d seats has been opened for Preset %q",
+		class.Name, len(class.Seats), class.Preset)
+
+	opened := classroomOpened{
+		ID:      class.ID,
+		Expires: class.Expires,
+		Links:   make([]classroomLink, len(class.Seats)),
+	}
+
+	for i, seat := range class.Seats {
+		opened.Links[i] = classroomLink{
+			Seat: seat.Number,
+			Link: classroomSeatPath + "?" + url.Values{
+				"token": []string{seat.Token},
+			}.Encode(),
+		}
+	}
+
+	mData, mErr := json.Marshal(opened)
+	if mErr != nil {
+		return mErr
+	}
+
+	w.Header().Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(mData)
+
+	return nil
+}
+
+func (c classes) Delete(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if err := c.prepare(w, r); err != nil {
+		return err
+	}
+
+	id := r.URL.Query().Get("id")
+
+	if !c.classroom.Close(id) {
+		return ErrClassroomNotFound
+	}
+
+	l.Info("Class %s has been closed by the instructor", id)
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// classroomSeat controller logs the students in with the token of their
+// seat
+type classroomSeat struct {
+	baseController
+
+	socket socket
+}
+
+func newClassroomSeat(s socket) classroomSeat {
+	return classroomSeat{
+		socket: s,
+	}
+}
+
+func (c classroomSeat) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	room := c.socket.commonCfg.Classroom
+	if room == nil {
+		return ErrClassroomDisabled
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	token := r.URL.Query().Get("token")
+
+	var session classroom.Session
+
+	err := c.socket.logins.verify(r, func() error {
+		s, ok := room.Login(token)
+		if !ok {
+			// Same as the SharedKey verification, delay the brute force
+			// attack
+			time.Sleep(500 * time.Millisecond)
+
+			return ErrClassroomSeatRefused
+		}
+
+		session = s
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.socket.logins.succeed(r)
+
+	id, _, found := c.socket.seatPreset(session)
+	if !found {
+		return ErrClassroomPresetNotFound
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     classroomSeatCookie,
+		Value:    session.Token(),
+		Path:     "/sshwifty/",
+		Expires:  session.Expires,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	mData, mErr := json.Marshal(seatRespond{
+		ID:      id,
+		Key:     session.Key,
+		Class:   session.Name,
+		Seat:    session.Seat,
+		Expires: session.Expires.Unix(),
+	})
+	if mErr != nil {
+		return mErr
+	}
+
+	hd.Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(mData)
+
+	return nil
+}
@@ -18,7 +18,10 @@
 package controller
 
 import (
+	"crypto/subtle"
+	"net"
 	"net/http"
+	"net/netip"
 	"regexp"
 	"strings"
 )
@@ -28,6 +31,70 @@ func clientSupportGZIP(r *http.Request) bool {
 	return strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
 }
 
+// bearerAuthorized returns whether or not the request `r` carries the
+// `token` as it's Bearer token
+func bearerAuthorized(r *http.Request, token string) bool {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// trustedProxies are the reverse proxies whose X-Forwarded-For headers are
+// honoured, see the TrustedProxies of the configuration
+type trustedProxies []netip.Prefix
+
+// trusts returns whether or not the `addr` belongs to one of the proxies
+func (t trustedProxies) trusts(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	for _, p := range t {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientAddress returns the address of the client of `r`, without the port.
+// It's the peer of the connection, unless the peer is one of the `proxies`,
+// in which case it's the last address of the X-Forwarded-For headers that
+// is not one of the `proxies`. Addresses before that one are set by the
+// client itself, so they are never used to identify the client
+func clientAddress(r *http.Request, proxies trustedProxies) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !proxies.trusts(addr) {
+		return host
+	}
+
+	forwarded := []string{}
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(v, ",")...)
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			// Malformed by the proxy, the last known address is the best
+			// we have
+			return host
+		}
+
+		host = addr.Unmap().String()
+
+		if !proxies.trusts(addr) {
+			return host
+		}
+	}
+
+	return host
+}
+
 var (
 	serverMessageFormatLink = regexp.MustCompile(`\[(.*?)\]\((.*?)\)`)
 )
@@ -19,6 +19,9 @@ package controller
 
 import (
 	"html"
+	"net"
+	"net/http/httptest"
+	"net/netip"
 	"testing"
 )
 
@@ -52,3 +55,64 @@ func TestParseServerMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestBearerAuthorized(t *testing.T) {
+	for header, expected := range map[string]bool{
+		"":              false,
+		"secret":        false,
+		"Basic secret":  false,
+		"Bearer secre":  false,
+		"Bearer secret": true,
+	} {
+		r := httptest.NewRequest("GET", "/sshwifty/provision", nil)
+		r.Header.Set("Authorization", header)
+
+		if bearerAuthorized(r, "secret") != expected {
+			t.Errorf("Expecting %q to be authorized: %v", header, expected)
+		}
+	}
+}
+
+func TestClientAddress(t *testing.T) {
+	proxies := trustedProxies{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("::1/128"),
+	}
+
+	for _, test := range []struct {
+		remote    string
+		forwarded []string
+		expected  string
+	}{
+		{"192.0.2.1:1234", nil, "192.0.2.1"},
+		{"192.0.2.1:1234", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"10.0.0.1:1234", nil, "10.0.0.1"},
+		{"10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"[::1]:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"[::ffff:10.0.0.1]:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"10.0.0.1:1234", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"10.0.0.1:1234", []string{"198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"10.0.0.1:1234", []string{"198.51.100.1", "10.0.0.2"}, "198.51.100.1"},
+		{"10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"10.0.0.1:1234", []string{"198.51.100.1, bad"}, "10.0.0.1"},
+	} {
+		r := httptest.NewRequest("GET", "/sshwifty/socket/verify", nil)
+		r.RemoteAddr = test.remote
+
+		for _, f := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+
+		if a := clientAddress(r, proxies); a != test.expected {
+			t.Errorf("Expecting %q for %q %v, got %q",
+				test.expected, test.remote, test.forwarded, a)
+		}
+
+		// Nothing is trusted by default
+		host, _, _ := net.SplitHostPort(test.remote)
+		if a := clientAddress(r, nil); a != host {
+			t.Errorf("Expecting %q for %q without trusted proxies, got %q",
+				host, test.remote, a)
+		}
+	}
+}
@@ -20,12 +20,19 @@ package controller
 import (
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nirui/sshwifty/application/command"
 	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/hostkeys"
+	"github.com/nirui/sshwifty/application/journal"
+	"github.com/nirui/sshwifty/application/keyvault"
 	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/management"
+	"github.com/nirui/sshwifty/application/passkey"
 	"github.com/nirui/sshwifty/application/server"
+	"github.com/nirui/sshwifty/application/settings"
 )
 
 // Errors
@@ -47,6 +54,29 @@ type handler struct {
 	homeCtl         home
 	socketCtl       socket
 	socketVerifyCtl socketVerification
+	publicKeysCtl   publicKeys
+	usageCtl        usage
+	limitsCtl       limits
+	journalCtl      journalHistory
+	availabilityCtl availability
+	deepLinkCtl     deepLink
+	kioskCtl        kioskKeepalive
+	hookStatsCtl    hookStats
+	settingsCtl     userSettings
+	forwardsCtl     reverseForwards
+	handoverCtl     handoverSessions
+	previewCtl      preview
+	passkeyRegCtl   passkeyRegistration
+	passkeyLoginCtl passkeyLogin
+	passkeyEnrolCtl passkeyEnrollments
+	provisionCtl    provision
+	sessionsCtl     sessions
+	recordingsCtl   recordingsSearch
+	noticesCtl      notices
+	classesCtl      classes
+	seatCtl         classroomSeat
+	replicationCtl  replication
+	management      *management.Server
 }
 
 func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -75,8 +105,22 @@ func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Add("Date", time.Now().UTC().Format(time.RFC1123))
 
+	if h.management != nil && management.IsRequest(r) {
+		err = h.management.Serve(w, r)
+		if err != nil {
+			clientLogger.Warning("Management call %s ended with error: %s",
+				r.URL.Path, err)
+
+			return
+		}
+
+		clientLogger.Info("Management call completed: %s", r.URL.Path)
+
+		return
+	}
+
 	switch r.URL.Path {
-	case "/":
+	case "/", "/connect", "/kiosk", "/classroom":
 		err = serveController(h.homeCtl, w, r, clientLogger)
 
 	case "/sshwifty/socket":
@@ -84,6 +128,63 @@ func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "/sshwifty/socket/verify":
 		err = serveController(h.socketVerifyCtl, w, r, clientLogger)
 
+	case "/sshwifty/keys":
+		err = serveController(h.publicKeysCtl, w, r, clientLogger)
+
+	case "/sshwifty/usage":
+		err = serveController(h.usageCtl, w, r, clientLogger)
+
+	case "/sshwifty/limits":
+		err = serveController(h.limitsCtl, w, r, clientLogger)
+
+	case "/sshwifty/journal":
+		err = serveController(h.journalCtl, w, r, clientLogger)
+
+	case "/sshwifty/availability":
+		err = serveController(h.availabilityCtl, w, r, clientLogger)
+
+	case "/sshwifty/deeplink":
+		err = serveController(h.deepLinkCtl, w, r, clientLogger)
+
+	case "/sshwifty/kiosk":
+		err = serveController(h.kioskCtl, w, r, clientLogger)
+
+	case "/sshwifty/hooks":
+		err = serveController(h.hookStatsCtl, w, r, clientLogger)
+
+	case "/sshwifty/settings":
+		err = serveController(h.settingsCtl, w, r, clientLogger)
+
+	case "/sshwifty/forwards":
+		err = serveController(h.forwardsCtl, w, r, clientLogger)
+
+	case "/sshwifty/handover":
+		err = serveController(h.handoverCtl, w, r, clientLogger)
+
+	case "/sshwifty/sessions":
+		err = serveController(h.sessionsCtl, w, r, clientLogger)
+
+	case "/sshwifty/recordings/search":
+		err = serveController(h.recordingsCtl, w, r, clientLogger)
+
+	case "/sshwifty/broadcast":
+		err = serveController(h.noticesCtl, w, r, clientLogger)
+
+	case "/sshwifty/classroom":
+		err = serveController(h.classesCtl, w, r, clientLogger)
+	case "/sshwifty/classroom/seat":
+		err = serveController(h.seatCtl, w, r, clientLogger)
+
+	case "/sshwifty/passkey/register":
+		err = serveController(h.passkeyRegCtl, w, r, clientLogger)
+	case "/sshwifty/passkey/login":
+		err = serveController(h.passkeyLoginCtl, w, r, clientLogger)
+	case "/sshwifty/passkey/enrollments":
+		err = serveController(h.passkeyEnrolCtl, w, r, clientLogger)
+
+	case configuration.ReplicationPath:
+		err = serveController(h.replicationCtl, w, r, clientLogger)
+
 	case "/robots.txt":
 		err = serveStaticCacheData(
 			"robots.txt",
@@ -125,6 +226,10 @@ func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				w,
 				r,
 				clientLogger)
+		} else if strings.HasPrefix(r.URL.Path, previewURLPrefix) {
+			err = h.previewCtl.serve(w, r, clientLogger)
+		} else if strings.HasPrefix(r.URL.Path, provisionURLPrefix) {
+			err = serveController(h.provisionCtl, w, r, clientLogger)
 		} else {
 			err = ErrNotFound
 		}
@@ -151,15 +256,109 @@ func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		NewError(http.StatusInternalServerError, err.Error()), w, r, h.logger)
 }
 
-// Builder returns a http controller builder
-func Builder(cmds command.Commands) server.HandlerBuilder {
+// handlerBuilder returns a builder of the handler which serves the
+// `commonCfg` alone
+func handlerBuilder(cmds command.Commands) server.HandlerBuilder {
+	// Handlers are rebuilt when the configuration is reloaded, the passkey
+	// login sessions are carried over to the new ones so the users stay
+	// signed in
+	passkeyParties := map[string]*passkey.RelyingParty{}
+	passkeyPartiesLock := sync.Mutex{}
+
 	return func(
 		commonCfg configuration.Common,
 		cfg configuration.Server,
 		logger log.Logger,
 	) http.Handler {
-		hooks := command.NewHooks(commonCfg.Hooks)
-		socketCtl := newSocketCtl(commonCfg, cfg, cmds, hooks)
+		hooks := command.NewHooks(commonCfg.Hooks, logger.Context("Hooks"))
+		stepUp := command.NewStepUp(
+			commonCfg.StepUpRules, commonCfg.StepUpVerifier)
+		var j *journal.Journal
+		if len(commonCfg.JournalFile) > 0 {
+			jj, jErr := journal.Open(
+				commonCfg.JournalFile, commonCfg.JournalPolicy)
+			if jErr != nil {
+				logger.Error("Unable to open journal, connection events "+
+					"will not be journaled: %s", jErr)
+			} else {
+				j = jj
+			}
+		}
+
+		var st *settings.Store
+		if len(commonCfg.UserSettingsFile) > 0 {
+			s, sErr := settings.Open(commonCfg.UserSettingsFile)
+			if sErr != nil {
+				logger.Error("Unable to open user settings, the settings "+
+					"will be disabled: %s", sErr)
+			} else {
+				st = s
+			}
+		}
+
+		passkeyPartiesLock.Lock()
+		passkeys := passkeyParties[commonCfg.PasskeyFile]
+		if passkeys == nil && len(commonCfg.PasskeyFile) > 0 {
+			s, sErr := passkey.Open(commonCfg.PasskeyFile)
+			if sErr != nil {
+				logger.Error("Unable to open passkey file, passkey login "+
+					"will be disabled: %s", sErr)
+			} else {
+				passkeys = passkey.New(
+					"Sshwifty", s, commonCfg.PasskeySessionLifetime)
+				passkeyParties[commonCfg.PasskeyFile] = passkeys
+			}
+		}
+		passkeyPartiesLock.Unlock()
+
+		var known *hostkeys.Store
+		if len(commonCfg.KnownHostsFile) > 0 {
+			k, kErr := hostkeys.Open(commonCfg.KnownHostsFile)
+			if kErr != nil {
+				logger.Error("Unable to open known hosts file, host keys "+
+					"will not be recorded: %s", kErr)
+			} else {
+				known = k
+			}
+		}
+
+		logins := newLoginLimiter(
+			commonCfg.LoginLimiter,
+			commonCfg.TrustedProxies,
+			logger.Context("Login limit"))
+
+		socketCtl := newSocketCtl(
+			commonCfg, cfg, cmds, hooks, stepUp, j, known, st, passkeys,
+			logins)
+		socketVerifyCtl := newSocketVerification(socketCtl, cfg, commonCfg)
+
+		var vault *keyvault.Vault
+		if len(commonCfg.KeyVaultFile) > 0 {
+			v, vErr := keyvault.Open(commonCfg.KeyVaultFile)
+			if vErr != nil {
+				logger.Error("Unable to open public key vault, the "+
+					"vault will be disabled: %s", vErr)
+			} else {
+				vault = v
+			}
+		}
+
+		var mgmt *management.Server
+		if len(commonCfg.ManagementToken) > 0 {
+			mgmt = management.New(
+				commonCfg.ManagementToken, management.Sources{
+					Journal:  j,
+					Events:   commonCfg.Events,
+					Audit:    commonCfg.Audit,
+					Presets:  commonCfg.Presets,
+					Vault:    vault,
+					HostKeys: known,
+					Usage:    commonCfg.Usage,
+					Hooks:    hooks,
+					Forwards: commonCfg.ReverseForwards,
+					Streams:  commonCfg.Streams,
+				})
+		}
 
 		return handler{
 			hostNameChecker: commonCfg.HostName + ":",
@@ -167,7 +366,66 @@ func Builder(cmds command.Commands) server.HandlerBuilder {
 			logger:          logger,
 			homeCtl:         home{},
 			socketCtl:       socketCtl,
-			socketVerifyCtl: newSocketVerification(socketCtl, cfg, commonCfg),
+			socketVerifyCtl: socketVerifyCtl,
+			publicKeysCtl:   newPublicKeys(socketVerifyCtl, vault),
+			usageCtl:        newUsage(socketVerifyCtl, commonCfg.Usage),
+			limitsCtl:       newLimits(socketVerifyCtl),
+			journalCtl:      newJournalHistory(socketVerifyCtl, j),
+			availabilityCtl: newAvailability(
+				socketVerifyCtl, commonCfg.Watcher),
+			deepLinkCtl: newDeepLink(
+				socketVerifyCtl, commonCfg.DeepLinks, commonCfg.Presets),
+			kioskCtl:     newKioskKeepalive(socketCtl),
+			hookStatsCtl: newHookStats(socketVerifyCtl, hooks),
+			settingsCtl:  newUserSettings(socketVerifyCtl, st),
+			forwardsCtl: newReverseForwards(
+				socketVerifyCtl, commonCfg.ReverseForwards),
+			handoverCtl: newHandoverSessions(
+				socketVerifyCtl, commonCfg.Handover),
+			previewCtl: newPreview(commonCfg.Previews),
+			passkeyRegCtl: newPasskeyRegistration(
+				socketVerifyCtl, passkeys, commonCfg.PasskeyRPID),
+			passkeyLoginCtl: newPasskeyLogin(
+				socketCtl, passkeys, commonCfg.PasskeyRPID),
+			passkeyEnrolCtl: newPasskeyEnrollments(commonCfg, passkeys),
+			provisionCtl:    newProvision(commonCfg, cmds),
+			sessionsCtl:     newSessions(commonCfg),
+			recordingsCtl:   newRecordingsSearch(commonCfg),
+			noticesCtl:      newNotices(commonCfg),
+			classesCtl:      newClasses(commonCfg),
+			seatCtl:         newClassroomSeat(socketCtl),
+			replicationCtl: newReplication(
+				commonCfg, configuration.ReplicationSource{
+					KnownHosts: known,
+					KeyVault:   vault,
+					Journal:    j,
+					Provision:  commonCfg.Provision,
+				}),
+			management: mgmt,
 		}
 	}
 }
+
+// Builder returns a http controller builder
+func Builder(cmds command.Commands) server.HandlerBuilder {
+	build := handlerBuilder(cmds)
+
+	return func(
+		commonCfg configuration.Common,
+		cfg configuration.Server,
+		logger log.Logger,
+	) http.Handler {
+		main := build(commonCfg, cfg, logger)
+
+		if len(commonCfg.Tenants) <= 0 {
+			return main
+		}
+
+		return newTenants(commonCfg.Tenants, main, func(
+			t configuration.TenantCommon,
+		) http.Handler {
+			return build(
+				t.Common(commonCfg), cfg, logger.Context("Tenant (%s)", t.Name))
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/deeplink"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Errors
+var (
+	ErrDeepLinkDisabled = NewError(
+		http.StatusNotFound, "Deep links are disabled")
+
+	ErrDeepLinkMalformed = NewError(
+		http.StatusBadRequest, "Malformed deep link")
+
+	ErrDeepLinkRefused = NewError(
+		http.StatusForbidden, "Deep link is invalid or has expired")
+
+	ErrDeepLinkPresetNotFound = NewError(
+		http.StatusNotFound, "Preset of the deep link is not found")
+)
+
+type deepLinkPreset struct {
+	ID int `json:"id"`
+}
+
+// deepLink controller resolves the signed deep links into the Presets they
+// open. The link itself (`/connect?preset=...&expires=...&token=...`) is
+// served as the home page, which asks this controller for the Preset once
+// the user is authenticated
+type deepLink struct {
+	baseController
+
+	verifier socketVerification
+	signer   deeplink.Signer
+	presets  []configuration.Preset
+}
+
+func newDeepLink(
+	verifier socketVerification,
+	signer deeplink.Signer,
+	presets []configuration.Preset,
+) deepLink {
+	return deepLink{
+		verifier: verifier,
+		signer:   signer,
+		presets:  presets,
+	}
+}
+
+func (d deepLink) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if !d.signer.Enabled() {
+		return ErrDeepLinkDisabled
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	err := d.verifier.authorize(r)
+	if err != nil {
+		return err
+	}
+
+	q := r.URL.Query()
+	title := q.Get("preset")
+
+	err = d.signer.Verify(title, q.Get("expires"), q.Get("token"))
+	if errors.Is(err, deeplink.ErrMalformed) {
+		return ErrDeepLinkMalformed
+	} else if err != nil {
+		l.Warning("Refused deep link to Preset %q: %s", title, err)
+
+		return ErrDeepLinkRefused
+	}
+
+	for i := range d.presets {
+		if d.presets[i].Title != title {
+			continue
+		}
+
+		mData, mErr := json.Marshal(deepLinkPreset{ID: i})
+		if mErr != nil {
+			return mErr
+		}
+
+		hd.Add("Content-Type", "text/json; charset=utf-8")
+		w.Write(mData)
+
+		return nil
+	}
+
+	return ErrDeepLinkPresetNotFound
+}
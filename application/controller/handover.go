@@ -0,0 +1,85 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nirui/sshwifty/application/handover"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Errors
+var (
+	ErrHandoverDisabled = NewError(
+		http.StatusNotFound, "Session handover is not enabled")
+
+	ErrHandoverUnknownUser = NewError(
+		http.StatusForbidden, "The identity of the user is unknown")
+)
+
+// handoverSessions controller lists the sessions the user has parked on
+// other devices, so they can be resumed on this one
+type handoverSessions struct {
+	baseController
+
+	verifier socketVerification
+	registry *handover.Registry
+}
+
+func newHandoverSessions(
+	verifier socketVerification,
+	registry *handover.Registry,
+) handoverSessions {
+	return handoverSessions{
+		verifier: verifier,
+		registry: registry,
+	}
+}
+
+func (h handoverSessions) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if !h.registry.Enabled() {
+		return ErrHandoverDisabled
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	err := h.verifier.authorize(r)
+	if err != nil {
+		return err
+	}
+
+	user := h.verifier.user(r)
+	if len(user) <= 0 {
+		return ErrHandoverUnknownUser
+	}
+
+	mData, mErr := json.Marshal(h.registry.Sessions(user))
+	if mErr != nil {
+		return mErr
+	}
+
+	hd.Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(mData)
+
+	return nil
+}
@@ -0,0 +1,66 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// hookStats controller exposes the statistics of asynchronous Hooks
+type hookStats struct {
+	baseController
+
+	verifier socketVerification
+	hooks    command.Hooks
+}
+
+func newHookStats(
+	verifier socketVerification,
+	hooks command.Hooks,
+) hookStats {
+	return hookStats{
+		verifier: verifier,
+		hooks:    hooks,
+	}
+}
+
+func (h hookStats) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	err := h.verifier.authorize(r)
+	if err != nil {
+		return err
+	}
+
+	mData, mErr := json.Marshal(h.hooks.AsyncStats())
+	if mErr != nil {
+		return mErr
+	}
+
+	hd.Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(mData)
+
+	return nil
+}
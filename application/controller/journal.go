@@ -0,0 +1,94 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/nirui/sshwifty/application/journal"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Errors
+var (
+	ErrJournalDisabled = NewError(
+		http.StatusNotFound, "Journal is not enabled")
+
+	ErrJournalInvalidLimit = NewError(
+		http.StatusBadRequest, "Invalid limit")
+)
+
+const (
+	journalDefaultLimit = 100
+)
+
+// journalHistory controller exposes the connection history recorded in the
+// journal
+type journalHistory struct {
+	baseController
+
+	verifier socketVerification
+	journal  *journal.Journal
+}
+
+func newJournalHistory(
+	verifier socketVerification,
+	j *journal.Journal,
+) journalHistory {
+	return journalHistory{
+		verifier: verifier,
+		journal:  j,
+	}
+}
+
+func (j journalHistory) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if j.journal == nil {
+		return ErrJournalDisabled
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	err := j.verifier.authorize(r)
+	if err != nil {
+		return err
+	}
+
+	limit := journalDefaultLimit
+
+	if v := r.URL.Query().Get("limit"); len(v) > 0 {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return ErrJournalInvalidLimit
+		}
+	}
+
+	mData, mErr := json.Marshal(j.journal.Events(limit))
+	if mErr != nil {
+		return mErr
+	}
+
+	hd.Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(mData)
+
+	return nil
+}
@@ -0,0 +1,240 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/kiosk"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/network"
+)
+
+// Errors
+var (
+	ErrKioskDisabled = NewError(
+		http.StatusNotFound, "Kiosks are disabled")
+
+	ErrKioskMalformed = NewError(
+		http.StatusBadRequest, "Malformed kiosk token")
+
+	ErrKioskRefused = NewError(
+		http.StatusForbidden, "Kiosk token is invalid or has expired")
+
+	ErrKioskPresetNotFound = NewError(
+		http.StatusNotFound, "Preset of the kiosk is not found")
+)
+
+const (
+	kioskSessionCookie = "sshwifty-kiosk"
+)
+
+type kioskRespond struct {
+	ID        int    `json:"id"`
+	Key       string `json:"key"`
+	Keepalive int    `json:"keepalive"`
+	Expires   int64  `json:"expires"`
+	Status    string `json:"status,omitempty"`
+}
+
+// kioskSession returns the kiosk session carried by the request `r`
+func (s socket) kioskSession(r *http.Request) (kiosk.Session, bool) {
+	if !s.commonCfg.Kiosks.Enabled() {
+		return kiosk.Session{}, false
+	}
+
+	c, err := r.Cookie(kioskSessionCookie)
+	if err != nil {
+		return kiosk.Session{}, false
+	}
+
+	session, err := s.commonCfg.Kiosks.Resume(c.Value)
+	if err != nil {
+		return kiosk.Session{}, false
+	}
+
+	return session, true
+}
+
+// dropKioskSession ends the kiosk session carried by `r`
+func (s socket) dropKioskSession(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie(kioskSessionCookie); err != nil {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     kioskSessionCookie,
+		Value:    "",
+		Path:     "/sshwifty/",
+		MaxAge:   -1,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// kioskPreset returns the ID and the Preset watched by the kiosk `session`
+func (s socket) kioskPreset(
+	session kiosk.Session,
+) (int, configuration.Preset, bool) {
+	return presetByTitle(s.commonCfg.Presets, session.Preset)
+}
+
+// kioskRemote only allows the remote of the Preset watched by the kiosk
+type kioskRemote struct {
+	cfg    command.Configuration
+	preset configuration.Preset
+	found  bool
+}
+
+func (k kioskRemote) Allowed(address string) bool {
+	if !k.found {
+		return false
+	}
+
+	p, ok := k.cfg.Preset(k.preset.Type, address)
+
+	return ok && p.Title == k.preset.Title
+}
+
+// restrictKiosk restricts the `cfg` to the read-only session of the Preset
+// watched by the kiosk, when the request `r` is sent by a kiosk
+func (s socket) restrictKiosk(
+	r *http.Request,
+	cfg command.Configuration,
+) command.Configuration {
+	session, ok := s.kioskSession(r)
+	if !ok {
+		return cfg
+	}
+
+	_, preset, found := s.kioskPreset(session)
+
+	cfg.Dial = network.AccessControlDial(kioskRemote{
+		cfg:    cfg,
+		preset: preset,
+		found:  found,
+	}, cfg.Dial)
+	cfg.ReadOnly = true
+	cfg.AllowDynamicForwards = false
+	cfg.AllowLocalForwards = false
+	cfg.AllowFileTransfer = false
+
+	return cfg
+}
+
+// kioskKeepalive controller logs the kiosks in with their tokens, and is
+// called by them periodically as the keepalive, which tells them whether
+// they should carry on
+type kioskKeepalive struct {
+	baseController
+
+	socket socket
+}
+
+func newKioskKeepalive(s socket) kioskKeepalive {
+	return kioskKeepalive{
+		socket: s,
+	}
+}
+
+func (k kioskKeepalive) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	kiosks := k.socket.commonCfg.Kiosks
+	if !kiosks.Enabled() {
+		return ErrKioskDisabled
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	q := r.URL.Query()
+
+	var session kiosk.Session
+
+	err := k.socket.logins.verify(r, func() error {
+		s, lErr := kiosks.Login(q.Get("preset"), q.Get("expires"),
+			q.Get("token"))
+		if errors.Is(lErr, kiosk.ErrMalformed) {
+			return ErrKioskMalformed
+		} else if lErr != nil {
+			l.Warning("Refused kiosk of Preset %q: %s", q.Get("preset"), lErr)
+
+			// Same as the SharedKey verification, delay the brute force
+			// attack
+			time.Sleep(500 * time.Millisecond)
+
+			return ErrKioskRefused
+		}
+
+		session = s
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	k.socket.logins.succeed(r)
+
+	id, _, found := k.socket.kioskPreset(session)
+	if !found {
+		return ErrKioskPresetNotFound
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     kioskSessionCookie,
+		Value:    session.Cookie(),
+		Path:     "/sshwifty/",
+		Expires:  session.Expires,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	rsp := kioskRespond{
+		ID:        id,
+		Key:       session.Key,
+		Keepalive: int(kiosks.Keepalive().Seconds()),
+		Expires:   session.Expires.Unix(),
+	}
+
+	if k.socket.commonCfg.Watcher != nil {
+		for _, st := range k.socket.commonCfg.Watcher.Statuses() {
+			if st.Preset == id {
+				rsp.Status = st.State.String()
+			}
+		}
+	}
+
+	mData, mErr := json.Marshal(rsp)
+	if mErr != nil {
+		return mErr
+	}
+
+	hd.Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(mData)
+
+	return nil
+}
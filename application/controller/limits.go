@@ -0,0 +1,194 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/stepup"
+)
+
+const (
+	limitsStepUpMatchTimeout = 3 * time.Second
+)
+
+type limitsSessions struct {
+	Active   int    `json:"active"`
+	Sent     uint64 `json:"sent"`
+	Received uint64 `json:"received"`
+}
+
+type limitsFeatures struct {
+	DynamicForwards bool `json:"dynamic_forwards"`
+	LocalForwards   bool `json:"local_forwards"`
+	ReverseForwards bool `json:"reverse_forwards"`
+	FileTransfer    bool `json:"file_transfer"`
+}
+
+type limitsPreset struct {
+	ID     int           `json:"id"`
+	StepUp stepup.Method `json:"step_up,omitempty"`
+}
+
+type limitsRespond struct {
+	User                   string         `json:"user,omitempty"`
+	UserGroups             []string       `json:"user_groups"`
+	ReadOnly               bool           `json:"read_only"`
+	OnlyAllowPresetRemotes bool           `json:"only_allow_preset_remotes"`
+	Sessions               limitsSessions `json:"sessions"`
+	Features               limitsFeatures `json:"features"`
+	Presets                []limitsPreset `json:"presets"`
+}
+
+// limits controller tells the users the limits that apply to them and their
+// current usage, so the denials can be explained to them
+type limits struct {
+	baseController
+
+	verifier socketVerification
+}
+
+func newLimits(verifier socketVerification) limits {
+	return limits{
+		verifier: verifier,
+	}
+}
+
+// sessions returns the usage of the active streams of the caller, who is
+// identified by the `user` when it's known, or by the `clientIP` otherwise
+func (c limits) sessions(user string, clientIP string) limitsSessions {
+	s := limitsSessions{}
+
+	for _, r := range c.verifier.commonCfg.Streams.Records() {
+		if r.Tenant != c.verifier.commonCfg.Tenant {
+			continue
+		}
+
+		if len(user) > 0 {
+			if r.User != user {
+				continue
+			}
+		} else if host, _, err := net.SplitHostPort(r.Client); err != nil ||
+			host != clientIP {
+			continue
+		}
+
+		s.Active++
+		s.Sent += r.Sent
+		s.Received += r.Received
+	}
+
+	return s
+}
+
+// presets returns the Presets the caller is allowed to connect to, and the
+// step-up authentication required by them
+func (c limits) presets(
+	ctx context.Context,
+	r *http.Request,
+	cfg command.Configuration,
+) []limitsPreset {
+	if session, ok := c.verifier.kioskSession(r); ok {
+		id, _, found := c.verifier.kioskPreset(session)
+		if !found {
+			return []limitsPreset{}
+		}
+
+		return []limitsPreset{{ID: id}}
+	}
+
+	if session, ok := c.verifier.seatSession(r); ok {
+		id, _, found := c.verifier.seatPreset(session)
+		if !found {
+			return []limitsPreset{}
+		}
+
+		return []limitsPreset{{ID: id}}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, limitsStepUpMatchTimeout)
+	defer cancel()
+
+	presets := make([]limitsPreset, len(cfg.Presets))
+	for i, p := range cfg.Presets {
+		presets[i] = limitsPreset{ID: i}
+
+		method, required := cfg.StepUp.Required(ctx,
+			command.NewRemoteHookParameters(cfg, 0, p.Type, p.Host))
+		if required {
+			presets[i].StepUp = method
+		}
+	}
+
+	return presets
+}
+
+func (c limits) Get(w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	err := c.verifier.authorize(r)
+	if err != nil {
+		return err
+	}
+
+	commonCfg := c.verifier.commonCfg
+	cfg := c.verifier.restrictKiosk(r, command.Configuration{
+		ClientAddress:        r.RemoteAddr,
+		User:                 c.verifier.user(r),
+		UserGroups:           c.verifier.userGroups(r),
+		Presets:              commonCfg.Presets,
+		ReverseForwardPolicy: commonCfg.ReverseForwardPolicy,
+		AllowDynamicForwards: commonCfg.AllowDynamicForwards,
+		AllowLocalForwards:   commonCfg.AllowLocalForwards,
+		AllowFileTransfer:    commonCfg.AllowFileTransfer,
+		StepUp:               c.verifier.stepUp,
+	})
+	cfg = c.verifier.restrictSeat(r, cfg)
+
+	mData, mErr := json.Marshal(limitsRespond{
+		User:                   cfg.User,
+		UserGroups:             cfg.UserGroups,
+		ReadOnly:               cfg.ReadOnly,
+		OnlyAllowPresetRemotes: commonCfg.OnlyAllowPresetRemotes,
+		Sessions:               c.sessions(cfg.User, cfg.ClientIP()),
+		Features: limitsFeatures{
+			DynamicForwards: cfg.AllowDynamicForwards,
+			LocalForwards:   cfg.AllowLocalForwards,
+			ReverseForwards: cfg.ReverseForwardPolicy.Enabled() &&
+				!cfg.ReadOnly,
+			FileTransfer: cfg.AllowFileTransfer,
+		},
+		Presets: c.presets(r.Context(), r, cfg),
+	})
+	if mErr != nil {
+		return mErr
+	}
+
+	hd.Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(mData)
+
+	return nil
+}
@@ -0,0 +1,91 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/ratelimit"
+)
+
+// Errors
+var (
+	ErrLoginTooManyAttempts = NewError(
+		http.StatusTooManyRequests,
+		"Too many failed login attempts, please try again later")
+)
+
+// loginLimiter locks out the clients which failed to login too many times.
+// Clients are identified by clientAddress, so the clients behind a proxy
+// which is not one of the `proxies` are locked out together
+type loginLimiter struct {
+	limiter *ratelimit.Limiter
+	proxies trustedProxies
+	l       log.Logger
+}
+
+func newLoginLimiter(
+	limiter *ratelimit.Limiter,
+	proxies trustedProxies,
+	l log.Logger,
+) loginLimiter {
+	return loginLimiter{
+		limiter: limiter,
+		proxies: proxies,
+		l:       l,
+	}
+}
+
+// verify runs `verify` unless the client of `r` is locked out, and counts
+// it's failure. The backend errors are only logged, so an unavailable
+// backend can't lock out everyone
+func (l loginLimiter) verify(r *http.Request, verify func() error) error {
+	client := clientAddress(r, l.proxies)
+
+	err := l.limiter.Allow(r.Context(), client)
+	if errors.Is(err, ratelimit.ErrLocked) {
+		return ErrLoginTooManyAttempts
+	} else if err != nil {
+		l.l.Warning("Unable to check the failed logins of %s: %s",
+			client, err)
+	}
+
+	err = verify()
+	if err == nil {
+		return nil
+	}
+
+	if fErr := l.limiter.Fail(r.Context(), client); fErr != nil {
+		l.l.Warning("Unable to count the failed login of %s: %s",
+			client, fErr)
+	}
+
+	return err
+}
+
+// succeed clears the failed logins of the client of `r`
+func (l loginLimiter) succeed(r *http.Request) {
+	client := clientAddress(r, l.proxies)
+
+	if err := l.limiter.Succeed(r.Context(), client); err != nil {
+		l.l.Warning("Unable to clear the failed logins of %s: %s",
+			client, err)
+	}
+}
@@ -0,0 +1,99 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/ratelimit"
+)
+
+func TestAuthorizeClearsFailedLogins(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemory(), "login", 2, time.Minute)
+	defer limiter.Close()
+
+	s := socketVerification{
+		socket: socket{
+			commonCfg: configuration.Common{SharedKey: "secret"},
+			logins:    newLoginLimiter(limiter, nil, log.NewDitch()),
+		},
+	}
+
+	authorize := func(key []byte) error {
+		r := httptest.NewRequest("GET", "/sshwifty/socket/verify", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("X-Key", base64.StdEncoding.EncodeToString(key))
+
+		return s.authorize(r)
+	}
+
+	good := s.authKey("secret")
+	bad := []byte("bad")
+
+	for i, test := range []struct {
+		key      []byte
+		expected error
+	}{
+		{bad, ErrSocketAuthFailed},
+		{good, nil},
+		{bad, ErrSocketAuthFailed},
+		// Would be locked out if the success didn't clear the failures
+		{good, nil},
+		{bad, ErrSocketAuthFailed},
+		{bad, ErrSocketAuthFailed},
+		{good, ErrLoginTooManyAttempts},
+	} {
+		if err := authorize(test.key); err != test.expected {
+			t.Errorf("Test %d: expecting %v, got %v", i, test.expected, err)
+		}
+	}
+}
+
+func TestLoginLimiterTrustedProxies(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemory(), "login", 1, time.Minute)
+	defer limiter.Close()
+
+	l := newLoginLimiter(limiter, trustedProxies{
+		netip.MustParsePrefix("10.0.0.1/32"),
+	}, log.NewDitch())
+
+	login := func(client string, err error) error {
+		r := httptest.NewRequest("GET", "/sshwifty/socket/verify", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", client)
+
+		return l.verify(r, func() error { return err })
+	}
+
+	login("192.0.2.1", ErrSocketAuthFailed)
+
+	if err := login("192.0.2.1", nil); err != ErrLoginTooManyAttempts {
+		t.Errorf("Expecting ErrLoginTooManyAttempts, got %v", err)
+	}
+
+	// Other clients behind the same proxy are not locked out
+	if err := login("192.0.2.2", nil); err != nil {
+		t.Errorf("Expecting login to be allowed, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nirui/sshwifty/application/broadcast"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Errors
+var (
+	ErrBroadcastDisabled = NewError(
+		http.StatusNotFound, "Broadcasting is not enabled")
+
+	ErrBroadcastInvalidRequest = NewError(
+		http.StatusBadRequest, "Invalid broadcast request")
+
+	ErrBroadcastUnauthorized = NewError(
+		http.StatusUnauthorized, "Invalid management token")
+)
+
+const (
+	broadcastMaxMessageSize = 1024
+	broadcastMaxRequestSize = 4 * broadcastMaxMessageSize
+)
+
+// broadcastRequest is the notice to broadcast
+type broadcastRequest struct {
+	Message string `json:"message"`
+}
+
+// broadcastResult tells how many clients have received the notice
+type broadcastResult struct {
+	Clients int `json:"clients"`
+}
+
+// notices controller lets the administrator push a notice to all the
+// connected clients, i.e. to warn them before a maintenance
+type notices struct {
+	baseController
+
+	token       string
+	broadcaster *broadcast.Broadcaster
+}
+
+func newNotices(commonCfg configuration.Common) notices {
+	return notices{
+		token:       commonCfg.ManagementToken,
+		broadcaster: commonCfg.Broadcaster,
+	}
+}
+
+func (n notices) Post(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if n.broadcaster == nil || len(n.token) <= 0 {
+		return ErrBroadcastDisabled
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	if !bearerAuthorized(r, n.token) {
+		return ErrBroadcastUnauthorized
+	}
+
+	data, err := io.ReadAll(
+		http.MaxBytesReader(w, r.Body, broadcastMaxRequestSize))
+	if err != nil {
+		return ErrBroadcastInvalidRequest
+	}
+
+	req := broadcastRequest{}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return ErrBroadcastInvalidRequest
+	}
+
+	req.Message = strings.TrimSpace(req.Message)
+	if len(req.Message) <= 0 || len(req.Message) > broadcastMaxMessageSize ||
+		!utf8.ValidString(req.Message) {
+		return ErrBroadcastInvalidRequest
+	}
+
+	clients := n.broadcaster.Send(req.Message)
+
+	l.Info("Notice has been broadcasted to %d clients: %s",
+		clients, req.Message)
+
+	mData, mErr := json.Marshal(broadcastResult{Clients: clients})
+	if mErr != nil {
+		return mErr
+	}
+
+	hd.Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(mData)
+
+	return nil
+}
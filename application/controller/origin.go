@@ -0,0 +1,93 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nirui/sshwifty/application/journal"
+	"github.com/nirui/sshwifty/application/server"
+)
+
+// forwardedFor returns the addresses in the `for` parameters of the
+// Forwarded headers (RFC 7239) of `hd`, or in the X-Forwarded-For headers
+// when there's no Forwarded header
+func forwardedFor(hd http.Header) []string {
+	addrs := []string{}
+
+	for _, v := range hd.Values("Forwarded") {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+
+				value = strings.Trim(strings.TrimSpace(value), "\"")
+				if len(value) > 0 {
+					addrs = append(addrs, value)
+				}
+			}
+		}
+	}
+
+	if len(addrs) > 0 {
+		return addrs
+	}
+
+	for _, v := range hd.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(v, ",") {
+			addr = strings.TrimSpace(addr)
+			if len(addr) > 0 {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	return addrs
+}
+
+// clientOrigin returns the Origin of the client of `r`, or nil when there's
+// nothing more to tell than it's address
+func clientOrigin(r *http.Request) *journal.Origin {
+	hello := server.ClientHelloFrom(r.Context())
+	o := journal.Origin{
+		Forwarded: forwardedFor(r.Header),
+		Port:      "",
+		JA3:       hello.JA3,
+		JA4:       hello.JA4,
+	}
+
+	// Only the proxies which saw the client know it's port
+	if len(o.Forwarded) > 0 {
+		_, port, err := net.SplitHostPort(o.Forwarded[0])
+		if _, pErr := strconv.ParseUint(port, 10, 16); err == nil &&
+			pErr == nil {
+			o.Port = port
+		}
+	}
+
+	if len(o.Forwarded) <= 0 && len(o.JA3) <= 0 {
+		return nil
+	}
+
+	return &o
+}
@@ -0,0 +1,84 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestClientOrigin(t *testing.T) {
+	for _, test := range []struct {
+		headers   map[string][]string
+		forwarded []string
+		port      string
+	}{
+		{nil, nil, ""},
+		{
+			map[string][]string{
+				"X-Forwarded-For": {"100.64.0.1, 10.0.0.1", "10.0.0.2"},
+			},
+			[]string{"100.64.0.1", "10.0.0.1", "10.0.0.2"},
+			"",
+		},
+		{
+			map[string][]string{
+				"X-Forwarded-For": {"100.64.0.1:40123"},
+			},
+			[]string{"100.64.0.1:40123"},
+			"40123",
+		},
+		{
+			map[string][]string{
+				"Forwarded": {
+					"for=\"[2001:db8::1]:4711\";proto=https, For=10.0.0.1",
+				},
+				"X-Forwarded-For": {"10.0.0.9"},
+			},
+			[]string{"[2001:db8::1]:4711", "10.0.0.1"},
+			"4711",
+		},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range test.headers {
+			r.Header[k] = v
+		}
+
+		o := clientOrigin(r)
+
+		if test.forwarded == nil {
+			if o != nil {
+				t.Errorf("Expecting no origin, got %v", o)
+			}
+
+			continue
+		}
+
+		if o == nil {
+			t.Errorf("Expecting an origin for %v", test.headers)
+			continue
+		}
+
+		if !slices.Equal(o.Forwarded, test.forwarded) || o.Port != test.port {
+			t.Errorf("Expecting %v (port %q), got %v (port %q)",
+				test.forwarded, test.port, o.Forwarded, o.Port)
+		}
+	}
+}
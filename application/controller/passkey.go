@@ -0,0 +1,405 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/passkey"
+)
+
+// Errors
+var (
+	ErrPasskeysDisabled = NewError(
+		http.StatusNotFound, "Passkey login is not enabled")
+
+	ErrPasskeysInvalidRequest = NewError(
+		http.StatusBadRequest, "Invalid passkey request")
+
+	ErrPasskeysUnknownOwner = NewError(
+		http.StatusForbidden, "The owner of the passkey is unknown, an "+
+			"enrollment code is required")
+
+	ErrPasskeysInvalidOwner = NewError(
+		http.StatusBadRequest, "Invalid name of the passkey owner")
+
+	ErrPasskeysEnrollmentNotFound = NewError(
+		http.StatusForbidden, "The enrollment code is invalid or has "+
+			"expired")
+
+	ErrPasskeysEnrollmentDisabled = NewError(
+		http.StatusNotFound, "Passkey enrollment is not enabled")
+
+	ErrPasskeysLoginFailed = NewError(
+		http.StatusForbidden, "Passkey login has failed")
+)
+
+const (
+	passkeySessionCookie   = "sshwifty-passkey"
+	passkeyMaxRequestSize  = 16 * 1024
+	passkeyUserNameMaxSize = 64
+)
+
+func passkeyRespond(w http.ResponseWriter, code int, data interface{}) error {
+	mData, mErr := json.Marshal(data)
+	if mErr != nil {
+		return mErr
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+	hd.Add("Content-Type", "text/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(mData)
+
+	return nil
+}
+
+func passkeyDecode(
+	w http.ResponseWriter, r *http.Request, v interface{}) error {
+	err := json.NewDecoder(
+		http.MaxBytesReader(w, r.Body, passkeyMaxRequestSize)).Decode(v)
+	if err != nil {
+		return ErrPasskeysInvalidRequest
+	}
+
+	return nil
+}
+
+// dropPasskeySession ends the passkey login session carried by `r`
+func (s socket) dropPasskeySession(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(passkeySessionCookie); err == nil &&
+		s.passkeys != nil {
+		s.passkeys.Logout(c.Value)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     passkeySessionCookie,
+		Value:    "",
+		Path:     "/sshwifty/",
+		MaxAge:   -1,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// passkeyRegistration controller registers new passkeys for users who are
+// already authenticated
+type passkeyRegistration struct {
+	baseController
+
+	verifier socketVerification
+	passkeys *passkey.RelyingParty
+	rpID     string
+}
+
+type passkeyRegistrationRequest struct {
+	Enrollment string                       `json:"enrollment"`
+	Response   passkey.RegistrationResponse `json:"response"`
+}
+
+func newPasskeyRegistration(
+	verifier socketVerification,
+	passkeys *passkey.RelyingParty,
+	rpID string,
+) passkeyRegistration {
+	return passkeyRegistration{
+		verifier: verifier,
+		passkeys: passkeys,
+		rpID:     rpID,
+	}
+}
+
+// owner returns the name of the user who'll own the new passkey. It's the
+// user the administrator enrolled with the `enrollment` code, or otherwise
+// the identity given by the UserHeader or the current passkey login. Names
+// the clients made up are never accepted, as the passkeys would then let
+// anyone sign in as anybody
+func (p passkeyRegistration) owner(
+	r *http.Request, enrollment string) (string, error) {
+	if len(enrollment) > 0 {
+		user, err := p.passkeys.Enrolled(enrollment)
+		if err != nil {
+			return "", ErrPasskeysEnrollmentNotFound
+		}
+
+		return user, nil
+	}
+
+	user := ""
+
+	if len(p.verifier.commonCfg.UserHeader) > 0 {
+		user = r.Header.Get(p.verifier.commonCfg.UserHeader)
+	} else if session, ok := p.verifier.passkeySession(r); ok {
+		user = session.User
+	}
+
+	if len(user) <= 0 {
+		return "", ErrPasskeysUnknownOwner
+	}
+
+	if len(user) > passkeyUserNameMaxSize {
+		return "", ErrPasskeysInvalidOwner
+	}
+
+	return user, nil
+}
+
+func (p passkeyRegistration) prepare(r *http.Request) error {
+	if p.passkeys == nil {
+		return ErrPasskeysDisabled
+	}
+
+	return p.verifier.authorize(r)
+}
+
+// Get starts the registration, the returned options should be passed to
+// navigator.credentials.create
+func (p passkeyRegistration) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	err := p.prepare(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := p.owner(r, r.URL.Query().Get("enrollment"))
+	if err != nil {
+		return err
+	}
+
+	opts, err := p.passkeys.BeginRegistration(p.rpID, user)
+	if err != nil {
+		return err
+	}
+
+	return passkeyRespond(w, http.StatusOK, opts)
+}
+
+// Post finishes the registration
+func (p passkeyRegistration) Post(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	err := p.prepare(r)
+	if err != nil {
+		return err
+	}
+
+	req := passkeyRegistrationRequest{}
+
+	err = passkeyDecode(w, r, &req)
+	if err != nil {
+		return err
+	}
+
+	user, err := p.owner(r, req.Enrollment)
+	if err != nil {
+		return err
+	}
+
+	c, err := p.passkeys.FinishRegistration(p.rpID, user, req.Response)
+	switch err {
+	case nil:
+	case passkey.ErrCredentialAlreadyExists:
+		return NewError(http.StatusConflict, err.Error())
+	default:
+		return NewError(http.StatusBadRequest, err.Error())
+	}
+
+	if len(req.Enrollment) > 0 {
+		p.passkeys.Unenroll(req.Enrollment)
+	}
+
+	l.Info("Passkey %s of \"%s\" has been registered", c.ID, c.User)
+
+	return passkeyRespond(w, http.StatusCreated, c)
+}
+
+// passkeyLogin controller authenticates users with their passkeys. Users who
+// logged in are given a session key which replaces the SharedKey
+type passkeyLogin struct {
+	baseController
+
+	socket   socket
+	passkeys *passkey.RelyingParty
+	rpID     string
+}
+
+type passkeyLoginRespond struct {
+	User string `json:"user"`
+	Key  string `json:"key"`
+}
+
+func newPasskeyLogin(
+	s socket,
+	passkeys *passkey.RelyingParty,
+	rpID string,
+) passkeyLogin {
+	return passkeyLogin{
+		socket:   s,
+		passkeys: passkeys,
+		rpID:     rpID,
+	}
+}
+
+// Get starts the login, the returned options should be passed to
+// navigator.credentials.get
+func (p passkeyLogin) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if p.passkeys == nil {
+		return ErrPasskeysDisabled
+	}
+
+	opts, err := p.passkeys.BeginLogin(p.rpID)
+	if err != nil {
+		return err
+	}
+
+	return passkeyRespond(w, http.StatusOK, opts)
+}
+
+// Post finishes the login
+func (p passkeyLogin) Post(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if p.passkeys == nil {
+		return ErrPasskeysDisabled
+	}
+
+	req := passkey.LoginResponse{}
+
+	err := passkeyDecode(w, r, &req)
+	if err != nil {
+		return err
+	}
+
+	var session passkey.Session
+
+	err = p.socket.logins.verify(r, func() error {
+		s, fErr := p.passkeys.FinishLogin(p.rpID, req)
+		if fErr != nil {
+			l.Warning("Passkey login has failed: %s", fErr)
+
+			// Same as the SharedKey verification, delay the brute force
+			// attack
+			time.Sleep(500 * time.Millisecond)
+
+			return ErrPasskeysLoginFailed
+		}
+
+		session = s
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	p.socket.logins.succeed(r)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     passkeySessionCookie,
+		Value:    session.ID,
+		Path:     "/sshwifty/",
+		Expires:  session.Expires,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	l.Info("\"%s\" has logged in with a passkey", session.User)
+
+	return passkeyRespond(w, http.StatusOK, passkeyLoginRespond{
+		User: session.User,
+		Key:  session.Key,
+	})
+}
+
+// Delete logs out
+func (p passkeyLogin) Delete(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if p.passkeys == nil {
+		return ErrPasskeysDisabled
+	}
+
+	p.socket.dropPasskeySession(w, r)
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// passkeyEnrollments controller lets the administrator enroll the users who
+// can't be identified otherwise, so they can register their first passkey
+type passkeyEnrollments struct {
+	baseController
+
+	token    string
+	passkeys *passkey.RelyingParty
+}
+
+type passkeyEnrollmentRequest struct {
+	User string `json:"user"`
+}
+
+func newPasskeyEnrollments(
+	commonCfg configuration.Common,
+	passkeys *passkey.RelyingParty,
+) passkeyEnrollments {
+	return passkeyEnrollments{
+		token:    commonCfg.ManagementToken,
+		passkeys: passkeys,
+	}
+}
+
+// Post enrolls the user, the returned code should be handed to the user
+func (p passkeyEnrollments) Post(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if p.passkeys == nil || len(p.token) <= 0 {
+		return ErrPasskeysEnrollmentDisabled
+	}
+
+	if !bearerAuthorized(r, p.token) {
+		return ErrProvisionUnauthorized
+	}
+
+	req := passkeyEnrollmentRequest{}
+
+	err := passkeyDecode(w, r, &req)
+	if err != nil {
+		return err
+	}
+
+	user := strings.TrimSpace(req.User)
+	if len(user) <= 0 || len(user) > passkeyUserNameMaxSize {
+		return ErrPasskeysInvalidOwner
+	}
+
+	e, err := p.passkeys.Enroll(user)
+	if err != nil {
+		return err
+	}
+
+	l.Info("\"%s\" has been enrolled to register a passkey", e.User)
+
+	return passkeyRespond(w, http.StatusCreated, e)
+}
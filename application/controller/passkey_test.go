@@ -0,0 +1,69 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/passkey"
+)
+
+func TestPasskeyRegistrationOwner(t *testing.T) {
+	store, err := passkey.Open(filepath.Join(t.TempDir(), "passkeys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passkeys := passkey.New("Test", store, 0)
+	reg := newPasskeyRegistration(socketVerification{
+		socket: socket{passkeys: passkeys},
+	}, passkeys, "sshwifty.example.com")
+
+	r := httptest.NewRequest("GET", "/sshwifty/passkey/register", nil)
+
+	// Names the client declares itself are not accepted
+	r.URL.RawQuery = "user=admin"
+	if _, err := reg.owner(r, ""); err != ErrPasskeysUnknownOwner {
+		t.Errorf("Expecting ErrPasskeysUnknownOwner, got %v", err)
+	}
+
+	_, err = reg.owner(r, "made-up")
+	if err != ErrPasskeysEnrollmentNotFound {
+		t.Errorf("Expecting ErrPasskeysEnrollmentNotFound, got %v", err)
+	}
+
+	e, err := passkeys.Enroll("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user, err := reg.owner(r, e.Code); err != nil || user != "bob" {
+		t.Errorf("Expecting bob, got %q (%v)", user, err)
+	}
+
+	// Identity given by the reverse proxy
+	reg.verifier.commonCfg = configuration.Common{UserHeader: "X-User"}
+	r.Header.Set("X-User", "alice")
+
+	if user, err := reg.owner(r, ""); err != nil || user != "alice" {
+		t.Errorf("Expecting alice, got %q (%v)", user, err)
+	}
+}
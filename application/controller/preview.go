@@ -0,0 +1,112 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+
+	"github.com/nirui/sshwifty/application/forward"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+const (
+	previewURLPrefix    = "/sshwifty/preview/"
+	previewURLPrefixLen = len(previewURLPrefix)
+)
+
+// previewSandboxPolicy isolates the previewed pages from the origin of
+// Sshwifty, so they cannot access the data stored by it
+const previewSandboxPolicy = "sandbox allow-downloads allow-forms " +
+	"allow-modals allow-popups allow-scripts"
+
+// preview controller proxies the requests to the HTTP services forwarded by
+// the sessions. The services are identified by the token given to the
+// session, which is only valid while the session is alive
+type preview struct {
+	previews *forward.Previews
+}
+
+func newPreview(previews *forward.Previews) preview {
+	return preview{
+		previews: previews,
+	}
+}
+
+func (p preview) serve(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	token, path, found := strings.Cut(r.URL.Path[previewURLPrefixLen:], "/")
+
+	v, ok := p.previews.Get(token)
+	if !ok {
+		return ErrNotFound
+	}
+
+	// Relative links of the page only works with the trailing slash
+	if !found {
+		http.Redirect(w, r, previewURLPrefix+token+"/", http.StatusFound)
+
+		return nil
+	}
+
+	base := previewURLPrefix + token
+
+	proxy := httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = v.Target
+			pr.Out.URL.Path = "/" + path
+			pr.Out.URL.RawPath = ""
+			pr.Out.Host = v.Target
+			pr.Out.Header.Del("Cookie")
+			pr.SetXForwarded()
+		},
+		Transport: &http.Transport{
+			DialContext: func(
+				ctx context.Context, n string, addr string,
+			) (net.Conn, error) {
+				return v.Dial(ctx, "tcp", v.Target)
+			},
+			DisableKeepAlives: true,
+		},
+		ModifyResponse: func(res *http.Response) error {
+			// Keeps the redirections inside of the preview
+			loc := res.Header.Get("Location")
+			if strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+				res.Header.Set("Location", base+loc)
+			}
+
+			res.Header.Del("Set-Cookie")
+			res.Header.Set("Content-Security-Policy", previewSandboxPolicy)
+
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			l.Debug("Unable to preview %q (%s): %s", v.Name, v.Target, err)
+
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	proxy.ServeHTTP(w, r)
+
+	return nil
+}
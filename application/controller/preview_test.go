@@ -0,0 +1,111 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nirui/sshwifty/application/forward"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+func TestPreview(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/login" {
+				http.Redirect(w, r, "/home", http.StatusFound)
+				return
+			}
+
+			w.Write([]byte("Path " + r.URL.Path))
+		}))
+	defer service.Close()
+
+	previews := forward.NewPreviews()
+	dialer := net.Dialer{}
+
+	// The target is never dialed directly, the Dial decides where to go
+	token, remove := previews.Add(forward.Preview{
+		Name:   "web",
+		Target: "web.internal:80",
+		Dial: func(
+			ctx context.Context, n string, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, n, service.Listener.Addr().String())
+		},
+	})
+	defer remove()
+
+	p := newPreview(previews)
+
+	serve := func(path string) (*httptest.ResponseRecorder, error) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+
+		return w, p.serve(w, r, log.NewDitch())
+	}
+
+	if _, err := serve(previewURLPrefix + "unknown/"); err != ErrNotFound {
+		t.Errorf("Expecting ErrNotFound for unknown token, got %v", err)
+		return
+	}
+
+	w, err := serve(previewURLPrefix + token)
+	if err != nil || w.Code != http.StatusFound ||
+		w.Header().Get("Location") != previewURLPrefix+token+"/" {
+		t.Errorf("Expecting redirection to the trailing slash, got %d %q: %v",
+			w.Code, w.Header().Get("Location"), err)
+		return
+	}
+
+	w, err = serve(previewURLPrefix + token + "/index.html")
+	if err != nil || w.Code != http.StatusOK {
+		t.Errorf("Unexpected response %d: %v", w.Code, err)
+		return
+	}
+
+	body, _ := io.ReadAll(w.Body)
+	if string(body) != "Path /index.html" {
+		t.Errorf("Unexpected body %q", body)
+		return
+	}
+
+	if w.Header().Get("Content-Security-Policy") != previewSandboxPolicy {
+		t.Error("Expecting the preview to be sandboxed")
+		return
+	}
+
+	w, err = serve(previewURLPrefix + token + "/login")
+	if err != nil ||
+		w.Header().Get("Location") != previewURLPrefix+token+"/home" {
+		t.Errorf("Expecting redirection to stay in the preview, got %q: %v",
+			w.Header().Get("Location"), err)
+		return
+	}
+
+	remove()
+
+	if _, err := serve(previewURLPrefix + token + "/"); err != ErrNotFound {
+		t.Errorf("Expecting ErrNotFound for removed token, got %v", err)
+		return
+	}
+}
@@ -0,0 +1,354 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Errors
+var (
+	ErrProvisionDisabled = NewError(
+		http.StatusNotFound, "Provisioning is not enabled")
+
+	ErrProvisionUnauthorized = NewError(
+		http.StatusUnauthorized, "Invalid management token")
+
+	ErrProvisionInvalidRequest = NewError(
+		http.StatusBadRequest, "Invalid provisioning request")
+)
+
+const (
+	provisionURLPrefix      = "/sshwifty/provision/"
+	provisionMaxRequestSize = 1024 * 1024
+)
+
+// provision controller lets the declarative tools (i.e. a Terraform
+// provider) reconcile the provisioned Presets, policy rules and users. Every
+// collection is replaced as a whole by PUT, which must carry the ETag of the
+// collection it's based on as If-Match, so concurrent changes are never
+// overwritten silently. The Presets can also be changed one by one at
+// "presets/<index>", which requires the ETag of the collection as well
+type provision struct {
+	baseController
+
+	token     string
+	provision *configuration.Provision
+	cmds      command.Commands
+	reload    func()
+}
+
+func newProvision(
+	commonCfg configuration.Common,
+	cmds command.Commands,
+) provision {
+	return provision{
+		token:     commonCfg.ManagementToken,
+		provision: commonCfg.Provision,
+		cmds:      cmds,
+		reload:    commonCfg.Reload,
+	}
+}
+
+// prepare authorizes the request and returns the name of the requested
+// collection
+func (p provision) prepare(
+	w http.ResponseWriter, r *http.Request) (string, error) {
+	if p.provision == nil || len(p.token) <= 0 {
+		return "", ErrProvisionDisabled
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	if !bearerAuthorized(r, p.token) {
+		return "", ErrProvisionUnauthorized
+	}
+
+	return strings.TrimPrefix(r.URL.Path, provisionURLPrefix), nil
+}
+
+// failure converts the error of configuration.Provision to the controller
+// Error
+func (p provision) failure(err error) error {
+	switch {
+	case errors.Is(err, configuration.ErrProvisionUnknownCollection),
+		errors.Is(err, configuration.ErrProvisionPresetNotFound):
+		return ErrNotFound
+
+	case errors.Is(err, configuration.ErrProvisionPreconditionRequired):
+		return NewError(http.StatusPreconditionRequired, err.Error())
+
+	case errors.Is(err, configuration.ErrProvisionPreconditionFailed):
+		return NewError(http.StatusPreconditionFailed, err.Error())
+
+	case errors.Is(err, configuration.ErrProvisionInvalid):
+		return NewError(http.StatusBadRequest, err.Error())
+
+	default:
+		return err
+	}
+}
+
+// verify verifies the configuration with the provisioned state applied
+func (p provision) verify(c configuration.Configuration) error {
+	presets, err := p.cmds.Reconfigure(c.Presets)
+	if err != nil {
+		return err
+	}
+
+	c.Presets = presets
+
+	return c.Verify()
+}
+
+// presetIndex returns the index of the Preset and the action requested by
+// the `name` (i.e. "presets/2" or "presets/2/move"), or false when it's not
+// a request for a single Preset
+func presetIndex(name string) (int, string, bool, error) {
+	item, ok := strings.CutPrefix(
+		name, configuration.ProvisionPresets+"/")
+	if !ok {
+		return 0, "", false, nil
+	}
+
+	item, action, _ := strings.Cut(item, "/")
+
+	index, err := strconv.ParseUint(item, 10, 31)
+	if err != nil {
+		return 0, "", true, ErrNotFound
+	}
+
+	return int(index), action, true, nil
+}
+
+// changed reloads the configuration after the provisioned `name` has been
+// changed
+func (p provision) changed(name string, l log.Logger) {
+	l.Info("Provisioned %s has been changed, reloading", name)
+
+	p.reload()
+}
+
+func (p provision) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	name, err := p.prepare(w, r)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	var etag string
+
+	index, action, single, err := presetIndex(name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case single && len(action) > 0:
+		return ErrNotFound
+
+	case single:
+		data, etag, err = p.provision.GetPreset(index)
+
+	default:
+		data, etag, err = p.provision.Get(name)
+	}
+	if err != nil {
+		return p.failure(err)
+	}
+
+	hd := w.Header()
+	hd.Add("ETag", etag)
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+
+		return nil
+	}
+
+	hd.Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(data)
+
+	return nil
+}
+
+func (p provision) Put(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	name, err := p.prepare(w, r)
+	if err != nil {
+		return err
+	}
+
+	index, action, single, err := presetIndex(name)
+	if err != nil {
+		return err
+	}
+
+	if single && len(action) > 0 {
+		return ErrNotFound
+	}
+
+	data, err := io.ReadAll(
+		http.MaxBytesReader(w, r.Body, provisionMaxRequestSize))
+	if err != nil {
+		return ErrProvisionInvalidRequest
+	}
+
+	var etag string
+	var changed bool
+
+	if single {
+		name = configuration.ProvisionPresets
+		etag, changed, err = p.provision.ReplacePreset(
+			index, data, r.Header.Get("If-Match"), p.verify)
+	} else {
+		etag, changed, err = p.provision.Put(
+			name, data, r.Header.Get("If-Match"), p.verify)
+	}
+	if len(etag) > 0 {
+		w.Header().Add("ETag", etag)
+	}
+	if err != nil {
+		return p.failure(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	if changed {
+		p.changed(name, l)
+	}
+
+	return nil
+}
+
+// provisionMove is the request to move a Preset
+type provisionMove struct {
+	To int
+}
+
+// Post adds a Preset when it's sent to "presets", or moves the Preset when
+// it's sent to "presets/<index>/move"
+func (p provision) Post(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	name, err := p.prepare(w, r)
+	if err != nil {
+		return err
+	}
+
+	index, action, single, err := presetIndex(name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !single && name == configuration.ProvisionPresets:
+	case single && action == "move":
+	default:
+		return ErrNotFound
+	}
+
+	data, err := io.ReadAll(
+		http.MaxBytesReader(w, r.Body, provisionMaxRequestSize))
+	if err != nil {
+		return ErrProvisionInvalidRequest
+	}
+
+	var etag string
+	var changed bool
+
+	if single {
+		move := provisionMove{}
+
+		if json.Unmarshal(data, &move) != nil {
+			return ErrProvisionInvalidRequest
+		}
+
+		etag, changed, err = p.provision.MovePreset(
+			index, move.To, r.Header.Get("If-Match"), p.verify)
+	} else {
+		index, etag, err = p.provision.AddPreset(
+			data, r.Header.Get("If-Match"), p.verify)
+		changed = err == nil
+	}
+	if len(etag) > 0 {
+		w.Header().Add("ETag", etag)
+	}
+	if err != nil {
+		return p.failure(err)
+	}
+
+	if single {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.Header().Add("Location", provisionURLPrefix+
+			configuration.ProvisionPresets+"/"+strconv.Itoa(index))
+		w.WriteHeader(http.StatusCreated)
+	}
+
+	if changed {
+		p.changed(configuration.ProvisionPresets, l)
+	}
+
+	return nil
+}
+
+func (p provision) Delete(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	name, err := p.prepare(w, r)
+	if err != nil {
+		return err
+	}
+
+	index, action, single, err := presetIndex(name)
+	if err != nil {
+		return err
+	}
+
+	if !single || len(action) > 0 {
+		return ErrNotFound
+	}
+
+	etag, changed, err := p.provision.DeletePreset(
+		index, r.Header.Get("If-Match"), p.verify)
+	if len(etag) > 0 {
+		w.Header().Add("ETag", etag)
+	}
+	if err != nil {
+		return p.failure(err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	if changed {
+		p.changed(configuration.ProvisionPresets, l)
+	}
+
+	return nil
+}
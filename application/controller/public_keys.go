@@ -0,0 +1,171 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nirui/sshwifty/application/keyvault"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Errors
+var (
+	ErrPublicKeysVaultDisabled = NewError(
+		http.StatusNotFound, "Public key vault is not enabled")
+
+	ErrPublicKeysInvalidRequest = NewError(
+		http.StatusBadRequest, "Invalid public key request")
+
+	ErrPublicKeysMissingFingerprint = NewError(
+		http.StatusBadRequest, "The fingerprint of the key must be specified")
+
+	ErrPublicKeysNotOwned = NewError(
+		http.StatusForbidden, "The key belongs to another user")
+)
+
+const (
+	publicKeysMaxRequestSize = 16 * 1024
+)
+
+// publicKeys controller allows users to store their public keys (never the
+// private ones) on the server, so they can be picked by the frontend and
+// audited by the operator. The keys are owned by the authenticated user who
+// added them, and the users can only see and remove their own keys. Users
+// who are only authenticated by the SharedKey share the same, anonymous
+// identity
+type publicKeys struct {
+	baseController
+
+	verifier socketVerification
+	vault    *keyvault.Vault
+}
+
+type publicKeysAddRequest struct {
+	Key     string `json:"key"`
+	Comment string `json:"comment"`
+}
+
+func newPublicKeys(
+	verifier socketVerification,
+	vault *keyvault.Vault,
+) publicKeys {
+	return publicKeys{
+		verifier: verifier,
+		vault:    vault,
+	}
+}
+
+func (p publicKeys) prepare(w http.ResponseWriter, r *http.Request) error {
+	if p.vault == nil {
+		return ErrPublicKeysVaultDisabled
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	return p.verifier.authorize(r)
+}
+
+func (p publicKeys) respond(
+	w http.ResponseWriter, code int, data interface{}) error {
+	mData, mErr := json.Marshal(data)
+	if mErr != nil {
+		return mErr
+	}
+
+	w.Header().Add("Content-Type", "text/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(mData)
+
+	return nil
+}
+
+func (p publicKeys) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	err := p.prepare(w, r)
+	if err != nil {
+		return err
+	}
+
+	return p.respond(w, http.StatusOK, p.vault.Owned(p.verifier.user(r)))
+}
+
+func (p publicKeys) Post(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	err := p.prepare(w, r)
+	if err != nil {
+		return err
+	}
+
+	req := publicKeysAddRequest{}
+
+	err = json.NewDecoder(
+		http.MaxBytesReader(w, r.Body, publicKeysMaxRequestSize)).Decode(&req)
+	if err != nil {
+		return ErrPublicKeysInvalidRequest
+	}
+
+	k, err := p.vault.Add([]byte(req.Key), p.verifier.user(r), req.Comment)
+	switch err {
+	case nil:
+	case keyvault.ErrInvalidPublicKey:
+		return NewError(http.StatusBadRequest, err.Error())
+	case keyvault.ErrKeyAlreadyExists:
+		return NewError(http.StatusConflict, err.Error())
+	default:
+		return err
+	}
+
+	l.Info("Public key %s (%s) of \"%s\" has been added",
+		k.Fingerprint, k.Type, k.Owner)
+
+	return p.respond(w, http.StatusCreated, k)
+}
+
+func (p publicKeys) Delete(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	err := p.prepare(w, r)
+	if err != nil {
+		return err
+	}
+
+	fingerprint := r.URL.Query().Get("fingerprint")
+	if len(fingerprint) <= 0 {
+		return ErrPublicKeysMissingFingerprint
+	}
+
+	err = p.vault.RemoveOwned(fingerprint, p.verifier.user(r))
+	switch err {
+	case nil:
+	case keyvault.ErrKeyNotFound:
+		return NewError(http.StatusNotFound, err.Error())
+	case keyvault.ErrKeyNotOwned:
+		return ErrPublicKeysNotOwned
+	default:
+		return err
+	}
+
+	l.Info("Public key %s has been removed", fingerprint)
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
@@ -0,0 +1,106 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/recording"
+)
+
+// Errors
+var (
+	ErrRecordingsSearchDisabled = NewError(
+		http.StatusNotFound, "Recording search is not enabled")
+
+	ErrRecordingsInvalidQuery = NewError(
+		http.StatusBadRequest, "Invalid search query")
+
+	ErrRecordingsInvalidLimit = NewError(
+		http.StatusBadRequest, "Invalid search limit")
+)
+
+const (
+	recordingsSearchDefaultLimit = 100
+	recordingsSearchMaxLimit     = 1000
+)
+
+// recordingsSearch controller lets the administrator find the recorded
+// sessions in which the given text was printed to the terminal
+type recordingsSearch struct {
+	baseController
+
+	token    string
+	recorder *recording.Recorder
+}
+
+func newRecordingsSearch(commonCfg configuration.Common) recordingsSearch {
+	return recordingsSearch{
+		token:    commonCfg.ManagementToken,
+		recorder: commonCfg.Recorder,
+	}
+}
+
+func (s recordingsSearch) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if !s.recorder.Indexed() || len(s.token) <= 0 {
+		return ErrRecordingsSearchDisabled
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	if !bearerAuthorized(r, s.token) {
+		return ErrProvisionUnauthorized
+	}
+
+	query := r.URL.Query()
+	limit := recordingsSearchDefaultLimit
+
+	if v := query.Get("limit"); len(v) > 0 {
+		var err error
+
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > recordingsSearchMaxLimit {
+			return ErrRecordingsInvalidLimit
+		}
+	}
+
+	hits, err := s.recorder.Search(query.Get("q"), limit)
+	if errors.Is(err, recording.ErrIndexEmptyQuery) {
+		return ErrRecordingsInvalidQuery
+	} else if err != nil {
+		return err
+	}
+
+	mData, mErr := json.Marshal(hits)
+	if mErr != nil {
+		return mErr
+	}
+
+	w.Header().Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(mData)
+
+	return nil
+}
@@ -0,0 +1,95 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/recording"
+)
+
+func TestRecordingsSearch(t *testing.T) {
+	recorder := recording.New(recording.Settings{
+		Directory: t.TempDir(),
+		Index:     true,
+	})
+	recorder.Start(log.NewDitch())
+	defer recorder.Close()
+
+	rec, err := recorder.Record(recording.Meta{Protocol: "SSH"})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	rec.Output([]byte("$ cat /etc/shadow\r\n"))
+	defer rec.Close()
+
+	s := newRecordingsSearch(configuration.Common{
+		ManagementToken: "token",
+		Recorder:        recorder,
+	})
+
+	search := func(query string, token string) (*httptest.ResponseRecorder,
+		error) {
+		r := httptest.NewRequest("GET", "/sshwifty/recordings/search?"+query,
+			nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+
+		return w, s.Get(w, r, log.NewDitch())
+	}
+
+	w, err := search("q=ETC%2Fshadow", "token")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	hits := []recording.Hit{}
+	if err := json.Unmarshal(w.Body.Bytes(), &hits); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if len(hits) != 1 || hits[0].Line != "$ cat /etc/shadow" {
+		t.Error("Unexpected hits:", hits)
+	}
+
+	for _, test := range []struct {
+		query    string
+		token    string
+		expected error
+	}{
+		{"q=shadow", "wrong", ErrProvisionUnauthorized},
+		{"q=%20", "token", ErrRecordingsInvalidQuery},
+		{"q=shadow&limit=0", "token", ErrRecordingsInvalidLimit},
+		{"q=shadow&limit=1001", "token", ErrRecordingsInvalidLimit},
+	} {
+		if _, err := search(test.query, test.token); err != test.expected {
+			t.Errorf("Expecting %q to fail with %v, got %v",
+				test.query, test.expected, err)
+		}
+	}
+
+	s = newRecordingsSearch(configuration.Common{ManagementToken: "token"})
+	_, err = search("q=shadow", "token")
+	if err != ErrRecordingsSearchDisabled {
+		t.Error("Expecting the search to be disabled, got", err)
+	}
+}
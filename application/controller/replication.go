@@ -0,0 +1,87 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"net/http"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Errors
+var (
+	ErrReplicationDisabled = NewError(
+		http.StatusNotFound, "Replication is not enabled")
+
+	ErrReplicationUnauthorized = NewError(
+		http.StatusUnauthorized, "Invalid management token")
+)
+
+// replication controller serves the snapshot of the replicated state to the
+// standby instances, which poll it with the ETag of the last snapshot as
+// If-None-Match
+type replication struct {
+	baseController
+
+	token  string
+	source configuration.ReplicationSource
+}
+
+func newReplication(
+	commonCfg configuration.Common,
+	source configuration.ReplicationSource,
+) replication {
+	return replication{
+		token:  commonCfg.ManagementToken,
+		source: source,
+	}
+}
+
+func (p replication) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if len(p.token) <= 0 {
+		return ErrReplicationDisabled
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	if !bearerAuthorized(r, p.token) {
+		return ErrReplicationUnauthorized
+	}
+
+	data, etag, err := p.source.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	hd.Add("ETag", etag)
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+
+		return nil
+	}
+
+	hd.Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(data)
+
+	return nil
+}
@@ -0,0 +1,59 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"net/http"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/network"
+)
+
+// sandboxOnly returns whether or not the client who sent the request `r` can
+// only connect to the sandbox Presets, which is the case for the anonymous
+// users once there's any sandbox Preset. The kiosks are restricted to their
+// own Preset instead
+func (s socket) sandboxOnly(r *http.Request) bool {
+	if len(s.commonCfg.Sandboxes) <= 0 || len(s.user(r)) > 0 {
+		return false
+	}
+
+	_, isKiosk := s.kioskSession(r)
+
+	return !isKiosk
+}
+
+// restrictSandbox confines the anonymous users to the sandbox Presets, and
+// disables the forwarding which could reach beyond them. The remotes must be
+// reached with the protocol of the sandbox Preset, or the dial is refused
+func (s socket) restrictSandbox(
+	r *http.Request,
+	cfg command.Configuration,
+) command.Configuration {
+	if !s.sandboxOnly(r) {
+		return cfg
+	}
+
+	cfg.Dial = network.TargetAccessControlDial(
+		s.commonCfg.Sandboxes, cfg.Dial)
+	cfg.AllowDynamicForwards = false
+	cfg.AllowLocalForwards = false
+	cfg.ReverseForwardPolicy = nil
+
+	return cfg
+}
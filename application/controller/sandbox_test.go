@@ -0,0 +1,85 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/network"
+)
+
+func TestRestrictSandbox(t *testing.T) {
+	s := socket{commonCfg: configuration.Common{
+		Sandboxes: network.AllowedTargets{
+			{Protocol: "SSH", Address: "sandbox:22"}: {},
+		},
+	}}
+
+	cfg := s.restrictSandbox(
+		httptest.NewRequest("GET", "/sshwifty/socket", nil),
+		command.Configuration{
+			Dial: func(
+				ctx context.Context, n string, address string,
+			) (net.Conn, error) {
+				client, server := net.Pipe()
+				server.Close()
+
+				return client, nil
+			},
+			AllowLocalForwards: true,
+		})
+
+	if cfg.AllowLocalForwards {
+		t.Error("Expecting the forwards to be disabled")
+	}
+
+	for _, test := range []struct {
+		protocol string
+		address  string
+		allowed  bool
+	}{
+		{"SSH", "sandbox:22", true},
+		{"Telnet", "sandbox:22", false},
+		{"", "sandbox:22", false},
+		{"SSH", "other:22", false},
+	} {
+		ctx := context.Background()
+		if len(test.protocol) > 0 {
+			ctx = network.WithDialProtocol(ctx, test.protocol)
+		}
+
+		conn, err := cfg.Dial(ctx, "tcp", test.address)
+		if conn != nil {
+			conn.Close()
+		}
+
+		if test.allowed && err != nil {
+			t.Errorf("Expecting %q to %q to be allowed, got %v",
+				test.protocol, test.address, err)
+		} else if !test.allowed &&
+			err != network.ErrAccessControlDialTargetHostNotAllowed {
+			t.Errorf("Expecting %q to %q to be refused, got %v",
+				test.protocol, test.address, err)
+		}
+	}
+}
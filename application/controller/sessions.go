@@ -0,0 +1,124 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/streamstats"
+)
+
+// Errors
+var (
+	ErrSessionsDisabled = NewError(
+		http.StatusNotFound, "Session management is not enabled")
+
+	ErrSessionsInvalidID = NewError(
+		http.StatusBadRequest, "Invalid session ID")
+
+	ErrSessionsNotFound = NewError(
+		http.StatusNotFound, "Session was not found or can't be terminated")
+
+	ErrSessionsUnauthorized = NewError(
+		http.StatusUnauthorized, "Invalid management token")
+)
+
+const (
+	sessionsTerminationReason = "Terminated by the administrator"
+)
+
+// sessions controller lets the administrator list the active streams, and
+// terminate the ones that must not carry on without restarting Sshwifty
+type sessions struct {
+	baseController
+
+	token   string
+	streams *streamstats.Registry
+}
+
+func newSessions(commonCfg configuration.Common) sessions {
+	return sessions{
+		token:   commonCfg.ManagementToken,
+		streams: commonCfg.Streams,
+	}
+}
+
+// prepare authorizes the request
+func (s sessions) prepare(w http.ResponseWriter, r *http.Request) error {
+	if s.streams == nil || len(s.token) <= 0 {
+		return ErrSessionsDisabled
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	if !bearerAuthorized(r, s.token) {
+		return ErrSessionsUnauthorized
+	}
+
+	return nil
+}
+
+func (s sessions) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if err := s.prepare(w, r); err != nil {
+		return err
+	}
+
+	records := s.streams.Records()
+	if records == nil {
+		records = []streamstats.Record{}
+	}
+
+	mData, mErr := json.Marshal(records)
+	if mErr != nil {
+		return mErr
+	}
+
+	w.Header().Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(mData)
+
+	return nil
+}
+
+func (s sessions) Delete(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	if err := s.prepare(w, r); err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		return ErrSessionsInvalidID
+	}
+
+	if !s.streams.Terminate(id, sessionsTerminationReason) {
+		return ErrSessionsNotFound
+	}
+
+	l.Info("Session %d has been terminated by the administrator", id)
+
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
@@ -0,0 +1,133 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/settings"
+)
+
+// Errors
+var (
+	ErrUserSettingsDisabled = NewError(
+		http.StatusNotFound, "User settings is not enabled")
+
+	ErrUserSettingsUnknownUser = NewError(
+		http.StatusForbidden, "The identity of the user is unknown")
+
+	ErrUserSettingsInvalidRequest = NewError(
+		http.StatusBadRequest, "Invalid user settings request")
+)
+
+const (
+	userSettingsMaxRequestSize = 4 * 1024
+)
+
+// userSettings controller allows users to save their default settings on the
+// server, so the settings follow them across browsers and devices
+type userSettings struct {
+	baseController
+
+	verifier socketVerification
+	store    *settings.Store
+}
+
+func newUserSettings(
+	verifier socketVerification,
+	store *settings.Store,
+) userSettings {
+	return userSettings{
+		verifier: verifier,
+		store:    store,
+	}
+}
+
+// prepare authorizes the request and returns the identity of the user
+func (u userSettings) prepare(
+	w http.ResponseWriter, r *http.Request) (string, error) {
+	if u.store == nil {
+		return "", ErrUserSettingsDisabled
+	}
+
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	err := u.verifier.authorize(r)
+	if err != nil {
+		return "", err
+	}
+
+	user := u.verifier.user(r)
+	if len(user) <= 0 {
+		return "", ErrUserSettingsUnknownUser
+	}
+
+	return user, nil
+}
+
+func (u userSettings) respond(
+	w http.ResponseWriter, s settings.Settings) error {
+	mData, mErr := json.Marshal(s)
+	if mErr != nil {
+		return mErr
+	}
+
+	w.Header().Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(mData)
+
+	return nil
+}
+
+func (u userSettings) Get(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	user, err := u.prepare(w, r)
+	if err != nil {
+		return err
+	}
+
+	return u.respond(w, u.store.Get(user))
+}
+
+func (u userSettings) Put(
+	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	user, err := u.prepare(w, r)
+	if err != nil {
+		return err
+	}
+
+	req := settings.Settings{}
+
+	err = json.NewDecoder(
+		http.MaxBytesReader(w, r.Body, userSettingsMaxRequestSize)).Decode(&req)
+	if err != nil {
+		return ErrUserSettingsInvalidRequest
+	}
+
+	err = u.store.Set(user, req)
+	if err != nil {
+		return NewError(http.StatusBadRequest, err.Error())
+	}
+
+	l.Info("Saved default settings of user %q", user)
+
+	return u.respond(w, req)
+}
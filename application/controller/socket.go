@@ -27,6 +27,7 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -34,8 +35,12 @@ import (
 
 	"github.com/nirui/sshwifty/application/command"
 	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/hostkeys"
+	"github.com/nirui/sshwifty/application/journal"
 	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/passkey"
 	"github.com/nirui/sshwifty/application/rw"
+	"github.com/nirui/sshwifty/application/settings"
 )
 
 // Errors
@@ -54,6 +59,12 @@ var (
 
 	ErrSocketInvalidDataPackage = NewError(
 		http.StatusBadRequest, "Invalid data package")
+
+	ErrSocketTooManyClients = NewError(
+		http.StatusTooManyRequests, "Too many clients are connected")
+
+	ErrSocketShuttingDown = NewError(
+		http.StatusServiceUnavailable, "Server is shutting down")
 )
 
 const (
@@ -68,6 +79,13 @@ type socket struct {
 	upgrader  websocket.Upgrader
 	commander command.Commander
 	hks       command.Hooks
+	stepUp    *command.StepUp
+	journal   *journal.Journal
+	known     *hostkeys.Store
+	settings  *settings.Store
+	passkeys  *passkey.RelyingParty
+	bindings  *socketBindings
+	logins    loginLimiter
 }
 
 func hashCombineSocketKeys(addedKey string, privateKey string) []byte {
@@ -83,14 +101,116 @@ func newSocketCtl(
 	cfg configuration.Server,
 	cmds command.Commands,
 	hooks command.Hooks,
+	stepUp *command.StepUp,
+	j *journal.Journal,
+	known *hostkeys.Store,
+	st *settings.Store,
+	pk *passkey.RelyingParty,
+	logins loginLimiter,
 ) socket {
+	var bindings *socketBindings
+	if commonCfg.SocketBinding {
+		bindings = newSocketBindings(commonCfg.TrustedProxies)
+	}
+
 	return socket{
 		commonCfg: commonCfg,
 		serverCfg: cfg,
 		upgrader:  buildWebsocketUpgrader(cfg),
 		commander: command.New(cmds),
 		hks:       hooks,
+		stepUp:    stepUp,
+		journal:   j,
+		known:     known,
+		settings:  st,
+		logins:    logins,
+		passkeys:  pk,
+		bindings:  bindings,
+	}
+}
+
+// passkeySession returns the passkey login session carried by the request
+// `r`
+func (s socket) passkeySession(r *http.Request) (passkey.Session, bool) {
+	if s.passkeys == nil {
+		return passkey.Session{}, false
+	}
+
+	c, err := r.Cookie(passkeySessionCookie)
+	if err != nil {
+		return passkey.Session{}, false
+	}
+
+	return s.passkeys.Session(c.Value)
+}
+
+// sharedKey returns the key which the request `r` must be authenticated
+// with. Kiosks, students and users logged in with a passkey are given their
+// own session key in place of the SharedKey
+func (s socket) sharedKey(r *http.Request) string {
+	if session, ok := s.kioskSession(r); ok {
+		return session.Key
+	}
+
+	if session, ok := s.seatSession(r); ok {
+		return session.Key
 	}
+
+	if session, ok := s.passkeySession(r); ok {
+		return session.Key
+	}
+
+	return s.commonCfg.SharedKey
+}
+
+// user returns the identity of the user who sent the request `r`. The
+// identity is only trustworthy when it's set by a reverse proxy that
+// authenticated the user
+func (s socket) user(r *http.Request) string {
+	if len(s.commonCfg.UserHeader) <= 0 {
+		if _, ok := s.kioskSession(r); ok {
+			return "kiosk"
+		}
+
+		if session, ok := s.seatSession(r); ok {
+			return fmt.Sprintf("%s#%d", session.Name, session.Seat)
+		}
+
+		if session, ok := s.passkeySession(r); ok {
+			return session.User
+		}
+
+		return ""
+	}
+
+	return r.Header.Get(s.commonCfg.UserHeader)
+}
+
+// restrict applies the restrictions of the kiosks, the students and the
+// sandboxes to the `cfg` of the request `r`
+func (s socket) restrict(
+	r *http.Request,
+	cfg command.Configuration,
+) command.Configuration {
+	return s.restrictSandbox(r, s.restrictSeat(r, s.restrictKiosk(r, cfg)))
+}
+
+// userGroups returns the groups of the user who sent the request `r`, which
+// are set by the reverse proxy that authenticated the user
+func (s socket) userGroups(r *http.Request) []string {
+	userGroups := []string{}
+	if len(s.commonCfg.UserGroupsHeader) <= 0 {
+		return userGroups
+	}
+
+	for _, g := range strings.Split(
+		r.Header.Get(s.commonCfg.UserGroupsHeader), ",") {
+		if g = strings.TrimSpace(g); len(g) > 0 {
+			userGroups = append(userGroups, g)
+		}
+	}
+
+	return userGroups
 }
 
 type websocketWriter struct {
@@ -214,9 +334,9 @@ func (s socket) createCipher(key []byte) (cipher.AEAD, cipher.AEAD, error) {
 	return gcmRead, gcmWrite, nil
 }
 
-func (s socket) mixerKey(r *http.Request) []byte {
+func (s socket) mixerKey(r *http.Request, sharedKey string) []byte {
 	return hashCombineSocketKeys(
-		r.UserAgent(), s.commonCfg.SharedKey+"+"+s.commonCfg.HostName)
+		r.UserAgent(), sharedKey+"+"+s.commonCfg.HostName)
 }
 
 const keyTimeTruncater = 100
@@ -224,16 +344,65 @@ const keyTimeTruncater = 100
 func (s socket) buildCipherKey(r *http.Request) [16]byte {
 	key := [16]byte{}
 
+	sharedKey := s.sharedKey(r)
+
 	copy(key[:], hashCombineSocketKeys(
 		strconv.FormatInt(time.Now().Unix()/keyTimeTruncater, 10),
-		string(s.mixerKey(r))+"+"+s.commonCfg.SharedKey,
+		string(s.mixerKey(r, sharedKey))+"+"+sharedKey,
 	))
 
 	return key
 }
 
+// record writes a client event into the journal
+func (s socket) record(
+	r *http.Request,
+	t journal.EventType,
+	d time.Duration,
+	e error,
+	l log.Logger,
+) {
+	event := journal.Event{
+		Time:     time.Now(),
+		Type:     t,
+		Client:   r.RemoteAddr,
+		Duration: d,
+		User:     s.user(r),
+		Origin:   clientOrigin(r),
+		Tenant:   s.commonCfg.Tenant,
+	}
+
+	if e != nil {
+		event.Error = e.Error()
+	}
+
+	s.commonCfg.Audit.Publish(event)
+	s.commonCfg.Events.Publish(event)
+
+	if err := s.journal.Record(event); err != nil {
+		l.Warning("Unable to write journal: %s", err)
+	}
+}
+
 func (s socket) Get(
-	w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	w http.ResponseWriter, r *http.Request, l log.Logger) (err error) {
+	if s.bindings != nil {
+		err = s.bindings.consume(r, r.URL.Query().Get("binding"))
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.commonCfg.Sessions.Draining() {
+		return ErrSocketShuttingDown
+	}
+
+	endClient, ok := s.commonCfg.Clients.TryBegin(s.commonCfg.MaxClients)
+	if !ok {
+		return ErrSocketTooManyClients
+	}
+	defer endClient()
+
 	// Error will not be returned when Websocket already handled
 	// (i.e. returned the error to client). We just log the error and that's it
 	c, err := s.upgrader.Upgrade(w, r, nil)
@@ -243,6 +412,18 @@ func (s socket) Get(
 	}
 
 	defer c.Close()
+	defer s.commonCfg.Sessions.Begin()()
+
+	if session, ok := s.seatSession(r); ok {
+		defer s.commonCfg.Classroom.Attend(session, r.RemoteAddr)()
+	}
+
+	connectedAt := time.Now()
+	s.record(r, journal.CLIENT_CONNECTED, 0, nil, l)
+	defer func() {
+		s.record(r, journal.CLIENT_DISCONNECTED,
+			time.Since(connectedAt), err, l)
+	}()
 
 	wsReader := rw.NewFetchReader(s.buildWSFetcher(c))
 	wsWriter := websocketWriter{Conn: c}
@@ -297,12 +478,63 @@ func (s socket) Get(
 	cipherWriteBuf := [cipherReadBufSize]byte{}
 	maxWriteLen := int(cipherReadBufSize) - (writeCipher.Overhead() + 2)
 
+	user := s.user(r)
+	userGroups := s.userGroups(r)
+
 	senderLock := sync.Mutex{}
 	cmdExec, cmdExecErr := s.commander.New(
-		command.Configuration{
-			Dial:        s.commonCfg.Dialer,
-			DialTimeout: s.commonCfg.DecideDialTimeout(s.serverCfg.ReadTimeout),
-		},
+		s.restrict(r, command.Configuration{
+			Dial: s.commonCfg.Dialer,
+			DialTimeout: s.commonCfg.DecideDialTimeout(
+				s.serverCfg.ReadTimeout),
+			ReadDeadlineStrategy: s.commonCfg.ReadDeadlineStrategy,
+			PromptTimeout:        s.commonCfg.PromptTimeout,
+			Journal:              s.journal,
+			Audit:                s.commonCfg.Audit,
+			Tracer:               s.commonCfg.Tracer,
+			Events:               s.commonCfg.Events,
+			KnownHosts:           s.known,
+			ClientAddress:        r.RemoteAddr,
+			ClientOrigin:         clientOrigin(r),
+			Tenant:               s.commonCfg.Tenant,
+			User:                 user,
+			UserGroups:           userGroups,
+			Presets:              s.commonCfg.Presets,
+			ConnectNotice:        s.commonCfg.ConnectNotice,
+			Watcher:              s.commonCfg.Watcher,
+			Broadcaster:          s.commonCfg.Broadcaster,
+			Sessions:             s.commonCfg.Sessions,
+			SSHRekeyThreshold:    s.commonCfg.SSHRekeyThreshold,
+			SSHMaxPacketSize:     s.commonCfg.SSHMaxPacketSize,
+			SSHAlgorithms:        s.commonCfg.SSHAlgorithms,
+			SSHServerPolicy:      s.commonCfg.SSHServerPolicy,
+			SSHKeepaliveInterval: s.commonCfg.SSHKeepaliveInterval,
+			SSHKeepaliveCountMax: s.commonCfg.SSHKeepaliveCountMax,
+			SSHReconnectAttempts: s.commonCfg.SSHReconnectAttempts,
+			SSHReconnectMaxDelay: s.commonCfg.SSHReconnectMaxDelay,
+			Handover:             s.commonCfg.Handover,
+			InterruptMuteTime:    s.commonCfg.InterruptMuteTime,
+			Relays:               s.commonCfg.Relays,
+			Recorder:             s.commonCfg.Recorder,
+			RecordAllSessions:    s.commonCfg.RecordAllSessions,
+			TerminalType:         s.settings.Get(user).TerminalType,
+			TerminalModes:        s.commonCfg.TerminalModes,
+			Warmup:               s.commonCfg.Warmup,
+			ForwardBindHost:      s.commonCfg.ForwardBindHost,
+			ReverseForwardPolicy: s.commonCfg.ReverseForwardPolicy,
+			ReverseForwards:      s.commonCfg.ReverseForwards,
+			Previews:             s.commonCfg.Previews,
+			AllowDynamicForwards: s.commonCfg.AllowDynamicForwards,
+			AllowLocalForwards:   s.commonCfg.AllowLocalForwards,
+			AllowFileTransfer:    s.commonCfg.AllowFileTransfer,
+			SSHAgentSocket:       s.commonCfg.SSHAgentSocket,
+			PresetRemotesOnly:    s.commonCfg.OnlyAllowPresetRemotes,
+			Approver:             s.commonCfg.Approver,
+			StepUp:               s.stepUp,
+			Risk:                 s.commonCfg.RiskScorer,
+			Streams:              s.commonCfg.Streams,
+			Sharing:              s.commonCfg.Sharing,
+		}),
 		rw.NewFetchReader(func() ([]byte, error) {
 			defer s.increaseNonce(readNonce[:])
 
@@ -0,0 +1,161 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrSocketBindingFailed = NewError(
+		http.StatusForbidden,
+		"The Websocket connection must present a valid binding token "+
+			"issued to the same client")
+
+	ErrSocketBindingTooManyTokens = NewError(
+		http.StatusTooManyRequests,
+		"Too many Websocket connections are pending, please try again later")
+)
+
+const (
+	socketBindingTokenSize = 32
+	socketBindingLifetime  = 60 * time.Second
+	socketBindingMaxTokens = 4096
+
+	// Limits the tokens of each client address, so a single client can't use
+	// up the socketBindingMaxTokens to lock out everyone else. Clients behind
+	// a reverse proxy share one address unless the proxy is trusted
+	socketBindingMaxClientTokens = 16
+)
+
+type socketBinding struct {
+	address string
+	client  [sha256.Size]byte
+	expires time.Time
+}
+
+// socketBindings issues single-use tokens to verified clients. Every
+// Websocket connection must present one, so a stolen key cannot connect
+// without also passing the verification from a similar looking client
+type socketBindings struct {
+	lock    sync.Mutex
+	tokens  map[string]socketBinding
+	proxies trustedProxies
+	now     func() time.Time
+}
+
+func newSocketBindings(proxies trustedProxies) *socketBindings {
+	return &socketBindings{
+		lock:    sync.Mutex{},
+		tokens:  map[string]socketBinding{},
+		proxies: proxies,
+		now:     time.Now,
+	}
+}
+
+// client returns the fingerprint of the client of `r`, which is the hash of
+// it's address, User-Agent, and the server name and version of the TLS
+// connection. It's not bound to the TLS connection itself, everything in it
+// can be copied by an attacker who shares the address with the client, so
+// it only keeps a stolen token from being used elsewhere
+func (b *socketBindings) client(r *http.Request) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte(clientAddress(r, b.proxies)))
+	h.Write([]byte{0})
+	h.Write([]byte(r.UserAgent()))
+
+	if r.TLS != nil {
+		h.Write([]byte{0})
+		h.Write([]byte(r.TLS.ServerName))
+		h.Write([]byte{byte(r.TLS.Version >> 8), byte(r.TLS.Version)})
+	}
+
+	sum := [sha256.Size]byte{}
+	copy(sum[:], h.Sum(nil))
+
+	return sum
+}
+
+// issue creates a new token for the client of `r`
+func (b *socketBindings) issue(r *http.Request) (string, error) {
+	t := make([]byte, socketBindingTokenSize)
+
+	_, err := rand.Read(t)
+	if err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(t)
+	address := clientAddress(r, b.proxies)
+	now := b.now()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	issued := 0
+
+	for k, v := range b.tokens {
+		if now.After(v.expires) {
+			delete(b.tokens, k)
+		} else if v.address == address {
+			issued++
+		}
+	}
+
+	if issued >= socketBindingMaxClientTokens ||
+		len(b.tokens) >= socketBindingMaxTokens {
+		return "", ErrSocketBindingTooManyTokens
+	}
+
+	b.tokens[token] = socketBinding{
+		address: address,
+		client:  b.client(r),
+		expires: now.Add(socketBindingLifetime),
+	}
+
+	return token, nil
+}
+
+// consume verifies and invalidates the `token` presented by `r`
+func (b *socketBindings) consume(r *http.Request, token string) error {
+	client := b.client(r)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	binding, ok := b.tokens[token]
+	if !ok {
+		return ErrSocketBindingFailed
+	}
+
+	delete(b.tokens, token)
+
+	if b.now().After(binding.expires) ||
+		subtle.ConstantTimeCompare(binding.client[:], client[:]) != 1 {
+		return ErrSocketBindingFailed
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSocketBindingsConsume(t *testing.T) {
+	b := newSocketBindings(nil)
+
+	r := httptest.NewRequest("GET", "/sshwifty/socket/verify", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("User-Agent", "Test")
+
+	token, err := b.issue(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other client
+	other := httptest.NewRequest("GET", "/sshwifty/socket", nil)
+	other.RemoteAddr = "192.0.2.2:1234"
+	other.Header.Set("User-Agent", "Test")
+
+	if err := b.consume(other, token); err != ErrSocketBindingFailed {
+		t.Errorf("Expecting ErrSocketBindingFailed, got %v", err)
+	}
+
+	// Tokens presented by other clients are invalidated as well
+	token, _ = b.issue(r)
+	b.consume(other, token)
+
+	if err := b.consume(r, token); err != ErrSocketBindingFailed {
+		t.Errorf("Expecting ErrSocketBindingFailed, got %v", err)
+	}
+
+	// Same client from another port, single use
+	token, _ = b.issue(r)
+	r.RemoteAddr = "192.0.2.1:5678"
+
+	if err := b.consume(r, token); err != nil {
+		t.Errorf("Expecting token to be accepted, got %v", err)
+	}
+
+	if err := b.consume(r, token); err != ErrSocketBindingFailed {
+		t.Errorf("Expecting ErrSocketBindingFailed, got %v", err)
+	}
+
+	// Expired
+	token, _ = b.issue(r)
+	b.now = func() time.Time {
+		return time.Now().Add(2 * socketBindingLifetime)
+	}
+
+	if err := b.consume(r, token); err != ErrSocketBindingFailed {
+		t.Errorf("Expecting ErrSocketBindingFailed, got %v", err)
+	}
+}
+
+func TestSocketBindingsIssueLimits(t *testing.T) {
+	b := newSocketBindings(nil)
+
+	r := httptest.NewRequest("GET", "/sshwifty/socket/verify", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	for i := 0; i < socketBindingMaxClientTokens; i++ {
+		if _, err := b.issue(r); err != nil {
+			t.Fatalf("Expecting token %d to be issued, got %v", i, err)
+		}
+	}
+
+	if _, err := b.issue(r); err != ErrSocketBindingTooManyTokens {
+		t.Errorf("Expecting ErrSocketBindingTooManyTokens, got %v", err)
+	}
+
+	// Other clients are not affected
+	other := httptest.NewRequest("GET", "/sshwifty/socket/verify", nil)
+	other.RemoteAddr = "192.0.2.2:1234"
+
+	token, err := b.issue(other)
+	if err != nil {
+		t.Errorf("Expecting token to be issued, got %v", err)
+	}
+
+	// Consumed tokens no longer count
+	if err := b.consume(other, token); err != nil {
+		t.Errorf("Expecting token to be accepted, got %v", err)
+	}
+
+	// Global cap as the backstop
+	for i := 0; len(b.tokens) < socketBindingMaxTokens; i++ {
+		b.tokens[strconv.Itoa(i)] = socketBinding{
+			address: strconv.Itoa(i),
+			expires: b.now().Add(socketBindingLifetime),
+		}
+	}
+
+	if _, err := b.issue(other); err != ErrSocketBindingTooManyTokens {
+		t.Errorf("Expecting ErrSocketBindingTooManyTokens, got %v", err)
+	}
+
+	// Expired tokens no longer count
+	b.now = func() time.Time {
+		return time.Now().Add(2 * socketBindingLifetime)
+	}
+
+	if _, err := b.issue(r); err != nil {
+		t.Errorf("Expecting token to be issued, got %v", err)
+	}
+}
+
+func TestSocketBindingsTrustedProxies(t *testing.T) {
+	b := newSocketBindings(trustedProxies{
+		netip.MustParsePrefix("10.0.0.1/32"),
+	})
+
+	r := httptest.NewRequest("GET", "/sshwifty/socket/verify", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "192.0.2.1")
+
+	for i := 0; i < socketBindingMaxClientTokens; i++ {
+		if _, err := b.issue(r); err != nil {
+			t.Fatalf("Expecting token %d to be issued, got %v", i, err)
+		}
+	}
+
+	if _, err := b.issue(r); err != ErrSocketBindingTooManyTokens {
+		t.Errorf("Expecting ErrSocketBindingTooManyTokens, got %v", err)
+	}
+
+	// Other clients behind the same proxy are not affected
+	other := httptest.NewRequest("GET", "/sshwifty/socket/verify", nil)
+	other.RemoteAddr = "10.0.0.1:5678"
+	other.Header.Set("X-Forwarded-For", "192.0.2.2")
+
+	token, err := b.issue(other)
+	if err != nil {
+		t.Fatalf("Expecting token to be issued, got %v", err)
+	}
+
+	// Nor can they use the tokens of each other
+	if err := b.consume(r, token); err != ErrSocketBindingFailed {
+		t.Errorf("Expecting ErrSocketBindingFailed, got %v", err)
+	}
+}
@@ -29,6 +29,7 @@ import (
 
 	"github.com/nirui/sshwifty/application/configuration"
 	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/watcher"
 )
 
 type socketVerification struct {
@@ -36,15 +37,21 @@ type socketVerification struct {
 
 	heartbeat     string
 	timeout       string
+	accessCfg     socketAccessConfiguration
 	configRspBody []byte
 }
 
 type socketRemotePreset struct {
-	Title    string            `json:"title"`
-	Type     string            `json:"type"`
-	Host     string            `json:"host"`
-	TabColor string            `json:"tab_color"`
-	Meta     map[string]string `json:"meta"`
+	ID          int               `json:"id"`
+	Title       string            `json:"title"`
+	Type        string            `json:"type"`
+	Host        string            `json:"host"`
+	TabColor    string            `json:"tab_color"`
+	Meta        map[string]string `json:"meta"`
+	Status      string            `json:"status,omitempty"`
+	NoTrace     bool              `json:"no_trace,omitempty"`
+	Command     string            `json:"command,omitempty"`
+	Environment map[string]string `json:"environment,omitempty"`
 }
 
 type socketAccessConfiguration struct {
@@ -59,11 +66,15 @@ func newSocketAccessConfiguration(
 	presets := make([]socketRemotePreset, len(remotes))
 	for i := range presets {
 		presets[i] = socketRemotePreset{
-			Title:    remotes[i].Title,
-			Type:     remotes[i].Type,
-			Host:     remotes[i].Host,
-			TabColor: remotes[i].TabColor,
-			Meta:     remotes[i].Meta,
+			ID:          i,
+			Title:       remotes[i].Title,
+			Type:        remotes[i].Type,
+			Host:        remotes[i].Host,
+			TabColor:    remotes[i].TabColor,
+			Meta:        remotes[i].Meta,
+			NoTrace:     remotes[i].NoTrace,
+			Command:     remotes[i].Command,
+			Environment: remotes[i].Environment,
 		}
 	}
 	return socketAccessConfiguration{
@@ -72,6 +83,27 @@ func newSocketAccessConfiguration(
 	}
 }
 
+// withStatuses returns a copy of the configuration with the availability
+// statuses of the watched Presets filled in
+func (s socketAccessConfiguration) withStatuses(
+	statuses []watcher.Status,
+) socketAccessConfiguration {
+	presets := make([]socketRemotePreset, len(s.Presets))
+	copy(presets, s.Presets)
+
+	for _, st := range statuses {
+		if st.Preset < 0 || st.Preset >= len(presets) {
+			continue
+		}
+
+		presets[st.Preset].Status = st.State.String()
+	}
+
+	s.Presets = presets
+
+	return s
+}
+
 func buildAccessConfigRespondBody(accessCfg socketAccessConfiguration) []byte {
 	mData, mErr := json.Marshal(accessCfg)
 	if mErr != nil {
@@ -85,28 +117,29 @@ func newSocketVerification(
 	srvCfg configuration.Server,
 	commCfg configuration.Common,
 ) socketVerification {
+	accessCfg := newSocketAccessConfiguration(
+		commCfg.Presets,
+		srvCfg.ServerMessage,
+	)
+
 	return socketVerification{
 		socket: s,
 		heartbeat: strconv.FormatFloat(
 			srvCfg.HeartbeatTimeout.Seconds(), 'g', 2, 64),
 		timeout: strconv.FormatFloat(
 			srvCfg.ReadTimeout.Seconds(), 'g', 2, 64),
-		configRspBody: buildAccessConfigRespondBody(
-			newSocketAccessConfiguration(
-				commCfg.Presets,
-				srvCfg.ServerMessage,
-			),
-		),
+		accessCfg:     accessCfg,
+		configRspBody: buildAccessConfigRespondBody(accessCfg),
 	}
 }
 
-func (s socketVerification) authKey(r *http.Request) []byte {
+func (s socketVerification) authKey(sharedKey string) []byte {
 	timeMixer := strconv.FormatInt(time.Now().Unix()/100, 10)
 
-	if len(s.commonCfg.SharedKey) > 0 {
+	if len(sharedKey) > 0 {
 		return hashCombineSocketKeys(
 			timeMixer,
-			s.commonCfg.SharedKey,
+			sharedKey,
 		)[:32]
 	}
 
@@ -116,18 +149,83 @@ func (s socketVerification) authKey(r *http.Request) []byte {
 	)[:32]
 }
 
+// authorize verifies the Auth Key carried by the request. Requests are always
+// authorized when no SharedKey is configured
+func (s socketVerification) authorize(r *http.Request) error {
+	if len(s.commonCfg.SharedKey) <= 0 {
+		return nil
+	}
+
+	key := r.Header.Get("X-Key")
+
+	if len(key) <= 0 || len(key) > 64 {
+		return ErrSocketInvalidAuthKey
+	}
+
+	err := s.logins.verify(r, func() error {
+		// Delay the brute force attack, same as the socket verification
+		time.Sleep(500 * time.Millisecond)
+
+		decodedKey, decodedKeyErr := base64.StdEncoding.DecodeString(key)
+
+		if decodedKeyErr != nil {
+			return NewError(http.StatusBadRequest, decodedKeyErr.Error())
+		}
+
+		if !hmac.Equal(s.authKey(s.sharedKey(r)), decodedKey) {
+			return ErrSocketAuthFailed
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logins.succeed(r)
+
+	return nil
+}
+
 func (s socketVerification) setServerConfigRespond(
-	hd *http.Header, w http.ResponseWriter) {
+	hd *http.Header, w http.ResponseWriter, r *http.Request) error {
+	// The token must be presented by the Websocket connection that follows
+	if s.bindings != nil {
+		token, err := s.bindings.issue(r)
+		if err != nil {
+			return err
+		}
+
+		hd.Add("X-Binding", token)
+	}
+
 	hd.Add("X-Heartbeat", s.heartbeat)
 	hd.Add("X-Timeout", s.timeout)
 
-	if s.commonCfg.OnlyAllowPresetRemotes {
+	_, isStudent := s.seatSession(r)
+
+	if s.commonCfg.OnlyAllowPresetRemotes || s.sandboxOnly(r) || isStudent {
 		hd.Add("X-OnlyAllowPresetRemotes", "yes")
 	}
 
+	if s.passkeys != nil {
+		hd.Add("X-Passkeys", "yes")
+	}
+
 	hd.Add("Content-Type", "text/json; charset=utf-8")
 
+	// Statuses of the watched Presets changes over time, so the respond must
+	// be rebuilt every time
+	if s.commonCfg.Watcher != nil {
+		w.Write(buildAccessConfigRespondBody(
+			s.accessCfg.withStatuses(s.commonCfg.Watcher.Statuses())))
+
+		return nil
+	}
+
 	w.Write(s.configRspBody)
+
+	return nil
 }
 
 func (s socketVerification) Get(
@@ -138,13 +236,18 @@ func (s socketVerification) Get(
 
 	key := r.Header.Get("X-Key")
 
+	sharedKey := s.sharedKey(r)
+
 	if len(key) <= 0 {
-		hd.Add("X-Key", base64.StdEncoding.EncodeToString(s.mixerKey(r)))
+		hd.Add("X-Key", base64.StdEncoding.EncodeToString(
+			s.mixerKey(r, sharedKey)))
 
-		if len(s.commonCfg.SharedKey) <= 0 {
-			s.setServerConfigRespond(&hd, w)
+		if s.passkeys != nil {
+			hd.Add("X-Passkeys", "yes")
+		}
 
-			return nil
+		if len(s.commonCfg.SharedKey) <= 0 {
+			return s.setServerConfigRespond(&hd, w, r)
 		}
 
 		return ErrSocketInvalidAuthKey
@@ -154,26 +257,46 @@ func (s socketVerification) Get(
 		return ErrSocketInvalidAuthKey
 	}
 
-	// Delay the brute force attack. Use it with connection limits (via
-	// iptables or nginx etc)
-	time.Sleep(500 * time.Millisecond)
+	err := s.logins.verify(r, func() error {
+		// Delay the brute force attack. Use it with the LoginLimit, or
+		// connection limits (via iptables or nginx etc)
+		time.Sleep(500 * time.Millisecond)
 
-	decodedKey, decodedKeyErr := base64.StdEncoding.DecodeString(key)
+		decodedKey, decodedKeyErr := base64.StdEncoding.DecodeString(key)
 
-	if decodedKeyErr != nil {
-		return NewError(http.StatusBadRequest, decodedKeyErr.Error())
-	}
+		if decodedKeyErr != nil {
+			return NewError(http.StatusBadRequest, decodedKeyErr.Error())
+		}
 
-	authKey := s.authKey(r)
+		if hmac.Equal(s.authKey(sharedKey), decodedKey) {
+			return nil
+		}
+
+		// A user who logged in with a passkey (or a kiosk, or a student) may
+		// switch back
+		// to the SharedKey, in which case the session is dropped
+		if sharedKey == s.commonCfg.SharedKey ||
+			!hmac.Equal(s.authKey(s.commonCfg.SharedKey), decodedKey) {
+			return ErrSocketAuthFailed
+		}
 
-	if !hmac.Equal(authKey, decodedKey) {
-		return ErrSocketAuthFailed
+		sharedKey = s.commonCfg.SharedKey
+		s.dropPasskeySession(w, r)
+		s.dropKioskSession(w, r)
+		s.dropSeatSession(w, r)
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	hd.Add("X-Key", base64.StdEncoding.EncodeToString(s.mixerKey(r)))
-	s.setServerConfigRespond(&hd, w)
+	s.logins.succeed(r)
 
-	return nil
+	hd.Add("X-Key", base64.StdEncoding.EncodeToString(
+		s.mixerKey(r, sharedKey)))
+
+	return s.setServerConfigRespond(&hd, w, r)
 }
 
 func (s socketVerification) Options(
@@ -0,0 +1,132 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+// tenantCookieName is the name of the cookie which selects the tenant for
+// the clients that reached Sshwifty through the PathPrefix of it
+const tenantCookieName = "sshwifty-tenant"
+
+// tenants dispatches the requests to the handlers of the tenants
+type tenants struct {
+	main         http.Handler
+	byHostName   map[string]http.Handler
+	byName       map[string]http.Handler
+	pathPrefixes []tenantPathPrefix
+}
+
+type tenantPathPrefix struct {
+	prefix string
+	name   string
+}
+
+func newTenants(
+	ts []configuration.TenantCommon,
+	main http.Handler,
+	build func(configuration.TenantCommon) http.Handler,
+) tenants {
+	d := tenants{
+		main:         main,
+		byHostName:   make(map[string]http.Handler, len(ts)),
+		byName:       make(map[string]http.Handler, len(ts)),
+		pathPrefixes: make([]tenantPathPrefix, 0, len(ts)),
+	}
+
+	for _, t := range ts {
+		h := build(t)
+
+		for _, n := range t.HostNames {
+			d.byHostName[n] = h
+		}
+
+		// Only the tenants with a PathPrefix can be selected by the cookie,
+		// otherwise the cookie can be used to reach the tenants which are
+		// meant to be reached through their host names alone
+		if len(t.PathPrefix) > 0 {
+			d.byName[t.Name] = h
+			d.pathPrefixes = append(d.pathPrefixes, tenantPathPrefix{
+				prefix: t.PathPrefix,
+				name:   t.Name,
+			})
+		}
+	}
+
+	return d
+}
+
+// hostName returns the lower cased host name of the request, without the
+// port
+func (d tenants) hostName(r *http.Request) string {
+	hostPort := r.Host
+
+	if len(hostPort) <= 0 {
+		hostPort = r.URL.Host
+	}
+
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host = hostPort
+	}
+
+	return strings.ToLower(host)
+}
+
+func (d tenants) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, ok := d.byHostName[d.hostName(r)]; ok {
+		h.ServeHTTP(w, r)
+
+		return
+	}
+
+	for _, p := range d.pathPrefixes {
+		if r.URL.Path != p.prefix &&
+			!strings.HasPrefix(r.URL.Path, p.prefix+"/") {
+			continue
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     tenantCookieName,
+			Value:    p.name,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+
+		return
+	}
+
+	if c, err := r.Cookie(tenantCookieName); err == nil {
+		if h, ok := d.byName[c.Value]; ok {
+			h.ServeHTTP(w, r)
+
+			return
+		}
+	}
+
+	d.main.ServeHTTP(w, r)
+}
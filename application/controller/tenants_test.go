@@ -0,0 +1,88 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nirui/sshwifty/application/configuration"
+)
+
+type namedHandler string
+
+func (n namedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, string(n))
+}
+
+func TestTenants(t *testing.T) {
+	d := newTenants([]configuration.TenantCommon{
+		{Name: "a", HostNames: []string{"a.example.com"}},
+		{Name: "b", PathPrefix: "/b"},
+	}, namedHandler("main"), func(t configuration.TenantCommon) http.Handler {
+		return namedHandler(t.Name)
+	})
+
+	for _, test := range []struct {
+		host   string
+		path   string
+		cookie string
+		served string
+	}{
+		{"example.com", "/", "", "main"},
+		{"A.example.com:8182", "/", "", "a"},
+		{"a.example.com", "/", "b", "a"},
+		{"example.com", "/", "b", "b"},
+		{"example.com", "/", "a", "main"},
+		{"example.com", "/", "c", "main"},
+		{"example.com", "/bb", "", "main"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, test.path, nil)
+		r.Host = test.host
+		if len(test.cookie) > 0 {
+			r.AddCookie(&http.Cookie{Name: tenantCookieName, Value: test.cookie})
+		}
+
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, r)
+
+		if w.Body.String() != test.served {
+			t.Errorf("Expecting %s %s (cookie %q) to be served by %q, got %q",
+				test.host, test.path, test.cookie, test.served,
+				w.Body.String())
+		}
+	}
+
+	for _, path := range []string{"/b", "/b/"} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, r)
+
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+			t.Errorf("Expecting %s to be redirected to /, got %d %q",
+				path, w.Code, w.Header().Get("Location"))
+		}
+
+		c := w.Result().Cookies()
+		if len(c) != 1 || c[0].Name != tenantCookieName || c[0].Value != "b" {
+			t.Errorf("Expecting %s to select tenant \"b\", got %v", path, c)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/network"
+)
+
+// usage controller exposes the traffic usage of the remotes
+type usage struct {
+	baseController
+
+	verifier socketVerification
+	usage    *network.TrafficUsage
+}
+
+func newUsage(verifier socketVerification, u *network.TrafficUsage) usage {
+	return usage{
+		verifier: verifier,
+		usage:    u,
+	}
+}
+
+// writePrometheus writes the records in the Prometheus text format
+func (u usage) writePrometheus(
+	w http.ResponseWriter, records []network.TrafficRecord) {
+	w.Header().Add("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	metrics := []struct {
+		name  string
+		help  string
+		value func(r network.TrafficRecord) uint64
+	}{
+		{
+			"sshwifty_remote_connections_total",
+			"Total connections made to the remotes",
+			func(r network.TrafficRecord) uint64 { return r.Connections },
+		},
+		{
+			"sshwifty_remote_sent_bytes_total",
+			"Total bytes sent to the remotes",
+			func(r network.TrafficRecord) uint64 { return r.Sent },
+		},
+		{
+			"sshwifty_remote_received_bytes_total",
+			"Total bytes received from the remotes",
+			func(r network.TrafficRecord) uint64 { return r.Received },
+		},
+	}
+
+	b := strings.Builder{}
+
+	for _, m := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n",
+			m.name, m.help, m.name)
+
+		for _, r := range records {
+			fmt.Fprintf(&b, "%s{group=%s} %d\n",
+				m.name, strconv.Quote(r.Name), m.value(r))
+		}
+	}
+
+	w.Write([]byte(b.String()))
+}
+
+func (u usage) Get(w http.ResponseWriter, r *http.Request, l log.Logger) error {
+	hd := w.Header()
+	hd.Add("Cache-Control", "no-store")
+	hd.Add("Pragma", "no-store")
+
+	err := u.verifier.authorize(r)
+	if err != nil {
+		return err
+	}
+
+	records := u.usage.Records()
+
+	if r.URL.Query().Get("format") == "prometheus" {
+		u.writePrometheus(w, records)
+
+		return nil
+	}
+
+	mData, mErr := json.Marshal(records)
+	if mErr != nil {
+		return mErr
+	}
+
+	hd.Add("Content-Type", "text/json; charset=utf-8")
+	w.Write(mData)
+
+	return nil
+}
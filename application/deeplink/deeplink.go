@@ -0,0 +1,107 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package deeplink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Errors
+var (
+	ErrDisabled = errors.New(
+		"deep links are disabled")
+
+	ErrMalformed = errors.New(
+		"malformed deep link")
+
+	ErrExpired = errors.New(
+		"deep link has expired")
+
+	ErrInvalidToken = errors.New(
+		"invalid deep link token")
+)
+
+// Signer signs and verifies the deep links that open a Preset. The token of
+// a link is the unpadded base64url encoded HMAC-SHA256 of the Preset title
+// and the expiry time (Unix seconds, in decimal) joined with "\n"
+type Signer struct {
+	key []byte
+	now func() time.Time
+}
+
+// New creates a new Signer with the `key`. Signer with an empty key refuses
+// every link
+func New(key string) Signer {
+	return Signer{
+		key: []byte(key),
+		now: time.Now,
+	}
+}
+
+// Enabled returns whether or not the deep links are enabled
+func (s Signer) Enabled() bool {
+	return len(s.key) > 0
+}
+
+func (s Signer) token(preset string, expires int64) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(preset))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+
+	return mac.Sum(nil)
+}
+
+// Sign returns the token of the link that opens the `preset` until `expires`
+func (s Signer) Sign(preset string, expires time.Time) string {
+	return base64.RawURLEncoding.EncodeToString(
+		s.token(preset, expires.Unix()))
+}
+
+// Verify returns nil when the `token` of the link that opens the `preset`
+// until `expires` (Unix seconds) is valid
+func (s Signer) Verify(preset string, expires string, token string) error {
+	if !s.Enabled() {
+		return ErrDisabled
+	}
+
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || len(preset) <= 0 {
+		return ErrMalformed
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return ErrMalformed
+	}
+
+	if !hmac.Equal(s.token(preset, exp), decoded) {
+		return ErrInvalidToken
+	}
+
+	if s.now().Unix() > exp {
+		return ErrExpired
+	}
+
+	return nil
+}
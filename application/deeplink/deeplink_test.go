@@ -0,0 +1,63 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package deeplink
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := New("secret")
+	s.now = func() time.Time { return now }
+
+	expires := now.Add(time.Minute)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	token := s.Sign("web01", expires)
+
+	if err := s.Verify("web01", exp, token); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	if err := s.Verify("web02", exp, token); !errors.Is(err, ErrInvalidToken) {
+		t.Error("Expecting the token to be bound to the Preset, got", err)
+	}
+
+	if err := s.Verify("web01", exp+"0", token); !errors.Is(
+		err, ErrInvalidToken) {
+		t.Error("Expecting the token to be bound to the expiry, got", err)
+	}
+
+	if err := s.Verify("web01", "soon", token); !errors.Is(err, ErrMalformed) {
+		t.Error("Expecting ErrMalformed, got", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	if err := s.Verify("web01", exp, token); !errors.Is(err, ErrExpired) {
+		t.Error("Expecting ErrExpired, got", err)
+	}
+
+	if err := New("").Verify("web01", exp, token); !errors.Is(
+		err, ErrDisabled) {
+		t.Error("Expecting ErrDisabled, got", err)
+	}
+}
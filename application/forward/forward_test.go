@@ -0,0 +1,185 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package forward
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	for _, c := range []struct {
+		s      string
+		expect Rule
+	}{
+		{"127.0.0.1:8080", Rule{"127.0.0.1", 8080, 8080}},
+		{"*:8000-8100", Rule{"*", 8000, 8100}},
+		{"[::1]:0-65535", Rule{"::1", 0, 65535}},
+	} {
+		r, err := ParseRule(c.s)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %s", c.s, err)
+			return
+		}
+
+		if r != c.expect {
+			t.Errorf("Expecting %q to be parsed as %v, got %v",
+				c.s, c.expect, r)
+			return
+		}
+
+		if r.String() != c.s {
+			t.Errorf("Expecting %q, got %q", c.s, r.String())
+			return
+		}
+	}
+
+	for _, s := range []string{"8080", ":8080", "*:80-a", "*:9000-8000"} {
+		if _, err := ParseRule(s); err == nil {
+			t.Errorf("Expecting %q to be rejected", s)
+			return
+		}
+	}
+
+	_, err := ParseRule("*:70000")
+	if !errors.Is(err, ErrRuleInvalidPort) {
+		t.Errorf("Expecting ErrRuleInvalidPort, got %v", err)
+		return
+	}
+}
+
+func TestPolicyAllows(t *testing.T) {
+	p, err := ParsePolicy([]string{"localhost:8000-8100", "*:9000"})
+	if err != nil {
+		t.Error("Failed to parse policy:", err)
+		return
+	}
+
+	for _, c := range []struct {
+		host   string
+		port   uint16
+		expect bool
+	}{
+		{"localhost", 8000, true},
+		{"LOCALHOST", 8100, true},
+		{"localhost", 8101, false},
+		{"0.0.0.0", 8050, false},
+		{"0.0.0.0", 9000, true},
+		{"", 9000, true},
+	} {
+		if p.Allows(c.host, c.port) != c.expect {
+			t.Errorf("Expecting %s:%d to be allowed: %t",
+				c.host, c.port, c.expect)
+			return
+		}
+	}
+
+	if (Policy{}).Allows("localhost", 8000) {
+		t.Error("Expecting empty policy to allow nothing")
+		return
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	removeA := r.Add(Entry{Bind: "localhost:8000"})
+	removeB := r.Add(Entry{Bind: "localhost:8001"})
+
+	entries := r.Entries()
+	if len(entries) != 2 ||
+		entries[0].Bind != "localhost:8000" ||
+		entries[1].Bind != "localhost:8001" {
+		t.Errorf("Unexpected entries %v", entries)
+		return
+	}
+
+	removeA()
+	removeA()
+
+	entries = r.Entries()
+	if len(entries) != 1 || entries[0].Bind != "localhost:8001" {
+		t.Errorf("Unexpected entries %v", entries)
+		return
+	}
+
+	removeB()
+
+	if len(r.Entries()) != 0 {
+		t.Error("Expecting all entries to be removed")
+		return
+	}
+
+	var nilRegistry *Registry
+
+	nilRegistry.Add(Entry{})()
+
+	if len(nilRegistry.Entries()) != 0 {
+		t.Error("Expecting nil Registry to have no entries")
+		return
+	}
+}
+
+func TestPreviews(t *testing.T) {
+	p := NewPreviews()
+
+	tokenA, removeA := p.Add(Preview{Name: "a", Target: "localhost:80"})
+	tokenB, removeB := p.Add(Preview{Name: "b", Target: "localhost:81"})
+	defer removeB()
+
+	if len(tokenA) <= 0 || tokenA == tokenB {
+		t.Errorf("Unexpected tokens %q and %q", tokenA, tokenB)
+		return
+	}
+
+	if v, ok := p.Get(tokenA); !ok || v.Name != "a" {
+		t.Errorf("Unexpected Preview %v of %q", v, tokenA)
+		return
+	}
+
+	removeA()
+
+	if _, ok := p.Get(tokenA); ok {
+		t.Error("Expecting the Preview to be removed")
+		return
+	}
+
+	if v, ok := p.Get(tokenB); !ok || v.Name != "b" {
+		t.Errorf("Unexpected Preview %v of %q", v, tokenB)
+		return
+	}
+
+	if _, ok := p.Get(""); ok {
+		t.Error("Expecting empty token to be rejected")
+		return
+	}
+}
+
+func TestHTTPTarget(t *testing.T) {
+	a, ok := HTTPTarget("http://localhost:8080")
+	if !ok || a != "localhost:8080" {
+		t.Errorf("Unexpected HTTP target %q, %t", a, ok)
+		return
+	}
+
+	a, ok = HTTPTarget("localhost:8080")
+	if ok || a != "localhost:8080" {
+		t.Errorf("Unexpected target %q, %t", a, ok)
+		return
+	}
+}
@@ -0,0 +1,140 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package forward contains the policy and the bookkeeping of the reverse
+// (remote) port forwards requested by the clients
+package forward
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Errors
+var (
+	ErrRuleInvalidPort = errors.New("invalid port")
+)
+
+// RuleAnyHost matches any bind host
+const RuleAnyHost = "*"
+
+// Rule allows the remote bind addresses of the given host and port range
+type Rule struct {
+	Host     string
+	FromPort uint16
+	ToPort   uint16
+}
+
+// ParseRule parses a Rule in the format of "host:port" or
+// "host:fromPort-toPort". The host can be "*" to match any bind host
+func ParseRule(s string) (Rule, error) {
+	host, ports, err := net.SplitHostPort(s)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	if len(host) <= 0 {
+		return Rule{}, fmt.Errorf("host of %q is required, use %q to "+
+			"allow any host", s, RuleAnyHost)
+	}
+
+	from, to, found := strings.Cut(ports, "-")
+	if !found {
+		to = from
+	}
+
+	fromPort, err := strconv.ParseUint(from, 10, 16)
+	if err != nil {
+		return Rule{}, fmt.Errorf("%w %q of %q", ErrRuleInvalidPort, from, s)
+	}
+
+	toPort, err := strconv.ParseUint(to, 10, 16)
+	if err != nil {
+		return Rule{}, fmt.Errorf("%w %q of %q", ErrRuleInvalidPort, to, s)
+	}
+
+	if fromPort > toPort {
+		return Rule{}, fmt.Errorf("%w range %q of %q",
+			ErrRuleInvalidPort, ports, s)
+	}
+
+	return Rule{
+		Host:     host,
+		FromPort: uint16(fromPort),
+		ToPort:   uint16(toPort),
+	}, nil
+}
+
+// Allows returns whether or not the Rule allows binding to the `host` and
+// `port`
+func (r Rule) Allows(host string, port uint16) bool {
+	if r.Host != RuleAnyHost && !strings.EqualFold(r.Host, host) {
+		return false
+	}
+
+	return port >= r.FromPort && port <= r.ToPort
+}
+
+// String returns the Rule in the format accepted by ParseRule
+func (r Rule) String() string {
+	ports := strconv.FormatUint(uint64(r.FromPort), 10)
+	if r.ToPort != r.FromPort {
+		ports += "-" + strconv.FormatUint(uint64(r.ToPort), 10)
+	}
+
+	return net.JoinHostPort(r.Host, ports)
+}
+
+// Policy decides which remote bind addresses are allowed. An empty Policy
+// allows nothing
+type Policy []Rule
+
+// ParsePolicy parses all given `rules` into a Policy
+func ParsePolicy(rules []string) (Policy, error) {
+	p := make(Policy, 0, len(rules))
+
+	for _, s := range rules {
+		r, err := ParseRule(s)
+		if err != nil {
+			return nil, err
+		}
+
+		p = append(p, r)
+	}
+
+	return p, nil
+}
+
+// Enabled returns whether or not any reverse forward can be allowed
+func (p Policy) Enabled() bool {
+	return len(p) > 0
+}
+
+// Allows returns whether or not binding to the `host` and `port` is allowed
+// by any of the Rules
+func (p Policy) Allows(host string, port uint16) bool {
+	for _, r := range p {
+		if r.Allows(host, port) {
+			return true
+		}
+	}
+
+	return false
+}
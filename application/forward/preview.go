@@ -0,0 +1,97 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package forward
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"strings"
+	"sync"
+)
+
+// HTTPTargetPrefix marks the targets of the forwards as HTTP services, which
+// can be previewed through Sshwifty
+const HTTPTargetPrefix = "http://"
+
+// HTTPTarget returns the address of the `target` and whether or not it's
+// marked as a HTTP service
+func HTTPTarget(target string) (string, bool) {
+	return strings.CutPrefix(target, HTTPTargetPrefix)
+}
+
+// Preview is a HTTP service that can be previewed through Sshwifty
+type Preview struct {
+	Name   string
+	Target string
+	Dial   func(
+		ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// Previews keeps the Previews of the active sessions. Each Preview is
+// identified by a random token, which is only known by the session that
+// added it
+type Previews struct {
+	lock    sync.RWMutex
+	entries map[string]Preview
+}
+
+// NewPreviews creates a new Previews
+func NewPreviews() *Previews {
+	return &Previews{
+		lock:    sync.RWMutex{},
+		entries: map[string]Preview{},
+	}
+}
+
+// Add adds the Preview `v` and returns its token. Call the returned function
+// to remove it once the session is closed. Nothing is added when the Previews
+// is nil, in which case the returned token is empty
+func (p *Previews) Add(v Preview) (string, func()) {
+	if p == nil {
+		return "", func() {}
+	}
+
+	token := rand.Text()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.entries[token] = v
+
+	return token, sync.OnceFunc(func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+
+		delete(p.entries, token)
+	})
+}
+
+// Get returns the Preview of the `token`
+func (p *Previews) Get(token string) (Preview, bool) {
+	if p == nil {
+		return Preview{}, false
+	}
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	v, ok := p.entries[token]
+
+	return v, ok
+}
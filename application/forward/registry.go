@@ -0,0 +1,99 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package forward
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Entry describes an active reverse forward
+type Entry struct {
+	ID     uint64    `json:"id"`
+	User   string    `json:"user,omitempty"`
+	Client string    `json:"client"`
+	Remote string    `json:"remote"`
+	Bind   string    `json:"bind"`
+	Target string    `json:"target"`
+	Since  time.Time `json:"since"`
+}
+
+// Registry keeps track of the active reverse forwards
+type Registry struct {
+	lock    sync.Mutex
+	nextID  uint64
+	entries map[uint64]Entry
+}
+
+// NewRegistry creates a new Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		lock:    sync.Mutex{},
+		nextID:  0,
+		entries: map[uint64]Entry{},
+	}
+}
+
+// Add records the Entry `e` as active. The ID and the Since of the Entry is
+// assigned by the Registry. Call the returned function to remove it once the
+// forward is closed
+func (r *Registry) Add(e Entry) func() {
+	if r == nil {
+		return func() {}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.nextID++
+
+	e.ID = r.nextID
+	e.Since = time.Now()
+
+	r.entries[e.ID] = e
+
+	return sync.OnceFunc(func() {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		delete(r.entries, e.ID)
+	})
+}
+
+// Entries returns all active forwards ordered by the time they're added
+func (r *Registry) Entries() []Entry {
+	if r == nil {
+		return []Entry{}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	entries := make([]Entry, 0, len(r.entries))
+
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+
+	return entries
+}
@@ -0,0 +1,206 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package handover parks the sessions the users have left on one device, so
+// they can be resumed on another one
+package handover
+
+import (
+	"crypto/rand"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrDisabled = errors.New(
+		"session handover is disabled")
+
+	ErrUnknownUser = errors.New(
+		"sessions of unknown users can't be handed over")
+
+	ErrTooManySessions = errors.New(
+		"too many sessions are waiting to be resumed")
+)
+
+// Session describes a parked session
+type Session struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Remote  string    `json:"remote"`
+	Login   string    `json:"login"`  // The user logged in to the remote
+	Client  string    `json:"client"` // The client that parked the session
+	Parked  time.Time `json:"parked"`
+	Expires time.Time `json:"expires"`
+}
+
+// Parked is the session parked by the command, which is closed when it's not
+// resumed in time
+type Parked interface {
+	Close() error
+}
+
+type entry struct {
+	user    string
+	session Session
+	parked  Parked
+	timer   *time.Timer
+}
+
+// Registry keeps the parked sessions until they're resumed by the same user,
+// or have expired
+type Registry struct {
+	lifetime time.Duration
+	maxUser  int
+	lock     sync.Mutex
+	entries  map[string]*entry
+}
+
+// New creates a new Registry, which keeps the parked sessions for the
+// `lifetime`, and at most `maxUser` of them for every user. Returns nil when
+// the `lifetime` is not positive, in which case the handover is disabled
+func New(lifetime time.Duration, maxUser int) *Registry {
+	if lifetime <= 0 {
+		return nil
+	}
+
+	return &Registry{
+		lifetime: lifetime,
+		maxUser:  maxUser,
+		lock:     sync.Mutex{},
+		entries:  map[string]*entry{},
+	}
+}
+
+// Enabled returns whether or not the handover is enabled
+func (r *Registry) Enabled() bool {
+	return r != nil
+}
+
+// count returns how many sessions of the `user` are parked
+func (r *Registry) count(user string) int {
+	n := 0
+
+	for _, e := range r.entries {
+		if e.user == user {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Park keeps the `parked` session of the `user` until it's resumed, or has
+// expired. The ID, the Parked and the Expires of the `s` are assigned by the
+// Registry
+func (r *Registry) Park(user string, s Session, parked Parked) (
+	Session, error) {
+	if r == nil {
+		return Session{}, ErrDisabled
+	}
+
+	if len(user) <= 0 {
+		return Session{}, ErrUnknownUser
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.maxUser > 0 && r.count(user) >= r.maxUser {
+		return Session{}, ErrTooManySessions
+	}
+
+	s.ID = rand.Text()
+	s.Parked = time.Now()
+	s.Expires = s.Parked.Add(r.lifetime)
+
+	e := &entry{
+		user:    user,
+		session: s,
+		parked:  parked,
+	}
+	e.timer = time.AfterFunc(r.lifetime, func() { r.expire(e) })
+
+	r.entries[s.ID] = e
+
+	return s, nil
+}
+
+// expire closes the parked session of `e` if it's still not resumed
+func (r *Registry) expire(e *entry) {
+	r.lock.Lock()
+	current, found := r.entries[e.session.ID]
+	if found && current == e {
+		delete(r.entries, e.session.ID)
+	}
+	r.lock.Unlock()
+
+	if found && current == e {
+		e.parked.Close()
+	}
+}
+
+// Sessions returns the sessions of the `user` that are waiting to be resumed,
+// ordered by the time they're parked
+func (r *Registry) Sessions(user string) []Session {
+	if r == nil || len(user) <= 0 {
+		return []Session{}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	sessions := make([]Session, 0, len(r.entries))
+
+	for _, e := range r.entries {
+		if e.user != user {
+			continue
+		}
+
+		sessions = append(sessions, e.session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Parked.Before(sessions[j].Parked)
+	})
+
+	return sessions
+}
+
+// Resume takes the session `id` of the `user` to the `remote` out of the
+// Registry. Sessions of other users are never returned
+func (r *Registry) Resume(
+	user string, id string, remote string) (Session, Parked, bool) {
+	if r == nil || len(user) <= 0 {
+		return Session{}, nil, false
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	e, found := r.entries[id]
+	if !found || e.user != user || e.session.Remote != remote {
+		return Session{}, nil, false
+	}
+
+	delete(r.entries, id)
+	e.timer.Stop()
+
+	return e.session, e.parked, true
+}
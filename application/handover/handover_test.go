@@ -0,0 +1,112 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handover
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type dummyParked struct {
+	closed chan struct{}
+}
+
+func (d dummyParked) Close() error {
+	close(d.closed)
+
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	if New(0, 0).Enabled() {
+		t.Error("Expecting the handover to be disabled without a lifetime")
+	}
+
+	r := New(time.Hour, 2)
+
+	_, err := r.Park("", Session{}, dummyParked{})
+	if !errors.Is(err, ErrUnknownUser) {
+		t.Error("Expecting sessions of unknown users to be refused, got", err)
+	}
+
+	p := dummyParked{closed: make(chan struct{})}
+
+	s, err := r.Park("alice", Session{
+		Type: "SSH", Remote: "host:22", Login: "root"}, p)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if len(s.ID) <= 0 || !s.Expires.After(s.Parked) || s.Login != "root" {
+		t.Errorf("Unexpected session: %+v", s)
+	}
+
+	if len(r.Sessions("bob")) != 0 {
+		t.Error("Expecting the sessions to be listed to their users only")
+	}
+
+	if sessions := r.Sessions("alice"); len(sessions) != 1 ||
+		sessions[0] != s {
+		t.Errorf("Expecting %+v, got %+v", s, sessions)
+	}
+
+	if _, _, ok := r.Resume("bob", s.ID, "host:22"); ok {
+		t.Error("Expecting the session to be resumed by its user only")
+	}
+
+	if _, _, ok := r.Resume("alice", s.ID, "other:22"); ok {
+		t.Error("Expecting the session to be resumed to its remote only")
+	}
+
+	r.Park("alice", Session{}, dummyParked{})
+
+	_, err = r.Park("alice", Session{}, dummyParked{})
+	if !errors.Is(err, ErrTooManySessions) {
+		t.Error("Expecting the sessions of a user to be limited, got", err)
+	}
+
+	resumed, parked, ok := r.Resume("alice", s.ID, "host:22")
+	if !ok || resumed != s || parked != p {
+		t.Errorf("Expecting %+v to be resumed, got %+v", s, resumed)
+	}
+
+	if _, _, ok := r.Resume("alice", s.ID, "host:22"); ok {
+		t.Error("Expecting the session to be resumed only once")
+	}
+}
+
+func TestRegistryExpire(t *testing.T) {
+	r := New(10*time.Millisecond, 0)
+	p := dummyParked{closed: make(chan struct{})}
+
+	s, err := r.Park("alice", Session{}, p)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	select {
+	case <-p.closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expecting the expired session to be closed")
+	}
+
+	if _, _, ok := r.Resume("alice", s.ID, ""); ok {
+		t.Error("Expecting the expired session to be removed")
+	}
+}
@@ -0,0 +1,358 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package hostkeys keeps the host keys of the remotes which have been
+// accepted by the users in a known_hosts file, so they don't have to be
+// verified again when connecting to the same remote later
+package hostkeys
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Errors
+var (
+	ErrHostNotFound = errors.New(
+		"no host key has been recorded for the host")
+)
+
+// Result of checking a host key against the Store
+type Result int
+
+// Results
+const (
+	// Unknown means no key has been recorded for the host
+	Unknown Result = iota
+
+	// Known means the key matches the one recorded for the host
+	Known
+
+	// Changed means a different key has been recorded for the host
+	Changed
+
+	// Revoked means the key is marked as "@revoked"
+	Revoked
+)
+
+// Entry is a line of the known_hosts file
+type Entry struct {
+	Marker      string   `json:"marker,omitempty"`
+	Hosts       []string `json:"hosts"`
+	Type        string   `json:"type"`
+	Fingerprint string   `json:"fingerprint"`
+}
+
+var (
+	stores     = map[string]*Store{}
+	storesLock = sync.Mutex{}
+)
+
+// Store keeps host keys in a file of the OpenSSH known_hosts format. Keys
+// are recorded by the address the remote is dialed as. The file can be
+// edited by hand as well, changes are picked up when it's modified
+type Store struct {
+	path     string
+	lock     sync.Mutex
+	modTime  time.Time
+	size     int64
+	callback ssh.HostKeyCallback
+}
+
+// Open opens the Store of the given file, which will be created when it
+// doesn't exist. Stores of the same file will be shared
+func Open(path string) (*Store, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	storesLock.Lock()
+	defer storesLock.Unlock()
+
+	if s, ok := stores[absPath]; ok {
+		return s, nil
+	}
+
+	f, err := os.OpenFile(absPath, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to open known hosts file %q: %s", absPath, err)
+	}
+	f.Close()
+
+	s := &Store{
+		path:     absPath,
+		lock:     sync.Mutex{},
+		modTime:  time.Time{},
+		size:     0,
+		callback: nil,
+	}
+
+	err = s.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	stores[absPath] = s
+
+	return s, nil
+}
+
+// reload parses the file again when it has been modified. Caller must hold
+// the lock
+func (s *Store) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf(
+			"unable to read known hosts file %q: %s", s.path, err)
+	}
+
+	if s.callback != nil &&
+		info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	callback, err := knownhosts.New(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.callback = callback
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+
+	return nil
+}
+
+// hostAddr is the net.Addr of the dialed address. The actual remote address
+// is not used, it may belong to a proxy
+type hostAddr string
+
+func (h hostAddr) Network() string {
+	return "tcp"
+}
+
+func (h hostAddr) String() string {
+	return string(h)
+}
+
+// Check checks the `key` of the remote dialed as `hostname` (in the
+// "host:port" format) against the recorded ones. It's safe to call Check on
+// a nil Store, which results Unknown
+func (s *Store) Check(hostname string, key ssh.PublicKey) (Result, error) {
+	if s == nil {
+		return Unknown, nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return Unknown, err
+	}
+
+	err = s.callback(hostname, hostAddr(hostname), key)
+	if err == nil {
+		return Known, nil
+	}
+
+	keyErr := &knownhosts.KeyError{}
+	if errors.As(err, &keyErr) {
+		if len(keyErr.Want) <= 0 {
+			return Unknown, nil
+		}
+
+		return Changed, nil
+	}
+
+	revokedErr := &knownhosts.RevokedError{}
+	if errors.As(err, &revokedErr) {
+		return Revoked, nil
+	}
+
+	return Unknown, err
+}
+
+// Add records the `key` of the remote dialed as `hostname`. It's safe to
+// call Add on a nil Store, which records nothing
+func (s *Store) Add(hostname string, key ssh.PublicKey) error {
+	if s == nil {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(
+		knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n")
+	if err != nil {
+		return err
+	}
+
+	s.callback = nil
+
+	return nil
+}
+
+// parse calls `each` for every line of the file that contains a key, with
+// the parsed Entry and the raw line. Caller must hold the lock
+func (s *Store) parse(each func(e Entry, line []byte)) error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		marker, hosts, key, _, _, err := ssh.ParseKnownHosts(line)
+		if err != nil {
+			// Comments and blank lines
+			each(Entry{}, line)
+
+			continue
+		}
+
+		each(Entry{
+			Marker:      marker,
+			Hosts:       hosts,
+			Type:        key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+		}, line)
+	}
+
+	return nil
+}
+
+// Entries returns the recorded keys, in the order of the file
+func (s *Store) Entries() ([]Entry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entries := []Entry{}
+
+	err := s.parse(func(e Entry, line []byte) {
+		if len(e.Hosts) > 0 {
+			entries = append(entries, e)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Remove removes the lines that record a key of the `hostname`, so the
+// next key of the remote will be verified by the user again. Lines of
+// hashed hosts are not matched
+func (s *Store) Remove(hostname string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	host := knownhosts.Normalize(hostname)
+
+	kept := [][]byte{}
+	removed := 0
+
+	err := s.parse(func(e Entry, line []byte) {
+		for _, h := range e.Hosts {
+			if h == host {
+				removed++
+
+				return
+			}
+		}
+
+		kept = append(kept, line)
+	})
+	if err != nil {
+		return err
+	}
+
+	if removed <= 0 {
+		return ErrHostNotFound
+	}
+
+	tmpPath := s.path + ".tmp"
+
+	err = os.WriteFile(tmpPath, bytes.Join(kept, []byte("\n")), 0600)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, s.path)
+	if err != nil {
+		return err
+	}
+
+	s.callback = nil
+
+	return nil
+}
+
+// Export returns the content of the known_hosts file
+func (s *Store) Export() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return os.ReadFile(s.path)
+}
+
+// Replace replaces the content of the known_hosts file with `data`, which
+// must be in the known_hosts format. Nothing is changed when it's not
+func (s *Store) Replace(data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tmpPath := s.path + ".tmp"
+
+	err := os.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = knownhosts.New(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+
+		return err
+	}
+
+	err = os.Rename(tmpPath, s.path)
+	if err != nil {
+		return err
+	}
+
+	s.callback = nil
+
+	return nil
+}
@@ -0,0 +1,150 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package hostkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func testKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s", err)
+	}
+
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Unable to convert key: %s", err)
+	}
+
+	return key
+}
+
+func testCheck(t *testing.T, s *Store, host string, k ssh.PublicKey) Result {
+	r, err := s.Check(host, k)
+	if err != nil {
+		t.Fatalf("Unable to check key: %s", err)
+	}
+
+	return r
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Unable to open store: %s", err)
+	}
+
+	key, otherKey := testKey(t), testKey(t)
+
+	if r := testCheck(t, s, "example.com:22", key); r != Unknown {
+		t.Errorf("Expecting Unknown, got %d", r)
+	}
+
+	err = s.Add("example.com:22", key)
+	if err != nil {
+		t.Fatalf("Unable to add key: %s", err)
+	}
+
+	if r := testCheck(t, s, "example.com:22", key); r != Known {
+		t.Errorf("Expecting Known, got %d", r)
+	}
+
+	if r := testCheck(t, s, "example.com:22", otherKey); r != Changed {
+		t.Errorf("Expecting Changed, got %d", r)
+	}
+
+	if r := testCheck(t, s, "example.com:2222", otherKey); r != Unknown {
+		t.Errorf("Expecting Unknown for another port, got %d", r)
+	}
+
+	entries, err := s.Entries()
+	if err != nil {
+		t.Fatalf("Unable to list entries: %s", err)
+	}
+
+	if len(entries) != 1 || entries[0].Hosts[0] != "example.com" ||
+		entries[0].Fingerprint != ssh.FingerprintSHA256(key) {
+		t.Errorf("Unexpected entries %v", entries)
+	}
+
+	err = s.Remove("example.com")
+	if err != nil {
+		t.Fatalf("Unable to remove host: %s", err)
+	}
+
+	if r := testCheck(t, s, "example.com:22", otherKey); r != Unknown {
+		t.Errorf("Expecting Unknown after removal, got %d", r)
+	}
+
+	if err := s.Remove("example.com"); err != ErrHostNotFound {
+		t.Errorf("Expecting ErrHostNotFound, got %v", err)
+	}
+
+	shared, err := Open(path)
+	if err != nil || shared != s {
+		t.Error("Stores of the same file must be shared")
+	}
+}
+
+func TestStoreReloadsModifiedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Unable to open store: %s", err)
+	}
+
+	key := testKey(t)
+
+	err = os.WriteFile(path, []byte("# Edited by hand\n@revoked * "+
+		string(ssh.MarshalAuthorizedKey(key))), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write file: %s", err)
+	}
+
+	// Make sure the modification is noticed even on file systems with
+	// coarse timestamps
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+
+	if r := testCheck(t, s, "example.com:22", key); r != Revoked {
+		t.Errorf("Expecting Revoked, got %d", r)
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var s *Store
+
+	if r := testCheck(t, s, "example.com:22", testKey(t)); r != Unknown {
+		t.Errorf("Expecting Unknown, got %d", r)
+	}
+
+	if err := s.Add("example.com:22", testKey(t)); err != nil {
+		t.Errorf("Expecting nil Store to record nothing, got %s", err)
+	}
+}
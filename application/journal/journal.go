@@ -0,0 +1,407 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// Lines longer than this are skipped when the journal is loaded
+	lineMaxSize = 1024 * 1024
+)
+
+// EventType is the type of the connection lifecycle event
+type EventType string
+
+// Defined EventTypes
+const (
+	CLIENT_CONNECTED    EventType = "client.connected"
+	CLIENT_DISCONNECTED EventType = "client.disconnected"
+	REMOTE_CONNECTED    EventType = "remote.connected"
+	REMOTE_FAILED       EventType = "remote.failed"
+	REMOTE_DISCONNECTED EventType = "remote.disconnected"
+
+	// REMOTE_RISK is recorded when the risk score of a remote connection
+	// has reached the alerting threshold
+	REMOTE_RISK EventType = "remote.risk"
+
+	// REMOTE_WEAK_TRANSPORT is recorded when the transport of a remote
+	// connection doesn't fit the known-good profile of the servers
+	REMOTE_WEAK_TRANSPORT EventType = "remote.weak_transport"
+
+	// REMOTE_SHARED is recorded when a remote connection is established to
+	// the account of a remote which other sessions are connected to
+	REMOTE_SHARED EventType = "remote.shared"
+
+	// REMOTE_OUTPUT carries a chunk of the output of the remote. It's only
+	// published to the audit sinks which asked for it, and never recorded
+	// into the Journal
+	REMOTE_OUTPUT EventType = "remote.output"
+)
+
+// Event is a connection lifecycle event
+type Event struct {
+	Time     time.Time     `json:"time"`
+	Type     EventType     `json:"type"`
+	Client   string        `json:"client,omitempty"`
+	Protocol string        `json:"protocol,omitempty"`
+	Remote   string        `json:"remote,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Error    string        `json:"error,omitempty"`
+
+	// User who has logged into Sshwifty, empty when the login is anonymous
+	User string `json:"user,omitempty"`
+
+	// Origin tells the client apart from the others sharing it's address
+	Origin *Origin `json:"origin,omitempty"`
+
+	// Tenant which the client has reached, empty when it's none of them
+	Tenant string `json:"tenant,omitempty"`
+
+	// Bytes sent to and received from the remote, carried by the
+	// REMOTE_DISCONNECTED events
+	BytesSent     uint64 `json:"bytes_sent,omitempty"`
+	BytesReceived uint64 `json:"bytes_received,omitempty"`
+
+	// Why the remote connection was ended, carried by the
+	// REMOTE_DISCONNECTED events
+	Reason string `json:"reason,omitempty"`
+
+	// Protocol specific details of the remote connection
+	Details map[string]string `json:"details,omitempty"`
+
+	// Output of the remote, only carried by REMOTE_OUTPUT events
+	Output []byte `json:"output,omitempty"`
+}
+
+// Origin identifies the client beyond it's address, so the clients sharing
+// an address (i.e. behind a carrier-grade NAT) can be told apart
+type Origin struct {
+	// Addresses which the request was forwarded for by the proxies, the
+	// original client first, as given by the X-Forwarded-For and Forwarded
+	// headers. They're recorded as received, and may be forged by the client
+	Forwarded []string `json:"forwarded,omitempty"`
+
+	// Source port of the original client, empty when it wasn't forwarded by
+	// the proxies
+	Port string `json:"port,omitempty"`
+
+	// JA3 and JA4 fingerprints of the TLS ClientHello, empty when the
+	// client isn't connected through TLS
+	JA3 string `json:"ja3,omitempty"`
+	JA4 string `json:"ja4,omitempty"`
+}
+
+// Policy determines which events are kept in the Journal
+type Policy struct {
+	// Events older than this will be pruned. 0 to keep them forever
+	Retention time.Duration
+
+	// Max amount of events to keep
+	MaxEvents int
+}
+
+var (
+	journals     = map[string]*Journal{}
+	journalsLock = sync.Mutex{}
+)
+
+// Journal is an append-only file that stores connection lifecycle events, so
+// they survive restarts
+type Journal struct {
+	path   string
+	policy Policy
+	lock   sync.Mutex
+	file   *os.File
+	events []Event
+	pruned int
+}
+
+// Open opens the Journal stored in the given file. Journals of the same file
+// will be shared, so multiple servers can write into the same Journal safely.
+// The `policy` of the latest Open is used, so the Journal that's opened
+// again after the configuration has been reloaded follows the new one
+func Open(path string, policy Policy) (*Journal, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	journalsLock.Lock()
+	defer journalsLock.Unlock()
+
+	if j, ok := journals[absPath]; ok {
+		return j, j.apply(policy)
+	}
+
+	j := &Journal{
+		path:   absPath,
+		policy: policy,
+		lock:   sync.Mutex{},
+		file:   nil,
+		events: []Event{},
+		pruned: 0,
+	}
+
+	err = j.load()
+	if err != nil {
+		return nil, err
+	}
+
+	j.prune(time.Now())
+
+	err = j.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	journals[absPath] = j
+
+	return j, nil
+}
+
+// apply replaces the Policy of the Journal with the `policy`, and prunes the
+// events which are no longer wanted by it
+func (j *Journal) apply(policy Policy) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.policy == policy {
+		return nil
+	}
+
+	j.policy = policy
+	j.prune(time.Now())
+
+	if j.pruned <= 0 {
+		return nil
+	}
+
+	return j.compact()
+}
+
+func (j *Journal) load() error {
+	f, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to open journal %q: %s", j.path, err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	line := []byte{}
+
+	for {
+		line, err = readLine(r, line)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("unable to read journal %q: %s", j.path, err)
+		}
+
+		e := Event{}
+
+		// Skip damaged lines (i.e. partly written before a crash)
+		if json.Unmarshal(line, &e) != nil {
+			continue
+		}
+
+		j.events = append(j.events, e)
+	}
+}
+
+// readLine reads the next line from the `r` into the `buf`. The lines which
+// are longer than lineMaxSize are read through, but returned empty, so they
+// will be skipped like the damaged ones rather than failing the load
+func readLine(r *bufio.Reader, buf []byte) ([]byte, error) {
+	buf = buf[:0]
+	oversized := false
+
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(buf)+len(chunk) > lineMaxSize {
+			oversized = true
+		}
+
+		if !oversized {
+			buf = append(buf, chunk...)
+		}
+
+		if isPrefix {
+			continue
+		}
+
+		if oversized {
+			return buf[:0], nil
+		}
+
+		return buf, nil
+	}
+}
+
+// prune removes events which are no longer wanted by the Policy from the
+// memory. Caller must hold the lock
+func (j *Journal) prune(now time.Time) {
+	drop := 0
+
+	if j.policy.MaxEvents > 0 && len(j.events) > j.policy.MaxEvents {
+		drop = len(j.events) - j.policy.MaxEvents
+	}
+
+	if j.policy.Retention > 0 {
+		expire := now.Add(-j.policy.Retention)
+
+		for drop < len(j.events) && j.events[drop].Time.Before(expire) {
+			drop++
+		}
+	}
+
+	if drop <= 0 {
+		return
+	}
+
+	j.events = append(j.events[:0:0], j.events[drop:]...)
+	j.pruned += drop
+}
+
+// compact rewrites the journal file with only the events in memory. Caller
+// must hold the lock
+func (j *Journal) compact() error {
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+
+	tmpPath := j.path + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+
+	for _, e := range j.events {
+		err = enc.Encode(e)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, j.path)
+	if err != nil {
+		return err
+	}
+
+	j.file, err = os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0600)
+	j.pruned = 0
+
+	return err
+}
+
+// Record appends the event to the Journal. It's safe to call Record on a nil
+// Journal, in which case the event is discarded
+func (j *Journal) Record(e Event) error {
+	if j == nil {
+		return nil
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.file == nil {
+		return os.ErrClosed
+	}
+
+	_, err = j.file.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+
+	j.events = append(j.events, e)
+	j.prune(e.Time)
+
+	// Don't rewrite the entire file every time an event is pruned
+	if j.pruned > 0 && j.pruned >= len(j.events) {
+		return j.compact()
+	}
+
+	return nil
+}
+
+// Events returns the latest `limit` events, oldest first. 0 to return all
+// events
+func (j *Journal) Events(limit int) []Event {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	start := 0
+	if limit > 0 && len(j.events) > limit {
+		start = len(j.events) - limit
+	}
+
+	return append([]Event{}, j.events[start:]...)
+}
+
+// Replace replaces all events of the Journal with the given `events`, which
+// must be ordered from the oldest to the latest
+func (j *Journal) Replace(events []Event) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.events = append([]Event{}, events...)
+	j.prune(time.Now())
+
+	return j.compact()
+}
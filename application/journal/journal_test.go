@@ -0,0 +1,174 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	policy := Policy{Retention: time.Hour, MaxEvents: 3}
+
+	j, err := Open(path, policy)
+	if err != nil {
+		t.Error("Failed to open journal:", err)
+		return
+	}
+
+	now := time.Now()
+
+	j.Record(Event{Time: now.Add(-2 * time.Hour), Type: CLIENT_CONNECTED})
+
+	for i := 0; i < 4; i++ {
+		err = j.Record(Event{Time: now, Type: REMOTE_CONNECTED})
+		if err != nil {
+			t.Error("Failed to record event:", err)
+			return
+		}
+	}
+
+	if events := j.Events(0); len(events) != 3 {
+		t.Errorf("Expecting 3 events, got %d", len(events))
+		return
+	}
+
+	if events := j.Events(2); len(events) != 2 {
+		t.Errorf("Expecting 2 events, got %d", len(events))
+		return
+	}
+
+	// Reload from the file
+	reloaded := &Journal{path: j.path, policy: policy}
+
+	err = reloaded.load()
+	if err != nil {
+		t.Error("Failed to reload journal:", err)
+		return
+	}
+
+	reloaded.prune(now)
+
+	if len(reloaded.events) != 3 {
+		t.Errorf("Expecting 3 events after reload, got %d",
+			len(reloaded.events))
+		return
+	}
+
+	for _, e := range reloaded.events {
+		if e.Type != REMOTE_CONNECTED {
+			t.Errorf("Unexpected event after reload: %+v", e)
+			return
+		}
+	}
+
+	var nilJournal *Journal
+	if nilJournal.Record(Event{}) != nil {
+		t.Error("Expecting nil Journal to discard events")
+		return
+	}
+}
+
+func TestJournalLongLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	longError := strings.Repeat("e", 128*1024)
+
+	lines := []string{
+		`{"type":"client.connected"}`,
+		`{"type":"remote.failed","error":"` + longError + `"}`,
+		`{"type":"remote.failed","error":"` +
+			strings.Repeat("x", 2*lineMaxSize) + `"}`,
+		`{"type":"client.disconnected"}`,
+	}
+
+	err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := Open(path, Policy{})
+	if err != nil {
+		t.Fatal("Failed to open journal with long lines:", err)
+	}
+
+	events := j.Events(0)
+	if len(events) != 3 {
+		t.Fatalf("Expecting 3 events, got %d", len(events))
+	}
+
+	if events[1].Error != longError {
+		t.Error("Expecting the long line to be loaded")
+	}
+
+	if events[2].Type != CLIENT_DISCONNECTED {
+		t.Errorf("Expecting the oversized line to be skipped, got %+v",
+			events[2])
+	}
+}
+
+func TestJournalReopenPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	j, err := Open(path, Policy{MaxEvents: 3})
+	if err != nil {
+		t.Fatal("Failed to open journal:", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := j.Record(Event{Type: REMOTE_CONNECTED}); err != nil {
+			t.Fatal("Failed to record event:", err)
+		}
+	}
+
+	reopened, err := Open(path, Policy{MaxEvents: 1})
+	if err != nil {
+		t.Fatal("Failed to reopen journal:", err)
+	}
+
+	if reopened != j {
+		t.Fatal("Expecting the journal to be shared")
+	}
+
+	if events := j.Events(0); len(events) != 1 {
+		t.Errorf("Expecting 1 event under the new policy, got %d",
+			len(events))
+	}
+
+	// The pruned events are removed from the file as well
+	reloaded := &Journal{path: j.path}
+
+	if err := reloaded.load(); err != nil {
+		t.Fatal("Failed to reload journal:", err)
+	}
+
+	if len(reloaded.events) != 1 {
+		t.Errorf("Expecting 1 event in the file, got %d",
+			len(reloaded.events))
+	}
+
+	j.Record(Event{Type: REMOTE_CONNECTED})
+
+	if events := j.Events(0); len(events) != 1 {
+		t.Errorf("Expecting the new policy to be kept, got %d events",
+			len(events))
+	}
+}
@@ -0,0 +1,323 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package keyvault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Errors
+var (
+	ErrInvalidPublicKey = errors.New(
+		"invalid public key, expecting a key in the authorized_keys format")
+
+	ErrKeyAlreadyExists = errors.New(
+		"the public key already exists")
+
+	ErrKeyNotFound = errors.New(
+		"the public key was not found")
+
+	ErrKeyNotOwned = errors.New(
+		"the public key belongs to another user")
+)
+
+const (
+	keyCommentMaxLen = 256
+	keyOwnerMaxLen   = 128
+)
+
+var (
+	vaults     = map[string]*Vault{}
+	vaultsLock = sync.Mutex{}
+)
+
+// Key contains a public key and it's metadata
+type Key struct {
+	Fingerprint string    `json:"fingerprint"`
+	Type        string    `json:"type"`
+	Key         string    `json:"key"`
+	Owner       string    `json:"owner"`
+	Comment     string    `json:"comment"`
+	Added       time.Time `json:"added"`
+}
+
+// Vault stores public keys in a JSON file. Only public keys will be accepted,
+// private keys are never stored
+type Vault struct {
+	path string
+	lock sync.RWMutex
+	keys map[string]Key
+}
+
+// Open opens the Vault stored in the given file. Vaults of the same file will
+// be shared, so multiple servers can access the same Vault safely
+func Open(path string) (*Vault, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	vaultsLock.Lock()
+	defer vaultsLock.Unlock()
+
+	if v, ok := vaults[absPath]; ok {
+		return v, nil
+	}
+
+	v := &Vault{
+		path: absPath,
+		lock: sync.RWMutex{},
+		keys: map[string]Key{},
+	}
+
+	err = v.load()
+	if err != nil {
+		return nil, err
+	}
+
+	vaults[absPath] = v
+
+	return v, nil
+}
+
+func (v *Vault) load() error {
+	data, err := os.ReadFile(v.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to read key vault %q: %s", v.path, err)
+	}
+
+	keys := []Key{}
+
+	err = json.Unmarshal(data, &keys)
+	if err != nil {
+		return fmt.Errorf("unable to parse key vault %q: %s", v.path, err)
+	}
+
+	for _, k := range keys {
+		v.keys[k.Fingerprint] = k
+	}
+
+	return nil
+}
+
+// save writes all keys into the vault file. Caller must hold the write lock
+func (v *Vault) save() error {
+	data, err := json.MarshalIndent(v.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := v.path + ".tmp"
+
+	err = os.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, v.path)
+}
+
+func (v *Vault) list() []Key {
+	keys := make([]Key, 0, len(v.keys))
+
+	for _, k := range v.keys {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Added.Equal(keys[j].Added) {
+			return keys[i].Fingerprint < keys[j].Fingerprint
+		}
+
+		return keys[i].Added.Before(keys[j].Added)
+	})
+
+	return keys
+}
+
+// List returns all stored keys, ordered by the time they're added
+func (v *Vault) List() []Key {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	return v.list()
+}
+
+// ownerName returns the `owner` in the length it's stored
+func ownerName(owner string) string {
+	if len(owner) > keyOwnerMaxLen {
+		return owner[:keyOwnerMaxLen]
+	}
+
+	return owner
+}
+
+// Owned returns the keys owned by the `owner`, ordered by the time they're
+// added
+func (v *Vault) Owned(owner string) []Key {
+	owner = ownerName(owner)
+
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	keys := []Key{}
+
+	for _, k := range v.list() {
+		if k.Owner == owner {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// Add parses and stores the given public key. `authorizedKey` must be a
+// single public key in the authorized_keys format. When `comment` is empty,
+// the comment of the key will be used instead
+func (v *Vault) Add(authorizedKey []byte, owner, comment string) (Key, error) {
+	pubKey, keyComment, _, rest, err := ssh.ParseAuthorizedKey(authorizedKey)
+	if err != nil {
+		return Key{}, ErrInvalidPublicKey
+	}
+
+	if len(strings.TrimSpace(string(rest))) > 0 {
+		return Key{}, ErrInvalidPublicKey
+	}
+
+	if len(comment) <= 0 {
+		comment = keyComment
+	}
+
+	if len(comment) > keyCommentMaxLen {
+		comment = comment[:keyCommentMaxLen]
+	}
+
+	owner = ownerName(owner)
+
+	k := Key{
+		Fingerprint: ssh.FingerprintSHA256(pubKey),
+		Type:        pubKey.Type(),
+		Key: strings.TrimSpace(
+			string(ssh.MarshalAuthorizedKey(pubKey))),
+		Owner:   owner,
+		Comment: comment,
+		Added:   time.Now().UTC(),
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if _, ok := v.keys[k.Fingerprint]; ok {
+		return Key{}, ErrKeyAlreadyExists
+	}
+
+	v.keys[k.Fingerprint] = k
+
+	err = v.save()
+	if err != nil {
+		delete(v.keys, k.Fingerprint)
+
+		return Key{}, err
+	}
+
+	return k, nil
+}
+
+// Remove removes the key of the given fingerprint from the Vault
+func (v *Vault) Remove(fingerprint string) error {
+	return v.remove(fingerprint, func(k Key) error {
+		return nil
+	})
+}
+
+// RemoveOwned removes the key of the given fingerprint from the Vault, only
+// when it's owned by the `owner`
+func (v *Vault) RemoveOwned(fingerprint, owner string) error {
+	owner = ownerName(owner)
+
+	return v.remove(fingerprint, func(k Key) error {
+		if k.Owner != owner {
+			return ErrKeyNotOwned
+		}
+
+		return nil
+	})
+}
+
+// remove removes the key of the given fingerprint once it's allowed by the
+// `check`
+func (v *Vault) remove(fingerprint string, check func(k Key) error) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	k, ok := v.keys[fingerprint]
+	if !ok {
+		return ErrKeyNotFound
+	}
+
+	if err := check(k); err != nil {
+		return err
+	}
+
+	delete(v.keys, fingerprint)
+
+	err := v.save()
+	if err != nil {
+		v.keys[fingerprint] = k
+
+		return err
+	}
+
+	return nil
+}
+
+// Replace replaces all keys in the Vault with the given `keys`
+func (v *Vault) Replace(keys []Key) error {
+	replaced := make(map[string]Key, len(keys))
+
+	for _, k := range keys {
+		replaced[k.Fingerprint] = k
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	current := v.keys
+	v.keys = replaced
+
+	err := v.save()
+	if err != nil {
+		v.keys = current
+
+		return err
+	}
+
+	return nil
+}
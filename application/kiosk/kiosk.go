@@ -0,0 +1,130 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kiosk
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nirui/sshwifty/application/deeplink"
+)
+
+// Errors
+var (
+	ErrMalformed = errors.New(
+		"malformed kiosk token")
+
+	ErrRefused = errors.New(
+		"kiosk token is refused")
+)
+
+// Session is the login session of a kiosk, which is an unattended display
+// (i.e. a NOC wall display) that watches the console of a Preset in the
+// read-only mode
+type Session struct {
+	Preset  string
+	Expires time.Time
+	Key     string // Replaces the SharedKey for the kiosk
+	token   string
+}
+
+// Cookie returns the value of the cookie that resumes the Session
+func (s Session) Cookie() string {
+	return strconv.FormatInt(s.Expires.Unix(), 10) + "." + s.token + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(s.Preset))
+}
+
+// Kiosk verifies the tokens of the kiosks. The tokens are signed in the same
+// way as the deep links (see deeplink.Signer), but with a different key, so
+// the two cannot be used in place of each other
+type Kiosk struct {
+	key       []byte
+	signer    deeplink.Signer
+	keepalive time.Duration
+}
+
+// New creates a new Kiosk, or nil when the `key` is empty. The kiosks are
+// expected to call back every `keepalive`
+func New(key string, keepalive time.Duration) *Kiosk {
+	if len(key) <= 0 {
+		return nil
+	}
+
+	return &Kiosk{
+		key:       []byte(key),
+		signer:    deeplink.New(key),
+		keepalive: keepalive,
+	}
+}
+
+// Enabled returns whether or not the kiosks are enabled
+func (k *Kiosk) Enabled() bool {
+	return k != nil
+}
+
+// Keepalive returns the interval in which the kiosks are expected to call
+// back
+func (k *Kiosk) Keepalive() time.Duration {
+	return k.keepalive
+}
+
+// Login verifies the `token` of the kiosk that watches the `preset` until
+// `expires` (Unix seconds), and returns its Session
+func (k *Kiosk) Login(preset, expires, token string) (Session, error) {
+	err := k.signer.Verify(preset, expires, token)
+	if errors.Is(err, deeplink.ErrMalformed) {
+		return Session{}, ErrMalformed
+	} else if err != nil {
+		return Session{}, fmt.Errorf("%w: %s", ErrRefused, err)
+	}
+
+	exp, _ := strconv.ParseInt(expires, 10, 64)
+
+	mac := hmac.New(sha256.New, k.key)
+	mac.Write([]byte("kiosk session\n"))
+	mac.Write([]byte(token))
+
+	return Session{
+		Preset:  preset,
+		Expires: time.Unix(exp, 0),
+		Key:     base64.RawURLEncoding.EncodeToString(mac.Sum(nil)),
+		token:   token,
+	}, nil
+}
+
+// Resume verifies the `cookie` given by Session.Cookie, and returns the
+// Session again
+func (k *Kiosk) Resume(cookie string) (Session, error) {
+	parts := strings.SplitN(cookie, ".", 3)
+	if len(parts) != 3 {
+		return Session{}, ErrMalformed
+	}
+
+	preset, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Session{}, ErrMalformed
+	}
+
+	return k.Login(string(preset), parts[0], parts[1])
+}
@@ -0,0 +1,87 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package kiosk
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/deeplink"
+)
+
+func TestKiosk(t *testing.T) {
+	if New("", time.Minute).Enabled() {
+		t.Error("Expecting kiosks without a key to be disabled")
+	}
+
+	k := New("secret", time.Minute)
+
+	expires := time.Now().Add(time.Hour)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	token := deeplink.New("secret").Sign("Wall", expires)
+
+	s, err := k.Login("Wall", exp, token)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if s.Preset != "Wall" || s.Expires.Unix() != expires.Unix() {
+		t.Errorf("Unexpected session: %+v", s)
+	}
+
+	if len(s.Key) <= 0 || s.Key == token {
+		t.Errorf("Expecting the session key to be derived, got %q", s.Key)
+	}
+
+	resumed, err := k.Resume(s.Cookie())
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if resumed != s {
+		t.Errorf("Expecting %+v, got %+v", s, resumed)
+	}
+
+	_, err = k.Login("Other", exp, token)
+	if !errors.Is(err, ErrRefused) {
+		t.Error("Expecting the token to be bound to the Preset, got", err)
+	}
+
+	_, err = New("other", time.Minute).Login("Wall", exp, token)
+	if !errors.Is(err, ErrRefused) {
+		t.Error("Expecting the token to be bound to the key, got", err)
+	}
+
+	expired := time.Now().Add(-time.Hour)
+	_, err = k.Login(
+		"Wall",
+		strconv.FormatInt(expired.Unix(), 10),
+		deeplink.New("secret").Sign("Wall", expired),
+	)
+	if !errors.Is(err, ErrRefused) {
+		t.Error("Expecting expired tokens to be refused, got", err)
+	}
+
+	for _, c := range []string{"", "1.2", "1.2.!!!", "soon.x.V2FsbA"} {
+		if _, err := k.Resume(c); !errors.Is(err, ErrMalformed) {
+			t.Errorf("Expecting ErrMalformed for %q, got %v", c, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package log
+
+import (
+	"errors"
+	"strings"
+)
+
+// Format is the format of the log lines written by the Writer
+type Format string
+
+// Formats
+const (
+	// FormatText writes the logs as human readable lines
+	FormatText Format = "text"
+
+	// FormatJSON writes every log as a JSON object in its own line, for the
+	// log collectors
+	FormatJSON Format = "json"
+)
+
+// Errors
+var (
+	ErrUnknownFormat = errors.New(
+		"unknown log format, expecting \"text\" or \"json\"")
+)
+
+// ParseFormat parses the name of the Format. Empty name results FormatText,
+// which is also returned along with the error when the name is unknown
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(name)) {
+	case "", FormatText:
+		return FormatText, nil
+
+	case FormatJSON:
+		return FormatJSON, nil
+
+	default:
+		return FormatText, ErrUnknownFormat
+	}
+}
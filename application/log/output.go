@@ -0,0 +1,40 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package log
+
+import (
+	"errors"
+	"io"
+)
+
+// Errors
+var (
+	ErrUnsupportedOutput = errors.New(
+		"log output is not supported on this platform")
+)
+
+// LevelWriter is an underlaying writer of the Writer which records the level
+// and the time of the logs by itself, such as the syslog. The Writer only
+// sends the message of the logs to it, without the newline
+type LevelWriter interface {
+	io.Writer
+
+	// WriteLevel writes the log `b` of the `level`, which is one of "debug",
+	// "info", "warning", "error" and "default"
+	WriteLevel(level string, b []byte) (int, error)
+}
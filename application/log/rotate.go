@@ -0,0 +1,210 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedFileTimeLayout is the layout of the time in the name of the rotated
+// files, which sorts in the same order as the time
+const rotatedFileTimeLayout = "20060102-150405.000000000"
+
+// Rotation settings of the RotatingFile
+type Rotation struct {
+	// The file is rotated before it grows larger than this many bytes. 0
+	// to never rotate it
+	MaxSize int64
+
+	// Rotated files older than this are removed. 0 to keep them regardless
+	// of their age
+	MaxAge time.Duration
+
+	// Max amount of the rotated files to keep. 0 to keep all of them
+	MaxBackups int
+}
+
+// RotatingFile writes the logs into a file, which is renamed with the time
+// of the rotation appended to its name (i.e. "sshwifty.log" to
+// "sshwifty.log.20250102-030405.000000000") once it has grown too large
+type RotatingFile struct {
+	path string
+	r    Rotation
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingFile opens the file at the `path` for appending the logs
+func NewRotatingFile(path string, r Rotation) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path: path,
+		r:    r,
+		lock: sync.Mutex{},
+		file: nil,
+		size: 0,
+	}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(
+		f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+
+	return nil
+}
+
+// Write implements io.Writer
+func (f *RotatingFile) Write(b []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.r.MaxSize > 0 && f.size > 0 && f.size+int64(len(b)) > f.r.MaxSize {
+		err := f.rotate(time.Now())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	wLen, wErr := f.file.Write(b)
+	f.size += int64(wLen)
+
+	return wLen, wErr
+}
+
+func (f *RotatingFile) rotate(now time.Time) error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(f.path, f.path+"."+now.Format(rotatedFileTimeLayout))
+	if err != nil {
+		return err
+	}
+
+	err = f.open()
+	if err != nil {
+		return err
+	}
+
+	f.removeBackups(now)
+
+	return nil
+}
+
+// removeBackups removes the rotated files that are too old, or beyond the
+// MaxBackups
+func (f *RotatingFile) removeBackups(now time.Time) {
+	if f.r.MaxAge <= 0 && f.r.MaxBackups <= 0 {
+		return
+	}
+
+	dir, name := filepath.Split(f.path)
+	if len(dir) <= 0 {
+		dir = "."
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	type backup struct {
+		name    string
+		rotated time.Time
+	}
+
+	backups := make([]backup, 0, len(entries))
+
+	for _, e := range entries {
+		suffix, found := strings.CutPrefix(e.Name(), name+".")
+		if !found || e.IsDir() {
+			continue
+		}
+
+		rotated, err := time.ParseInLocation(
+			rotatedFileTimeLayout, suffix, now.Location())
+		if err != nil {
+			continue
+		}
+
+		backups = append(backups, backup{
+			name:    filepath.Join(dir, e.Name()),
+			rotated: rotated,
+		})
+	}
+
+	// Newest first
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].rotated.After(backups[j].rotated)
+	})
+
+	for i, b := range backups {
+		tooMany := f.r.MaxBackups > 0 && i >= f.r.MaxBackups
+		tooOld := f.r.MaxAge > 0 && now.Sub(b.rotated) > f.r.MaxAge
+
+		if tooMany || tooOld {
+			os.Remove(b.name)
+		}
+	}
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	return err
+}
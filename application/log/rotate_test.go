@@ -0,0 +1,101 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sshwifty.log")
+
+	f, err := NewRotatingFile(path, Rotation{
+		MaxSize:    10,
+		MaxAge:     0,
+		MaxBackups: 2,
+	})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if string(current) != "fourth\n" {
+		t.Errorf("Expecting %q, got %q", "fourth\n", current)
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Fatalf("Expecting 2 rotated files, got %q", backups)
+	}
+
+	// The oldest one has been removed
+	for _, b := range backups {
+		data, _ := os.ReadFile(b)
+
+		if strings.Contains(string(data), "first") {
+			t.Errorf("Expecting %q to be removed", b)
+		}
+	}
+}
+
+func TestRotatingFileMaxAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sshwifty.log")
+
+	old := path + "." + time.Now().Add(-48*time.Hour).Format(
+		rotatedFileTimeLayout)
+	if err := os.WriteFile(old, []byte("old\n"), 0640); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	f, err := NewRotatingFile(path, Rotation{
+		MaxSize:    4,
+		MaxAge:     24 * time.Hour,
+		MaxBackups: 0,
+	})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer f.Close()
+
+	f.Write([]byte("new\n"))
+	f.Write([]byte("newer\n"))
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Expecting %q to be removed, got %v", old, err)
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 1 {
+		t.Errorf("Expecting 1 rotated file, got %q", backups)
+	}
+}
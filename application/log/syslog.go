@@ -0,0 +1,74 @@
+//go:build !(windows || plan9)
+
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package log
+
+import (
+	"log/syslog"
+	"strings"
+)
+
+// Syslog writes the logs to a syslog server
+type Syslog struct {
+	w *syslog.Writer
+}
+
+// NewSyslog creates a new Syslog. Empty `network` and `address` sends the
+// logs to the local syslog server
+func NewSyslog(network, address, tag string) (LevelWriter, error) {
+	w, err := syslog.Dial(
+		network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return Syslog{w: w}, nil
+}
+
+// Write implements io.Writer
+func (s Syslog) Write(b []byte) (int, error) {
+	return s.WriteLevel("default", b)
+}
+
+// WriteLevel implements LevelWriter
+func (s Syslog) WriteLevel(level string, b []byte) (int, error) {
+	m := strings.TrimRight(string(b), "\r\n")
+
+	var err error
+
+	switch level {
+	case "debug":
+		err = s.w.Debug(m)
+
+	case "warning":
+		err = s.w.Warning(m)
+
+	case "error":
+		err = s.w.Err(m)
+
+	default:
+		err = s.w.Info(m)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
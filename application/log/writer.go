@@ -18,33 +18,56 @@
 package log
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 )
 
 // Writer will write logs to the underlaying writer
 type Writer struct {
 	c string
+	f Format
 	w io.Writer
 }
 
+// jsonLine is a log written in the FormatJSON
+type jsonLine struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Component string `json:"component"`
+	Context   string `json:"context,omitempty"`
+	Message   string `json:"message"`
+}
+
 // NewWriter creates a new Writer
 func NewWriter(context string, w io.Writer) Writer {
+	return NewFormattedWriter(context, FormatText, w)
+}
+
+// NewFormattedWriter creates a new Writer which writes logs in the Format `f`
+func NewFormattedWriter(context string, f Format, w io.Writer) Writer {
 	return Writer{
 		c: context,
+		f: f,
 		w: w,
 	}
 }
 
+func (w Writer) sub(name string, params ...interface{}) Writer {
+	return NewFormattedWriter(
+		w.c+" > "+fmt.Sprintf(name, params...), w.f, w.w)
+}
+
 // Context build a new Sub context
 func (w Writer) Context(name string, params ...interface{}) Logger {
-	return NewWriter(w.c+" > "+fmt.Sprintf(name, params...), w.w)
+	return w.sub(name, params...)
 }
 
 // Write writes default error
 func (w Writer) Write(b []byte) (int, error) {
-	_, wErr := w.write("DEF", string(b))
+	_, wErr := w.write("DEF", "default", "%s", string(b))
 
 	if wErr != nil {
 		return 0, wErr
@@ -54,27 +77,59 @@ func (w Writer) Write(b []byte) (int, error) {
 }
 
 func (w Writer) write(
-	prefix string, msg string, params ...interface{}) (int, error) {
-	return fmt.Fprintf(w.w, "["+prefix+"] "+
-		time.Now().Format(time.RFC1123)+" "+w.c+": "+msg+"\r\n", params...)
+	prefix string, level string, msg string, params ...interface{},
+) (int, error) {
+	lw, leveled := w.w.(LevelWriter)
+
+	if w.f != FormatJSON {
+		if leveled {
+			return lw.WriteLevel(level,
+				[]byte(fmt.Sprintf(w.c+": "+msg, params...)))
+		}
+
+		return fmt.Fprintf(w.w, "["+prefix+"] "+
+			time.Now().Format(time.RFC1123)+" "+w.c+": "+msg+"\r\n",
+			params...)
+	}
+
+	// The first context is the component, the sub contexts are built by it
+	component, context, _ := strings.Cut(w.c, " > ")
+
+	b, err := json.Marshal(jsonLine{
+		Time:      time.Now().Format(time.RFC3339Nano),
+		Level:     level,
+		Component: component,
+		Context:   context,
+		Message: strings.TrimRight(
+			fmt.Sprintf(msg, params...), "\r\n"),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if leveled {
+		return lw.WriteLevel(level, b)
+	}
+
+	return w.w.Write(append(b, '\n'))
 }
 
 // Info write an info message
 func (w Writer) Info(msg string, params ...interface{}) {
-	w.write("INF", msg, params...)
+	w.write("INF", "info", msg, params...)
 }
 
 // Debug write an debug message
 func (w Writer) Debug(msg string, params ...interface{}) {
-	w.write("DBG", msg, params...)
+	w.write("DBG", "debug", msg, params...)
 }
 
 // Warning write an warning message
 func (w Writer) Warning(msg string, params ...interface{}) {
-	w.write("WRN", msg, params...)
+	w.write("WRN", "warning", msg, params...)
 }
 
 // Error write an error message
 func (w Writer) Error(msg string, params ...interface{}) {
-	w.write("ERR", msg, params...)
+	w.write("ERR", "error", msg, params...)
 }
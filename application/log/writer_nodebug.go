@@ -18,7 +18,6 @@
 package log
 
 import (
-	"fmt"
 	"io"
 )
 
@@ -35,19 +34,23 @@ func NewNonDebugWriter(context string, w io.Writer) NonDebugWriter {
 }
 
 // NewDebugOrNonDebugWriter creates debug or nondebug log depends on
-// given `useDebug`
+// given `useDebug`, which writes logs in the Format `f`
 func NewDebugOrNonDebugWriter(
-	useDebug bool, context string, w io.Writer) Logger {
+	useDebug bool, f Format, context string, w io.Writer) Logger {
 	if useDebug {
-		return NewWriter(context, w)
+		return NewFormattedWriter(context, f, w)
 	}
 
-	return NewNonDebugWriter(context, w)
+	return NonDebugWriter{
+		Writer: NewFormattedWriter(context, f, w),
+	}
 }
 
 // Context build a new Sub context
 func (w NonDebugWriter) Context(name string, params ...interface{}) Logger {
-	return NewNonDebugWriter(w.c+" > "+fmt.Sprintf(name, params...), w.w)
+	return NonDebugWriter{
+		Writer: w.sub(name, params...),
+	}
 }
 
 // Debug ditchs debug operation
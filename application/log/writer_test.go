@@ -0,0 +1,117 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONWriter(t *testing.T) {
+	b := bytes.Buffer{}
+	l := NewDebugOrNonDebugWriter(false, FormatJSON, "Sshwifty", &b)
+
+	l.Context("Server (%s)", "127.0.0.1:8182").Context("Socket").Warning(
+		"Unable to %s", "read")
+	l.Debug("Ditched")
+	l.Write([]byte("100% done\n"))
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expecting 2 lines, got %q", lines)
+	}
+
+	expected := []jsonLine{
+		{
+			Level:     "warning",
+			Component: "Sshwifty",
+			Context:   "Server (127.0.0.1:8182) > Socket",
+			Message:   "Unable to read",
+		},
+		{
+			Level:     "default",
+			Component: "Sshwifty",
+			Message:   "100% done",
+		},
+	}
+
+	for i, line := range lines {
+		got := jsonLine{}
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		if len(got.Time) <= 0 {
+			t.Errorf("Expecting the time of %q", line)
+		}
+		got.Time = ""
+
+		if got != expected[i] {
+			t.Errorf("Expecting %+v, got %+v", expected[i], got)
+		}
+	}
+}
+
+type testLevelWriter []string
+
+func (w *testLevelWriter) Write(b []byte) (int, error) {
+	return w.WriteLevel("default", b)
+}
+
+func (w *testLevelWriter) WriteLevel(level string, b []byte) (int, error) {
+	*w = append(*w, level+" "+string(b))
+
+	return len(b), nil
+}
+
+func TestLevelWriter(t *testing.T) {
+	w := testLevelWriter{}
+	l := NewDebugOrNonDebugWriter(true, FormatText, "Sshwifty", &w)
+
+	l.Context("Socket").Error("Unable to %s", "read")
+	l.Debug("Debugging")
+
+	expected := []string{
+		"error Sshwifty > Socket: Unable to read",
+		"debug Sshwifty: Debugging",
+	}
+
+	if strings.Join(w, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expecting %q, got %q", expected, w)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, expected := range map[string]Format{
+		"":     FormatText,
+		"text": FormatText,
+		"JSON": FormatJSON,
+	} {
+		if f, err := ParseFormat(name); err != nil || f != expected {
+			t.Errorf("Expecting %q for %q, got %q (%v)",
+				expected, name, f, err)
+		}
+	}
+
+	f, err := ParseFormat("xml")
+	if err != ErrUnknownFormat || f != FormatText {
+		t.Errorf("Expecting ErrUnknownFormat, got %q (%v)", f, err)
+	}
+}
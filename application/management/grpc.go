@@ -0,0 +1,276 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package management
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Code is the status code of a gRPC call
+type Code uint32
+
+// Codes used by the management API, as defined by gRPC
+const (
+	CodeOK                 Code = 0
+	CodeCanceled           Code = 1
+	CodeUnknown            Code = 2
+	CodeInvalidArgument    Code = 3
+	CodeNotFound           Code = 5
+	CodeAlreadyExists      Code = 6
+	CodeResourceExhausted  Code = 8
+	CodeFailedPrecondition Code = 9
+	CodeUnimplemented      Code = 12
+	CodeInternal           Code = 13
+	CodeUnauthenticated    Code = 16
+)
+
+// Status is an error that results the given gRPC status
+type Status struct {
+	Code    Code
+	Message string
+}
+
+// Error returns the error message
+func (s Status) Error() string {
+	return fmt.Sprintf("gRPC status %d: %s", s.Code, s.Message)
+}
+
+// statusOf converts the `err` to the Status sent to the client
+func statusOf(err error) Status {
+	if err == nil {
+		return Status{Code: CodeOK}
+	}
+
+	s := Status{}
+	if errors.As(err, &s) {
+		return s
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return Status{Code: CodeCanceled, Message: err.Error()}
+	}
+
+	return Status{Code: CodeUnknown, Message: err.Error()}
+}
+
+const (
+	grpcContentType    = "application/grpc"
+	grpcMaxRequestSize = 4 * 1024 * 1024
+	grpcFrameHeadSize  = 5
+)
+
+// IsRequest returns whether or not `r` is a gRPC request
+func IsRequest(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+
+	ct := r.Header.Get("Content-Type")
+
+	return ct == grpcContentType ||
+		strings.HasPrefix(ct, grpcContentType+"+") ||
+		strings.HasPrefix(ct, grpcContentType+";")
+}
+
+// unaryMethod handles a call that results a single reply
+type unaryMethod func(ctx context.Context, req []byte) ([]byte, error)
+
+// streamMethod handles a call that results a stream of replies, which are
+// sent through `send`
+type streamMethod func(
+	ctx context.Context, req []byte, send func([]byte) error) error
+
+type method struct {
+	unary  unaryMethod
+	stream streamMethod
+}
+
+// Server serves the methods of a gRPC service. Only unary and server
+// streaming calls of uncompressed messages are supported, which is all the
+// management API needs
+type Server struct {
+	token   []byte
+	methods map[string]method
+}
+
+// encodeStatusMessage percent-encodes the `msg` for the grpc-message
+// trailer
+func encodeStatusMessage(msg string) string {
+	b := strings.Builder{}
+
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+
+			continue
+		}
+
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+// authorize checks the "authorization" metadata of the call
+func (s *Server) authorize(r *http.Request) error {
+	auth := r.Header.Get("Authorization")
+
+	const prefix = "Bearer "
+
+	if len(auth) < len(prefix) ||
+		!strings.EqualFold(auth[:len(prefix)], prefix) ||
+		subtle.ConstantTimeCompare(
+			[]byte(auth[len(prefix):]), s.token) != 1 {
+		return Status{
+			Code:    CodeUnauthenticated,
+			Message: "invalid management token",
+		}
+	}
+
+	return nil
+}
+
+// readRequest reads the only request message of the call
+func (s *Server) readRequest(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(
+		io.LimitReader(r.Body, grpcMaxRequestSize+grpcFrameHeadSize+1))
+	if err != nil {
+		return nil, Status{Code: CodeCanceled, Message: err.Error()}
+	}
+
+	if len(body) > grpcMaxRequestSize+grpcFrameHeadSize {
+		return nil, Status{
+			Code:    CodeResourceExhausted,
+			Message: "request message is too large",
+		}
+	}
+
+	if len(body) < grpcFrameHeadSize ||
+		int(binary.BigEndian.Uint32(body[1:5])) !=
+			len(body)-grpcFrameHeadSize {
+		return nil, Status{
+			Code:    CodeInternal,
+			Message: "the call must carry exactly one request message",
+		}
+	}
+
+	if body[0] != 0 {
+		return nil, Status{
+			Code:    CodeUnimplemented,
+			Message: "compressed messages are not supported",
+		}
+	}
+
+	return body[grpcFrameHeadSize:], nil
+}
+
+// serve handles the call, returns the error that ends it
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) error {
+	err := s.authorize(r)
+	if err != nil {
+		return err
+	}
+
+	m, ok := s.methods[r.URL.Path]
+	if !ok {
+		return Status{
+			Code:    CodeUnimplemented,
+			Message: "unknown method " + r.URL.Path,
+		}
+	}
+
+	req, err := s.readRequest(r)
+	if err != nil {
+		return err
+	}
+
+	rc := http.NewResponseController(w)
+
+	send := func(reply []byte) error {
+		head := [grpcFrameHeadSize]byte{}
+		binary.BigEndian.PutUint32(head[1:], uint32(len(reply)))
+
+		if _, err := w.Write(head[:]); err != nil {
+			return err
+		}
+
+		if _, err := w.Write(reply); err != nil {
+			return err
+		}
+
+		return rc.Flush()
+	}
+
+	if m.unary != nil {
+		reply, err := m.unary(r.Context(), req)
+		if err != nil {
+			return err
+		}
+
+		return send(reply)
+	}
+
+	// Streams last as long as the client wants, they must not be cut by
+	// the timeouts of the server
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	// Let the client know the stream has started before the first reply,
+	// which may take a long time
+	if err := rc.Flush(); err != nil {
+		return err
+	}
+
+	return m.stream(r.Context(), req, send)
+}
+
+// Serve serves the gRPC call. The error that ended the call is returned
+// after it has been sent to the client as the status
+func (s *Server) Serve(w http.ResponseWriter, r *http.Request) error {
+	hd := w.Header()
+	hd.Set("Content-Type", grpcContentType)
+	hd.Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	err := s.serve(w, r)
+	st := statusOf(err)
+
+	hd.Set("Grpc-Status", strconv.FormatUint(uint64(st.Code), 10))
+
+	if len(st.Message) > 0 {
+		hd.Set("Grpc-Message", encodeStatusMessage(st.Message))
+	}
+
+	return err
+}
+
+// ServeHTTP serves the gRPC call
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Serve(w, r)
+}
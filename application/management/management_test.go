@@ -0,0 +1,366 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package management
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/audit"
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/journal"
+	"github.com/nirui/sshwifty/application/keyvault"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+const testToken = "management-token"
+
+type testCall struct {
+	replies [][]byte
+	status  string
+	message string
+}
+
+func startTestServer(t *testing.T, src Sources) *httptest.Server {
+	src.Hooks = command.NewHooks(configuration.HookSettings{}, log.NewDitch())
+
+	ts := httptest.NewUnstartedServer(New(testToken, src))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func frame(req []byte) []byte {
+	b := make([]byte, grpcFrameHeadSize, grpcFrameHeadSize+len(req))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(req)))
+
+	return append(b, req...)
+}
+
+func readReplies(t *testing.T, body io.Reader, each func([]byte) bool) {
+	for {
+		head := [grpcFrameHeadSize]byte{}
+
+		if _, err := io.ReadFull(body, head[:]); err != nil {
+			return
+		}
+
+		reply := make([]byte, binary.BigEndian.Uint32(head[1:]))
+
+		if _, err := io.ReadFull(body, reply); err != nil {
+			t.Fatalf("Unable to read reply: %s", err)
+		}
+
+		if !each(reply) {
+			return
+		}
+	}
+}
+
+func request(
+	ctx context.Context,
+	ts *httptest.Server,
+	method string,
+	token string,
+	req []byte,
+) (*http.Response, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		ts.URL+"/"+ServiceName+"/"+method, bytes.NewReader(frame(req)))
+	if err != nil {
+		return nil, err
+	}
+
+	r.Header.Set("Content-Type", "application/grpc+proto")
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	return ts.Client().Do(r)
+}
+
+func call(
+	t *testing.T,
+	ts *httptest.Server,
+	method string,
+	token string,
+	req []byte,
+) testCall {
+	resp, err := request(context.Background(), ts, method, token, req)
+	if err != nil {
+		t.Fatalf("Unable to call %s: %s", method, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Expecting the call to be made over HTTP/2, got %s",
+			resp.Proto)
+	}
+
+	c := testCall{}
+
+	readReplies(t, resp.Body, func(b []byte) bool {
+		c.replies = append(c.replies, b)
+
+		return true
+	})
+
+	c.status = resp.Trailer.Get("Grpc-Status")
+	c.message = resp.Trailer.Get("Grpc-Message")
+
+	return c
+}
+
+// repeated returns the bytes of all fields of given `number`
+func repeated(t *testing.T, b []byte, number int) [][]byte {
+	ff, err := fields(b)
+	if err != nil {
+		t.Fatalf("Unable to decode reply: %s", err)
+	}
+
+	result := [][]byte{}
+
+	for _, f := range ff {
+		if f.number == number {
+			result = append(result, f.bytes)
+		}
+	}
+
+	return result
+}
+
+func TestServerAuthorization(t *testing.T) {
+	ts := startTestServer(t, Sources{})
+
+	c := call(t, ts, "ListPresets", "wrong-token", nil)
+	if c.status != "16" {
+		t.Errorf("Expecting status 16 (UNAUTHENTICATED), got %q", c.status)
+	}
+
+	if len(c.replies) != 0 {
+		t.Errorf("Expecting no reply, got %d", len(c.replies))
+	}
+
+	c = call(t, ts, "Shutdown", testToken, nil)
+	if c.status != "12" {
+		t.Errorf("Expecting status 12 (UNIMPLEMENTED), got %q", c.status)
+	}
+
+	c = call(t, ts, "ListEvents", testToken, nil)
+	if c.status != "9" {
+		t.Errorf("Expecting status 9 (FAILED_PRECONDITION), got %q", c.status)
+	}
+
+	if c.message != "journal is not enabled" {
+		t.Errorf("Unexpected status message %q", c.message)
+	}
+}
+
+func TestServerListPresets(t *testing.T) {
+	ts := startTestServer(t, Sources{
+		Presets: []configuration.Preset{
+			{
+				Title: "Server",
+				Type:  "SSH",
+				Host:  "localhost:22",
+				Tags:  []string{"prod", "db"},
+				Meta:  map[string]string{"Password": "secret"},
+			},
+		},
+	})
+
+	c := call(t, ts, "ListPresets", testToken, nil)
+	if c.status != "0" || len(c.replies) != 1 {
+		t.Fatalf("Unexpected result: status %q, %d replies",
+			c.status, len(c.replies))
+	}
+
+	presets := repeated(t, c.replies[0], 1)
+	if len(presets) != 1 {
+		t.Fatalf("Expecting 1 preset, got %d", len(presets))
+	}
+
+	if bytes.Contains(presets[0], []byte("secret")) {
+		t.Error("Preset Meta must not be exposed")
+	}
+
+	if title := repeated(t, presets[0], 1); string(title[0]) != "Server" {
+		t.Errorf("Expecting title \"Server\", got %q", title[0])
+	}
+
+	if tags := repeated(t, presets[0], 5); len(tags) != 2 ||
+		string(tags[0]) != "prod" || string(tags[1]) != "db" {
+		t.Errorf("Unexpected tags %q", tags)
+	}
+}
+
+func TestServerKeys(t *testing.T) {
+	vault, err := keyvault.Open(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatalf("Unable to open vault: %s", err)
+	}
+
+	ts := startTestServer(t, Sources{Vault: vault})
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s", err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Unable to convert key: %s", err)
+	}
+
+	req := message{}
+	req.bytes(1, ssh.MarshalAuthorizedKey(sshPub))
+	req.string(2, "admin")
+
+	c := call(t, ts, "AddKey", testToken, req)
+	if c.status != "0" || len(c.replies) != 1 {
+		t.Fatalf("Unable to add key: status %q: %s", c.status, c.message)
+	}
+
+	fingerprint := string(repeated(t, c.replies[0], 1)[0])
+	if fingerprint != ssh.FingerprintSHA256(sshPub) {
+		t.Errorf("Unexpected fingerprint %q", fingerprint)
+	}
+
+	c = call(t, ts, "AddKey", testToken, req)
+	if c.status != "6" {
+		t.Errorf("Expecting status 6 (ALREADY_EXISTS), got %q", c.status)
+	}
+
+	invalid := message{}
+	invalid.string(1, "not a key")
+
+	c = call(t, ts, "AddKey", testToken, invalid)
+	if c.status != "3" {
+		t.Errorf("Expecting status 3 (INVALID_ARGUMENT), got %q", c.status)
+	}
+
+	c = call(t, ts, "ListKeys", testToken, nil)
+	if keys := repeated(t, c.replies[0], 1); len(keys) != 1 {
+		t.Errorf("Expecting 1 key, got %d", len(keys))
+	}
+
+	remove := message{}
+	remove.string(1, fingerprint)
+
+	c = call(t, ts, "RemoveKey", testToken, remove)
+	if c.status != "0" {
+		t.Errorf("Unable to remove key: status %q: %s", c.status, c.message)
+	}
+
+	c = call(t, ts, "RemoveKey", testToken, remove)
+	if c.status != "5" {
+		t.Errorf("Expecting status 5 (NOT_FOUND), got %q", c.status)
+	}
+
+	if len(vault.List()) != 0 {
+		t.Errorf("Expecting the vault to be empty, got %d keys",
+			len(vault.List()))
+	}
+}
+
+func TestServerWatchEvents(t *testing.T) {
+	feed := audit.NewFeed()
+	ts := startTestServer(t, Sources{Events: feed})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req := message{}
+	req.string(1, string(journal.REMOTE_CONNECTED))
+
+	resp, err := request(ctx, ts, "WatchEvents", testToken, req)
+	if err != nil {
+		t.Fatalf("Unable to call WatchEvents: %s", err)
+	}
+	defer resp.Body.Close()
+
+	// The subscription is only made once the request is served, so keep
+	// publishing until the stream started
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			feed.Publish(journal.Event{
+				Type:   journal.CLIENT_CONNECTED,
+				Client: "filtered",
+			})
+			feed.Publish(journal.Event{
+				Type:     journal.REMOTE_CONNECTED,
+				Protocol: "SSH",
+				Remote:   "localhost:22",
+			})
+		}
+	}()
+
+	received := 0
+
+	readReplies(t, resp.Body, func(b []byte) bool {
+		eventType := repeated(t, b, 2)
+		if len(eventType) != 1 ||
+			string(eventType[0]) != string(journal.REMOTE_CONNECTED) {
+			t.Errorf("Unexpected event type %q", eventType)
+		}
+
+		received++
+
+		return received < 3
+	})
+
+	if received != 3 {
+		t.Errorf("Expecting 3 events, got %d", received)
+	}
+}
+
+func TestEncodeStatusMessage(t *testing.T) {
+	got := encodeStatusMessage("100% done\nnext")
+
+	if got != "100%25 done%0Anext" {
+		t.Errorf("Unexpected encoded message %q", got)
+	}
+
+	if strings.ContainsAny(got, "\n") {
+		t.Error("Encoded message must not contain control characters")
+	}
+}
@@ -0,0 +1,151 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package management
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Errors
+var (
+	ErrMalformedMessage = errors.New(
+		"malformed protobuf message")
+)
+
+// Wire types of protobuf
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// message encodes a protobuf message. Fields of zero value are omitted, as
+// proto3 does
+type message []byte
+
+func (m *message) tag(field int, wire int) {
+	*m = binary.AppendUvarint(*m, uint64(field<<3|wire))
+}
+
+func (m *message) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+
+	m.tag(field, wireVarint)
+	*m = binary.AppendUvarint(*m, v)
+}
+
+func (m *message) bool(field int, v bool) {
+	if !v {
+		return
+	}
+
+	m.varint(field, 1)
+}
+
+func (m *message) bytes(field int, b []byte) {
+	if len(b) <= 0 {
+		return
+	}
+
+	m.tag(field, wireBytes)
+	*m = binary.AppendUvarint(*m, uint64(len(b)))
+	*m = append(*m, b...)
+}
+
+func (m *message) string(field int, s string) {
+	m.bytes(field, []byte(s))
+}
+
+// embed encodes `sub` as a field of embedded message. Unlike bytes, empty
+// messages are still encoded, so they keep their place in repeated fields
+func (m *message) embed(field int, sub []byte) {
+	m.tag(field, wireBytes)
+	*m = binary.AppendUvarint(*m, uint64(len(sub)))
+	*m = append(*m, sub...)
+}
+
+// field is a decoded field of a protobuf message
+type field struct {
+	number int
+	wire   int
+	varint uint64
+	bytes  []byte
+}
+
+// fields decodes the fields of the protobuf message `b`. Fields of the
+// fixed size wire types are skipped, as none of the requests uses them
+func fields(b []byte) ([]field, error) {
+	result := []field{}
+
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 || tag>>3 == 0 {
+			return nil, ErrMalformedMessage
+		}
+		b = b[n:]
+
+		f := field{
+			number: int(tag >> 3),
+			wire:   int(tag & 7),
+		}
+
+		switch f.wire {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, ErrMalformedMessage
+			}
+			b = b[n:]
+
+		case wireFixed64:
+			if len(b) < 8 {
+				return nil, ErrMalformedMessage
+			}
+			b = b[8:]
+
+			continue
+
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return nil, ErrMalformedMessage
+			}
+			f.bytes = b[n : n+int(l)]
+			b = b[n+int(l):]
+
+		case wireFixed32:
+			if len(b) < 4 {
+				return nil, ErrMalformedMessage
+			}
+			b = b[4:]
+
+			continue
+
+		default:
+			return nil, ErrMalformedMessage
+		}
+
+		result = append(result, f)
+	}
+
+	return result, nil
+}
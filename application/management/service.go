@@ -0,0 +1,483 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package management serves the management API, which exposes the state of
+// Sshwifty to the infrastructure tools through gRPC. The service is defined
+// in management.proto
+package management
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/nirui/sshwifty/application/audit"
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/configuration"
+	"github.com/nirui/sshwifty/application/forward"
+	"github.com/nirui/sshwifty/application/hostkeys"
+	"github.com/nirui/sshwifty/application/journal"
+	"github.com/nirui/sshwifty/application/keyvault"
+	"github.com/nirui/sshwifty/application/network"
+	"github.com/nirui/sshwifty/application/streamstats"
+)
+
+const (
+	// ServiceName is the full name of the service in management.proto
+	ServiceName = "sshwifty.management.v1.Management"
+
+	eventsDefaultLimit = 100
+	watchBuffer        = 256
+)
+
+// Sources are the parts of Sshwifty that the management API works with.
+// The nil ones are treated as disabled
+type Sources struct {
+	Journal  *journal.Journal
+	Events   *audit.Feed
+	Audit    *audit.Dispatcher
+	Presets  []configuration.Preset
+	Vault    *keyvault.Vault
+	HostKeys *hostkeys.Store
+	Usage    *network.TrafficUsage
+	Hooks    command.Hooks
+	Forwards *forward.Registry
+	Streams  *streamstats.Registry
+}
+
+type service struct {
+	src Sources
+}
+
+// New creates the Server of the management API. Calls must present the
+// `token` as the "authorization: Bearer <token>" metadata
+func New(token string, src Sources) *Server {
+	s := service{src: src}
+
+	prefix := "/" + ServiceName + "/"
+
+	return &Server{
+		token: []byte(token),
+		methods: map[string]method{
+			prefix + "ListEvents":    {unary: s.listEvents},
+			prefix + "WatchEvents":   {stream: s.watchEvents},
+			prefix + "ListPresets":   {unary: s.listPresets},
+			prefix + "ListKeys":      {unary: s.listKeys},
+			prefix + "AddKey":        {unary: s.addKey},
+			prefix + "RemoveKey":     {unary: s.removeKey},
+			prefix + "ListHostKeys":  {unary: s.listHostKeys},
+			prefix + "RemoveHostKey": {unary: s.removeHostKey},
+			prefix + "ListForwards":  {unary: s.listForwards},
+			prefix + "GetStats":      {unary: s.getStats},
+		},
+	}
+}
+
+// stringField decodes the string field of given `number` in a request
+func stringField(f field, number int, target *string) error {
+	if f.number != number {
+		return nil
+	}
+
+	if f.wire != wireBytes {
+		return Status{
+			Code:    CodeInvalidArgument,
+			Message: "field " + strconv.Itoa(number) + " must be a string",
+		}
+	}
+
+	*target = string(f.bytes)
+
+	return nil
+}
+
+// parseRequest decodes the request message, calling `each` for every field
+func parseRequest(req []byte, each func(f field) error) error {
+	ff, err := fields(req)
+	if err != nil {
+		return Status{Code: CodeInvalidArgument, Message: err.Error()}
+	}
+
+	for _, f := range ff {
+		if err := each(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s service) listEvents(ctx context.Context, req []byte) ([]byte, error) {
+	if s.src.Journal == nil {
+		return nil, Status{
+			Code:    CodeFailedPrecondition,
+			Message: "journal is not enabled",
+		}
+	}
+
+	limit := eventsDefaultLimit
+
+	err := parseRequest(req, func(f field) error {
+		if f.number == 1 && f.wire == wireVarint && f.varint > 0 {
+			limit = int(min(f.varint, uint64(1<<31-1)))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reply := message{}
+
+	for _, e := range s.src.Journal.Events(limit) {
+		b, _ := audit.FormatProtobuf.Encode(e)
+		reply.embed(1, b)
+	}
+
+	return reply, nil
+}
+
+func (s service) watchEvents(
+	ctx context.Context, req []byte, send func([]byte) error) error {
+	if s.src.Events == nil {
+		return Status{
+			Code:    CodeFailedPrecondition,
+			Message: "event feed is not enabled",
+		}
+	}
+
+	types := map[journal.EventType]struct{}{}
+
+	err := parseRequest(req, func(f field) error {
+		t := ""
+
+		if err := stringField(f, 1, &t); err != nil {
+			return err
+		}
+
+		if len(t) > 0 {
+			types[journal.EventType(t)] = struct{}{}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sub := s.src.Events.Subscribe(watchBuffer)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case e := <-sub.Events():
+			if _, ok := types[e.Type]; len(types) > 0 && !ok {
+				continue
+			}
+
+			b, _ := audit.FormatProtobuf.Encode(e)
+
+			if err := send(b); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s service) listPresets(ctx context.Context, req []byte) ([]byte, error) {
+	reply := message{}
+
+	// Meta is never exposed, it may carry credentials
+	for _, p := range s.src.Presets {
+		m := message{}
+		m.string(1, p.Title)
+		m.string(2, p.Type)
+		m.string(3, p.Host)
+		m.string(4, p.TabColor)
+
+		for _, t := range p.Tags {
+			m.embed(5, []byte(t))
+		}
+
+		m.bool(6, p.FastStart)
+		m.bool(7, p.NoTrace)
+
+		reply.embed(1, m)
+	}
+
+	return reply, nil
+}
+
+func encodeKey(k keyvault.Key) message {
+	m := message{}
+	m.string(1, k.Fingerprint)
+	m.string(2, k.Type)
+	m.string(3, k.Key)
+	m.string(4, k.Owner)
+	m.string(5, k.Comment)
+	m.varint(6, uint64(k.Added.UnixNano()))
+
+	return m
+}
+
+func (s service) vault() (*keyvault.Vault, error) {
+	if s.src.Vault == nil {
+		return nil, Status{
+			Code:    CodeFailedPrecondition,
+			Message: "public key vault is not enabled",
+		}
+	}
+
+	return s.src.Vault, nil
+}
+
+func (s service) listKeys(ctx context.Context, req []byte) ([]byte, error) {
+	v, err := s.vault()
+	if err != nil {
+		return nil, err
+	}
+
+	reply := message{}
+
+	for _, k := range v.List() {
+		reply.embed(1, encodeKey(k))
+	}
+
+	return reply, nil
+}
+
+func (s service) addKey(ctx context.Context, req []byte) ([]byte, error) {
+	v, err := s.vault()
+	if err != nil {
+		return nil, err
+	}
+
+	key, owner, comment := "", "", ""
+
+	err = parseRequest(req, func(f field) error {
+		if err := stringField(f, 1, &key); err != nil {
+			return err
+		}
+
+		if err := stringField(f, 2, &owner); err != nil {
+			return err
+		}
+
+		return stringField(f, 3, &comment)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := v.Add([]byte(key), owner, comment)
+
+	switch {
+	case err == nil:
+		return encodeKey(k), nil
+
+	case errors.Is(err, keyvault.ErrKeyAlreadyExists):
+		return nil, Status{Code: CodeAlreadyExists, Message: err.Error()}
+
+	case errors.Is(err, keyvault.ErrInvalidPublicKey):
+		return nil, Status{Code: CodeInvalidArgument, Message: err.Error()}
+
+	default:
+		return nil, Status{Code: CodeInternal, Message: err.Error()}
+	}
+}
+
+func (s service) removeKey(ctx context.Context, req []byte) ([]byte, error) {
+	v, err := s.vault()
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := ""
+
+	err = parseRequest(req, func(f field) error {
+		return stringField(f, 1, &fingerprint)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = v.Remove(fingerprint)
+
+	switch {
+	case err == nil:
+		return message{}, nil
+
+	case errors.Is(err, keyvault.ErrKeyNotFound):
+		return nil, Status{Code: CodeNotFound, Message: err.Error()}
+
+	default:
+		return nil, Status{Code: CodeInternal, Message: err.Error()}
+	}
+}
+
+func (s service) hostKeys() (*hostkeys.Store, error) {
+	if s.src.HostKeys == nil {
+		return nil, Status{
+			Code:    CodeFailedPrecondition,
+			Message: "known hosts file is not enabled",
+		}
+	}
+
+	return s.src.HostKeys, nil
+}
+
+func (s service) listHostKeys(
+	ctx context.Context, req []byte) ([]byte, error) {
+	h, err := s.hostKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := h.Entries()
+	if err != nil {
+		return nil, Status{Code: CodeInternal, Message: err.Error()}
+	}
+
+	reply := message{}
+
+	for _, e := range entries {
+		m := message{}
+		m.string(1, e.Marker)
+
+		for _, host := range e.Hosts {
+			m.embed(2, []byte(host))
+		}
+
+		m.string(3, e.Type)
+		m.string(4, e.Fingerprint)
+
+		reply.embed(1, m)
+	}
+
+	return reply, nil
+}
+
+func (s service) removeHostKey(
+	ctx context.Context, req []byte) ([]byte, error) {
+	h, err := s.hostKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	host := ""
+
+	err = parseRequest(req, func(f field) error {
+		return stringField(f, 1, &host)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.Remove(host)
+
+	switch {
+	case err == nil:
+		return message{}, nil
+
+	case errors.Is(err, hostkeys.ErrHostNotFound):
+		return nil, Status{Code: CodeNotFound, Message: err.Error()}
+
+	default:
+		return nil, Status{Code: CodeInternal, Message: err.Error()}
+	}
+}
+
+func (s service) listForwards(
+	ctx context.Context, req []byte) ([]byte, error) {
+	reply := message{}
+
+	for _, e := range s.src.Forwards.Entries() {
+		m := message{}
+		m.varint(1, e.ID)
+		m.string(2, e.User)
+		m.string(3, e.Client)
+		m.string(4, e.Remote)
+		m.string(5, e.Bind)
+		m.string(6, e.Target)
+		m.varint(7, uint64(e.Since.UnixNano()))
+
+		reply.embed(1, m)
+	}
+
+	return reply, nil
+}
+
+func (s service) getStats(ctx context.Context, req []byte) ([]byte, error) {
+	reply := message{}
+
+	if s.src.Usage != nil {
+		for _, r := range s.src.Usage.Records() {
+			m := message{}
+			m.string(1, r.Name)
+			m.varint(2, r.Connections)
+			m.varint(3, r.Sent)
+			m.varint(4, r.Received)
+
+			reply.embed(1, m)
+		}
+	}
+
+	for _, st := range s.src.Audit.Stats() {
+		m := message{}
+		m.string(1, st.Sink)
+		m.varint(2, st.Delivered)
+		m.varint(3, st.Dropped)
+		m.varint(4, st.Failed)
+
+		reply.embed(2, m)
+	}
+
+	hooks := s.src.Hooks.AsyncStats()
+
+	h := message{}
+	h.varint(1, uint64(hooks.Queued))
+	h.varint(2, uint64(hooks.Workers))
+	h.varint(3, hooks.Completed)
+	h.varint(4, hooks.Failed)
+	h.varint(5, hooks.Dropped)
+
+	reply.embed(3, h)
+
+	for _, st := range s.src.Streams.Records() {
+		m := message{}
+		m.varint(1, st.ID)
+		m.string(2, st.Client)
+		m.string(3, st.User)
+		m.string(4, st.Command)
+		m.varint(5, uint64(st.Stream))
+		m.string(6, st.Remote)
+		m.varint(7, uint64(st.Started.UnixNano()))
+		m.varint(8, uint64(st.CPUTime))
+		m.varint(9, uint64(st.Buffered))
+		m.varint(10, uint64(st.Peak))
+		m.varint(11, st.Sent)
+		m.varint(12, st.Received)
+
+		reply.embed(4, m)
+	}
+
+	return reply, nil
+}
@@ -20,20 +20,96 @@ package network
 import (
 	"context"
 	"net"
+	"time"
 )
 
 // Dial dial to remote machine
 type Dial func(
 	ctx context.Context, network string, address string) (net.Conn, error)
 
-// TCPDial build a TCP dialer
-func TCPDial() Dial {
+// KeepAlive contains TCP keep-alive settings of the outgoing connections.
+// Zero values tell the system to use it's own default
+type KeepAlive struct {
+	Idle     time.Duration
+	Interval time.Duration
+	Count    int
+}
+
+// config returns the net.KeepAliveConfig of current KeepAlive
+func (k KeepAlive) config() net.KeepAliveConfig {
+	return net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     k.Idle,
+		Interval: k.Interval,
+		Count:    k.Count,
+	}
+}
+
+// dialer returns a net.Dialer which is configured with current KeepAlive
+func (k KeepAlive) dialer() net.Dialer {
+	return net.Dialer{
+		KeepAliveConfig: k.config(),
+	}
+}
+
+// TCPDial build a TCP dialer. Host names will be resolved by the `resolver`
+// when it's not nil
+func TCPDial(keepAlive KeepAlive, resolver *Resolver) Dial {
 	return func(
 		ctx context.Context,
 		network string,
 		address string,
 	) (net.Conn, error) {
-		dial := net.Dialer{}
-		return dial.DialContext(ctx, network, address)
+		dial := keepAlive.dialer()
+
+		trace := dialTraceFrom(ctx)
+		if trace == nil && resolver == nil {
+			return dial.DialContext(ctx, network, address)
+		}
+
+		return resolvedDial(ctx, trace, resolver, dial, network, address)
 	}
 }
+
+// resolvedDial resolves the host of the `address` separately before dialing,
+// so the `resolver` can be used, and the time spent on each phase can be
+// reported to the `trace`
+func resolvedDial(
+	ctx context.Context,
+	trace *DialTrace,
+	resolver *Resolver,
+	dial net.Dialer,
+	network string,
+	address string,
+) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		trace.Begin(DIAL_PHASE_CONNECT)
+		conn, err := dial.DialContext(ctx, network, address)
+		if err == nil {
+			trace.End()
+		}
+		return conn, err
+	}
+
+	trace.Begin(DIAL_PHASE_RESOLVE)
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	trace.Begin(DIAL_PHASE_CONNECT)
+
+	var conn net.Conn
+	err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	for _, addr := range addrs {
+		conn, err = dial.DialContext(
+			ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			trace.End()
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,202 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrCommandDialEmptyCommand = errors.New(
+		"proxy command must not be empty")
+)
+
+// ProxyCommands contains external commands that will be used to reach the
+// remote, indexed by the remote address and the protocol spoken with it
+type ProxyCommands map[DialTarget][]string
+
+// commandAddr is the net.Addr of a commandConn
+type commandAddr string
+
+func (c commandAddr) Network() string {
+	return "command"
+}
+
+func (c commandAddr) String() string {
+	return string(c)
+}
+
+// commandConn is a net.Conn which uses the stdio of an external process as
+// it's transport
+type commandConn struct {
+	cmd       *exec.Cmd
+	reader    *os.File
+	writer    *os.File
+	addr      commandAddr
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (c *commandConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *commandConn) Write(b []byte) (int, error) {
+	return c.writer.Write(b)
+}
+
+func (c *commandConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.writer.Close()
+		c.reader.Close()
+
+		if c.cmd.Process != nil {
+			c.cmd.Process.Kill()
+		}
+
+		c.cmd.Wait()
+	})
+
+	return c.closeErr
+}
+
+func (c *commandConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *commandConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *commandConn) SetDeadline(t time.Time) error {
+	rErr := c.reader.SetReadDeadline(t)
+	wErr := c.writer.SetWriteDeadline(t)
+
+	if rErr != nil {
+		return rErr
+	}
+
+	return wErr
+}
+
+func (c *commandConn) SetReadDeadline(t time.Time) error {
+	return c.reader.SetReadDeadline(t)
+}
+
+func (c *commandConn) SetWriteDeadline(t time.Time) error {
+	return c.writer.SetWriteDeadline(t)
+}
+
+// expandProxyCommand replaces "%h" and "%p" in the command arguments with the
+// host and port of the remote, and "%%" with a single "%"
+func expandProxyCommand(command []string, host, port string) []string {
+	r := strings.NewReplacer("%%", "%", "%h", host, "%p", port)
+	result := make([]string, len(command))
+
+	for i := range command {
+		result[i] = r.Replace(command[i])
+	}
+
+	return result
+}
+
+// startCommandConn starts the given command, and returns a net.Conn that is
+// connected to it's stdio
+func startCommandConn(
+	ctx context.Context,
+	command []string,
+) (net.Conn, error) {
+	if len(command) <= 0 || len(command[0]) <= 0 {
+		return nil, ErrCommandDialEmptyCommand
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		stdinReader.Close()
+		stdinWriter.Close()
+
+		return nil, err
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = stdinReader
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = nil
+
+	err = cmd.Start()
+
+	// The child process has it's own copies of these
+	stdinReader.Close()
+	stdoutWriter.Close()
+
+	if err != nil {
+		stdinWriter.Close()
+		stdoutReader.Close()
+
+		return nil, err
+	}
+
+	return &commandConn{
+		cmd:    cmd,
+		reader: stdoutReader,
+		writer: stdinWriter,
+		addr:   commandAddr(strings.Join(command, " ")),
+	}, nil
+}
+
+// CommandDial creates a Dial which reaches the remote through the stdio of
+// an external command when the address is listed in `commands` (much like the
+// ProxyCommand of OpenSSH), or use `dial` otherwise. The protocol carried by
+// the context must match as well
+func CommandDial(commands ProxyCommands, dial Dial) Dial {
+	return func(
+		ctx context.Context,
+		network string,
+		address string,
+	) (net.Conn, error) {
+		command, ok := commands[dialTargetFrom(ctx, address)]
+		if !ok {
+			return dial(ctx, network, address)
+		}
+
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			host, port = address, ""
+		}
+
+		return startCommandConn(
+			ctx, expandProxyCommand(command, host, port))
+	}
+}
@@ -0,0 +1,86 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"context"
+	"net"
+	"os/exec"
+	"testing"
+)
+
+func TestCommandDial(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("Command \"cat\" is not available:", err)
+		return
+	}
+
+	dial := CommandDial(ProxyCommands{
+		{Protocol: "SSH", Address: "example.com:22"}: {"cat"},
+	}, func(
+		ctx context.Context, network string, address string,
+	) (net.Conn, error) {
+		return nil, ErrCommandDialEmptyCommand
+	})
+
+	// Same address, but another protocol
+	_, err := dial(WithDialProtocol(context.Background(), "Telnet"),
+		"tcp", "example.com:22")
+	if err != ErrCommandDialEmptyCommand {
+		t.Errorf("Expecting the command to be skipped, got %v", err)
+	}
+
+	c, err := dial(WithDialProtocol(context.Background(), "SSH"),
+		"tcp", "example.com:22")
+	if err != nil {
+		t.Error("Failed to dial through the command:", err)
+		return
+	}
+	defer c.Close()
+
+	_, err = c.Write([]byte("Hello"))
+	if err != nil {
+		t.Error("Failed to write:", err)
+		return
+	}
+
+	buf := make([]byte, 5)
+	_, err = c.Read(buf)
+	if err != nil {
+		t.Error("Failed to read:", err)
+		return
+	}
+
+	if string(buf) != "Hello" {
+		t.Errorf("Expecting \"Hello\", got %q", buf)
+		return
+	}
+}
+
+func TestExpandProxyCommand(t *testing.T) {
+	result := expandProxyCommand(
+		[]string{"nc", "-X", "%%h", "%h", "%p"}, "example.com", "22")
+	expected := []string{"nc", "-X", "%h", "example.com", "22"}
+
+	for i := range expected {
+		if result[i] != expected[i] {
+			t.Errorf("Expecting %q, got %q", expected, result)
+			return
+		}
+	}
+}
@@ -19,6 +19,7 @@ package network
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"time"
 
@@ -54,25 +55,47 @@ func (s socks5Dial) DialContext(
 	return conn, dErr
 }
 
-// BuildSocks5Dial builds a Socks5 dialer
-func BuildSocks5Dial(
-	socks5Address string, userName string, password string) (Dial, error) {
-	var auth *proxy.Auth
+// Socks5Credentials returns the user name and the password of the Socks5
+// server. It's called before every connection, so the credentials that are
+// rotated while running will be used by the next connection
+type Socks5Credentials func() (userName string, password string, err error)
 
-	if len(userName) > 0 || len(password) > 0 {
-		auth = &proxy.Auth{
-			User:     userName,
-			Password: password,
-		}
+// StaticSocks5Credentials returns Socks5Credentials that never change
+func StaticSocks5Credentials(userName, password string) Socks5Credentials {
+	return func() (string, string, error) {
+		return userName, password, nil
 	}
+}
 
+// BuildSocks5Dial builds a Socks5 dialer
+func BuildSocks5Dial(
+	socks5Address string,
+	credentials Socks5Credentials,
+	keepAlive KeepAlive,
+) (Dial, error) {
 	return func(
 		ctx context.Context,
 		network string,
 		address string,
 	) (net.Conn, error) {
+		userName, password, credErr := credentials()
+
+		if credErr != nil {
+			return nil, fmt.Errorf(
+				"unable to load the Socks5 credentials: %s", credErr)
+		}
+
+		var auth *proxy.Auth
+
+		if len(userName) > 0 || len(password) > 0 {
+			auth = &proxy.Auth{
+				User:     userName,
+				Password: password,
+			}
+		}
+
 		dialCfg := socks5Dial{
-			Dialer: net.Dialer{},
+			Dialer: keepAlive.dialer(),
 			ctx:    ctx,
 		}
 
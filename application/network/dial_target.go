@@ -0,0 +1,77 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"context"
+	"net"
+)
+
+// dialProtocolContextKey is the context key of the dial protocol
+type dialProtocolContextKey struct{}
+
+// WithDialProtocol returns a context that tells the Dial which protocol (the
+// Type of the Preset, i.e. "SSH") will be spoken with the remote
+func WithDialProtocol(ctx context.Context, protocol string) context.Context {
+	return context.WithValue(ctx, dialProtocolContextKey{}, protocol)
+}
+
+// DialTarget is a remote address, and the protocol that will be spoken with
+// it
+type DialTarget struct {
+	Protocol string
+	Address  string
+}
+
+// dialTargetFrom returns the DialTarget of dialing the `address` with the
+// `ctx`. The Protocol is empty when the `ctx` carries none
+func dialTargetFrom(ctx context.Context, address string) DialTarget {
+	protocol, _ := ctx.Value(dialProtocolContextKey{}).(string)
+
+	return DialTarget{
+		Protocol: protocol,
+		Address:  address,
+	}
+}
+
+// AllowedTargets contains a map of allowed remotes and the protocols they
+// can be reached with
+type AllowedTargets map[DialTarget]struct{}
+
+// Allowed returns whether or not the given target is allowed
+func (a AllowedTargets) Allowed(t DialTarget) bool {
+	_, ok := a[t]
+
+	return ok
+}
+
+// TargetAccessControlDial creates a Dial which only dials the remotes that
+// are allowed to be reached with the protocol carried by the context
+func TargetAccessControlDial(allowed AllowedTargets, dial Dial) Dial {
+	return func(
+		ctx context.Context,
+		network string,
+		address string,
+	) (net.Conn, error) {
+		if !allowed.Allowed(dialTargetFrom(ctx, address)) {
+			return nil, ErrAccessControlDialTargetHostNotAllowed
+		}
+
+		return dial(ctx, network, address)
+	}
+}
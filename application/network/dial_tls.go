@@ -0,0 +1,45 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// WrapTLS wraps the established `conn` in TLS, and completes the handshake
+// before the `ctx` is done. The `conn` is closed when the handshake has
+// failed
+func WrapTLS(
+	ctx context.Context,
+	conn net.Conn,
+	cfg *tls.Config,
+) (net.Conn, error) {
+	tlsConn := tls.Client(conn, cfg)
+
+	dialTraceFrom(ctx).Begin(DIAL_PHASE_TLS)
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
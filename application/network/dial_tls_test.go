@@ -0,0 +1,80 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrapTLS(t *testing.T) {
+	s := httptest.NewUnstartedServer(http.NotFoundHandler())
+	s.TLS = &tls.Config{NextProtos: []string{"ssh"}}
+	s.StartTLS()
+	defer s.Close()
+
+	roots := s.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	for _, c := range []struct {
+		cfg *tls.Config
+		ok  bool
+	}{
+		{&tls.Config{
+			ServerName: "example.com",
+			RootCAs:    roots,
+			NextProtos: []string{"ssh"},
+		}, true},
+		{&tls.Config{ServerName: "other.example.net", RootCAs: roots}, false},
+		{&tls.Config{ServerName: "example.com"}, false},
+	} {
+		conn, err := net.Dial("tcp", s.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		trace := NewDialTrace()
+
+		wrapped, err := WrapTLS(
+			WithDialTrace(context.Background(), trace), conn, c.cfg)
+		if (err == nil) != c.ok {
+			t.Errorf("Expecting the handshake with %q to succeed: %v, "+
+				"got %v", c.cfg.ServerName, c.ok, err)
+		}
+
+		if trace.Report().Phase != DIAL_PHASE_TLS {
+			t.Errorf("Expecting the TLS phase to be traced, got %+v",
+				trace.Report())
+		}
+
+		if err != nil {
+			continue
+		}
+
+		state := wrapped.(*tls.Conn).ConnectionState()
+		if state.NegotiatedProtocol != "ssh" {
+			t.Errorf("Expecting \"ssh\" to be negotiated, got %q",
+				state.NegotiatedProtocol)
+		}
+
+		wrapped.Close()
+	}
+}
@@ -0,0 +1,177 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Dial phases traced by the DialTrace
+const (
+	DIAL_PHASE_RESOLVE = "resolve"
+	DIAL_PHASE_CONNECT = "connect"
+	DIAL_PHASE_TLS     = "tls"
+)
+
+type dialTraceContextKey struct{}
+
+// DialTraceReport is the result of a DialTrace
+type DialTraceReport struct {
+	// Started is the time when the first phase has started
+	Started time.Time
+
+	// Phase is the phase which has not been completed (i.e. failed), empty
+	// when all phases are completed
+	Phase string
+
+	// PhaseElapsed is the time spent on the unfinished Phase
+	PhaseElapsed time.Duration
+
+	// Completed contains the time spent on each completed phase
+	Completed map[string]time.Duration
+}
+
+// DialPhaseObserver is called every time a phase of the DialTrace has been
+// completed, with the time it has started and ended
+type DialPhaseObserver func(phase string, start time.Time, end time.Time)
+
+// DialTrace records the time spent on each phase of a connection attempt.
+// All methods are safe to call on a nil DialTrace
+type DialTrace struct {
+	lock       sync.Mutex
+	started    time.Time
+	phase      string
+	phaseStart time.Time
+	completed  map[string]time.Duration
+	observer   DialPhaseObserver
+}
+
+// NewDialTrace creates a new DialTrace
+func NewDialTrace() *DialTrace {
+	return &DialTrace{
+		lock:       sync.Mutex{},
+		started:    time.Time{},
+		phase:      "",
+		phaseStart: time.Time{},
+		completed:  map[string]time.Duration{},
+		observer:   nil,
+	}
+}
+
+// Observe sets the DialPhaseObserver `o` which will be called when the
+// phases are completed
+func (t *DialTrace) Observe(o DialPhaseObserver) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.observer = o
+}
+
+// WithDialTrace returns a context that carries the DialTrace, so the Dial
+// can report it's phases to it
+func WithDialTrace(ctx context.Context, t *DialTrace) context.Context {
+	return context.WithValue(ctx, dialTraceContextKey{}, t)
+}
+
+// dialTraceFrom returns the DialTrace carried by the `ctx`, or nil when
+// there is none
+func dialTraceFrom(ctx context.Context) *DialTrace {
+	t, _ := ctx.Value(dialTraceContextKey{}).(*DialTrace)
+
+	return t
+}
+
+// end completes current phase. Caller must hold the lock
+func (t *DialTrace) end(now time.Time) {
+	if len(t.phase) <= 0 {
+		return
+	}
+
+	t.completed[t.phase] += now.Sub(t.phaseStart)
+
+	if t.observer != nil {
+		t.observer(t.phase, t.phaseStart, now)
+	}
+
+	t.phase = ""
+}
+
+// Begin completes current phase, and begins a new one
+func (t *DialTrace) Begin(phase string) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+
+	if t.started.IsZero() {
+		t.started = now
+	}
+
+	t.end(now)
+
+	t.phase = phase
+	t.phaseStart = now
+}
+
+// End completes current phase
+func (t *DialTrace) End() {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.end(time.Now())
+}
+
+// Report returns the current DialTraceReport
+func (t *DialTrace) Report() DialTraceReport {
+	if t == nil {
+		return DialTraceReport{}
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	r := DialTraceReport{
+		Started:   t.started,
+		Phase:     t.phase,
+		Completed: make(map[string]time.Duration, len(t.completed)),
+	}
+
+	if len(t.phase) > 0 {
+		r.PhaseElapsed = time.Since(t.phaseStart)
+	}
+
+	for k, v := range t.completed {
+		r.Completed[k] = v
+	}
+
+	return r
+}
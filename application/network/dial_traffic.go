@@ -0,0 +1,145 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// TrafficClassifier returns the name of the traffic group which the given
+// remote `address` belongs to. The `remote` is the address the connection
+// has actually reached, which tells the IP address the host name of the
+// `address` was resolved to when the remote was dialed directly
+type TrafficClassifier func(address string, remote net.Addr) string
+
+// TrafficRecord is the traffic usage of a traffic group
+type TrafficRecord struct {
+	Name        string `json:"name"`
+	Connections uint64 `json:"connections"`
+	Sent        uint64 `json:"sent"`
+	Received    uint64 `json:"received"`
+}
+
+type trafficCounter struct {
+	connections atomic.Uint64
+	sent        atomic.Uint64
+	received    atomic.Uint64
+}
+
+// TrafficUsage aggregates bytes transferred with the remotes by their
+// traffic group
+type TrafficUsage struct {
+	lock     sync.Mutex
+	counters map[string]*trafficCounter
+}
+
+// NewTrafficUsage creates a new TrafficUsage
+func NewTrafficUsage() *TrafficUsage {
+	return &TrafficUsage{
+		lock:     sync.Mutex{},
+		counters: map[string]*trafficCounter{},
+	}
+}
+
+func (t *TrafficUsage) counter(name string) *trafficCounter {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	c, ok := t.counters[name]
+	if !ok {
+		c = &trafficCounter{}
+		t.counters[name] = c
+	}
+
+	return c
+}
+
+// Records returns the usage of all traffic groups, ordered by their names
+func (t *TrafficUsage) Records() []TrafficRecord {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	records := make([]TrafficRecord, 0, len(t.counters))
+
+	for name, c := range t.counters {
+		records = append(records, TrafficRecord{
+			Name:        name,
+			Connections: c.connections.Load(),
+			Sent:        c.sent.Load(),
+			Received:    c.received.Load(),
+		})
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name < records[j].Name
+	})
+
+	return records
+}
+
+// trafficConn counts the bytes transferred through the net.Conn
+type trafficConn struct {
+	net.Conn
+
+	counter *trafficCounter
+}
+
+func (t trafficConn) Read(b []byte) (int, error) {
+	rLen, rErr := t.Conn.Read(b)
+	t.counter.received.Add(uint64(rLen))
+
+	return rLen, rErr
+}
+
+func (t trafficConn) Write(b []byte) (int, error) {
+	wLen, wErr := t.Conn.Write(b)
+	t.counter.sent.Add(uint64(wLen))
+
+	return wLen, wErr
+}
+
+// TrafficDial creates a Dial which records traffic of the dialed connections
+// into `usage`, grouped by the `classify`
+func TrafficDial(
+	usage *TrafficUsage,
+	classify TrafficClassifier,
+	dial Dial,
+) Dial {
+	return func(
+		ctx context.Context,
+		network string,
+		address string,
+	) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		counter := usage.counter(classify(address, conn.RemoteAddr()))
+		counter.connections.Add(1)
+
+		return trafficConn{
+			Conn:    conn,
+			counter: counter,
+		}, nil
+	}
+}
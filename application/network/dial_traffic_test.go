@@ -0,0 +1,89 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+)
+
+type testTrafficConn struct {
+	net.Conn
+
+	remote net.Addr
+}
+
+func (t testTrafficConn) RemoteAddr() net.Addr {
+	return t.remote
+}
+
+func TestTrafficDial(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+	classified := map[string]net.Addr{}
+	usage := NewTrafficUsage()
+
+	dial := TrafficDial(usage, func(address string, r net.Addr) string {
+		classified[address] = r
+
+		return "group"
+	}, func(
+		ctx context.Context, network string, address string,
+	) (net.Conn, error) {
+		client, server := net.Pipe()
+
+		go func() {
+			defer server.Close()
+
+			b := make([]byte, 5)
+			io.ReadFull(server, b)
+			server.Write([]byte("hi"))
+		}()
+
+		return testTrafficConn{Conn: client, remote: remote}, nil
+	})
+
+	c, err := dial(context.Background(), "tcp", "example.com:22")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Write([]byte("hello"))
+	io.ReadFull(c, make([]byte, 2))
+	c.Close()
+
+	if r := classified["example.com:22"]; r != remote {
+		t.Errorf("Expecting the remote address %v, got %v", remote, r)
+	}
+
+	records := usage.Records()
+	if len(records) != 1 {
+		t.Fatalf("Expecting 1 record, got %v", records)
+	}
+
+	expected := TrafficRecord{
+		Name:        "group",
+		Connections: 1,
+		Sent:        5,
+		Received:    2,
+	}
+	if records[0] != expected {
+		t.Errorf("Expecting %+v, got %+v", expected, records[0])
+	}
+}
@@ -0,0 +1,122 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+)
+
+// Errors
+var (
+	ErrUnixSocketPathEmpty = errors.New(
+		"unix socket path must not be empty")
+
+	ErrUnixSocketPathNotAbsolute = errors.New(
+		"unix socket path must be absolute")
+
+	ErrUnixSocketPathNotClean = errors.New(
+		"unix socket path must be clean, without any \".\" or \"..\" element")
+)
+
+const (
+	unixSocketHostPrefix  = "unix:"
+	unixSocketAliasPrefix = "unix_"
+	unixSocketAliasSuffix = ".sock"
+	unixSocketAliasLen    = 20
+)
+
+// UnixSocketPath returns the path of the unix socket when the given `host` is
+// a unix socket target (i.e. "unix:/path/to/socket")
+func UnixSocketPath(host string) (string, bool) {
+	if !strings.HasPrefix(host, unixSocketHostPrefix) {
+		return "", false
+	}
+
+	return host[len(unixSocketHostPrefix):], true
+}
+
+// VerifyUnixSocketPath returns an error when the given `path` cannot be used
+// as an unix socket target
+func VerifyUnixSocketPath(path string) error {
+	if len(path) <= 0 {
+		return ErrUnixSocketPathEmpty
+	}
+
+	if !filepath.IsAbs(path) {
+		return ErrUnixSocketPathNotAbsolute
+	}
+
+	if filepath.Clean(path) != path {
+		return ErrUnixSocketPathNotClean
+	}
+
+	return nil
+}
+
+// UnixSocketAlias returns a host name which can be used by the client to
+// refer the unix socket of the given `path`. The path itself never leaves the
+// server
+func UnixSocketAlias(path string) string {
+	h := sha256.Sum256([]byte(path))
+
+	return unixSocketAliasPrefix +
+		hex.EncodeToString(h[:])[:unixSocketAliasLen] +
+		unixSocketAliasSuffix
+}
+
+// UnixSockets contains a map of unix socket paths indexed by their alias
+type UnixSockets map[string]string
+
+// Add adds the socket `path` to the list, and returns it's alias
+func (u UnixSockets) Add(path string) string {
+	alias := UnixSocketAlias(path)
+
+	u[alias] = path
+
+	return alias
+}
+
+// UnixSocketDial creates a Dial which dials the unix socket when the host of
+// the address is an alias in the `sockets`, or use `dial` otherwise
+func UnixSocketDial(sockets UnixSockets, dial Dial) Dial {
+	return func(
+		ctx context.Context,
+		network string,
+		address string,
+	) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return dial(ctx, network, address)
+		}
+
+		path, ok := sockets[host]
+		if !ok {
+			return dial(ctx, network, address)
+		}
+
+		d := net.Dialer{}
+
+		return d.DialContext(ctx, "unix", path)
+	}
+}
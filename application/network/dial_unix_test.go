@@ -0,0 +1,82 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixSocketDial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sock")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip("Unix socket is not supported:", err)
+		return
+	}
+	defer l.Close()
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c.Close()
+	}()
+
+	errNotUnix := errors.New("not an unix socket")
+	sockets := UnixSockets{}
+	alias := sockets.Add(path)
+
+	dial := UnixSocketDial(sockets, func(
+		ctx context.Context, network string, address string,
+	) (net.Conn, error) {
+		return nil, errNotUnix
+	})
+
+	c, err := dial(context.Background(), "tcp", net.JoinHostPort(alias, "22"))
+	if err != nil {
+		t.Error("Failed to dial the unix socket:", err)
+		return
+	}
+	c.Close()
+
+	_, err = dial(context.Background(), "tcp", "localhost:22")
+	if err != errNotUnix {
+		t.Errorf("Expecting error %s, got %s", errNotUnix, err)
+		return
+	}
+}
+
+func TestVerifyUnixSocketPath(t *testing.T) {
+	for _, p := range []string{"", "relative.sock", "/tmp/../etc/sock"} {
+		if VerifyUnixSocketPath(p) == nil {
+			t.Errorf("Expecting path %q to be rejected", p)
+			return
+		}
+	}
+
+	if err := VerifyUnixSocketPath("/run/sshd.sock"); err != nil {
+		t.Error("Expecting path to be accepted, got:", err)
+		return
+	}
+}
@@ -0,0 +1,329 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// Errors
+var (
+	ErrWireGuardInvalidKey = errors.New(
+		"WireGuard key must be 32 bytes encoded in base64")
+
+	ErrWireGuardNoAddress = errors.New(
+		"WireGuard tunnel must have at least one address")
+
+	ErrWireGuardNoAllowedIPs = errors.New(
+		"WireGuard peer must have at least one allowed IP")
+)
+
+const (
+	wireGuardDefaultMTU = 1420
+	wireGuardMinMTU     = 576
+	wireGuardKeySize    = 32
+)
+
+// WireGuardPeer is the remote end of a WireGuard tunnel
+type WireGuardPeer struct {
+	PublicKey           string        // Base64 encoded
+	PresharedKey        string        // Base64 encoded, optional
+	Endpoint            string        // Host and port of the peer
+	AllowedIPs          []string      // Networks that are reached through
+	PersistentKeepalive time.Duration // 0 to disable
+}
+
+// WireGuardSettings contains the settings of a userspace WireGuard tunnel
+type WireGuardSettings struct {
+	PrivateKey string   // Base64 encoded
+	Addresses  []string // Addresses of the local end inside the tunnel
+	DNS        []string // Resolvers which are reached through the tunnel
+	MTU        int      // 0 to use the default
+	Peer       WireGuardPeer
+}
+
+// wireGuardKey decodes the base64 encoded WireGuard key `key` to hex, which
+// is the format of the configuration protocol of the device
+func wireGuardKey(key string) (string, error) {
+	k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(k) != wireGuardKeySize {
+		return "", ErrWireGuardInvalidKey
+	}
+
+	return hex.EncodeToString(k), nil
+}
+
+// parseWireGuardAddrs parses the IP addresses in `addrs`
+func parseWireGuardAddrs(addrs []string) ([]netip.Addr, error) {
+	result := make([]netip.Addr, 0, len(addrs))
+
+	for _, a := range addrs {
+		addr, err := netip.ParseAddr(strings.TrimSpace(a))
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, addr)
+	}
+
+	return result, nil
+}
+
+// mtu returns the MTU of the tunnel
+func (w WireGuardSettings) mtu() int {
+	if w.MTU <= 0 {
+		return wireGuardDefaultMTU
+	}
+
+	return w.MTU
+}
+
+// Verify returns an error when the tunnel can't be built with the settings
+func (w WireGuardSettings) Verify() error {
+	if _, err := wireGuardKey(w.PrivateKey); err != nil {
+		return fmt.Errorf("invalid PrivateKey: %s", err)
+	}
+
+	if len(w.Addresses) <= 0 {
+		return ErrWireGuardNoAddress
+	}
+
+	if _, err := parseWireGuardAddrs(w.Addresses); err != nil {
+		return fmt.Errorf("invalid Addresses: %s", err)
+	}
+
+	if _, err := parseWireGuardAddrs(w.DNS); err != nil {
+		return fmt.Errorf("invalid DNS: %s", err)
+	}
+
+	if w.MTU != 0 && (w.MTU < wireGuardMinMTU || w.MTU > 65535) {
+		return fmt.Errorf("MTU must be between %d and 65535",
+			wireGuardMinMTU)
+	}
+
+	if _, err := wireGuardKey(w.Peer.PublicKey); err != nil {
+		return fmt.Errorf("invalid PublicKey of the Peer: %s", err)
+	}
+
+	if len(w.Peer.PresharedKey) > 0 {
+		if _, err := wireGuardKey(w.Peer.PresharedKey); err != nil {
+			return fmt.Errorf("invalid PresharedKey of the Peer: %s", err)
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(w.Peer.Endpoint); err != nil {
+		return fmt.Errorf("invalid Endpoint of the Peer: %s", err)
+	}
+
+	if len(w.Peer.AllowedIPs) <= 0 {
+		return ErrWireGuardNoAllowedIPs
+	}
+
+	for _, a := range w.Peer.AllowedIPs {
+		if _, err := netip.ParsePrefix(strings.TrimSpace(a)); err != nil {
+			return fmt.Errorf("invalid AllowedIPs of the Peer: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// ipcConfig returns the configuration of the device in it's configuration
+// protocol. The Endpoint of the peer is resolved by the `resolver`, as the
+// device only accepts IP addresses
+func (w WireGuardSettings) ipcConfig(
+	ctx context.Context, resolver *net.Resolver) (string, error) {
+	cfg := strings.Builder{}
+
+	privateKey, err := wireGuardKey(w.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	publicKey, err := wireGuardKey(w.Peer.PublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	host, port, err := net.SplitHostPort(w.Peer.Endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	ips, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := net.JoinHostPort(ips[0].Unmap().String(), port)
+
+	fmt.Fprintf(&cfg, "private_key=%s\n", privateKey)
+	fmt.Fprintf(&cfg, "public_key=%s\n", publicKey)
+
+	if len(w.Peer.PresharedKey) > 0 {
+		presharedKey, err := wireGuardKey(w.Peer.PresharedKey)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(&cfg, "preshared_key=%s\n", presharedKey)
+	}
+
+	fmt.Fprintf(&cfg, "endpoint=%s\n", endpoint)
+	fmt.Fprintf(&cfg, "persistent_keepalive_interval=%d\n",
+		int(w.Peer.PersistentKeepalive/time.Second))
+
+	for _, a := range w.Peer.AllowedIPs {
+		fmt.Fprintf(&cfg, "allowed_ip=%s\n", strings.TrimSpace(a))
+	}
+
+	return cfg.String(), nil
+}
+
+// wireGuardTunnel is a WireGuard device with an userspace network stack, so
+// no kernel interface or privilege is needed. It's brought up by the first
+// connection that goes through it
+type wireGuardTunnel struct {
+	settings WireGuardSettings
+	lock     sync.Mutex
+	dev      *device.Device
+	net      *netstack.Net
+}
+
+var (
+	// The tunnels are shared by the Dials which are built with the same
+	// settings (i.e. when the configuration is reloaded), as the peer only
+	// talks to one device of the same key at a time
+	wireGuardTunnelsLock = sync.Mutex{}
+	wireGuardTunnels     = map[string]*wireGuardTunnel{}
+)
+
+// getWireGuardTunnel returns the tunnel of the `settings`
+func getWireGuardTunnel(settings WireGuardSettings) *wireGuardTunnel {
+	key := fmt.Sprintf("%#v", settings)
+
+	wireGuardTunnelsLock.Lock()
+	defer wireGuardTunnelsLock.Unlock()
+
+	if t, ok := wireGuardTunnels[key]; ok {
+		return t
+	}
+
+	t := &wireGuardTunnel{
+		settings: settings,
+		lock:     sync.Mutex{},
+		dev:      nil,
+		net:      nil,
+	}
+
+	wireGuardTunnels[key] = t
+
+	return t
+}
+
+// up brings the tunnel up if it's not already, and returns it's network
+func (w *wireGuardTunnel) up(ctx context.Context) (*netstack.Net, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.net != nil {
+		return w.net, nil
+	}
+
+	addrs, err := parseWireGuardAddrs(w.settings.Addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	dns, err := parseWireGuardAddrs(w.settings.DNS)
+	if err != nil {
+		return nil, err
+	}
+
+	ipcCfg, err := w.settings.ipcConfig(ctx, net.DefaultResolver)
+	if err != nil {
+		return nil, err
+	}
+
+	tunDev, tunNet, err := netstack.CreateNetTUN(addrs, dns, w.settings.mtu())
+	if err != nil {
+		return nil, err
+	}
+
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(),
+		device.NewLogger(device.LogLevelSilent, ""))
+
+	if err := dev.IpcSet(ipcCfg); err != nil {
+		dev.Close()
+
+		return nil, err
+	}
+
+	if err := dev.Up(); err != nil {
+		dev.Close()
+
+		return nil, err
+	}
+
+	w.dev = dev
+	w.net = tunNet
+
+	return w.net, nil
+}
+
+// WireGuardDial creates a Dial which reaches the `targets` through the
+// WireGuard tunnel built with the `settings`, or use `dial` for others. The
+// names of the targets are resolved by the DNS of the tunnel
+func WireGuardDial(
+	settings WireGuardSettings,
+	targets AllowedTargets,
+	dial Dial,
+) Dial {
+	tunnel := getWireGuardTunnel(settings)
+
+	return func(
+		ctx context.Context,
+		network string,
+		address string,
+	) (net.Conn, error) {
+		if !targets.Allowed(dialTargetFrom(ctx, address)) {
+			return dial(ctx, network, address)
+		}
+
+		tunNet, err := tunnel.up(ctx)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to bring up the WireGuard tunnel: %s", err)
+		}
+
+		return tunNet.DialContext(ctx, network, address)
+	}
+}
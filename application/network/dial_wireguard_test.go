@@ -0,0 +1,225 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// testWireGuardKeys generates a WireGuard key pair, encoded in base64
+func testWireGuardKeys(t *testing.T) (string, string) {
+	private := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(private); err != nil {
+		t.Fatal("Failed to generate key:", err)
+	}
+
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		t.Fatal("Failed to generate key:", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(private),
+		base64.StdEncoding.EncodeToString(public)
+}
+
+// testWireGuardPeer starts a WireGuard device which accepts the
+// `clientPublicKey` from 10.7.0.2, and echos the data sent to 10.7.0.1:22.
+// It returns the UDP port of the device
+func testWireGuardPeer(
+	t *testing.T, privateKey, clientPublicKey string) string {
+	tunDev, tunNet, err := netstack.CreateNetTUN(
+		[]netip.Addr{netip.MustParseAddr("10.7.0.1")}, nil, 1420)
+	if err != nil {
+		t.Fatal("Failed to create tunnel:", err)
+	}
+
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(),
+		device.NewLogger(device.LogLevelSilent, ""))
+	t.Cleanup(dev.Close)
+
+	privateHex, _ := wireGuardKey(privateKey)
+	clientHex, _ := wireGuardKey(clientPublicKey)
+
+	err = dev.IpcSet("private_key=" + privateHex + "\nlisten_port=0\n" +
+		"public_key=" + clientHex + "\nallowed_ip=10.7.0.2/32\n")
+	if err != nil {
+		t.Fatal("Failed to configure device:", err)
+	}
+
+	if err = dev.Up(); err != nil {
+		t.Fatal("Failed to bring up device:", err)
+	}
+
+	listener, err := tunNet.ListenTCP(&net.TCPAddr{
+		IP: net.ParseIP("10.7.0.1"), Port: 22})
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			c, aErr := listener.Accept()
+			if aErr != nil {
+				return
+			}
+
+			go func() {
+				defer c.Close()
+
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	ipc, err := dev.IpcGet()
+	if err != nil {
+		t.Fatal("Failed to read device configuration:", err)
+	}
+
+	for _, line := range strings.Split(ipc, "\n") {
+		if port, ok := strings.CutPrefix(line, "listen_port="); ok {
+			return port
+		}
+	}
+
+	t.Fatal("Listening port of the device is unknown")
+
+	return ""
+}
+
+func TestWireGuardDial(t *testing.T) {
+	peerPrivate, peerPublic := testWireGuardKeys(t)
+	clientPrivate, clientPublic := testWireGuardKeys(t)
+
+	port := testWireGuardPeer(t, peerPrivate, clientPublic)
+
+	dial := WireGuardDial(WireGuardSettings{
+		PrivateKey: clientPrivate,
+		Addresses:  []string{"10.7.0.2"},
+		Peer: WireGuardPeer{
+			PublicKey:  peerPublic,
+			Endpoint:   "127.0.0.1:" + port,
+			AllowedIPs: []string{"10.7.0.0/24"},
+		},
+	}, AllowedTargets{
+		{Protocol: "SSH", Address: "10.7.0.1:22"}: {},
+	}, func(
+		ctx context.Context, network string, address string,
+	) (net.Conn, error) {
+		return nil, ErrAccessControlDialTargetHostNotAllowed
+	})
+
+	// Same address, but another protocol
+	_, err := dial(WithDialProtocol(context.Background(), "Telnet"),
+		"tcp", "10.7.0.1:22")
+	if err != ErrAccessControlDialTargetHostNotAllowed {
+		t.Errorf("Expecting the tunnel to be skipped, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(
+		WithDialProtocol(context.Background(), "SSH"), 10*time.Second)
+	defer cancel()
+
+	c, err := dial(ctx, "tcp", "10.7.0.1:22")
+	if err != nil {
+		t.Fatal("Failed to dial through the tunnel:", err)
+	}
+	defer c.Close()
+
+	if _, err = c.Write([]byte("Hello")); err != nil {
+		t.Fatal("Failed to write:", err)
+	}
+
+	c.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(c, buf); err != nil {
+		t.Fatal("Failed to read:", err)
+	}
+
+	if string(buf) != "Hello" {
+		t.Errorf("Expecting \"Hello\", got %q", buf)
+	}
+}
+
+func TestWireGuardSettingsVerify(t *testing.T) {
+	private, public := testWireGuardKeys(t)
+
+	valid := WireGuardSettings{
+		PrivateKey: private,
+		Addresses:  []string{"10.7.0.2", "fd00::2"},
+		Peer: WireGuardPeer{
+			PublicKey:  public,
+			Endpoint:   "vpn.example.com:51820",
+			AllowedIPs: []string{"10.7.0.0/24"},
+		},
+	}
+
+	if err := valid.Verify(); err != nil {
+		t.Error("Expecting the settings to be valid, got:", err)
+	}
+
+	for name, modify := range map[string]func(w *WireGuardSettings){
+		"PrivateKey": func(w *WireGuardSettings) {
+			w.PrivateKey = "c2hvcnQ="
+		},
+		"Addresses": func(w *WireGuardSettings) {
+			w.Addresses = nil
+		},
+		"DNS": func(w *WireGuardSettings) {
+			w.DNS = []string{"dns.example.com"}
+		},
+		"MTU": func(w *WireGuardSettings) {
+			w.MTU = 100
+		},
+		"PublicKey": func(w *WireGuardSettings) {
+			w.Peer.PublicKey = ""
+		},
+		"PresharedKey": func(w *WireGuardSettings) {
+			w.Peer.PresharedKey = "invalid"
+		},
+		"Endpoint": func(w *WireGuardSettings) {
+			w.Peer.Endpoint = "vpn.example.com"
+		},
+		"AllowedIPs": func(w *WireGuardSettings) {
+			w.Peer.AllowedIPs = []string{"10.7.0.1"}
+		},
+	} {
+		w := valid
+		w.Addresses = append([]string{}, valid.Addresses...)
+		modify(&w)
+
+		if err := w.Verify(); err == nil {
+			t.Errorf("Expecting invalid %s to be rejected", name)
+		}
+	}
+}
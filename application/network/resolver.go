@@ -0,0 +1,297 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type resolverTTLContextKey struct{}
+
+// resolverTTL collects the TTL of the DNS responses received during a lookup
+type resolverTTL struct {
+	lock         sync.Mutex
+	ttl          uint32
+	ttlSeen      bool
+	negative     uint32
+	negativeSeen bool
+}
+
+// observe parses the DNS response `msg`, and records the TTL from it
+func (r *resolverTTL) observe(msg []byte) {
+	p := dnsmessage.Parser{}
+
+	h, err := p.Start(msg)
+	if err != nil || !h.Response {
+		return
+	}
+
+	if p.SkipAllQuestions() != nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for {
+		ah, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		} else if err != nil {
+			return
+		}
+
+		if ah.Type == dnsmessage.TypeA || ah.Type == dnsmessage.TypeAAAA {
+			if !r.ttlSeen || ah.TTL < r.ttl {
+				r.ttl = ah.TTL
+				r.ttlSeen = true
+			}
+		}
+
+		if p.SkipAnswer() != nil {
+			return
+		}
+	}
+
+	// The TTL of a negative answer is the smaller one of the SOA record's
+	// TTL and it's MINIMUM field (RFC 2308)
+	for {
+		ah, err := p.AuthorityHeader()
+		if err != nil {
+			return
+		}
+
+		if ah.Type != dnsmessage.TypeSOA {
+			if p.SkipAuthority() != nil {
+				return
+			}
+
+			continue
+		}
+
+		soa, err := p.SOAResource()
+		if err != nil {
+			return
+		}
+
+		ttl := min(ah.TTL, soa.MinTTL)
+		if !r.negativeSeen || ttl < r.negative {
+			r.negative = ttl
+			r.negativeSeen = true
+		}
+	}
+}
+
+// resolverConn observes the DNS responses passing through the connection
+type resolverConn struct {
+	net.Conn
+
+	ttl    *resolverTTL
+	stream bool
+	buf    []byte
+}
+
+func (r *resolverConn) Read(b []byte) (int, error) {
+	rLen, rErr := r.Conn.Read(b)
+	if rLen <= 0 {
+		return rLen, rErr
+	}
+
+	if !r.stream {
+		r.ttl.observe(b[:rLen])
+
+		return rLen, rErr
+	}
+
+	// DNS over TCP, each message is prefixed with a 2 bytes length
+	r.buf = append(r.buf, b[:rLen]...)
+
+	for len(r.buf) >= 2 {
+		mLen := int(r.buf[0])<<8 | int(r.buf[1])
+		if len(r.buf) < mLen+2 {
+			break
+		}
+
+		r.ttl.observe(r.buf[2 : mLen+2])
+		r.buf = r.buf[mLen+2:]
+	}
+
+	return rLen, rErr
+}
+
+type resolverEntry struct {
+	addrs   []net.IPAddr
+	err     error
+	expires time.Time
+}
+
+// Resolver resolves host names with an in-process cache. The cache respects
+// the TTL of the DNS records (capped by the maxTTL), and failed lookups of
+// non-existing hosts are cached as well (negative caching).
+//
+// All methods are safe to call on a nil Resolver, in which case the host is
+// resolved by the net.DefaultResolver without caching
+type Resolver struct {
+	lock        sync.Mutex
+	resolver    *net.Resolver
+	entries     map[string]resolverEntry
+	size        int
+	maxTTL      time.Duration
+	negativeTTL time.Duration
+}
+
+// NewResolver creates a new Resolver that caches at most `size` hosts. It
+// returns nil when `size` is not positive
+func NewResolver(
+	size int,
+	maxTTL time.Duration,
+	negativeTTL time.Duration,
+) *Resolver {
+	if size <= 0 {
+		return nil
+	}
+
+	dialer := net.Dialer{}
+
+	return &Resolver{
+		lock: sync.Mutex{},
+		resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(
+				ctx context.Context,
+				network string,
+				address string,
+			) (net.Conn, error) {
+				conn, err := dialer.DialContext(ctx, network, address)
+				if err != nil {
+					return nil, err
+				}
+
+				ttl, ok := ctx.Value(resolverTTLContextKey{}).(*resolverTTL)
+				if !ok {
+					return conn, nil
+				}
+
+				return &resolverConn{
+					Conn:   conn,
+					ttl:    ttl,
+					stream: strings.HasPrefix(network, "tcp"),
+					buf:    nil,
+				}, nil
+			},
+		},
+		entries:     make(map[string]resolverEntry, size),
+		size:        size,
+		maxTTL:      maxTTL,
+		negativeTTL: negativeTTL,
+	}
+}
+
+// evict removes expired entries, or the one that's about to expire when the
+// cache is still full. Caller must hold the lock
+func (r *Resolver) evict(now time.Time) {
+	if len(r.entries) < r.size {
+		return
+	}
+
+	oldest := ""
+	oldestExpires := time.Time{}
+
+	for host, e := range r.entries {
+		if !e.expires.After(now) {
+			delete(r.entries, host)
+			continue
+		}
+
+		if len(oldest) <= 0 || e.expires.Before(oldestExpires) {
+			oldest = host
+			oldestExpires = e.expires
+		}
+	}
+
+	if len(r.entries) >= r.size && len(oldest) > 0 {
+		delete(r.entries, oldest)
+	}
+}
+
+// LookupIPAddr resolves the `host`
+func (r *Resolver) LookupIPAddr(
+	ctx context.Context,
+	host string,
+) ([]net.IPAddr, error) {
+	if r == nil {
+		return net.DefaultResolver.LookupIPAddr(ctx, host)
+	}
+
+	host = strings.ToLower(host)
+	now := time.Now()
+
+	r.lock.Lock()
+	e, ok := r.entries[host]
+	r.lock.Unlock()
+
+	if ok && e.expires.After(now) {
+		return e.addrs, e.err
+	}
+
+	ttl := &resolverTTL{}
+	addrs, err := r.resolver.LookupIPAddr(
+		context.WithValue(ctx, resolverTTLContextKey{}, ttl), host)
+
+	var keep time.Duration
+
+	if err == nil {
+		keep = r.maxTTL
+		if ttl.ttlSeen {
+			keep = min(time.Duration(ttl.ttl)*time.Second, r.maxTTL)
+		}
+	} else {
+		dnsErr := &net.DNSError{}
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return nil, err // Temporary failures are not cached
+		}
+
+		keep = r.negativeTTL
+		if ttl.negativeSeen {
+			keep = min(time.Duration(ttl.negative)*time.Second, keep)
+		}
+	}
+
+	if keep <= 0 {
+		return addrs, err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.evict(now)
+	r.entries[host] = resolverEntry{
+		addrs:   addrs,
+		err:     err,
+		expires: now.Add(keep),
+	}
+
+	return addrs, err
+}
@@ -0,0 +1,132 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func buildResolverTestResponse(
+	t *testing.T,
+	build func(*dnsmessage.Builder),
+) []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true})
+	b.EnableCompression()
+
+	if err := b.StartQuestions(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName("example.com."),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	build(&b)
+
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+func TestResolverTTLObserveAnswers(t *testing.T) {
+	msg := buildResolverTestResponse(t, func(b *dnsmessage.Builder) {
+		if err := b.StartAnswers(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, ttl := range []uint32{120, 60, 300} {
+			if err := b.AResource(dnsmessage.ResourceHeader{
+				Name:  dnsmessage.MustNewName("example.com."),
+				Class: dnsmessage.ClassINET,
+				TTL:   ttl,
+			}, dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	ttl := resolverTTL{}
+	ttl.observe(msg)
+
+	if !ttl.ttlSeen || ttl.ttl != 60 {
+		t.Errorf("Expecting TTL 60, got %d (seen: %v)", ttl.ttl, ttl.ttlSeen)
+	}
+
+	if ttl.negativeSeen {
+		t.Error("Expecting no negative TTL")
+	}
+}
+
+func TestResolverTTLObserveNegative(t *testing.T) {
+	msg := buildResolverTestResponse(t, func(b *dnsmessage.Builder) {
+		if err := b.StartAuthorities(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.SOAResource(dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName("example.com."),
+			Class: dnsmessage.ClassINET,
+			TTL:   3600,
+		}, dnsmessage.SOAResource{
+			NS:     dnsmessage.MustNewName("ns.example.com."),
+			MBox:   dnsmessage.MustNewName("admin.example.com."),
+			MinTTL: 45,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	ttl := resolverTTL{}
+	ttl.observe(msg)
+
+	if ttl.ttlSeen {
+		t.Error("Expecting no positive TTL")
+	}
+
+	if !ttl.negativeSeen || ttl.negative != 45 {
+		t.Errorf("Expecting negative TTL 45, got %d (seen: %v)",
+			ttl.negative, ttl.negativeSeen)
+	}
+}
+
+func TestResolverDisabled(t *testing.T) {
+	r := NewResolver(0, 0, 0)
+	if r != nil {
+		t.Fatal("Expecting a nil Resolver when the size is 0")
+	}
+
+	addrs, err := r.LookupIPAddr(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(addrs) != 1 || !addrs[0].IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Unexpected result %v", addrs)
+	}
+}
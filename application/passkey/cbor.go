@@ -0,0 +1,185 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package passkey
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Errors
+var (
+	ErrCBORTruncated = errors.New(
+		"truncated CBOR data")
+
+	ErrCBORUnsupported = errors.New(
+		"unsupported CBOR data")
+
+	ErrCBORTooDeep = errors.New(
+		"CBOR data is nested too deep")
+)
+
+const (
+	cborMaxDepth = 16
+)
+
+// cborDecode decodes the first CBOR item of `b`, and returns it along with
+// the amount of bytes it took.
+//
+// Only the subset of CBOR which is used by WebAuthn is supported. Integers
+// are decoded as int64, byte strings as []byte, text strings as string,
+// arrays as []interface{} and maps as map[interface{}]interface{}
+func cborDecode(b []byte) (interface{}, int, error) {
+	return cborDecodeItem(b, 0)
+}
+
+// cborHead decodes the head of the item, returns the major type, the
+// argument and the size of the head
+func cborHead(b []byte) (byte, uint64, int, error) {
+	if len(b) < 1 {
+		return 0, 0, 0, ErrCBORTruncated
+	}
+
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+
+	switch {
+	case info < 24:
+		return major, uint64(info), 1, nil
+
+	case info == 24:
+		if len(b) < 2 {
+			return 0, 0, 0, ErrCBORTruncated
+		}
+		return major, uint64(b[1]), 2, nil
+
+	case info == 25:
+		if len(b) < 3 {
+			return 0, 0, 0, ErrCBORTruncated
+		}
+		return major, uint64(binary.BigEndian.Uint16(b[1:])), 3, nil
+
+	case info == 26:
+		if len(b) < 5 {
+			return 0, 0, 0, ErrCBORTruncated
+		}
+		return major, uint64(binary.BigEndian.Uint32(b[1:])), 5, nil
+
+	case info == 27:
+		if len(b) < 9 {
+			return 0, 0, 0, ErrCBORTruncated
+		}
+		return major, binary.BigEndian.Uint64(b[1:]), 9, nil
+
+	default:
+		// Indefinite lengths are not used by WebAuthn
+		return 0, 0, 0, ErrCBORUnsupported
+	}
+}
+
+func cborDecodeItem(b []byte, depth int) (interface{}, int, error) {
+	if depth > cborMaxDepth {
+		return nil, 0, ErrCBORTooDeep
+	}
+
+	major, arg, n, err := cborHead(b)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, 0, ErrCBORUnsupported
+		}
+		return int64(arg), n, nil
+
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, 0, ErrCBORUnsupported
+		}
+		return -1 - int64(arg), n, nil
+
+	case 2, 3:
+		if arg > uint64(len(b)-n) {
+			return nil, 0, ErrCBORTruncated
+		}
+		data := b[n : n+int(arg)]
+		if major == 3 {
+			return string(data), n + int(arg), nil
+		}
+		return append([]byte{}, data...), n + int(arg), nil
+
+	case 4:
+		// Each item takes at least one byte
+		if arg > uint64(len(b)-n) {
+			return nil, 0, ErrCBORTruncated
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, size, err := cborDecodeItem(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			n += size
+		}
+		return items, n, nil
+
+	case 5:
+		if arg > uint64(len(b)-n)/2 {
+			return nil, 0, ErrCBORTruncated
+		}
+		items := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, size, err := cborDecodeItem(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += size
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, 0, ErrCBORUnsupported
+			}
+			val, size, err := cborDecodeItem(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += size
+			items[key] = val
+		}
+		return items, n, nil
+
+	case 7:
+		switch arg {
+		case 20:
+			return false, n, nil
+		case 21:
+			return true, n, nil
+		case 22, 23:
+			return nil, n, nil
+		}
+		return nil, 0, ErrCBORUnsupported
+
+	default:
+		// Tags are not used by WebAuthn
+		return nil, 0, ErrCBORUnsupported
+	}
+}
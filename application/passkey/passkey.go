@@ -0,0 +1,576 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package passkey allows users to authenticate to Sshwifty itself through
+// WebAuthn passkeys
+package passkey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrChallengeNotFound = errors.New(
+		"the challenge was not found or has expired")
+
+	ErrSignCountNotIncreased = errors.New(
+		"the signature counter of the passkey did not increase, the " +
+			"passkey may have been cloned")
+
+	ErrUserMismatch = errors.New(
+		"the passkey doesn't belong to the user")
+
+	ErrEnrollmentNotFound = errors.New(
+		"the enrollment was not found or has expired")
+)
+
+const (
+	challengeSize     = 32
+	challengeLifetime = 2 * time.Minute
+	sessionIDSize     = 32
+	sessionKeySize    = 32
+	credentialIDMax   = 1023
+
+	enrollmentCodeSize = 24
+	enrollmentLifetime = 24 * time.Hour
+)
+
+type ceremony int
+
+const (
+	ceremonyRegister ceremony = iota
+	ceremonyLogin
+)
+
+type challenge struct {
+	ceremony ceremony
+	user     string
+	expires  time.Time
+}
+
+// Session is a login session created by a passkey
+type Session struct {
+	ID      string
+	User    string
+	Key     string
+	Expires time.Time
+}
+
+// Parameter is a public key parameter of the registration options
+type Parameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// Descriptor describes an existing passkey
+type Descriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// RegistrationOptions is the options of navigator.credentials.create, all
+// binary values are base64url encoded
+type RegistrationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams   []Parameter  `json:"pubKeyCredParams"`
+	Timeout            int64        `json:"timeout"`
+	ExcludeCredentials []Descriptor `json:"excludeCredentials"`
+	Attestation        string       `json:"attestation"`
+}
+
+// RegistrationResponse is the response of navigator.credentials.create, all
+// binary values are base64url encoded
+type RegistrationResponse struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+// LoginOptions is the options of navigator.credentials.get, all binary
+// values are base64url encoded
+type LoginOptions struct {
+	Challenge        string `json:"challenge"`
+	RPID             string `json:"rpId"`
+	Timeout          int64  `json:"timeout"`
+	UserVerification string `json:"userVerification"`
+}
+
+// LoginResponse is the response of navigator.credentials.get, all binary
+// values are base64url encoded
+type LoginResponse struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+}
+
+// Enrollment allows the user to register a passkey of his own once. It's
+// issued by the administrator, so the users who can't be identified
+// otherwise can register their first passkey
+type Enrollment struct {
+	Code    string    `json:"code"`
+	User    string    `json:"user"`
+	Expires time.Time `json:"expires"`
+}
+
+// RelyingParty registers passkeys and authenticates users with them
+type RelyingParty struct {
+	name            string
+	store           Store
+	sessionLifetime time.Duration
+	lock            sync.Mutex
+	challenges      map[string]challenge
+	sessions        map[string]Session
+	enrollments     map[string]Enrollment
+	now             func() time.Time
+}
+
+// New creates a new RelyingParty which keeps passkeys in the `store`, and
+// creates login sessions that lasts for `sessionLifetime`
+func New(
+	name string,
+	store Store,
+	sessionLifetime time.Duration,
+) *RelyingParty {
+	return &RelyingParty{
+		name:            name,
+		store:           store,
+		sessionLifetime: sessionLifetime,
+		lock:            sync.Mutex{},
+		challenges:      map[string]challenge{},
+		sessions:        map[string]Session{},
+		enrollments:     map[string]Enrollment{},
+		now:             time.Now,
+	}
+}
+
+func random(size int) ([]byte, error) {
+	b := make([]byte, size)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// expire removes all expired challenges and sessions. Caller must hold the
+// lock
+func (p *RelyingParty) expire(now time.Time) {
+	for k, c := range p.challenges {
+		if now.After(c.expires) {
+			delete(p.challenges, k)
+		}
+	}
+
+	for k, s := range p.sessions {
+		if now.After(s.Expires) {
+			delete(p.sessions, k)
+		}
+	}
+
+	for k, e := range p.enrollments {
+		if now.After(e.Expires) {
+			delete(p.enrollments, k)
+		}
+	}
+}
+
+func (p *RelyingParty) challenge(c ceremony, user string) (string, error) {
+	b, err := random(challengeSize)
+	if err != nil {
+		return "", err
+	}
+
+	now := p.now()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.expire(now)
+
+	p.challenges[string(b)] = challenge{
+		ceremony: c,
+		user:     user,
+		expires:  now.Add(challengeLifetime),
+	}
+
+	return Encode(b), nil
+}
+
+// consume removes the challenge `b` and returns it. A challenge can only be
+// consumed once
+func (p *RelyingParty) consume(b []byte, c ceremony) (challenge, error) {
+	now := p.now()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.expire(now)
+
+	ch, ok := p.challenges[string(b)]
+	if !ok || ch.ceremony != c {
+		return challenge{}, ErrChallengeNotFound
+	}
+
+	delete(p.challenges, string(b))
+
+	return ch, nil
+}
+
+// BeginRegistration starts the registration of a new passkey for the `user`
+func (p *RelyingParty) BeginRegistration(
+	rpID string,
+	user string,
+) (RegistrationOptions, error) {
+	existing, err := p.store.List(user)
+	if err != nil {
+		return RegistrationOptions{}, err
+	}
+
+	c, err := p.challenge(ceremonyRegister, user)
+	if err != nil {
+		return RegistrationOptions{}, err
+	}
+
+	userID := sha256.Sum256([]byte(user))
+
+	opts := RegistrationOptions{
+		Challenge:          c,
+		PubKeyCredParams:   make([]Parameter, 0, len(Algorithms())),
+		Timeout:            challengeLifetime.Milliseconds(),
+		ExcludeCredentials: make([]Descriptor, 0, len(existing)),
+		Attestation:        "none",
+	}
+	opts.RP.ID = rpID
+	opts.RP.Name = p.name
+	opts.User.ID = Encode(userID[:16])
+	opts.User.Name = user
+	opts.User.DisplayName = user
+
+	for _, alg := range Algorithms() {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, Parameter{
+			Type: "public-key",
+			Alg:  alg,
+		})
+	}
+
+	for _, e := range existing {
+		opts.ExcludeCredentials = append(opts.ExcludeCredentials, Descriptor{
+			Type: "public-key",
+			ID:   e.ID,
+		})
+	}
+
+	return opts, nil
+}
+
+// FinishRegistration verifies the registration response and stores the new
+// passkey. Attestation statements are not verified, passkeys are accepted
+// as they're self-attested
+func (p *RelyingParty) FinishRegistration(
+	rpID string,
+	user string,
+	r RegistrationResponse,
+) (Credential, error) {
+	rawClientData, err := Decode(r.ClientDataJSON)
+	if err != nil {
+		return Credential{}, ErrInvalidClientData
+	}
+
+	challengeBytes, err := verifyClientData(
+		rawClientData, clientDataTypeCreate, rpID)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	ch, err := p.consume(challengeBytes, ceremonyRegister)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if ch.user != user {
+		return Credential{}, ErrUserMismatch
+	}
+
+	rawAttestation, err := Decode(r.AttestationObject)
+	if err != nil {
+		return Credential{}, ErrInvalidAttestation
+	}
+
+	attestation, _, err := cborDecode(rawAttestation)
+	if err != nil {
+		return Credential{}, fmt.Errorf("%s: %s", ErrInvalidAttestation, err)
+	}
+
+	attestationMap, ok := attestation.(map[interface{}]interface{})
+	if !ok {
+		return Credential{}, ErrInvalidAttestation
+	}
+
+	rawAuthData, ok := attestationMap["authData"].([]byte)
+	if !ok {
+		return Credential{}, ErrInvalidAttestation
+	}
+
+	authData, err := parseAuthData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	err = authData.verify(rpID)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if authData.publicKey == nil ||
+		len(authData.credentialID) <= 0 ||
+		len(authData.credentialID) > credentialIDMax {
+		return Credential{}, fmt.Errorf("%s: missing credential",
+			ErrInvalidAuthData)
+	}
+
+	publicKey, err := marshalPublicKey(authData.publicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	now := p.now().UTC()
+
+	c := Credential{
+		ID:        Encode(authData.credentialID),
+		User:      user,
+		PublicKey: publicKey,
+		SignCount: authData.signCount,
+		Added:     now,
+		LastUsed:  now,
+	}
+
+	err = p.store.Add(c)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	return c, nil
+}
+
+// BeginLogin starts a login. The passkey is discovered by the browser, so
+// no user needs to be specified
+func (p *RelyingParty) BeginLogin(rpID string) (LoginOptions, error) {
+	c, err := p.challenge(ceremonyLogin, "")
+	if err != nil {
+		return LoginOptions{}, err
+	}
+
+	return LoginOptions{
+		Challenge:        c,
+		RPID:             rpID,
+		Timeout:          challengeLifetime.Milliseconds(),
+		UserVerification: "preferred",
+	}, nil
+}
+
+// FinishLogin verifies the login response and creates a new login Session
+func (p *RelyingParty) FinishLogin(
+	rpID string,
+	r LoginResponse,
+) (Session, error) {
+	rawClientData, err := Decode(r.ClientDataJSON)
+	if err != nil {
+		return Session{}, ErrInvalidClientData
+	}
+
+	challengeBytes, err := verifyClientData(
+		rawClientData, clientDataTypeGet, rpID)
+	if err != nil {
+		return Session{}, err
+	}
+
+	_, err = p.consume(challengeBytes, ceremonyLogin)
+	if err != nil {
+		return Session{}, err
+	}
+
+	c, err := p.store.Get(r.ID)
+	if err != nil {
+		return Session{}, err
+	}
+
+	rawAuthData, err := Decode(r.AuthenticatorData)
+	if err != nil {
+		return Session{}, ErrInvalidAuthData
+	}
+
+	authData, err := parseAuthData(rawAuthData)
+	if err != nil {
+		return Session{}, err
+	}
+
+	err = authData.verify(rpID)
+	if err != nil {
+		return Session{}, err
+	}
+
+	signature, err := Decode(r.Signature)
+	if err != nil {
+		return Session{}, ErrInvalidSignature
+	}
+
+	publicKey, err := parsePublicKey(c.PublicKey)
+	if err != nil {
+		return Session{}, err
+	}
+
+	clientDataHash := sha256.Sum256(rawClientData)
+
+	err = verifySignature(
+		publicKey, rawAuthData, clientDataHash[:], signature)
+	if err != nil {
+		return Session{}, err
+	}
+
+	// Authenticators that don't implement the counter always report 0
+	if (authData.signCount > 0 || c.SignCount > 0) &&
+		authData.signCount <= c.SignCount {
+		return Session{}, ErrSignCountNotIncreased
+	}
+
+	c.SignCount = authData.signCount
+	c.LastUsed = p.now().UTC()
+
+	err = p.store.Update(c)
+	if err != nil {
+		return Session{}, err
+	}
+
+	return p.createSession(c.User)
+}
+
+func (p *RelyingParty) createSession(user string) (Session, error) {
+	id, err := random(sessionIDSize)
+	if err != nil {
+		return Session{}, err
+	}
+
+	key, err := random(sessionKeySize)
+	if err != nil {
+		return Session{}, err
+	}
+
+	now := p.now()
+
+	s := Session{
+		ID:      Encode(id),
+		User:    user,
+		Key:     Encode(key),
+		Expires: now.Add(p.sessionLifetime),
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.expire(now)
+
+	p.sessions[s.ID] = s
+
+	return s, nil
+}
+
+// Session returns the unexpired login Session of the given ID
+func (p *RelyingParty) Session(id string) (Session, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	s, ok := p.sessions[id]
+	if !ok || p.now().After(s.Expires) {
+		return Session{}, false
+	}
+
+	return s, true
+}
+
+// Logout removes the login Session of the given ID
+func (p *RelyingParty) Logout(id string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.sessions, id)
+}
+
+// Enroll issues an Enrollment which allows a passkey to be registered for
+// the `user` once
+func (p *RelyingParty) Enroll(user string) (Enrollment, error) {
+	code, err := random(enrollmentCodeSize)
+	if err != nil {
+		return Enrollment{}, err
+	}
+
+	now := p.now()
+
+	e := Enrollment{
+		Code:    Encode(code),
+		User:    user,
+		Expires: now.Add(enrollmentLifetime),
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.expire(now)
+
+	p.enrollments[e.Code] = e
+
+	return e, nil
+}
+
+// Enrolled returns the user of the unexpired Enrollment of the given `code`
+func (p *RelyingParty) Enrolled(code string) (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	e, ok := p.enrollments[code]
+	if !ok || p.now().After(e.Expires) {
+		return "", ErrEnrollmentNotFound
+	}
+
+	return e.User, nil
+}
+
+// Unenroll removes the Enrollment of the given `code`, once it has been
+// used
+func (p *RelyingParty) Unenroll(code string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.enrollments, code)
+}
@@ -0,0 +1,312 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package passkey
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCBORPair struct {
+	k interface{}
+	v interface{}
+}
+
+func testCBORHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	default:
+		b := []byte{major<<5 | 25, 0, 0}
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		return b
+	}
+}
+
+func testCBOREncode(v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return testCBORHead(1, uint64(-1-v))
+		}
+		return testCBORHead(0, uint64(v))
+	case []byte:
+		return append(testCBORHead(2, uint64(len(v))), v...)
+	case string:
+		return append(testCBORHead(3, uint64(len(v))), v...)
+	case []testCBORPair:
+		b := testCBORHead(5, uint64(len(v)))
+		for _, p := range v {
+			b = append(b, testCBOREncode(p.k)...)
+			b = append(b, testCBOREncode(p.v)...)
+		}
+		return b
+	default:
+		panic("unsupported")
+	}
+}
+
+type testAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	signCount uint32
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testAuthenticator{key: k, id: []byte("credential-id")}
+}
+
+func (a *testAuthenticator) authData(rpID string, attested bool) []byte {
+	h := sha256.Sum256([]byte(rpID))
+	b := append([]byte{}, h[:]...)
+	flags := byte(authDataFlagUserPresent)
+	if attested {
+		flags |= authDataFlagAttestedCredData
+	}
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint32(b, a.signCount)
+
+	if !attested {
+		return b
+	}
+
+	b = append(b, make([]byte, 16)...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(a.id)))
+	b = append(b, a.id...)
+
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+
+	return append(b, testCBOREncode([]testCBORPair{
+		{1, coseKeyTypeEC2},
+		{3, coseAlgES256},
+		{-1, 1},
+		{-2, x},
+		{-3, y},
+	})...)
+}
+
+func testClientData(t, challenge, origin string) []byte {
+	b, _ := json.Marshal(clientData{
+		Type:      t,
+		Challenge: challenge,
+		Origin:    origin,
+	})
+
+	return b
+}
+
+func (a *testAuthenticator) register(
+	rpID, challenge, origin string) RegistrationResponse {
+	return RegistrationResponse{
+		ID: Encode(a.id),
+		ClientDataJSON: Encode(
+			testClientData(clientDataTypeCreate, challenge, origin)),
+		AttestationObject: Encode(testCBOREncode([]testCBORPair{
+			{"fmt", "none"},
+			{"attStmt", []testCBORPair{}},
+			{"authData", a.authData(rpID, true)},
+		})),
+	}
+}
+
+func (a *testAuthenticator) login(
+	t *testing.T, rpID, challenge, origin string) LoginResponse {
+	a.signCount++
+
+	clientData := testClientData(clientDataTypeGet, challenge, origin)
+	authData := a.authData(rpID, false)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return LoginResponse{
+		ID:                Encode(a.id),
+		ClientDataJSON:    Encode(clientData),
+		AuthenticatorData: Encode(authData),
+		Signature:         Encode(sig),
+	}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	const rpID = "sshwifty.example.com"
+	const origin = "https://sshwifty.example.com:8182"
+
+	store, err := Open(filepath.Join(t.TempDir(), "passkeys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rp := New("Sshwifty", store, time.Hour)
+	a := newTestAuthenticator(t)
+
+	regOpts, err := rp.BeginRegistration(rpID, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := a.register(rpID, regOpts.Challenge, origin)
+
+	c, err := rp.FinishRegistration(rpID, "alice", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.ID != Encode(a.id) || c.User != "alice" {
+		t.Errorf("Unexpected credential %+v", c)
+	}
+
+	// The challenge can only be used once
+	_, err = rp.FinishRegistration(rpID, "alice", reg)
+	if err != ErrChallengeNotFound {
+		t.Errorf("Expecting ErrChallengeNotFound, got %v", err)
+	}
+
+	loginOpts, err := rp.BeginLogin(rpID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := rp.FinishLogin(
+		rpID, a.login(t, rpID, loginOpts.Challenge, origin))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.User != "alice" || len(session.Key) <= 0 {
+		t.Errorf("Unexpected session %+v", session)
+	}
+
+	if s, ok := rp.Session(session.ID); !ok || s.Key != session.Key {
+		t.Error("Session was not found")
+	}
+
+	rp.Logout(session.ID)
+
+	if _, ok := rp.Session(session.ID); ok {
+		t.Error("Session was not removed")
+	}
+
+	// Replayed sign counter
+	loginOpts, _ = rp.BeginLogin(rpID)
+	a.signCount--
+	_, err = rp.FinishLogin(
+		rpID, a.login(t, rpID, loginOpts.Challenge, origin))
+	if err != ErrSignCountNotIncreased {
+		t.Errorf("Expecting ErrSignCountNotIncreased, got %v", err)
+	}
+
+	// Foreign origin
+	loginOpts, _ = rp.BeginLogin(rpID)
+	_, err = rp.FinishLogin(rpID, a.login(
+		t, rpID, loginOpts.Challenge, "https://evil.example.org"))
+	if err == nil {
+		t.Error("Expecting foreign origin to be rejected")
+	}
+
+	// Tampered signature
+	loginOpts, _ = rp.BeginLogin(rpID)
+	resp := a.login(t, rpID, loginOpts.Challenge, origin)
+	sig, _ := Decode(resp.Signature)
+	sig[len(sig)-1] ^= 0xff
+	resp.Signature = Encode(sig)
+	_, err = rp.FinishLogin(rpID, resp)
+	if err != ErrInvalidSignature {
+		t.Errorf("Expecting ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestFileStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passkeys.json")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Add(Credential{ID: "a", User: "bob", Added: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Add(Credential{ID: "a"}); err != ErrCredentialAlreadyExists {
+		t.Errorf("Expecting ErrCredentialAlreadyExists, got %v", err)
+	}
+
+	fresh := &FileStore{path: path, credentials: map[string]Credential{}}
+	if err := fresh.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	list, _ := fresh.List("bob")
+	if len(list) != 1 || list[0].ID != "a" {
+		t.Errorf("Unexpected credentials %+v", list)
+	}
+}
+
+func TestEnrollment(t *testing.T) {
+	rp := New("Test", &FileStore{credentials: map[string]Credential{}}, 0)
+	now := time.Now()
+	rp.now = func() time.Time { return now }
+
+	e, err := rp.Enroll("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user, err := rp.Enrolled(e.Code); err != nil || user != "bob" {
+		t.Errorf("Expecting bob, got %q (%v)", user, err)
+	}
+
+	if _, err := rp.Enrolled("made-up"); err != ErrEnrollmentNotFound {
+		t.Errorf("Expecting ErrEnrollmentNotFound, got %v", err)
+	}
+
+	rp.Unenroll(e.Code)
+
+	if _, err := rp.Enrolled(e.Code); err != ErrEnrollmentNotFound {
+		t.Errorf("Expecting ErrEnrollmentNotFound after use, got %v", err)
+	}
+
+	e, _ = rp.Enroll("alice")
+	now = now.Add(enrollmentLifetime + time.Second)
+
+	if _, err := rp.Enrolled(e.Code); err != ErrEnrollmentNotFound {
+		t.Errorf("Expecting ErrEnrollmentNotFound once expired, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package passkey
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrCredentialAlreadyExists = errors.New(
+		"the passkey already exists")
+
+	ErrCredentialNotFound = errors.New(
+		"the passkey was not found")
+)
+
+var (
+	fileStores     = map[string]*FileStore{}
+	fileStoresLock = sync.Mutex{}
+)
+
+// Credential is a registered passkey
+type Credential struct {
+	ID        string    `json:"id"`
+	User      string    `json:"user"`
+	PublicKey string    `json:"public_key"`
+	SignCount uint32    `json:"sign_count"`
+	Added     time.Time `json:"added"`
+	LastUsed  time.Time `json:"last_used"`
+}
+
+// Store stores registered passkeys. Implement it to keep the passkeys in
+// an existing user database
+type Store interface {
+	// List returns all passkeys of the `user`
+	List(user string) ([]Credential, error)
+
+	// Get returns the passkey of the given ID, or ErrCredentialNotFound
+	Get(id string) (Credential, error)
+
+	// Add stores a new passkey, or returns ErrCredentialAlreadyExists
+	Add(c Credential) error
+
+	// Update replaces an existing passkey
+	Update(c Credential) error
+}
+
+// FileStore stores passkeys in a JSON file
+type FileStore struct {
+	path        string
+	lock        sync.RWMutex
+	credentials map[string]Credential
+}
+
+// Open opens the FileStore stored in the given file. Stores of the same file
+// will be shared, so multiple servers can access the same store safely
+func Open(path string) (*FileStore, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fileStoresLock.Lock()
+	defer fileStoresLock.Unlock()
+
+	if s, ok := fileStores[absPath]; ok {
+		return s, nil
+	}
+
+	s := &FileStore{
+		path:        absPath,
+		lock:        sync.RWMutex{},
+		credentials: map[string]Credential{},
+	}
+
+	err = s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	fileStores[absPath] = s
+
+	return s, nil
+}
+
+func (s *FileStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to read passkey file %q: %s", s.path, err)
+	}
+
+	credentials := []Credential{}
+
+	err = json.Unmarshal(data, &credentials)
+	if err != nil {
+		return fmt.Errorf("unable to parse passkey file %q: %s", s.path, err)
+	}
+
+	for _, c := range credentials {
+		s.credentials[c.ID] = c
+	}
+
+	return nil
+}
+
+// save writes all passkeys into the file. Caller must hold the write lock
+func (s *FileStore) save() error {
+	credentials := make([]Credential, 0, len(s.credentials))
+
+	for _, c := range s.credentials {
+		credentials = append(credentials, c)
+	}
+
+	sort.Slice(credentials, func(i, j int) bool {
+		if credentials[i].Added.Equal(credentials[j].Added) {
+			return credentials[i].ID < credentials[j].ID
+		}
+
+		return credentials[i].Added.Before(credentials[j].Added)
+	})
+
+	data, err := json.MarshalIndent(credentials, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.path + ".tmp"
+
+	err = os.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, s.path)
+}
+
+// List implements Store
+func (s *FileStore) List(user string) ([]Credential, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	credentials := []Credential{}
+
+	for _, c := range s.credentials {
+		if c.User != user {
+			continue
+		}
+
+		credentials = append(credentials, c)
+	}
+
+	sort.Slice(credentials, func(i, j int) bool {
+		return credentials[i].Added.Before(credentials[j].Added)
+	})
+
+	return credentials, nil
+}
+
+// Get implements Store
+func (s *FileStore) Get(id string) (Credential, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	c, ok := s.credentials[id]
+	if !ok {
+		return Credential{}, ErrCredentialNotFound
+	}
+
+	return c, nil
+}
+
+// Add implements Store
+func (s *FileStore) Add(c Credential) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.credentials[c.ID]; ok {
+		return ErrCredentialAlreadyExists
+	}
+
+	s.credentials[c.ID] = c
+
+	err := s.save()
+	if err != nil {
+		delete(s.credentials, c.ID)
+
+		return err
+	}
+
+	return nil
+}
+
+// Update implements Store
+func (s *FileStore) Update(c Credential) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	old, ok := s.credentials[c.ID]
+	if !ok {
+		return ErrCredentialNotFound
+	}
+
+	s.credentials[c.ID] = c
+
+	err := s.save()
+	if err != nil {
+		s.credentials[c.ID] = old
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,340 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package passkey
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+)
+
+// Types of the client data
+const (
+	clientDataTypeCreate = "webauthn.create"
+	clientDataTypeGet    = "webauthn.get"
+)
+
+// Flags of the authenticator data
+const (
+	authDataFlagUserPresent      = 0x01
+	authDataFlagUserVerified     = 0x04
+	authDataFlagAttestedCredData = 0x40
+)
+
+// COSE algorithms that are supported
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// COSE key types
+const (
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+)
+
+const (
+	authDataMinSize = 37
+)
+
+// Algorithms returns the COSE algorithms of the supported public keys, in
+// the order of preference
+func Algorithms() []int {
+	return []int{coseAlgES256, coseAlgEdDSA, coseAlgRS256}
+}
+
+// Errors
+var (
+	ErrInvalidClientData = errors.New(
+		"invalid client data")
+
+	ErrInvalidAuthData = errors.New(
+		"invalid authenticator data")
+
+	ErrInvalidAttestation = errors.New(
+		"invalid attestation object")
+
+	ErrUnsupportedKey = errors.New(
+		"unsupported public key")
+
+	ErrUserNotPresent = errors.New(
+		"user presence was not confirmed by the authenticator")
+
+	ErrInvalidSignature = errors.New(
+		"invalid signature")
+)
+
+// encoding is the base64url encoding used by WebAuthn
+var encoding = base64.RawURLEncoding
+
+// Encode encodes `b` in base64url, which is the encoding used by the
+// WebAuthn JSON messages
+func Encode(b []byte) string {
+	return encoding.EncodeToString(b)
+}
+
+// Decode decodes the base64url encoded `s`. Padding is tolerated
+func Decode(s string) ([]byte, error) {
+	return encoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// clientData is the collected client data of a ceremony
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// verifyClientData verifies the client data `raw` of a ceremony of type `t`
+// and returns the challenge it carries
+func verifyClientData(raw []byte, t string, rpID string) ([]byte, error) {
+	c := clientData{}
+
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidClientData, err)
+	}
+
+	if c.Type != t {
+		return nil, fmt.Errorf("%s: unexpected type %q",
+			ErrInvalidClientData, c.Type)
+	}
+
+	origin, err := url.Parse(c.Origin)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid origin %q",
+			ErrInvalidClientData, c.Origin)
+	}
+
+	host := origin.Hostname()
+	if host != rpID && !strings.HasSuffix(host, "."+rpID) {
+		return nil, fmt.Errorf("%s: origin %q doesn't belong to %q",
+			ErrInvalidClientData, c.Origin, rpID)
+	}
+
+	challenge, err := Decode(c.Challenge)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid challenge", ErrInvalidClientData)
+	}
+
+	return challenge, nil
+}
+
+// authData is the parsed authenticator data
+type authData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    crypto.PublicKey
+}
+
+// parseAuthData parses the authenticator data `b`
+func parseAuthData(b []byte) (authData, error) {
+	if len(b) < authDataMinSize {
+		return authData{}, ErrInvalidAuthData
+	}
+
+	d := authData{
+		rpIDHash:  b[:32],
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+
+	if d.flags&authDataFlagAttestedCredData == 0 {
+		return d, nil
+	}
+
+	// AAGUID (16 bytes) followed by the length of the credential ID
+	rest := b[authDataMinSize:]
+	if len(rest) < 18 {
+		return authData{}, ErrInvalidAuthData
+	}
+
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return authData{}, ErrInvalidAuthData
+	}
+
+	d.credentialID = rest[:idLen]
+
+	key, _, err := cborDecode(rest[idLen:])
+	if err != nil {
+		return authData{}, fmt.Errorf("%s: %s", ErrInvalidAuthData, err)
+	}
+
+	d.publicKey, err = parseCOSEKey(key)
+	if err != nil {
+		return authData{}, err
+	}
+
+	return d, nil
+}
+
+// verify verifies the relying party and the user presence of the data
+func (d authData) verify(rpID string) error {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+
+	if subtle.ConstantTimeCompare(rpIDHash[:], d.rpIDHash) != 1 {
+		return fmt.Errorf("%s: relying party mismatch", ErrInvalidAuthData)
+	}
+
+	if d.flags&authDataFlagUserPresent == 0 {
+		return ErrUserNotPresent
+	}
+
+	return nil
+}
+
+// userVerified returns whether or not the user is verified by the
+// authenticator (i.e. through PIN or biometrics)
+func (d authData) userVerified() bool {
+	return d.flags&authDataFlagUserVerified != 0
+}
+
+// parseCOSEKey converts the decoded COSE key `v` to a public key
+func parseCOSEKey(v interface{}) (crypto.PublicKey, error) {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+
+	switch {
+	case kty == coseKeyTypeEC2 && alg == coseAlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrUnsupportedKey
+		}
+
+		k := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		// Make sure the point is valid
+		if _, err := k.ECDH(); err != nil {
+			return nil, ErrUnsupportedKey
+		}
+
+		return k, nil
+
+	case kty == coseKeyTypeOKP && alg == coseAlgEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKey
+		}
+
+		return ed25519.PublicKey(x), nil
+
+	case kty == coseKeyTypeRSA && alg == coseAlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+
+		if len(n) < 256 || len(e) <= 0 || len(e) > 4 {
+			return nil, ErrUnsupportedKey
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	default:
+		return nil, ErrUnsupportedKey
+	}
+}
+
+// verifySignature verifies the assertion `signature` of the `authData` and
+// the hash of the client data, signed by the private key of `key`
+func verifySignature(
+	key crypto.PublicKey,
+	authData []byte,
+	clientDataHash []byte,
+	signature []byte,
+) error {
+	signed := bytes.Join([][]byte{authData, clientDataHash}, nil)
+	digest := sha256.Sum256(signed)
+
+	valid := false
+
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(k, digest[:], signature)
+
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(k, signed, signature)
+
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(
+			k, crypto.SHA256, digest[:], signature) == nil
+
+	default:
+		return ErrUnsupportedKey
+	}
+
+	if !valid {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// marshalPublicKey encodes the public key for storing
+func marshalPublicKey(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return Encode(der), nil
+}
+
+// parsePublicKey decodes the public key encoded by marshalPublicKey
+func parsePublicKey(s string) (crypto.PublicKey, error) {
+	der, err := Decode(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParsePKIXPublicKey(der)
+}
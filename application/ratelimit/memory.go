@@ -0,0 +1,114 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	memorySweepInterval = time.Minute
+)
+
+type memoryCounter struct {
+	count   int64
+	expires time.Time
+}
+
+// Memory keeps the counters in the memory of current process
+type Memory struct {
+	lock     sync.Mutex
+	counters map[string]memoryCounter
+	swept    time.Time
+	now      func() time.Time
+}
+
+// NewMemory creates a new Memory
+func NewMemory() *Memory {
+	return &Memory{
+		lock:     sync.Mutex{},
+		counters: map[string]memoryCounter{},
+		swept:    time.Time{},
+		now:      time.Now,
+	}
+}
+
+// sweep removes the expired counters. Must be called with the lock held
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.swept) < memorySweepInterval {
+		return
+	}
+
+	m.swept = now
+
+	for k, c := range m.counters {
+		if !now.Before(c.expires) {
+			delete(m.counters, k)
+		}
+	}
+}
+
+// Count implements Backend
+func (m *Memory) Count(ctx context.Context, key string) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	c, ok := m.counters[key]
+	if !ok || !m.now().Before(c.expires) {
+		return 0, nil
+	}
+
+	return c.count, nil
+}
+
+// Increase implements Backend
+func (m *Memory) Increase(
+	ctx context.Context, key string, window time.Duration) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	c, ok := m.counters[key]
+	if !ok || !now.Before(c.expires) {
+		c = memoryCounter{count: 0, expires: now.Add(window)}
+	}
+
+	c.count++
+	m.counters[key] = c
+
+	return c.count, nil
+}
+
+// Reset implements Backend
+func (m *Memory) Reset(ctx context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.counters, key)
+
+	return nil
+}
+
+// Close implements Backend
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,144 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package ratelimit counts the failed attempts of the clients, and locks out
+// the ones that failed too many times in a time window. The counters are
+// kept by a Backend, which can be shared by the replicas of a clustered
+// deployment so the limits hold across all of them.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Errors
+var (
+	ErrLocked = errors.New(
+		"too many failed attempts, try again later")
+
+	ErrUnsupportedBackend = errors.New(
+		"unsupported rate limiter backend")
+)
+
+// Backend keeps the counters
+type Backend interface {
+	// Count returns the counter of the `key`, 0 when it doesn't exist or is
+	// expired
+	Count(ctx context.Context, key string) (int64, error)
+
+	// Increase increases the counter of the `key` by one and returns the
+	// result. A new counter expires `window` after it's created
+	Increase(ctx context.Context, key string, window time.Duration) (
+		int64, error)
+
+	// Reset removes the counter of the `key`
+	Reset(ctx context.Context, key string) error
+
+	// Close releases the resources of the Backend
+	Close() error
+}
+
+// NewBackend creates the Backend described by `backend`: an empty string or
+// "memory" for the Memory, or a "redis://" or "rediss://" URL for the Redis
+func NewBackend(backend string) (Backend, error) {
+	switch {
+	case len(backend) <= 0, backend == "memory":
+		return NewMemory(), nil
+
+	case strings.HasPrefix(backend, "redis://"),
+		strings.HasPrefix(backend, "rediss://"):
+		return NewRedis(backend)
+
+	default:
+		return nil, ErrUnsupportedBackend
+	}
+}
+
+// Limiter locks out the clients which failed more than the allowed times
+// within the window. A nil Limiter never locks anyone out
+type Limiter struct {
+	backend Backend
+	prefix  string
+	max     int64
+	window  time.Duration
+}
+
+// New creates a new Limiter. The counters of the Limiter are named by the
+// `name`, so Limiters of different purposes can share the same Backend
+func New(
+	backend Backend,
+	name string,
+	maxAttempts int,
+	window time.Duration,
+) *Limiter {
+	return &Limiter{
+		backend: backend,
+		prefix:  "sshwifty:ratelimit:" + name + ":",
+		max:     int64(maxAttempts),
+		window:  window,
+	}
+}
+
+// Allow returns ErrLocked when the `client` has failed too many times
+func (l *Limiter) Allow(ctx context.Context, client string) error {
+	if l == nil {
+		return nil
+	}
+
+	n, err := l.backend.Count(ctx, l.prefix+client)
+	if err != nil {
+		return err
+	}
+
+	if n >= l.max {
+		return ErrLocked
+	}
+
+	return nil
+}
+
+// Fail records a failed attempt of the `client`
+func (l *Limiter) Fail(ctx context.Context, client string) error {
+	if l == nil {
+		return nil
+	}
+
+	_, err := l.backend.Increase(ctx, l.prefix+client, l.window)
+
+	return err
+}
+
+// Succeed clears the failed attempts of the `client`
+func (l *Limiter) Succeed(ctx context.Context, client string) error {
+	if l == nil {
+		return nil
+	}
+
+	return l.backend.Reset(ctx, l.prefix+client)
+}
+
+// Close closes the Backend
+func (l *Limiter) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	return l.backend.Close()
+}
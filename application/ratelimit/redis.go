@@ -0,0 +1,351 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ratelimit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrRedisInvalidURL = errors.New(
+		"invalid Redis URL, must be a \"redis://\" or \"rediss://\" URL")
+
+	ErrRedisUnexpectedReply = errors.New(
+		"unexpected reply from the Redis server")
+)
+
+const (
+	redisDefaultPort    = "6379"
+	redisMaxLineSize    = 64 * 1024
+	redisDialTimeout    = 10 * time.Second
+	redisCommandTimeout = 5 * time.Second
+)
+
+// redisIncreaseScript increases the counter and sets it's expiry when it's
+// just created, atomically
+const redisIncreaseScript = "local c = redis.call('INCR', KEYS[1]) " +
+	"if c == 1 then redis.call('PEXPIRE', KEYS[1], ARGV[1]) end " +
+	"return c"
+
+// redisError is an error replied by the Redis server. The connection is
+// still usable after it
+type redisError string
+
+func (e redisError) Error() string {
+	return "Redis server error: " + string(e)
+}
+
+// Redis keeps the counters in a Redis server through the RESP protocol, so
+// they're shared by all the Sshwifty instances that use the same server
+type Redis struct {
+	u      *url.URL
+	db     int
+	lock   sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedis creates a new Redis which connects to the Redis server at `u`,
+// i.e. "redis://:password@host:6379/0". The connection is established on
+// the first use
+func NewRedis(u string) (*Redis, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRedisInvalidURL, err)
+	}
+
+	if (parsed.Scheme != "redis" && parsed.Scheme != "rediss") ||
+		len(parsed.Hostname()) <= 0 {
+		return nil, ErrRedisInvalidURL
+	}
+
+	db := 0
+
+	if path := strings.Trim(parsed.Path, "/"); len(path) > 0 {
+		db, err = strconv.Atoi(path)
+		if err != nil || db < 0 {
+			return nil, fmt.Errorf("%w: invalid database %q",
+				ErrRedisInvalidURL, path)
+		}
+	}
+
+	return &Redis{
+		u:      parsed,
+		db:     db,
+		lock:   sync.Mutex{},
+		conn:   nil,
+		reader: nil,
+	}, nil
+}
+
+// connect connects and authenticates to the Redis server
+func (r *Redis) connect(ctx context.Context) error {
+	port := r.u.Port()
+	if len(port) <= 0 {
+		port = redisDefaultPort
+	}
+
+	dialer := net.Dialer{Timeout: redisDialTimeout}
+
+	conn, err := dialer.DialContext(
+		ctx, "tcp", net.JoinHostPort(r.u.Hostname(), port))
+	if err != nil {
+		return err
+	}
+
+	if r.u.Scheme == "rediss" {
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName: r.u.Hostname(),
+		})
+
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+
+			return err
+		}
+
+		conn = tlsConn
+	}
+
+	r.conn = conn
+	r.reader = bufio.NewReaderSize(conn, redisMaxLineSize)
+
+	if r.u.User != nil {
+		args := []string{"AUTH"}
+
+		if pass, hasPass := r.u.User.Password(); hasPass {
+			if user := r.u.User.Username(); len(user) > 0 {
+				args = append(args, user)
+			}
+
+			args = append(args, pass)
+		} else {
+			args = append(args, r.u.User.Username())
+		}
+
+		if _, err := r.roundTrip(args...); err != nil {
+			r.disconnect()
+
+			return err
+		}
+	}
+
+	if r.db != 0 {
+		if _, err := r.roundTrip("SELECT", strconv.Itoa(r.db)); err != nil {
+			r.disconnect()
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// disconnect drops the connection, so the next command reconnects
+func (r *Redis) disconnect() {
+	if r.conn == nil {
+		return
+	}
+
+	r.conn.Close()
+	r.conn = nil
+	r.reader = nil
+}
+
+// roundTrip sends a command and reads it's reply. Must be called with the
+// lock held and the connection established
+func (r *Redis) roundTrip(args ...string) (redisReply, error) {
+	buf := bytes.Buffer{}
+
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+
+	for _, a := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(a), a)
+	}
+
+	if _, err := r.conn.Write(buf.Bytes()); err != nil {
+		return redisReply{}, err
+	}
+
+	return readRedisReply(r.reader)
+}
+
+// do runs a command on the Redis server
+func (r *Redis) do(ctx context.Context, args ...string) (redisReply, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, redisCommandTimeout)
+		defer cancel()
+	}
+
+	if r.conn == nil {
+		if err := r.connect(ctx); err != nil {
+			return redisReply{}, err
+		}
+	}
+
+	deadline, _ := ctx.Deadline()
+	r.conn.SetDeadline(deadline)
+
+	reply, err := r.roundTrip(args...)
+	if err != nil {
+		if _, isRedisErr := err.(redisError); !isRedisErr {
+			r.disconnect()
+		}
+
+		return redisReply{}, err
+	}
+
+	return reply, nil
+}
+
+// Count implements Backend
+func (r *Redis) Count(ctx context.Context, key string) (int64, error) {
+	reply, err := r.do(ctx, "GET", key)
+	if err != nil {
+		return 0, err
+	}
+
+	if reply.null {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(reply.data, 10, 64)
+	if err != nil {
+		return 0, ErrRedisUnexpectedReply
+	}
+
+	return n, nil
+}
+
+// Increase implements Backend
+func (r *Redis) Increase(
+	ctx context.Context, key string, window time.Duration) (int64, error) {
+	reply, err := r.do(ctx, "EVAL", redisIncreaseScript, "1", key,
+		strconv.FormatInt(window.Milliseconds(), 10))
+	if err != nil {
+		return 0, err
+	}
+
+	if reply.kind != ':' {
+		return 0, ErrRedisUnexpectedReply
+	}
+
+	return reply.integer, nil
+}
+
+// Reset implements Backend
+func (r *Redis) Reset(ctx context.Context, key string) error {
+	_, err := r.do(ctx, "DEL", key)
+
+	return err
+}
+
+// Close implements Backend
+func (r *Redis) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.disconnect()
+
+	return nil
+}
+
+// redisReply is a non-array reply of the Redis server
+type redisReply struct {
+	kind    byte
+	data    string
+	integer int64
+	null    bool
+}
+
+// readRedisReply reads a reply of the Redis server. Errors replied by the
+// server are returned as redisError
+func readRedisReply(reader *bufio.Reader) (redisReply, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return redisReply{}, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) <= 0 {
+		return redisReply{}, ErrRedisUnexpectedReply
+	}
+
+	reply := redisReply{kind: line[0]}
+
+	switch line[0] {
+	case '+':
+		reply.data = line[1:]
+
+		return reply, nil
+
+	case '-':
+		return redisReply{}, redisError(line[1:])
+
+	case ':':
+		reply.integer, err = strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return redisReply{}, ErrRedisUnexpectedReply
+		}
+
+		return reply, nil
+
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size > redisMaxLineSize {
+			return redisReply{}, ErrRedisUnexpectedReply
+		}
+
+		if size < 0 {
+			reply.null = true
+
+			return reply, nil
+		}
+
+		data := make([]byte, size+2)
+
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return redisReply{}, err
+		}
+
+		reply.data = string(data[:size])
+
+		return reply, nil
+
+	default:
+		return redisReply{}, ErrRedisUnexpectedReply
+	}
+}
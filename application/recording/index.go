@@ -0,0 +1,369 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package recording
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Errors
+var (
+	ErrIndexDisabled = errors.New(
+		"the recordings are not indexed")
+
+	ErrIndexEmptyQuery = errors.New(
+		"the search query must contain at least one letter or digit")
+)
+
+const (
+	indexLineMaxLength = 4096
+)
+
+// Hit is a line of the terminal output that matches the search
+type Hit struct {
+	Recording string  `json:"recording"` // Name of the recording
+	Time      float64 `json:"time"`      // Seconds since the recording began
+	Line      string  `json:"line"`
+}
+
+// indexTerms splits the `s` into the lower cased words it contains
+func indexTerms(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Index is an in-memory full-text index of the lines printed to the
+// terminals of the recorded sessions
+type Index struct {
+	lock       sync.RWMutex
+	nextID     uint64
+	lines      map[uint64]Hit
+	terms      map[string][]uint64
+	recordings map[string][]uint64
+}
+
+// NewIndex creates a new Index
+func NewIndex() *Index {
+	return &Index{
+		lock:       sync.RWMutex{},
+		nextID:     0,
+		lines:      map[uint64]Hit{},
+		terms:      map[string][]uint64{},
+		recordings: map[string][]uint64{},
+	}
+}
+
+// add adds the `line` to the index
+func (i *Index) add(line Hit) {
+	terms := indexTerms(line.Line)
+	if len(terms) <= 0 {
+		return
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	id := i.nextID
+	i.nextID++
+
+	i.lines[id] = line
+	i.recordings[line.Recording] = append(i.recordings[line.Recording], id)
+
+	added := make(map[string]struct{}, len(terms))
+
+	for _, t := range terms {
+		if _, ok := added[t]; ok {
+			continue
+		}
+
+		added[t] = struct{}{}
+		i.terms[t] = append(i.terms[t], id)
+	}
+}
+
+// Remove removes the lines of the `recording` from the index
+func (i *Index) Remove(recording string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	ids := i.recordings[recording]
+	if len(ids) <= 0 {
+		return
+	}
+
+	delete(i.recordings, recording)
+
+	terms := map[string]struct{}{}
+
+	for _, id := range ids {
+		for _, t := range indexTerms(i.lines[id].Line) {
+			terms[t] = struct{}{}
+		}
+
+		delete(i.lines, id)
+	}
+
+	for t := range terms {
+		kept := i.terms[t][:0]
+
+		for _, id := range i.terms[t] {
+			if _, ok := i.lines[id]; ok {
+				kept = append(kept, id)
+			}
+		}
+
+		if len(kept) <= 0 {
+			delete(i.terms, t)
+		} else {
+			i.terms[t] = kept
+		}
+	}
+}
+
+// Search returns at most `limit` lines which contain the `query` (case
+// insensitive), in the order they were printed
+func (i *Index) Search(query string, limit int) ([]Hit, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+
+	terms := indexTerms(query)
+	if len(terms) <= 0 {
+		return nil, ErrIndexEmptyQuery
+	}
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	// Lines which contain the query contain all of it's terms, so only the
+	// shortest list of them needs to be checked
+	candidates := i.terms[terms[0]]
+	for _, t := range terms[1:] {
+		if len(i.terms[t]) < len(candidates) {
+			candidates = i.terms[t]
+		}
+	}
+
+	hits := []Hit{}
+
+	for _, id := range candidates {
+		if len(hits) >= limit {
+			break
+		}
+
+		line := i.lines[id]
+		if !strings.Contains(strings.ToLower(line.Line), query) {
+			continue
+		}
+
+		hits = append(hits, line)
+	}
+
+	return hits, nil
+}
+
+// indexer turns the terminal output of a recording into lines, and adds
+// them to the Index. The escape sequences are dropped, and the carriage
+// returns and backspaces move the cursor, so the redrawn lines (i.e. the
+// shell prompts) are indexed as they appeared
+type indexer struct {
+	index     *Index
+	recording string
+	line      []rune
+	cursor    int
+	start     float64
+	started   bool
+	escape    indexerEscape
+}
+
+// indexerEscape is the state of the escape sequence being skipped
+type indexerEscape int
+
+const (
+	indexerEscapeNone indexerEscape = iota
+	indexerEscapeStart
+	indexerEscapeCSI
+	indexerEscapeOSC
+	indexerEscapeOSCEnd
+	indexerEscapeCharset
+)
+
+// newIndexer creates an indexer which adds the lines of the `recording` to
+// the `index`
+func newIndexer(index *Index, recording string) *indexer {
+	return &indexer{
+		index:     index,
+		recording: recording,
+		line:      nil,
+		cursor:    0,
+		start:     0,
+		started:   false,
+		escape:    indexerEscapeNone,
+	}
+}
+
+// flush adds the current line to the Index, and starts a new one
+func (x *indexer) flush() {
+	text := strings.TrimSpace(string(x.line))
+
+	if len(text) > 0 {
+		x.index.add(Hit{
+			Recording: x.recording,
+			Time:      x.start,
+			Line:      text,
+		})
+	}
+
+	x.line = x.line[:0]
+	x.cursor = 0
+	x.started = false
+}
+
+// put writes the rune `r` at the cursor
+func (x *indexer) put(r rune, time float64) {
+	if !x.started {
+		x.start = time
+		x.started = true
+	}
+
+	if x.cursor < len(x.line) {
+		x.line[x.cursor] = r
+	} else if len(x.line) < indexLineMaxLength {
+		x.line = append(x.line, r)
+	} else {
+		return
+	}
+
+	x.cursor++
+}
+
+// output feeds the `data` printed at the `time` (in seconds since the
+// recording began)
+func (x *indexer) output(data string, time float64) {
+	for _, r := range data {
+		switch x.escape {
+		case indexerEscapeStart:
+			switch r {
+			case '[':
+				x.escape = indexerEscapeCSI
+			case ']':
+				x.escape = indexerEscapeOSC
+			case '(', ')', '*', '+':
+				x.escape = indexerEscapeCharset
+			default:
+				x.escape = indexerEscapeNone
+			}
+
+			continue
+
+		case indexerEscapeCSI:
+			if r >= 0x40 && r <= 0x7e {
+				x.escape = indexerEscapeNone
+
+				// Erase in Line, the rest of the line is cleared
+				if r == 'K' && x.cursor < len(x.line) {
+					x.line = x.line[:x.cursor]
+				}
+			}
+
+			continue
+
+		case indexerEscapeOSC:
+			if r == '\a' {
+				x.escape = indexerEscapeNone
+			} else if r == 0x1b {
+				x.escape = indexerEscapeOSCEnd
+			}
+
+			continue
+
+		case indexerEscapeOSCEnd, indexerEscapeCharset:
+			x.escape = indexerEscapeNone
+
+			continue
+		}
+
+		switch r {
+		case 0x1b:
+			x.escape = indexerEscapeStart
+
+		case '\n':
+			x.flush()
+
+		case '\r':
+			x.cursor = 0
+
+		case '\b':
+			if x.cursor > 0 {
+				x.cursor--
+			}
+
+		case '\t':
+			x.put(' ', time)
+
+		default:
+			if unicode.IsPrint(r) {
+				x.put(r, time)
+			}
+		}
+	}
+}
+
+// indexCast adds the lines of the asciicast v2 file read from the `r` to the
+// `index` as the `recording`
+func indexCast(index *Index, recording string, r io.Reader) error {
+	d := json.NewDecoder(r)
+	h := header{}
+
+	if err := d.Decode(&h); err != nil {
+		return err
+	}
+
+	x := newIndexer(index, recording)
+	defer x.flush()
+
+	for {
+		event := []json.RawMessage{}
+
+		err := d.Decode(&event)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if len(event) != 3 {
+			continue
+		}
+
+		var time float64
+		var code, data string
+
+		if json.Unmarshal(event[0], &time) != nil ||
+			json.Unmarshal(event[1], &code) != nil ||
+			json.Unmarshal(event[2], &data) != nil || code != "o" {
+			continue
+		}
+
+		x.output(data, time)
+	}
+}
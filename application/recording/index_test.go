@@ -0,0 +1,175 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package recording
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/log"
+)
+
+func TestIndexerLines(t *testing.T) {
+	index := NewIndex()
+	x := newIndexer(index, "a.cast")
+
+	x.output("\x1b]0;title\a\x1b[1;32muser@host\x1b[0m:~$ ", 1)
+	x.output("lss\b \b\r\n", 2)
+	x.output("progress 10%\rprogress 100%\x1b[K\r\n", 3)
+	x.output("\t \r\n", 4)
+	x.output("\x1b(Bdone", 5)
+	x.flush()
+
+	expected := []string{
+		"user@host:~$ ls",
+		"progress 100%",
+		"done",
+	}
+
+	if len(index.lines) != len(expected) {
+		t.Fatalf("Expecting %d lines, got %d: %v",
+			len(expected), len(index.lines), index.lines)
+	}
+
+	for i, e := range expected {
+		if index.lines[uint64(i)].Line != e {
+			t.Errorf("Expecting line %d to be %q, got %q",
+				i, e, index.lines[uint64(i)].Line)
+		}
+	}
+
+	if index.lines[0].Time != 1 || index.lines[2].Time != 5 {
+		t.Error("Unexpected line times:", index.lines)
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	index := NewIndex()
+
+	for i, line := range []string{
+		"$ cat /etc/passwd",
+		"root:x:0:0:root:/root:/bin/bash",
+		"$ rm -rf /tmp/cache",
+		"$ cat /etc/hosts",
+	} {
+		index.add(Hit{Recording: "a.cast", Time: float64(i), Line: line})
+	}
+
+	index.add(Hit{Recording: "b.cast", Time: 7, Line: "$ Cat /ETC/passwd"})
+
+	hits, err := index.Search("cat /etc/passwd", 10)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if len(hits) != 2 || hits[0].Recording != "a.cast" ||
+		hits[0].Time != 0 || hits[1].Recording != "b.cast" {
+		t.Error("Unexpected hits:", hits)
+	}
+
+	hits, _ = index.Search("passwd cat", 10)
+	if len(hits) != 0 {
+		t.Error("Expecting the terms to be matched in order, got", hits)
+	}
+
+	hits, _ = index.Search("CAT", 1)
+	if len(hits) != 1 {
+		t.Error("Expecting the hits to be limited, got", hits)
+	}
+
+	if _, err := index.Search(" / ", 10); err != ErrIndexEmptyQuery {
+		t.Error("Expecting ErrIndexEmptyQuery, got", err)
+	}
+
+	index.Remove("a.cast")
+
+	hits, _ = index.Search("cat", 10)
+	if len(hits) != 1 || hits[0].Recording != "b.cast" {
+		t.Error("Expecting the removed lines to be gone, got", hits)
+	}
+
+	if _, ok := index.terms["rm"]; ok {
+		t.Error("Expecting the unused terms to be removed")
+	}
+}
+
+func TestRecorderIndex(t *testing.T) {
+	dir := t.TempDir()
+	r := New(Settings{
+		Directory: dir,
+		Filename:  "${PROTOCOL}/${ID}",
+		Index:     true,
+	})
+	r.Start(log.NewDitch())
+
+	rec, err := r.Record(Meta{Protocol: "SSH"})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	rec.Output([]byte("$ sudo reb"))
+	rec.Output([]byte("oot\r\n$ "))
+
+	hits, err := r.Search("sudo reboot", 10)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if len(hits) != 1 || !strings.HasPrefix(hits[0].Recording, "SSH/") ||
+		hits[0].Line != "$ sudo reboot" {
+		t.Fatal("Expecting the live recording to be searchable, got", hits)
+	}
+
+	rec.Close()
+	r.Close()
+
+	// The recordings left in the Directory are indexed again on Start
+	r = New(Settings{
+		Directory: dir,
+		Retention: time.Hour,
+		Index:     true,
+	})
+	r.Start(log.NewDitch())
+
+	again, _ := r.Search("sudo reboot", 10)
+	if len(again) != 1 || again[0].Recording != hits[0].Recording {
+		t.Fatal("Expecting the recording to be indexed on Start, got", again)
+	}
+
+	past := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(dir, filepath.FromSlash(hits[0].Recording)),
+		past, past)
+
+	rec, err = r.Record(Meta{})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	rec.Close()
+	r.Close()
+
+	if hits, _ := r.Search("sudo reboot", 10); len(hits) != 0 {
+		t.Error("Expecting the pruned recording to be unindexed, got", hits)
+	}
+
+	if _, err := New(Settings{Directory: dir}).Search("x", 1); err == nil {
+		t.Error("Expecting the search to fail without the Index")
+	}
+}
@@ -0,0 +1,699 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package recording writes the output of the remote sessions into asciicast
+// v2 files, so they can be replayed later. The finished recordings can be
+// shipped to a Storage off the host
+package recording
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Errors
+var (
+	ErrInvalidFilename = errors.New(
+		"the Filename must be a relative path inside of the Directory")
+)
+
+// Defaults
+const (
+	DefaultFilename = "${TIME}-${PROTOCOL}-${REMOTE}-${ID}.cast"
+
+	defaultWidth  = 80
+	defaultHeight = 24
+
+	fileExt       = ".cast"
+	pruneInterval = time.Minute
+
+	uploadQueue   = 256
+	uploadTimeout = 10 * time.Minute
+	uploadRetries = 3
+)
+
+// unsafeNameChars matches the chars which are replaced when the values are
+// put into the file names
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Storage keeps the finished recordings off the host
+type Storage interface {
+	// Name returns the name of the Storage used in logs
+	Name() string
+
+	// Store stores the recording `name` (a relative path using "/" as the
+	// separator) read from the `data` of the `size`. It must return once the
+	// `ctx` is done
+	Store(
+		ctx context.Context, name string, data io.ReadSeeker, size int64) error
+}
+
+// Settings contains the settings of the Recorder
+type Settings struct {
+	Directory string        // Where the recordings are written to
+	Filename  string        // Template of the file names of the recordings
+	Retention time.Duration // 0 to keep the recordings forever
+
+	// Where the finished recordings are shipped to, nil to keep them in the
+	// Directory. Shipped recordings are removed from the Directory, the ones
+	// failed to be shipped are kept there
+	Storage Storage
+
+	// Index the output of the recordings so they can be searched. The
+	// recordings in the Directory are indexed on Start, the new ones as
+	// they're written
+	Index bool
+}
+
+// Meta describes the session being recorded
+type Meta struct {
+	Protocol string // i.e. SSH
+	Remote   string
+	User     string // The logged-in user, if any
+	Client   string
+	Term     string
+	Width    int
+	Height   int
+}
+
+// Recorder creates the recordings, ships the finished ones to the Storage,
+// and prunes the expired ones
+type Recorder struct {
+	settings   Settings
+	lock       sync.Mutex
+	lastPruned time.Time
+	uploads    chan string
+	closing    chan struct{}
+	wait       sync.WaitGroup
+	index      *Index // nil when the recordings are not indexed
+}
+
+// New creates a new Recorder. Returns nil when the Directory is empty, in
+// which case the recording is disabled
+func New(s Settings) *Recorder {
+	if len(s.Directory) <= 0 {
+		return nil
+	}
+
+	if len(s.Filename) <= 0 {
+		s.Filename = DefaultFilename
+	}
+
+	var index *Index
+
+	if s.Index {
+		index = NewIndex()
+	}
+
+	return &Recorder{
+		settings:   s,
+		lock:       sync.Mutex{},
+		lastPruned: time.Time{},
+		uploads:    make(chan string, uploadQueue),
+		closing:    make(chan struct{}),
+		wait:       sync.WaitGroup{},
+		index:      index,
+	}
+}
+
+// Start indexes the recordings in the Directory, and starts shipping the
+// finished recordings to the Storage. It's safe to call Start on a nil
+// Recorder
+func (r *Recorder) Start(l log.Logger) {
+	if r == nil {
+		return
+	}
+
+	if r.index != nil {
+		r.indexDirectory(l)
+	}
+
+	if r.settings.Storage == nil {
+		return
+	}
+
+	r.wait.Add(1)
+
+	go r.upload(l.Context("Storage (%s)", r.settings.Storage.Name()))
+}
+
+// Close ships the recordings that are still queued, and then stops the
+// Recorder. It's safe to call Close on a nil Recorder
+func (r *Recorder) Close() {
+	if r == nil {
+		return
+	}
+
+	close(r.closing)
+	r.wait.Wait()
+}
+
+// indexDirectory adds the recordings in the Directory to the Index
+func (r *Recorder) indexDirectory(l log.Logger) {
+	indexed := 0
+
+	filepath.WalkDir(r.settings.Directory, func(
+		path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, fileExt) {
+			return nil
+		}
+
+		name, err := r.name(path)
+		if err != nil {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			l.Warning("Unable to index recording %q: %s", path, err)
+
+			return nil
+		}
+		defer f.Close()
+
+		err = indexCast(r.index, name, f)
+		if err != nil {
+			l.Warning("Recording %q is partially indexed: %s", path, err)
+		}
+
+		indexed++
+
+		return nil
+	})
+
+	l.Debug("%d recordings indexed", indexed)
+}
+
+// name returns the name of the recording at the `path`, which is relative
+// to the Directory and uses "/" as the separator
+func (r *Recorder) name(path string) (string, error) {
+	name, err := filepath.Rel(r.settings.Directory, path)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.ToSlash(name), nil
+}
+
+// Search returns at most `limit` lines of the recordings which contain the
+// `query`. The names of the recordings are the same ones used in the
+// Storage
+func (r *Recorder) Search(query string, limit int) ([]Hit, error) {
+	if r == nil || r.index == nil {
+		return nil, ErrIndexDisabled
+	}
+
+	return r.index.Search(query, limit)
+}
+
+// Indexed returns whether or not the recordings are indexed
+func (r *Recorder) Indexed() bool {
+	return r != nil && r.index != nil
+}
+
+// finished queues the finished recording at the `path` to be shipped. The
+// recording is kept in the Directory when the queue is full
+func (r *Recorder) finished(path string) {
+	select {
+	case r.uploads <- path:
+	default:
+	}
+}
+
+// upload ships the queued recordings until the Recorder is closed
+func (r *Recorder) upload(l log.Logger) {
+	defer r.wait.Done()
+
+	for {
+		select {
+		case path := <-r.uploads:
+			r.store(l, path)
+
+		case <-r.closing:
+			for {
+				select {
+				case path := <-r.uploads:
+					r.store(l, path)
+
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// store ships the recording at the `path` to the Storage, and removes it
+// from the Directory once it's shipped. Failed attempts are retried for
+// uploadRetries times
+func (r *Recorder) store(l log.Logger, path string) {
+	name, err := r.name(path)
+	if err != nil {
+		l.Warning("Unable to ship recording %q: %s", path, err)
+
+		return
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = r.storeFile(path, name)
+		if err == nil {
+			break
+		}
+
+		if attempt >= uploadRetries {
+			l.Warning("Unable to ship recording %q, it's kept in the "+
+				"directory: %s", path, err)
+
+			return
+		}
+
+		select {
+		case <-time.After(time.Second << attempt):
+		case <-r.closing:
+			l.Warning("Unable to ship recording %q before closing, it's "+
+				"kept in the directory: %s", path, err)
+
+			return
+		}
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		l.Warning("Unable to remove the shipped recording %q: %s", path, err)
+
+		return
+	}
+
+	l.Debug("Recording %q shipped", name)
+}
+
+// storeFile sends the file at the `path` to the Storage as `name`
+func (r *Recorder) storeFile(path string, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+
+	return r.settings.Storage.Store(ctx, name, f, info.Size())
+}
+
+// Enabled returns whether or not the recording is enabled
+func (r *Recorder) Enabled() bool {
+	return r != nil
+}
+
+// VerifyFilename returns an error when the `filename` template could expand
+// into a path outside of the Directory
+func VerifyFilename(filename string) error {
+	if len(filename) <= 0 {
+		return nil
+	}
+
+	if !filepath.IsLocal(expandFilename(filename, Meta{}, time.Time{}, "")) {
+		return ErrInvalidFilename
+	}
+
+	return nil
+}
+
+// expandFilename expands the `template`. Following placeholders will be
+// replaced:
+//   - ${TIME}: Time the recording started, in UTC
+//   - ${DATE}: Date the recording started, in UTC
+//   - ${PROTOCOL}: Protocol of the session, i.e. SSH
+//   - ${REMOTE}: Address of the remote
+//   - ${USER}: The logged-in user, or "anonymous"
+//   - ${CLIENT}: Address of the client
+//   - ${ID}: A random ID which keeps the file names unique
+//
+// Unknown placeholders are kept as is
+func expandFilename(template string, m Meta, t time.Time, id string) string {
+	return os.Expand(template, func(name string) string {
+		value := ""
+
+		switch name {
+		case "TIME":
+			value = t.UTC().Format("20060102T150405Z")
+
+		case "DATE":
+			value = t.UTC().Format("2006-01-02")
+
+		case "PROTOCOL":
+			value = m.Protocol
+
+		case "REMOTE":
+			value = m.Remote
+
+		case "USER":
+			value = m.User
+			if len(value) <= 0 {
+				value = "anonymous"
+			}
+
+		case "CLIENT":
+			value = m.Client
+
+		case "ID":
+			value = id
+
+		default:
+			return "${" + name + "}"
+		}
+
+		// Values are never allowed to add directories into the path
+		value = unsafeNameChars.ReplaceAllString(value, "_")
+		if len(value) <= 0 || value == "." || value == ".." {
+			value = "_"
+		}
+
+		return value
+	})
+}
+
+// Record creates a new Recording of the session described by the `m`
+func (r *Recorder) Record(m Meta) (*Recording, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	r.prune(time.Now())
+
+	start := time.Now()
+	id := strings.ToLower(rand.Text()[:8])
+
+	name := expandFilename(r.settings.Filename, m, start, id)
+	if !strings.HasSuffix(name, fileExt) {
+		name += fileExt
+	}
+
+	if !filepath.IsLocal(name) {
+		return nil, ErrInvalidFilename
+	}
+
+	path := filepath.Join(r.settings.Directory, name)
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the directory of "+
+			"recording %q: %s", path, err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create recording %q: %s", path, err)
+	}
+
+	rec := &Recording{
+		path:     path,
+		lock:     sync.Mutex{},
+		file:     f,
+		w:        bufio.NewWriter(f),
+		start:    start,
+		partial:  nil,
+		indexer:  nil,
+		finished: nil,
+	}
+
+	if r.index != nil {
+		rec.indexer = newIndexer(r.index, filepath.ToSlash(name))
+	}
+
+	if r.settings.Storage != nil {
+		rec.finished = r.finished
+	}
+
+	err = rec.writeHeader(m)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+
+		return nil, fmt.Errorf("unable to write recording %q: %s", path, err)
+	}
+
+	return rec, nil
+}
+
+// prune removes the recordings that are older than the Retention. It's done
+// at most once every pruneInterval
+func (r *Recorder) prune(now time.Time) {
+	if r.settings.Retention <= 0 {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if now.Sub(r.lastPruned) < pruneInterval {
+		return
+	}
+
+	r.lastPruned = now
+	expire := now.Add(-r.settings.Retention)
+
+	filepath.WalkDir(r.settings.Directory, func(
+		path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, fileExt) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || !info.ModTime().Before(expire) {
+			return nil
+		}
+
+		if os.Remove(path) != nil || r.index == nil {
+			return nil
+		}
+
+		if name, err := r.name(path); err == nil {
+			r.index.Remove(name)
+		}
+
+		return nil
+	})
+}
+
+// header is the header line of an asciicast v2 file
+type header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recording is an asciicast v2 file being written. Methods of a nil
+// Recording do nothing, so the sessions which are not recorded can use it
+// the same way
+type Recording struct {
+	path    string
+	lock    sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	start   time.Time
+	partial []byte   // Incomplete UTF-8 sequence at the end of the last output
+	indexer *indexer // nil when the recordings are not indexed
+
+	// Called with the path once the recording is finished, if not nil
+	finished func(path string)
+}
+
+// Path returns the path of the recording file
+func (r *Recording) Path() string {
+	if r == nil {
+		return ""
+	}
+
+	return r.path
+}
+
+func (r *Recording) writeHeader(m Meta) error {
+	width, height := m.Width, m.Height
+	if width <= 0 || height <= 0 {
+		width, height = defaultWidth, defaultHeight
+	}
+
+	h := header{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: r.start.Unix(),
+		Title:     m.Protocol + " " + m.Remote,
+	}
+
+	if len(m.Term) > 0 {
+		h.Env = map[string]string{"TERM": m.Term}
+	}
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	r.w.Write(data)
+	r.w.WriteByte('\n')
+
+	return r.w.Flush()
+}
+
+// writeEvent writes an event of the `code` carrying the `data`. Caller must
+// hold the lock
+func (r *Recording) writeEvent(code string, data string) error {
+	if r.file == nil {
+		return os.ErrClosed
+	}
+
+	d, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	elapsed := time.Since(r.start).Seconds()
+
+	r.w.WriteByte('[')
+	r.w.WriteString(strconv.FormatFloat(elapsed, 'f', 6, 64))
+	r.w.WriteString(`, "`)
+	r.w.WriteString(code)
+	r.w.WriteString(`", `)
+	r.w.Write(d)
+	r.w.WriteString("]\n")
+
+	if code == "o" && r.indexer != nil {
+		r.indexer.output(data, elapsed)
+	}
+
+	return r.w.Flush()
+}
+
+// Output records the `data` printed to the terminal. UTF-8 sequences split
+// across the outputs are joined before they're recorded
+func (r *Recording) Output(data []byte) error {
+	if r == nil {
+		return nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if len(r.partial) > 0 {
+		data = append(r.partial, data...)
+		r.partial = nil
+	}
+
+	complete := len(data)
+
+	// Keep the trailing incomplete sequence (at most utf8.UTFMax-1 bytes)
+	// for the next output
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		c := data[len(data)-i]
+		if c < utf8.RuneSelf {
+			break
+		}
+
+		if !utf8.RuneStart(c) {
+			continue
+		}
+
+		if !utf8.FullRune(data[len(data)-i:]) {
+			complete = len(data) - i
+		}
+
+		break
+	}
+
+	if complete < len(data) {
+		r.partial = append([]byte{}, data[complete:]...)
+	}
+
+	if complete <= 0 {
+		return nil
+	}
+
+	return r.writeEvent("o", string(data[:complete]))
+}
+
+// Resize records that the terminal has been resized
+func (r *Recording) Resize(cols, rows int) error {
+	if r == nil || cols <= 0 || rows <= 0 {
+		return nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.writeEvent("r", strconv.Itoa(cols)+"x"+strconv.Itoa(rows))
+}
+
+// Close finishes the recording
+func (r *Recording) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	if len(r.partial) > 0 {
+		r.writeEvent("o", string(r.partial))
+		r.partial = nil
+	}
+
+	r.w.Flush()
+
+	if r.indexer != nil {
+		r.indexer.flush()
+	}
+
+	err := r.file.Close()
+	r.file = nil
+
+	if r.finished != nil {
+		r.finished(r.path)
+	}
+
+	return err
+}
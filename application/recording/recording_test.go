@@ -0,0 +1,222 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package recording
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/log"
+)
+
+type dummyStorage struct {
+	stored map[string]string
+}
+
+func (d *dummyStorage) Name() string {
+	return "dummy"
+}
+
+func (d *dummyStorage) Store(
+	ctx context.Context, name string, data io.ReadSeeker, size int64) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+
+	d.stored[name] = string(b)
+
+	return nil
+}
+
+func TestExpandFilename(t *testing.T) {
+	m := Meta{Protocol: "SSH", Remote: "[::1]:22", User: "../root"}
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	name := expandFilename(
+		"${DATE}/${USER}-${REMOTE}-${TIME}-${ID}-${OTHER}", m, start, "a1")
+	if name != "2025-01-02/.._root-___1__22-20250102T030405Z-a1-${OTHER}" {
+		t.Error("Unexpected file name:", name)
+	}
+
+	for _, f := range []string{"/tmp/${ID}", "../${ID}", "${ID}/../.."} {
+		if VerifyFilename(f) == nil {
+			t.Errorf("Expecting %q to be refused", f)
+		}
+	}
+
+	if err := VerifyFilename(DefaultFilename); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+}
+
+func TestRecording(t *testing.T) {
+	dir := t.TempDir()
+
+	if New(Settings{}).Enabled() {
+		t.Error("Expecting the recording to be disabled without a Directory")
+	}
+
+	rec, err := New(Settings{Directory: dir, Filename: "${ID}"}).Record(Meta{
+		Protocol: "SSH",
+		Remote:   "host:22",
+		Term:     "xterm",
+		Width:    100,
+		Height:   30,
+	})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if !strings.HasSuffix(rec.Path(), ".cast") {
+		t.Error("Unexpected path:", rec.Path())
+	}
+
+	// "你" is split across the outputs
+	rec.Output([]byte("a\xe4\xbd"))
+	rec.Output([]byte("\xa0b"))
+	rec.Resize(120, 40)
+	rec.Output([]byte("\xe4"))
+	rec.Close()
+
+	if rec.Output([]byte("x")) == nil {
+		t.Error("Expecting the closed recording to refuse outputs")
+	}
+
+	f, err := os.Open(rec.Path())
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+
+	s.Scan()
+	h := header{}
+	if err := json.Unmarshal(s.Bytes(), &h); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if h.Version != 2 || h.Width != 100 || h.Height != 30 ||
+		h.Env["TERM"] != "xterm" {
+		t.Errorf("Unexpected header: %+v", h)
+	}
+
+	expected := [][2]string{
+		{"o", "a"},
+		{"o", "你b"},
+		{"r", "120x40"},
+		{"o", "�"},
+	}
+
+	for _, e := range expected {
+		if !s.Scan() {
+			t.Fatal("Expecting event", e)
+		}
+
+		event := []any{}
+		if err := json.Unmarshal(s.Bytes(), &event); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		if len(event) != 3 || event[1] != e[0] || event[2] != e[1] {
+			t.Errorf("Expecting %q, got %q", e, event)
+		}
+	}
+
+	if s.Scan() {
+		t.Error("Unexpected event:", s.Text())
+	}
+}
+
+func TestRecorderPrune(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old.cast")
+	other := filepath.Join(dir, "other.txt")
+
+	for _, p := range []string{old, other} {
+		if err := os.WriteFile(p, nil, 0600); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		past := time.Now().Add(-2 * time.Hour)
+		os.Chtimes(p, past, past)
+	}
+
+	r := New(Settings{Directory: dir, Retention: time.Hour})
+
+	rec, err := r.Record(Meta{})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	rec.Close()
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("Expecting the expired recording to be removed")
+	}
+
+	if _, err := os.Stat(other); err != nil {
+		t.Error("Expecting other files to be kept")
+	}
+
+	if _, err := os.Stat(rec.Path()); err != nil {
+		t.Error("Expecting the new recording to be kept")
+	}
+}
+
+func TestRecorderStorage(t *testing.T) {
+	storage := &dummyStorage{stored: map[string]string{}}
+	r := New(Settings{
+		Directory: t.TempDir(),
+		Filename:  "${PROTOCOL}/${ID}",
+		Storage:   storage,
+	})
+	r.Start(log.NewDitch())
+
+	rec, err := r.Record(Meta{Protocol: "SSH"})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	rec.Output([]byte("hello"))
+	rec.Close()
+	r.Close()
+
+	if _, err := os.Stat(rec.Path()); !os.IsNotExist(err) {
+		t.Error("Expecting the shipped recording to be removed")
+	}
+
+	if len(storage.stored) != 1 {
+		t.Fatalf("Expecting one recording to be shipped, got %d",
+			len(storage.stored))
+	}
+
+	for name, data := range storage.stored {
+		if !strings.HasPrefix(name, "SSH/") ||
+			!strings.Contains(data, `"hello"`) {
+			t.Errorf("Unexpected recording %q: %q", name, data)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package recording
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Errors
+var (
+	ErrS3InvalidEndpoint = errors.New(
+		"the Endpoint must be an http or https URL without path")
+
+	ErrS3MissingCredential = errors.New(
+		"the AccessKey and the SecretKey are required")
+)
+
+const (
+	s3DefaultRegion   = "us-east-1"
+	s3RespondMaxSize  = 4096
+	s3SigningAlgo     = "AWS4-HMAC-SHA256"
+	s3TimeFormat      = "20060102T150405Z"
+	s3DateFormat      = "20060102"
+	s3ContentType     = "application/x-asciicast"
+	s3ContentSHA256   = "X-Amz-Content-Sha256"
+	s3Date            = "X-Amz-Date"
+	s3ServiceName     = "s3"
+	s3SigningTerminal = "aws4_request"
+)
+
+// S3Settings contains the settings of the S3 Storage
+type S3Settings struct {
+	// URL of the S3 compatible service, i.e. http://minio:9000. Empty to use
+	// the AWS S3 endpoint of the Region
+	Endpoint  string
+	Region    string // Empty to use the default (us-east-1)
+	Bucket    string
+	Prefix    string // Prepended to the names of the objects
+	AccessKey string
+	SecretKey string
+
+	// Put the Bucket into the path of the URLs instead of the host name,
+	// which is required by most self-hosted services such as MinIO
+	PathStyle bool
+}
+
+// Verify returns an error when the S3Settings are invalid
+func (s S3Settings) Verify() error {
+	if len(s.AccessKey) <= 0 || len(s.SecretKey) <= 0 {
+		return ErrS3MissingCredential
+	}
+
+	if len(s.Endpoint) <= 0 {
+		return nil
+	}
+
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+		len(u.Host) <= 0 || strings.Trim(u.Path, "/") != "" {
+		return ErrS3InvalidEndpoint
+	}
+
+	return nil
+}
+
+// S3 stores the recordings as the objects of a bucket of AWS S3, or a
+// service compatible to it (i.e. MinIO)
+type S3 struct {
+	settings S3Settings
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3 creates a new S3 Storage. The `s` must have been verified
+func NewS3(s S3Settings) *S3 {
+	if len(s.Region) <= 0 {
+		s.Region = s3DefaultRegion
+	}
+
+	if len(s.Endpoint) <= 0 {
+		s.Endpoint = "https://s3." + s.Region + ".amazonaws.com"
+	}
+
+	// Verified by Verify
+	endpoint, _ := url.Parse(s.Endpoint)
+
+	return &S3{
+		settings: s,
+		endpoint: endpoint,
+		client:   &http.Client{},
+		now:      time.Now,
+	}
+}
+
+// Name implements Storage
+func (s *S3) Name() string {
+	return "s3:" + s.settings.Bucket
+}
+
+// objectURL returns the URL of the object `name`
+func (s *S3) objectURL(name string) *url.URL {
+	key := path.Join("/", s.settings.Prefix, name)
+
+	u := *s.endpoint
+
+	if s.settings.PathStyle {
+		u.Path = "/" + s.settings.Bucket + key
+	} else {
+		u.Host = s.settings.Bucket + "." + u.Host
+		u.Path = key
+	}
+
+	// Sent as it's signed
+	u.RawPath = s3EscapePath(u.Path)
+
+	return &u
+}
+
+// Store implements Storage
+func (s *S3) Store(
+	ctx context.Context, name string, data io.ReadSeeker, size int64) error {
+	h := sha256.New()
+
+	_, err := io.Copy(h, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = data.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPut, s.objectURL(name).String(), io.NopCloser(data))
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = size
+	req.Header.Set("Content-Type", s3ContentType)
+
+	signS3(req, hex.EncodeToString(h.Sum(nil)), s.now(),
+		s.settings.Region, s.settings.AccessKey, s.settings.SecretKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	respond, _ := io.ReadAll(io.LimitReader(resp.Body, s3RespondMaxSize))
+
+	return fmt.Errorf("unexpected respond %q: %s",
+		resp.Status, strings.TrimSpace(string(respond)))
+}
+
+// s3EscapePath escapes the `p` as the canonical URI of the AWS Signature
+// Version 4, where every char other than the unreserved ones and "/" is
+// percent-encoded
+func s3EscapePath(p string) string {
+	b := strings.Builder{}
+
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// s3SigningKey derives the signing key of the AWS Signature Version 4
+func s3SigningKey(secret string, date string, region string) []byte {
+	key := []byte("AWS4" + secret)
+
+	for _, d := range []string{date, region, s3ServiceName, s3SigningTerminal} {
+		m := hmac.New(sha256.New, key)
+		m.Write([]byte(d))
+		key = m.Sum(nil)
+	}
+
+	return key
+}
+
+// signS3 signs the `req` with the AWS Signature Version 4. All headers that
+// are already set on the `req` are signed, the query of it is not
+func signS3(
+	req *http.Request,
+	payloadHash string,
+	t time.Time,
+	region string,
+	accessKey string,
+	secretKey string,
+) {
+	t = t.UTC()
+	date := t.Format(s3DateFormat)
+	scope := date + "/" + region + "/" + s3ServiceName + "/" +
+		s3SigningTerminal
+
+	req.Header.Set(s3ContentSHA256, payloadHash)
+	req.Header.Set(s3Date, t.Format(s3TimeFormat))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(
+			strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonical := strings.Builder{}
+	canonical.WriteString(req.Method + "\n")
+	canonical.WriteString(s3EscapePath(req.URL.Path) + "\n")
+	canonical.WriteString("\n") // No query
+
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonical.WriteString("\n" + signedHeaders + "\n" + payloadHash)
+
+	canonicalHash := sha256.Sum256([]byte(canonical.String()))
+	toSign := s3SigningAlgo + "\n" + t.Format(s3TimeFormat) + "\n" + scope +
+		"\n" + hex.EncodeToString(canonicalHash[:])
+
+	m := hmac.New(sha256.New, s3SigningKey(secretKey, date, region))
+	m.Write([]byte(toSign))
+
+	req.Header.Set("Authorization", s3SigningAlgo+
+		" Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+hex.EncodeToString(m.Sum(nil)))
+}
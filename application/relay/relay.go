@@ -0,0 +1,196 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package relay relays the output read from the remote sessions to the
+// consumers of it through channels, with a limit on the memory held by the
+// chunks of the output that were read but not yet consumed
+package relay
+
+import (
+	"io"
+)
+
+// Defaults
+const (
+	DefaultBufferSize = 4096
+)
+
+// Settings of the Pool
+type Settings struct {
+	// Size of the buffer of each read, 0 to use the DefaultBufferSize
+	BufferSize int
+
+	// Max amount of the chunks each stream can read ahead of its consumer,
+	// in addition to the one waiting to be consumed
+	QueueSize int
+
+	// Max amount of the buffers held by all the streams of the Pool. 0 for
+	// no limit. Streams wait for the buffers once they're all held, which
+	// slows down the remotes
+	MaxBuffers int
+}
+
+// Pool relays the output of the streams with the same Settings, and limits
+// the buffers held by all of them
+type Pool struct {
+	bufferSize int
+	queueSize  int
+	buffers    chan struct{}
+}
+
+// New creates a new Pool
+func New(s Settings) *Pool {
+	if s.BufferSize <= 0 {
+		s.BufferSize = DefaultBufferSize
+	}
+
+	if s.QueueSize < 0 {
+		s.QueueSize = 0
+	}
+
+	var buffers chan struct{}
+	if s.MaxBuffers > 0 {
+		buffers = make(chan struct{}, s.MaxBuffers)
+	}
+
+	return &Pool{
+		bufferSize: s.BufferSize,
+		queueSize:  s.QueueSize,
+		buffers:    buffers,
+	}
+}
+
+// Held returns how many buffers are currently held by the streams
+func (p *Pool) Held() int {
+	if p == nil {
+		return 0
+	}
+
+	return len(p.buffers)
+}
+
+func (p *Pool) size() int {
+	if p == nil {
+		return DefaultBufferSize
+	}
+
+	return p.bufferSize
+}
+
+func (p *Pool) queue() int {
+	if p == nil {
+		return 0
+	}
+
+	return p.queueSize
+}
+
+// acquire waits until a buffer can be held, it returns false when the
+// stream is `closed` before that
+func (p *Pool) acquire(closed <-chan struct{}) bool {
+	if p == nil || p.buffers == nil {
+		return true
+	}
+
+	select {
+	case p.buffers <- struct{}{}:
+		return true
+
+	case <-closed:
+		return false
+	}
+}
+
+func (p *Pool) release() {
+	if p == nil || p.buffers == nil {
+		return
+	}
+
+	<-p.buffers
+}
+
+// Relay starts reading the `r` into the returned channel, which is closed
+// once the `r` has ended, or the stream is `closed`. The Pool can be nil, in
+// which case the default Settings are used without the limit
+func (p *Pool) Relay(r io.Reader, closed <-chan struct{}) <-chan []byte {
+	queue := make(chan []byte, p.queue())
+	output := make(chan []byte)
+
+	go p.read(r, queue, closed)
+	go p.forward(queue, output, closed)
+
+	return output
+}
+
+// read reads the `r` into the `queue`, with a buffer held for each chunk
+func (p *Pool) read(r io.Reader, queue chan<- []byte, closed <-chan struct{}) {
+	defer close(queue)
+
+	for {
+		if !p.acquire(closed) {
+			return
+		}
+
+		buf := make([]byte, p.size())
+
+		rLen, rErr := r.Read(buf)
+		if rLen <= 0 {
+			p.release()
+		} else {
+			select {
+			case queue <- buf[:rLen]:
+			case <-closed:
+				p.release()
+				return
+			}
+		}
+
+		if rErr != nil {
+			return
+		}
+	}
+}
+
+// forward hands the chunks in the `queue` to the consumer of the `output`,
+// and releases their buffers once they're taken
+func (p *Pool) forward(
+	queue <-chan []byte, output chan<- []byte, closed <-chan struct{}) {
+	defer close(output)
+
+	for data := range queue {
+		select {
+		case output <- data:
+			p.release()
+
+		case <-closed:
+			p.release()
+
+			go p.drain(queue)
+
+			return
+		}
+	}
+}
+
+// drain releases the buffers of the chunks left in the `queue` after the
+// stream is closed. The reading may still be blocked until the closing of
+// the stream reaches the `r`, which must not hold up the consumer
+func (p *Pool) drain(queue <-chan []byte) {
+	for range queue {
+		p.release()
+	}
+}
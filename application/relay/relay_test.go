@@ -0,0 +1,82 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package relay
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelay(t *testing.T) {
+	var p *Pool
+
+	closed := make(chan struct{})
+	output := p.Relay(strings.NewReader("hello"), closed)
+
+	data := <-output
+	if string(data) != "hello" {
+		t.Errorf("Unexpected output %q", data)
+	}
+
+	if _, ok := <-output; ok {
+		t.Error("Expecting the output to be closed once the reader ended")
+	}
+}
+
+func TestPoolMaxBuffers(t *testing.T) {
+	p := New(Settings{BufferSize: 2, QueueSize: 1, MaxBuffers: 2})
+
+	r, w := io.Pipe()
+	defer w.Close()
+
+	go w.Write([]byte("aabbccdd"))
+
+	closed := make(chan struct{})
+	output := p.Relay(r, closed)
+
+	// One chunk waits to be consumed, one is queued, and the stream waits
+	// for the buffers to read the rest
+	deadline := time.Now().Add(time.Second)
+	for p.Held() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if p.Held() != 2 {
+		t.Fatalf("Expecting 2 buffers to be held, got %d", p.Held())
+	}
+
+	if data := <-output; string(data) != "aa" {
+		t.Errorf("Unexpected output %q", data)
+	}
+
+	if data := <-output; string(data) != "bb" {
+		t.Errorf("Unexpected output %q", data)
+	}
+
+	close(closed)
+	w.Close()
+
+	for range output {
+	}
+
+	if p.Held() != 0 {
+		t.Errorf("Expecting all buffers to be released, got %d", p.Held())
+	}
+}
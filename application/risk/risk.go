@@ -0,0 +1,252 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package risk sends the metadata of the sessions to an external scoring
+// service (i.e. an UEBA tool), and decides what to do with the session by
+// the risk score it answers
+package risk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nirui/sshwifty/application/approval"
+	"github.com/nirui/sshwifty/application/stepup"
+)
+
+const (
+	maxResponseLen = 4 * 1024
+)
+
+// Errors
+var (
+	ErrTerminated = errors.New(
+		"session is refused because of its risk score")
+
+	ErrInvalidResponse = errors.New(
+		"invalid response from the risk scoring webhook")
+)
+
+// Action is what to do with a session of the given risk score
+type Action int
+
+// Defined Actions, ordered by the severity
+const (
+	ActionNone Action = iota
+	ActionAlert
+	ActionStepUp
+	ActionTerminate
+)
+
+// String returns the name of the Action
+func (a Action) String() string {
+	switch a {
+	case ActionAlert:
+		return "alert"
+
+	case ActionStepUp:
+		return "step-up"
+
+	case ActionTerminate:
+		return "terminate"
+
+	default:
+		return "none"
+	}
+}
+
+// Thresholds are the lowest scores that trigger each of the Actions. A 0
+// threshold disables the Action
+type Thresholds struct {
+	Alert     float64
+	StepUp    float64
+	Terminate float64
+}
+
+// Action returns the most severe Action that the `score` triggers
+func (t Thresholds) Action(score float64) Action {
+	switch {
+	case t.Terminate > 0 && score >= t.Terminate:
+		return ActionTerminate
+
+	case t.StepUp > 0 && score >= t.StepUp:
+		return ActionStepUp
+
+	case t.Alert > 0 && score >= t.Alert:
+		return ActionAlert
+
+	default:
+		return ActionNone
+	}
+}
+
+// Request is the session metadata sent to the scoring webhook
+type Request struct {
+	// Parameters of the session, the same ones that are given to the Hooks
+	Session map[string]string `json:"session"`
+
+	// Command that the session is going to run instead of a shell. Only
+	// sent when the Scorer is configured to include it
+	Command string `json:"command,omitempty"`
+}
+
+// Assessment is the answer of the scoring webhook
+type Assessment struct {
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason"`
+}
+
+// Settings of the Scorer
+type Settings struct {
+	// Secret to sign the requests with, empty to send them unsigned
+	Secret string
+
+	// Max time to wait for the score, 0 to wait as long as the session
+	Timeout time.Duration
+
+	// Whether or not to send the commands of the sessions
+	IncludeCommand bool
+
+	// Whether or not to refuse the sessions when the webhook fails
+	FailClosed bool
+
+	// Scores that trigger the Actions
+	Thresholds Thresholds
+
+	// Method of the step-up authentication triggered by ActionStepUp
+	StepUpMethod stepup.Method
+}
+
+// Scorer requests risk scores of sessions from a webhook.
+//
+// The Request is POSTed as JSON (signed the same way as the push-approval
+// webhook when a secret is set), and the webhook must answer with
+// {"score": 0.0, "reason": "..."}
+type Scorer struct {
+	url      string
+	settings Settings
+	client   *http.Client
+}
+
+// New creates a new Scorer which sends requests to `url`
+func New(url string, settings Settings) *Scorer {
+	return &Scorer{
+		url:      url,
+		settings: settings,
+		client:   &http.Client{},
+	}
+}
+
+// Enabled returns whether or not the Scorer is enabled
+func (s *Scorer) Enabled() bool {
+	return s != nil
+}
+
+// StepUpMethod returns the method of the step-up authentication that is
+// required by ActionStepUp
+func (s *Scorer) StepUpMethod() stepup.Method {
+	if s == nil {
+		return ""
+	}
+
+	return s.settings.StepUpMethod
+}
+
+// send sends the request `body` and returns the Assessment
+func (s *Scorer) send(ctx context.Context, body []byte) (Assessment, error) {
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return Assessment{}, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.settings.Secret) > 0 {
+		req.Header.Set(approval.WebhookSignatureHeader,
+			approval.Sign(s.settings.Secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Assessment{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Assessment{}, fmt.Errorf(
+			"webhook returned status %d", resp.StatusCode)
+	}
+
+	a := Assessment{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseLen)).
+		Decode(&a)
+	if err != nil {
+		return Assessment{}, fmt.Errorf("%s: %s", ErrInvalidResponse, err)
+	}
+
+	return a, nil
+}
+
+// Assess requests the risk score of the session described by the `session`
+// parameters which is about to run the `command` (empty for a shell), and
+// returns the Action it triggers.
+//
+// When the webhook fails, the returned Action is ActionNone, or
+// ActionTerminate if the Scorer fails closed, together with the error.
+// Disabled Scorer always returns ActionNone
+func (s *Scorer) Assess(
+	ctx context.Context,
+	session map[string]string,
+	command string,
+) (Assessment, Action, error) {
+	if s == nil {
+		return Assessment{}, ActionNone, nil
+	}
+
+	r := Request{Session: session}
+	if s.settings.IncludeCommand {
+		r.Command = command
+	}
+
+	body, err := json.Marshal(r)
+	if err != nil {
+		return Assessment{}, ActionNone, err
+	}
+
+	if s.settings.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.settings.Timeout)
+		defer cancel()
+	}
+
+	a, err := s.send(ctx, body)
+	if err != nil {
+		if s.settings.FailClosed {
+			return Assessment{}, ActionTerminate, err
+		}
+
+		return Assessment{}, ActionNone, err
+	}
+
+	return a, s.settings.Thresholds.Action(a.Score), nil
+}
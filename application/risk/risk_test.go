@@ -0,0 +1,167 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package risk
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/approval"
+)
+
+func testScoringServer(
+	t *testing.T,
+	secret string,
+	score func(r Request) float64,
+) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Error("Failed to read the request body:", err)
+				return
+			}
+
+			expected := approval.Sign(secret, body)
+			sig := req.Header.Get(approval.WebhookSignatureHeader)
+			if sig != expected {
+				t.Errorf("Expecting signature %q, got %q", expected, sig)
+			}
+
+			r := Request{}
+			if err := json.Unmarshal(body, &r); err != nil {
+				t.Error("Failed to decode the request:", err)
+				return
+			}
+
+			json.NewEncoder(w).Encode(Assessment{
+				Score:  score(r),
+				Reason: "test",
+			})
+		}))
+}
+
+func TestThresholds(t *testing.T) {
+	th := Thresholds{Alert: 30, StepUp: 60, Terminate: 90}
+
+	for score, expected := range map[float64]Action{
+		0:   ActionNone,
+		29:  ActionNone,
+		30:  ActionAlert,
+		60:  ActionStepUp,
+		89:  ActionStepUp,
+		100: ActionTerminate,
+	} {
+		if a := th.Action(score); a != expected {
+			t.Errorf("Expecting score %v to trigger %s, got %s",
+				score, expected, a)
+		}
+	}
+
+	if a := (Thresholds{Alert: 30}).Action(100); a != ActionAlert {
+		t.Errorf("Expecting disabled Actions to be skipped, got %s", a)
+	}
+}
+
+func TestScorerAssess(t *testing.T) {
+	s := testScoringServer(t, "secret", func(r Request) float64 {
+		if r.Session["User"] == "mallory" {
+			return 95
+		}
+		if len(r.Command) > 0 {
+			return 70
+		}
+		return 10
+	})
+	defer s.Close()
+
+	settings := Settings{
+		Secret:         "secret",
+		Timeout:        10 * time.Second,
+		IncludeCommand: true,
+		Thresholds:     Thresholds{StepUp: 50, Terminate: 90},
+	}
+	sc := New(s.URL, settings)
+
+	for _, c := range []struct {
+		user    string
+		command string
+		action  Action
+	}{
+		{"alice", "", ActionNone},
+		{"alice", "rm -rf /", ActionStepUp},
+		{"mallory", "", ActionTerminate},
+	} {
+		a, action, err := sc.Assess(context.Background(), map[string]string{
+			"User": c.user,
+		}, c.command)
+		if err != nil {
+			t.Errorf("Failed to assess %q: %s", c.user, err)
+			continue
+		}
+		if action != c.action {
+			t.Errorf("Expecting %s for %q running %q, got %s (%v)",
+				c.action, c.user, c.command, action, a.Score)
+		}
+	}
+
+	// Commands are kept private unless asked otherwise
+	settings.IncludeCommand = false
+	sc = New(s.URL, settings)
+	_, action, err := sc.Assess(context.Background(), map[string]string{
+		"User": "alice",
+	}, "rm -rf /")
+	if err != nil || action != ActionNone {
+		t.Errorf("Expecting the command to be left out, got %s, %v",
+			action, err)
+	}
+}
+
+func TestScorerFailure(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+	defer s.Close()
+
+	settings := Settings{Timeout: time.Second}
+	_, action, err := New(s.URL, settings).
+		Assess(context.Background(), nil, "")
+	if err == nil || action != ActionNone {
+		t.Errorf("Expecting failing open, got %s, %v", action, err)
+	}
+
+	settings.FailClosed = true
+	_, action, err = New(s.URL, settings).
+		Assess(context.Background(), nil, "")
+	if err == nil || action != ActionTerminate {
+		t.Errorf("Expecting failing closed, got %s, %v", action, err)
+	}
+
+	var disabled *Scorer
+	_, action, err = disabled.Assess(context.Background(), nil, "")
+	if err != nil || action != ActionNone || disabled.Enabled() {
+		t.Errorf("Expecting a disabled Scorer to pass, got %s, %v",
+			action, err)
+	}
+}
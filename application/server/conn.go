@@ -44,6 +44,7 @@ func (l listener) Accept() (net.Conn, error) {
 		TimeoutConn:  &timeoutConn,
 		readTimeout:  l.readTimeout,
 		writeTimeout: l.writeTimeout,
+		hello:        &clientHelloHolder{},
 	}, nil
 }
 
@@ -54,6 +55,7 @@ type conn struct {
 
 	readTimeout  time.Duration
 	writeTimeout time.Duration
+	hello        *clientHelloHolder
 }
 
 func (c conn) normalizeTimeout(t time.Time, m time.Duration) time.Time {
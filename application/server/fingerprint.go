@@ -0,0 +1,276 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ClientHello is the fingerprints of the TLS ClientHello which the client
+// has sent
+type ClientHello struct {
+	JA3 string
+	JA4 string
+}
+
+// clientHelloKey is the key of the *clientHelloHolder in the context of the
+// requests
+type clientHelloKey struct{}
+
+// clientHelloHolder keeps the ClientHello of a connection, which is known
+// only after the connection has been handed to the http.Server
+type clientHelloHolder struct {
+	hello atomic.Pointer[ClientHello]
+}
+
+// ClientHelloFrom returns the ClientHello of the connection which the request
+// of the `ctx` was received through. It's empty when the connection isn't
+// TLS
+func ClientHelloFrom(ctx context.Context) ClientHello {
+	h, ok := ctx.Value(clientHelloKey{}).(*clientHelloHolder)
+	if !ok {
+		return ClientHello{}
+	}
+
+	hello := h.hello.Load()
+	if hello == nil {
+		return ClientHello{}
+	}
+
+	return *hello
+}
+
+// withClientHello puts the holder of the ClientHello of the connection `c`
+// into the `ctx`
+func withClientHello(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+
+	cc, ok := c.(conn)
+	if !ok || cc.hello == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, clientHelloKey{}, cc.hello)
+}
+
+// recordClientHello fingerprints the `info`, and records it to the
+// connection which it has been received from. It's the GetConfigForClient
+// of the tls.Config, which always uses the original config
+func recordClientHello(info *tls.ClientHelloInfo) (*tls.Config, error) {
+	cc, ok := info.Conn.(conn)
+	if !ok || cc.hello == nil {
+		return nil, nil
+	}
+
+	hello := fingerprintClientHello(info)
+	cc.hello.hello.Store(&hello)
+
+	return nil, nil
+}
+
+// TLS extensions which are treated differently by the fingerprints
+const (
+	tlsExtensionServerName        = 0x0000
+	tlsExtensionALPN              = 0x0010
+	tlsExtensionSupportedVersions = 0x002b
+)
+
+// isGREASE returns whether or not the `v` is a GREASE value (RFC 8701),
+// which is sent randomly by the clients, thus excluded from the fingerprints
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// withoutGREASE returns the `values` without the GREASE values
+func withoutGREASE[T ~uint16](values []T) []uint16 {
+	result := make([]uint16, 0, len(values))
+
+	for _, v := range values {
+		if isGREASE(uint16(v)) {
+			continue
+		}
+
+		result = append(result, uint16(v))
+	}
+
+	return result
+}
+
+// joinValues joins the `values` formatted by the `format` with the `sep`
+func joinValues(values []uint16, format string, sep string) string {
+	b := strings.Builder{}
+
+	for i, v := range values {
+		if i > 0 {
+			b.WriteString(sep)
+		}
+
+		fmt.Fprintf(&b, format, v)
+	}
+
+	return b.String()
+}
+
+// fingerprintClientHello calculates the JA3 and JA4 fingerprints of the
+// `info`
+func fingerprintClientHello(info *tls.ClientHelloInfo) ClientHello {
+	ciphers := withoutGREASE(info.CipherSuites)
+	extensions := withoutGREASE(info.Extensions)
+	versions := withoutGREASE(info.SupportedVersions)
+
+	return ClientHello{
+		JA3: ja3(info, ciphers, extensions, versions),
+		JA4: ja4(info, ciphers, extensions, versions),
+	}
+}
+
+// ja3 calculates the JA3 fingerprint. The ClientHelloInfo doesn't tell the
+// legacy version of the ClientHello, it's assumed to be TLS 1.2 when the
+// supported versions are sent by the client, which is what all the clients
+// that support TLS 1.3 send
+func ja3(
+	info *tls.ClientHelloInfo,
+	ciphers []uint16,
+	extensions []uint16,
+	versions []uint16,
+) string {
+	version := uint16(tls.VersionTLS12)
+	if !slices.Contains(extensions, tlsExtensionSupportedVersions) &&
+		len(versions) > 0 {
+		version = slices.Max(versions)
+	}
+
+	points := make([]uint16, len(info.SupportedPoints))
+	for i, p := range info.SupportedPoints {
+		points[i] = uint16(p)
+	}
+
+	s := strings.Join([]string{
+		strconv.FormatUint(uint64(version), 10),
+		joinValues(ciphers, "%d", "-"),
+		joinValues(extensions, "%d", "-"),
+		joinValues(withoutGREASE(info.SupportedCurves), "%d", "-"),
+		joinValues(points, "%d", "-"),
+	}, ",")
+
+	sum := md5.Sum([]byte(s))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// ja4Versions are the version codes used by the JA4 fingerprint
+var ja4Versions = map[uint16]string{
+	tls.VersionTLS13: "13",
+	tls.VersionTLS12: "12",
+	tls.VersionTLS11: "11",
+	tls.VersionTLS10: "10",
+	0x0300:           "s3",
+}
+
+// ja4Hash returns the truncated SHA256 of `s` used by the JA4 fingerprint,
+// or zeros when `s` is empty
+func ja4Hash(s string) string {
+	if len(s) <= 0 {
+		return "000000000000"
+	}
+
+	sum := sha256.Sum256([]byte(s))
+
+	return hex.EncodeToString(sum[:6])
+}
+
+// ja4ALPN returns the first and the last character of the first ALPN the
+// client has offered
+func ja4ALPN(protos []string) string {
+	if len(protos) <= 0 || len(protos[0]) <= 0 {
+		return "00"
+	}
+
+	first, last := protos[0][0], protos[0][len(protos[0])-1]
+	isAlphanumeric := func(c byte) bool {
+		return (c >= '0' && c <= '9') ||
+			(c >= 'a' && c <= 'z') ||
+			(c >= 'A' && c <= 'Z')
+	}
+
+	if isAlphanumeric(first) && isAlphanumeric(last) {
+		return string([]byte{first, last})
+	}
+
+	return hex.EncodeToString([]byte{first})[:1] +
+		hex.EncodeToString([]byte{last})[1:]
+}
+
+// ja4 calculates the JA4 fingerprint of the ClientHello received through TCP
+func ja4(
+	info *tls.ClientHelloInfo,
+	ciphers []uint16,
+	extensions []uint16,
+	versions []uint16,
+) string {
+	version := "00"
+	if len(versions) > 0 {
+		if v, ok := ja4Versions[slices.Max(versions)]; ok {
+			version = v
+		}
+	}
+
+	sni := "i"
+	if slices.Contains(extensions, tlsExtensionServerName) {
+		sni = "d"
+	}
+
+	a := fmt.Sprintf("t%s%s%02d%02d%s",
+		version, sni,
+		min(len(ciphers), 99), min(len(extensions), 99),
+		ja4ALPN(info.SupportedProtos))
+
+	sortedCiphers := slices.Sorted(slices.Values(ciphers))
+
+	sortedExtensions := make([]uint16, 0, len(extensions))
+	for _, e := range extensions {
+		if e == tlsExtensionServerName || e == tlsExtensionALPN {
+			continue
+		}
+
+		sortedExtensions = append(sortedExtensions, e)
+	}
+	slices.Sort(sortedExtensions)
+
+	c := joinValues(sortedExtensions, "%04x", ",")
+	if len(c) > 0 && len(info.SignatureSchemes) > 0 {
+		c += "_" + joinValues(
+			withoutGREASE(info.SignatureSchemes), "%04x", ",")
+	}
+
+	return a + "_" +
+		ja4Hash(joinValues(sortedCiphers, "%04x", ",")) + "_" +
+		ja4Hash(c)
+}
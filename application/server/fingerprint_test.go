@@ -0,0 +1,93 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+)
+
+func testClientHello(grease bool) *tls.ClientHelloInfo {
+	info := &tls.ClientHelloInfo{
+		CipherSuites: []uint16{
+			tls.TLS_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		},
+		ServerName:        "example.com",
+		SupportedCurves:   []tls.CurveID{tls.X25519, tls.CurveP256},
+		SupportedPoints:   []uint8{0},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedProtos:   []string{"h2", "http/1.1"},
+		SupportedVersions: []uint16{tls.VersionTLS13, tls.VersionTLS12},
+		Extensions:        []uint16{0x0000, 0x0010, 0x000a, 0x000b, 0x002b},
+	}
+
+	if grease {
+		info.CipherSuites = append([]uint16{0x1a1a}, info.CipherSuites...)
+		info.SupportedCurves = append(
+			[]tls.CurveID{0x2a2a}, info.SupportedCurves...)
+		info.SupportedVersions = append(
+			[]uint16{0x3a3a}, info.SupportedVersions...)
+		info.Extensions = append([]uint16{0x4a4a}, info.Extensions...)
+	}
+
+	return info
+}
+
+func TestFingerprintClientHello(t *testing.T) {
+	hello := fingerprintClientHello(testClientHello(false))
+
+	if len(hello.JA3) != 32 {
+		t.Errorf("Expecting a MD5 JA3, got %q", hello.JA3)
+	}
+
+	if !strings.HasPrefix(hello.JA4, "t13d0205h2_") {
+		t.Errorf("Expecting the JA4 to start with %q, got %q",
+			"t13d0205h2_", hello.JA4)
+	}
+
+	if parts := strings.Split(hello.JA4, "_"); len(parts) != 3 ||
+		len(parts[1]) != 12 || len(parts[2]) != 12 {
+		t.Errorf("Expecting 3 parts of JA4, got %q", hello.JA4)
+	}
+
+	greased := fingerprintClientHello(testClientHello(true))
+
+	if greased != hello {
+		t.Errorf("Expecting GREASE to be ignored, got %v and %v",
+			greased, hello)
+	}
+}
+
+func TestJA4ALPN(t *testing.T) {
+	for _, test := range []struct {
+		protos   []string
+		expected string
+	}{
+		{nil, "00"},
+		{[]string{"h2"}, "h2"},
+		{[]string{"http/1.1"}, "h1"},
+		{[]string{"\xab\xcd"}, "ad"},
+	} {
+		if r := ja4ALPN(test.protos); r != test.expected {
+			t.Errorf("Expecting %q for %q, got %q",
+				test.expected, test.protos, r)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePort sets SO_REUSEPORT on the socket `c`, so other processes can
+// listen on the same address as well
+func reusePort(network, address string, c syscall.RawConn) error {
+	var err error
+
+	cErr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(
+			int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if cErr != nil {
+		return cErr
+	}
+
+	return err
+}
@@ -0,0 +1,47 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenTCPReusePort(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+	first, err := listenTCP("tcp4", addr, true)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defer first.Close()
+
+	addr = first.Addr().(*net.TCPAddr)
+
+	second, err := listenTCP("tcp4", addr, true)
+	if err != nil {
+		t.Fatal("Expecting the port to be shared, got", err)
+	}
+	defer second.Close()
+
+	if _, err := listenTCP("tcp4", addr, false); err == nil {
+		t.Error("Expecting the port to be taken without ReusePort")
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !(linux || darwin || freebsd || openbsd || netbsd || dragonfly)
+
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package server
+
+import (
+	"syscall"
+)
+
+// reusePort returns ErrReusePortUnsupported as SO_REUSEPORT is not supported
+// on current platform
+func reusePort(network, address string, c syscall.RawConn) error {
+	return ErrReusePortUnsupported
+}
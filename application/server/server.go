@@ -26,11 +26,13 @@ import (
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nirui/sshwifty/application/command"
 	"github.com/nirui/sshwifty/application/configuration"
 	"github.com/nirui/sshwifty/application/log"
+	"github.com/nirui/sshwifty/application/upgrade"
 )
 
 type dumpWrite struct{}
@@ -43,6 +45,9 @@ func (d dumpWrite) Write(b []byte) (int, error) {
 var (
 	ErrInvalidIPAddress = errors.New(
 		"invalid IP address")
+
+	ErrReusePortUnsupported = errors.New(
+		"ReusePort is not supported on this platform")
 )
 
 // HandlerBuilder builds a HTTP handler
@@ -60,19 +65,46 @@ type CloseCallback func(error)
 // Server represents a server
 type Server struct {
 	logger       log.Logger
+	inherited    *upgrade.Inherited
 	shutdownWait *sync.WaitGroup
 }
 
 // Serving represents a server that is serving for requests
 type Serving struct {
 	server       http.Server
+	inherited    *upgrade.Inherited
 	shutdownWait *sync.WaitGroup
+	listening    chan struct{}
+	address      string
+	listener     *net.TCPListener
+	logger       log.Logger
+	handler      *handler
+	certificate  atomic.Pointer[tls.Certificate]
+}
+
+// handler serves the requests with the handler it's last given, so the
+// handler can be replaced while serving
+type handler struct {
+	current atomic.Pointer[http.Handler]
+}
+
+func newHandler(h http.Handler) *handler {
+	hh := &handler{}
+	hh.current.Store(&h)
+
+	return hh
 }
 
-// New creates a new Server builder
-func New(logger log.Logger) Server {
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*h.current.Load()).ServeHTTP(w, r)
+}
+
+// New creates a new Server builder. The servers take the listeners in the
+// `inherited` instead of listening by themselves when possible
+func New(logger log.Logger, inherited *upgrade.Inherited) Server {
 	return Server{
 		logger:       logger,
+		inherited:    inherited,
 		shutdownWait: &sync.WaitGroup{},
 	}
 }
@@ -87,18 +119,41 @@ func (s Server) Serve(
 	ssCfg := serverCfg.WithDefault()
 	l := s.logger.Context(
 		"Server (%s:%d)", ssCfg.ListenInterface, ssCfg.ListenPort)
+	h := newHandler(handlerBuilder(commonCfg, ssCfg, l))
 	ss := &Serving{
 		server: http.Server{
-			Handler:           handlerBuilder(commonCfg, ssCfg, l),
-			TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12},
+			Handler: h,
+			TLSConfig: &tls.Config{
+				MinVersion:         tls.VersionTLS12,
+				GetConfigForClient: recordClientHello,
+			},
 			ReadTimeout:       ssCfg.ReadTimeout,
 			ReadHeaderTimeout: ssCfg.InitialTimeout,
 			WriteTimeout:      ssCfg.WriteTimeout,
 			IdleTimeout:       ssCfg.ReadTimeout,
 			MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
 			ErrorLog:          goLog.New(dumpWrite{}, "", 0),
+			ConnContext:       withClientHello,
 		},
+		inherited:    s.inherited,
 		shutdownWait: s.shutdownWait,
+		listening:    make(chan struct{}),
+		logger:       l,
+		handler:      h,
+	}
+	ss.server.TLSConfig.GetCertificate = ss.getCertificate
+	if len(commonCfg.ManagementToken) > 0 {
+		// The gRPC management API requires HTTP/2, which must then be
+		// served without TLS as well. Pings keep the idle streams from
+		// being cut by the read timeout
+		protocols := http.Protocols{}
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		ss.server.Protocols = &protocols
+		ss.server.HTTP2 = &http.HTTP2Config{
+			SendPingTimeout: ssCfg.ReadTimeout / 2,
+		}
 	}
 	s.shutdownWait.Add(1)
 	go ss.run(l, ssCfg, closeCallback)
@@ -112,7 +167,9 @@ func (s Server) Wait() {
 
 func (s *Serving) buildListener(
 	ip string,
+	family configuration.ListenFamily,
 	port uint16,
+	reuse bool,
 	readTimeout time.Duration,
 	writeTimeout time.Duration,
 ) (listener, error) {
@@ -122,14 +179,26 @@ func (s *Serving) buildListener(
 	}
 	ipPort := net.JoinHostPort(
 		ipAddr.String(), strconv.FormatInt(int64(port), 10))
-	addr, addrErr := net.ResolveTCPAddr("tcp", ipPort)
+	inherited, inheritErr := s.inherited.Listener(ipPort)
+	if inheritErr != nil {
+		return listener{}, inheritErr
+	} else if inherited != nil {
+		s.address = ipPort
+		return listener{
+			TCPListener:  inherited,
+			readTimeout:  readTimeout,
+			writeTimeout: writeTimeout,
+		}, nil
+	}
+	addr, addrErr := net.ResolveTCPAddr(family.Network(), ipPort)
 	if addrErr != nil {
 		return listener{}, addrErr
 	}
-	ll, llErr := net.ListenTCP("tcp", addr)
+	ll, llErr := listenTCP(family.Network(), addr, reuse)
 	if llErr != nil {
 		return listener{}, llErr
 	}
+	s.address = ipPort
 	return listener{
 		TCPListener:  ll,
 		readTimeout:  readTimeout,
@@ -137,6 +206,23 @@ func (s *Serving) buildListener(
 	}, nil
 }
 
+// listenTCP listens on the `addr`, with SO_REUSEPORT set when `reuse` is true
+func listenTCP(
+	network string,
+	addr *net.TCPAddr,
+	reuse bool,
+) (*net.TCPListener, error) {
+	if !reuse {
+		return net.ListenTCP(network, addr)
+	}
+	lc := net.ListenConfig{Control: reusePort}
+	l, err := lc.Listen(context.Background(), network, addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return l.(*net.TCPListener), nil
+}
+
 // run starts the server
 func (s *Serving) run(
 	logger log.Logger,
@@ -155,7 +241,9 @@ func (s *Serving) run(
 	}()
 	ls, err := s.buildListener(
 		cfg.ListenInterface,
+		cfg.ListenFamily,
 		cfg.ListenPort,
+		cfg.ReusePort,
 		cfg.ReadTimeout,
 		cfg.WriteTimeout,
 	)
@@ -163,17 +251,94 @@ func (s *Serving) run(
 		return err
 	}
 	defer ls.Close()
+	if cfg.IsTLS() {
+		cert, certErr := loadCertificate(cfg)
+		if certErr != nil {
+			err = certErr
+			return err
+		}
+		s.certificate.Store(cert)
+	}
+	s.listener = ls.TCPListener
+	close(s.listening)
 	if !cfg.IsTLS() {
 		logger.Info("Serving")
 		err = s.server.Serve(ls)
 	} else {
 		logger.Info("Serving TLS")
-		err = s.server.ServeTLS(
-			ls, cfg.TLSCertificateFile, cfg.TLSCertificateKeyFile)
+		err = s.server.ServeTLS(ls, "", "")
 	}
 	return err
 }
 
+// loadCertificate loads the TLS certificate of the `cfg`
+func loadCertificate(cfg configuration.Server) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(
+		cfg.TLSCertificateFile, cfg.TLSCertificateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// getCertificate returns the TLS certificate last loaded
+func (s *Serving) getCertificate(
+	*tls.ClientHelloInfo,
+) (*tls.Certificate, error) {
+	return s.certificate.Load(), nil
+}
+
+// Reload prepares to serve the new requests with the handler built from the
+// `commonCfg` and the `cfg`, and the TLS certificate loaded again. The
+// returned function applies them, the connections that are already accepted
+// are not affected. The `cfg` must only differ from the one the server is
+// started with in the settings that are reloadable (see
+// configuration.Configuration.Reloadable)
+func (s *Serving) Reload(
+	commonCfg configuration.Common,
+	cfg configuration.Server,
+	handlerBuilder HandlerBuilder,
+) (func(), error) {
+	ssCfg := cfg.WithDefault()
+	var cert *tls.Certificate
+	if ssCfg.IsTLS() {
+		c, err := loadCertificate(ssCfg)
+		if err != nil {
+			return nil, err
+		}
+		cert = c
+	}
+	return func() {
+		h := handlerBuilder(commonCfg, ssCfg, s.logger)
+		s.handler.current.Store(&h)
+		if cert != nil {
+			s.certificate.Store(cert)
+		}
+	}, nil
+}
+
+// Listening waits until the server has started listening. It returns false
+// when `closed` is closed before that, i.e. the server has failed
+func (s *Serving) Listening(closed <-chan struct{}) bool {
+	select {
+	case <-s.listening:
+		return true
+	case <-closed:
+		return false
+	}
+}
+
+// Listener returns the address and the listener of the server, nil when the
+// server is not listening yet
+func (s *Serving) Listener() (string, *net.TCPListener) {
+	select {
+	case <-s.listening:
+		return s.address, s.listener
+	default:
+		return "", nil
+	}
+}
+
 // Close close the server
 func (s *Serving) Close() error {
 	return s.server.Shutdown(context.TODO())
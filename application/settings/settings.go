@@ -0,0 +1,226 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// Errors
+var (
+	ErrInvalidUser = errors.New(
+		"invalid user identity")
+
+	ErrInvalidTheme = errors.New(
+		"theme must be either empty, \"dark\" or \"light\"")
+
+	ErrInvalidFontSize = errors.New(
+		"font size must be either 0 or between 8 and 36")
+
+	ErrInvalidAuthMethod = errors.New(
+		"auth method must be either empty, \"Password\", \"Private Key\" " +
+			"or \"None\"")
+
+	ErrInvalidTerminalType = errors.New(
+		"terminal type must be a valid terminal name")
+)
+
+// Font size limits, same as the ones of the client
+const (
+	MinFontSize = 8
+	MaxFontSize = 36
+)
+
+const (
+	userMaxLen = 128
+)
+
+var (
+	terminalTypeVerifier = regexp.MustCompile("^[0-9A-Za-z_.+-]{1,32}$")
+)
+
+var (
+	stores     = map[string]*Store{}
+	storesLock = sync.Mutex{}
+)
+
+// Settings contains the default settings of a user. Zero values tell the
+// client to use it's own default
+type Settings struct {
+	Theme        string `json:"theme"`
+	FontSize     int    `json:"font_size"`
+	AuthMethod   string `json:"auth_method"`
+	TerminalType string `json:"terminal_type"`
+}
+
+// Verify verifies the settings
+func (s Settings) Verify() error {
+	switch s.Theme {
+	case "", "dark", "light":
+	default:
+		return ErrInvalidTheme
+	}
+
+	if s.FontSize != 0 &&
+		(s.FontSize < MinFontSize || s.FontSize > MaxFontSize) {
+		return ErrInvalidFontSize
+	}
+
+	switch s.AuthMethod {
+	case "", "Password", "Private Key", "None":
+	default:
+		return ErrInvalidAuthMethod
+	}
+
+	if len(s.TerminalType) > 0 &&
+		!terminalTypeVerifier.MatchString(s.TerminalType) {
+		return ErrInvalidTerminalType
+	}
+
+	return nil
+}
+
+// Store stores the Settings of users in a JSON file
+type Store struct {
+	path  string
+	lock  sync.RWMutex
+	users map[string]Settings
+}
+
+// Open opens the Store saved in the given file. Stores of the same file will
+// be shared, so multiple servers can access the same Store safely
+func Open(path string) (*Store, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	storesLock.Lock()
+	defer storesLock.Unlock()
+
+	if s, ok := stores[absPath]; ok {
+		return s, nil
+	}
+
+	s := &Store{
+		path:  absPath,
+		lock:  sync.RWMutex{},
+		users: map[string]Settings{},
+	}
+
+	err = s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	stores[absPath] = s
+
+	return s, nil
+}
+
+func (s *Store) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to read user settings %q: %s", s.path, err)
+	}
+
+	err = json.Unmarshal(data, &s.users)
+	if err != nil {
+		return fmt.Errorf("unable to parse user settings %q: %s", s.path, err)
+	}
+
+	for u, st := range s.users {
+		err = st.Verify()
+		if err != nil {
+			return fmt.Errorf("invalid settings of user %q in %q: %s",
+				u, s.path, err)
+		}
+	}
+
+	return nil
+}
+
+// save writes the settings of all users into the file. Caller must hold the
+// write lock
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.users, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.path + ".tmp"
+
+	err = os.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, s.path)
+}
+
+// Get returns the Settings of the `user`. Zero Settings will be returned when
+// the user has not saved any
+func (s *Store) Get(user string) Settings {
+	if s == nil {
+		return Settings{}
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.users[user]
+}
+
+// Set verifies and saves the Settings of the `user`
+func (s *Store) Set(user string, st Settings) error {
+	if len(user) <= 0 || len(user) > userMaxLen {
+		return ErrInvalidUser
+	}
+
+	err := st.Verify()
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	old, existed := s.users[user]
+	s.users[user] = st
+
+	err = s.save()
+	if err == nil {
+		return nil
+	}
+
+	if existed {
+		s.users[user] = old
+	} else {
+		delete(s.users, user)
+	}
+
+	return err
+}
@@ -0,0 +1,95 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package settings
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSettingsVerify(t *testing.T) {
+	tests := []struct {
+		settings Settings
+		expected error
+	}{
+		{Settings{}, nil},
+		{Settings{Theme: "light", FontSize: 20, AuthMethod: "Private Key",
+			TerminalType: "xterm-256color"}, nil},
+		{Settings{Theme: "blue"}, ErrInvalidTheme},
+		{Settings{FontSize: 7}, ErrInvalidFontSize},
+		{Settings{FontSize: 37}, ErrInvalidFontSize},
+		{Settings{AuthMethod: "password"}, ErrInvalidAuthMethod},
+		{Settings{TerminalType: "xterm\r\nexit"}, ErrInvalidTerminalType},
+	}
+
+	for i, test := range tests {
+		err := test.settings.Verify()
+		if err != test.expected {
+			t.Errorf("Test %d: Expecting error %v, got %v",
+				i, test.expected, err)
+			return
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Error("Failed to open store:", err)
+		return
+	}
+
+	if st := s.Get("user"); st != (Settings{}) {
+		t.Errorf("Expecting empty settings, got %+v", st)
+		return
+	}
+
+	expected := Settings{Theme: "dark", FontSize: 18}
+
+	err = s.Set("user", expected)
+	if err != nil {
+		t.Error("Failed to save settings:", err)
+		return
+	}
+
+	if s.Set("", expected) != ErrInvalidUser {
+		t.Error("Expecting settings of an anonymous user to be rejected")
+		return
+	}
+
+	if s.Set("user", Settings{Theme: "blue"}) != ErrInvalidTheme {
+		t.Error("Expecting invalid settings to be rejected")
+		return
+	}
+
+	// Reload from the file
+	reloaded := &Store{path: s.path, users: map[string]Settings{}}
+
+	err = reloaded.load()
+	if err != nil {
+		t.Error("Failed to reload store:", err)
+		return
+	}
+
+	if st := reloaded.Get("user"); st != expected {
+		t.Errorf("Expecting settings %+v after reload, got %+v", expected, st)
+		return
+	}
+}
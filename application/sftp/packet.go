@@ -0,0 +1,193 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftp
+
+import (
+	"encoding/binary"
+	"os"
+	"time"
+)
+
+// packet builds the payload of a packet
+type packet []byte
+
+func (p *packet) uint32(v uint32) {
+	*p = binary.BigEndian.AppendUint32(*p, v)
+}
+
+func (p *packet) uint64(v uint64) {
+	*p = binary.BigEndian.AppendUint64(*p, v)
+}
+
+func (p *packet) bytes(b []byte) {
+	p.uint32(uint32(len(b)))
+	*p = append(*p, b...)
+}
+
+func (p *packet) string(s string) {
+	p.uint32(uint32(len(s)))
+	*p = append(*p, s...)
+}
+
+// reader reads the payload of a packet
+type reader []byte
+
+func (r *reader) uint32() (uint32, bool) {
+	if len(*r) < 4 {
+		return 0, false
+	}
+
+	v := binary.BigEndian.Uint32(*r)
+	*r = (*r)[4:]
+
+	return v, true
+}
+
+func (r *reader) uint64() (uint64, bool) {
+	if len(*r) < 8 {
+		return 0, false
+	}
+
+	v := binary.BigEndian.Uint64(*r)
+	*r = (*r)[8:]
+
+	return v, true
+}
+
+func (r *reader) string() (string, bool) {
+	sLen, ok := r.uint32()
+	if !ok || uint64(len(*r)) < uint64(sLen) {
+		return "", false
+	}
+
+	s := string((*r)[:sLen])
+	*r = (*r)[sLen:]
+
+	return s, true
+}
+
+// attrs reads the file attributes. The Name of the result is left empty
+func (r *reader) attrs() (FileInfo, bool) {
+	info := FileInfo{}
+
+	flags, ok := r.uint32()
+	if !ok {
+		return FileInfo{}, false
+	}
+
+	if flags&attrSize != 0 {
+		if info.Size, ok = r.uint64(); !ok {
+			return FileInfo{}, false
+		}
+	}
+
+	if flags&attrUIDGID != 0 {
+		if _, ok = r.uint64(); !ok {
+			return FileInfo{}, false
+		}
+	}
+
+	if flags&attrPermissions != 0 {
+		perm, ok := r.uint32()
+		if !ok {
+			return FileInfo{}, false
+		}
+
+		info.Mode = fileMode(perm)
+	}
+
+	if flags&attrACModTime != 0 {
+		if _, ok = r.uint32(); !ok {
+			return FileInfo{}, false
+		}
+
+		mtime, ok := r.uint32()
+		if !ok {
+			return FileInfo{}, false
+		}
+
+		info.ModTime = time.Unix(int64(mtime), 0)
+	}
+
+	if flags&attrExtended != 0 {
+		count, ok := r.uint32()
+		if !ok {
+			return FileInfo{}, false
+		}
+
+		for i := uint32(0); i < count*2; i++ {
+			if _, ok = r.string(); !ok {
+				return FileInfo{}, false
+			}
+		}
+	}
+
+	return info, true
+}
+
+// names reads the entries of a name packet
+func (r *reader) names() ([]FileInfo, error) {
+	count, ok := r.uint32()
+	if !ok {
+		return nil, ErrMalformedPacket
+	}
+
+	// Every entry takes at least 12 bytes, don't trust the count blindly
+	if uint64(count)*12 > uint64(len(*r)) {
+		return nil, ErrMalformedPacket
+	}
+
+	names := make([]FileInfo, 0, count)
+
+	for i := uint32(0); i < count; i++ {
+		name, ok := r.string()
+		if !ok {
+			return nil, ErrMalformedPacket
+		}
+
+		// The long name, which is meant for human
+		if _, ok = r.string(); !ok {
+			return nil, ErrMalformedPacket
+		}
+
+		info, ok := r.attrs()
+		if !ok {
+			return nil, ErrMalformedPacket
+		}
+
+		info.Name = name
+		names = append(names, info)
+	}
+
+	return names, nil
+}
+
+// fileMode converts the SFTP permissions to os.FileMode
+func fileMode(perm uint32) os.FileMode {
+	mode := os.FileMode(perm & 0777)
+
+	switch perm & modeTypeMask {
+	case modeDir:
+		mode |= os.ModeDir
+
+	case modeSymlink:
+		mode |= os.ModeSymlink
+	}
+
+	return mode
+}
@@ -0,0 +1,611 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package sftp implements a minimal SFTP (version 3) client which is just
+// enough to browse the remote file system and to transfer files.
+//
+// See draft-ietf-secsh-filexfer-02 for the protocol
+package sftp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Packet types
+const (
+	packetInit     = 1
+	packetVersion  = 2
+	packetOpen     = 3
+	packetClose    = 4
+	packetRead     = 5
+	packetWrite    = 6
+	packetOpenDir  = 11
+	packetReadDir  = 12
+	packetRemove   = 13
+	packetMkdir    = 14
+	packetRmdir    = 15
+	packetRealPath = 16
+	packetStat     = 17
+	packetRename   = 18
+	packetStatus   = 101
+	packetHandle   = 102
+	packetData     = 103
+	packetName     = 104
+	packetAttrs    = 105
+)
+
+// Status codes
+const (
+	StatusOK               = 0
+	StatusEOF              = 1
+	StatusNoSuchFile       = 2
+	StatusPermissionDenied = 3
+	StatusFailure          = 4
+)
+
+// Open flags
+const (
+	openRead     = 0x01
+	openWrite    = 0x02
+	openCreate   = 0x08
+	openTruncate = 0x10
+)
+
+// Attribute flags
+const (
+	attrSize        = 0x00000001
+	attrUIDGID      = 0x00000002
+	attrPermissions = 0x00000004
+	attrACModTime   = 0x00000008
+	attrExtended    = 0x80000000
+)
+
+// File type bits of the permissions
+const (
+	modeTypeMask = 0170000
+	modeDir      = 0040000
+	modeSymlink  = 0120000
+)
+
+const (
+	protocolVersion = 3
+
+	// maxPacketSize limits the size of the packets accepted from the
+	// server, so a misbehaving one cannot make us allocate too much
+	maxPacketSize = 256 * 1024
+
+	// MaxDataSize is the max amount of data that will be read or written
+	// through a single request
+	MaxDataSize = 32 * 1024
+)
+
+// Errors
+var (
+	ErrUnsupportedVersion = errors.New(
+		"unsupported SFTP version")
+
+	ErrPacketTooLarge = errors.New(
+		"SFTP packet was too large")
+
+	ErrMalformedPacket = errors.New(
+		"malformed SFTP packet")
+
+	ErrUnexpectedPacket = errors.New(
+		"unexpected SFTP packet")
+
+	ErrClosed = errors.New(
+		"SFTP client has been closed")
+)
+
+// StatusError is the error reported by the server through a status packet
+type StatusError struct {
+	Code    uint32
+	Message string
+}
+
+// Error returns the error message
+func (s *StatusError) Error() string {
+	if len(s.Message) > 0 {
+		return s.Message
+	}
+
+	return fmt.Sprintf("SFTP request has failed with status %d", s.Code)
+}
+
+// Is reports whether the status matches `target`, which allows
+// errors.Is(err, os.ErrNotExist) and errors.Is(err, os.ErrPermission)
+func (s *StatusError) Is(target error) bool {
+	switch target {
+	case os.ErrNotExist:
+		return s.Code == StatusNoSuchFile
+
+	case os.ErrPermission:
+		return s.Code == StatusPermissionDenied
+	}
+
+	return false
+}
+
+// FileInfo is the information of a remote file
+type FileInfo struct {
+	Name    string
+	Size    uint64
+	Mode    os.FileMode
+	ModTime time.Time
+}
+
+// IsDir returns whether or not the file is a directory
+func (f FileInfo) IsDir() bool {
+	return f.Mode.IsDir()
+}
+
+// Client is a SFTP client. Requests are sent one after another, so it's
+// safe to use it concurrently but it will not pipeline requests
+type Client struct {
+	w      io.WriteCloser
+	r      io.Reader
+	lock   sync.Mutex
+	nextID uint32
+	closed bool
+}
+
+// New starts a SFTP session with the server through `w` and `r`, which are
+// usually the stdin and stdout of the "sftp" SSH subsystem
+func New(w io.WriteCloser, r io.Reader) (*Client, error) {
+	c := &Client{
+		w:      w,
+		r:      r,
+		lock:   sync.Mutex{},
+		nextID: 0,
+		closed: false,
+	}
+
+	b := packet{}
+	b.uint32(protocolVersion)
+
+	if err := c.writePacket(packetInit, b); err != nil {
+		return nil, err
+	}
+
+	t, p, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+
+	if t != packetVersion {
+		return nil, ErrUnexpectedPacket
+	}
+
+	version, ok := p.uint32()
+	if !ok {
+		return nil, ErrMalformedPacket
+	}
+
+	if version < protocolVersion {
+		return nil, ErrUnsupportedVersion
+	}
+
+	return c, nil
+}
+
+// Close closes the session
+func (c *Client) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+
+	return c.w.Close()
+}
+
+func (c *Client) writePacket(t byte, p packet) error {
+	h := [5]byte{}
+	binary.BigEndian.PutUint32(h[:4], uint32(len(p)+1))
+	h[4] = t
+
+	if _, err := c.w.Write(append(h[:], p...)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) readPacket() (byte, reader, error) {
+	h := [5]byte{}
+
+	if _, err := io.ReadFull(c.r, h[:]); err != nil {
+		return 0, nil, err
+	}
+
+	pLen := binary.BigEndian.Uint32(h[:4])
+
+	if pLen < 1 {
+		return 0, nil, ErrMalformedPacket
+	}
+
+	if pLen > maxPacketSize {
+		return 0, nil, ErrPacketTooLarge
+	}
+
+	p := make([]byte, pLen-1)
+
+	if _, err := io.ReadFull(c.r, p); err != nil {
+		return 0, nil, err
+	}
+
+	return h[4], reader(p), nil
+}
+
+// request sends the request and returns the response of it. The request ID
+// is filled automatically and must not be included in the `payload`
+func (c *Client) request(t byte, payload packet) (byte, reader, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return 0, nil, ErrClosed
+	}
+
+	c.nextID++
+	id := c.nextID
+
+	p := packet{}
+	p.uint32(id)
+
+	if err := c.writePacket(t, append(p, payload...)); err != nil {
+		return 0, nil, err
+	}
+
+	rt, r, err := c.readPacket()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	rID, ok := r.uint32()
+	if !ok {
+		return 0, nil, ErrMalformedPacket
+	}
+
+	if rID != id {
+		return 0, nil, ErrUnexpectedPacket
+	}
+
+	return rt, r, nil
+}
+
+// status converts the status packet to an error. Status OK results nil, and
+// EOF results io.EOF
+func status(r reader) error {
+	code, ok := r.uint32()
+	if !ok {
+		return ErrMalformedPacket
+	}
+
+	switch code {
+	case StatusOK:
+		return nil
+
+	case StatusEOF:
+		return io.EOF
+	}
+
+	// The message is not sent by some old servers
+	message, _ := r.string()
+
+	return &StatusError{
+		Code:    code,
+		Message: message,
+	}
+}
+
+// expectStatus sends a request which is only answered by a status packet
+func (c *Client) expectStatus(t byte, p packet) error {
+	rt, r, err := c.request(t, p)
+	if err != nil {
+		return err
+	}
+
+	if rt != packetStatus {
+		return ErrUnexpectedPacket
+	}
+
+	return status(r)
+}
+
+// expectHandle sends a request which is answered by a handle packet
+func (c *Client) expectHandle(t byte, p packet) (string, error) {
+	rt, r, err := c.request(t, p)
+	if err != nil {
+		return "", err
+	}
+
+	switch rt {
+	case packetHandle:
+		handle, ok := r.string()
+		if !ok {
+			return "", ErrMalformedPacket
+		}
+
+		return handle, nil
+
+	case packetStatus:
+		if err := status(r); err != nil {
+			return "", err
+		}
+	}
+
+	return "", ErrUnexpectedPacket
+}
+
+// expectName sends a request which is answered by a name packet
+func (c *Client) expectName(t byte, p packet) ([]FileInfo, error) {
+	rt, r, err := c.request(t, p)
+	if err != nil {
+		return nil, err
+	}
+
+	switch rt {
+	case packetName:
+		return r.names()
+
+	case packetStatus:
+		if err := status(r); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, ErrUnexpectedPacket
+}
+
+func (c *Client) closeHandle(handle string) error {
+	p := packet{}
+	p.string(handle)
+
+	return c.expectStatus(packetClose, p)
+}
+
+// RealPath returns the absolute path of the `path`. Use "." to get the
+// current (usually the home) directory
+func (c *Client) RealPath(path string) (string, error) {
+	p := packet{}
+	p.string(path)
+
+	names, err := c.expectName(packetRealPath, p)
+	if err != nil {
+		return "", err
+	}
+
+	if len(names) != 1 {
+		return "", ErrMalformedPacket
+	}
+
+	return names[0].Name, nil
+}
+
+// Stat returns the information of the file at `path`, following symlinks
+func (c *Client) Stat(path string) (FileInfo, error) {
+	p := packet{}
+	p.string(path)
+
+	rt, r, err := c.request(packetStat, p)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	switch rt {
+	case packetAttrs:
+		info, ok := r.attrs()
+		if !ok {
+			return FileInfo{}, ErrMalformedPacket
+		}
+
+		return info, nil
+
+	case packetStatus:
+		if err := status(r); err != nil {
+			return FileInfo{}, err
+		}
+	}
+
+	return FileInfo{}, ErrUnexpectedPacket
+}
+
+// ReadDir lists the directory at `path`. The "." and ".." entries are
+// excluded
+func (c *Client) ReadDir(path string) ([]FileInfo, error) {
+	p := packet{}
+	p.string(path)
+
+	handle, err := c.expectHandle(packetOpenDir, p)
+	if err != nil {
+		return nil, err
+	}
+	defer c.closeHandle(handle)
+
+	entries := make([]FileInfo, 0, 32)
+
+	for {
+		p := packet{}
+		p.string(handle)
+
+		names, err := c.expectName(packetReadDir, p)
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, name := range names {
+			if name.Name == "." || name.Name == ".." {
+				continue
+			}
+
+			entries = append(entries, name)
+		}
+	}
+}
+
+// Remove removes the file at `path`
+func (c *Client) Remove(path string) error {
+	p := packet{}
+	p.string(path)
+
+	return c.expectStatus(packetRemove, p)
+}
+
+// RemoveDirectory removes the empty directory at `path`
+func (c *Client) RemoveDirectory(path string) error {
+	p := packet{}
+	p.string(path)
+
+	return c.expectStatus(packetRmdir, p)
+}
+
+// Mkdir creates a directory at `path`
+func (c *Client) Mkdir(path string) error {
+	p := packet{}
+	p.string(path)
+	p.uint32(0)
+
+	return c.expectStatus(packetMkdir, p)
+}
+
+// Rename renames the file `from` to `to`
+func (c *Client) Rename(from string, to string) error {
+	p := packet{}
+	p.string(from)
+	p.string(to)
+
+	return c.expectStatus(packetRename, p)
+}
+
+// Open opens the file at `path` for reading
+func (c *Client) Open(path string) (*File, error) {
+	return c.open(path, openRead)
+}
+
+// Create creates or truncates the file at `path` for writing
+func (c *Client) Create(path string) (*File, error) {
+	return c.open(path, openWrite|openCreate|openTruncate)
+}
+
+func (c *Client) open(path string, flags uint32) (*File, error) {
+	p := packet{}
+	p.string(path)
+	p.uint32(flags)
+	p.uint32(0)
+
+	handle, err := c.expectHandle(packetOpen, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		c:      c,
+		handle: handle,
+		offset: 0,
+	}, nil
+}
+
+// File is an opened remote file
+type File struct {
+	c      *Client
+	handle string
+	offset uint64
+}
+
+// Read reads up to len(b) (but no more than MaxDataSize) bytes of data from
+// the file
+func (f *File) Read(b []byte) (int, error) {
+	bLen := len(b)
+
+	if bLen > MaxDataSize {
+		bLen = MaxDataSize
+	}
+
+	p := packet{}
+	p.string(f.handle)
+	p.uint64(f.offset)
+	p.uint32(uint32(bLen))
+
+	rt, r, err := f.c.request(packetRead, p)
+	if err != nil {
+		return 0, err
+	}
+
+	switch rt {
+	case packetData:
+		data, ok := r.string()
+		if !ok || len(data) > bLen {
+			return 0, ErrMalformedPacket
+		}
+
+		f.offset += uint64(len(data))
+
+		return copy(b, data), nil
+
+	case packetStatus:
+		if err := status(r); err != nil {
+			return 0, err
+		}
+	}
+
+	return 0, ErrUnexpectedPacket
+}
+
+// Write writes `b` to the file
+func (f *File) Write(b []byte) (int, error) {
+	written := 0
+
+	for len(b) > 0 {
+		wLen := len(b)
+
+		if wLen > MaxDataSize {
+			wLen = MaxDataSize
+		}
+
+		p := packet{}
+		p.string(f.handle)
+		p.uint64(f.offset)
+		p.bytes(b[:wLen])
+
+		if err := f.c.expectStatus(packetWrite, p); err != nil {
+			return written, err
+		}
+
+		f.offset += uint64(wLen)
+		written += wLen
+		b = b[wLen:]
+	}
+
+	return written, nil
+}
+
+// Close closes the file
+func (f *File) Close() error {
+	return f.c.closeHandle(f.handle)
+}
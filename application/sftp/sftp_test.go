@@ -0,0 +1,321 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testServer is a tiny in-memory SFTP server
+type testServer struct {
+	files   map[string][]byte
+	dirs    map[string]bool
+	handles map[string]string
+	listed  map[string]bool
+}
+
+func (s *testServer) status(id uint32, code uint32) (byte, packet) {
+	p := packet{}
+	p.uint32(id)
+	p.uint32(code)
+	p.string("")
+	p.string("")
+
+	return packetStatus, p
+}
+
+func (s *testServer) handle(id uint32, path string) (byte, packet) {
+	h := string(rune('a' + len(s.handles)))
+	s.handles[h] = path
+
+	p := packet{}
+	p.uint32(id)
+	p.string(h)
+
+	return packetHandle, p
+}
+
+func (s *testServer) serve(t byte, r reader) (byte, packet) {
+	id, _ := r.uint32()
+
+	switch t {
+	case packetRealPath:
+		path, _ := r.string()
+		if path == "." {
+			path = "/home"
+		}
+
+		p := packet{}
+		p.uint32(id)
+		p.uint32(1)
+		p.string(path)
+		p.string(path)
+		p.uint32(0)
+
+		return packetName, p
+
+	case packetOpenDir:
+		path, _ := r.string()
+		if !s.dirs[path] {
+			return s.status(id, StatusNoSuchFile)
+		}
+
+		return s.handle(id, path)
+
+	case packetReadDir:
+		h, _ := r.string()
+		if s.listed[h] {
+			return s.status(id, StatusEOF)
+		}
+		s.listed[h] = true
+
+		names := []string{".", ".."}
+		for name := range s.files {
+			if strings.HasPrefix(name, s.handles[h]+"/") {
+				names = append(names, name[len(s.handles[h])+1:])
+			}
+		}
+
+		p := packet{}
+		p.uint32(id)
+		p.uint32(uint32(len(names)))
+		for _, name := range names {
+			p.string(name)
+			p.string(name)
+			p.uint32(attrSize | attrPermissions)
+			p.uint64(uint64(len(s.files[s.handles[h]+"/"+name])))
+			p.uint32(0100644)
+		}
+
+		return packetName, p
+
+	case packetOpen:
+		path, _ := r.string()
+		flags, _ := r.uint32()
+		if flags&openCreate != 0 {
+			s.files[path] = []byte{}
+		} else if _, ok := s.files[path]; !ok {
+			return s.status(id, StatusNoSuchFile)
+		}
+
+		return s.handle(id, path)
+
+	case packetRead:
+		h, _ := r.string()
+		offset, _ := r.uint64()
+		length, _ := r.uint32()
+		data := s.files[s.handles[h]]
+		if offset >= uint64(len(data)) {
+			return s.status(id, StatusEOF)
+		}
+
+		end := offset + uint64(length)
+		if end > uint64(len(data)) {
+			end = uint64(len(data))
+		}
+
+		p := packet{}
+		p.uint32(id)
+		p.bytes(data[offset:end])
+
+		return packetData, p
+
+	case packetWrite:
+		h, _ := r.string()
+		offset, _ := r.uint64()
+		data, _ := r.string()
+		path := s.handles[h]
+		s.files[path] = append(s.files[path][:offset], data...)
+
+		return s.status(id, StatusOK)
+
+	case packetClose:
+		h, _ := r.string()
+		delete(s.handles, h)
+
+		return s.status(id, StatusOK)
+
+	case packetRename:
+		from, _ := r.string()
+		to, _ := r.string()
+		s.files[to] = s.files[from]
+		delete(s.files, from)
+
+		return s.status(id, StatusOK)
+
+	case packetRemove:
+		path, _ := r.string()
+		if _, ok := s.files[path]; !ok {
+			return s.status(id, StatusNoSuchFile)
+		}
+		delete(s.files, path)
+
+		return s.status(id, StatusOK)
+	}
+
+	return s.status(id, StatusFailure)
+}
+
+func (s *testServer) run(r io.Reader, w io.WriteCloser) {
+	defer w.Close()
+
+	for {
+		h := [5]byte{}
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return
+		}
+
+		p := make([]byte, binary.BigEndian.Uint32(h[:4])-1)
+		if _, err := io.ReadFull(r, p); err != nil {
+			return
+		}
+
+		var rt byte
+		var rp packet
+
+		if h[4] == packetInit {
+			rt = packetVersion
+			rp.uint32(protocolVersion)
+		} else {
+			rt, rp = s.serve(h[4], reader(p))
+		}
+
+		rh := [5]byte{}
+		binary.BigEndian.PutUint32(rh[:4], uint32(len(rp)+1))
+		rh[4] = rt
+
+		if _, err := w.Write(append(rh[:], rp...)); err != nil {
+			return
+		}
+	}
+}
+
+func testClient(t *testing.T, s *testServer) *Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	go s.run(sr, sw)
+
+	c, err := New(cw, cr)
+	if err != nil {
+		t.Fatalf("Unable to start the session: %s", err)
+	}
+
+	t.Cleanup(func() {
+		c.Close()
+	})
+
+	return c
+}
+
+func TestClient(t *testing.T) {
+	s := &testServer{
+		files: map[string][]byte{
+			"/home/a.txt": []byte("Hello"),
+		},
+		dirs:    map[string]bool{"/home": true},
+		handles: map[string]string{},
+		listed:  map[string]bool{},
+	}
+	c := testClient(t, s)
+
+	home, err := c.RealPath(".")
+	if err != nil || home != "/home" {
+		t.Errorf("Expecting home to be %q, got %q (%v)", "/home", home, err)
+	}
+
+	f, err := c.Create("/home/b.txt")
+	if err != nil {
+		t.Fatalf("Unable to create file: %s", err)
+	}
+
+	data := bytes.Repeat([]byte("0123456789"), MaxDataSize/5)
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Unable to write file: %s", err)
+	}
+	f.Close()
+
+	f, err = c.Open("/home/b.txt")
+	if err != nil {
+		t.Fatalf("Unable to open file: %s", err)
+	}
+
+	read, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || !bytes.Equal(read, data) {
+		t.Errorf("Expecting to read back %d bytes, got %d (%v)",
+			len(data), len(read), err)
+	}
+
+	if err := c.Rename("/home/b.txt", "/home/c.txt"); err != nil {
+		t.Errorf("Unable to rename: %s", err)
+	}
+
+	entries, err := c.ReadDir("/home")
+	if err != nil {
+		t.Fatalf("Unable to list directory: %s", err)
+	}
+
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name)
+
+		if e.IsDir() || e.Mode.Perm() != 0644 {
+			t.Errorf("Unexpected mode %s of %q", e.Mode, e.Name)
+		}
+	}
+	sort.Strings(names)
+
+	if strings.Join(names, ",") != "a.txt,c.txt" {
+		t.Errorf("Unexpected entries %v", names)
+	}
+
+	if err := c.Remove("/home/a.txt"); err != nil {
+		t.Errorf("Unable to remove: %s", err)
+	}
+
+	err = c.Remove("/home/a.txt")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expecting a not exist error, got %v", err)
+	}
+}
+
+func TestClientRejectsOversizedPacket(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	go func() {
+		io.ReadFull(sr, make([]byte, 9))
+
+		h := [5]byte{}
+		binary.BigEndian.PutUint32(h[:4], maxPacketSize+1)
+		h[4] = packetVersion
+		sw.Write(h[:])
+	}()
+
+	if _, err := New(cw, cr); err != ErrPacketTooLarge {
+		t.Errorf("Expecting ErrPacketTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package sharing keeps track of the sessions which are connected to the
+// same account of the same remote, so the users can be told that they're not
+// alone on it before they step on each other
+package sharing
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Target is the account of the remote which the sessions are connected to
+type Target struct {
+	Tenant   string
+	Protocol string
+	Remote   string
+
+	// Account used to login to the remote, empty when the protocol doesn't
+	// tell (i.e. Telnet), in which case the sessions to the same remote are
+	// considered to be sharing it
+	Account string
+}
+
+// Session describes a session connected to a Target
+type Session struct {
+	// User who has logged into Sshwifty, empty when the login is anonymous
+	User string `json:"user,omitempty"`
+
+	// IP address of the client of the session
+	Client string `json:"client"`
+
+	// When the session has joined the Target
+	Since time.Time `json:"since"`
+}
+
+// Notify is called with the other sessions of the Target every time they
+// have changed. It's called without the Registry locked, but must not block
+// for long, as it delays the sessions which are joining or leaving
+type Notify func(others []Session)
+
+type member struct {
+	session   Session
+	notify    Notify
+	lock      sync.Mutex
+	delivered uint64
+	left      bool
+}
+
+// deliver notifies the member about the `others`, unless a later change
+// numbered after the `seq` has been delivered already
+func (m *member) deliver(seq uint64, others []Session) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.left || seq <= m.delivered {
+		return
+	}
+
+	m.delivered = seq
+	m.notify(others)
+}
+
+// delivery is a pending notification of a member
+type delivery struct {
+	member *member
+	others []Session
+}
+
+// Registry keeps the sessions connected to every Target
+type Registry struct {
+	lock    sync.Mutex
+	seq     uint64
+	targets map[Target][]*member
+}
+
+// New creates a new Registry
+func New() *Registry {
+	return &Registry{
+		lock:    sync.Mutex{},
+		seq:     0,
+		targets: map[Target][]*member{},
+	}
+}
+
+// others returns the sessions of the `members` except the `m`, ordered by
+// when they have joined
+func others(members []*member, m *member) []Session {
+	sessions := make([]Session, 0, len(members))
+
+	for _, o := range members {
+		if o == m {
+			continue
+		}
+
+		sessions = append(sessions, o.session)
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Since.Before(sessions[j].Since)
+	})
+
+	return sessions
+}
+
+// changed numbers the change of the `members`, and returns the
+// notifications about it. Caller must hold the lock
+func (r *Registry) changed(members []*member) (uint64, []delivery) {
+	r.seq++
+
+	deliveries := make([]delivery, len(members))
+	for i, m := range members {
+		deliveries[i] = delivery{member: m, others: others(members, m)}
+	}
+
+	return r.seq, deliveries
+}
+
+// deliver sends the `deliveries` of the change `seq`
+func deliver(seq uint64, deliveries []delivery) {
+	for _, d := range deliveries {
+		d.member.deliver(seq, d.others)
+	}
+}
+
+// Join adds the session `s` to the Target `t`, and returns the other
+// sessions which were already connected to it. The `notify` is called with
+// them when there are any, and then every time they have changed, until the
+// returned `leave` is called. It's safe to call Join on a nil Registry, in
+// which case the session is always alone
+func (r *Registry) Join(t Target, s Session, notify Notify) (
+	already []Session, leave func()) {
+	if r == nil {
+		return []Session{}, func() {}
+	}
+
+	m := &member{session: s, notify: notify}
+
+	r.lock.Lock()
+	current := r.targets[t]
+	members := append(append(make([]*member, 0, len(current)+1),
+		current...), m)
+	r.targets[t] = members
+	seq, deliveries := r.changed(members)
+	r.lock.Unlock()
+
+	// Sessions that are alone are not told about it until they have company
+	if len(current) > 0 {
+		deliver(seq, deliveries)
+	}
+
+	once := sync.Once{}
+
+	return others(members, m), func() {
+		once.Do(func() { r.leave(t, m) })
+	}
+}
+
+// leave removes the member `m` from the Target `t`, and notifies the
+// remaining members
+func (r *Registry) leave(t Target, m *member) {
+	r.lock.Lock()
+	current := r.targets[t]
+	remaining := make([]*member, 0, len(current))
+	for _, o := range current {
+		if o == m {
+			continue
+		}
+
+		remaining = append(remaining, o)
+	}
+	if len(remaining) > 0 {
+		r.targets[t] = remaining
+	} else {
+		delete(r.targets, t)
+	}
+	seq, deliveries := r.changed(remaining)
+	r.lock.Unlock()
+
+	// Waits for the ongoing notification, the `m` is never notified after
+	// it has left
+	m.lock.Lock()
+	m.left = true
+	m.lock.Unlock()
+
+	deliver(seq, deliveries)
+}
+
+// Sessions returns the sessions connected to the Target `t`, ordered by when
+// they have joined. It's safe to call Sessions on a nil Registry
+func (r *Registry) Sessions(t Target) []Session {
+	if r == nil {
+		return []Session{}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return others(r.targets[t], nil)
+}
@@ -0,0 +1,95 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package sharing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	r := New()
+	target := Target{Protocol: "SSH", Remote: "host:22", Account: "root"}
+	now := time.Now()
+
+	notified := map[string][]Session{}
+	notify := func(name string) Notify {
+		return func(others []Session) {
+			notified[name] = others
+		}
+	}
+
+	already, leaveAlice := r.Join(
+		target, Session{User: "alice", Since: now}, notify("alice"))
+	if len(already) != 0 {
+		t.Errorf("Expecting nobody else, got %+v", already)
+	}
+
+	already, _ = r.Join(Target{
+		Protocol: "SSH", Remote: "host:22", Account: "admin",
+	}, Session{User: "carol", Since: now}, notify("carol"))
+	if len(already) != 0 {
+		t.Errorf("Expecting other accounts to be apart, got %+v", already)
+	}
+
+	already, leaveBob := r.Join(target, Session{
+		User: "bob", Since: now.Add(time.Second),
+	}, notify("bob"))
+	if len(already) != 1 || already[0].User != "alice" {
+		t.Errorf("Expecting alice to be there already, got %+v", already)
+	}
+
+	if len(notified["bob"]) != 1 || notified["bob"][0].User != "alice" {
+		t.Errorf("Expecting bob to be told about alice, got %+v",
+			notified["bob"])
+	}
+
+	if len(notified["alice"]) != 1 || notified["alice"][0].User != "bob" {
+		t.Errorf("Expecting alice to be told about bob, got %+v",
+			notified["alice"])
+	}
+
+	if _, ok := notified["carol"]; ok {
+		t.Error("Expecting carol not to be told about the other account")
+	}
+
+	if s := r.Sessions(target); len(s) != 2 || s[0].User != "alice" {
+		t.Errorf("Expecting alice and bob, got %+v", s)
+	}
+
+	leaveAlice()
+	leaveAlice()
+
+	if len(notified["bob"]) != 0 {
+		t.Errorf("Expecting bob to be alone, got %+v", notified["bob"])
+	}
+
+	leaveBob()
+
+	if len(r.Sessions(target)) != 0 {
+		t.Error("Expecting the sessions to have left")
+	}
+
+	var nilRegistry *Registry
+
+	already, leave := nilRegistry.Join(target, Session{}, notify("dave"))
+	leave()
+	if len(already) != 0 {
+		t.Errorf("Expecting a nil Registry to be empty, got %+v", already)
+	}
+}
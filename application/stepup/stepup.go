@@ -0,0 +1,202 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package stepup verifies the fresh authentications that the users must pass
+// before connecting to privileged remotes
+package stepup
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Method is a method of step-up authentication
+type Method string
+
+// Defined Methods
+const (
+	// METHOD_PASSWORD requires the user to enter the SharedKey again
+	METHOD_PASSWORD Method = "password"
+
+	// METHOD_TOTP requires the user to enter a time-based one-time password
+	METHOD_TOTP Method = "totp"
+)
+
+const (
+	totpStep   = 30 * time.Second
+	totpDigits = 6
+	totpSkew   = 1
+
+	// TOTPAnySecret is the key of the TOTP secret that is used for the users
+	// who don't have one of their own
+	TOTPAnySecret = "*"
+)
+
+// Errors
+var (
+	ErrRejected = errors.New(
+		"step-up authentication has failed")
+
+	ErrUnsupportedMethod = errors.New(
+		"unsupported step-up authentication method")
+
+	ErrNoTOTPSecret = errors.New(
+		"no TOTP secret was configured for the user")
+)
+
+// Verify returns an error when the Method is unsupported
+func (m Method) Verify() error {
+	switch m {
+	case METHOD_PASSWORD, METHOD_TOTP:
+		return nil
+
+	default:
+		return fmt.Errorf("%s: %q. Supported methods are: %q",
+			ErrUnsupportedMethod, string(m), []Method{
+				METHOD_PASSWORD,
+				METHOD_TOTP,
+			})
+	}
+}
+
+// ParseTOTPSecret parses the base32 encoded TOTP `secret`
+func ParseTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Join(strings.Fields(secret), ""))
+	secret = strings.TrimRight(secret, "=")
+
+	s, err := base32.StdEncoding.WithPadding(base32.NoPadding).
+		DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP secret: %s", err)
+	}
+
+	if len(s) < 10 {
+		return nil, errors.New("invalid TOTP secret: must be at least " +
+			"80 bits long")
+	}
+
+	return s, nil
+}
+
+// TOTP returns the TOTP code of the `secret` for the time step `counter`
+func TOTP(secret []byte, counter int64) string {
+	msg := [8]byte{}
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000)
+}
+
+// Verifier verifies the answers of the step-up authentications
+type Verifier struct {
+	password string
+	secrets  map[string][]byte
+	now      func() time.Time
+	lock     sync.Mutex
+	used     map[string]int64
+}
+
+// NewVerifier creates a new Verifier. The `password` is used by the
+// METHOD_PASSWORD, and the TOTP `secrets` of each user is used by the
+// METHOD_TOTP
+func NewVerifier(password string, secrets map[string][]byte) *Verifier {
+	return &Verifier{
+		password: password,
+		secrets:  secrets,
+		now:      time.Now,
+		lock:     sync.Mutex{},
+		used:     make(map[string]int64, len(secrets)),
+	}
+}
+
+// Verify returns nil when the `answer` given by the `user` passes the
+// step-up authentication of the `method`
+func (v *Verifier) Verify(method Method, user string, answer []byte) error {
+	switch method {
+	case METHOD_PASSWORD:
+		return v.verifyPassword(answer)
+
+	case METHOD_TOTP:
+		return v.verifyTOTP(user, string(answer))
+
+	default:
+		return ErrUnsupportedMethod
+	}
+}
+
+func (v *Verifier) verifyPassword(answer []byte) error {
+	if len(v.password) <= 0 ||
+		subtle.ConstantTimeCompare([]byte(v.password), answer) != 1 {
+		return ErrRejected
+	}
+
+	return nil
+}
+
+func (v *Verifier) verifyTOTP(user string, code string) error {
+	key := user
+	secret, ok := v.secrets[key]
+	if !ok {
+		key = TOTPAnySecret
+		secret, ok = v.secrets[key]
+	}
+	if !ok {
+		return ErrNoTOTPSecret
+	}
+
+	if len(code) != totpDigits {
+		return ErrRejected
+	}
+
+	current := v.now().Unix() / int64(totpStep/time.Second)
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	// Users who share the same secret also share the same replay record,
+	// as the same code is valid for all of them
+	for c := current - totpSkew; c <= current+totpSkew; c++ {
+		if c <= v.used[key] {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(TOTP(secret, c)),
+			[]byte(code)) != 1 {
+			continue
+		}
+
+		v.used[key] = c
+
+		return nil
+	}
+
+	return ErrRejected
+}
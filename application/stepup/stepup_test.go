@@ -0,0 +1,114 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package stepup
+
+import (
+	"encoding/base32"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	// Test vectors of RFC 6238, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+
+	for _, c := range []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	} {
+		if code := TOTP(secret, c.time/30); code != c.code {
+			t.Errorf("Expecting code %q at %d, got %q", c.code, c.time, code)
+		}
+	}
+}
+
+func TestParseTOTPSecret(t *testing.T) {
+	encoded := base32.StdEncoding.EncodeToString(
+		[]byte("12345678901234567890"))
+
+	s, err := ParseTOTPSecret(encoded)
+	if err != nil || string(s) != "12345678901234567890" {
+		t.Errorf("Failed to parse the secret: %q, %v", s, err)
+	}
+
+	if _, err := ParseTOTPSecret("NOT A SECRET"); err == nil {
+		t.Error("Expecting an invalid secret to be rejected")
+	}
+
+	if _, err := ParseTOTPSecret("GEZDGNBV"); err == nil {
+		t.Error("Expecting a short secret to be rejected")
+	}
+}
+
+func TestVerifierTOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	v := NewVerifier("", map[string][]byte{"alice": secret})
+	v.now = func() time.Time { return time.Unix(1111111109, 0) }
+
+	if err := v.Verify(METHOD_TOTP, "alice", []byte("000000")); !errors.Is(
+		err, ErrRejected) {
+		t.Errorf("Expecting %q, got %v", ErrRejected, err)
+	}
+
+	if err := v.Verify(METHOD_TOTP, "alice", []byte("081804")); err != nil {
+		t.Error("Expecting the code to be accepted, got:", err)
+	}
+
+	if err := v.Verify(METHOD_TOTP, "alice", []byte("081804")); !errors.Is(
+		err, ErrRejected) {
+		t.Errorf("Expecting a replayed code to be rejected, got %v", err)
+	}
+
+	if err := v.Verify(METHOD_TOTP, "bob", []byte("081804")); !errors.Is(
+		err, ErrNoTOTPSecret) {
+		t.Errorf("Expecting %q, got %v", ErrNoTOTPSecret, err)
+	}
+
+	v.secrets[TOTPAnySecret] = secret
+	v.now = func() time.Time { return time.Unix(1111111109+30, 0) }
+
+	if err := v.Verify(
+		METHOD_TOTP, "bob", []byte(TOTP(secret, 1111111109/30+1)),
+	); err != nil {
+		t.Error("Expecting the shared secret to be used, got:", err)
+	}
+}
+
+func TestVerifierPassword(t *testing.T) {
+	v := NewVerifier("secret", nil)
+
+	if err := v.Verify(METHOD_PASSWORD, "", []byte("secret")); err != nil {
+		t.Error("Expecting the password to be accepted, got:", err)
+	}
+
+	if err := v.Verify(METHOD_PASSWORD, "", []byte("wrong")); !errors.Is(
+		err, ErrRejected) {
+		t.Errorf("Expecting %q, got %v", ErrRejected, err)
+	}
+
+	if err := NewVerifier("", nil).Verify(
+		METHOD_PASSWORD, "", []byte("")); !errors.Is(err, ErrRejected) {
+		t.Errorf("Expecting empty password to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package streamstats
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Only one of every sampleEvery operations of a stream is timed, the time is
+// then scaled up to cover the ones that are not
+const sampleEvery = 4
+
+// Info describes a stream
+type Info struct {
+	Client  string
+	User    string
+	Tenant  string
+	Command string
+	Stream  byte
+}
+
+// Record is the resource usage of an active stream
+type Record struct {
+	ID         uint64        `json:"id"`
+	Client     string        `json:"client"`
+	User       string        `json:"user,omitempty"`
+	Tenant     string        `json:"tenant,omitempty"`
+	Command    string        `json:"command"`
+	Stream     byte          `json:"stream"`
+	Remote     string        `json:"remote,omitempty"`
+	Started    time.Time     `json:"started"`
+	Duration   time.Duration `json:"duration"`
+	CPUTime    time.Duration `json:"cpu_time"`
+	Buffered   int64         `json:"buffered"`
+	Peak       int64         `json:"peak_buffered"`
+	Sent       uint64        `json:"sent"`
+	Received   uint64        `json:"received"`
+	Terminable bool          `json:"terminable"`
+}
+
+// Terminator terminates the stream with the `reason`. It must be safe to
+// call from any goroutine, and may be called more than once
+type Terminator func(reason string)
+
+// Stream tracks the resource usage of a stream. All methods of a nil Stream
+// do nothing
+type Stream struct {
+	registry  *Registry
+	id        uint64
+	info      Info
+	started   time.Time
+	remote    atomic.Pointer[string]
+	ops       atomic.Uint64
+	cpu       atomic.Int64
+	buffered  atomic.Int64
+	peak      atomic.Int64
+	sent      atomic.Uint64
+	received  atomic.Uint64
+	terminate atomic.Pointer[Terminator]
+}
+
+// Describe sets the remote the stream is connected to
+func (s *Stream) Describe(remote string) {
+	if s == nil {
+		return
+	}
+
+	s.remote.Store(&remote)
+}
+
+// Measure starts timing an operation of the stream (i.e. processing the data
+// it received, or encrypting and sending the data it sent). Call the returned
+// function once the operation is done
+func (s *Stream) Measure() func() {
+	if s == nil || s.ops.Add(1)%sampleEvery != 0 {
+		return func() {}
+	}
+
+	start := time.Now()
+
+	return func() {
+		s.cpu.Add(int64(time.Since(start)) * sampleEvery)
+	}
+}
+
+// Hold records that `n` bytes are buffered by the stream. Call Release when
+// they're no longer held
+func (s *Stream) Hold(n int) {
+	if s == nil {
+		return
+	}
+
+	held := s.buffered.Add(int64(n))
+
+	for {
+		peak := s.peak.Load()
+		if held <= peak || s.peak.CompareAndSwap(peak, held) {
+			return
+		}
+	}
+}
+
+// Release records that `n` bytes buffered by the stream are released
+func (s *Stream) Release(n int) {
+	if s == nil {
+		return
+	}
+
+	s.buffered.Add(-int64(n))
+}
+
+// Sent records `n` bytes sent by the stream to the client
+func (s *Stream) Sent(n int) {
+	if s == nil {
+		return
+	}
+
+	s.sent.Add(uint64(n))
+}
+
+// Received records `n` bytes received by the stream from the client
+func (s *Stream) Received(n int) {
+	if s == nil {
+		return
+	}
+
+	s.received.Add(uint64(n))
+}
+
+// Terminable sets the Terminator `t`, which terminates the stream when it's
+// asked to by the Registry.Terminate
+func (s *Stream) Terminable(t Terminator) {
+	if s == nil {
+		return
+	}
+
+	s.terminate.Store(&t)
+}
+
+// Close removes the stream from the Registry
+func (s *Stream) Close() {
+	if s == nil {
+		return
+	}
+
+	s.registry.remove(s.id)
+}
+
+func (s *Stream) record() Record {
+	remote := ""
+	if r := s.remote.Load(); r != nil {
+		remote = *r
+	}
+
+	return Record{
+		ID:         s.id,
+		Client:     s.info.Client,
+		User:       s.info.User,
+		Tenant:     s.info.Tenant,
+		Command:    s.info.Command,
+		Stream:     s.info.Stream,
+		Remote:     remote,
+		Started:    s.started,
+		Duration:   time.Since(s.started),
+		CPUTime:    time.Duration(s.cpu.Load()),
+		Buffered:   s.buffered.Load(),
+		Peak:       s.peak.Load(),
+		Sent:       s.sent.Load(),
+		Received:   s.received.Load(),
+		Terminable: s.terminate.Load() != nil,
+	}
+}
+
+// Registry keeps the resource usage of all active streams. The CPU time is
+// approximated by the time the streams spend on processing their data, which
+// is sampled
+type Registry struct {
+	lock    sync.Mutex
+	next    uint64
+	streams map[uint64]*Stream
+}
+
+// NewRegistry creates a new Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		lock:    sync.Mutex{},
+		next:    0,
+		streams: map[uint64]*Stream{},
+	}
+}
+
+// Open starts tracking a new stream. It returns nil when the Registry is nil
+func (r *Registry) Open(info Info) *Stream {
+	if r == nil {
+		return nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.next++
+
+	s := &Stream{
+		registry: r,
+		id:       r.next,
+		info:     info,
+		started:  time.Now(),
+	}
+
+	r.streams[s.id] = s
+
+	return s
+}
+
+func (r *Registry) remove(id uint64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.streams, id)
+}
+
+// Terminate terminates the active stream of the `id` with the `reason`. It
+// returns false when there's no such stream, or the stream can't be
+// terminated
+func (r *Registry) Terminate(id uint64, reason string) bool {
+	if r == nil {
+		return false
+	}
+
+	r.lock.Lock()
+	s, found := r.streams[id]
+	r.lock.Unlock()
+
+	if !found {
+		return false
+	}
+
+	t := s.terminate.Load()
+	if t == nil {
+		return false
+	}
+
+	(*t)(reason)
+
+	return true
+}
+
+// Records returns the usage of all active streams, the ones that used the
+// most CPU time first
+func (r *Registry) Records() []Record {
+	if r == nil {
+		return nil
+	}
+
+	r.lock.Lock()
+	records := make([]Record, 0, len(r.streams))
+	for _, s := range r.streams {
+		records = append(records, s.record())
+	}
+	r.lock.Unlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].CPUTime != records[j].CPUTime {
+			return records[i].CPUTime > records[j].CPUTime
+		}
+
+		return records[i].ID < records[j].ID
+	})
+
+	return records
+}
@@ -0,0 +1,109 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package streamstats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	a := r.Open(Info{Client: "1.1.1.1:1", Command: "SSH", Stream: 1})
+	b := r.Open(Info{Client: "2.2.2.2:2", Command: "Telnet", Stream: 2})
+
+	b.Describe("remote:23")
+	b.Hold(100)
+	b.Hold(50)
+	b.Release(100)
+	b.Sent(10)
+	b.Received(20)
+
+	for i := 0; i < sampleEvery; i++ {
+		done := b.Measure()
+		time.Sleep(time.Millisecond)
+		done()
+	}
+
+	records := r.Records()
+	if len(records) != 2 {
+		t.Fatalf("Expecting 2 records, got %d", len(records))
+	}
+
+	if records[0].ID != 2 || records[0].CPUTime <= 0 {
+		t.Errorf("Expecting the busy stream to be the first, got %v", records)
+	}
+
+	if records[0].Remote != "remote:23" ||
+		records[0].Buffered != 50 ||
+		records[0].Peak != 150 ||
+		records[0].Sent != 10 ||
+		records[0].Received != 20 {
+		t.Errorf("Unexpected record %v", records[0])
+	}
+
+	a.Close()
+
+	if records := r.Records(); len(records) != 1 || records[0].ID != 2 {
+		t.Errorf("Expecting the closed stream to be removed, got %v", records)
+	}
+
+	var nilStream *Stream
+	nilStream.Hold(1)
+	nilStream.Measure()()
+	nilStream.Close()
+
+	var nilRegistry *Registry
+	if s := nilRegistry.Open(Info{}); s != nil {
+		t.Error("Expecting nil Registry to return nil Stream")
+	}
+}
+
+func TestRegistryTerminate(t *testing.T) {
+	r := NewRegistry()
+
+	a := r.Open(Info{Client: "1.1.1.1:1", Command: "SSH", Stream: 1})
+	b := r.Open(Info{Client: "2.2.2.2:2", Command: "Echo", Stream: 2})
+	defer b.Close()
+
+	reasons := []string{}
+	a.Terminable(func(reason string) {
+		reasons = append(reasons, reason)
+	})
+
+	if r.Terminate(2, "Stop") {
+		t.Error("Expecting the stream without a Terminator to be kept")
+	}
+
+	if !r.Terminate(1, "Stop") || len(reasons) != 1 || reasons[0] != "Stop" {
+		t.Errorf("Expecting the stream to be terminated, got %q", reasons)
+	}
+
+	for _, record := range r.Records() {
+		if record.Terminable != (record.ID == 1) {
+			t.Errorf("Unexpected Terminable of %v", record)
+		}
+	}
+
+	a.Close()
+
+	if r.Terminate(1, "Stop") {
+		t.Error("Expecting the closed stream to be gone")
+	}
+}
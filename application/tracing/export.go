@@ -0,0 +1,217 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const (
+	exportRespondMaxSize = 4096
+	exportScopeName      = "github.com/nirui/sshwifty"
+)
+
+// Values of the OTLP span status code
+const (
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+// otlpSpanKindInternal is the OTLP span kind of all the Spans
+const otlpSpanKindInternal = 1
+
+// The OTLP/HTTP JSON encoding of the spans. See opentelemetry-proto for the
+// definition, in which the IDs are hex encoded and the 64-bit integers are
+// encoded as strings
+type (
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// exporter POSTs the spans to the OTLP/HTTP endpoint
+type exporter struct {
+	endpoint string
+	headers  http.Header
+	service  string
+	client   *http.Client
+}
+
+func newExporter(settings Settings) exporter {
+	return exporter{
+		endpoint: settings.Endpoint,
+		headers:  settings.Headers,
+		service:  settings.ServiceName,
+		client:   &http.Client{},
+	}
+}
+
+func encodeAttributes(attributes map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	encoded := make([]otlpAttribute, len(keys))
+	for i, k := range keys {
+		encoded[i] = otlpAttribute{
+			Key:   k,
+			Value: otlpValue{StringValue: attributes[k]},
+		}
+	}
+
+	return encoded
+}
+
+func encodeSpan(s *Span) otlpSpan {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	encoded := otlpSpan{
+		TraceID: hex.EncodeToString(s.trace[:]),
+		SpanID:  hex.EncodeToString(s.id[:]),
+		Name:    s.name,
+		Kind:    otlpSpanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(
+			s.start.UnixNano(), 10),
+		EndTimeUnixNano: strconv.FormatInt(
+			s.end.UnixNano(), 10),
+		Attributes: encodeAttributes(s.attributes),
+		Status: otlpStatus{
+			Code:    otlpStatusUnset,
+			Message: "",
+		},
+	}
+
+	if s.parent != [8]byte{} {
+		encoded.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+
+	if len(s.err) > 0 {
+		encoded.Status = otlpStatus{
+			Code:    otlpStatusError,
+			Message: s.err,
+		}
+	}
+
+	return encoded
+}
+
+func (e exporter) export(ctx context.Context, spans []*Span) error {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		encoded[i] = encodeSpan(s)
+	}
+
+	body, err := json.Marshal(otlpTraces{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: encodeAttributes(map[string]string{
+					"service.name": e.service,
+				}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: exportScopeName},
+				Spans: encoded,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range e.headers {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, exportRespondMaxSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected respond status %s", resp.Status)
+	}
+
+	return nil
+}
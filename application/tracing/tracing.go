@@ -0,0 +1,292 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package tracing records the spans of the connection setup, and exports
+// them to an OpenTelemetry collector through OTLP/HTTP
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"math"
+	"math/big"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// Settings of the Tracer
+type Settings struct {
+	// URL of the OTLP/HTTP traces endpoint of the collector, i.e.
+	// "http://collector:4318/v1/traces"
+	Endpoint string
+
+	// Headers sent along with the spans, i.e. for the authentication
+	Headers http.Header
+
+	// Name of the service the spans are reported as
+	ServiceName string
+
+	// Ratio of the connections that are traced, from 0 to 1
+	SampleRate float64
+
+	// Max amount of spans waiting for export. Spans beyond it are dropped
+	Buffer int
+
+	// Max amount of spans exported in a single request
+	BatchSize int
+
+	// Max time to wait for more spans before exporting a partial batch
+	FlushInterval time.Duration
+
+	// Max time a single export can take
+	Timeout time.Duration
+}
+
+// Tracer creates the Spans, and exports them once they're ended. All methods
+// are safe to call on a nil Tracer
+type Tracer struct {
+	settings Settings
+	exporter exporter
+	spans    chan *Span
+	dropped  atomic.Uint64
+	closing  chan struct{}
+	wait     sync.WaitGroup
+}
+
+// New creates a new Tracer, or nil when there's no Endpoint
+func New(settings Settings) *Tracer {
+	if len(settings.Endpoint) <= 0 {
+		return nil
+	}
+
+	return &Tracer{
+		settings: settings,
+		exporter: newExporter(settings),
+		spans:    make(chan *Span, settings.Buffer),
+		dropped:  atomic.Uint64{},
+		closing:  make(chan struct{}),
+		wait:     sync.WaitGroup{},
+	}
+}
+
+// Start starts exporting the spans
+func (t *Tracer) Start(l log.Logger) {
+	if t == nil {
+		return
+	}
+
+	t.wait.Add(1)
+
+	go t.export(l)
+}
+
+// Close exports the spans that are still waiting, and then stops the Tracer
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+
+	close(t.closing)
+	t.wait.Wait()
+}
+
+// sampled returns whether or not a new trace should be recorded
+func (t *Tracer) sampled() bool {
+	if t.settings.SampleRate >= 1 {
+		return true
+	}
+
+	if t.settings.SampleRate <= 0 {
+		return false
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+	if err != nil {
+		return false
+	}
+
+	return float64(n.Int64())/math.MaxInt64 < t.settings.SampleRate
+}
+
+// Begin begins the root Span of a new trace. It returns nil (which is also a
+// valid Span) when the trace is not sampled
+func (t *Tracer) Begin(name string) *Span {
+	if t == nil || !t.sampled() {
+		return nil
+	}
+
+	s := &Span{
+		tracer:     t,
+		name:       name,
+		start:      time.Now(),
+		attributes: map[string]string{},
+	}
+
+	rand.Read(s.trace[:])
+	rand.Read(s.id[:])
+
+	return s
+}
+
+// submit queues the Span `s` for export without blocking
+func (t *Tracer) submit(s *Span) {
+	select {
+	case t.spans <- s:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+func (t *Tracer) export(l log.Logger) {
+	defer t.wait.Done()
+
+	ticker := time.NewTicker(t.settings.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, t.settings.BatchSize)
+
+	for {
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+
+			if len(batch) < t.settings.BatchSize {
+				continue
+			}
+
+			batch = t.flush(batch, l)
+
+		case <-ticker.C:
+			batch = t.flush(batch, l)
+
+		case <-t.closing:
+			for {
+				select {
+				case s := <-t.spans:
+					batch = append(batch, s)
+
+					if len(batch) >= t.settings.BatchSize {
+						batch = t.flush(batch, l)
+					}
+
+					continue
+				default:
+				}
+
+				break
+			}
+
+			t.flush(batch, l)
+
+			return
+		}
+	}
+}
+
+// flush exports the `batch`. Returns the emptied batch
+func (t *Tracer) flush(batch []*Span, l log.Logger) []*Span {
+	if dropped := t.dropped.Swap(0); dropped > 0 {
+		l.Warning("%d spans were dropped as too many of them were waiting "+
+			"for export", dropped)
+	}
+
+	if len(batch) <= 0 {
+		return batch
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), t.settings.Timeout)
+	defer cancel()
+
+	err := t.exporter.export(ctx, batch)
+	if err != nil {
+		l.Warning("Unable to export %d spans, they're dropped: %s",
+			len(batch), err)
+	}
+
+	return batch[:0]
+}
+
+// Span is a timed operation of a trace. All methods are safe to call on a
+// nil Span, which records nothing
+type Span struct {
+	tracer     *Tracer
+	trace      [16]byte
+	id         [8]byte
+	parent     [8]byte
+	name       string
+	start      time.Time
+	end        time.Time
+	lock       sync.Mutex
+	attributes map[string]string
+	err        string
+}
+
+// Set sets the attribute `key` of the Span to the `value`
+func (s *Span) Set(key string, value string) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.attributes[key] = value
+}
+
+// Record records a completed child Span of the Span, which started at the
+// `start` and ended at the `end`
+func (s *Span) Record(name string, start time.Time, end time.Time) {
+	if s == nil {
+		return
+	}
+
+	c := &Span{
+		tracer:     s.tracer,
+		trace:      s.trace,
+		parent:     s.id,
+		name:       name,
+		start:      start,
+		end:        end,
+		attributes: map[string]string{},
+	}
+
+	rand.Read(c.id[:])
+
+	s.tracer.submit(c)
+}
+
+// End ends the Span, with the `err` (when it's not nil) as the reason of
+// the failure of it
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	s.lock.Unlock()
+
+	s.tracer.submit(s)
+}
@@ -0,0 +1,127 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package tracing
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nirui/sshwifty/application/log"
+)
+
+func TestTracer(t *testing.T) {
+	lock := sync.Mutex{}
+	spans := []otlpSpan{}
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			traces := otlpTraces{}
+			b, _ := io.ReadAll(r.Body)
+			if err := json.Unmarshal(b, &traces); err != nil {
+				t.Error("Unexpected error:", err)
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			for _, r := range traces.ResourceSpans {
+				for _, s := range r.ScopeSpans {
+					spans = append(spans, s.Spans...)
+				}
+			}
+		}))
+	defer server.Close()
+
+	tracer := New(Settings{
+		Endpoint:      server.URL,
+		Headers:       http.Header{"Authorization": {"Bearer token"}},
+		ServiceName:   "sshwifty",
+		SampleRate:    1,
+		Buffer:        16,
+		BatchSize:     16,
+		FlushInterval: time.Hour,
+		Timeout:       time.Second,
+	})
+	tracer.Start(log.NewDitch())
+
+	root := tracer.Begin("ssh.connect")
+	root.Set("remote", "localhost:22")
+	root.Record("connect", time.Now().Add(-time.Second), time.Now())
+	root.End(errors.New("Handshake has failed"))
+
+	// Spans are exported before the Tracer is closed
+	tracer.Close()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(spans) != 2 {
+		t.Fatalf("Expecting 2 spans, got %d", len(spans))
+	}
+
+	child, parent := spans[0], spans[1]
+
+	if child.TraceID != parent.TraceID || child.ParentSpanID != parent.SpanID {
+		t.Errorf("Expecting %+v to be the child of %+v", child, parent)
+	}
+
+	if parent.Name != "ssh.connect" || len(parent.ParentSpanID) > 0 {
+		t.Errorf("Unexpected root span %+v", parent)
+	}
+
+	if parent.Status.Code != otlpStatusError ||
+		parent.Status.Message != "Handshake has failed" {
+		t.Errorf("Unexpected status %+v", parent.Status)
+	}
+
+	if len(parent.Attributes) != 1 ||
+		parent.Attributes[0].Value.StringValue != "localhost:22" {
+		t.Errorf("Unexpected attributes %+v", parent.Attributes)
+	}
+}
+
+func TestTracerDisabled(t *testing.T) {
+	tracer := New(Settings{})
+	if tracer != nil {
+		t.Fatal("Expecting no Tracer without an Endpoint")
+	}
+
+	tracer.Start(log.NewDitch())
+	defer tracer.Close()
+
+	s := tracer.Begin("ssh.connect")
+	s.Set("remote", "localhost:22")
+	s.Record("connect", time.Now(), time.Now())
+	s.End(nil)
+
+	unsampled := New(Settings{Endpoint: "http://localhost", SampleRate: 0})
+	if unsampled.Begin("ssh.connect") != nil {
+		t.Error("Expecting nothing to be traced when SampleRate is 0")
+	}
+}
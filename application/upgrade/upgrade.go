@@ -0,0 +1,266 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package upgrade hands the listeners of the running process over to a new
+// process of the (upgraded) executable. The old process then stops accepting
+// new connections, and keeps serving the connected sessions until they're
+// closed, so routine upgrades don't disconnect the users.
+//
+// The listeners are passed to the new process as inherited files, described
+// by the SSHWIFTY_UPGRADE_LISTENERS environment variable in the format of
+// "address=fd,address=fd". The new process tells the old one it's ready
+// by writing to the pipe given in SSHWIFTY_UPGRADE_READY.
+package upgrade
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	listenersEnv = "SSHWIFTY_UPGRADE_LISTENERS"
+	readyEnv     = "SSHWIFTY_UPGRADE_READY"
+)
+
+// Errors
+var (
+	ErrUnsupported = errors.New(
+		"upgrade is not supported on this platform")
+
+	ErrNotReady = errors.New(
+		"new process has exited before it's ready")
+
+	ErrReadyTimeout = errors.New(
+		"new process did not get ready in time")
+
+	ErrNotListener = errors.New(
+		"inherited file is not a TCP listener")
+)
+
+// Inherited contains the listeners handed over by the old process
+type Inherited struct {
+	lock      sync.Mutex
+	listeners map[string]*os.File
+	ready     *os.File
+}
+
+// parseFile parses the file descriptor `fd` into a file
+func parseFile(fd string, name string) *os.File {
+	n, err := strconv.ParseUint(strings.TrimSpace(fd), 10, 32)
+	if err != nil || n <= 2 {
+		return nil
+	}
+
+	return os.NewFile(uintptr(n), name)
+}
+
+// Inherit takes the listeners handed over by the old process when current
+// process is started by an upgrade. The environment variables are removed,
+// so they won't be passed further to the child processes
+func Inherit() *Inherited {
+	i := &Inherited{
+		lock:      sync.Mutex{},
+		listeners: map[string]*os.File{},
+		ready:     nil,
+	}
+
+	listeners := os.Getenv(listenersEnv)
+	ready := os.Getenv(readyEnv)
+
+	os.Unsetenv(listenersEnv)
+	os.Unsetenv(readyEnv)
+
+	for _, l := range strings.Split(listeners, ",") {
+		sep := strings.LastIndex(l, "=")
+		if sep <= 0 {
+			continue
+		}
+
+		if f := parseFile(l[sep+1:], l[:sep]); f != nil {
+			i.listeners[l[:sep]] = f
+		}
+	}
+
+	i.ready = parseFile(ready, readyEnv)
+
+	return i
+}
+
+// Listener takes the inherited listener of the `address`. It returns nil when
+// there is no such listener. Each listener can only be taken once
+func (i *Inherited) Listener(address string) (*net.TCPListener, error) {
+	if i == nil {
+		return nil, nil
+	}
+
+	i.lock.Lock()
+	f, ok := i.listeners[address]
+	delete(i.listeners, address)
+	i.lock.Unlock()
+
+	if !ok {
+		return nil, nil
+	}
+
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, err
+	}
+
+	tl, ok := l.(*net.TCPListener)
+	if !ok {
+		l.Close()
+
+		return nil, ErrNotListener
+	}
+
+	return tl, nil
+}
+
+// Ready tells the old process that current one has started listening, so the
+// old one can stop accepting. The listeners that are not taken are closed
+func (i *Inherited) Ready() {
+	if i == nil {
+		return
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for address, f := range i.listeners {
+		f.Close()
+		delete(i.listeners, address)
+	}
+
+	if i.ready == nil {
+		return
+	}
+
+	i.ready.Write([]byte{1})
+	i.ready.Close()
+	i.ready = nil
+}
+
+// Sessions counts the sessions that are being served, so the old process
+// knows when all of them are closed
+type Sessions struct {
+	lock     sync.Mutex
+	count    int
+	idle     chan struct{}
+	draining bool
+}
+
+// NewSessions creates a new Sessions
+func NewSessions() *Sessions {
+	idle := make(chan struct{})
+	close(idle)
+
+	return &Sessions{
+		lock:     sync.Mutex{},
+		count:    0,
+		idle:     idle,
+		draining: false,
+	}
+}
+
+// Begin registers a session, the returned function must be called once the
+// session is closed
+func (s *Sessions) Begin() func() {
+	end, _ := s.TryBegin(0)
+
+	return end
+}
+
+// TryBegin registers a session unless there are already `limit` sessions
+// (0 means no limit). The returned function must be called once the session
+// is closed
+func (s *Sessions) TryBegin(limit int) (func(), bool) {
+	if s == nil {
+		return func() {}, true
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if limit > 0 && s.count >= limit {
+		return func() {}, false
+	}
+
+	if s.count == 0 {
+		s.idle = make(chan struct{})
+	}
+
+	s.count++
+
+	once := sync.Once{}
+
+	return func() {
+		once.Do(func() {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+
+			s.count--
+
+			if s.count == 0 {
+				close(s.idle)
+			}
+		})
+	}, true
+}
+
+// Count returns the number of sessions that are being served
+func (s *Sessions) Count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.count
+}
+
+// Idle returns a channel which is closed once there is no session
+func (s *Sessions) Idle() <-chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.idle
+}
+
+// Drain marks the sessions as draining, so no new session (or new stream of
+// the existing ones) should be started
+func (s *Sessions) Drain() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.draining = true
+}
+
+// Draining returns whether or not the sessions are draining
+func (s *Sessions) Draining() bool {
+	if s == nil {
+		return false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.draining
+}
@@ -0,0 +1,203 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package upgrade
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	s := NewSessions()
+
+	select {
+	case <-s.Idle():
+	default:
+		t.Error("Expecting Sessions to be idle when created")
+		return
+	}
+
+	end1 := s.Begin()
+	end2 := s.Begin()
+
+	end1()
+	end1()
+
+	if s.Count() != 1 {
+		t.Errorf("Expecting 1 session, got %d", s.Count())
+		return
+	}
+
+	idle := s.Idle()
+
+	select {
+	case <-idle:
+		t.Error("Expecting Sessions not to be idle")
+		return
+	default:
+	}
+
+	end2()
+
+	select {
+	case <-idle:
+	case <-time.After(time.Second):
+		t.Error("Expecting Sessions to be idle once all sessions are closed")
+		return
+	}
+
+	var nilSessions *Sessions
+
+	nilSessions.Begin()()
+}
+
+func TestInherit(t *testing.T) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Error("Failed to listen:", err)
+		return
+	}
+	defer l.Close()
+
+	f, err := l.File()
+	if err != nil {
+		t.Skip("Listener can't be handed over on current platform:", err)
+	}
+
+	// The inherited files are closed by the Inherited
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		t.Error("Failed to create pipe:", err)
+		return
+	}
+	defer ready.Close()
+
+	address := l.Addr().String()
+
+	t.Setenv(listenersEnv,
+		"malformed,"+address+"="+strconv.FormatUint(uint64(f.Fd()), 10))
+	t.Setenv(readyEnv, strconv.FormatUint(uint64(readyW.Fd()), 10))
+
+	i := Inherit()
+
+	if len(os.Getenv(listenersEnv)) > 0 {
+		t.Error("Expecting the environment variables to be removed")
+		return
+	}
+
+	if missing, _ := i.Listener("127.0.0.1:1"); missing != nil {
+		t.Error("Expecting unknown address to return no listener")
+		return
+	}
+
+	inherited, err := i.Listener(address)
+	if err != nil || inherited == nil {
+		t.Error("Expecting the listener to be inherited, got error:", err)
+		return
+	}
+	defer inherited.Close()
+
+	if again, _ := i.Listener(address); again != nil {
+		t.Error("Expecting the listener can only be taken once")
+		return
+	}
+
+	go func() {
+		conn, cErr := net.Dial("tcp", address)
+		if cErr == nil {
+			conn.Close()
+		}
+	}()
+
+	inherited.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn, err := inherited.Accept()
+	if err != nil {
+		t.Error("Failed to accept through the inherited listener:", err)
+		return
+	}
+	conn.Close()
+
+	i.Ready()
+
+	b := [1]byte{}
+
+	ready.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := ready.Read(b[:]); err != nil {
+		t.Error("Expecting the old process to be told ready, got error:", err)
+		return
+	}
+}
+
+func TestSessionsTryBegin(t *testing.T) {
+	s := NewSessions()
+
+	end1, ok := s.TryBegin(1)
+	if !ok {
+		t.Error("Expecting the first session to begin")
+		return
+	}
+
+	if _, ok := s.TryBegin(1); ok {
+		t.Error("Expecting the second session to be refused")
+		return
+	}
+
+	end1()
+
+	end2, ok := s.TryBegin(1)
+	if !ok {
+		t.Error("Expecting the session to begin after the first one ended")
+		return
+	}
+
+	end2()
+
+	var n *Sessions
+
+	if _, ok := n.TryBegin(1); !ok {
+		t.Error("Expecting a nil Sessions to be unlimited")
+	}
+}
+
+func TestSessionsDrain(t *testing.T) {
+	s := NewSessions()
+
+	if s.Draining() {
+		t.Error("Expecting Sessions not to be draining when created")
+		return
+	}
+
+	s.Drain()
+
+	if !s.Draining() {
+		t.Error("Expecting Sessions to be draining")
+		return
+	}
+
+	var n *Sessions
+
+	if n.Draining() {
+		t.Error("Expecting a nil Sessions not to be draining")
+	}
+}
@@ -0,0 +1,112 @@
+//go:build !(windows || plan9)
+
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package upgrade
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Signals are the signals which request an upgrade
+var Signals = []os.Signal{syscall.SIGUSR2}
+
+// Requested returns whether the `sig` requests an upgrade
+func Requested(sig os.Signal) bool {
+	return sig == syscall.SIGUSR2
+}
+
+// Start starts a new process of the executable with the `listeners`, which
+// are indexed by their address, and waits until it's ready. The old process
+// should stop accepting on the listeners once it returns without error
+func Start(listeners map[string]*net.TCPListener, timeout time.Duration) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	files := make([]*os.File, 0, len(listeners)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	described := make([]string, 0, len(listeners))
+
+	for address, l := range listeners {
+		f, fErr := l.File()
+		if fErr != nil {
+			return fErr
+		}
+
+		// Inherited files are numbered after stdin, stdout and stderr
+		described = append(described,
+			address+"="+strconv.FormatInt(int64(len(files)+3), 10))
+		files = append(files, f)
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	files = append(files, readyW)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		listenersEnv+"="+strings.Join(described, ","),
+		readyEnv+"="+strconv.FormatInt(int64(len(files)+2), 10))
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Close our copy of the write end, so the read fails once the new
+	// process has exited
+	readyW.Close()
+
+	ready.SetReadDeadline(time.Now().Add(timeout))
+
+	_, err = io.ReadFull(ready, make([]byte, 1))
+	if err == nil {
+		go cmd.Wait()
+
+		return nil
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	if os.IsTimeout(err) {
+		return ErrReadyTimeout
+	}
+
+	return ErrNotReady
+}
@@ -0,0 +1,41 @@
+//go:build windows || plan9
+
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package upgrade
+
+import (
+	"net"
+	"os"
+	"time"
+)
+
+// Signals are the signals which request an upgrade, none on current platform
+var Signals = []os.Signal{}
+
+// Requested always returns false as upgrade is not supported on current
+// platform
+func Requested(sig os.Signal) bool {
+	return false
+}
+
+// Start returns ErrUnsupported as upgrade is not supported on current
+// platform
+func Start(listeners map[string]*net.TCPListener, timeout time.Duration) error {
+	return ErrUnsupported
+}
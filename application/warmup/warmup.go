@@ -0,0 +1,372 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package warmup keeps authenticated SSH connections to selected remotes
+// ready, so users can attach to them without waiting for the handshake
+package warmup
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nirui/sshwifty/application/network"
+)
+
+// Errors
+var (
+	ErrFingerprintMismatch = errors.New(
+		"fingerprint of the remote does not match the expected one")
+)
+
+const (
+	keepAliveRequest = "keepalive@openssh.com"
+)
+
+// Target is a SSH remote to keep a warm connection to
+type Target struct {
+	// Index of the Preset which the Target belongs to
+	Preset int
+
+	Title   string
+	Address string
+	User    string
+
+	// Credential used for authentication. When both are empty, the "none"
+	// authentication will be used
+	Password   string
+	PrivateKey string
+
+	// SHA256 fingerprint of the host key, in the format returned by
+	// ssh.FingerprintSHA256
+	Fingerprint string
+
+	// Algorithms offered to the server. Empty to use the defaults of the
+	// SSH library
+	Ciphers           []string
+	KeyExchanges      []string
+	MACs              []string
+	HostKeyAlgorithms []string
+
+	// Wraps the connection in TLS before the SSH handshake when it's set
+	TLS *tls.Config
+}
+
+// authMethods returns the ssh.AuthMethod of the Target
+func (t Target) authMethods() ([]ssh.AuthMethod, error) {
+	if len(t.PrivateKey) > 0 {
+		signer, err := ssh.ParsePrivateKey([]byte(t.PrivateKey))
+		if err != nil {
+			return nil, err
+		}
+
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	}
+
+	if len(t.Password) > 0 {
+		return []ssh.AuthMethod{ssh.Password(t.Password)}, nil
+	}
+
+	return nil, nil
+}
+
+// Verify returns an error when the credential of the Target is unusable
+func (t Target) Verify() error {
+	_, err := t.authMethods()
+
+	return err
+}
+
+func (t Target) verifyHostKey(
+	hostname string,
+	remote net.Addr,
+	key ssh.PublicKey,
+) error {
+	if ssh.FingerprintSHA256(key) != t.Fingerprint {
+		return ErrFingerprintMismatch
+	}
+
+	return nil
+}
+
+// Settings of the Pool
+type Settings struct {
+	// Timeout of establishing and revalidating a connection
+	Timeout time.Duration
+
+	// How often idle connections are revalidated
+	Revalidate time.Duration
+
+	// Max amount of warm connections. Targets beyond it are ignored
+	MaxConnections int
+
+	// ssh.Config.RekeyThreshold of the connections
+	RekeyThreshold uint64
+}
+
+// Pool keeps one warm connection for each of the Targets
+type Pool struct {
+	dial     network.Dial
+	settings Settings
+	targets  []Target
+	lock     sync.Mutex
+	conns    []*ssh.Client
+	wakes    []chan struct{}
+	closing  chan struct{}
+	wait     sync.WaitGroup
+}
+
+// New creates a new Pool. It returns nil when there is no Target to keep
+// warm connections to
+func New(dial network.Dial, settings Settings, targets []Target) *Pool {
+	if len(targets) > settings.MaxConnections {
+		targets = targets[:max(settings.MaxConnections, 0)]
+	}
+
+	if len(targets) <= 0 {
+		return nil
+	}
+
+	wakes := make([]chan struct{}, len(targets))
+	for i := range wakes {
+		wakes[i] = make(chan struct{}, 1)
+	}
+
+	return &Pool{
+		dial:     dial,
+		settings: settings,
+		targets:  targets,
+		conns:    make([]*ssh.Client, len(targets)),
+		wakes:    wakes,
+		closing:  make(chan struct{}),
+	}
+}
+
+// Start starts to establish and maintain the warm connections
+func (p *Pool) Start() {
+	if p == nil {
+		return
+	}
+
+	for i := range p.targets {
+		p.wait.Add(1)
+
+		go p.keep(i)
+	}
+}
+
+// Close stops the maintenance and closes all idle warm connections
+func (p *Pool) Close() {
+	if p == nil {
+		return
+	}
+
+	close(p.closing)
+	p.wait.Wait()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i, c := range p.conns {
+		if c == nil {
+			continue
+		}
+
+		c.Close()
+		p.conns[i] = nil
+	}
+}
+
+// Take removes the warm connection to the Target of given `address` and
+// `user` from the Pool and returns it. The caller owns the returned
+// connection and must close it after use. A new warm connection will be
+// established in the background to replace it
+func (p *Pool) Take(address string, user string) (*ssh.Client, bool) {
+	if p == nil {
+		return nil, false
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i, t := range p.targets {
+		if t.Address != address || t.User != user || p.conns[i] == nil {
+			continue
+		}
+
+		c := p.conns[i]
+		p.conns[i] = nil
+
+		select {
+		case p.wakes[i] <- struct{}{}:
+		default: // Already woken
+		}
+
+		return c, true
+	}
+
+	return nil, false
+}
+
+func (p *Pool) keep(i int) {
+	defer p.wait.Done()
+
+	ticker := time.NewTicker(p.settings.Revalidate)
+	defer ticker.Stop()
+
+	for {
+		if c := p.current(i); c == nil {
+			established, err := p.connect(p.targets[i])
+			if err == nil {
+				p.store(i, established)
+			}
+		} else if !p.revalidate(c) {
+			p.drop(i, c)
+
+			continue
+		}
+
+		select {
+		case <-ticker.C:
+		case <-p.wakes[i]:
+		case <-p.closing:
+			return
+		}
+	}
+}
+
+func (p *Pool) current(i int) *ssh.Client {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.conns[i]
+}
+
+func (p *Pool) store(i int, c *ssh.Client) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.conns[i] = c
+
+	// Forget the connection once the remote has closed it, the replacement
+	// will be established by the next maintenance round
+	go func() {
+		c.Wait()
+		p.drop(i, c)
+	}()
+}
+
+// drop closes the connection `c` if it is still the warm connection of the
+// Target `i`. Connections that have already been taken are left untouched
+func (p *Pool) drop(i int, c *ssh.Client) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.conns[i] != c {
+		return
+	}
+
+	c.Close()
+	p.conns[i] = nil
+}
+
+// connect establishes an authenticated connection to the Target `t`
+func (p *Pool) connect(t Target) (*ssh.Client, error) {
+	ctx, cancel := context.WithTimeout(
+		context.Background(), p.settings.Timeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-p.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	auth, err := t.authMethods()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := p.dial(network.WithDialProtocol(ctx, "SSH"), "tcp", t.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.TLS != nil {
+		conn, err = network.WrapTLS(ctx, conn, t.TLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn.SetDeadline(time.Now().Add(p.settings.Timeout))
+
+	c, chans, reqs, err := ssh.NewClientConn(
+		conn, t.Address, &ssh.ClientConfig{
+			Config: ssh.Config{
+				RekeyThreshold: p.settings.RekeyThreshold,
+				Ciphers:        t.Ciphers,
+				KeyExchanges:   t.KeyExchanges,
+				MACs:           t.MACs,
+			},
+			User:              t.User,
+			Auth:              auth,
+			HostKeyCallback:   t.verifyHostKey,
+			HostKeyAlgorithms: t.HostKeyAlgorithms,
+			Timeout:           p.settings.Timeout,
+		})
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// revalidate returns whether or not the connection `c` is still responding
+func (p *Pool) revalidate(c *ssh.Client) bool {
+	result := make(chan error, 1)
+
+	go func() {
+		_, _, err := c.SendRequest(keepAliveRequest, true, nil)
+		result <- err
+	}()
+
+	timeout := time.NewTimer(p.settings.Timeout)
+	defer timeout.Stop()
+
+	select {
+	case err := <-result:
+		return err == nil
+
+	case <-timeout.C:
+		return false
+
+	case <-p.closing:
+		return true
+	}
+}
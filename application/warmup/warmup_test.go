@@ -0,0 +1,196 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package warmup
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func testWarmupDial(
+	ctx context.Context,
+	network string,
+	address string,
+) (net.Conn, error) {
+	d := net.Dialer{}
+
+	return d.DialContext(ctx, network, address)
+}
+
+// testWarmupListen starts a SSH server which accepts the password "test", and
+// returns its address and the fingerprint of its host key
+func testWarmupListen(t *testing.T) (string, string) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(
+			c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "test" {
+				return nil, errors.New("wrong password")
+			}
+
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				sConn, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+				if err != nil {
+					return
+				}
+				defer sConn.Close()
+
+				go ssh.DiscardRequests(reqs)
+
+				for c := range chans {
+					c.Reject(ssh.Prohibited, "")
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String(), ssh.FingerprintSHA256(signer.PublicKey())
+}
+
+func testWarmupTake(p *Pool, address, user string) (*ssh.Client, bool) {
+	for i := 0; i < 100; i++ {
+		if c, ok := p.Take(address, user); ok {
+			return c, ok
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return nil, false
+}
+
+func TestPool(t *testing.T) {
+	addr, fingerprint := testWarmupListen(t)
+
+	p := New(testWarmupDial, Settings{
+		Timeout:        time.Second,
+		Revalidate:     time.Second,
+		MaxConnections: 2,
+	}, []Target{
+		{
+			Preset:      0,
+			Address:     addr,
+			User:        "good",
+			Password:    "test",
+			Fingerprint: fingerprint,
+		},
+		{
+			Preset:      1,
+			Address:     addr,
+			User:        "impostor",
+			Password:    "test",
+			Fingerprint: "SHA256:nope",
+		},
+		{
+			Preset:      2,
+			Address:     addr,
+			User:        "ignored",
+			Password:    "test",
+			Fingerprint: fingerprint,
+		},
+	})
+	p.Start()
+	defer p.Close()
+
+	c, ok := testWarmupTake(p, addr, "good")
+	if !ok {
+		t.Error("Expecting a warm connection to be available")
+		return
+	}
+	defer c.Close()
+
+	if _, _, err := c.SendRequest(keepAliveRequest, true, nil); err != nil {
+		t.Error("Expecting the warm connection to be usable, got", err)
+		return
+	}
+
+	// A replacement will be established after the connection was taken
+	c2, ok := testWarmupTake(p, addr, "good")
+	if !ok {
+		t.Error("Expecting the warm connection to be replaced")
+		return
+	}
+	defer c2.Close()
+
+	if c2 == c {
+		t.Error("Expecting a different connection")
+		return
+	}
+
+	if _, ok := p.Take(addr, "impostor"); ok {
+		t.Error("Expecting no connection when the fingerprint mismatches")
+		return
+	}
+
+	if _, ok := p.Take(addr, "ignored"); ok {
+		t.Error("Expecting Targets beyond MaxConnections to be ignored")
+		return
+	}
+}
+
+func TestPoolNil(t *testing.T) {
+	p := New(testWarmupDial, Settings{MaxConnections: 4}, nil)
+	if p != nil {
+		t.Error("Expecting nil Pool without Targets")
+		return
+	}
+
+	p.Start()
+	defer p.Close()
+
+	if _, ok := p.Take("localhost:22", "test"); ok {
+		t.Error("Expecting nothing from a nil Pool")
+		return
+	}
+}
@@ -0,0 +1,324 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package watcher
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nirui/sshwifty/application/network"
+)
+
+// Errors
+var (
+	ErrUnexpectedBanner = errors.New(
+		"remote responded with an unexpected banner")
+)
+
+// Supported checks
+const (
+	// CheckTCP reports the target as up when a connection can be established
+	CheckTCP = "tcp"
+
+	// CheckSSH reports the target as up when it sends a SSH banner after the
+	// connection is established
+	CheckSSH = "ssh"
+)
+
+const (
+	sshBannerPrefix    = "SSH-"
+	sshBannerMaxLength = 255
+	sshBannerMaxLines  = 16
+)
+
+// State is the availability state of a Target
+type State byte
+
+// Defined States
+const (
+	STATE_UNKNOWN State = iota
+	STATE_UP
+	STATE_DOWN
+)
+
+// String returns the name of the State
+func (s State) String() string {
+	switch s {
+	case STATE_UP:
+		return "up"
+	case STATE_DOWN:
+		return "down"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// VerifyCheck returns an error when the `check` is not supported
+func VerifyCheck(check string) error {
+	switch check {
+	case CheckTCP, CheckSSH:
+		return nil
+	default:
+		return fmt.Errorf("unsupported check %q, expecting %q or %q",
+			check, CheckTCP, CheckSSH)
+	}
+}
+
+// Target is a remote to be watched
+type Target struct {
+	// Index of the Preset which the Target belongs to
+	Preset int
+
+	Title   string
+	Address string
+	Check   string
+
+	// Protocol spoken with the remote, which is the Type of the Preset
+	Protocol string
+}
+
+// Status is the availability of a Target
+type Status struct {
+	Preset  int           `json:"preset"`
+	Title   string        `json:"title"`
+	State   State         `json:"state"`
+	Checked time.Time     `json:"checked"`
+	Latency time.Duration `json:"latency,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Watcher checks the availability of the Targets periodically in the
+// background
+type Watcher struct {
+	dial        network.Dial
+	interval    time.Duration
+	timeout     time.Duration
+	targets     []Target
+	lock        sync.Mutex
+	statuses    []Status
+	subscribers map[chan struct{}]struct{}
+	closing     chan struct{}
+	wait        sync.WaitGroup
+}
+
+// New creates a new Watcher. It returns nil when there is no Target to watch
+func New(
+	dial network.Dial,
+	interval time.Duration,
+	timeout time.Duration,
+	targets []Target,
+) *Watcher {
+	if len(targets) <= 0 {
+		return nil
+	}
+
+	statuses := make([]Status, len(targets))
+	for i, t := range targets {
+		statuses[i] = Status{
+			Preset: t.Preset,
+			Title:  t.Title,
+			State:  STATE_UNKNOWN,
+		}
+	}
+
+	return &Watcher{
+		dial:        dial,
+		interval:    interval,
+		timeout:     min(timeout, interval),
+		targets:     targets,
+		statuses:    statuses,
+		subscribers: map[chan struct{}]struct{}{},
+		closing:     make(chan struct{}),
+	}
+}
+
+// Start starts watching the Targets
+func (w *Watcher) Start() {
+	if w == nil {
+		return
+	}
+
+	for i := range w.targets {
+		w.wait.Add(1)
+
+		go w.watch(i)
+	}
+}
+
+// Close stops the watching and waits until all checks are finished
+func (w *Watcher) Close() {
+	if w == nil {
+		return
+	}
+
+	close(w.closing)
+	w.wait.Wait()
+}
+
+func (w *Watcher) watch(i int) {
+	defer w.wait.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.update(i, w.check(w.targets[i]))
+
+		select {
+		case <-ticker.C:
+		case <-w.closing:
+			return
+		}
+	}
+}
+
+// check performs a single check on the Target `t`
+func (w *Watcher) check(t Target) Status {
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-w.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	start := time.Now()
+	s := Status{
+		Preset:  t.Preset,
+		Title:   t.Title,
+		State:   STATE_DOWN,
+		Checked: start,
+	}
+
+	conn, err := w.dial(
+		network.WithDialProtocol(ctx, t.Protocol), "tcp", t.Address)
+	if err != nil {
+		s.Error = err.Error()
+
+		return s
+	}
+	defer conn.Close()
+
+	if t.Check == CheckSSH {
+		deadline, _ := ctx.Deadline()
+		conn.SetReadDeadline(deadline)
+
+		err = readSSHBanner(conn)
+		if err != nil {
+			s.Error = err.Error()
+
+			return s
+		}
+	}
+
+	s.State = STATE_UP
+	s.Latency = time.Since(start)
+
+	return s
+}
+
+// readSSHBanner reads the identification string sent by the SSH server. Lines
+// before the identification string are allowed by RFC 4253
+func readSSHBanner(conn net.Conn) error {
+	r := bufio.NewReaderSize(conn, sshBannerMaxLength)
+
+	for i := 0; i < sshBannerMaxLines; i++ {
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(string(line), sshBannerPrefix) {
+			return nil
+		}
+	}
+
+	return ErrUnexpectedBanner
+}
+
+func (w *Watcher) update(i int, s Status) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	changed := w.statuses[i].State != s.State
+	w.statuses[i] = s
+
+	if !changed {
+		return
+	}
+
+	for c := range w.subscribers {
+		select {
+		case c <- struct{}{}:
+		default: // Already notified
+		}
+	}
+}
+
+// Statuses returns the current Status of all Targets
+func (w *Watcher) Statuses() []Status {
+	if w == nil {
+		return nil
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	statuses := make([]Status, len(w.statuses))
+	copy(statuses, w.statuses)
+
+	return statuses
+}
+
+// Subscribe returns a channel which will be notified when the State of any
+// Target has changed, and a function to cancel the subscription. Multiple
+// changes may result only one notification, so the subscriber should always
+// fetch all Statuses after being notified
+func (w *Watcher) Subscribe() (<-chan struct{}, func()) {
+	if w == nil {
+		return nil, func() {}
+	}
+
+	c := make(chan struct{}, 1)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.subscribers[c] = struct{}{}
+
+	return c, func() {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+
+		delete(w.subscribers, c)
+	}
+}
@@ -0,0 +1,116 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package watcher
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func testWatcherDial(
+	ctx context.Context,
+	network string,
+	address string,
+) (net.Conn, error) {
+	d := net.Dialer{}
+
+	return d.DialContext(ctx, network, address)
+}
+
+func testWatcherListen(t *testing.T, banner string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.Write([]byte(banner))
+			conn.Close()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestWatcher(t *testing.T) {
+	sshAddr := testWatcherListen(t, "Welcome\r\nSSH-2.0-Test\r\n")
+	httpAddr := testWatcherListen(t, "HTTP/1.1 400 Bad Request\r\n\r\n")
+
+	w := New(testWatcherDial, time.Minute, time.Second, []Target{
+		{Preset: 0, Title: "SSH", Address: sshAddr, Check: CheckSSH},
+		{Preset: 2, Title: "TCP", Address: httpAddr, Check: CheckTCP},
+		{Preset: 3, Title: "Not SSH", Address: httpAddr, Check: CheckSSH},
+	})
+
+	changed, cancel := w.Subscribe()
+	defer cancel()
+
+	w.Start()
+	defer w.Close()
+
+	expected := []State{STATE_UP, STATE_UP, STATE_DOWN}
+	timeout := time.After(5 * time.Second)
+
+	for {
+		statuses := w.Statuses()
+		matched := 0
+
+		for i, s := range statuses {
+			if s.State == expected[i] {
+				matched++
+			}
+		}
+
+		if matched == len(expected) {
+			break
+		}
+
+		select {
+		case <-changed:
+		case <-timeout:
+			t.Fatalf("Expecting states %v, got %v", expected, statuses)
+		}
+	}
+
+	if s := w.Statuses()[1]; s.Preset != 2 || s.Checked.IsZero() {
+		t.Errorf("Unexpected status %v", s)
+	}
+}
+
+func TestWatcherNoTarget(t *testing.T) {
+	w := New(testWatcherDial, time.Minute, time.Second, nil)
+	if w != nil {
+		t.Fatal("Expecting a nil Watcher when there is no Target")
+	}
+
+	w.Start()
+	w.Close()
+
+	if len(w.Statuses()) != 0 {
+		t.Error("Expecting no Status")
+	}
+}
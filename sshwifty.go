@@ -1,3 +1,5 @@
+//go:build !conformance
+
 // Sshwifty - A Web SSH client
 //
 // Copyright (C) 2019-2023 Ni Rui <ranqus@gmail.com>
@@ -18,19 +20,142 @@
 package main
 
 import (
+	"errors"
+	"io"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/nirui/sshwifty/application"
+	"github.com/nirui/sshwifty/application/agent"
 	"github.com/nirui/sshwifty/application/commands"
 	"github.com/nirui/sshwifty/application/configuration"
 	"github.com/nirui/sshwifty/application/controller"
 	"github.com/nirui/sshwifty/application/log"
 )
 
+// defaultAgentBridge is where the agent accepts the browser by default
+const defaultAgentBridge = "127.0.0.1:8183"
+
+// defaultConfigInterval is how often the remote configuration is checked for
+// changes by default, in seconds
+const defaultConfigInterval = 60
+
+// errUnknownLogOutput is returned when the SSHWIFTY_LOG_OUTPUT is unknown
+var errUnknownLogOutput = errors.New(
+	"unknown log output, expecting \"stderr\", \"syslog\" or \"file\"")
+
+// logOutput opens the writer of the logs selected by the SSHWIFTY_LOG_OUTPUT
+func logOutput() (io.Writer, error) {
+	switch strings.ToLower(os.Getenv("SSHWIFTY_LOG_OUTPUT")) {
+	case "", "stderr":
+		return os.Stderr, nil
+
+	case "syslog":
+		tag := os.Getenv("SSHWIFTY_LOG_SYSLOG_TAG")
+		if len(tag) <= 0 {
+			tag = "sshwifty"
+		}
+
+		return log.NewSyslog(
+			os.Getenv("SSHWIFTY_LOG_SYSLOG_NETWORK"),
+			os.Getenv("SSHWIFTY_LOG_SYSLOG_ADDRESS"),
+			tag)
+
+	case "file":
+		maxSize, _ := strconv.ParseUint(
+			os.Getenv("SSHWIFTY_LOG_FILE_MAXSIZE"), 10, 32)
+		maxAge, _ := strconv.ParseUint(
+			os.Getenv("SSHWIFTY_LOG_FILE_MAXAGE"), 10, 32)
+		maxBackups, _ := strconv.ParseUint(
+			os.Getenv("SSHWIFTY_LOG_FILE_MAXBACKUPS"), 10, 32)
+
+		return log.NewRotatingFile(os.Getenv("SSHWIFTY_LOG_FILE"),
+			log.Rotation{
+				MaxSize:    int64(maxSize) << 20,
+				MaxAge:     time.Duration(maxAge) * time.Hour,
+				MaxBackups: int(maxBackups),
+			})
+
+	default:
+		return nil, errUnknownLogOutput
+	}
+}
+
+// newLogger creates the logger of the `context`. The SSHWIFTY_DEBUG enables
+// the debug logs, the SSHWIFTY_LOG_FORMAT selects the log.Format, and the
+// SSHWIFTY_LOG_OUTPUT selects where the logs are written
+func newLogger(context string) (log.Logger, log.Format) {
+	format, formatErr := log.ParseFormat(os.Getenv("SSHWIFTY_LOG_FORMAT"))
+
+	output, outputErr := logOutput()
+	if outputErr != nil {
+		output = os.Stderr
+	}
+
+	l := log.NewDebugOrNonDebugWriter(
+		len(os.Getenv("SSHWIFTY_DEBUG")) > 0, format, context, output)
+
+	if formatErr != nil {
+		l.Warning("Unknown SSHWIFTY_LOG_FORMAT %q, using %q instead",
+			os.Getenv("SSHWIFTY_LOG_FORMAT"), format)
+	}
+
+	if outputErr != nil {
+		l.Warning("Unable to write logs to SSHWIFTY_LOG_OUTPUT %q, "+
+			"using the standard error output instead: %s",
+			os.Getenv("SSHWIFTY_LOG_OUTPUT"), outputErr)
+	}
+
+	return l, format
+}
+
+// runAgent runs the local agent of dynamic forwarding, which accepts SOCKS5
+// clients on the `socks` address
+func runAgent(socks string) {
+	l, _ := newLogger("Agent")
+
+	bridge := os.Getenv("SSHWIFTY_AGENT_BRIDGE")
+	if len(bridge) <= 0 {
+		bridge = defaultAgentBridge
+	}
+
+	origin := os.Getenv("SSHWIFTY_AGENT_ORIGIN")
+	if len(origin) <= 0 {
+		l.Error("SSHWIFTY_AGENT_ORIGIN must be set to the origin of the " +
+			"Sshwifty instance, i.e. https://sshwifty.example.com")
+		os.Exit(1)
+	}
+
+	err := agent.Run(socks, bridge, origin, l)
+	if err != nil {
+		l.Error("Agent has stopped: %s", err)
+		os.Exit(1)
+	}
+}
+
 func main() {
+	if socks := os.Getenv("SSHWIFTY_AGENT"); len(socks) > 0 {
+		runAgent(socks)
+
+		return
+	}
+
 	configLoaders := make([]configuration.Loader, 0, 2)
 
-	if len(os.Getenv("SSHWIFTY_CONFIG")) > 0 {
+	if configuration.IsRemote(os.Getenv("SSHWIFTY_CONFIG")) {
+		interval, _ := strconv.ParseUint(
+			os.Getenv("SSHWIFTY_CONFIG_INTERVAL"), 10, 32)
+		if interval <= 0 {
+			interval = defaultConfigInterval
+		}
+
+		configLoaders = append(configLoaders, configuration.Remote(
+			os.Getenv("SSHWIFTY_CONFIG"),
+			configuration.String(os.Getenv("SSHWIFTY_CONFIG_TOKEN")),
+			time.Duration(interval)*time.Second))
+	} else if len(os.Getenv("SSHWIFTY_CONFIG")) > 0 {
 		configLoaders = append(configLoaders,
 			configuration.File(os.Getenv("SSHWIFTY_CONFIG")))
 	} else {
@@ -38,9 +163,16 @@ func main() {
 		configLoaders = append(configLoaders, configuration.Enviro())
 	}
 
+	l, format := newLogger(application.Name)
+
+	// Only the logs are written when they're parsed by the log collectors
+	screen := io.Writer(os.Stderr)
+	if format == log.FormatJSON {
+		screen = io.Discard
+	}
+
 	e := application.
-		New(os.Stderr, log.NewDebugOrNonDebugWriter(
-			len(os.Getenv("SSHWIFTY_DEBUG")) > 0, application.Name, os.Stderr)).
+		New(screen, l).
 		Run(configuration.Redundant(configLoaders...),
 			application.DefaultProccessSignallerBuilder,
 			commands.New(),
@@ -0,0 +1,86 @@
+//go:build conformance
+
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/nirui/sshwifty/application/command"
+	"github.com/nirui/sshwifty/application/commands"
+	"github.com/nirui/sshwifty/application/log"
+)
+
+// This build runs the commands directly over stdin and stdout, so fuzzers and
+// protocol test suites can drive them without a websocket connection.
+//
+// When SSHWIFTY_CONFORMANCE_CORPUS is set, the seed corpus is written into
+// the directory it specified instead, one file per input.
+func main() {
+	l := log.NewDebugOrNonDebugWriter(
+		len(os.Getenv("SSHWIFTY_DEBUG")) > 0,
+		log.FormatText,
+		"Conformance",
+		os.Stderr,
+	)
+
+	corpusDir := os.Getenv("SSHWIFTY_CONFORMANCE_CORPUS")
+	if len(corpusDir) > 0 {
+		wErr := writeCorpus(corpusDir)
+		if wErr != nil {
+			l.Error("Unable to write corpus: %s", wErr)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	rErr := command.RunConformance(commands.New(), os.Stdin, os.Stdout, l)
+	if rErr != nil {
+		l.Error("Conformance run has failed: %s", rErr)
+		os.Exit(1)
+	}
+}
+
+// writeCorpus writes all seed inputs into `dir`
+func writeCorpus(dir string) error {
+	mErr := os.MkdirAll(dir, 0o755)
+	if mErr != nil {
+		return mErr
+	}
+
+	corpora := []map[string][]byte{
+		commands.ControlCorpus(),
+		commands.TelnetCorpus(),
+		commands.SSHCorpus(),
+		commands.ConserverCorpus(),
+	}
+
+	for _, corpus := range corpora {
+		for name, input := range corpus {
+			wErr := os.WriteFile(filepath.Join(dir, name), input, 0o644)
+			if wErr != nil {
+				return wErr
+			}
+		}
+	}
+
+	return nil
+}
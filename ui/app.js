@@ -20,17 +20,25 @@ import "./app.css";
 import Auth from "./auth.vue";
 import { Colors as ControlColors } from "./commands/color.js";
 import { Commands } from "./commands/commands.js";
+import * as conserver from "./commands/conserver.js";
 import { Controls } from "./commands/controls.js";
 import { Presets } from "./commands/presets.js";
 import * as ssh from "./commands/ssh.js";
 import * as telnet from "./commands/telnet.js";
 import "./common.css";
+import * as conserverctl from "./control/conserver.js";
 import * as sshctl from "./control/ssh.js";
 import * as telnetctl from "./control/telnet.js";
+import * as classroom from "./classroom.js";
 import * as cipher from "./crypto.js";
+import * as deeplink from "./deeplink.js";
+import { handover } from "./handover.js";
 import Home from "./home.vue";
+import * as kiosk from "./kiosk.js";
 import "./landing.css";
 import Loading from "./loading.vue";
+import * as passkey from "./passkey.js";
+import { userSettings } from "./settings.js";
 import { Socket } from "./socket.js";
 import * as stream from "./stream/common.js";
 import * as xhr from "./xhr.js";
@@ -46,22 +54,29 @@ const mainTemplate = `
   v-if="page == 'app'"
   :host-path="hostPath"
   :query="query"
+  :launch-preset="launchPreset"
   :connection="socket"
   :controls="controls"
   :commands="commands"
   :server-message="serverMessage"
   :preset-data="presetData.presets"
   :restricted-to-presets="presetData.restricted"
+  :passkeys="passkeys"
+  :kiosk="kiosk.length > 0"
   :view-port="viewPort"
   @navigate-to="changeURLHash"
   @tab-opened="tabOpened"
   @tab-closed="tabClosed"
   @tab-updated="tabUpdated"
+  @tab-stopped="tabStopped"
+  @register-passkey="registerPasskey"
 ></home>
 <auth
   v-else-if="page == 'auth'"
   :error="authErr"
+  :passkeys="passkeys"
   @auth="submitAuth"
+  @passkey="submitPasskey"
 ></auth>
 <loading class="app-error-message" v-else :error="loadErr"></loading>
 `.trim();
@@ -109,8 +124,16 @@ function startApp(rootEl) {
           window.location.hash.indexOf("#") === 0
             ? window.location.hash.slice(1, window.location.hash.length)
             : "",
+        deepLink: deeplink.query(window.location),
+        kiosk: kiosk.query(window.location),
+        kioskLive: false,
+        kioskWatchdog: null,
+        classroom: classroom.query(window.location),
+        launchPreset: -1,
         page: "loading",
         key: "",
+        passphrase: "",
+        passkeys: false,
         serverMessage: "",
         presetData: {
           presets: new Presets([]),
@@ -122,8 +145,13 @@ function startApp(rootEl) {
         controls: new Controls([
           new telnetctl.Telnet(uiControlColors),
           new sshctl.SSH(uiControlColors),
+          new conserverctl.Conserver(uiControlColors),
+        ]),
+        commands: new Commands([
+          new telnet.Command(),
+          new ssh.Command(),
+          new conserver.Command(),
         ]),
-        commands: new Commands([new telnet.Command(), new ssh.Command()]),
         tabUpdateIndicator: null,
         viewPort: {
           dim: {
@@ -233,7 +261,7 @@ function startApp(rootEl) {
           heartbeatInterval * 1000,
         );
       },
-      executeHomeApp(authResult, key) {
+      async executeHomeApp(authResult, key) {
         let authData = JSON.parse(authResult.data);
         this.serverMessage = authData.server_message
           ? authData.server_message
@@ -242,11 +270,34 @@ function startApp(rootEl) {
           presets: new Presets(authData.presets ? authData.presets : []),
           restricted: authResult.onlyAllowPresetRemotes,
         };
+        this.passkeys = authResult.passkeys && passkey.supported();
         this.socket = this.buildSocket(
           key,
           authResult.timeout,
           authResult.heartbeat,
         );
+        const keyBuilder = async () => {
+          return btoa(
+            String.fromCharCode.apply(
+              null,
+              await this.getSocketAuthKey(this.passphrase),
+            ),
+          );
+        };
+        await userSettings.load(keyBuilder);
+        handover.load(keyBuilder);
+        if (this.deepLink.length > 0) {
+          try {
+            this.launchPreset = await deeplink.resolve(
+              this.deepLink,
+              await keyBuilder(),
+            );
+          } catch (e) {
+            alert(e.message);
+          }
+          deeplink.clear(window.location, window.history);
+          this.deepLink = "";
+        }
         this.page = "app";
       },
       async doAuth(privateKey) {
@@ -281,9 +332,73 @@ function startApp(rootEl) {
           data: h.responseText,
           onlyAllowPresetRemotes:
             h.getResponseHeader("X-OnlyAllowPresetRemotes") === "yes",
+          passkeys: h.getResponseHeader("X-Passkeys") === "yes",
+          binding: h.getResponseHeader("X-Binding") || "",
         };
       },
+      async startKiosk() {
+        let session = null;
+
+        try {
+          session = await kiosk.login(this.kiosk);
+        } catch (e) {
+          this.loadErr = e.message;
+
+          return;
+        }
+
+        // The backend is unreachable, keep trying until it's back, so the
+        // kiosk recovers by itself
+        if (session === null) {
+          setTimeout(() => {
+            this.startKiosk();
+          }, backendQueryRetryDelay);
+
+          return;
+        }
+
+        this.launchPreset = session.id;
+        this.kioskWatchdog = new kiosk.Watchdog(
+          this.kiosk,
+          session.keepalive,
+          () => this.kioskLive,
+          () => window.location.reload(),
+          (msg) => {
+            this.page = "loading";
+            this.loadErr = msg;
+          },
+        );
+
+        // The key given by the kiosk login replaces the passphrase
+        await this.submitAuth(session.key);
+
+        this.kioskWatchdog.start();
+      },
+      async startClassroom() {
+        let session = null;
+
+        try {
+          session = await classroom.login(this.classroom);
+        } catch (e) {
+          this.loadErr = e.message;
+
+          return;
+        }
+
+        this.launchPreset = session.id;
+
+        // The key given by the seat replaces the passphrase
+        await this.submitAuth(session.key);
+      },
       async tryInitialAuth() {
+        if (this.kiosk.length > 0) {
+          return this.startKiosk();
+        }
+
+        if (this.classroom.length > 0) {
+          return this.startClassroom();
+        }
+
         try {
           let result = await this.doAuth("");
 
@@ -321,6 +436,7 @@ function startApp(rootEl) {
           switch (result.result) {
             case 200:
               this.executeHomeApp(result, {
+                binding: "",
                 async fetch() {
                   let result = await self.doAuth("");
 
@@ -332,12 +448,15 @@ function startApp(rootEl) {
                     );
                   }
 
+                  this.binding = result.binding;
+
                   return await buildSocketKey(atob(result.key) + "+");
                 },
               });
               break;
 
             case 403:
+              this.passkeys = result.passkeys && passkey.supported();
               this.page = "auth";
               break;
 
@@ -363,7 +482,9 @@ function startApp(rootEl) {
           let self = this;
           switch (result.result) {
             case 200:
+              this.passphrase = passphrase;
               this.executeHomeApp(result, {
+                binding: "",
                 async fetch() {
                   let result = await self.doAuth(passphrase);
 
@@ -375,6 +496,8 @@ function startApp(rootEl) {
                     );
                   }
 
+                  this.binding = result.binding;
+
                   return await buildSocketKey(
                     atob(result.key) + "+" + passphrase,
                   );
@@ -386,6 +509,11 @@ function startApp(rootEl) {
               this.authErr = "Authentication has failed. Wrong passphrase?";
               break;
 
+            case 429:
+              this.authErr =
+                "Too many failed attempts. Please try again later";
+              break;
+
             default:
               this.authErr =
                 "Unexpected backend query status: " + result.result;
@@ -394,6 +522,48 @@ function startApp(rootEl) {
           this.authErr = "Unable to authenticate: " + e;
         }
       },
+      async submitPasskey() {
+        this.authErr = "";
+
+        let result = null;
+
+        try {
+          result = await passkey.login();
+        } catch (e) {
+          this.authErr = "Unable to sign in with the passkey: " + e;
+
+          return;
+        }
+
+        // The key given by the passkey login replaces the passphrase
+        await this.submitAuth(result.key);
+      },
+      async registerPasskey() {
+        let enrollment = prompt(
+          "Enter the enrollment code given by the administrator, or leave " +
+            "it empty if you've already been identified",
+        );
+
+        if (enrollment === null) {
+          return;
+        }
+
+        try {
+          await passkey.register(
+            btoa(
+              String.fromCharCode.apply(
+                null,
+                await this.getSocketAuthKey(this.passphrase),
+              ),
+            ),
+            enrollment.trim(),
+          );
+
+          alert("The passkey has been registered");
+        } catch (e) {
+          alert("Unable to register the passkey: " + e);
+        }
+      },
       updateTabTitleInfo(tabs, updated) {
         if (tabs.length <= 0) {
           this.resetTitleInfo();
@@ -404,9 +574,15 @@ function startApp(rootEl) {
         this.changeTitleInfo("(" + tabs.length + (updated ? "*" : "") + ")");
       },
       tabOpened(tabs) {
+        this.kioskLive = true;
         this.tabUpdated(tabs);
       },
+      tabStopped(tabs) {
+        this.kioskLive = false;
+      },
       tabClosed(tabs) {
+        this.kioskLive = false;
+
         if (tabs.length > 0) {
           this.updateTabTitleInfo(tabs, this.tabUpdateIndicator !== null);
 
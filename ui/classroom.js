@@ -0,0 +1,68 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import * as xhr from "./xhr.js";
+
+const classroomInterface = "/sshwifty/classroom/seat";
+const classroomPath = "/classroom";
+
+/**
+ * Return the query of the seat link the page is opened with, or an empty
+ * string when the page is not opened by a student
+ *
+ * @param {Location} location Location of the page
+ *
+ * @returns {string}
+ *
+ */
+export function query(location) {
+  if (!location.pathname.endsWith(classroomPath)) {
+    return "";
+  }
+
+  const q = new URLSearchParams(location.search);
+
+  if (!q.get("token")) {
+    return "";
+  }
+
+  return location.search;
+}
+
+/**
+ * Log the student in with the token of the seat in the query
+ *
+ * @param {string} q Query of the seat link
+ *
+ * @returns {object} Session of the student, which contains the ID of the
+ *                   Preset of the class (`id`) and the key that replaces the
+ *                   passphrase (`key`)
+ *
+ * @throws {Error} When the seat is refused by the backend
+ *
+ */
+export async function login(q) {
+  let h = await xhr.get(classroomInterface + q, {});
+
+  if (h.status !== 200) {
+    throw new Error(
+      "Unable to join the class: " + h.status + " " + h.responseText,
+    );
+  }
+
+  return JSON.parse(h.responseText);
+}
@@ -0,0 +1,360 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Client of the local agent of dynamic forwarding. The agent accepts local
+// SOCKS5 clients and relays them here through a websocket, where they're
+// served by the SOCKS5 server that runs in the browser. The agent also
+// listens on the local ports of the local forwards, whose connections are
+// relayed to the targets of the forwards
+
+import * as socks5 from "./socks5.js";
+
+const FRAME_ACCEPT = 0x00;
+const FRAME_DATA = 0x01;
+const FRAME_CLOSE = 0x02;
+const FRAME_LISTEN = 0x03;
+const FRAME_UNLISTEN = 0x04;
+
+const FRAME_HEADER_SIZE = 5;
+
+export const STATUS_CONNECTING = "Connecting";
+export const STATUS_CONNECTED = "Connected";
+export const STATUS_DISCONNECTED = "Disconnected";
+
+/**
+ * Verify the address of the agent
+ *
+ * @param {string} url Address of the agent
+ *
+ * @throws {Error} When the address is invalid
+ *
+ */
+export function verifyURL(url) {
+  let u = null;
+
+  try {
+    u = new URL(url);
+  } catch (e) {
+    throw new Error("Invalid address of the agent");
+  }
+
+  if (u.protocol !== "ws:" && u.protocol !== "wss:") {
+    throw new Error('Address of the agent must start with "ws://" or "wss://"');
+  }
+}
+
+class Forward {
+  /**
+   * constructor
+   *
+   * @param {function} send Sends the data to the local connection
+   * @param {Promise<object>} channel The channel opened to the target of the
+   *                                  forward, see socks5.Server
+   * @param {function} close Closes the local connection
+   *
+   */
+  constructor(send, channel, close) {
+    this.sender = send;
+    this.closer = close;
+    this.channel = null;
+    this.pending = [];
+    this.closed = false;
+
+    this.connect(channel);
+  }
+
+  /**
+   * Wait for the channel to be opened
+   *
+   * @param {Promise<object>} channel The channel being opened
+   *
+   */
+  async connect(channel) {
+    try {
+      this.channel = await channel;
+    } catch (e) {
+      this.close();
+      return;
+    }
+
+    if (this.closed) {
+      this.channel.close();
+      return;
+    }
+
+    for (const d of this.pending) {
+      this.channel.send(d);
+    }
+
+    this.pending = [];
+  }
+
+  /**
+   * Feed the data sent by the local connection
+   *
+   * @param {Uint8Array} data Data
+   *
+   */
+  feed(data) {
+    if (this.closed) {
+      return;
+    }
+
+    if (this.channel === null) {
+      this.pending.push(data);
+      return;
+    }
+
+    this.channel.send(data);
+  }
+
+  /**
+   * Close the local connection and the channel
+   *
+   */
+  close() {
+    if (this.closed) {
+      return;
+    }
+
+    this.closed = true;
+    this.pending = [];
+
+    if (this.channel !== null) {
+      this.channel.close();
+    }
+
+    this.closer();
+  }
+}
+
+export class Agent {
+  /**
+   * constructor
+   *
+   * @param {string} url Address of the agent, i.e. ws://127.0.0.1:8183
+   * @param {function} connect Connects to the host and port requested by the
+   *                           SOCKS5 clients, see socks5.Server
+   * @param {function} status Called when the status of the agent changes
+   * @param {Array<object>} forwards Local forwards, each has the `listen`
+   *                                 address and the `host` and `port` of the
+   *                                 target
+   * @param {function} forward Connects to the target of the local forwards,
+   *                           just like `connect`
+   *
+   */
+  constructor(url, connect, status, forwards, forward) {
+    this.url = url;
+    this.connector = connect;
+    this.statusChanged = status;
+    this.forwards = (forwards || []).map((f) => {
+      return {
+        listen: f.listen,
+        host: f.host,
+        port: f.port,
+        listened: "",
+        error: "",
+      };
+    });
+    this.forwarder = forward;
+    this.servers = new Map();
+    this.socket = null;
+  }
+
+  /**
+   * Connect to the agent
+   *
+   */
+  start() {
+    const self = this;
+
+    self.socket = new WebSocket(self.url);
+    self.socket.binaryType = "arraybuffer";
+    self.statusChanged(STATUS_CONNECTING);
+
+    self.socket.addEventListener("open", () => {
+      self.statusChanged(STATUS_CONNECTED);
+
+      for (let i = 0; i < self.forwards.length; i++) {
+        self.send(
+          FRAME_LISTEN,
+          i + 1,
+          new TextEncoder().encode(self.forwards[i].listen),
+        );
+      }
+    });
+
+    self.socket.addEventListener("message", (e) => {
+      self.receive(new Uint8Array(e.data));
+    });
+
+    self.socket.addEventListener("close", () => {
+      for (const server of self.servers.values()) {
+        server.close();
+      }
+
+      self.servers.clear();
+
+      for (const f of self.forwards) {
+        f.listened = "";
+      }
+
+      self.statusChanged(STATUS_DISCONNECTED);
+    });
+  }
+
+  /**
+   * Send a frame to the agent
+   *
+   * @param {number} frame Frame type
+   * @param {number} id Connection ID
+   * @param {Uint8Array} payload Payload
+   *
+   */
+  send(frame, id, payload) {
+    if (this.socket === null || this.socket.readyState !== WebSocket.OPEN) {
+      return;
+    }
+
+    const d = new Uint8Array(FRAME_HEADER_SIZE + payload.length);
+
+    d[0] = frame;
+    new DataView(d.buffer).setUint32(1, id);
+    d.set(payload, FRAME_HEADER_SIZE);
+
+    this.socket.send(d);
+  }
+
+  /**
+   * Handle a frame sent by the agent
+   *
+   * @param {Uint8Array} d Frame
+   *
+   */
+  receive(d) {
+    if (d.length < FRAME_HEADER_SIZE) {
+      return;
+    }
+
+    const self = this,
+      id = new DataView(d.buffer, d.byteOffset).getUint32(1),
+      payload = d.subarray(FRAME_HEADER_SIZE);
+
+    const send = (data) => {
+        self.send(FRAME_DATA, id, data);
+      },
+      close = () => {
+        if (self.servers.delete(id)) {
+          self.send(FRAME_CLOSE, id, new Uint8Array(0));
+        }
+      };
+
+    switch (d[0]) {
+      case FRAME_ACCEPT:
+        if (payload.length <= 0) {
+          self.servers.set(id, new socks5.Server(send, self.connector, close));
+          return;
+        }
+
+        self.accept(
+          id,
+          new DataView(payload.buffer, payload.byteOffset).getUint32(0),
+          send,
+          close,
+        );
+        return;
+
+      case FRAME_DATA:
+        if (self.servers.has(id)) {
+          self.servers.get(id).feed(payload);
+        }
+        return;
+
+      case FRAME_CLOSE:
+        if (self.servers.has(id)) {
+          const server = self.servers.get(id);
+
+          self.servers.delete(id);
+          server.close();
+        }
+        return;
+
+      case FRAME_LISTEN:
+        if (id > 0 && id <= self.forwards.length) {
+          self.forwards[id - 1].listened = new TextDecoder("utf-8").decode(
+            payload,
+          );
+          self.forwards[id - 1].error = "";
+        }
+        return;
+
+      case FRAME_UNLISTEN:
+        if (id > 0 && id <= self.forwards.length) {
+          self.forwards[id - 1].listened = "";
+          self.forwards[id - 1].error = new TextDecoder("utf-8").decode(
+            payload,
+          );
+        }
+        return;
+    }
+  }
+
+  /**
+   * Relay a connection accepted by the listener of a local forward
+   *
+   * @param {number} id Connection ID
+   * @param {number} listener Listener ID
+   * @param {function} send Sends data to the connection
+   * @param {function} close Closes the connection
+   *
+   */
+  accept(id, listener, send, close) {
+    if (listener <= 0 || listener > this.forwards.length) {
+      this.send(FRAME_CLOSE, id, new Uint8Array(0));
+      return;
+    }
+
+    const f = this.forwards[listener - 1],
+      forward = new Forward(
+        send,
+        this.forwarder(f.host, f.port, {
+          data(d) {
+            send(d);
+          },
+          close() {
+            forward.close();
+          },
+        }),
+        close,
+      );
+
+    this.servers.set(id, forward);
+  }
+
+  /**
+   * Disconnect from the agent
+   *
+   */
+  close() {
+    if (this.socket === null) {
+      return;
+    }
+
+    this.socket.close();
+    this.socket = null;
+  }
+}
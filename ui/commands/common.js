@@ -60,6 +60,10 @@ const availableEncodings = [
 export const MAX_HOOK_OUTPUT_LEN = 128;
 export const HOOK_OUTPUT_STR_ELLIPSIS = "...";
 
+// Interrupts that stop the program flooding the terminal, by the names of the
+// signals raised by their control keys (^C and ^\)
+export const interrupts = ["INT", "QUIT"];
+
 export const charsetPresets = (() => {
   let r = [];
 
@@ -426,3 +430,76 @@ export function splitHostPort(d, defPort) {
     port: portNum,
   };
 }
+
+/**
+ * Returns the title of the connection failure described by the DialFailure
+ * code sent by the backend
+ *
+ * @param {number|null} code The DialFailure code, null when it's unknown
+ *
+ * @returns {string} Title of the failure
+ *
+ */
+export function dialFailureTitle(code) {
+  switch (code) {
+    case 0x01:
+      return "Host not found";
+
+    case 0x02:
+      return "Connection refused";
+
+    case 0x03:
+      return "Host unreachable";
+
+    case 0x04:
+      return "Connection timed out";
+
+    case 0x05:
+      return "Proxy error";
+
+    case 0x06:
+      return "TLS error";
+
+    case 0x07:
+      return "Authentication rejected";
+
+    case 0x08:
+      return "Host key mismatch";
+
+    default:
+      return "Connection failed";
+  }
+}
+
+/**
+ * Returns the notice which tells the user about the other sessions that are
+ * connected to the same account of the remote
+ *
+ * @param {Array<object>} sessions The other sessions sent by the backend,
+ *                                 empty when the session is alone again
+ *
+ * @returns {string} The notice to be written into the terminal
+ *
+ */
+export function sharingNotice(sessions) {
+  if (sessions.length <= 0) {
+    return (
+      "\r\n\x1b[33mNo other session is connected to the remote account " +
+      "now\x1b[0m\r\n"
+    );
+  }
+
+  const listed = sessions.map((s) => {
+    return (
+      (s.user ? s.user + " (" + s.client + ")" : s.client) +
+      " since " +
+      new Date(s.since).toLocaleTimeString()
+    );
+  });
+
+  return (
+    "\r\n\x1b[33mWarning: Also connected to the remote account: " +
+    listed.join(", ") +
+    "\x1b[0m\r\n"
+  );
+}
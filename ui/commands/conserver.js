@@ -0,0 +1,999 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import * as header from "../stream/header.js";
+import * as reader from "../stream/reader.js";
+import * as stream from "../stream/stream.js";
+import * as address from "./address.js";
+import * as command from "./commands.js";
+import * as common from "./common.js";
+import * as controls from "./controls.js";
+import * as event from "./events.js";
+import Exception from "./exception.js";
+import * as history from "./history.js";
+import * as presets from "./presets.js";
+import * as signals from "./signals.js";
+import * as stepUp from "./step_up.js";
+import * as strings from "./string.js";
+
+const COMMAND_ID = 0x02;
+
+const MAX_USERNAME_LEN = 64;
+const MAX_CONSOLE_LEN = 64;
+const MAX_ANSWER_LEN = 256;
+
+const SERVER_INITIAL_ERROR_BAD_ADDRESS = 0x01;
+const SERVER_INITIAL_ERROR_BAD_USER = 0x02;
+const SERVER_INITIAL_ERROR_BAD_CONSOLE = 0x03;
+
+const OPTION_PROTOBUF = 0x01;
+
+const SERVER_REMOTE_BAND = 0x00;
+const SERVER_HOOK_OUTPUT_BEFORE_CONNECTING = 0x01;
+const SERVER_CONNECT_FAILED = 0x02;
+const SERVER_CONNECTED = 0x03;
+const SERVER_STEP_UP = 0x04;
+const SERVER_REQUEST_PASSWORD = 0x05;
+const SERVER_SELECT_CONSOLE = 0x06;
+const SERVER_FRAMING = 0x07;
+
+const CLIENT_RESPOND_STEP_UP = 0x01;
+const CLIENT_RESPOND = 0x02;
+
+const DEFAULT_PORT = 782;
+
+const HostMaxSearchResults = 3;
+
+class Conserver {
+  /**
+   * constructor
+   *
+   * @param {stream.Sender} sd Stream sender
+   * @param {object} config configuration
+   * @param {object} callbacks Event callbacks
+   *
+   */
+  constructor(sd, config, callbacks) {
+    this.sender = sd;
+    this.config = config;
+    this.connected = false;
+    this.framing = signals.FRAMING_BINARY;
+    this.events = new event.Events(
+      [
+        "initialization.failed",
+        "initialized",
+        "hook.before_connected",
+        "connect.failed",
+        "connect.succeed",
+        "connect.step_up",
+        "connect.password",
+        "connect.select_console",
+        "@inband",
+        "close",
+        "@completed",
+      ],
+      callbacks,
+    );
+  }
+
+  /**
+   * Send intial request
+   *
+   * @param {stream.InitialSender} initialSender Initial stream request sender
+   *
+   */
+  run(initialSender) {
+    let addr = new address.Address(
+        this.config.host.type,
+        this.config.host.address,
+        this.config.host.port,
+      ),
+      addrBuf = addr.buffer(),
+      userBuf = new strings.String(
+        common.strToUint8Array(this.config.user),
+      ).buffer(),
+      consoleBuf = new strings.String(
+        common.strToUint8Array(this.config.console),
+      ).buffer();
+
+    let data = new Uint8Array(
+      addrBuf.length + userBuf.length + consoleBuf.length + 1,
+    );
+
+    data.set(addrBuf, 0);
+    data.set(userBuf, addrBuf.length);
+    data.set(consoleBuf, addrBuf.length + userBuf.length);
+    data[data.length - 1] = OPTION_PROTOBUF;
+
+    initialSender.send(data);
+  }
+
+  /**
+   * Receive the initial stream request
+   *
+   * @param {header.InitialStream} streamInitialHeader Server respond on the
+   *                                                   initial stream request
+   *
+   */
+  initialize(streamInitialHeader) {
+    if (!streamInitialHeader.success()) {
+      this.events.fire("initialization.failed", streamInitialHeader);
+
+      return;
+    }
+
+    this.events.fire("initialized", streamInitialHeader);
+  }
+
+  /**
+   * Tick the command
+   *
+   * @param {header.Stream} streamHeader Stream data header
+   * @param {reader.Limited} rd Data reader
+   *
+   * @returns {any} The result of the ticking
+   *
+   * @throws {Exception} When the stream header type is unknown
+   *
+   */
+  async tick(streamHeader, rd) {
+    switch (streamHeader.marker()) {
+      case SERVER_FRAMING:
+        if (!this.connected) {
+          const d = await reader.readOne(rd);
+
+          this.framing = d[0];
+
+          return;
+        }
+        break;
+
+      case SERVER_CONNECTED:
+        if (!this.connected) {
+          this.connected = true;
+
+          return this.events.fire("connect.succeed", rd, this);
+        }
+        break;
+
+      case SERVER_CONNECT_FAILED:
+        if (!this.connected) {
+          return this.events.fire("connect.failed", rd);
+        }
+        break;
+
+      case SERVER_HOOK_OUTPUT_BEFORE_CONNECTING:
+        if (!this.connected) {
+          return this.events.fire("hook.before_connected", rd);
+        }
+        break;
+
+      case SERVER_STEP_UP:
+        if (!this.connected) {
+          return this.events.fire("connect.step_up", rd, this.sender);
+        }
+        break;
+
+      case SERVER_REQUEST_PASSWORD:
+        if (!this.connected) {
+          return this.events.fire("connect.password", rd, this.sender);
+        }
+        break;
+
+      case SERVER_SELECT_CONSOLE:
+        if (!this.connected) {
+          const d = await reader.readCompletely(rd);
+
+          return this.events.fire(
+            "connect.select_console",
+            this.framing === signals.FRAMING_PROTOBUF
+              ? signals.conserverConsoles(d)
+              : new TextDecoder("utf-8")
+                  .decode(d)
+                  .split("\n")
+                  .filter((c) => c.length > 0),
+            this.sender,
+          );
+        }
+        break;
+
+      case SERVER_REMOTE_BAND:
+        if (this.connected) {
+          return this.events.fire("inband", rd);
+        }
+        break;
+    }
+
+    throw new Exception("Unknown stream header marker");
+  }
+
+  /**
+   * Send close signal to remote
+   *
+   */
+  sendClose() {
+    return this.sender.close();
+  }
+
+  /**
+   * Send data to remote
+   *
+   * @param {Uint8Array} data
+   *
+   */
+  sendData(data) {
+    return this.sender.sendData(0x00, data);
+  }
+
+  /**
+   * Close the command
+   *
+   */
+  close() {
+    this.sendClose();
+
+    return this.events.fire("close");
+  }
+
+  /**
+   * Tear down the command completely
+   *
+   */
+  completed() {
+    return this.events.fire("completed");
+  }
+}
+
+const initialFieldDef = {
+  Host: {
+    name: "Host",
+    description: "The master server of the conserver",
+    type: "text",
+    value: "",
+    example: "console.nirui.org:782",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.length <= 0) {
+        throw new Error("Hostname must be specified");
+      }
+
+      let addr = common.splitHostPort(d, DEFAULT_PORT);
+
+      if (addr.addr.length <= 0) {
+        throw new Error("Cannot be empty");
+      }
+
+      if (addr.addr.length > address.MAX_ADDR_LEN) {
+        throw new Error(
+          "Can no longer than " + address.MAX_ADDR_LEN + " bytes",
+        );
+      }
+
+      if (addr.port <= 0) {
+        throw new Error("Port must be specified");
+      }
+
+      return "Look like " + addr.type + " address";
+    },
+  },
+  User: {
+    name: "User",
+    description: "",
+    type: "text",
+    value: "",
+    example: "guest",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.length <= 0) {
+        throw new Error("Username must be specified");
+      }
+
+      if (d.length > MAX_USERNAME_LEN) {
+        throw new Error(
+          "Username must not longer than " + MAX_USERNAME_LEN + " bytes",
+        );
+      }
+
+      return "We'll login as user \"" + d + '"';
+    },
+  },
+  Console: {
+    name: "Console",
+    description:
+      "Name of the console to attach. Leave it empty to select one from " +
+      "the consoles of the server",
+    type: "text",
+    value: "",
+    example: "rack1-sw1",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.length > MAX_CONSOLE_LEN) {
+        throw new Error(
+          "Console name must not longer than " + MAX_CONSOLE_LEN + " bytes",
+        );
+      }
+
+      if (d.length <= 0) {
+        return "We'll list the consoles of the server";
+      }
+
+      return "We'll attach to console \"" + d + '"';
+    },
+  },
+  "Console List": {
+    name: "Console List",
+    description: "Consoles of the server",
+    type: "select",
+    value: "",
+    example: "",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.length <= 0) {
+        throw new Error("Console must be selected");
+      }
+
+      return "";
+    },
+  },
+  Password: {
+    name: "Password",
+    description: "",
+    type: "password",
+    value: "",
+    example: "----------",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.length <= 0) {
+        throw new Error("Password must be specified");
+      }
+
+      if (d.length > MAX_ANSWER_LEN) {
+        throw new Error(
+          "It's too long, make it shorter than " + MAX_ANSWER_LEN + " bytes",
+        );
+      }
+
+      return "We'll login with this password";
+    },
+  },
+  Encoding: {
+    name: "Encoding",
+    description: "The character encoding of the console",
+    type: "select",
+    value: "utf-8",
+    example: common.charsetPresets.join(","),
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      for (let i in common.charsetPresets) {
+        if (common.charsetPresets[i] !== d) {
+          continue;
+        }
+
+        return "";
+      }
+
+      throw new Error('The character encoding "' + d + '" is not supported');
+    },
+  },
+};
+
+class Wizard {
+  /**
+   * constructor
+   *
+   * @param {command.Info} info
+   * @param {presets.Preset} preset
+   * @param {object} session
+   * @param {Array<string>} keptSessions
+   * @param {streams.Streams} streams
+   * @param {subscribe.Subscribe} subs
+   * @param {controls.Controls} controls
+   * @param {history.History} history
+   *
+   */
+  constructor(
+    info,
+    preset,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    this.info = info;
+    this.preset = preset;
+    this.hasStarted = false;
+    this.streams = streams;
+    this.session = session;
+    this.keptSessions = keptSessions;
+    this.step = subs;
+    this.controls = controls.get("Conserver");
+    this.history = history;
+  }
+
+  run() {
+    this.step.resolve(this.stepInitialPrompt());
+  }
+
+  started() {
+    return this.hasStarted;
+  }
+
+  control() {
+    return this.controls;
+  }
+
+  close() {
+    this.step.resolve(
+      this.stepErrorDone(
+        "Action cancelled",
+        "Action has been cancelled without reach any success",
+      ),
+    );
+  }
+
+  stepErrorDone(title, message) {
+    return command.done(false, null, title, message);
+  }
+
+  stepHookOutputPrompt(title, msg) {
+    return command.wait(
+      title,
+      strings.truncate(
+        msg,
+        common.MAX_HOOK_OUTPUT_LEN,
+        common.HOOK_OUTPUT_STR_ELLIPSIS,
+      ),
+    );
+  }
+
+  stepSuccessfulDone(data) {
+    return command.done(
+      true,
+      data,
+      "Success!",
+      "We have attached to the console",
+    );
+  }
+
+  stepWaitForAcceptWait() {
+    return command.wait(
+      "Requesting",
+      "Waiting for the request to be accepted by the backend",
+    );
+  }
+
+  stepWaitForEstablishWait(host) {
+    return command.wait(
+      "Connecting to " + host,
+      "Establishing connection with the console server, may take a while",
+    );
+  }
+
+  stepCancelWait(sd) {
+    sd.close();
+
+    return command.wait(
+      "Cancelling",
+      "Cancelling connection request, please wait",
+    );
+  }
+
+  stepPasswordPrompt(sd, configInput) {
+    const self = this;
+
+    return command.prompt(
+      "Provide credential",
+      'Please input the password of user "' + configInput.user + '"',
+      "Login",
+      (r) => {
+        sd.send(CLIENT_RESPOND, new TextEncoder().encode(r.password));
+
+        self.step.resolve(self.stepWaitForEstablishWait(configInput.host));
+      },
+      () => {
+        self.step.resolve(self.stepCancelWait(sd));
+      },
+      command.fieldsWithPreset(
+        initialFieldDef,
+        [{ name: "Password" }],
+        self.preset,
+        (r) => {},
+      ),
+    );
+  }
+
+  stepSelectConsolePrompt(sd, configInput, consoles) {
+    const self = this;
+
+    return command.prompt(
+      "Select console",
+      consoles.length > 0
+        ? "Please select the console to attach"
+        : "The server didn't list any console, please input its name",
+      "Attach",
+      (r) => {
+        const name = consoles.length > 0 ? r["console list"] : r.console;
+
+        configInput.console = name;
+
+        sd.send(CLIENT_RESPOND, common.strToUint8Array(name));
+
+        self.step.resolve(self.stepWaitForEstablishWait(configInput.host));
+      },
+      () => {
+        self.step.resolve(self.stepCancelWait(sd));
+      },
+      consoles.length > 0
+        ? command.fields(initialFieldDef, [
+            {
+              name: "Console List",
+              value: consoles[0],
+              example: consoles.join(","),
+            },
+          ])
+        : command.fields(initialFieldDef, [
+            {
+              name: "Console",
+              verify(d) {
+                if (d.length <= 0) {
+                  throw new Error("Console name must be specified");
+                }
+
+                return initialFieldDef["Console"].verify(d);
+              },
+            },
+          ]),
+    );
+  }
+
+  /**
+   *
+   * @param {stream.Sender} sender
+   * @param {object} configInput
+   * @param {object} sessionData
+   *
+   */
+  buildCommand(sender, configInput, sessionData) {
+    let self = this;
+
+    let parsedConfig = {
+      host: address.parseHostPort(configInput.host, DEFAULT_PORT),
+      user: configInput.user,
+      console: configInput.console,
+      charset: configInput.charset,
+    };
+
+    // Copy the keptSessions from the record so it will not be overwritten here
+    let keptSessions = self.keptSessions ? [].concat(...self.keptSessions) : [];
+
+    return new Conserver(sender, parsedConfig, {
+      "initialization.failed"(streamInitialHeader) {
+        switch (streamInitialHeader.data()) {
+          case SERVER_INITIAL_ERROR_BAD_ADDRESS:
+            self.step.resolve(
+              self.stepErrorDone("Request rejected", "Invalid address"),
+            );
+
+            return;
+
+          case SERVER_INITIAL_ERROR_BAD_USER:
+            self.step.resolve(
+              self.stepErrorDone("Request rejected", "Invalid user"),
+            );
+
+            return;
+
+          case SERVER_INITIAL_ERROR_BAD_CONSOLE:
+            self.step.resolve(
+              self.stepErrorDone("Request rejected", "Invalid console"),
+            );
+
+            return;
+
+          case header.INITIAL_ERROR_SHUTTING_DOWN:
+            self.step.resolve(
+              self.stepErrorDone(
+                "Request rejected",
+                "The server is shutting down, please try again later",
+              ),
+            );
+
+            return;
+        }
+
+        self.step.resolve(
+          self.stepErrorDone(
+            "Request rejected",
+            "Unknown error code: " + streamInitialHeader.data(),
+          ),
+        );
+      },
+      initialized(streamInitialHeader) {
+        self.step.resolve(self.stepWaitForEstablishWait(configInput.host));
+      },
+      async "hook.before_connected"(rd) {
+        const d = new TextDecoder("utf-8").decode(
+          await reader.readCompletely(rd),
+        );
+        self.step.resolve(
+          self.stepHookOutputPrompt("Waiting for server hook", d),
+        );
+      },
+      async "connect.step_up"(rd, sd) {
+        const method = await reader.readOne(rd);
+
+        self.step.resolve(
+          stepUp.prompt(
+            method[0],
+            (answer) => {
+              sd.send(CLIENT_RESPOND_STEP_UP, answer);
+
+              self.step.resolve(
+                self.stepWaitForEstablishWait(configInput.host),
+              );
+            },
+            () => {
+              self.step.resolve(self.stepCancelWait(sd));
+            },
+          ),
+        );
+      },
+      async "connect.password"(rd, sd) {
+        await reader.readCompletely(rd);
+
+        self.step.resolve(self.stepPasswordPrompt(sd, configInput));
+      },
+      "connect.select_console"(consoles, sd) {
+        self.step.resolve(
+          self.stepSelectConsolePrompt(sd, configInput, consoles),
+        );
+      },
+      "connect.succeed"(rd, commandHandler) {
+        self.step.resolve(
+          self.stepSuccessfulDone(
+            new command.Result(
+              configInput.console + "@" + configInput.host,
+              self.info,
+              self.controls.build({
+                charset: parsedConfig.charset,
+                tabColor: configInput.tabColor,
+                send(data) {
+                  return commandHandler.sendData(data);
+                },
+                close() {
+                  return commandHandler.sendClose();
+                },
+                events: commandHandler.events,
+              }),
+              self.controls.ui(),
+            ),
+          ),
+        );
+
+        // Connections of the "no trace" presets are not kept in the history
+        if (!(self.preset && self.preset.noTrace())) {
+          self.history.save(
+            self.info.name() +
+              ":" +
+              configInput.user +
+              "@" +
+              configInput.host +
+              "/" +
+              configInput.console,
+            configInput.console + "@" + configInput.host,
+            new Date(),
+            self.info,
+            {
+              host: configInput.host,
+              user: configInput.user,
+              console: configInput.console,
+              charset: configInput.charset,
+              tabColor: configInput.tabColor,
+            },
+            sessionData,
+            keptSessions,
+          );
+        }
+      },
+      async "connect.failed"(rd) {
+        let readed = await reader.readCompletely(rd),
+          message = new TextDecoder("utf-8").decode(readed.buffer);
+
+        self.step.resolve(self.stepErrorDone("Connection failed", message));
+      },
+      "@inband"(rd) {},
+      close() {},
+      "@completed"() {},
+    });
+  }
+
+  stepInitialPrompt() {
+    const self = this;
+
+    return command.prompt(
+      "Conserver",
+      "Console server",
+      "Connect",
+      (r) => {
+        self.hasStarted = true;
+
+        self.streams.request(COMMAND_ID, (sd) => {
+          return self.buildCommand(
+            sd,
+            {
+              host: r.host,
+              user: r.user,
+              console: r.console,
+              charset: r.encoding,
+              tabColor: self.preset ? self.preset.tabColor() : "",
+            },
+            self.session,
+          );
+        });
+
+        self.step.resolve(self.stepWaitForAcceptWait());
+      },
+      () => {},
+      command.fieldsWithPreset(
+        initialFieldDef,
+        [
+          {
+            name: "Host",
+            suggestions(input) {
+              const hosts = self.history.search(
+                "Conserver",
+                "host",
+                input,
+                HostMaxSearchResults,
+              );
+
+              let sugg = [];
+
+              for (let i = 0; i < hosts.length; i++) {
+                sugg.push({
+                  title: hosts[i].title,
+                  value: hosts[i].data.host,
+                  meta: {
+                    User: hosts[i].data.user,
+                    Console: hosts[i].data.console,
+                    Encoding: hosts[i].data.charset,
+                  },
+                });
+              }
+
+              return sugg;
+            },
+          },
+          { name: "User" },
+          { name: "Console" },
+          { name: "Encoding" },
+        ],
+        self.preset,
+        (r) => {},
+      ),
+    );
+  }
+}
+
+class Executor extends Wizard {
+  /**
+   * constructor
+   *
+   * @param {command.Info} info
+   * @param {object} config
+   * @param {object} session
+   * @param {Array<string>} keptSessions
+   * @param {streams.Streams} streams
+   * @param {subscribe.Subscribe} subs
+   * @param {controls.Controls} controls
+   * @param {history.History} history
+   *
+   */
+  constructor(
+    info,
+    config,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    super(
+      info,
+      presets.emptyPreset(),
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+
+    this.config = config;
+  }
+
+  stepInitialPrompt() {
+    const self = this;
+
+    self.hasStarted = true;
+
+    self.streams.request(COMMAND_ID, (sd) => {
+      return self.buildCommand(
+        sd,
+        {
+          host: self.config.host,
+          user: self.config.user,
+          console: self.config.console ? self.config.console : "",
+          charset: self.config.charset ? self.config.charset : "utf-8",
+          tabColor: self.config.tabColor ? self.config.tabColor : "",
+        },
+        self.session,
+      );
+    });
+
+    return self.stepWaitForAcceptWait();
+  }
+}
+
+export class Command {
+  constructor() {}
+
+  id() {
+    return COMMAND_ID;
+  }
+
+  name() {
+    return "Conserver";
+  }
+
+  description() {
+    return "Console server";
+  }
+
+  color() {
+    return "#c96";
+  }
+
+  wizard(
+    info,
+    preset,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    return new Wizard(
+      info,
+      preset,
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  execute(
+    info,
+    config,
+    session,
+    keptSessions,
+    streams,
+    subs,
+    controls,
+    history,
+  ) {
+    return new Executor(
+      info,
+      config,
+      session,
+      keptSessions,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  launch(info, launcher, streams, subs, controls, history) {
+    const d = launcher.split("|", 3);
+
+    const userHostName = d[0].match(new RegExp("^(.*)\\@(.*)$"));
+
+    if (!userHostName || userHostName.length !== 3) {
+      throw new Exception('Given launcher "' + launcher + '" was malformed');
+    }
+
+    let user = userHostName[1],
+      host = userHostName[2],
+      console = d.length >= 2 ? d[1] : "",
+      charset = d.length >= 3 && d[2] ? d[2] : "utf-8";
+
+    try {
+      initialFieldDef["User"].verify(user);
+      initialFieldDef["Host"].verify(host);
+      initialFieldDef["Console"].verify(console);
+      initialFieldDef["Encoding"].verify(charset);
+    } catch (e) {
+      throw new Exception(
+        'Given launcher "' + launcher + '" was malformed ' + e,
+      );
+    }
+
+    return this.execute(
+      info,
+      {
+        user: user,
+        host: host,
+        console: console,
+        charset: charset,
+      },
+      null,
+      null,
+      streams,
+      subs,
+      controls,
+      history,
+    );
+  }
+
+  launcher(config) {
+    return (
+      config.user +
+      "@" +
+      config.host +
+      "|" +
+      (config.console ? config.console : "") +
+      "|" +
+      (config.charset ? config.charset : "utf-8")
+    );
+  }
+
+  represet(preset) {
+    const host = preset.host();
+
+    if (host.length > 0) {
+      preset.insertMeta("Host", host);
+    }
+
+    return preset;
+  }
+}
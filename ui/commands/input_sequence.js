@@ -0,0 +1,65 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Numbering of the input frames, see application/commands/input_sequence.go.
+// Every frame starts with a 32 bits big-endian sequence number counted up
+// from 1, so the backend can drop the frames it has already received
+
+import * as common from "../stream/common.js";
+import * as header from "../stream/header.js";
+
+export const SIZE = 4;
+
+export class InputSequence {
+  constructor() {
+    this.last = 0;
+  }
+
+  /**
+   * Continue the numbering from the last sequence number accepted by the
+   * backend, i.e. after the session has been resumed on this device
+   *
+   * @param {number} seq Last accepted sequence number
+   *
+   */
+  continueFrom(seq) {
+    this.last = seq >>> 0;
+  }
+
+  /**
+   * Split the data into the numbered input frames
+   *
+   * @param {Uint8Array} data Input data
+   *
+   * @returns {Array<Uint8Array>} The frames, each fits in one stream request
+   *
+   */
+  frames(data) {
+    return common
+      .separateBuffer(data, header.STREAM_MAX_LENGTH - SIZE)
+      .map((seg) => {
+        const f = new Uint8Array(SIZE + seg.length);
+
+        this.last = (this.last + 1) >>> 0;
+
+        new DataView(f.buffer).setUint32(0, this.last);
+        f.set(seg, SIZE);
+
+        return f;
+      });
+  }
+}
@@ -0,0 +1,50 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import assert from "assert";
+import * as header from "../stream/header.js";
+import * as inputSequence from "./input_sequence.js";
+
+describe("InputSequence", () => {
+  it("Frames", () => {
+    const s = new inputSequence.InputSequence();
+
+    assert.deepStrictEqual(s.frames(new Uint8Array([1, 2])), [
+      new Uint8Array([0, 0, 0, 1, 1, 2]),
+    ]);
+
+    const frames = s.frames(new Uint8Array(header.STREAM_MAX_LENGTH));
+
+    assert.strictEqual(frames.length, 2);
+    assert.strictEqual(frames[0].length, header.STREAM_MAX_LENGTH);
+    assert.deepStrictEqual(
+      frames[0].subarray(0, 4),
+      new Uint8Array([0, 0, 0, 2]),
+    );
+    assert.deepStrictEqual(frames[1], new Uint8Array([0, 0, 0, 3, 0, 0, 0, 0]));
+  });
+
+  it("Continue", () => {
+    const s = new inputSequence.InputSequence();
+
+    s.continueFrom(0xffffffff);
+
+    assert.deepStrictEqual(s.frames(new Uint8Array([1])), [
+      new Uint8Array([0, 0, 0, 0, 1]),
+    ]);
+  });
+});
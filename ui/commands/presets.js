@@ -22,11 +22,16 @@ import Exception from "./exception.js";
  *
  */
 const presetItem = {
+  id: -1,
   title: "",
   type: "",
   host: "",
   tab_color: "",
   meta: {},
+  status: "",
+  no_trace: false,
+  command: "",
+  environment: {},
 };
 
 /**
@@ -110,6 +115,27 @@ export class Preset {
     this.preset = parsePresetItem(preset);
   }
 
+  /**
+   * Return the ID of the preset, which is assigned by the backend
+   *
+   * @returns {number}
+   *
+   */
+  id() {
+    return this.preset.id;
+  }
+
+  /**
+   * Return the availability status of the preset when it's being watched by
+   * the backend, otherwise an empty string
+   *
+   * @returns {string}
+   *
+   */
+  status() {
+    return this.preset.status;
+  }
+
   /**
    * Return the title of the preset
    *
@@ -140,6 +166,38 @@ export class Preset {
     return this.preset.host;
   }
 
+  /**
+   * Return whether or not the preset is a "no trace" one, whose connections
+   * must not be kept in the history
+   *
+   * @returns {boolean}
+   *
+   */
+  noTrace() {
+    return this.preset.no_trace;
+  }
+
+  /**
+   * Return the command the preset runs instead of an interactive shell, or
+   * an empty string when it opens a shell
+   *
+   * @returns {string}
+   *
+   */
+  command() {
+    return this.preset.command;
+  }
+
+  /**
+   * Return the environment variables the preset sets for the session
+   *
+   * @returns {object} Values of the variables, keyed by their names
+   *
+   */
+  environment() {
+    return this.preset.environment;
+  }
+
   /**
    * Return the tab color of the preset
    *
@@ -256,6 +314,26 @@ export class Presets {
     }
   }
 
+  /**
+   * Return the availability status of all watched presets
+   *
+   * @returns {object} Statuses indexed by the ID of the presets
+   *
+   */
+  statuses() {
+    let statuses = {};
+
+    for (let i = 0; i < this.presets.length; i++) {
+      if (this.presets[i].status().length <= 0) {
+        continue;
+      }
+
+      statuses[this.presets[i].id()] = this.presets[i].status();
+    }
+
+    return statuses;
+  }
+
   /**
    * Return all presets of a type
    *
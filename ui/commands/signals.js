@@ -0,0 +1,528 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Codec of the messages of application/commands/signals.proto, used for the
+// payloads of the SSH signals once FRAMING_PROTOBUF is negotiated. The
+// decoded messages are the same objects the JSON payloads are parsed into
+
+import Exception from "./exception.js";
+
+export const FRAMING_BINARY = 0x00;
+export const FRAMING_PROTOBUF = 0x01;
+
+const WIRE_VARINT = 0;
+const WIRE_FIXED64 = 1;
+const WIRE_BYTES = 2;
+const WIRE_FIXED32 = 5;
+
+const textEncoder = new TextEncoder();
+const textDecoder = new TextDecoder("utf-8");
+
+export class Message {
+  /**
+   * constructor
+   *
+   */
+  constructor() {
+    this.data = [];
+  }
+
+  /**
+   * Append a varint
+   *
+   * @param {number} v Value, no greater than Number.MAX_SAFE_INTEGER
+   *
+   */
+  appendVarint(v) {
+    while (v > 0x7f) {
+      this.data.push((v % 0x80) | 0x80);
+      v = Math.floor(v / 0x80);
+    }
+
+    this.data.push(v);
+  }
+
+  /**
+   * Encode a varint field. Fields of zero value are omitted, as proto3 does
+   *
+   * @param {number} field Field number
+   * @param {number} v Value
+   *
+   */
+  varint(field, v) {
+    if (!v) {
+      return;
+    }
+
+    this.appendVarint((field << 3) | WIRE_VARINT);
+    this.appendVarint(v);
+  }
+
+  /**
+   * Encode a string field. Empty strings are omitted
+   *
+   * @param {number} field Field number
+   * @param {string} s Value
+   *
+   */
+  string(field, s) {
+    if (!s) {
+      return;
+    }
+
+    const b = textEncoder.encode(s);
+
+    this.appendVarint((field << 3) | WIRE_BYTES);
+    this.appendVarint(b.length);
+    this.data.push(...b);
+  }
+
+  /**
+   * Return the encoded message
+   *
+   * @returns {Uint8Array} Encoded message
+   *
+   */
+  buffer() {
+    return new Uint8Array(this.data);
+  }
+}
+
+/**
+ * Decode the fields of the protobuf message. Fields of the fixed size wire
+ * types are skipped, as none of the messages uses them
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {Array<object>} Fields, each has the number, the wire, and the
+ *                          varint or the bytes of it
+ *
+ * @throws {Exception} When the message is malformed
+ *
+ */
+export function fields(data) {
+  const result = [];
+  let pos = 0;
+
+  const varint = () => {
+    let v = 0,
+      scale = 1;
+
+    for (;;) {
+      if (pos >= data.length || scale > Number.MAX_SAFE_INTEGER) {
+        throw new Exception("Malformed protobuf message");
+      }
+
+      const b = data[pos++];
+
+      v += (b & 0x7f) * scale;
+      scale *= 0x80;
+
+      if (b < 0x80) {
+        return v;
+      }
+    }
+  };
+
+  const skip = (n) => {
+    if (pos + n > data.length) {
+      throw new Exception("Malformed protobuf message");
+    }
+
+    pos += n;
+  };
+
+  while (pos < data.length) {
+    const tag = varint(),
+      f = { number: Math.floor(tag / 8), wire: tag % 8 };
+
+    if (f.number <= 0) {
+      throw new Exception("Malformed protobuf message");
+    }
+
+    switch (f.wire) {
+      case WIRE_VARINT:
+        f.varint = varint();
+        break;
+
+      case WIRE_FIXED64:
+        skip(8);
+        continue;
+
+      case WIRE_BYTES: {
+        const len = varint();
+
+        skip(len);
+        f.bytes = data.subarray(pos - len, pos);
+        break;
+      }
+
+      case WIRE_FIXED32:
+        skip(4);
+        continue;
+
+      default:
+        throw new Exception("Malformed protobuf message");
+    }
+
+    result.push(f);
+  }
+
+  return result;
+}
+
+/**
+ * Decode the message into an object
+ *
+ * @param {Uint8Array} data Encoded message
+ * @param {object} schema Field number to the [name, type] of the field. Type
+ *                        is "number", "bool", "string", or a function which
+ *                        decodes the embedded message
+ * @param {Array<string>} repeated Names of the repeated fields
+ *
+ * @returns {object} Decoded message, fields missing from the data have their
+ *                   default values
+ *
+ */
+function decode(data, schema, repeated = []) {
+  const defaults = { number: 0, bool: false, string: "" },
+    result = {};
+
+  for (const n in schema) {
+    const [name, type] = schema[n];
+
+    if (repeated.indexOf(name) >= 0) {
+      result[name] = [];
+    } else if (typeof type === "string") {
+      result[name] = defaults[type];
+    } else {
+      result[name] = type(new Uint8Array(0));
+    }
+  }
+
+  for (const f of fields(data)) {
+    if (!schema[f.number]) {
+      continue;
+    }
+
+    const [name, type] = schema[f.number];
+    let v = null;
+
+    if (type === "number" || type === "bool") {
+      if (f.wire !== WIRE_VARINT) {
+        continue;
+      }
+
+      v = type === "bool" ? f.varint !== 0 : f.varint;
+    } else {
+      if (f.wire !== WIRE_BYTES) {
+        continue;
+      }
+
+      v = type === "string" ? textDecoder.decode(f.bytes) : type(f.bytes);
+    }
+
+    if (repeated.indexOf(name) >= 0) {
+      result[name].push(v);
+    } else {
+      result[name] = v;
+    }
+  }
+
+  return result;
+}
+
+/**
+ * Decode SSHPromptCountdown
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {number} Seconds left
+ *
+ */
+export function promptCountdown(data) {
+  return decode(data, { 1: ["seconds", "number"] }).seconds;
+}
+
+/**
+ * Decode SSHAttempt
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {object} The attempt and the max attempts
+ *
+ */
+export function attempt(data) {
+  return decode(data, { 1: ["attempt", "number"], 2: ["max", "number"] });
+}
+
+/**
+ * Decode SSHFingerprint
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {string} The fingerprint
+ *
+ */
+export function fingerprint(data) {
+  return decode(data, { 1: ["fingerprint", "string"] }).fingerprint;
+}
+
+/**
+ * Decode SSHSessionEnded
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {object} The exit status (null when it's not reported) and the
+ *                   exit signal
+ *
+ */
+export function sessionEnded(data) {
+  const d = decode(data, {
+    1: ["status", "number"],
+    2: ["signal", "string"],
+    3: ["reported", "bool"],
+  });
+
+  return { status: d.reported ? d.status : null, signal: d.signal };
+}
+
+/**
+ * Decode SSHConnectTiming
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {object} The timing
+ *
+ */
+export function connectTiming(data) {
+  const d = decode(
+      data,
+      {
+        1: ["started", "number"],
+        2: ["phase", "string"],
+        3: ["phase_elapsed", "number"],
+        4: ["timed_out", "bool"],
+        5: [
+          "completed",
+          (e) => decode(e, { 1: ["key", "string"], 2: ["value", "number"] }),
+        ],
+      },
+      ["completed"],
+    ),
+    completed = {};
+
+  for (const e of d.completed) {
+    completed[e.key] = e.value;
+  }
+
+  d.completed = completed;
+
+  return d;
+}
+
+/**
+ * Decode SSHTransportInfo
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {object} The transport info
+ *
+ */
+export function transportInfo(data) {
+  return decode(data, {
+    1: ["client_version", "string"],
+    2: ["server_version", "string"],
+    3: ["kex", "string"],
+    4: ["host_key", "string"],
+    5: ["cipher_client_server", "string"],
+    6: ["cipher_server_client", "string"],
+    7: ["mac_client_server", "string"],
+    8: ["mac_server_client", "string"],
+  });
+}
+
+/**
+ * Decode SSHWeakTransport
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {Array<string>} The warnings
+ *
+ */
+export function weakTransport(data) {
+  return decode(data, { 1: ["warnings", "string"] }, ["warnings"]).warnings;
+}
+
+/**
+ * Decode SSHForwards
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {Array<object>} The forwards
+ *
+ */
+export function forwards(data) {
+  const forward = (d) =>
+    decode(d, {
+      1: ["name", "string"],
+      2: ["target", "string"],
+      3: ["local", "string"],
+      4: ["preview", "string"],
+      5: ["error", "string"],
+    });
+
+  return decode(data, { 1: ["forwards", forward] }, ["forwards"]).forwards;
+}
+
+/**
+ * Decode SSHPushApproval
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {object} The push approval
+ *
+ */
+export function pushApproval(data) {
+  return decode(data, {
+    1: ["provider", "string"],
+    2: ["timeout", "number"],
+  });
+}
+
+/**
+ * Decode SSHReverseForwardResult
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {object} The result
+ *
+ */
+export function reverseForwardResult(data) {
+  return decode(data, {
+    1: ["bind", "string"],
+    2: ["target", "string"],
+    3: ["listen", "string"],
+    4: ["error", "string"],
+  });
+}
+
+/**
+ * Decode SSHReconnectStatus
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {object} The status
+ *
+ */
+export function reconnectStatus(data) {
+  return decode(data, {
+    1: ["state", "string"],
+    2: ["attempt", "number"],
+    3: ["attempts", "number"],
+    4: ["delay", "number"],
+    5: ["error", "string"],
+  });
+}
+
+/**
+ * Decode SSHHandoverStatus
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {object} The status
+ *
+ */
+export function handoverStatus(data) {
+  return decode(data, {
+    1: ["state", "string"],
+    2: ["id", "string"],
+    3: ["expires", "number"],
+    4: ["error", "string"],
+  });
+}
+
+/**
+ * Decode SharingSessions
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {Array<object>} The sessions
+ *
+ */
+export function sharingSessions(data) {
+  const session = (d) =>
+    decode(d, {
+      1: ["user", "string"],
+      2: ["client", "string"],
+      3: ["since", "string"],
+    });
+
+  return decode(data, { 1: ["sessions", session] }, ["sessions"]).sessions;
+}
+
+/**
+ * Decode ConserverConsoles
+ *
+ * @param {Uint8Array} data Encoded message
+ *
+ * @returns {Array<string>} Names of the consoles
+ *
+ */
+export function conserverConsoles(data) {
+  return decode(data, { 1: ["names", "string"] }, ["names"]).names;
+}
+
+/**
+ * Encode SSHResize
+ *
+ * @param {number} rows
+ * @param {number} cols
+ * @param {number} width Width of the terminal in pixels, 0 when unknown
+ * @param {number} height Height of the terminal in pixels, 0 when unknown
+ *
+ * @returns {Uint8Array} Encoded message
+ *
+ */
+export function resize(rows, cols, width, height) {
+  const m = new Message();
+
+  m.varint(1, rows);
+  m.varint(2, cols);
+  m.varint(3, Math.min(width || 0, 0xffff));
+  m.varint(4, Math.min(height || 0, 0xffff));
+
+  return m.buffer();
+}
+
+/**
+ * Encode SSHReverseForwardRequest
+ *
+ * @param {string} bind Bind address on the remote, in "host:port"
+ * @param {string} target Target address, in "host:port"
+ *
+ * @returns {Uint8Array} Encoded message
+ *
+ */
+export function reverseForwardRequest(bind, target) {
+  const m = new Message();
+
+  m.string(1, bind);
+  m.string(2, target);
+
+  return m.buffer();
+}
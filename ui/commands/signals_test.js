@@ -0,0 +1,189 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import assert from "assert";
+import * as signals from "./signals.js";
+
+// Encoded by application/commands/signals_protobuf.go
+const bytes = (b) => new Uint8Array(b);
+
+describe("Signals", () => {
+  it("Decode", () => {
+    assert.strictEqual(signals.promptCountdown(bytes([8, 172, 2])), 300);
+    assert.strictEqual(signals.promptCountdown(bytes([])), 0);
+
+    assert.deepStrictEqual(signals.attempt(bytes([8, 2, 16, 3])), {
+      attempt: 2,
+      max: 3,
+    });
+
+    assert.strictEqual(
+      signals.fingerprint(
+        bytes([10, 10, 83, 72, 65, 50, 53, 54, 58, 97, 98, 99]),
+      ),
+      "SHA256:abc",
+    );
+
+    assert.deepStrictEqual(
+      signals.sessionEnded(bytes([8, 130, 1, 18, 3, 73, 78, 84, 24, 1])),
+      { status: 130, signal: "INT" },
+    );
+    assert.deepStrictEqual(signals.sessionEnded(bytes([24, 1])), {
+      status: 0,
+      signal: "",
+    });
+    assert.deepStrictEqual(signals.sessionEnded(bytes([])), {
+      status: null,
+      signal: "",
+    });
+
+    assert.deepStrictEqual(
+      signals.connectTiming(
+        bytes([
+          8, 128, 208, 149, 255, 188, 49, 18, 4, 97, 117, 116, 104, 24, 220, 11,
+          32, 1, 42, 5, 10, 3, 100, 110, 115, 42, 7, 10, 3, 116, 99, 112, 16, 5,
+        ]),
+      ),
+      {
+        started: 1700000000000,
+        phase: "auth",
+        phase_elapsed: 1500,
+        timed_out: true,
+        completed: { dns: 0, tcp: 5 },
+      },
+    );
+
+    const info = signals.transportInfo(
+      bytes([
+        10, 10, 83, 83, 72, 45, 50, 46, 48, 45, 71, 111, 18, 19, 83, 83, 72, 45,
+        50, 46, 48, 45, 79, 112, 101, 110, 83, 83, 72, 95, 57, 46, 54, 26, 17,
+        99, 117, 114, 118, 101, 50, 53, 53, 49, 57, 45, 115, 104, 97, 50, 53,
+        54,
+      ]),
+    );
+
+    assert.strictEqual(info.client_version, "SSH-2.0-Go");
+    assert.strictEqual(info.server_version, "SSH-2.0-OpenSSH_9.6");
+    assert.strictEqual(info.kex, "curve25519-sha256");
+    assert.strictEqual(info.mac_server_client, "");
+
+    assert.deepStrictEqual(
+      signals.weakTransport(
+        bytes([10, 8, 119, 101, 97, 107, 32, 107, 101, 120, 10, 0]),
+      ),
+      ["weak kex", ""],
+    );
+
+    const forwards = signals.forwards(
+      bytes([
+        10, 35, 10, 3, 119, 101, 98, 18, 12, 49, 50, 55, 46, 48, 46, 48, 46, 49,
+        58, 56, 48, 26, 14, 49, 50, 55, 46, 48, 46, 48, 46, 49, 58, 56, 48, 56,
+        48, 10, 0,
+      ]),
+    );
+
+    assert.strictEqual(forwards.length, 2);
+    assert.strictEqual(forwards[0].name, "web");
+    assert.strictEqual(forwards[0].target, "127.0.0.1:80");
+    assert.strictEqual(forwards[0].local, "127.0.0.1:8080");
+    assert.strictEqual(forwards[1].name, "");
+
+    assert.deepStrictEqual(
+      signals.reconnectStatus(
+        bytes([
+          10, 7, 119, 97, 105, 116, 105, 110, 103, 16, 1, 24, 3, 32, 208, 15,
+        ]),
+      ),
+      { state: "waiting", attempt: 1, attempts: 3, delay: 2000, error: "" },
+    );
+
+    assert.deepStrictEqual(
+      signals.handoverStatus(
+        bytes([
+          10, 6, 112, 97, 114, 107, 101, 100, 18, 3, 65, 66, 67, 24, 128, 226,
+          207, 170, 6,
+        ]),
+      ),
+      { state: "parked", id: "ABC", expires: 1700000000, error: "" },
+    );
+
+    assert.deepStrictEqual(
+      signals.sharingSessions(
+        bytes([
+          10, 40, 10, 5, 97, 108, 105, 99, 101, 18, 9, 49, 57, 50, 46, 48, 46,
+          50, 46, 49, 26, 20, 50, 48, 50, 52, 45, 48, 49, 45, 48, 50, 84, 48,
+          51, 58, 48, 52, 58, 48, 53, 90, 10, 33, 18, 9, 49, 57, 50, 46, 48, 46,
+          50, 46, 50, 26, 20, 50, 48, 50, 52, 45, 48, 49, 45, 48, 50, 84, 48,
+          51, 58, 48, 52, 58, 48, 53, 90,
+        ]),
+      ),
+      [
+        { user: "alice", client: "192.0.2.1", since: "2024-01-02T03:04:05Z" },
+        { user: "", client: "192.0.2.2", since: "2024-01-02T03:04:05Z" },
+      ],
+    );
+    assert.deepStrictEqual(signals.sharingSessions(bytes([])), []);
+
+    assert.deepStrictEqual(
+      signals.conserverConsoles(
+        bytes([10, 4, 119, 101, 98, 49, 10, 3, 100, 98, 49]),
+      ),
+      ["web1", "db1"],
+    );
+  });
+
+  it("Encode", () => {
+    const resize = signals.fields(signals.resize(24, 80, 100000, 0));
+
+    assert.deepStrictEqual(
+      resize.map((f) => [f.number, f.varint]),
+      [
+        [1, 24],
+        [2, 80],
+        [3, 0xffff],
+      ],
+    );
+
+    const req = signals.fields(
+      signals.reverseForwardRequest("127.0.0.1:8022", "host:22"),
+    );
+
+    assert.deepStrictEqual(
+      req.map((f) => [f.number, new TextDecoder().decode(f.bytes)]),
+      [
+        [1, "127.0.0.1:8022"],
+        [2, "host:22"],
+      ],
+    );
+  });
+
+  it("Malformed", () => {
+    for (const d of [
+      bytes([0x08]),
+      bytes([0x12, 0x05, 0x61]),
+      bytes([0x00]),
+      bytes([0x0b, 0x00]),
+    ]) {
+      assert.throws(() => signals.fields(d));
+    }
+
+    // Unknown fields and fields of fixed size wire types are skipped
+    const skipped = bytes([0x11, 1, 2, 3, 4, 5, 6, 7, 8, 0x18, 1, 8, 5]);
+
+    assert.strictEqual(signals.promptCountdown(skipped), 5);
+  });
+});
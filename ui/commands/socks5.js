@@ -0,0 +1,302 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// SOCKS5 server (RFC 1928) that runs in the browser. Only the CONNECT command
+// without authentication is supported
+
+const VERSION = 0x05;
+
+const METHOD_NO_AUTH = 0x00;
+const METHOD_NO_ACCEPTABLE = 0xff;
+
+const COMMAND_CONNECT = 0x01;
+
+const ADDRESS_IPV4 = 0x01;
+const ADDRESS_DOMAIN = 0x03;
+const ADDRESS_IPV6 = 0x04;
+
+export const REPLY_SUCCEEDED = 0x00;
+export const REPLY_GENERAL_FAILURE = 0x01;
+export const REPLY_CONNECTION_REFUSED = 0x05;
+export const REPLY_COMMAND_NOT_SUPPORTED = 0x07;
+export const REPLY_ADDRESS_NOT_SUPPORTED = 0x08;
+
+const STATE_GREETING = 0x00;
+const STATE_REQUEST = 0x01;
+const STATE_CONNECTING = 0x02;
+const STATE_RELAYING = 0x03;
+const STATE_CLOSED = 0x04;
+
+/**
+ * Format the IPv6 address
+ *
+ * @param {Uint8Array} d 16 bytes of address
+ *
+ * @returns {string} The address
+ *
+ */
+function formatIPv6(d) {
+  const parts = [];
+
+  for (let i = 0; i < 16; i += 2) {
+    parts.push(((d[i] << 8) | d[i + 1]).toString(16));
+  }
+
+  return parts.join(":");
+}
+
+export class Server {
+  /**
+   * constructor
+   *
+   * @param {function} send Sends the data to the SOCKS5 client
+   * @param {function} connect Connects to the host and port, returns a
+   *                           Promise of the channel which has `send` and
+   *                           `close` methods. The data and the closing of
+   *                           the channel is delivered to the given callbacks
+   * @param {function} close Closes the connection of the SOCKS5 client
+   *
+   */
+  constructor(send, connect, close) {
+    this.sender = send;
+    this.connector = connect;
+    this.closer = close;
+    this.state = STATE_GREETING;
+    this.buffer = new Uint8Array(0);
+    this.pending = [];
+    this.channel = null;
+  }
+
+  /**
+   * Feed the data sent by the SOCKS5 client
+   *
+   * @param {Uint8Array} data Data
+   *
+   */
+  feed(data) {
+    switch (this.state) {
+      case STATE_CLOSED:
+        return;
+
+      case STATE_RELAYING:
+        this.channel.send(data);
+        return;
+
+      case STATE_CONNECTING:
+        this.pending.push(data);
+        return;
+    }
+
+    const buf = new Uint8Array(this.buffer.length + data.length);
+
+    buf.set(this.buffer, 0);
+    buf.set(data, this.buffer.length);
+
+    this.buffer = buf;
+
+    while (this.state === STATE_GREETING || this.state === STATE_REQUEST) {
+      const used =
+        this.state === STATE_GREETING ? this.greeting() : this.request();
+
+      if (used <= 0) {
+        return;
+      }
+
+      this.buffer = this.buffer.slice(used);
+    }
+
+    if (this.buffer.length > 0) {
+      this.pending.push(this.buffer);
+      this.buffer = new Uint8Array(0);
+    }
+  }
+
+  /**
+   * Handle the greeting of the client
+   *
+   * @returns {number} Bytes used, 0 when more data is needed
+   *
+   */
+  greeting() {
+    const b = this.buffer;
+
+    if (b.length < 2 || b.length < 2 + b[1]) {
+      return 0;
+    }
+
+    if (b[0] !== VERSION) {
+      this.close();
+      return 0;
+    }
+
+    if (!b.subarray(2, 2 + b[1]).includes(METHOD_NO_AUTH)) {
+      this.sender(new Uint8Array([VERSION, METHOD_NO_ACCEPTABLE]));
+      this.close();
+      return 0;
+    }
+
+    this.sender(new Uint8Array([VERSION, METHOD_NO_AUTH]));
+    this.state = STATE_REQUEST;
+
+    return 2 + b[1];
+  }
+
+  /**
+   * Handle the request of the client
+   *
+   * @returns {number} Bytes used, 0 when more data is needed
+   *
+   */
+  request() {
+    const b = this.buffer;
+
+    if (b.length < 5) {
+      return 0;
+    }
+
+    let host = "",
+      addrEnd = 0;
+
+    switch (b[3]) {
+      case ADDRESS_IPV4:
+        addrEnd = 4 + 4;
+        break;
+
+      case ADDRESS_DOMAIN:
+        addrEnd = 5 + b[4];
+        break;
+
+      case ADDRESS_IPV6:
+        addrEnd = 4 + 16;
+        break;
+
+      default:
+        this.reply(REPLY_ADDRESS_NOT_SUPPORTED);
+        this.close();
+        return 0;
+    }
+
+    if (b.length < addrEnd + 2) {
+      return 0;
+    }
+
+    if (b[0] !== VERSION) {
+      this.close();
+      return 0;
+    }
+
+    if (b[1] !== COMMAND_CONNECT) {
+      this.reply(REPLY_COMMAND_NOT_SUPPORTED);
+      this.close();
+      return 0;
+    }
+
+    switch (b[3]) {
+      case ADDRESS_IPV4:
+        host = b.subarray(4, addrEnd).join(".");
+        break;
+
+      case ADDRESS_DOMAIN:
+        host = new TextDecoder("utf-8").decode(b.subarray(5, addrEnd));
+        break;
+
+      case ADDRESS_IPV6:
+        host = formatIPv6(b.subarray(4, addrEnd));
+        break;
+    }
+
+    const port = (b[addrEnd] << 8) | b[addrEnd + 1];
+
+    this.state = STATE_CONNECTING;
+    this.connect(host, port);
+
+    return addrEnd + 2;
+  }
+
+  /**
+   * Connect to the requested host and port
+   *
+   * @param {string} host Host
+   * @param {number} port Port
+   *
+   */
+  async connect(host, port) {
+    const self = this;
+
+    try {
+      self.channel = await self.connector(host, port, {
+        data(d) {
+          self.sender(d);
+        },
+        close() {
+          self.close();
+        },
+      });
+    } catch (e) {
+      self.reply(REPLY_CONNECTION_REFUSED);
+      self.close();
+      return;
+    }
+
+    if (self.state === STATE_CLOSED) {
+      self.channel.close();
+      return;
+    }
+
+    self.reply(REPLY_SUCCEEDED);
+    self.state = STATE_RELAYING;
+
+    for (const d of self.pending) {
+      self.channel.send(d);
+    }
+
+    self.pending = [];
+  }
+
+  /**
+   * Send the reply of the request, with empty bind address
+   *
+   * @param {number} rep Reply code
+   *
+   */
+  reply(rep) {
+    this.sender(
+      new Uint8Array([VERSION, rep, 0, ADDRESS_IPV4, 0, 0, 0, 0, 0, 0]),
+    );
+  }
+
+  /**
+   * Close the SOCKS5 connection and the channel
+   *
+   */
+  close() {
+    if (this.state === STATE_CLOSED) {
+      return;
+    }
+
+    const relaying = this.state === STATE_RELAYING;
+
+    this.state = STATE_CLOSED;
+    this.pending = [];
+
+    if (relaying) {
+      this.channel.close();
+    }
+
+    this.closer();
+  }
+}
@@ -0,0 +1,119 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import assert from "assert";
+import * as socks5 from "./socks5.js";
+
+function newServer(connect) {
+  const result = { sent: [], closed: false };
+
+  result.server = new socks5.Server(
+    (d) => {
+      result.sent.push(Array.from(d));
+    },
+    connect,
+    () => {
+      result.closed = true;
+    },
+  );
+
+  return result;
+}
+
+describe("SOCKS5", () => {
+  it("Connect by domain", async () => {
+    let connected = null,
+      channelSent = [];
+
+    const s = newServer(async (host, port, callbacks) => {
+      connected = { host: host, port: port, callbacks: callbacks };
+
+      return {
+        send(d) {
+          channelSent.push(Array.from(d));
+        },
+        close() {},
+      };
+    });
+
+    s.server.feed(new Uint8Array([0x05, 0x01, 0x00]));
+    assert.deepStrictEqual(s.sent, [[0x05, 0x00]]);
+
+    // Request and the data that follows arrives at once
+    s.server.feed(
+      new Uint8Array([
+        0x05, 0x01, 0x00, 0x03, 0x07, 0x65, 0x78, 0x61, 0x6d, 0x70, 0x6c,
+        0x65, 0x00, 0x50, 0x01, 0x02,
+      ]),
+    );
+
+    await new Promise((r) => setTimeout(r, 0));
+
+    assert.deepStrictEqual(connected.host, "example");
+    assert.strictEqual(connected.port, 80);
+    assert.deepStrictEqual(s.sent[1], [0x05, 0x00, 0, 0x01, 0, 0, 0, 0, 0, 0]);
+    assert.deepStrictEqual(channelSent, [[0x01, 0x02]]);
+
+    connected.callbacks.data(new Uint8Array([0x03]));
+    assert.deepStrictEqual(s.sent[2], [0x03]);
+
+    connected.callbacks.close();
+    assert.strictEqual(s.closed, true);
+  });
+
+  it("Connect by IPv6 and refused", async () => {
+    let host = "";
+
+    const s = newServer(async (h, port, callbacks) => {
+      host = h;
+
+      throw new Error("Refused");
+    });
+
+    s.server.feed(new Uint8Array([0x05, 0x01, 0x00]));
+    s.server.feed(
+      new Uint8Array([
+        0x05, 0x01, 0x00, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+        1, 0x00, 0x16,
+      ]),
+    );
+
+    await new Promise((r) => setTimeout(r, 0));
+
+    assert.strictEqual(host, "0:0:0:0:0:0:0:1");
+    assert.strictEqual(s.sent[1][1], socks5.REPLY_CONNECTION_REFUSED);
+    assert.strictEqual(s.closed, true);
+  });
+
+  it("Unsupported command and method", () => {
+    const noAuth = newServer(async () => {});
+
+    noAuth.server.feed(new Uint8Array([0x05, 0x01, 0x02]));
+    assert.deepStrictEqual(noAuth.sent, [[0x05, 0xff]]);
+    assert.strictEqual(noAuth.closed, true);
+
+    const bind = newServer(async () => {});
+
+    bind.server.feed(new Uint8Array([0x05, 0x01, 0x00]));
+    bind.server.feed(new Uint8Array([0x05, 0x02, 0x00, 0x01, 0, 0, 0, 0]));
+    assert.strictEqual(bind.closed, false);
+
+    bind.server.feed(new Uint8Array([0x00, 0x50]));
+    assert.strictEqual(bind.sent[1][1], socks5.REPLY_COMMAND_NOT_SUPPORTED);
+    assert.strictEqual(bind.closed, true);
+  });
+});
@@ -15,27 +15,47 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <https://www.gnu.org/licenses/>.
 
+import { handover } from "../handover.js";
+import { userSettings } from "../settings.js";
 import * as header from "../stream/header.js";
 import * as reader from "../stream/reader.js";
 import * as stream from "../stream/stream.js";
 import * as address from "./address.js";
+import * as agent from "./agent.js";
 import * as command from "./commands.js";
 import * as common from "./common.js";
 import * as controls from "./controls.js";
 import * as event from "./events.js";
 import Exception from "./exception.js";
 import * as history from "./history.js";
+import * as inputSequence from "./input_sequence.js";
 import * as presets from "./presets.js";
+import * as signals from "./signals.js";
+import * as sshDynamic from "./ssh_dynamic.js";
+import * as sshFiles from "./ssh_files.js";
+import * as stepUp from "./step_up.js";
 import * as strings from "./string.js";
+import * as terminal from "./terminal.js";
 
 const AUTHMETHOD_NONE = 0x00;
 const AUTHMETHOD_PASSPHRASE = 0x01;
 const AUTHMETHOD_PRIVATE_KEY = 0x02;
 
+const OPTION_DEBUG_TRANSPORT = 0x01;
+const OPTION_EXEC = 0x02;
+const OPTION_TERMINAL = 0x04;
+const OPTION_ENVIRONMENT = 0x08;
+const OPTION_PRIVATE_KEY = 0x10;
+const OPTION_RESUME = 0x20;
+const OPTION_SEQUENCED_INPUT = 0x40;
+const OPTION_PROTOBUF = 0x80;
+
 const COMMAND_ID = 0x01;
 
 const MAX_USERNAME_LEN = 64;
 const MAX_PASSWORD_LEN = 4096;
+const MAX_COMMAND_LEN = 2048;
+const MAX_ENVIRONMENT = 32;
 const DEFAULT_PORT = 22;
 
 const SERVER_REMOTE_STDOUT = 0x00;
@@ -45,15 +65,52 @@ const SERVER_CONNECT_FAILED = 0x03;
 const SERVER_CONNECTED = 0x04;
 const SERVER_CONNECT_REQUEST_FINGERPRINT = 0x05;
 const SERVER_CONNECT_REQUEST_CREDENTIAL = 0x06;
+const SERVER_EXTENDED = 0x07;
+
+const SERVER_EXTENDED_PROMPT_COUNTDOWN = 0x00;
+const SERVER_EXTENDED_AUTH_ATTEMPT = 0x01;
+const SERVER_EXTENDED_CONNECT_TIMING = 0x02;
+const SERVER_EXTENDED_TRANSPORT_INFO = 0x03;
+const SERVER_EXTENDED_FORWARDS = 0x04;
+const SERVER_EXTENDED_REVERSE_FORWARD = 0x05;
+const SERVER_EXTENDED_DYNAMIC = 0x06;
+const SERVER_EXTENDED_PUSH_APPROVAL = 0x07;
+const SERVER_EXTENDED_STEP_UP = 0x08;
+const SERVER_EXTENDED_FILE_TRANSFER = 0x09;
+const SERVER_EXTENDED_KEY_PASSPHRASE = 0x0a;
+const SERVER_EXTENDED_HOST_KEY_CHANGED = 0x0b;
+const SERVER_EXTENDED_LOCAL_FORWARD = 0x0c;
+const SERVER_EXTENDED_SESSION_ENDED = 0x0d;
+const SERVER_EXTENDED_BANNER = 0x0e;
+const SERVER_EXTENDED_WEAK_TRANSPORT = 0x0f;
+const SERVER_EXTENDED_RECONNECT = 0x10;
+const SERVER_EXTENDED_HANDOVER = 0x11;
+const SERVER_EXTENDED_INPUT_SEQUENCE = 0x12;
+const SERVER_EXTENDED_CONNECT_FAILURE = 0x13;
+const SERVER_EXTENDED_SHARING = 0x14;
+const SERVER_EXTENDED_FRAMING = 0x15;
 
 const CLIENT_DATA_STDIN = 0x00;
 const CLIENT_DATA_RESIZE = 0x01;
 const CLIENT_CONNECT_RESPOND_FINGERPRINT = 0x02;
 const CLIENT_CONNECT_RESPOND_CREDENTIAL = 0x03;
+const CLIENT_REVERSE_FORWARD = 0x04;
+const CLIENT_DYNAMIC = 0x05;
+const CLIENT_CONNECT_RESPOND_STEP_UP = 0x06;
+const CLIENT_FILE_TRANSFER = 0x07;
+
+const REVERSE_FORWARD_SPEC =
+  /^(\[[^\]]+\]|[^:\s]+):(\d+):(\[[^\]]+\]|[^:\s]+):(\d+)$/;
+const LOCAL_FORWARD_SPEC = /^(\d+):(\[[^\]]+\]|[^:\s]+):(\d+)$/;
+const ENVIRONMENT_SPEC = /^([A-Za-z_][0-9A-Za-z_]{0,63})=(.*)$/;
 
 const SERVER_REQUEST_ERROR_BAD_USERNAME = 0x01;
 const SERVER_REQUEST_ERROR_BAD_ADDRESS = 0x02;
 const SERVER_REQUEST_ERROR_BAD_AUTHMETHOD = 0x03;
+const SERVER_REQUEST_ERROR_BAD_COMMAND = 0x04;
+const SERVER_REQUEST_ERROR_BAD_ENVIRONMENT = 0x06;
+const SERVER_REQUEST_ERROR_BAD_PRIVATE_KEY = 0x07;
+const SERVER_REQUEST_ERROR_BAD_HANDOVER = 0x08;
 
 const FingerprintPromptVerifyPassed = 0x00;
 const FingerprintPromptVerifyNoRecord = 0x01;
@@ -61,6 +118,208 @@ const FingerprintPromptVerifyMismatch = 0x02;
 
 const HostMaxSearchResults = 3;
 
+const NewSessionOption = "Start a new session";
+
+/**
+ * Describe the timing data of a failed connection attempt
+ *
+ * @param {object} timing Timing data sent by the backend
+ *
+ * @returns {string} Description of the timing
+ *
+ */
+function describeConnectTiming(timing) {
+  const seconds = (ms) =>
+    (ms / 1000).toLocaleString(undefined, { maximumFractionDigits: 2 }) + "s";
+
+  let result =
+    (timing.timed_out ? "Timed out" : "Failed") +
+    " during " +
+    timing.phase +
+    " after " +
+    seconds(timing.phase_elapsed);
+
+  if (timing.started > 0) {
+    result +=
+      ", attempt started at " + new Date(timing.started).toLocaleTimeString();
+  }
+
+  const completed = Object.keys(timing.completed || {});
+
+  if (completed.length > 0) {
+    result +=
+      " (" +
+      completed
+        .map((k) => k + " took " + seconds(timing.completed[k]))
+        .join(", ") +
+      ")";
+  }
+
+  return result;
+}
+
+/**
+ * Parse the reverse forwards given in the format of
+ * "bind_host:bind_port:target_host:target_port", separated by comma
+ *
+ * @param {string} d Reverse forwards
+ *
+ * @returns {Array<object>} The bind and target addresses of the forwards
+ *
+ * @throws {Error} When any of the forwards is malformed
+ *
+ */
+function parseReverseForwards(d) {
+  const forwards = [];
+
+  for (const spec of (d || "").split(",")) {
+    const s = spec.trim();
+
+    if (s.length <= 0) {
+      continue;
+    }
+
+    const m = s.match(REVERSE_FORWARD_SPEC);
+
+    if (!m) {
+      throw new Error(
+        'Invalid reverse forward "' +
+          s +
+          '", expecting bind_host:bind_port:target_host:target_port',
+      );
+    }
+
+    forwards.push({ bind: m[1] + ":" + m[2], target: m[3] + ":" + m[4] });
+  }
+
+  return forwards;
+}
+
+/**
+ * Parse the environment variables given in the format of "NAME=value",
+ * separated by comma
+ *
+ * @param {string} d Environment variables
+ *
+ * @returns {Array<object>} The names and the values of the variables
+ *
+ * @throws {Error} When any of the variables is malformed
+ *
+ */
+function parseEnvironment(d) {
+  const env = [];
+
+  for (const spec of (d || "").split(",")) {
+    const s = spec.trim();
+
+    if (s.length <= 0) {
+      continue;
+    }
+
+    const m = s.match(ENVIRONMENT_SPEC);
+
+    if (!m) {
+      throw new Error(
+        'Invalid environment variable "' + s + '", expecting NAME=value',
+      );
+    }
+
+    env.push({ name: m[1], value: m[2] });
+  }
+
+  if (env.length > MAX_ENVIRONMENT) {
+    throw new Error(
+      "Must not set more than " + MAX_ENVIRONMENT + " environment variables",
+    );
+  }
+
+  return env;
+}
+
+/**
+ * Returns the environment variables in the format of parseEnvironment
+ *
+ * @param {object} env Names and values of the variables
+ *
+ * @returns {string}
+ *
+ */
+function formatEnvironment(env) {
+  return Object.keys(env)
+    .sort()
+    .map((name) => name + "=" + env[name])
+    .join(", ");
+}
+
+/**
+ * Build the environment variables of the SSHRequest
+ *
+ * @param {Array<object>} env Names and values of the variables
+ *
+ * @returns {Uint8Array}
+ *
+ */
+function buildEnvironment(env) {
+  const parts = [new Uint8Array([env.length])];
+
+  for (const e of env) {
+    parts.push(new strings.String(common.strToUint8Array(e.name)).buffer());
+    parts.push(new strings.String(common.strToUint8Array(e.value)).buffer());
+  }
+
+  let buf = new Uint8Array(parts.reduce((n, p) => n + p.length, 0)),
+    offset = 0;
+
+  for (const p of parts) {
+    buf.set(p, offset);
+    offset += p.length;
+  }
+
+  return buf;
+}
+
+/**
+ * Parse the local forwards given in the format of
+ * "local_port:target_host:target_port", separated by comma
+ *
+ * @param {string} d Local forwards
+ *
+ * @returns {Array<object>} The local listen address and the target host and
+ *                          port of the forwards
+ *
+ * @throws {Error} When any of the forwards is malformed
+ *
+ */
+function parseLocalForwards(d) {
+  const forwards = [];
+
+  for (const spec of (d || "").split(",")) {
+    const s = spec.trim();
+
+    if (s.length <= 0) {
+      continue;
+    }
+
+    const m = s.match(LOCAL_FORWARD_SPEC);
+
+    if (!m || parseInt(m[1], 10) > 0xffff || parseInt(m[3], 10) > 0xffff) {
+      throw new Error(
+        'Invalid local forward "' +
+          s +
+          '", expecting local_port:target_host:target_port',
+      );
+    }
+
+    forwards.push({
+      listen: "127.0.0.1:" + m[1],
+      host: m[2].replace(/^\[(.*)\]$/, "$1"),
+      port: parseInt(m[3], 10),
+    });
+  }
+
+  return forwards;
+}
+
 class SSH {
   /**
    * constructor
@@ -74,6 +333,8 @@ class SSH {
     this.sender = sd;
     this.config = config;
     this.connected = false;
+    this.framing = signals.FRAMING_BINARY;
+    this.input = new inputSequence.InputSequence();
     this.events = new event.Events(
       [
         "initialization.failed",
@@ -83,8 +344,28 @@ class SSH {
         "connect.succeed",
         "connect.fingerprint",
         "connect.credential",
+        "connect.prompt_countdown",
+        "connect.auth_attempt",
+        "connect.key_passphrase",
+        "connect.host_key_changed",
+        "connect.timing",
+        "connect.failure",
+        "connect.transport_info",
+        "connect.banner",
+        "connect.weak_transport",
+        "connect.forwards",
+        "connect.push_approval",
+        "connect.step_up",
+        "reverse_forward",
+        "dynamic",
+        "local_forward",
+        "file_transfer",
         "@stdout",
         "@stderr",
+        "@session_ended",
+        "@reconnect",
+        "@handover",
+        "@sharing",
         "close",
         "@completed",
       ],
@@ -92,6 +373,20 @@ class SSH {
     );
   }
 
+  /**
+   * Returns whether the Private Key is sent along with the initial request,
+   * which is checked by the server before dialing
+   *
+   * @returns {boolean}
+   *
+   */
+  sendsPrivateKey() {
+    return (
+      this.config.auth === AUTHMETHOD_PRIVATE_KEY &&
+      this.config.credential.length > 0
+    );
+  }
+
   /**
    * Send intial request
    *
@@ -107,13 +402,71 @@ class SSH {
         this.config.host.port,
       ),
       addrBuf = addr.buffer(),
-      authMethod = new Uint8Array([this.config.auth]);
-
-    let data = new Uint8Array(userBuf.length + addrBuf.length + 1);
+      authMethod = new Uint8Array([this.config.auth]),
+      options = new Uint8Array([
+        (this.config.debugTransport ? OPTION_DEBUG_TRANSPORT : 0x00) |
+          (this.config.command.length > 0 ? OPTION_EXEC : 0x00) |
+          (this.config.environment.length > 0 ? OPTION_ENVIRONMENT : 0x00) |
+          (this.sendsPrivateKey() ? OPTION_PRIVATE_KEY : 0x00) |
+          (this.config.resume.length > 0 ? OPTION_RESUME : 0x00) |
+          OPTION_TERMINAL |
+          OPTION_SEQUENCED_INPUT |
+          OPTION_PROTOBUF,
+      ]),
+      commandBuf =
+        this.config.command.length > 0
+          ? new strings.String(this.config.command).buffer()
+          : new Uint8Array(0),
+      termSize = terminal.size(),
+      termTypeBuf = new strings.String(
+        common.strToUint8Array(terminal.type()),
+      ).buffer(),
+      termBuf = new Uint8Array(termTypeBuf.length + 4),
+      termSizeView = new DataView(termBuf.buffer);
+
+    termBuf.set(termTypeBuf, 0);
+    termSizeView.setUint16(termTypeBuf.length, termSize.rows);
+    termSizeView.setUint16(termTypeBuf.length + 2, termSize.cols);
+
+    const envBuf =
+      this.config.environment.length > 0
+        ? buildEnvironment(this.config.environment)
+        : new Uint8Array(0);
+    const keyBuf = this.sendsPrivateKey()
+      ? new strings.String(
+          common.strToUint8Array(this.config.credential),
+        ).buffer()
+      : new Uint8Array(0);
+    const resumeBuf =
+      this.config.resume.length > 0
+        ? new strings.String(
+            common.strToUint8Array(this.config.resume),
+          ).buffer()
+        : new Uint8Array(0);
+
+    let data = new Uint8Array(
+      userBuf.length +
+        addrBuf.length +
+        2 +
+        commandBuf.length +
+        termBuf.length +
+        envBuf.length +
+        keyBuf.length +
+        resumeBuf.length,
+    );
 
     data.set(userBuf, 0);
     data.set(addrBuf, userBuf.length);
     data.set(authMethod, userBuf.length + addrBuf.length);
+    data.set(options, userBuf.length + addrBuf.length + 1);
+    data.set(commandBuf, userBuf.length + addrBuf.length + 2);
+    data.set(termBuf, userBuf.length + addrBuf.length + 2 + commandBuf.length);
+    data.set(
+      envBuf,
+      data.length - resumeBuf.length - keyBuf.length - envBuf.length,
+    );
+    data.set(keyBuf, data.length - resumeBuf.length - keyBuf.length);
+    data.set(resumeBuf, data.length - resumeBuf.length);
 
     initialSender.send(data);
   }
@@ -156,7 +509,7 @@ class SSH {
 
       case SERVER_CONNECT_REQUEST_FINGERPRINT:
         if (!this.connected) {
-          return this.events.fire("connect.fingerprint", rd, this.sender);
+          return this.tickFingerprint(rd);
         }
         break;
 
@@ -180,6 +533,9 @@ class SSH {
         }
         break;
 
+      case SERVER_EXTENDED:
+        return this.tickExtended(rd);
+
       case SERVER_REMOTE_STDERR:
         if (this.connected) {
           return this.events.fire("stderr", rd);
@@ -196,6 +552,303 @@ class SSH {
     throw new Exception("Unknown stream header marker");
   }
 
+  /**
+   * Tick the fingerprint verification request
+   *
+   * @param {reader.Limited} rd Data reader
+   *
+   * @returns {any} The result of the ticking
+   *
+   */
+  async tickFingerprint(rd) {
+    const d = await reader.readCompletely(rd);
+
+    return this.events.fire(
+      "connect.fingerprint",
+      this.framing === signals.FRAMING_PROTOBUF
+        ? signals.fingerprint(d)
+        : new TextDecoder("utf-8").decode(d),
+      this.sender,
+    );
+  }
+
+  /**
+   * Read the payload which is a JSON, or the protobuf message decoded by the
+   * `decode` once the FRAMING_PROTOBUF is negotiated
+   *
+   * @param {reader.Limited} rd Data reader
+   * @param {function} decode Decoder of the message, see signals.js
+   *
+   * @returns {any} The payload
+   *
+   */
+  async readMessage(rd, decode) {
+    const d = await reader.readCompletely(rd);
+
+    if (this.framing === signals.FRAMING_PROTOBUF) {
+      return decode(d);
+    }
+
+    return JSON.parse(new TextDecoder("utf-8").decode(d));
+  }
+
+  /**
+   * Tick the extended signal
+   *
+   * @param {reader.Limited} rd Data reader
+   *
+   * @returns {any} The result of the ticking
+   *
+   */
+  async tickExtended(rd) {
+    const sig = await reader.readOne(rd);
+
+    switch (sig[0]) {
+      case SERVER_EXTENDED_FRAMING:
+        if (!this.connected) {
+          const d = await reader.readOne(rd);
+
+          this.framing = d[0];
+        }
+        break;
+
+      case SERVER_EXTENDED_PROMPT_COUNTDOWN:
+        if (!this.connected) {
+          if (this.framing === signals.FRAMING_PROTOBUF) {
+            return this.events.fire(
+              "connect.prompt_countdown",
+              signals.promptCountdown(await reader.readCompletely(rd)),
+            );
+          }
+
+          const d = await reader.readN(rd, 2);
+
+          return this.events.fire(
+            "connect.prompt_countdown",
+            (d[0] << 8) | d[1],
+          );
+        }
+        break;
+
+      case SERVER_EXTENDED_AUTH_ATTEMPT:
+      case SERVER_EXTENDED_KEY_PASSPHRASE:
+        if (!this.connected) {
+          const e =
+            sig[0] === SERVER_EXTENDED_AUTH_ATTEMPT
+              ? "connect.auth_attempt"
+              : "connect.key_passphrase";
+
+          if (this.framing === signals.FRAMING_PROTOBUF) {
+            const a = signals.attempt(await reader.readCompletely(rd));
+
+            return this.events.fire(e, a.attempt, a.max);
+          }
+
+          const d = await reader.readN(rd, 2);
+
+          return this.events.fire(e, d[0], d[1]);
+        }
+        break;
+
+      case SERVER_EXTENDED_HOST_KEY_CHANGED:
+        if (!this.connected) {
+          const d = await reader.readCompletely(rd);
+
+          return this.events.fire(
+            "connect.host_key_changed",
+            this.framing === signals.FRAMING_PROTOBUF
+              ? signals.fingerprint(d)
+              : new TextDecoder("utf-8").decode(d),
+          );
+        }
+        break;
+
+      case SERVER_EXTENDED_CONNECT_FAILURE:
+        if (!this.connected) {
+          const d = await reader.readOne(rd);
+
+          return this.events.fire("connect.failure", d[0]);
+        }
+        break;
+
+      case SERVER_EXTENDED_CONNECT_TIMING:
+        if (!this.connected) {
+          return this.events.fire(
+            "connect.timing",
+            await this.readMessage(rd, signals.connectTiming),
+          );
+        }
+        break;
+
+      case SERVER_EXTENDED_TRANSPORT_INFO:
+        if (!this.connected) {
+          return this.events.fire(
+            "connect.transport_info",
+            await this.readMessage(rd, signals.transportInfo),
+          );
+        }
+        break;
+
+      case SERVER_EXTENDED_BANNER:
+        if (!this.connected) {
+          const d = new TextDecoder("utf-8").decode(
+            await reader.readCompletely(rd),
+          );
+
+          return this.events.fire("connect.banner", d);
+        }
+        break;
+
+      case SERVER_EXTENDED_WEAK_TRANSPORT:
+        if (!this.connected) {
+          return this.events.fire(
+            "connect.weak_transport",
+            await this.readMessage(rd, signals.weakTransport),
+          );
+        }
+        break;
+
+      case SERVER_EXTENDED_FORWARDS:
+        if (!this.connected) {
+          return this.events.fire(
+            "connect.forwards",
+            await this.readMessage(rd, signals.forwards),
+          );
+        }
+        break;
+
+      case SERVER_EXTENDED_PUSH_APPROVAL:
+        if (!this.connected) {
+          return this.events.fire(
+            "connect.push_approval",
+            await this.readMessage(rd, signals.pushApproval),
+          );
+        }
+        break;
+
+      case SERVER_EXTENDED_STEP_UP:
+        if (!this.connected) {
+          const d = await reader.readOne(rd);
+
+          return this.events.fire("connect.step_up", d[0], this.sender);
+        }
+        break;
+
+      case SERVER_EXTENDED_REVERSE_FORWARD:
+        if (this.connected) {
+          return this.events.fire(
+            "reverse_forward",
+            await this.readMessage(rd, signals.reverseForwardResult),
+          );
+        }
+        break;
+
+      case SERVER_EXTENDED_DYNAMIC:
+        if (this.connected) {
+          const d = await reader.readCompletely(rd);
+
+          return this.events.fire(
+            "dynamic",
+            d[0],
+            (d[1] << 8) | d[2],
+            d.subarray(3),
+          );
+        }
+        break;
+
+      case SERVER_EXTENDED_LOCAL_FORWARD:
+        if (this.connected) {
+          const d = await reader.readCompletely(rd);
+
+          return this.events.fire(
+            "local_forward",
+            d[0],
+            (d[1] << 8) | d[2],
+            d.subarray(3),
+          );
+        }
+        break;
+
+      case SERVER_EXTENDED_FILE_TRANSFER:
+        if (this.connected) {
+          const d = await reader.readCompletely(rd);
+
+          return this.events.fire(
+            "file_transfer",
+            d[0],
+            (d[1] << 8) | d[2],
+            d.subarray(3),
+          );
+        }
+        break;
+
+      case SERVER_EXTENDED_SESSION_ENDED:
+        if (this.connected) {
+          const d = await reader.readCompletely(rd);
+
+          if (this.framing === signals.FRAMING_PROTOBUF) {
+            const s = signals.sessionEnded(d);
+
+            return this.events.fire("session_ended", s.status, s.signal);
+          }
+
+          // Empty when the remote didn't report the exit status
+          if (d.length < 4) {
+            return this.events.fire("session_ended", null, "");
+          }
+
+          return this.events.fire(
+            "session_ended",
+            new DataView(d.buffer, d.byteOffset, 4).getUint32(0),
+            new TextDecoder("utf-8").decode(d.subarray(4)),
+          );
+        }
+        break;
+
+      case SERVER_EXTENDED_RECONNECT:
+        if (this.connected) {
+          return this.events.fire(
+            "reconnect",
+            await this.readMessage(rd, signals.reconnectStatus),
+          );
+        }
+        break;
+
+      case SERVER_EXTENDED_HANDOVER:
+        if (this.connected) {
+          return this.events.fire(
+            "handover",
+            await this.readMessage(rd, signals.handoverStatus),
+          );
+        }
+        break;
+
+      // The resumed session continues the input numbering of the device
+      // which parked it
+      case SERVER_EXTENDED_INPUT_SEQUENCE:
+        if (this.connected) {
+          const d = await reader.readN(rd, inputSequence.SIZE);
+
+          this.input.continueFrom(
+            new DataView(d.buffer, d.byteOffset, d.length).getUint32(0),
+          );
+        }
+        break;
+
+      case SERVER_EXTENDED_SHARING:
+        if (this.connected) {
+          return this.events.fire(
+            "sharing",
+            await this.readMessage(rd, signals.sharingSessions),
+          );
+        }
+        break;
+    }
+
+    // Unknown extended signals are ignored so newer backends can keep
+    // working with this client
+  }
+
   /**
    * Send close signal to remote
    *
@@ -211,7 +864,13 @@ class SSH {
    *
    */
   async sendData(data) {
-    return this.sender.sendData(CLIENT_DATA_STDIN, data);
+    // Frames are queued all at once, so they're not interleaved with the ones
+    // of the other calls, which would be received out of order and dropped
+    return Promise.all(
+      this.input
+        .frames(data)
+        .map((f) => this.sender.send(CLIENT_DATA_STDIN, f)),
+    );
   }
 
   /**
@@ -219,17 +878,146 @@ class SSH {
    *
    * @param {number} rows
    * @param {number} cols
+   * @param {number} width Width of the terminal in pixels, 0 when unknown
+   * @param {number} height Height of the terminal in pixels, 0 when unknown
    *
    */
-  async sendResize(rows, cols) {
-    let data = new DataView(new ArrayBuffer(4));
+  async sendResize(rows, cols, width, height) {
+    if (this.framing === signals.FRAMING_PROTOBUF) {
+      return this.sender.send(
+        CLIENT_DATA_RESIZE,
+        signals.resize(rows, cols, width, height),
+      );
+    }
+
+    let data = new DataView(new ArrayBuffer(8));
 
     data.setUint16(0, rows);
     data.setUint16(2, cols);
+    data.setUint16(4, Math.min(width || 0, 0xffff));
+    data.setUint16(6, Math.min(height || 0, 0xffff));
 
     return this.sender.send(CLIENT_DATA_RESIZE, new Uint8Array(data.buffer));
   }
 
+  /**
+   * Send a frame of dynamic forwarding
+   *
+   * @param {number} frame Frame type
+   * @param {number} id Channel ID
+   * @param {Uint8Array} payload Payload
+   *
+   */
+  async sendDynamic(frame, id, payload) {
+    const d = new Uint8Array(3 + payload.length);
+
+    d[0] = frame;
+    d[1] = (id >> 8) & 0xff;
+    d[2] = id & 0xff;
+    d.set(payload, 3);
+
+    return this.sender.send(CLIENT_DYNAMIC, d);
+  }
+
+  /**
+   * Send a frame of local forwarding. All client signals are taken, so it's
+   * sent as a frame of dynamic forwarding with the frame type flagged
+   *
+   * @param {number} frame Frame type
+   * @param {number} id Channel ID
+   * @param {Uint8Array} payload Payload
+   *
+   */
+  async sendLocalForward(frame, id, payload) {
+    return this.sendDynamic(
+      frame | sshDynamic.FRAME_LOCAL_FORWARD,
+      id,
+      payload,
+    );
+  }
+
+  /**
+   * Send a POSIX signal to the remote session. All client signals are taken,
+   * so it's sent as a frame of dynamic forwarding with the frame type flagged
+   *
+   * @param {string} name Name of the signal without the "SIG" prefix
+   *
+   */
+  async sendSignal(name) {
+    return this.sendDynamic(
+      sshDynamic.FRAME_SIGNAL,
+      0,
+      common.strToUint8Array(name),
+    );
+  }
+
+  /**
+   * Interrupt the program that is flooding the terminal. All client signals
+   * are taken, so it's sent as a frame of dynamic forwarding with the frame
+   * type flagged
+   *
+   * @param {string} name Name of the interrupt, see common.interrupts
+   *
+   */
+  async sendInterrupt(name) {
+    return this.sendDynamic(
+      sshDynamic.FRAME_INTERRUPT,
+      0,
+      common.strToUint8Array(name),
+    );
+  }
+
+  /**
+   * Ask the server to park the session, so it can be resumed on another
+   * device. All client signals are taken, so it's sent as a frame of dynamic
+   * forwarding with the frame type flagged
+   *
+   */
+  async sendHandover() {
+    return this.sendDynamic(sshDynamic.FRAME_HANDOVER, 0, new Uint8Array(0));
+  }
+
+  /**
+   * Send a frame of file transfer
+   *
+   * @param {number} frame Frame type
+   * @param {number} id Request ID
+   * @param {Uint8Array} payload Payload
+   *
+   */
+  async sendFileTransfer(frame, id, payload) {
+    const d = new Uint8Array(3 + payload.length);
+
+    d[0] = frame;
+    d[1] = (id >> 8) & 0xff;
+    d[2] = id & 0xff;
+    d.set(payload, 3);
+
+    return this.sender.send(CLIENT_FILE_TRANSFER, d);
+  }
+
+  /**
+   * Request the remote to forward connections on the bind address to the
+   * target
+   *
+   * @param {string} bind Bind address on the remote, in "host:port"
+   * @param {string} target Target address, in "host:port"
+   *
+   */
+  async sendReverseForward(bind, target) {
+    if (this.framing === signals.FRAMING_PROTOBUF) {
+      return this.sender.send(
+        CLIENT_REVERSE_FORWARD,
+        signals.reverseForwardRequest(bind, target),
+      );
+    }
+
+    return this.sender.send(
+      CLIENT_REVERSE_FORWARD,
+      common.strToUint8Array(JSON.stringify({ bind: bind, target: target })),
+    );
+  }
+
   /**
    * Close the command
    *
@@ -330,6 +1118,129 @@ const initialFieldDef = {
       throw new Error('The character encoding "' + d + '" is not supported');
     },
   },
+  "Reverse Forwards": {
+    name: "Reverse Forwards",
+    description:
+      "Optional. Comma separated list of bind_host:bind_port:" +
+      "target_host:target_port. Connections to the bind address on the " +
+      "server will be forwarded to the target through the backend, if " +
+      "allowed by the backend",
+    type: "text",
+    value: "",
+    example: "127.0.0.1:8080:localhost:3000",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      const forwards = parseReverseForwards(d);
+
+      if (forwards.length <= 0) {
+        return "";
+      }
+
+      return forwards.length + " reverse forward(s) will be requested";
+    },
+  },
+  "Local Forwards": {
+    name: "Local Forwards",
+    description:
+      "Optional. Comma separated list of local_port:target_host:" +
+      "target_port. The SOCKS Agent listens on the local ports of the " +
+      "loopback address, and connections to them will be forwarded to the " +
+      "target through the server, if allowed by the backend",
+    type: "text",
+    value: "",
+    example: "8080:localhost:3000",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      const forwards = parseLocalForwards(d);
+
+      if (forwards.length <= 0) {
+        return "";
+      }
+
+      return (
+        forwards.length + " local forward(s) will be listened by the agent"
+      );
+    },
+  },
+  Command: {
+    name: "Command",
+    description:
+      "Optional. Run this command on the server instead of an interactive " +
+      "shell. The session ends once the command has exited",
+    type: "text",
+    value: "",
+    example: "uptime",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.length <= 0) {
+        return "";
+      }
+
+      if (common.strToUint8Array(d).length > MAX_COMMAND_LEN) {
+        throw new Error(
+          "Command must not longer than " + MAX_COMMAND_LEN + " bytes",
+        );
+      }
+
+      return "We'll run this command instead of a shell";
+    },
+  },
+  Environment: {
+    name: "Environment",
+    description:
+      "Optional. Comma separated list of NAME=value. The variables are set " +
+      "before the session starts, if accepted by the server (i.e. " +
+      "AcceptEnv of OpenSSH)",
+    type: "text",
+    value: "",
+    example: "LANG=en_US.UTF-8, LC_ALL=en_US.UTF-8",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      const env = parseEnvironment(d);
+
+      if (env.length <= 0) {
+        return "";
+      }
+
+      return env.length + " environment variable(s) will be sent";
+    },
+  },
+  "SOCKS Agent": {
+    name: "SOCKS Agent",
+    description:
+      "Optional. Address of the local agent of dynamic forwarding. Once " +
+      "connected, SOCKS5 clients of the agent can connect through the " +
+      "server, if allowed by the backend. The agent also listens for the " +
+      "Local Forwards",
+    type: "text",
+    value: "",
+    example: "ws://127.0.0.1:8183",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.length <= 0) {
+        return "";
+      }
+
+      agent.verifyURL(d);
+
+      return "We'll serve the SOCKS5 clients of this agent";
+    },
+  },
   Notice: {
     name: "Notice",
     description: "",
@@ -370,30 +1281,19 @@ const initialFieldDef = {
       return "We'll login with this password";
     },
   },
-  "Private Key": {
-    name: "Private Key",
-    description:
-      'Like the one inside <i style="color: #fff; font-style: normal;">' +
-      "~/.ssh/id_rsa</i>, can&apos;t be encrypted<br /><br />" +
-      'To decrypt the Private Key, use command: <i style="color: #fff;' +
-      ' font-style: normal;">ssh-keygen -f /path/to/private_key -p</i><br />' +
-      "<br />" +
-      "It is strongly recommended to use one Private Key per SSH server if " +
-      "the Private Key will be submitted to Sshwifty. To generate a new SSH " +
-      'key pair, use command <i style="color: #fff; font-style: normal;">' +
-      "ssh-keygen -o -f /path/to/my_server_key</i> and then deploy the " +
-      'generated <i style="color: #fff; font-style: normal;">' +
-      "/path/to/my_server_key.pub</i> file onto the target SSH server",
-    type: "textfile",
+  "Key Passphrase": {
+    name: "Key Passphrase",
+    description: "Passphrase of the encrypted Private Key",
+    type: "password",
     value: "",
-    example: "",
+    example: "----------",
     readonly: false,
     suggestions(input) {
       return [];
     },
     verify(d) {
       if (d.length <= 0) {
-        throw new Error("Private Key must be specified");
+        throw new Error("Passphrase must be specified");
       }
 
       if (d.length > MAX_PASSWORD_LEN) {
@@ -402,35 +1302,37 @@ const initialFieldDef = {
         );
       }
 
-      const lines = d.trim().split("\n");
-      let firstLineReaded = false;
-
-      for (let i in lines) {
-        if (!firstLineReaded) {
-          if (lines[i].indexOf("-") === 0) {
-            firstLineReaded = true;
-
-            if (lines[i].indexOf("RSA") <= 0) {
-              break;
-            }
-          }
-
-          continue;
-        }
-
-        if (lines[i].indexOf("Proc-Type: 4,ENCRYPTED") === 0) {
-          throw new Error("Cannot use encrypted Private Key file");
-        }
-
-        if (lines[i].indexOf(":") > 0) {
-          continue;
-        }
-
-        if (lines[i].indexOf("MII") < 0) {
-          throw new Error("Cannot use encrypted Private Key file");
-        }
+      return "We'll decrypt the Private Key with this passphrase";
+    },
+  },
+  "Private Key": {
+    name: "Private Key",
+    description:
+      'Like the one inside <i style="color: #fff; font-style: normal;">' +
+      "~/.ssh/id_rsa</i>. If the Private Key is encrypted, you&apos;ll be " +
+      "asked for it&apos;s passphrase during login<br /><br />" +
+      "It is strongly recommended to use one Private Key per SSH server if " +
+      "the Private Key will be submitted to Sshwifty. To generate a new SSH " +
+      'key pair, use command <i style="color: #fff; font-style: normal;">' +
+      "ssh-keygen -o -f /path/to/my_server_key</i> and then deploy the " +
+      'generated <i style="color: #fff; font-style: normal;">' +
+      "/path/to/my_server_key.pub</i> file onto the target SSH server",
+    type: "textfile",
+    value: "",
+    example: "",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.length <= 0) {
+        throw new Error("Private Key must be specified");
+      }
 
-        break;
+      if (d.length > MAX_PASSWORD_LEN) {
+        throw new Error(
+          "It's too long, make it shorter than " + MAX_PASSWORD_LEN + " bytes",
+        );
       }
 
       return "We'll login with this Private Key";
@@ -461,6 +1363,26 @@ const initialFieldDef = {
       }
     },
   },
+  "Parked Session": {
+    name: "Parked Session",
+    description:
+      "Sessions you have parked on other devices. The selected one will be " +
+      "resumed on this device",
+    type: "select",
+    value: "",
+    example: "",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.length <= 0) {
+        throw new Error("Session must be selected");
+      }
+
+      return "";
+    },
+  },
   Fingerprint: {
     name: "Fingerprint",
     description:
@@ -545,8 +1467,14 @@ class Wizard {
     this.history = history;
   }
 
-  run() {
-    this.step.resolve(this.stepInitialPrompt());
+  async run() {
+    const parked = await handover.sessions("SSH");
+
+    this.step.resolve(
+      parked.length > 0
+        ? this.stepResumePrompt(parked)
+        : this.stepInitialPrompt(),
+    );
   }
 
   started() {
@@ -617,11 +1545,30 @@ class Wizard {
       credential: sessionData.credential,
       host: address.parseHostPort(configInput.host, DEFAULT_PORT),
       fingerprint: configInput.fingerprint,
+      debugTransport: configInput.debugTransport,
+      command: common.strToUint8Array(configInput.command || ""),
+      environment: configInput.environment || [],
+      resume: configInput.resume || "",
     };
 
     // Copy the keptSessions from the record so it will not be overwritten here
     let keptSessions = self.keptSessions ? [].concat(...self.keptSessions) : [];
 
+    self.promptDeadline = null;
+    self.authAttempt = null;
+    self.keyPassphrase = null;
+    self.hostKeyChanged = null;
+    self.connectTiming = null;
+    self.connectFailure = null;
+    self.transportInfo = null;
+    self.banner = "";
+    self.transportWarnings = [];
+    self.forwards = [];
+    self.reverseForwards = [];
+    self.dynamic = null;
+    self.localForwards = null;
+    self.files = null;
+
     return new SSH(sender, config, {
       "initialization.failed"(hd) {
         switch (hd.data()) {
@@ -645,6 +1592,49 @@ class Wizard {
               ),
             );
             return;
+
+          case SERVER_REQUEST_ERROR_BAD_COMMAND:
+            self.step.resolve(
+              self.stepErrorDone("Request failed", "Invalid command"),
+            );
+            return;
+
+          case SERVER_REQUEST_ERROR_BAD_ENVIRONMENT:
+            self.step.resolve(
+              self.stepErrorDone(
+                "Request failed",
+                "Invalid environment variables",
+              ),
+            );
+            return;
+
+          case SERVER_REQUEST_ERROR_BAD_PRIVATE_KEY:
+            self.step.resolve(
+              self.stepErrorDone(
+                "Request failed",
+                "The Private Key is malformed or of an unsupported type",
+              ),
+            );
+            return;
+
+          case SERVER_REQUEST_ERROR_BAD_HANDOVER:
+            self.step.resolve(
+              self.stepErrorDone(
+                "Unable to resume",
+                "The session is not waiting to be resumed, it may have " +
+                  "expired or been resumed already",
+              ),
+            );
+            return;
+
+          case header.INITIAL_ERROR_SHUTTING_DOWN:
+            self.step.resolve(
+              self.stepErrorDone(
+                "Request failed",
+                "The server is shutting down, please try again later",
+              ),
+            );
+            return;
         }
 
         self.step.resolve(
@@ -658,7 +1648,106 @@ class Wizard {
         let d = new TextDecoder("utf-8").decode(
           await reader.readCompletely(rd),
         );
-        self.step.resolve(self.stepErrorDone("Connection failed", d));
+
+        if (self.hostKeyChanged !== null) {
+          self.step.resolve(
+            self.stepErrorDone(
+              "Host key has changed",
+              "The remote presented the host key " +
+                self.hostKeyChanged +
+                ", which is different from the one recorded or expected " +
+                "by the server. This could mean someone is intercepting the " +
+                "connection, or the key of the remote has been replaced. " +
+                "The connection has been refused, contact the administrator " +
+                "if the change is expected",
+            ),
+          );
+          return;
+        }
+
+        self.step.resolve(
+          self.stepErrorDone(
+            common.dialFailureTitle(self.connectFailure),
+            self.connectTiming === null
+              ? d
+              : d + ". " + describeConnectTiming(self.connectTiming),
+          ),
+        );
+      },
+      "connect.host_key_changed"(fingerprint) {
+        self.hostKeyChanged = fingerprint;
+      },
+      "connect.timing"(timing) {
+        self.connectTiming = timing;
+      },
+      "connect.failure"(code) {
+        self.connectFailure = code;
+      },
+      "connect.transport_info"(info) {
+        self.transportInfo = info;
+      },
+      "connect.banner"(banner) {
+        self.banner = banner;
+
+        self.step.resolve(command.wait("Server banner", banner));
+      },
+      "connect.weak_transport"(warnings) {
+        self.transportWarnings = warnings;
+      },
+      "connect.forwards"(forwards) {
+        self.forwards = forwards;
+      },
+      "connect.push_approval"(approval) {
+        self.step.resolve(
+          command.wait(
+            "Waiting for approval",
+            "A push request has been sent to you via " +
+              approval.provider +
+              ". Approve it within " +
+              approval.timeout +
+              " seconds to continue",
+          ),
+        );
+      },
+      "connect.step_up"(method, sd) {
+        self.step.resolve(
+          stepUp.prompt(
+            method,
+            (answer) => {
+              sd.send(CLIENT_CONNECT_RESPOND_STEP_UP, answer);
+
+              self.step.resolve(self.stepContinueWaitForEstablishWait());
+            },
+            () => {
+              sd.close();
+
+              self.step.resolve(
+                command.wait(
+                  "Cancelling login",
+                  "Cancelling login request, please wait",
+                ),
+              );
+            },
+          ),
+        );
+      },
+      "reverse_forward"(result) {
+        self.reverseForwards.push(result);
+      },
+      dynamic(frame, id, payload) {
+        if (self.dynamic) {
+          self.dynamic.receive(frame, id, payload);
+        }
+      },
+      local_forward(frame, id, payload) {
+        if (self.localForwards) {
+          self.localForwards.receive(frame, id, payload);
+        }
+      },
+      file_transfer(frame, id, payload) {
+        if (self.files) {
+          self.files.receive(frame, id, payload);
+        }
       },
       async "hook.before_connected"(rd) {
         const d = new TextDecoder("utf-8").decode(
@@ -671,6 +1760,22 @@ class Wizard {
       "connect.succeed"(rd, commandHandler) {
         self.connectionSucceed = true;
 
+        for (const f of configInput.reverseForwards || []) {
+          commandHandler.sendReverseForward(f.bind, f.target);
+        }
+
+        self.dynamic = new sshDynamic.Channels((frame, id, payload) => {
+          return commandHandler.sendDynamic(frame, id, payload);
+        });
+
+        self.localForwards = new sshDynamic.Channels((frame, id, payload) => {
+          return commandHandler.sendLocalForward(frame, id, payload);
+        });
+
+        self.files = new sshFiles.Transfers((frame, id, payload) => {
+          return commandHandler.sendFileTransfer(frame, id, payload);
+        });
+
         self.step.resolve(
           self.stepSuccessfulDone(
             new command.Result(
@@ -679,14 +1784,33 @@ class Wizard {
               self.controls.build({
                 charset: configInput.charset,
                 tabColor: configInput.tabColor,
+                transportInfo: self.transportInfo,
+                banner: self.banner,
+                transportWarnings: self.transportWarnings,
+                forwards: self.forwards,
+                reverseForwards: self.reverseForwards,
+                dynamic: self.dynamic,
+                localForwards: self.localForwards,
+                files: self.files,
+                socksAgent: configInput.socksAgent,
+                localForwardList: configInput.localForwards,
                 send(data) {
                   return commandHandler.sendData(data);
                 },
                 close() {
                   return commandHandler.sendClose();
                 },
-                resize(rows, cols) {
-                  return commandHandler.sendResize(rows, cols);
+                resize(rows, cols, width, height) {
+                  return commandHandler.sendResize(rows, cols, width, height);
+                },
+                signal(name) {
+                  return commandHandler.sendSignal(name);
+                },
+                interrupt(name) {
+                  return commandHandler.sendInterrupt(name);
+                },
+                handover() {
+                  return commandHandler.sendHandover();
                 },
                 events: commandHandler.events,
               }),
@@ -695,20 +1819,24 @@ class Wizard {
           ),
         );
 
-        self.history.save(
-          self.info.name() + ":" + configInput.user + "@" + configInput.host,
-          configInput.user + "@" + configInput.host,
-          new Date(),
-          self.info,
-          configInput,
-          sessionData,
-          keptSessions,
-        );
+        // Connections of the "no trace" presets are not kept in the history,
+        // neither are the resumed sessions, which carry no credential
+        if (!(self.preset && self.preset.noTrace()) && !configInput.resume) {
+          self.history.save(
+            self.info.name() + ":" + configInput.user + "@" + configInput.host,
+            configInput.user + "@" + configInput.host,
+            new Date(),
+            self.info,
+            configInput,
+            sessionData,
+            keptSessions,
+          );
+        }
       },
-      async "connect.fingerprint"(rd, sd) {
+      async "connect.fingerprint"(fingerprint, sd) {
         self.step.resolve(
           await self.stepFingerprintPrompt(
-            rd,
+            fingerprint,
             sd,
             (v) => {
               if (!configInput.fingerprint) {
@@ -739,8 +1867,21 @@ class Wizard {
           }),
         );
       },
+      "connect.prompt_countdown"(seconds) {
+        self.promptDeadline = Date.now() + seconds * 1000;
+      },
+      "connect.auth_attempt"(attempt, max) {
+        self.authAttempt = { attempt: attempt, max: max };
+      },
+      "connect.key_passphrase"(attempt, max) {
+        self.keyPassphrase = { attempt: attempt, max: max };
+      },
       "@stdout"(rd) {},
       "@stderr"(rd) {},
+      "@session_ended"(status, signal) {},
+      "@reconnect"(status) {},
+      "@handover"(status) {},
+      "@sharing"(sessions) {},
       close() {},
       "@completed"() {
         self.step.resolve(
@@ -753,6 +1894,136 @@ class Wizard {
     });
   }
 
+  /**
+   * Start the command with the data of the initial prompt
+   *
+   * @param {object} r Data of the initial prompt
+   *
+   */
+  stepStart(r) {
+    const self = this;
+
+    self.hasStarted = true;
+
+    self.streams.request(COMMAND_ID, (sd) => {
+      return self.buildCommand(
+        sd,
+        {
+          user: r.user,
+          authentication: r.authentication,
+          host: r.host,
+          charset: r.encoding,
+          tabColor: self.preset ? self.preset.tabColor() : "",
+          reverseForwards: parseReverseForwards(r["reverse forwards"]),
+          localForwards: parseLocalForwards(r["local forwards"]),
+          socksAgent: r["socks agent"],
+          command: r.command,
+          environment: parseEnvironment(r.environment),
+          fingerprint: self.preset
+            ? self.preset.metaDefault("Fingerprint", "")
+            : "",
+          debugTransport: self.preset
+            ? self.preset.metaDefault("Debug Transport", "") === "Yes"
+            : false,
+        },
+        self.session,
+      );
+    });
+
+    return self.stepWaitForAcceptWait();
+  }
+
+  /**
+   * Resume the session parked on another device
+   *
+   * @param {object} parked The parked session, see handover.js
+   *
+   */
+  stepResume(parked) {
+    const self = this;
+
+    self.hasStarted = true;
+
+    self.streams.request(COMMAND_ID, (sd) => {
+      return self.buildCommand(
+        sd,
+        {
+          user: parked.login,
+          authentication: "None",
+          host: parked.remote,
+          charset: "utf-8",
+          tabColor: self.preset ? self.preset.tabColor() : "",
+          fingerprint: "",
+          debugTransport: false,
+          resume: parked.id,
+        },
+        { credential: "" },
+      );
+    });
+
+    return self.stepWaitForAcceptWait();
+  }
+
+  stepResumePrompt(parked) {
+    const self = this;
+
+    const options = parked.map((p) => {
+      return (
+        p.login +
+        "@" +
+        p.remote +
+        " (until " +
+        new Date(p.expires).toLocaleTimeString() +
+        ")"
+      );
+    });
+
+    return command.prompt(
+      "Resume on this device",
+      "You have parked " +
+        parked.length +
+        " session(s) on other devices. Select one to continue it here, or " +
+        "start a new session",
+      "Continue",
+      (r) => {
+        const i = options.indexOf(r["parked session"]);
+
+        if (i < 0) {
+          self.step.resolve(self.stepInitialPrompt());
+
+          return;
+        }
+
+        self.step.resolve(self.stepResume(parked[i]));
+      },
+      () => {},
+      command.fields(initialFieldDef, [
+        {
+          name: "Parked Session",
+          value: options[0],
+          example: options.concat([NewSessionOption]).join(","),
+        },
+      ]),
+    );
+  }
+
+  stepPrivateKeyPrompt(r) {
+    const self = this;
+
+    return command.prompt(
+      "Provide Private Key",
+      "Please input the Private Key to login with",
+      "Connect",
+      (k) => {
+        self.session.credential = k["private key"];
+
+        self.step.resolve(self.stepStart(r));
+      },
+      () => {},
+      command.fields(initialFieldDef, [{ name: "Private Key" }]),
+    );
+  }
+
   stepInitialPrompt() {
     let self = this;
 
@@ -761,26 +2032,18 @@ class Wizard {
       "Secure Shell Host",
       "Connect",
       (r) => {
-        self.hasStarted = true;
+        // Pasted Private Keys are checked before dialing, so the user
+        // doesn't have to wait for the connection to learn it's malformed
+        if (
+          getAuthMethodFromStr(r.authentication) === AUTHMETHOD_PRIVATE_KEY &&
+          !(self.preset && self.preset.metaDefault("Private Key", ""))
+        ) {
+          self.step.resolve(self.stepPrivateKeyPrompt(r));
 
-        self.streams.request(COMMAND_ID, (sd) => {
-          return self.buildCommand(
-            sd,
-            {
-              user: r.user,
-              authentication: r.authentication,
-              host: r.host,
-              charset: r.encoding,
-              tabColor: self.preset ? self.preset.tabColor() : "",
-              fingerprint: self.preset
-                ? self.preset.metaDefault("Fingerprint", "")
-                : "",
-            },
-            self.session,
-          );
-        });
+          return;
+        }
 
-        self.step.resolve(self.stepWaitForAcceptWait());
+        self.step.resolve(self.stepStart(r));
       },
       () => {},
       command.fieldsWithPreset(
@@ -814,8 +2077,24 @@ class Wizard {
             },
           },
           { name: "User" },
-          { name: "Authentication" },
+          {
+            name: "Authentication",
+            value: userSettings.get("auth_method", ""),
+          },
           { name: "Encoding" },
+          { name: "Reverse Forwards" },
+          { name: "Local Forwards" },
+          self.preset && self.preset.command()
+            ? { name: "Command", value: self.preset.command(), readonly: true }
+            : { name: "Command" },
+          self.preset && Object.keys(self.preset.environment()).length > 0
+            ? {
+                name: "Environment",
+                value: formatEnvironment(self.preset.environment()),
+                readonly: true,
+              }
+            : { name: "Environment" },
+          { name: "SOCKS Agent" },
           { name: "Notice" },
         ],
         self.preset,
@@ -824,13 +2103,10 @@ class Wizard {
     );
   }
 
-  async stepFingerprintPrompt(rd, sd, verify, newFingerprint) {
+  async stepFingerprintPrompt(fingerprintData, sd, verify, newFingerprint) {
     const self = this;
 
-    let fingerprintData = new TextDecoder("utf-8").decode(
-        await reader.readCompletely(rd),
-      ),
-      fingerprintChanged = false;
+    let fingerprintChanged = false;
 
     switch (verify(fingerprintData)) {
       case FingerprintPromptVerifyPassed:
@@ -846,9 +2122,11 @@ class Wizard {
       !fingerprintChanged
         ? "Do you recognize this server?"
         : "Danger! Server fingerprint has changed!",
-      !fingerprintChanged
-        ? "Verify server fingerprint displayed below"
-        : "It's very unusual. Please verify the new server fingerprint below",
+      self.promptMessage(
+        !fingerprintChanged
+          ? "Verify server fingerprint displayed below"
+          : "It's very unusual. Please verify the new server fingerprint below",
+      ),
       !fingerprintChanged ? "Yes, I do" : "I'm aware of the change",
       (r) => {
         newFingerprint(fingerprintData);
@@ -873,6 +2151,32 @@ class Wizard {
     );
   }
 
+  /**
+   * Append the remaining time of the current prompt to the message
+   *
+   * @param {string} msg Prompt message
+   *
+   * @returns {string} Prompt message with the banner of the server and the
+   *                   remaining time (if any)
+   *
+   */
+  promptMessage(msg) {
+    if (this.banner) {
+      msg = this.banner.trim() + "\n\n" + msg;
+    }
+
+    if (this.promptDeadline === null) {
+      return msg;
+    }
+
+    const remain = Math.max(
+      Math.round((this.promptDeadline - Date.now()) / 1000),
+      0,
+    );
+
+    return msg + ". Please respond within " + remain + " seconds";
+  }
+
   stepHookOutputPrompt(title, msg) {
     return command.wait(
       title,
@@ -887,7 +2191,21 @@ class Wizard {
   async stepCredentialPrompt(rd, sd, config, newCredential) {
     const self = this;
 
-    let fields = [];
+    if (self.keyPassphrase !== null) {
+      const keyPassphrase = self.keyPassphrase;
+
+      self.keyPassphrase = null;
+
+      return self.stepKeyPassphrasePrompt(sd, keyPassphrase);
+    }
+
+    let fields = [],
+      retrying = self.authAttempt !== null && self.authAttempt.attempt > 1;
+
+    // The previous credential was rejected, don't send it again
+    if (retrying) {
+      config.credential = "";
+    }
 
     if (config.credential.length > 0) {
       sd.send(
@@ -914,22 +2232,34 @@ class Wizard {
     }
 
     let presetCredentialUsed = false;
-    const inputFields = command.fieldsWithPreset(
-      initialFieldDef,
-      fields,
-      self.preset,
-      (r) => {
-        if (r !== fields[0].name) {
-          return;
-        }
+    // Preset credential has been rejected by the server when retrying, so
+    // let the user input it instead
+    const inputFields = retrying
+      ? command.fields(initialFieldDef, fields)
+      : command.fieldsWithPreset(
+          initialFieldDef,
+          fields,
+          self.preset,
+          (r) => {
+            if (r !== fields[0].name) {
+              return;
+            }
 
-        presetCredentialUsed = true;
-      },
-    );
+            presetCredentialUsed = true;
+          },
+        );
 
     return command.prompt(
-      "Provide credential",
-      "Please input your credential",
+      retrying ? "Authentication failed" : "Provide credential",
+      self.promptMessage(
+        retrying
+          ? "Please try again (attempt " +
+              self.authAttempt.attempt +
+              " of " +
+              self.authAttempt.max +
+              ")"
+          : "Please input your credential",
+      ),
       "Login",
       (r) => {
         let vv = r[fields[0].name.toLowerCase()];
@@ -956,6 +2286,43 @@ class Wizard {
       inputFields,
     );
   }
+
+  stepKeyPassphrasePrompt(sd, attempt) {
+    const self = this;
+
+    return command.prompt(
+      attempt.attempt > 1 ? "Incorrect passphrase" : "Encrypted Private Key",
+      self.promptMessage(
+        attempt.attempt > 1
+          ? "Please try again (attempt " +
+              attempt.attempt +
+              " of " +
+              attempt.max +
+              ")"
+          : "Please input the passphrase of the Private Key",
+      ),
+      "Decrypt",
+      (r) => {
+        sd.send(
+          CLIENT_CONNECT_RESPOND_CREDENTIAL,
+          new TextEncoder().encode(r["key passphrase"]),
+        );
+
+        self.step.resolve(self.stepContinueWaitForEstablishWait());
+      },
+      () => {
+        sd.close();
+
+        self.step.resolve(
+          command.wait(
+            "Cancelling login",
+            "Cancelling login request, please wait",
+          ),
+        );
+      },
+      command.fields(initialFieldDef, [{ name: "Key Passphrase" }]),
+    );
+  }
 }
 
 class Executer extends Wizard {
@@ -996,6 +2363,10 @@ class Executer extends Wizard {
     this.config = config;
   }
 
+  run() {
+    this.step.resolve(this.stepInitialPrompt());
+  }
+
   stepInitialPrompt() {
     const self = this;
 
@@ -1011,6 +2382,9 @@ class Executer extends Wizard {
           charset: self.config.charset ? self.config.charset : "utf-8",
           tabColor: self.config.tabColor ? self.config.tabColor : "",
           fingerprint: self.config.fingerprint,
+          debugTransport: self.config.debugTransport ? true : false,
+          command: self.config.command ? self.config.command : "",
+          environment: self.config.environment ? self.config.environment : [],
         },
         self.session,
       );
@@ -0,0 +1,192 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Channels of SSH dynamic forwarding and local forwarding. Each channel is a
+// connection opened by the backend through the SSH connection
+
+import * as common from "./common.js";
+
+export const FRAME_OPEN = 0x00;
+export const FRAME_DATA = 0x01;
+export const FRAME_CLOSE = 0x02;
+
+// Flag of the frame type, marks the frames which ask the backend to type the
+// interrupt key named by the payload into the remote session, and drop the
+// output of it for a while
+export const FRAME_INTERRUPT = 0x10;
+
+// Flag of the frame type, marks the frames which ask the backend to park the
+// session, so it can be resumed on another device
+export const FRAME_HANDOVER = 0x20;
+
+// Flag of the frame type, marks the frames which ask the backend to send the
+// signal named by the payload to the remote session
+export const FRAME_SIGNAL = 0x40;
+
+// Flag of the frame type, marks the frames of the local forwarding which are
+// sent together with the ones of the dynamic forwarding
+export const FRAME_LOCAL_FORWARD = 0x80;
+
+const MAX_ID = 0xffff;
+const MAX_PAYLOAD_SIZE = 4096;
+
+export class Channels {
+  /**
+   * constructor
+   *
+   * @param {function} send Sends a frame to the backend, receives the frame
+   *                        type, the channel ID and the payload
+   *
+   */
+  constructor(send) {
+    this.sender = send;
+    this.channels = new Map();
+    this.nextID = 0;
+  }
+
+  /**
+   * Allocate an unused channel ID
+   *
+   * @returns {number} Channel ID
+   *
+   * @throws {Error} When all channel IDs are used
+   *
+   */
+  allocate() {
+    for (let i = 0; i < MAX_ID; i++) {
+      this.nextID = (this.nextID % MAX_ID) + 1;
+
+      if (!this.channels.has(this.nextID)) {
+        return this.nextID;
+      }
+    }
+
+    throw new Error("Too many channels");
+  }
+
+  /**
+   * Open a channel to the host and port
+   *
+   * @param {string} host Host
+   * @param {number} port Port
+   * @param {object} callbacks Receives the `data` and the `close` of the
+   *                           channel
+   *
+   * @returns {Promise<object>} The channel, which has `send` and `close`
+   *                            methods
+   *
+   */
+  open(host, port, callbacks) {
+    const id = this.allocate(),
+      addr = (host.indexOf(":") >= 0 ? "[" + host + "]" : host) + ":" + port;
+
+    return new Promise((resolve, reject) => {
+      this.channels.set(id, {
+        opened: false,
+        resolve: resolve,
+        reject: reject,
+        callbacks: callbacks,
+      });
+
+      this.sender(FRAME_OPEN, id, common.strToUint8Array(addr));
+    });
+  }
+
+  /**
+   * Build the channel of the given ID
+   *
+   * @param {number} id Channel ID
+   *
+   * @returns {object} The channel
+   *
+   */
+  channel(id) {
+    const self = this;
+
+    return {
+      send(data) {
+        if (!self.channels.has(id)) {
+          return;
+        }
+
+        for (let i = 0; i < data.length; i += MAX_PAYLOAD_SIZE) {
+          self.sender(FRAME_DATA, id, data.subarray(i, i + MAX_PAYLOAD_SIZE));
+        }
+      },
+      close() {
+        if (self.channels.delete(id)) {
+          self.sender(FRAME_CLOSE, id, new Uint8Array(0));
+        }
+      },
+    };
+  }
+
+  /**
+   * Handle a frame sent by the backend
+   *
+   * @param {number} frame Frame type
+   * @param {number} id Channel ID
+   * @param {Uint8Array} payload Payload
+   *
+   */
+  receive(frame, id, payload) {
+    const c = this.channels.get(id);
+
+    if (!c) {
+      return;
+    }
+
+    switch (frame) {
+      case FRAME_OPEN:
+        c.opened = true;
+        c.resolve(this.channel(id));
+        return;
+
+      case FRAME_DATA:
+        c.callbacks.data(payload);
+        return;
+
+      case FRAME_CLOSE:
+        this.channels.delete(id);
+
+        if (!c.opened) {
+          c.reject(new Error(new TextDecoder("utf-8").decode(payload)));
+        } else {
+          c.callbacks.close();
+        }
+        return;
+    }
+  }
+
+  /**
+   * Close all channels, usually because the connection is closed
+   *
+   */
+  closeAll() {
+    const channels = Array.from(this.channels.values());
+
+    this.channels.clear();
+
+    for (const c of channels) {
+      if (!c.opened) {
+        c.reject(new Error("Connection is closed"));
+      } else {
+        c.callbacks.close();
+      }
+    }
+  }
+}
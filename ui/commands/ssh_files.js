@@ -0,0 +1,372 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// File transfer of SSH. The requests are served by the backend through the
+// SFTP subsystem of the SSH connection
+
+import * as common from "./common.js";
+
+export const FRAME_LIST = 0x00;
+export const FRAME_DOWNLOAD = 0x01;
+export const FRAME_UPLOAD = 0x02;
+export const FRAME_DATA = 0x03;
+export const FRAME_RENAME = 0x04;
+export const FRAME_REMOVE = 0x05;
+export const FRAME_MKDIR = 0x06;
+export const FRAME_DONE = 0x07;
+export const FRAME_CLOSE = 0x08;
+
+export const ENTRY_FILE = 0x00;
+export const ENTRY_DIRECTORY = 0x01;
+export const ENTRY_SYMLINK = 0x02;
+export const ENTRY_OTHER = 0x03;
+
+const MAX_ID = 0xffff;
+const MAX_PAYLOAD_SIZE = 4096;
+const ENTRY_HEADER_SIZE = 15;
+
+/**
+ * Decode the entries carried by a List frame
+ *
+ * @param {Uint8Array} payload Payload of the frame
+ *
+ * @returns {Array<object>} Entries
+ *
+ */
+export function decodeEntries(payload) {
+  const entries = [],
+    view = new DataView(payload.buffer, payload.byteOffset, payload.length),
+    decoder = new TextDecoder("utf-8");
+
+  for (let i = 0; i + ENTRY_HEADER_SIZE < payload.length; ) {
+    const entry = {
+      type: payload[i],
+      permission: view.getUint16(i + 1),
+      size: Number(view.getBigUint64(i + 3)),
+      modified: new Date(view.getUint32(i + 11) * 1000),
+      name: "",
+    };
+
+    i += ENTRY_HEADER_SIZE;
+
+    let nameLen = payload[i] & 0x7f;
+
+    if (payload[i++] & 0x80) {
+      nameLen = (nameLen << 7) | (payload[i++] & 0x7f);
+    }
+
+    entry.name = decoder.decode(payload.subarray(i, i + nameLen));
+    entries.push(entry);
+
+    i += nameLen;
+  }
+
+  return entries;
+}
+
+export class Transfers {
+  /**
+   * constructor
+   *
+   * @param {function} send Sends a frame to the backend, receives the frame
+   *                        type, the request ID and the payload
+   *
+   */
+  constructor(send) {
+    this.sender = send;
+    this.requests = new Map();
+    this.nextID = 0;
+  }
+
+  /**
+   * Allocate an unused request ID
+   *
+   * @returns {number} Request ID
+   *
+   * @throws {Error} When all request IDs are used
+   *
+   */
+  allocate() {
+    for (let i = 0; i < MAX_ID; i++) {
+      this.nextID = (this.nextID % MAX_ID) + 1;
+
+      if (!this.requests.has(this.nextID)) {
+        return this.nextID;
+      }
+    }
+
+    throw new Error("Too many file transfers");
+  }
+
+  /**
+   * Send a request
+   *
+   * @param {number} frame Frame type of the request
+   * @param {Uint8Array} payload Payload of the request
+   * @param {object} handlers Handles the frames of the reply by their type,
+   *                          the result of the `done` handler resolves the
+   *                          request
+   *
+   * @returns {Promise<any>} The result of the request
+   *
+   */
+  request(frame, payload, handlers) {
+    const id = this.allocate();
+
+    return new Promise((resolve, reject) => {
+      this.requests.set(id, {
+        handlers: handlers,
+        resolve: resolve,
+        reject: reject,
+      });
+
+      this.sender(frame, id, payload);
+    });
+  }
+
+  /**
+   * List the directory
+   *
+   * @param {string} path Path of the directory, empty for the home directory
+   *
+   * @returns {Promise<object>} The absolute `path` and the `entries` of the
+   *                            directory
+   *
+   */
+  list(path) {
+    let entries = [];
+
+    return this.request(FRAME_LIST, common.strToUint8Array(path), {
+      list(payload) {
+        entries = entries.concat(decodeEntries(payload));
+      },
+      done(payload) {
+        return {
+          path: new TextDecoder("utf-8").decode(payload),
+          entries: entries,
+        };
+      },
+    });
+  }
+
+  /**
+   * Download the file
+   *
+   * @param {string} path Path of the file
+   * @param {function} progress Receives the received and the total size
+   *
+   * @returns {Promise<Blob>} The file
+   *
+   */
+  download(path, progress) {
+    const chunks = [];
+    let size = 0,
+      received = 0;
+
+    return this.request(FRAME_DOWNLOAD, common.strToUint8Array(path), {
+      download(payload) {
+        size = Number(
+          new DataView(payload.buffer, payload.byteOffset, 8).getBigUint64(0),
+        );
+
+        progress(received, size);
+      },
+      data(payload) {
+        chunks.push(payload.slice());
+        received += payload.length;
+
+        progress(received, size);
+      },
+      done() {
+        return new Blob(chunks);
+      },
+    });
+  }
+
+  /**
+   * Upload the file
+   *
+   * @param {string} path Path of the remote file
+   * @param {Blob} file The file to upload
+   * @param {function} progress Receives the sent and the total size
+   *
+   * @returns {Promise<void>} Resolves when the file is completely written
+   *
+   */
+  upload(path, file, progress) {
+    const self = this;
+
+    return this.request(FRAME_UPLOAD, common.strToUint8Array(path), {
+      async upload(payload, id) {
+        for (let i = 0; i < file.size; i += MAX_PAYLOAD_SIZE) {
+          const d = await file.slice(i, i + MAX_PAYLOAD_SIZE).arrayBuffer();
+
+          // The upload could be failed or cancelled in the meantime
+          if (!self.requests.has(id)) {
+            return;
+          }
+
+          await self.sender(FRAME_DATA, id, new Uint8Array(d));
+
+          progress(i + d.byteLength, file.size);
+        }
+
+        self.sender(FRAME_DONE, id, new Uint8Array(0));
+      },
+      done() {
+        return null;
+      },
+    });
+  }
+
+  /**
+   * Rename the file
+   *
+   * @param {string} from Old path
+   * @param {string} to New path
+   *
+   * @returns {Promise<void>} Resolves when the file is renamed
+   *
+   */
+  rename(from, to) {
+    const payload = common.strToUint8Array(from + "\0" + to);
+
+    return this.request(FRAME_RENAME, payload, {
+      done() {
+        return null;
+      },
+    });
+  }
+
+  /**
+   * Remove the file or the empty directory
+   *
+   * @param {string} path Path of the file
+   *
+   * @returns {Promise<void>} Resolves when the file is removed
+   *
+   */
+  remove(path) {
+    return this.request(FRAME_REMOVE, common.strToUint8Array(path), {
+      done() {
+        return null;
+      },
+    });
+  }
+
+  /**
+   * Create the directory
+   *
+   * @param {string} path Path of the directory
+   *
+   * @returns {Promise<void>} Resolves when the directory is created
+   *
+   */
+  mkdir(path) {
+    return this.request(FRAME_MKDIR, common.strToUint8Array(path), {
+      done() {
+        return null;
+      },
+    });
+  }
+
+  /**
+   * Handle a frame sent by the backend
+   *
+   * @param {number} frame Frame type
+   * @param {number} id Request ID
+   * @param {Uint8Array} payload Payload
+   *
+   */
+  receive(frame, id, payload) {
+    const r = this.requests.get(id);
+
+    if (!r) {
+      return;
+    }
+
+    const handle = (name) => {
+      if (!r.handlers[name]) {
+        return;
+      }
+
+      try {
+        return r.handlers[name](payload, id);
+      } catch (e) {
+        this.cancel(id, e);
+      }
+    };
+
+    switch (frame) {
+      case FRAME_LIST:
+        return handle("list");
+
+      case FRAME_DOWNLOAD:
+        return handle("download");
+
+      case FRAME_UPLOAD:
+        return handle("upload");
+
+      case FRAME_DATA:
+        return handle("data");
+
+      case FRAME_DONE:
+        this.requests.delete(id);
+        r.resolve(handle("done"));
+        return;
+
+      case FRAME_CLOSE:
+        this.requests.delete(id);
+        r.reject(new Error(new TextDecoder("utf-8").decode(payload)));
+        return;
+    }
+  }
+
+  /**
+   * Cancel the request
+   *
+   * @param {number} id Request ID
+   * @param {Error} e The reason
+   *
+   */
+  cancel(id, e) {
+    const r = this.requests.get(id);
+
+    if (!r) {
+      return;
+    }
+
+    this.requests.delete(id);
+    this.sender(FRAME_CLOSE, id, new Uint8Array(0));
+
+    r.reject(e);
+  }
+
+  /**
+   * Fail all requests, usually because the connection is closed
+   *
+   */
+  closeAll() {
+    const requests = Array.from(this.requests.values());
+
+    this.requests.clear();
+
+    for (const r of requests) {
+      r.reject(new Error("Connection is closed"));
+    }
+  }
+}
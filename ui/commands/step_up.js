@@ -0,0 +1,101 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+
+// Step-up authentication, which the backend may require the user to pass
+// right before connecting to privileged remotes
+
+import * as command from "./commands.js";
+
+export const METHOD_PASSWORD = 0x00;
+export const METHOD_TOTP = 0x01;
+
+const fieldDef = {
+  Password: {
+    name: "Password",
+    description: "The shared key you used to access this Sshwifty",
+    type: "password",
+    value: "",
+    example: "----------",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (d.length <= 0) {
+        throw new Error("Password must be specified");
+      }
+
+      return "";
+    },
+  },
+  Code: {
+    name: "Code",
+    description: "The one-time password generated by your authenticator",
+    type: "text",
+    value: "",
+    example: "123456",
+    readonly: false,
+    suggestions(input) {
+      return [];
+    },
+    verify(d) {
+      if (!/^[0-9]{6}$/.test(d)) {
+        throw new Error("Code must be 6 digits");
+      }
+
+      return "";
+    },
+  },
+};
+
+/**
+ * Returns the name of the field that the answer of `method` is inputted to
+ *
+ * @param {number} method Step-up method sent by the backend
+ *
+ * @returns {string} Name of the field
+ *
+ */
+export function fieldName(method) {
+  return method === METHOD_TOTP ? "Code" : "Password";
+}
+
+/**
+ * Build the prompt of the step-up authentication
+ *
+ * @param {number} method Step-up method sent by the backend
+ * @param {function} respond Called with the answer of the user
+ * @param {function} cancel Called when the user cancelled the prompt
+ *
+ * @returns {command.Next} The prompt step
+ *
+ */
+export function prompt(method, respond, cancel) {
+  const name = fieldName(method);
+
+  return command.prompt(
+    "Confirm your identity",
+    "This remote is privileged. Please authenticate again to continue",
+    "Continue",
+    (r) => {
+      respond(new TextEncoder().encode(r[name.toLowerCase()]));
+    },
+    cancel,
+    command.fields(fieldDef, [{ name: name }]),
+  );
+}
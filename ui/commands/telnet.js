@@ -25,17 +25,31 @@ import * as controls from "./controls.js";
 import * as event from "./events.js";
 import Exception from "./exception.js";
 import * as history from "./history.js";
+import * as inputSequence from "./input_sequence.js";
 import * as presets from "./presets.js";
+import * as signals from "./signals.js";
+import * as stepUp from "./step_up.js";
 import * as strings from "./string.js";
 
 const COMMAND_ID = 0x00;
 
+const OPTION_SEQUENCED_INPUT = 0x01;
+const OPTION_PROTOBUF = 0x02;
+
 const SERVER_INITIAL_ERROR_BAD_ADDRESS = 0x01;
 
 const SERVER_REMOTE_BAND = 0x00;
 const SERVER_HOOK_OUTPUT_BEFORE_CONNECTING = 0x01;
 const SERVER_DIAL_FAILED = 0x02;
 const SERVER_DIAL_CONNECTED = 0x03;
+const SERVER_STEP_UP = 0x04;
+const SERVER_DIAL_FAILURE = 0x05;
+const SERVER_SHARING = 0x06;
+const SERVER_FRAMING = 0x07;
+
+const CLIENT_REMOTE_BAND = 0x00;
+const CLIENT_RESPOND_STEP_UP = 0x01;
+const CLIENT_INTERRUPT = 0x02;
 
 const DEFAULT_PORT = 23;
 
@@ -54,14 +68,19 @@ class Telnet {
     this.sender = sd;
     this.config = config;
     this.connected = false;
+    this.framing = signals.FRAMING_BINARY;
+    this.input = new inputSequence.InputSequence();
     this.events = new event.Events(
       [
         "initialization.failed",
         "initialized",
         "hook.before_connected",
         "connect.failed",
+        "connect.failure",
         "connect.succeed",
+        "connect.step_up",
         "@inband",
+        "@sharing",
         "close",
         "@completed",
       ],
@@ -83,9 +102,10 @@ class Telnet {
       ),
       addrBuf = addr.buffer();
 
-    let data = new Uint8Array(addrBuf.length);
+    let data = new Uint8Array(addrBuf.length + 1);
 
     data.set(addrBuf, 0);
+    data[addrBuf.length] = OPTION_SEQUENCED_INPUT | OPTION_PROTOBUF;
 
     initialSender.send(data);
   }
@@ -118,8 +138,18 @@ class Telnet {
    * @throws {Exception} When the stream header type is unknown
    *
    */
-  tick(streamHeader, rd) {
+  async tick(streamHeader, rd) {
     switch (streamHeader.marker()) {
+      case SERVER_FRAMING:
+        if (!this.connected) {
+          const d = await reader.readOne(rd);
+
+          this.framing = d[0];
+
+          return;
+        }
+        break;
+
       case SERVER_DIAL_CONNECTED:
         if (!this.connected) {
           this.connected = true;
@@ -134,17 +164,42 @@ class Telnet {
         }
         break;
 
+      case SERVER_DIAL_FAILURE:
+        if (!this.connected) {
+          return this.events.fire("connect.failure", rd);
+        }
+        break;
+
       case SERVER_HOOK_OUTPUT_BEFORE_CONNECTING:
         if (!this.connected) {
           return this.events.fire("hook.before_connected", rd);
         }
         break;
 
+      case SERVER_STEP_UP:
+        if (!this.connected) {
+          return this.events.fire("connect.step_up", rd, this.sender);
+        }
+        break;
+
       case SERVER_REMOTE_BAND:
         if (this.connected) {
           return this.events.fire("inband", rd);
         }
         break;
+
+      case SERVER_SHARING:
+        if (this.connected) {
+          const d = await reader.readCompletely(rd);
+
+          return this.events.fire(
+            "sharing",
+            this.framing === signals.FRAMING_PROTOBUF
+              ? signals.sharingSessions(d)
+              : JSON.parse(new TextDecoder("utf-8").decode(d)),
+          );
+        }
+        break;
     }
 
     throw new Exception("Unknown stream header marker");
@@ -165,7 +220,23 @@ class Telnet {
    *
    */
   sendData(data) {
-    return this.sender.sendData(0x00, data);
+    // Frames are queued all at once, so they're not interleaved with the ones
+    // of the other calls, which would be received out of order and dropped
+    return Promise.all(
+      this.input
+        .frames(data)
+        .map((f) => this.sender.send(CLIENT_REMOTE_BAND, f)),
+    );
+  }
+
+  /**
+   * Interrupt the program that is flooding the terminal
+   *
+   * @param {string} name Name of the interrupt, see common.interrupts
+   *
+   */
+  sendInterrupt(name) {
+    return this.sender.send(CLIENT_INTERRUPT, common.strToUint8Array(name));
   }
 
   /**
@@ -361,6 +432,9 @@ class Wizard {
     // Copy the keptSessions from the record so it will not be overwritten here
     let keptSessions = self.keptSessions ? [].concat(...self.keptSessions) : [];
 
+    // DialFailure code of the failed connection attempt
+    let dialFailure = null;
+
     return new Telnet(sender, parsedConfig, {
       "initialization.failed"(streamInitialHeader) {
         switch (streamInitialHeader.data()) {
@@ -369,6 +443,16 @@ class Wizard {
               self.stepErrorDone("Request rejected", "Invalid address"),
             );
 
+            return;
+
+          case header.INITIAL_ERROR_SHUTTING_DOWN:
+            self.step.resolve(
+              self.stepErrorDone(
+                "Request rejected",
+                "The server is shutting down, please try again later",
+              ),
+            );
+
             return;
         }
 
@@ -390,6 +474,32 @@ class Wizard {
           self.stepHookOutputPrompt("Waiting for server hook", d),
         );
       },
+      async "connect.step_up"(rd, sd) {
+        const method = await reader.readOne(rd);
+
+        self.step.resolve(
+          stepUp.prompt(
+            method[0],
+            (answer) => {
+              sd.send(CLIENT_RESPOND_STEP_UP, answer);
+
+              self.step.resolve(
+                self.stepWaitForEstablishWait(configInput.host),
+              );
+            },
+            () => {
+              sd.close();
+
+              self.step.resolve(
+                command.wait(
+                  "Cancelling",
+                  "Cancelling connection request, please wait",
+                ),
+              );
+            },
+          ),
+        );
+      },
       "connect.succeed"(rd, commandHandler) {
         self.step.resolve(
           self.stepSuccessfulDone(
@@ -405,6 +515,9 @@ class Wizard {
                 close() {
                   return commandHandler.sendClose();
                 },
+                interrupt(name) {
+                  return commandHandler.sendInterrupt(name);
+                },
                 events: commandHandler.events,
               }),
               self.controls.ui(),
@@ -412,23 +525,34 @@ class Wizard {
           ),
         );
 
-        self.history.save(
-          self.info.name() + ":" + configInput.host,
-          configInput.host,
-          new Date(),
-          self.info,
-          configInput,
-          sessionData,
-          keptSessions,
-        );
+        // Connections of the "no trace" presets are not kept in the history
+        if (!(self.preset && self.preset.noTrace())) {
+          self.history.save(
+            self.info.name() + ":" + configInput.host,
+            configInput.host,
+            new Date(),
+            self.info,
+            configInput,
+            sessionData,
+            keptSessions,
+          );
+        }
       },
       async "connect.failed"(rd) {
         let readed = await reader.readCompletely(rd),
           message = new TextDecoder("utf-8").decode(readed.buffer);
 
-        self.step.resolve(self.stepErrorDone("Connection failed", message));
+        self.step.resolve(
+          self.stepErrorDone(common.dialFailureTitle(dialFailure), message),
+        );
+      },
+      async "connect.failure"(rd) {
+        const d = await reader.readOne(rd);
+
+        dialFailure = d[0];
       },
       "@inband"(rd) {},
+      "@sharing"(sessions) {},
       close() {},
       "@completed"() {},
     });
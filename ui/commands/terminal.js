@@ -0,0 +1,83 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Terminal that the remote sessions are started with, so the full-screen
+// applications render correctly before the first resize arrives
+
+import { userSettings } from "../settings.js";
+
+export const DEFAULT_TYPE = "xterm-256color";
+
+const MAX_DIMENSION = 0xffff;
+
+// Must match the font metrics of the console, see screen_console.vue
+const estimateFontSize = 16;
+const estimateCellWidth = 0.6;
+const estimateLineHeight = 1.3;
+const estimateLetterSpacing = 1;
+
+let lastSize = null;
+
+/**
+ * Remember the size of the console, which all the consoles share
+ *
+ * @param {number} rows Rows of the console
+ * @param {number} cols Columns of the console
+ *
+ */
+export function remember(rows, cols) {
+  if (rows <= 0 || cols <= 0) {
+    return;
+  }
+
+  lastSize = {
+    rows: Math.min(rows, MAX_DIMENSION),
+    cols: Math.min(cols, MAX_DIMENSION),
+  };
+}
+
+/**
+ * Returns the size of the console. It's estimated from the size of the
+ * window when no console has been opened yet
+ *
+ * @returns {object} The size in `rows` and `cols`
+ *
+ */
+export function size() {
+  if (lastSize !== null) {
+    return lastSize;
+  }
+
+  const fontSize = userSettings.get("font_size", estimateFontSize),
+    cellWidth = fontSize * estimateCellWidth + estimateLetterSpacing,
+    cellHeight = fontSize * estimateLineHeight;
+
+  return {
+    rows: Math.max(1, Math.floor(window.innerHeight / cellHeight)),
+    cols: Math.max(1, Math.floor(window.innerWidth / cellWidth)),
+  };
+}
+
+/**
+ * Returns the terminal type to request
+ *
+ * @returns {string}
+ *
+ */
+export function type() {
+  return userSettings.get("terminal_type", DEFAULT_TYPE);
+}
@@ -16,15 +16,33 @@
 // along with this program.  If not, see <https://www.gnu.org/licenses/>.
 
 import * as iconv from "iconv-lite";
+import * as agent from "../commands/agent.js";
 import * as color from "../commands/color.js";
 import * as common from "../commands/common.js";
 import * as reader from "../stream/reader.js";
 import * as subscribe from "../stream/subscribe.js";
 
+const previewInterface = "/sshwifty/preview/";
+
+// Signals that can be sent to the remote session. Remotes may ignore them
+const remoteSignals = ["INT", "TERM", "HUP", "QUIT", "KILL"];
+
 class Control {
   constructor(data, color) {
     this.background = color;
     this.charset = data.charset;
+    this.transportInfo = data.transportInfo;
+    this.transportWarnings = data.transportWarnings
+      ? data.transportWarnings
+      : [];
+    this.forwards = data.forwards ? data.forwards : [];
+    this.reverseForwards = data.reverseForwards ? data.reverseForwards : [];
+    this.dynamic = data.dynamic ? data.dynamic : null;
+    this.localForwards = data.localForwards ? data.localForwards : null;
+    this.files = data.files ? data.files : null;
+    this.socksAgent = null;
+    this.socksAgentStatus = "";
+    this.ended = "";
 
     this.charsetDecoder = (d) => {
       return iconv.decode(d, this.charset);
@@ -37,10 +55,26 @@ class Control {
     this.sender = data.send;
     this.closer = data.close;
     this.resizer = data.resize;
+    this.lastDim = null;
+    this.signaler = data.signal;
+    this.interrupter = data.interrupt;
+    this.handoverer = data.handover;
     this.subs = new subscribe.Subscribe();
 
     let self = this;
 
+    // The banner was shown before the login, keep it on the screen so it
+    // can still be read after the login has completed
+    if (data.banner) {
+      self.subs.resolve(data.banner.replace(/\r?\n/g, "\r\n"));
+    }
+
+    // Weak transport is shown in yellow, so the users can tell the insecure
+    // servers apart and report them
+    for (const w of self.transportWarnings) {
+      self.subs.resolve("\x1b[33mWarning: " + w + "\x1b[0m\r\n");
+    }
+
     data.events.place("stdout", async (rd) => {
       try {
         self.subs.resolve(self.charsetDecoder(await reader.readCompletely(rd)));
@@ -57,11 +91,121 @@ class Control {
       }
     });
 
+    data.events.place("session_ended", (status, signal) => {
+      if (status === null) {
+        self.ended = "Process exited without reporting its status";
+
+        return;
+      }
+
+      self.ended =
+        "Process exited with status " +
+        status +
+        (signal ? " (" + signal + ")" : "");
+    });
+
+    // The server reconnects the dropped connections by itself, tell the user
+    // what's happening, as the screen would otherwise freeze
+    data.events.place("reconnect", (status) => {
+      switch (status.state) {
+        case "waiting":
+          self.subs.resolve(
+            "\r\n\x1b[33m" +
+              status.error +
+              ", reconnecting in " +
+              Math.ceil(status.delay / 1000) +
+              "s (attempt " +
+              status.attempt +
+              " of " +
+              status.attempts +
+              ")\x1b[0m\r\n",
+          );
+          return;
+
+        case "reconnected":
+          self.subs.resolve("\x1b[33mReconnected\x1b[0m\r\n");
+
+          // The new PTY was opened with the initial size
+          if (self.lastDim) {
+            self.resize(self.lastDim);
+          }
+          return;
+
+        case "failed":
+          self.subs.resolve(
+            "\x1b[33mUnable to reconnect: " + status.error + "\x1b[0m\r\n",
+          );
+          return;
+      }
+    });
+
+    // The server closes the stream once the session is parked, which then
+    // waits on the server for another device to resume it
+    data.events.place("handover", (status) => {
+      switch (status.state) {
+        case "parked":
+          self.ended =
+            "Session has been parked, resume it on another device before " +
+            new Date(status.expires * 1000).toLocaleTimeString();
+          return;
+
+        case "refused":
+          self.subs.resolve(
+            "\r\n\x1b[33mUnable to park the session: " +
+              status.error +
+              "\x1b[0m\r\n",
+          );
+          return;
+      }
+    });
+
+    // Other sessions on the same account may be editing the same files,
+    // warn the user before they step on each other
+    data.events.place("sharing", (sessions) => {
+      self.subs.resolve(common.sharingNotice(sessions));
+    });
+
+    if (self.dynamic && data.socksAgent) {
+      self.socksAgent = new agent.Agent(
+        data.socksAgent,
+        (host, port, callbacks) => {
+          return self.dynamic.open(host, port, callbacks);
+        },
+        (status) => {
+          self.socksAgentStatus = status;
+        },
+        self.localForwards ? data.localForwardList : [],
+        (host, port, callbacks) => {
+          return self.localForwards.open(host, port, callbacks);
+        },
+      );
+      self.socksAgent.start();
+    }
+
     data.events.place("completed", () => {
       self.closed = true;
+
+      if (self.socksAgent) {
+        self.socksAgent.close();
+      }
+
+      if (self.dynamic) {
+        self.dynamic.closeAll();
+      }
+
+      if (self.localForwards) {
+        self.localForwards.closeAll();
+      }
+
+      if (self.files) {
+        self.files.closeAll();
+      }
+
       self.background.forget();
 
-      self.subs.reject("Remote connection has been terminated");
+      self.subs.reject(
+        self.ended ? self.ended : "Remote connection has been terminated",
+      );
     });
   }
 
@@ -74,7 +218,8 @@ class Control {
       return;
     }
 
-    this.resizer(dim.rows, dim.cols);
+    this.lastDim = dim;
+    this.resizer(dim.rows, dim.cols, dim.width, dim.height);
   }
 
   enabled() {
@@ -111,6 +256,121 @@ class Control {
     return this.background.hex();
   }
 
+  fileTransfers() {
+    return this.closed ? null : this.files;
+  }
+
+  signals() {
+    return this.closed ? [] : remoteSignals;
+  }
+
+  signal(name) {
+    if (this.closed) {
+      return;
+    }
+
+    return this.signaler(name);
+  }
+
+  interrupts() {
+    return this.closed ? [] : common.interrupts;
+  }
+
+  interrupt(name) {
+    if (this.closed) {
+      return;
+    }
+
+    return this.interrupter(name);
+  }
+
+  handover() {
+    if (this.closed) {
+      return;
+    }
+
+    return this.handoverer();
+  }
+
+  info() {
+    const forwards = this.forwards
+      .map((f) => {
+        if (f.preview) {
+          return {
+            name: "Forward " + f.name,
+            value: "Preview " + f.target,
+            link: previewInterface + f.preview + "/",
+          };
+        }
+
+        return {
+          name: "Forward " + f.name,
+          value: f.error
+            ? f.target + " (" + f.error + ")"
+            : f.local + " -> " + f.target,
+        };
+      })
+      .concat(
+        this.reverseForwards.map((f) => {
+          return {
+            name: "Reverse forward",
+            value:
+              (f.error ? f.bind : f.listen) +
+              " -> " +
+              f.target +
+              (f.error ? " (" + f.error + ")" : ""),
+          };
+        }),
+      );
+
+    if (this.socksAgent) {
+      forwards.push({
+        name: "SOCKS agent",
+        value: this.socksAgent.url + " (" + this.socksAgentStatus + ")",
+      });
+
+      for (const f of this.socksAgent.forwards) {
+        forwards.push({
+          name: "Local forward",
+          value:
+            (f.listened ? f.listened : f.listen) +
+            " -> " +
+            f.host +
+            ":" +
+            f.port +
+            (f.error ? " (" + f.error + ")" : ""),
+        });
+      }
+    }
+
+    const warnings = this.transportWarnings.map((w) => {
+      return { name: "Warning", value: w };
+    });
+
+    if (!this.transportInfo) {
+      return warnings.concat(forwards);
+    }
+
+    const t = this.transportInfo,
+      mac = (m) => (m ? m : "(implicit)");
+
+    return [
+      { name: "Server", value: t.server_version },
+      { name: "Key exchange", value: t.kex },
+      { name: "Host key", value: t.host_key },
+      {
+        name: "Cipher",
+        value: t.cipher_client_server + " / " + t.cipher_server_client,
+      },
+      {
+        name: "MAC",
+        value: mac(t.mac_client_server) + " / " + mac(t.mac_server_client),
+      },
+    ]
+      .concat(warnings)
+      .concat(forwards);
+  }
+
   close() {
     if (this.closer === null) {
       return;
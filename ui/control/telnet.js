@@ -344,6 +344,7 @@ class Control {
 
     this.sender = data.send;
     this.closer = data.close;
+    this.interrupter = data.interrupt;
     this.closed = false;
     this.localEchoEnabled = true;
     this.subs = new subscribe.Subscribe();
@@ -380,6 +381,12 @@ class Control {
       });
     });
 
+    // Other sessions on the same remote may be editing the same files, warn
+    // the user before they step on each other
+    data.events.place("sharing", (sessions) => {
+      self.subs.resolve(common.sharingNotice(sessions));
+    });
+
     data.events.place("completed", async () => {
       self.parser.close();
       self.closed = true;
@@ -472,6 +479,18 @@ class Control {
     return this.background.hex();
   }
 
+  interrupts() {
+    return this.closed ? [] : common.interrupts;
+  }
+
+  interrupt(name) {
+    if (this.closed) {
+      return;
+    }
+
+    return this.interrupter(name);
+  }
+
   close() {
     if (this.closer === null) {
       return;
@@ -0,0 +1,85 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import * as xhr from "./xhr.js";
+
+const deepLinkInterface = "/sshwifty/deeplink";
+const deepLinkPath = "/connect";
+
+/**
+ * Return the query of the deep link the page is opened with, or an empty
+ * string when the page is not opened by a deep link
+ *
+ * @param {Location} location Location of the page
+ *
+ * @returns {string}
+ *
+ */
+export function query(location) {
+  if (!location.pathname.endsWith(deepLinkPath)) {
+    return "";
+  }
+
+  const q = new URLSearchParams(location.search);
+
+  if (!q.get("preset") || !q.get("expires") || !q.get("token")) {
+    return "";
+  }
+
+  return location.search;
+}
+
+/**
+ * Remove the deep link from the address bar, so reloading the page doesn't
+ * open the Preset again
+ *
+ * @param {Location} location Location of the page
+ * @param {History} history History of the page
+ *
+ */
+export function clear(location, history) {
+  history.replaceState(
+    history.state,
+    "",
+    location.pathname.slice(0, -deepLinkPath.length + 1) + location.hash,
+  );
+}
+
+/**
+ * Ask the backend which Preset the deep link opens
+ *
+ * @param {string} q Query of the deep link
+ * @param {string} key Auth Key
+ *
+ * @returns {number} ID of the Preset
+ *
+ * @throws {Error} When the deep link is refused by the backend
+ *
+ */
+export async function resolve(q, key) {
+  let h = await xhr.get(deepLinkInterface + q, {
+    "X-Key": key,
+  });
+
+  if (h.status !== 200) {
+    throw new Error(
+      "Unable to open the deep link: " + h.status + " " + h.responseText,
+    );
+  }
+
+  return JSON.parse(h.responseText).id;
+}
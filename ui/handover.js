@@ -0,0 +1,75 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import * as xhr from "./xhr.js";
+
+const handoverInterface = "/sshwifty/handover";
+
+/**
+ * Sessions the user has parked on other devices, which can be resumed on
+ * this one
+ *
+ */
+export class Handover {
+  constructor() {
+    this.keyBuilder = null;
+  }
+
+  /**
+   * Set the builder of the Auth Key that is used to list the sessions
+   *
+   * @param {function} keyBuilder Async function that returns the Auth Key
+   *
+   */
+  load(keyBuilder) {
+    this.keyBuilder = keyBuilder;
+  }
+
+  /**
+   * List the parked sessions of the given type
+   *
+   * @param {string} type Type of the sessions, i.e. "SSH"
+   *
+   * @returns {Array<object>} The parked sessions, each with the `id`, the
+   *                          `remote`, the `login` and the time it
+   *                          `expires`. Empty when the handover is disabled
+   *                          (404) or the identity of the user is unknown
+   *                          (403)
+   *
+   */
+  async sessions(type) {
+    if (this.keyBuilder === null) {
+      return [];
+    }
+
+    try {
+      let h = await xhr.get(handoverInterface, {
+        "X-Key": await this.keyBuilder(),
+      });
+
+      if (h.status !== 200) {
+        return [];
+      }
+
+      return JSON.parse(h.responseText).filter((s) => s.type === type);
+    } catch (e) {
+      return [];
+    }
+  }
+}
+
+export const handover = new Handover();
@@ -136,6 +136,12 @@ export function build(ctx) {
       this.windowClass = "";
       this.status.description = connectionStatusConnected;
     },
+    presetStatus(id, status) {
+      ctx.updatePresetStatus(id, status);
+    },
+    notice(notice) {
+      ctx.showNotice(notice);
+    },
     traffic(inb, outb) {
       inboundPerSecond += inb;
       outboundPerSecond += outb;
@@ -0,0 +1,187 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import * as xhr from "./xhr.js";
+
+const kioskInterface = "/sshwifty/kiosk";
+const kioskPath = "/kiosk";
+
+// The page is reloaded once the console has been down for this many
+// keepalives in a row
+const maxMissedKeepalives = 2;
+
+/**
+ * Return whether or not the respond `status` means the backend is
+ * unreachable for now
+ *
+ * @param {number} status HTTP status code
+ *
+ * @returns {boolean}
+ *
+ */
+function unreachable(status) {
+  switch (status) {
+    case 0:
+    case 429:
+    case 502:
+    case 503:
+    case 504:
+      return true;
+  }
+
+  return false;
+}
+
+/**
+ * Return the query of the kiosk link the page is opened with, or an empty
+ * string when the page is not opened as a kiosk
+ *
+ * @param {Location} location Location of the page
+ *
+ * @returns {string}
+ *
+ */
+export function query(location) {
+  if (!location.pathname.endsWith(kioskPath)) {
+    return "";
+  }
+
+  const q = new URLSearchParams(location.search);
+
+  if (!q.get("preset") || !q.get("expires") || !q.get("token")) {
+    return "";
+  }
+
+  return location.search;
+}
+
+/**
+ * Log the kiosk in with the token in the query
+ *
+ * @param {string} q Query of the kiosk link
+ *
+ * @returns {object|null} Session of the kiosk, which contains the ID of the
+ *                        Preset (`id`), the key that replaces the passphrase
+ *                        (`key`) and the keepalive interval in seconds
+ *                        (`keepalive`). Or null when the backend is
+ *                        unreachable
+ *
+ * @throws {Error} When the kiosk is refused by the backend
+ *
+ */
+export async function login(q) {
+  let h = null;
+
+  try {
+    h = await xhr.get(kioskInterface + q, {});
+  } catch (e) {
+    return null;
+  }
+
+  if (unreachable(h.status)) {
+    return null;
+  }
+
+  if (h.status !== 200) {
+    throw new Error(
+      "Unable to start the kiosk: " + h.status + " " + h.responseText,
+    );
+  }
+
+  return JSON.parse(h.responseText);
+}
+
+export class Watchdog {
+  /**
+   * constructor
+   *
+   * @param {string} q Query of the kiosk link
+   * @param {number} interval Keepalive interval in seconds
+   * @param {function} alive Returns whether or not the console is up
+   * @param {function} restart Called to restart the kiosk
+   * @param {function} failed Called with the error message when the kiosk
+   *                          is no longer allowed
+   *
+   */
+  constructor(q, interval, alive, restart, failed) {
+    this.q = q;
+    this.interval = interval * 1000;
+    this.alive = alive;
+    this.restart = restart;
+    this.failed = failed;
+    this.missed = 0;
+    this.timer = null;
+  }
+
+  start() {
+    if (this.timer !== null) {
+      return;
+    }
+
+    this.timer = setInterval(() => {
+      this.tick();
+    }, this.interval);
+  }
+
+  stop() {
+    if (this.timer === null) {
+      return;
+    }
+
+    clearInterval(this.timer);
+    this.timer = null;
+  }
+
+  async tick() {
+    let h = null;
+
+    try {
+      h = await xhr.get(kioskInterface + this.q, {});
+    } catch (e) {
+      // Backend is unreachable, try again later
+      return;
+    }
+
+    if (unreachable(h.status)) {
+      return;
+    }
+
+    if (h.status !== 200) {
+      this.stop();
+      this.failed(
+        "The kiosk is no longer allowed: " + h.status + " " + h.responseText,
+      );
+
+      return;
+    }
+
+    if (this.alive()) {
+      this.missed = 0;
+
+      return;
+    }
+
+    this.missed++;
+
+    if (this.missed < maxMissedKeepalives) {
+      return;
+    }
+
+    this.stop();
+    this.restart();
+  }
+}
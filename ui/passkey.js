@@ -0,0 +1,151 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+
+import * as xhr from "./xhr.js";
+
+const registerInterface = "/sshwifty/passkey/register";
+const loginInterface = "/sshwifty/passkey/login";
+
+function decode(s) {
+  let b = atob(s.replace(/-/g, "+").replace(/_/g, "/")),
+    r = new Uint8Array(b.length);
+
+  for (let i = 0; i < b.length; i++) {
+    r[i] = b.charCodeAt(i);
+  }
+
+  return r;
+}
+
+function encode(buf) {
+  return btoa(String.fromCharCode.apply(null, new Uint8Array(buf)))
+    .replace(/\+/g, "-")
+    .replace(/\//g, "_")
+    .replace(/=+$/, "");
+}
+
+function parse(h) {
+  if (h.status < 200 || h.status >= 300) {
+    throw new Error(h.responseText || "Unexpected status " + h.status);
+  }
+
+  return JSON.parse(h.responseText);
+}
+
+/**
+ * Return whether or not the browser supports passkeys
+ *
+ * @returns {boolean}
+ *
+ */
+export function supported() {
+  return (
+    typeof window.PublicKeyCredential !== "undefined" &&
+    typeof navigator.credentials !== "undefined"
+  );
+}
+
+/**
+ * Register a new passkey for current user
+ *
+ * @param {string} authKey The Auth Key of current user
+ * @param {string} enrollment The enrollment code given by the
+ *                            administrator, leave empty when the server
+ *                            already knows the identity of current user
+ *
+ */
+export async function register(authKey, enrollment) {
+  let opts = parse(
+    await xhr.get(
+      registerInterface + "?enrollment=" + encodeURIComponent(enrollment),
+      {
+        "X-Key": authKey,
+      },
+    ),
+  );
+
+  let credential = await navigator.credentials.create({
+    publicKey: {
+      challenge: decode(opts.challenge),
+      rp: opts.rp,
+      user: {
+        id: decode(opts.user.id),
+        name: opts.user.name,
+        displayName: opts.user.displayName,
+      },
+      pubKeyCredParams: opts.pubKeyCredParams,
+      timeout: opts.timeout,
+      excludeCredentials: opts.excludeCredentials.map((c) => {
+        return { type: c.type, id: decode(c.id) };
+      }),
+      authenticatorSelection: {
+        residentKey: "required",
+        requireResidentKey: true,
+        userVerification: "preferred",
+      },
+      attestation: opts.attestation,
+    },
+  });
+
+  return parse(
+    await xhr.post(
+      registerInterface,
+      { "X-Key": authKey, "Content-Type": "application/json" },
+      JSON.stringify({
+        enrollment: enrollment,
+        response: {
+          id: credential.id,
+          clientDataJSON: encode(credential.response.clientDataJSON),
+          attestationObject: encode(credential.response.attestationObject),
+        },
+      }),
+    ),
+  );
+}
+
+/**
+ * Login with a passkey. The returned key replaces the passphrase
+ *
+ * @returns {object} The owner (`user`) of the passkey and the `key`
+ *
+ */
+export async function login() {
+  let opts = parse(await xhr.get(loginInterface, {}));
+
+  let credential = await navigator.credentials.get({
+    publicKey: {
+      challenge: decode(opts.challenge),
+      rpId: opts.rpId,
+      timeout: opts.timeout,
+      userVerification: opts.userVerification,
+    },
+  });
+
+  return parse(
+    await xhr.post(
+      loginInterface,
+      { "Content-Type": "application/json" },
+      JSON.stringify({
+        id: credential.id,
+        clientDataJSON: encode(credential.response.clientDataJSON),
+        authenticatorData: encode(credential.response.authenticatorData),
+        signature: encode(credential.response.signature),
+      }),
+    ),
+  );
+}
@@ -0,0 +1,121 @@
+// Sshwifty - A Web SSH client
+//
+// Copyright (C) 2019-2025 Ni Rui <ranqus@gmail.com>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as
+// published by the Free Software Foundation, either version 3 of the
+// License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+import * as xhr from "./xhr.js";
+
+const settingsInterface = "/sshwifty/settings";
+
+/**
+ * Default settings of the user, stored on the server so they follow the user
+ * across browsers and devices
+ *
+ */
+export class UserSettings {
+  constructor() {
+    this.settings = {};
+    this.keyBuilder = null;
+  }
+
+  /**
+   * Load the settings from the server
+   *
+   * @param {function} keyBuilder Async function that returns the Auth Key
+   *
+   */
+  async load(keyBuilder) {
+    this.keyBuilder = keyBuilder;
+
+    try {
+      let h = await xhr.get(settingsInterface, {
+        "X-Key": await this.keyBuilder(),
+      });
+
+      // The settings is not available when the feature is disabled (404) or
+      // when the identity of the user is unknown (403)
+      if (h.status !== 200) {
+        this.keyBuilder = null;
+
+        return;
+      }
+
+      this.settings = JSON.parse(h.responseText);
+    } catch (e) {
+      this.keyBuilder = null;
+    }
+  }
+
+  /**
+   * Return whether or not the settings is stored on the server
+   *
+   * @returns {boolean}
+   *
+   */
+  available() {
+    return this.keyBuilder !== null;
+  }
+
+  /**
+   * Get a setting
+   *
+   * @param {string} name Name of the setting
+   * @param {any} defaultValue Returned when the setting is not set
+   *
+   * @returns {any}
+   *
+   */
+  get(name, defaultValue) {
+    if (!this.settings[name]) {
+      return defaultValue;
+    }
+
+    return this.settings[name];
+  }
+
+  /**
+   * Change a setting and save it to the server
+   *
+   * @param {string} name Name of the setting
+   * @param {any} value Value of the setting
+   *
+   */
+  async set(name, value) {
+    if (!this.available() || this.settings[name] === value) {
+      return;
+    }
+
+    let changed = Object.assign({}, this.settings);
+
+    changed[name] = value;
+
+    let h = await xhr.put(
+      settingsInterface,
+      {
+        "X-Key": await this.keyBuilder(),
+        "Content-Type": "application/json",
+      },
+      JSON.stringify(changed),
+    );
+
+    if (h.status !== 200) {
+      throw new Error("Unable to save settings: " + h.responseText);
+    }
+
+    this.settings = JSON.parse(h.responseText);
+  }
+}
+
+export const userSettings = new UserSettings();
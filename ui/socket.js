@@ -48,14 +48,20 @@ class Dial {
    *
    * @param {string} address Target URL address
    * @param {number} timeout Connect timeout
+   * @param {string} binding Binding token issued by the verification, or
+   *                         empty when the server didn't issue one
    *
    * @returns {Promise<WebSocket>} When connection is established
    *
    */
-  connect(address, timeout) {
+  connect(address, timeout, binding) {
     const self = this;
     return new Promise((resolve, reject) => {
-      let ws = new WebSocket(address.webSocket),
+      let ws = new WebSocket(
+          binding
+            ? address.webSocket + "?binding=" + encodeURIComponent(binding)
+            : address.webSocket,
+        ),
         promised = false,
         timeoutTimer = setTimeout(() => {
           ws.close();
@@ -137,7 +143,15 @@ class Dial {
    *
    */
   async dial(callbacks) {
-    let ws = await this.connect(this.address, this.timeout);
+    // The key must be fetched before connecting, as the fetch also renews
+    // the binding token that the connection presents
+    let key = await this.buildKey();
+
+    let ws = await this.connect(
+      this.address,
+      this.timeout,
+      this.privateKey.binding,
+    );
 
     try {
       let rd = new reader.Reader(new reader.Multiple(() => {}), (data) => {
@@ -210,8 +224,6 @@ class Dial {
 
       let receiverNonce = await reader.readN(rd, crypt.GCMNonceSize);
 
-      let key = await this.buildKey();
-
       sdDataConvert = async (rawData) => {
         let encoded = await crypt.encryptGCM(key, senderNonce, rawData);
 
@@ -361,6 +373,12 @@ export class Socket {
 
           return callbacks.echo(delay);
         },
+        presetStatusUpdater(id, status) {
+          return callbacks.presetStatus(id, status);
+        },
+        noticeUpdater(notice) {
+          return callbacks.notice(notice);
+        },
         cleared(e) {
           if (self.streamHandler === null) {
             return;
@@ -25,12 +25,18 @@ export const COMPLETED = 0xc0;
 export const CONTROL_ECHO = 0x00;
 export const CONTROL_PAUSESTREAM = 0x01;
 export const CONTROL_RESUMESTREAM = 0x02;
+export const CONTROL_PRESETSTATUS = 0x03;
+export const CONTROL_NOTICE = 0x04;
 
 const headerHeaderCutter = 0xc0;
 const headerDataCutter = 0x3f;
 
 export const HEADER_MAX_DATA = headerDataCutter;
 
+// Sent as the data of a failed InitialStream when the server is shutting
+// down, see command.StreamErrorShuttingDown
+export const INITIAL_ERROR_SHUTTING_DOWN = 0x07ff;
+
 export class Header {
   /**
    * constructor
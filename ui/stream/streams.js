@@ -24,6 +24,10 @@ import * as stream from "./stream.js";
 
 export const ECHO_FAILED = -1;
 
+const presetStatuses = ["unknown", "up", "down"];
+
+const NOTICE_FLAG_MORE = 0x01;
+
 export class Requested {
   /**
    * constructor
@@ -53,6 +57,7 @@ export class Streams {
     this.echoTimer = null;
     this.lastEchoTime = null;
     this.lastEchoData = null;
+    this.notice = [];
     this.stop = false;
 
     this.streams = [];
@@ -244,7 +249,9 @@ export class Streams {
   async handleControl(rd) {
     let controlType = await reader.readOne(rd),
       delay = 0,
-      echoBytes = null;
+      echoBytes = null,
+      presetStatus = null,
+      notice = null;
 
     switch (controlType[0]) {
       case header.CONTROL_ECHO:
@@ -282,6 +289,44 @@ export class Streams {
 
         this.config.echoUpdater(delay);
 
+        return;
+
+      case header.CONTROL_PRESETSTATUS:
+        presetStatus = await reader.readCompletely(rd);
+
+        if (presetStatus.length < 3) {
+          return;
+        }
+
+        this.config.presetStatusUpdater(
+          (presetStatus[0] << 8) | presetStatus[1],
+          presetStatuses[presetStatus[2]]
+            ? presetStatuses[presetStatus[2]]
+            : presetStatuses[0],
+        );
+
+        return;
+
+      case header.CONTROL_NOTICE:
+        notice = await reader.readCompletely(rd);
+
+        if (notice.length < 1) {
+          return;
+        }
+
+        // Long notices are split into multiple messages, all but the last
+        // one of them are flagged
+        this.notice.push(...notice.slice(1));
+
+        if (notice[0] & NOTICE_FLAG_MORE) {
+          return;
+        }
+
+        notice = new TextDecoder("utf-8").decode(new Uint8Array(this.notice));
+        this.notice = [];
+
+        this.config.noticeUpdater(notice);
+
         return;
     }
 